@@ -0,0 +1,264 @@
+package locknut
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrPermissionDenied is returned by a ScopedLocknut for operations its principal is not granted
+var ErrPermissionDenied = errors.New("permission denied")
+
+// Permission is a set of operations on the records of a bucket
+type Permission uint8
+
+// The permissions granted by a Policy
+const (
+	// PermRead allows Get, GetOne, GetByPrefix, GetKeyList and Count
+	PermRead Permission = 1 << iota
+	// PermWrite allows Save, SaveBytes and CreateBucket
+	PermWrite
+	// PermDelete allows Delete
+	PermDelete
+	// PermAll is every permission
+	PermAll = PermRead | PermWrite | PermDelete
+)
+
+func (p Permission) String() string {
+	s := ""
+	for _, n := range []struct {
+		perm Permission
+		name string
+	}{{PermRead, "read"}, {PermWrite, "write"}, {PermDelete, "delete"}} {
+		if p&n.perm != 0 {
+			if s != "" {
+				s += "+"
+			}
+			s += n.name
+		}
+	}
+	if s == "" {
+		return "none"
+	}
+	return s
+}
+
+// AnyBucket grants a permission on every bucket
+const AnyBucket = "*"
+
+// Policy holds the permissions of principals per bucket, and the tokens principals authenticate
+// with. It is safe for concurrent use and can be changed while handles and servers use it.
+type Policy struct {
+	mu     sync.RWMutex
+	grants map[string]map[string]Permission
+	tokens map[string]string
+}
+
+// NewPolicy returns a Policy granting nothing
+func NewPolicy() *Policy {
+	return &Policy{grants: make(map[string]map[string]Permission), tokens: make(map[string]string)}
+}
+
+// Grant adds perm on bucket, or on every bucket for AnyBucket, to the permissions of principal
+func (p *Policy) Grant(principal, bucket string, perm Permission) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.grants[principal] == nil {
+		p.grants[principal] = make(map[string]Permission)
+	}
+	p.grants[principal][bucket] |= perm
+}
+
+// Revoke removes perm on bucket from the permissions of principal. Revoking on a bucket does not
+// touch what AnyBucket grants.
+func (p *Policy) Revoke(principal, bucket string, perm Permission) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if g := p.grants[principal]; g != nil {
+		if g[bucket] &^= perm; g[bucket] == 0 {
+			delete(g, bucket)
+		}
+	}
+}
+
+// Allowed reports whether principal holds every permission of perm on bucket
+func (p *Policy) Allowed(principal, bucket string, perm Permission) bool {
+	return p.Permissions(principal, bucket)&perm == perm
+}
+
+// Permissions returns the permissions principal holds on bucket
+func (p *Policy) Permissions(principal, bucket string) Permission {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	g := p.grants[principal]
+	return g[bucket] | g[AnyBucket]
+}
+
+// AddToken lets token authenticate principal, see Principal
+func (p *Policy) AddToken(token, principal string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tokens[token] = principal
+}
+
+// RemoveToken stops token from authenticating anyone
+func (p *Policy) RemoveToken(token string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.tokens, token)
+}
+
+// Principal returns the principal token authenticates, comparing tokens in constant time
+func (p *Policy) Principal(token string) (string, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for t, principal := range p.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return principal, true
+		}
+	}
+	return "", false
+}
+
+// WithPolicy sets the policy enforced by the handles As returns
+func WithPolicy(p *Policy) Option {
+	return func(bl *BoltLocknut) {
+		bl.policy = p
+	}
+}
+
+// Policy returns the policy set WithPolicy, or nil
+func (bl *BoltLocknut) Policy() *Policy {
+	return bl.policy
+}
+
+// ScopedLocknut is a handle on a BoltLocknut that only performs what the policy grants its principal,
+// failing with ErrPermissionDenied otherwise. It is returned by As.
+type ScopedLocknut struct {
+	bl        *BoltLocknut
+	principal string
+}
+
+var _ Locknut = (*ScopedLocknut)(nil)
+
+// As returns a handle acting as principal. Without a policy, see WithPolicy, it is denied everything.
+func (bl *BoltLocknut) As(principal string) *ScopedLocknut {
+	return &ScopedLocknut{bl: bl, principal: principal}
+}
+
+// Principal returns the principal of s
+func (s *ScopedLocknut) Principal() string {
+	return s.principal
+}
+
+// check fails unless the principal holds perm on bucket
+func (s *ScopedLocknut) check(op, bucket string, perm Permission) error {
+	if s.bl.policy == nil || !s.bl.policy.Allowed(s.principal, bucket, perm) {
+		return fmt.Errorf("%w: %s may not %s in %s", ErrPermissionDenied, s.principal, op, bucket)
+	}
+	return nil
+}
+
+// Save is BoltLocknut.Save, given PermWrite on bucket
+func (s *ScopedLocknut) Save(bucket, key string, data interface{}) error {
+	return s.SaveContext(context.Background(), bucket, key, data)
+}
+
+// SaveContext is BoltLocknut.SaveContext, given PermWrite on bucket
+func (s *ScopedLocknut) SaveContext(ctx context.Context, bucket, key string, data interface{}) error {
+	if err := s.check("save", bucket, PermWrite); err != nil {
+		return err
+	}
+	return s.bl.SaveContext(ctx, bucket, key, data)
+}
+
+// SaveBytes is BoltLocknut.SaveBytes, given PermWrite on bucket
+func (s *ScopedLocknut) SaveBytes(bucket, key string, data []byte) error {
+	return s.SaveBytesContext(context.Background(), bucket, key, data)
+}
+
+// SaveBytesContext is BoltLocknut.SaveBytesContext, given PermWrite on bucket
+func (s *ScopedLocknut) SaveBytesContext(ctx context.Context, bucket, key string, data []byte) error {
+	if err := s.check("save", bucket, PermWrite); err != nil {
+		return err
+	}
+	return s.bl.SaveBytesContext(ctx, bucket, key, data)
+}
+
+// Get is BoltLocknut.Get, given PermRead on bucket
+func (s *ScopedLocknut) Get(bucket, key string) ([]byte, error) {
+	if err := s.check("read", bucket, PermRead); err != nil {
+		return nil, err
+	}
+	return s.bl.Get(bucket, key)
+}
+
+// GetOne is BoltLocknut.GetOne, given PermRead on bucket
+func (s *ScopedLocknut) GetOne(bucket, key string) ([]byte, error) {
+	if err := s.check("read", bucket, PermRead); err != nil {
+		return nil, err
+	}
+	return s.bl.GetOne(bucket, key)
+}
+
+// GetByPrefix is BoltLocknut.GetByPrefix, given PermRead on bucket
+func (s *ScopedLocknut) GetByPrefix(bucket, prefix string) (map[string][]byte, error) {
+	if err := s.check("read", bucket, PermRead); err != nil {
+		return nil, err
+	}
+	return s.bl.GetByPrefix(bucket, prefix)
+}
+
+// GetKeyList is BoltLocknut.GetKeyList, given PermRead on bucket
+func (s *ScopedLocknut) GetKeyList(bucket, prefix string) ([]string, error) {
+	if err := s.check("list", bucket, PermRead); err != nil {
+		return nil, err
+	}
+	return s.bl.GetKeyList(bucket, prefix)
+}
+
+// Delete is BoltLocknut.Delete, given PermDelete on bucket
+func (s *ScopedLocknut) Delete(bucket, key string) error {
+	return s.DeleteContext(context.Background(), bucket, key)
+}
+
+// DeleteContext is BoltLocknut.DeleteContext, given PermDelete on bucket
+func (s *ScopedLocknut) DeleteContext(ctx context.Context, bucket, key string) error {
+	if err := s.check("delete", bucket, PermDelete); err != nil {
+		return err
+	}
+	return s.bl.DeleteContext(ctx, bucket, key)
+}
+
+// Buckets returns the buckets the principal holds any permission on
+func (s *ScopedLocknut) Buckets() ([]string, error) {
+	names, err := s.bl.Buckets()
+	if err != nil {
+		return nil, err
+	}
+	visible := names[:0]
+	for _, name := range names {
+		if s.bl.policy != nil && s.bl.policy.Permissions(s.principal, name) != 0 {
+			visible = append(visible, name)
+		}
+	}
+	return visible, nil
+}
+
+// CreateBucket is BoltLocknut.CreateBucket, given PermWrite on the bucket
+func (s *ScopedLocknut) CreateBucket(name string) error {
+	if err := s.check("create", name, PermWrite); err != nil {
+		return err
+	}
+	return s.bl.CreateBucket(name)
+}
+
+// Count is BoltLocknut.Count, given PermRead on bucket
+func (s *ScopedLocknut) Count(bucket string) (int, error) {
+	if err := s.check("count", bucket, PermRead); err != nil {
+		return 0, err
+	}
+	return s.bl.Count(bucket)
+}
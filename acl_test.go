@@ -0,0 +1,89 @@
+package locknut
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestScopedLocknut(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("acl_test.db")
+
+	policy := NewPolicy()
+	policy.Grant("alice", "notes", PermRead|PermWrite)
+	policy.Grant("admin", AnyBucket, PermAll)
+
+	bl, err := NewBoltLocknut("acl_test.db", ".", []byte("secret"), false, []string{"notes", "secrets"}, WithPolicy(policy))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	admin, alice, bob := bl.As("admin"), bl.As("alice"), bl.As("bob")
+
+	if err = admin.Save("secrets", "k", "v"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if err = alice.Save("notes", "n1", "hello"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if v, err := alice.Get("notes", "n1"); err != nil || string(v) != `"hello"` {
+		t.Errorf("unexpected Get %s %v", v, err)
+	}
+	if n, err := alice.Count("notes"); err != nil || n != 1 {
+		t.Errorf("unexpected Count %d %v", n, err)
+	}
+
+	denied := []error{
+		alice.Delete("notes", "n1"),
+		alice.Save("secrets", "k", "other"),
+		bob.CreateBucket("bobs"),
+	}
+	_, err = alice.Get("secrets", "k")
+	denied = append(denied, err)
+	_, err = bob.GetKeyList("notes", "")
+	denied = append(denied, err)
+	for i, err := range denied {
+		if !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("%d: expected ErrPermissionDenied, got %v", i, err)
+		}
+	}
+
+	if names, err := alice.Buckets(); err != nil || !reflect.DeepEqual(names, []string{"notes"}) {
+		t.Errorf("unexpected Buckets %v %v", names, err)
+	}
+
+	// policy changes apply to handles already given out
+	policy.Grant("alice", "notes", PermDelete)
+	if err = alice.Delete("notes", "n1"); err != nil {
+		t.Errorf("Delete: %s", err)
+	}
+	policy.Revoke("admin", "secrets", PermAll)
+	if !policy.Allowed("admin", "secrets", PermRead) {
+		t.Error("expected revoking on a bucket to leave AnyBucket grants alone")
+	}
+
+	// without a policy nothing is allowed
+	bl.policy = nil
+	if _, err = admin.Get("secrets", "k"); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("expected ErrPermissionDenied, got %v", err)
+	}
+}
+
+func TestPolicyTokens(t *testing.T) {
+	p := NewPolicy()
+	p.AddToken("t0ken", "alice")
+	if who, ok := p.Principal("t0ken"); !ok || who != "alice" {
+		t.Errorf("unexpected Principal %q %v", who, ok)
+	}
+	if _, ok := p.Principal("t0ke"); ok {
+		t.Error("expected an unknown token")
+	}
+	p.RemoveToken("t0ken")
+	if _, ok := p.Principal("t0ken"); ok {
+		t.Error("expected the token removed")
+	}
+	if s := (PermRead | PermDelete).String(); s != "read+delete" {
+		t.Errorf("unexpected String %q", s)
+	}
+}
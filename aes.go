@@ -4,11 +4,31 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
+	"golang.org/x/crypto/hkdf"
 	"io"
 )
 
+// ErrCiphertextTooShort is returned by Decrypt for input shorter than a nonce
+var ErrCiphertextTooShort = errors.New("ciphertext too short")
+
+var (
+	// ErrUnwrapKey is returned by UnwrapKey for a wrapped key that was altered or wrapped with another
+	// key encryption key
+	ErrUnwrapKey = errors.New("key does not unwrap")
+	// ErrWrapVersion is returned by UnwrapKey for a wrapped key in a format it does not know
+	ErrWrapVersion = errors.New("unknown key wrap version")
+)
+
+// A wrapped key is a version byte followed by the key wrapped in the format of that version:
+//
+//	v1: 0x01 | nonce (12) | AES-GCM sealed dek (len(dek) + 16), the version byte as additional data
+//
+// A new format gets a new version, UnwrapKey keeps reading the old ones.
+const wrapV1 byte = 1
+
 // Cryptor used for aes ops
 type Cryptor struct {
 	plain  []byte
@@ -80,7 +100,7 @@ func (c *Cryptor) Decrypt(key []byte) ([]byte, error) {
 
 	nonceSize := gcm.NonceSize()
 	if len(c.Cipher) < nonceSize {
-		return nil, errors.New("ciphertext too short")
+		return nil, ErrCiphertextTooShort
 	}
 
 	nonce, ciphertext := c.Cipher[:nonceSize], c.Cipher[nonceSize:]
@@ -88,8 +108,60 @@ func (c *Cryptor) Decrypt(key []byte) ([]byte, error) {
 	return c.plain, err
 }
 
+// WrapKey encrypts dek, a data encryption key, with kek, an AES key encryption key, for envelope
+// schemes that store keys next to the data they encrypt. The result is versioned, see wrapV1.
+func (c *Cryptor) WrapKey(dek, kek []byte) ([]byte, error) {
+	if len(dek) == 0 {
+		return nil, errors.New("key to wrap is empty")
+	}
+	a, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(a)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped := make([]byte, 1+gcm.NonceSize(), 1+gcm.NonceSize()+len(dek)+gcm.Overhead())
+	wrapped[0] = wrapV1
+	if _, err = io.ReadFull(rand.Reader, wrapped[1:]); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(wrapped, wrapped[1:], dek, wrapped[:1]), nil
+}
+
+// UnwrapKey returns the key wrapped by WrapKey with kek. It fails with ErrUnwrapKey if wrapped was
+// altered or wrapped with another key, and with ErrWrapVersion if it is in a format it does not know.
+func (c *Cryptor) UnwrapKey(wrapped, kek []byte) ([]byte, error) {
+	a, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(a)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(wrapped) == 0 || wrapped[0] != wrapV1 {
+		return nil, ErrWrapVersion
+	}
+	if len(wrapped) < 1+gcm.NonceSize()+gcm.Overhead() {
+		return nil, ErrUnwrapKey
+	}
+	nonce := wrapped[1 : 1+gcm.NonceSize()]
+	dek, err := gcm.Open(nil, nonce, wrapped[1+gcm.NonceSize():], wrapped[:1])
+	if err != nil {
+		return nil, ErrUnwrapKey
+	}
+	return dek, nil
+}
+
 // Decrypt without reciever
 func Decrypt(ciphertext, key []byte) ([]byte, error) {
+	if isSIV(ciphertext) {
+		return decryptSIV(ciphertext, key)
+	}
 	c, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
@@ -102,7 +174,7 @@ func Decrypt(ciphertext, key []byte) ([]byte, error) {
 
 	nonceSize := gcm.NonceSize()
 	if len(ciphertext) < nonceSize {
-		return nil, errors.New("ciphertext too short")
+		return nil, ErrCiphertextTooShort
 	}
 
 	nonce, ct := ciphertext[:nonceSize], ciphertext[nonceSize:]
@@ -112,6 +184,11 @@ func Decrypt(ciphertext, key []byte) ([]byte, error) {
 
 // Encrypt recieverless encryption
 func Encrypt(plain, key []byte) ([]byte, error) {
+	return encryptWith(rand.Reader, plain, key)
+}
+
+// encryptWith is Encrypt reading the nonce from r
+func encryptWith(r io.Reader, plain, key []byte) ([]byte, error) {
 	c, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
@@ -123,7 +200,7 @@ func Encrypt(plain, key []byte) ([]byte, error) {
 	}
 
 	nonce := make([]byte, gcm.NonceSize())
-	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+	if _, err = io.ReadFull(r, nonce); err != nil {
 		return nil, err
 	}
 
@@ -139,3 +216,14 @@ func GetRandKey() ([]byte, error) {
 	}
 	return key, nil
 }
+
+// HKDF derives n bytes from secret with HKDF (RFC 5869) using SHA-256, a nil salt standing for a
+// zeroed one, the way every key of the package is derived from another. Key providers use it for
+// theirs. It panics for n over 255 * 32 bytes, more than HKDF can derive.
+func HKDF(secret, salt, info []byte, n int) []byte {
+	out := make([]byte, n)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, info), out); err != nil {
+		panic("locknut: " + err.Error())
+	}
+	return out
+}
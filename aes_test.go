@@ -31,3 +31,36 @@ func TestAES(t *testing.T) {
 	assert.Equal(t, obj.plain, dec, "The two words should be the same.")
 
 }
+
+func TestWrapKey(t *testing.T) {
+	var c Cryptor
+	dek, err := GetRandKey()
+	assert.NoError(t, err)
+	kek, err := GetRandKey()
+	assert.NoError(t, err)
+
+	wrapped, err := c.WrapKey(dek, kek)
+	assert.NoError(t, err)
+	assert.Equal(t, wrapV1, wrapped[0])
+	assert.Len(t, wrapped, 1+12+32+16)
+
+	unwrapped, err := c.UnwrapKey(wrapped, kek)
+	assert.NoError(t, err)
+	assert.Equal(t, dek, unwrapped)
+
+	other, _ := GetRandKey()
+	_, err = c.UnwrapKey(wrapped, other)
+	assert.ErrorIs(t, err, ErrUnwrapKey)
+
+	altered := append([]byte(nil), wrapped...)
+	altered[20] ^= 1
+	_, err = c.UnwrapKey(altered, kek)
+	assert.ErrorIs(t, err, ErrUnwrapKey)
+
+	altered[0] = 2
+	_, err = c.UnwrapKey(altered, kek)
+	assert.ErrorIs(t, err, ErrWrapVersion)
+
+	_, err = c.UnwrapKey(wrapped[:10], kek)
+	assert.ErrorIs(t, err, ErrUnwrapKey)
+}
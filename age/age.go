@@ -0,0 +1,127 @@
+// Package age implements locknut.KeyProvider with age (https://age-encryption.org), wrapping the db key
+// to one or more X25519 recipients. Any holder of a matching identity file can unlock the db, which
+// gives several admins access without sharing a symmetric key. The wrapped key is an armored age file,
+// so it can also be opened with the age tool itself.
+package age
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	goage "filippo.io/age"
+	"filippo.io/age/armor"
+	"fmt"
+	"github.com/taybart/locknut"
+	"io"
+	"os"
+)
+
+// Provider wraps keys to age recipients and unwraps them with age identities
+type Provider struct {
+	recipients []goage.Recipient
+	identities []goage.Identity
+	keyFile    string
+}
+
+var _ locknut.KeyProvider = (*Provider)(nil)
+
+// Option configures a Provider, passed to New
+type Option func(*Provider)
+
+// WithRecipients adds recipients the db key is wrapped to, needed to create the key and by Rewrap
+func WithRecipients(recipients ...goage.Recipient) Option {
+	return func(p *Provider) {
+		p.recipients = append(p.recipients, recipients...)
+	}
+}
+
+// WithIdentities adds identities tried to unwrap the db key, one matching any recipient will do
+func WithIdentities(identities ...goage.Identity) Option {
+	return func(p *Provider) {
+		p.identities = append(p.identities, identities...)
+	}
+}
+
+// New returns a Provider keeping the wrapped db key in keyFile
+func New(keyFile string, opts ...Option) *Provider {
+	p := &Provider{keyFile: keyFile}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// ParseIdentityFile reads the identities of an age identity file, as written by age-keygen
+func ParseIdentityFile(path string) ([]goage.Identity, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return goage.ParseIdentities(f)
+}
+
+// GetKey returns the db key, created on first use, see locknut.EnvelopeKey
+func (p *Provider) GetKey(ctx context.Context) ([]byte, error) {
+	return locknut.EnvelopeKey(ctx, p, p.keyFile)
+}
+
+// Wrap encrypts key to every recipient, the result being an armored age file
+func (p *Provider) Wrap(ctx context.Context, key []byte) ([]byte, error) {
+	if locknut.FIPSBuild() {
+		return nil, fmt.Errorf("age: %w", locknut.ErrNotFIPS)
+	}
+	if len(p.recipients) == 0 {
+		return nil, errors.New("age: no recipients")
+	}
+	var buf bytes.Buffer
+	aw := armor.NewWriter(&buf)
+	w, err := goage.Encrypt(aw, p.recipients...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(key); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	if err = aw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unwrap decrypts a key wrapped by Wrap with the first identity matching one of its recipients
+func (p *Provider) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	if locknut.FIPSBuild() {
+		return nil, fmt.Errorf("age: %w", locknut.ErrNotFIPS)
+	}
+	if len(p.identities) == 0 {
+		return nil, errors.New("age: no identities")
+	}
+	r, err := goage.Decrypt(armor.NewReader(bytes.NewReader(wrapped)), p.identities...)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// Rewrap unwraps the db key with the identities and wraps it again to the recipients, replacing the
+// key file. It adds or removes admins without changing the db key, so the db is not re-encrypted. A
+// removed admin who kept a copy of the old key file can still unwrap the key from it, rotate the db
+// key with RotateKey to lock them out.
+func (p *Provider) Rewrap(ctx context.Context) error {
+	wrapped, err := os.ReadFile(p.keyFile)
+	if err != nil {
+		return err
+	}
+	key, err := p.Unwrap(ctx, wrapped)
+	if err != nil {
+		return err
+	}
+	if wrapped, err = p.Wrap(ctx, key); err != nil {
+		return err
+	}
+	return locknut.WriteKeyFile(p.keyFile, wrapped, true)
+}
@@ -0,0 +1,66 @@
+package age
+
+import (
+	"bytes"
+	"context"
+	goage "filippo.io/age"
+	"github.com/taybart/locknut"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProvider(t *testing.T) {
+	if locknut.FIPSBuild() {
+		t.Skip("age is not allowed in FIPS mode")
+	}
+	ctx := context.Background()
+	alice, _ := goage.GenerateX25519Identity()
+	bob, _ := goage.GenerateX25519Identity()
+	carol, _ := goage.GenerateX25519Identity()
+	keyFile := filepath.Join(t.TempDir(), "db.key")
+
+	key, err := New(keyFile,
+		WithRecipients(alice.Recipient(), bob.Recipient()),
+		WithIdentities(alice),
+	).GetKey(ctx)
+	if err != nil {
+		t.Fatalf("GetKey: %s", err)
+	}
+	if len(key) != 32 {
+		t.Fatalf("expected a 32 byte key, got %d", len(key))
+	}
+	if wrapped, _ := os.ReadFile(keyFile); !bytes.HasPrefix(wrapped, []byte("-----BEGIN AGE ENCRYPTED FILE-----")) {
+		t.Errorf("expected an armored age file, got %q", wrapped)
+	}
+
+	if got, err := New(keyFile, WithIdentities(bob)).GetKey(ctx); err != nil || !bytes.Equal(got, key) {
+		t.Errorf("expected the second recipient to unwrap the key, got %v", err)
+	}
+	if _, err = New(keyFile, WithIdentities(carol)).GetKey(ctx); err == nil {
+		t.Error("expected an identity that is not a recipient to fail")
+	}
+
+	// carol replaces bob
+	admin := New(keyFile, WithRecipients(alice.Recipient(), carol.Recipient()), WithIdentities(alice))
+	if err = admin.Rewrap(ctx); err != nil {
+		t.Fatalf("Rewrap: %s", err)
+	}
+	if got, err := New(keyFile, WithIdentities(carol)).GetKey(ctx); err != nil || !bytes.Equal(got, key) {
+		t.Errorf("expected the new recipient to unwrap the same key, got %v", err)
+	}
+	if _, err = New(keyFile, WithIdentities(bob)).GetKey(ctx); err == nil {
+		t.Error("expected the removed recipient to fail")
+	}
+}
+
+func TestParseIdentityFile(t *testing.T) {
+	id, _ := goage.GenerateX25519Identity()
+	path := filepath.Join(t.TempDir(), "key.txt")
+	os.WriteFile(path, []byte("# created: today\n"+id.String()+"\n"), 0600)
+
+	ids, err := ParseIdentityFile(path)
+	if err != nil || len(ids) != 1 {
+		t.Fatalf("unexpected ParseIdentityFile %v %v", ids, err)
+	}
+}
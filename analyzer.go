@@ -0,0 +1,68 @@
+package locknut
+
+import (
+	"golang.org/x/text/cases"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+	"strings"
+	"unicode"
+)
+
+// Analyzer controls how the text of an IndexField is turned into search terms. The zero value folds
+// case and splits words on anything that is not a letter or a digit. Changing the analyzer of a field
+// requires RebuildSearchIndex.
+type Analyzer struct {
+	// CaseSensitive disables Unicode case folding
+	CaseSensitive bool
+	// Normalize applies Unicode NFKC normalization, so compatibility forms such as full width letters
+	// or ligatures match their plain form
+	Normalize bool
+	// FoldAccents removes diacritics, so "café" matches "cafe"
+	FoldAccents bool
+	// NGram, when 2 or more, also indexes every run of NGram characters of each word, so Search
+	// matches parts of words. It grows the index considerably.
+	NGram int
+}
+
+// normalize applies the normalization and folding of the analyzer to s
+func (a Analyzer) normalize(s string) string {
+	if a.Normalize {
+		s = norm.NFKC.String(s)
+	}
+	if a.FoldAccents {
+		t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+		if folded, _, err := transform.String(t, s); err == nil {
+			s = folded
+		}
+	}
+	if !a.CaseSensitive {
+		s = cases.Fold().String(s)
+	}
+	return s
+}
+
+// value is the form a whole field value is indexed and looked up in
+func (a Analyzer) value(s string) string {
+	return a.normalize(strings.TrimSpace(s))
+}
+
+// words splits normalized text into words of letters and digits
+func (a Analyzer) words(s string) []string {
+	return strings.FieldsFunc(a.normalize(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// ngrams returns the runs of n characters of word, none if the word is shorter
+func ngrams(word string, n int) []string {
+	r := []rune(word)
+	if len(r) < n {
+		return nil
+	}
+	grams := make([]string, 0, len(r)-n+1)
+	for i := 0; i+n <= len(r); i++ {
+		grams = append(grams, string(r[i:i+n]))
+	}
+	return grams
+}
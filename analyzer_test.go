@@ -0,0 +1,81 @@
+package locknut
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestAnalyzer(t *testing.T) {
+	tests := []struct {
+		a    Analyzer
+		in   string
+		want []string
+	}{
+		{Analyzer{}, "Straße, STRASSE", []string{"strasse", "strasse"}},
+		{Analyzer{CaseSensitive: true}, "Hello world", []string{"Hello", "world"}},
+		{Analyzer{FoldAccents: true}, "Café Crème", []string{"cafe", "creme"}},
+		{Analyzer{Normalize: true}, "ｆｕｌｌ ﬁle", []string{"full", "file"}},
+	}
+	for _, tc := range tests {
+		if got := tc.a.words(tc.in); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%+v words(%q): expected %v, got %v", tc.a, tc.in, tc.want, got)
+		}
+	}
+
+	if got := ngrams("añejo", 3); !reflect.DeepEqual(got, []string{"añe", "ñej", "ejo"}) {
+		t.Errorf("unexpected ngrams %v", got)
+	}
+	if got := ngrams("ab", 3); got != nil {
+		t.Errorf("expected no ngrams for a short word, got %v", got)
+	}
+}
+
+func TestSearchAnalyzer(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("analyzer_test.db")
+	defer os.Remove("analyzer_test.idx")
+
+	bl, err := NewBoltLocknut("analyzer_test.db", ".", []byte("secret"), false, []string{"places"},
+		WithSearchIndex("analyzer_test.idx",
+			IndexField{Bucket: "places", Field: "name", Analyzer: Analyzer{FoldAccents: true, NGram: 3}},
+			IndexField{Bucket: "places", Field: "code", Analyzer: Analyzer{CaseSensitive: true}},
+		))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+
+	places := map[string]map[string]string{
+		"zrh": {"name": "Zürich Hauptbahnhof", "code": "ZRH"},
+		"bsl": {"name": "Basel Badischer Bahnhof", "code": "bsl"},
+	}
+	for k, v := range places {
+		if err = bl.Save("places", k, v); err != nil {
+			t.Fatalf("Save: %s", err)
+		}
+	}
+
+	for query, want := range map[string][]string{
+		"zurich":    {"zrh"},
+		"ZÜR":       {"zrh"},
+		"bahnhof":   {"bsl", "zrh"},
+		"bahn":      {"bsl", "zrh"},
+		"dischBahn": {},
+		"ba":        {},
+	} {
+		got, err := bl.Search("places", "name", query)
+		if err != nil {
+			t.Fatalf("Search: %s", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Search(%q): expected %v, got %v", query, want, got)
+		}
+	}
+
+	if got, _ := bl.Lookup("places", "code", "zrh"); len(got) != 0 {
+		t.Errorf("expected case sensitive lookup to miss, got %v", got)
+	}
+	if got, _ := bl.Lookup("places", "code", "ZRH"); !reflect.DeepEqual(got, []string{"zrh"}) {
+		t.Errorf("unexpected lookup %v", got)
+	}
+}
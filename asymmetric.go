@@ -0,0 +1,109 @@
+package locknut
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"errors"
+	"go.etcd.io/bbolt"
+)
+
+var (
+	// ErrWriteOnly is returned when reading records of a db opened with WithPublicKey, which takes the
+	// private key
+	ErrWriteOnly = errors.New("db is write only, reading records takes the private key")
+	// ErrWrongRecipient is returned by NewBoltLocknut when the public key given does not match the one
+	// the records of the db are sealed to
+	ErrWrongRecipient = errors.New("public key does not match the db")
+)
+
+// recipientKey is the meta record holding the public key records are sealed to
+var recipientKey = []byte("recipient")
+
+var recordSealInfo = []byte("locknut record seal")
+
+// WithPublicKey seals every record to pub instead of encrypting it with the secret, so ingestion
+// agents can Save records they cannot read back: reading fails with ErrWriteOnly. Only a BoltLocknut
+// opened WithPrivateKey can read them. Each record is encrypted with AES-GCM under a key agreed
+// between a fresh X25519 key pair and pub, ECIES style. RotateKey is not available, and the search
+// index is only updated by handles holding the private key, rebuild it after collectors wrote.
+func WithPublicKey(pub *ecdh.PublicKey) Option {
+	return func(bl *BoltLocknut) {
+		bl.public = pub
+	}
+}
+
+// WithPrivateKey reads and writes records sealed to the public key of priv, see WithPublicKey
+func WithPrivateKey(priv *ecdh.PrivateKey) Option {
+	return func(bl *BoltLocknut) {
+		bl.private = priv
+		bl.public = priv.PublicKey()
+	}
+}
+
+// sealKey derives the key of a record from the exchange between eph and recipient
+func sealKey(shared []byte, eph, recipient *ecdh.PublicKey) []byte {
+	salt := append(append([]byte(nil), eph.Bytes()...), recipient.Bytes()...)
+	return HKDF(shared, salt, recordSealInfo, 32)
+}
+
+// seal encrypts data the way records of bucket are stored, to the public key if one is set and with
+// the key of bucket otherwise
+func (bl *BoltLocknut) seal(bucket string, data []byte) ([]byte, error) {
+	if bl.public == nil {
+		return bl.encrypt(data, bl.keyFor(bucket))
+	}
+	eph, err := bl.public.Curve().GenerateKey(bl.randReader())
+	if err != nil {
+		return nil, err
+	}
+	shared, err := eph.ECDH(bl.public)
+	if err != nil {
+		return nil, err
+	}
+	ct, err := bl.encrypt(data, sealKey(shared, eph.PublicKey(), bl.public))
+	if err != nil {
+		return nil, err
+	}
+	return append(eph.PublicKey().Bytes(), ct...), nil
+}
+
+// unseal decrypts a record sealed to the public key
+func (bl *BoltLocknut) unseal(stored []byte) ([]byte, error) {
+	if bl.private == nil {
+		return nil, ErrWriteOnly
+	}
+	n := len(bl.public.Bytes())
+	if len(stored) < n {
+		return nil, ErrCiphertextTooShort
+	}
+	eph, err := bl.public.Curve().NewPublicKey(stored[:n])
+	if err != nil {
+		return nil, err
+	}
+	shared, err := bl.private.ECDH(eph)
+	if err != nil {
+		return nil, err
+	}
+	return Decrypt(stored[n:], sealKey(shared, eph, bl.public))
+}
+
+// checkRecipient verifies the public key against the one recorded in the db, recording it in a db
+// that has none yet
+func (bl *BoltLocknut) checkRecipient(tx *bbolt.Tx) error {
+	if meta := tx.Bucket(metaBucket); meta != nil {
+		if stored := meta.Get(recipientKey); stored != nil {
+			if !bytes.Equal(stored, bl.public.Bytes()) {
+				return ErrWrongRecipient
+			}
+			return nil
+		}
+	}
+	if !tx.Writable() {
+		return nil
+	}
+	meta, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return err
+	}
+	return meta.Put(recipientKey, bl.public.Bytes())
+}
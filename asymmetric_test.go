@@ -0,0 +1,64 @@
+package locknut
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestWriteOnly(t *testing.T) {
+	skipFIPS(t, "X25519 record sealing")
+	defer os.Remove("asymmetric_test.db")
+
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	collector, err := NewBoltLocknut("asymmetric_test.db", ".", nil, false, []string{"pii"},
+		WithPublicKey(priv.PublicKey()))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if err = collector.Save("pii", "ssn-1", "123-45-6789"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	in := `{"key":"ssn-2","value":"987-65-4321"}` + "\n"
+	if err = collector.Import(strings.NewReader(in), FormatJSONL, "pii"); err != nil {
+		t.Fatalf("Import: %s", err)
+	}
+	if _, err = collector.GetOne("pii", "ssn-1"); !errors.Is(err, ErrWriteOnly) {
+		t.Errorf("expected the collector to fail with ErrWriteOnly, got %v", err)
+	}
+	if keys, _ := collector.GetKeyList("pii", ""); len(keys) != 2 {
+		t.Errorf("expected keys to stay listable, got %v", keys)
+	}
+	if err = collector.RotateKey([]byte("new")); err == nil {
+		t.Error("expected RotateKey to fail")
+	}
+
+	reader, err := NewBoltLocknut("asymmetric_test.db", ".", nil, false, nil, WithPrivateKey(priv))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	recs, err := reader.GetByPrefix("pii", "ssn-")
+	if err != nil {
+		t.Fatalf("GetByPrefix: %s", err)
+	}
+	if string(recs["ssn-1"]) != `"123-45-6789"` || string(recs["ssn-2"]) != `"987-65-4321"` {
+		t.Errorf("unexpected records %q", recs)
+	}
+
+	other, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	_, err = NewBoltLocknut("asymmetric_test.db", ".", nil, false, nil, WithPublicKey(other.PublicKey()))
+	if !errors.Is(err, ErrWrongRecipient) {
+		t.Errorf("expected ErrWrongRecipient, got %v", err)
+	}
+	_, err = NewBoltLocknut("asymmetric_test.db", ".", []byte("secret"), false, nil)
+	if !errors.Is(err, ErrWrongSecret) {
+		t.Errorf("expected a secret to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,246 @@
+package locknut
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// The defaults of the async queue, see WithAsyncSaves
+const (
+	defaultAsyncQueue = 1024
+	asyncBatch        = 256
+)
+
+// asyncOp is a write queued by SaveAsync, SaveBytesAsync or DeleteAsync, or a Flush waiting on done
+type asyncOp struct {
+	ctx    context.Context
+	op     string
+	bucket string
+	key    string
+	data   []byte
+	done   chan struct{}
+}
+
+// asyncQueue is the queue of async writes and the goroutine applying them
+type asyncQueue struct {
+	size    int
+	onError func(err error)
+
+	mu      sync.RWMutex // guards ops and stopped, held for reading while queueing
+	ops     chan asyncOp
+	stopped chan struct{}
+
+	errMu sync.Mutex
+	errs  []error
+}
+
+// WithAsyncSaves sizes the queue of SaveAsync, SaveBytesAsync and DeleteAsync to queue writes, 1024
+// by default, and calls onError, when not nil, with the error of every queued write that fails. The
+// errors are RecordErrors naming the write.
+func WithAsyncSaves(queue int, onError func(err error)) Option {
+	return func(bl *BoltLocknut) {
+		bl.async.size = queue
+		bl.async.onError = onError
+	}
+}
+
+// SaveAsync queues Save for a goroutine that applies queued writes in batches, one transaction per
+// batch, and returns once the write is queued, blocking while the queue is full. The value is encoded
+// and the BeforeSave hooks run before it returns, their errors are returned; the errors of the write
+// itself go to Flush and to the callback set WithAsyncSaves. Writes are applied in the order queued.
+func (bl *BoltLocknut) SaveAsync(bucket, key string, data interface{}) error {
+	ctx, value, err := bl.prepareSave(context.Background(), bucket, data)
+	if err != nil {
+		return err
+	}
+	return bl.saveBytesAsync(ctx, bucket, key, value)
+}
+
+// SaveBytesAsync queues SaveBytes like SaveAsync
+func (bl *BoltLocknut) SaveBytesAsync(bucket, key string, data []byte) error {
+	return bl.saveBytesAsync(context.Background(), bucket, key, data)
+}
+
+func (bl *BoltLocknut) saveBytesAsync(ctx context.Context, bucket, key string, data []byte) (err error) {
+	if data == nil {
+		return errors.New("data is nil")
+	}
+	if err = bl.checkLimits("save", bucket, key, data); err != nil {
+		return err
+	}
+	if data, err = bl.beforeSave(ctx, bucket, key, data); err != nil {
+		return err
+	}
+	return bl.enqueue(asyncOp{ctx: ctx, op: "save", bucket: bucket, key: key, data: append([]byte(nil), data...)})
+}
+
+// DeleteAsync queues Delete like SaveAsync, the BeforeDelete hooks run before it returns
+func (bl *BoltLocknut) DeleteAsync(bucket, key string) error {
+	if key == "" {
+		return errors.New("cannot delete, key is nil")
+	}
+	ctx := context.Background()
+	if err := bl.beforeDelete(ctx, bucket, key); err != nil {
+		return err
+	}
+	return bl.enqueue(asyncOp{ctx: ctx, op: "delete", bucket: bucket, key: key})
+}
+
+// Flush waits for the writes queued before it to be applied, and returns the errors of the queued
+// writes that failed since the last Flush, joined. It must not be called from hooks or the callback of
+// WithAsyncSaves, which run on the goroutine applying the queue.
+func (bl *BoltLocknut) Flush() error {
+	done := make(chan struct{})
+	if err := bl.enqueue(asyncOp{done: done}); err != nil {
+		return err
+	}
+	<-done
+
+	q := &bl.async
+	q.errMu.Lock()
+	defer q.errMu.Unlock()
+	err := errors.Join(q.errs...)
+	q.errs = nil
+	return err
+}
+
+// enqueue queues op, starting the goroutine applying the queue when it is not running
+func (bl *BoltLocknut) enqueue(op asyncOp) error {
+	if err := bl.touch(); err != nil {
+		return err
+	}
+	q := &bl.async
+	q.mu.RLock()
+	if q.ops == nil {
+		q.mu.RUnlock()
+		q.mu.Lock()
+		if q.ops == nil {
+			size := q.size
+			if size <= 0 {
+				size = defaultAsyncQueue
+			}
+			q.ops, q.stopped = make(chan asyncOp, size), make(chan struct{})
+			go bl.applyAsync(q.ops, q.stopped)
+		}
+		q.mu.Unlock()
+		q.mu.RLock()
+	}
+	defer q.mu.RUnlock()
+	q.ops <- op
+	return nil
+}
+
+// stopAsync applies the queued writes and stops the goroutine applying them
+func (bl *BoltLocknut) stopAsync() {
+	q := &bl.async
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.ops == nil {
+		return
+	}
+	close(q.ops)
+	<-q.stopped
+	q.ops, q.stopped = nil, nil
+}
+
+// applyAsync applies the writes of ops in batches until ops is closed
+func (bl *BoltLocknut) applyAsync(ops chan asyncOp, stopped chan struct{}) {
+	defer close(stopped)
+	batch := make([]asyncOp, 0, asyncBatch)
+	for op := range ops {
+		batch = append(batch[:0], op)
+	gather:
+		for len(batch) < asyncBatch {
+			select {
+			case op, ok := <-ops:
+				if !ok {
+					break gather
+				}
+				batch = append(batch, op)
+			default:
+				break gather
+			}
+		}
+		bl.applyBatch(batch)
+	}
+}
+
+// applyBatch applies the writes of batch in one transaction, or one by one when it fails, so a failing
+// write does not fail the others. Flushes are released once the writes before them are applied.
+func (bl *BoltLocknut) applyBatch(batch []asyncOp) {
+	var writes []asyncOp
+	for _, op := range batch {
+		if op.done == nil {
+			writes = append(writes, op)
+		}
+	}
+
+	var err error
+	if len(writes) > 0 {
+		start := time.Now()
+		err = bl.writeOrQueue(writes[0].ctx, func(w *writeTx) error {
+			for _, op := range writes {
+				if err := op.apply(w); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		bl.observe("async_batch", start, &err)
+	}
+
+	for _, op := range batch {
+		if op.done != nil {
+			close(op.done)
+			continue
+		}
+		if err != nil {
+			opErr := bl.writeOrQueue(op.ctx, op.apply)
+			if opErr != nil {
+				bl.asyncFailed(op, opErr)
+				continue
+			}
+		}
+		if op.op == "delete" {
+			bl.afterDelete(op.ctx, op.bucket, op.key)
+		} else {
+			bl.afterSave(op.ctx, op.bucket, op.key, op.data)
+		}
+	}
+}
+
+// apply performs op in w, with the context op was queued with
+func (op asyncOp) apply(w *writeTx) error {
+	ctx := w.ctx
+	w.ctx = op.ctx
+	defer func() { w.ctx = ctx }()
+
+	var data []byte
+	if op.op == "save" {
+		data = op.data
+	}
+	if applied, err := w.claimIdempotencyKey(op.op, op.bucket, op.key, data); applied || err != nil {
+		return err
+	}
+	if op.op == "delete" {
+		return w.delete(op.bucket, op.key)
+	}
+	return w.put(op.bucket, op.key, op.data)
+}
+
+// asyncFailed keeps the error of a queued write for Flush and hands it to the callback
+func (bl *BoltLocknut) asyncFailed(op asyncOp, err error) {
+	var re *RecordError
+	if !errors.As(err, &re) || re.Key != op.key {
+		err = &RecordError{Op: op.op, Bucket: op.bucket, Key: op.key, Kind: err}
+	}
+	q := &bl.async
+	q.errMu.Lock()
+	q.errs = append(q.errs, err)
+	q.errMu.Unlock()
+	if q.onError != nil {
+		q.onError(err)
+	}
+}
@@ -0,0 +1,92 @@
+package locknut
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestSaveAsync(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("async_test.db")
+
+	var mu sync.Mutex
+	var failed []error
+	bl, err := NewBoltLocknut("async_test.db", ".", []byte("secret"), false, []string{"events"},
+		WithAsyncSaves(16, func(err error) {
+			mu.Lock()
+			failed = append(failed, err)
+			mu.Unlock()
+		}),
+		WithValidator("events", func(v []byte) error {
+			if string(v) == `"bad"` {
+				return errors.New("bad event")
+			}
+			return nil
+		}))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+
+	var wg sync.WaitGroup
+	for p := 0; p < 4; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				if err := bl.SaveAsync("events", fmt.Sprintf("%d-%03d", p, i), i); err != nil {
+					t.Errorf("SaveAsync: %s", err)
+				}
+			}
+		}(p)
+	}
+	wg.Wait()
+	if err = bl.SaveAsync("events", "bad", "bad"); err != nil {
+		t.Fatalf("SaveAsync: %s", err)
+	}
+	if err = bl.SaveBytesAsync("events", "raw", []byte(`"raw"`)); err != nil {
+		t.Fatalf("SaveBytesAsync: %s", err)
+	}
+	if err = bl.DeleteAsync("events", "0-000"); err != nil {
+		t.Fatalf("DeleteAsync: %s", err)
+	}
+
+	// the failing write is reported, the writes batched with it are applied
+	err = bl.Flush()
+	var re *RecordError
+	if !errors.As(err, &re) || re.Key != "bad" || !errors.Is(err, ErrValidation) {
+		t.Errorf("expected the validation error of bad, got %v", err)
+	}
+	if len(failed) != 1 || !errors.Is(failed[0], ErrValidation) {
+		t.Errorf("expected the callback called once, got %v", failed)
+	}
+	if n, err := bl.Count("events"); err != nil || n != 400 {
+		t.Errorf("expected 400 events, got %d %v", n, err)
+	}
+	if v, err := bl.Get("events", "raw"); err != nil || string(v) != `"raw"` {
+		t.Errorf("unexpected value %s %v", v, err)
+	}
+	if err = bl.Flush(); err != nil {
+		t.Errorf("expected the errors cleared by the last Flush, got %v", err)
+	}
+
+	// Close applies what is queued
+	if err = bl.SaveAsync("events", "last", "last"); err != nil {
+		t.Fatalf("SaveAsync: %s", err)
+	}
+	if err = bl.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	if err = bl.SaveAsync("events", "closed", 1); !errors.Is(err, ErrLocked) {
+		t.Errorf("expected ErrLocked, got %v", err)
+	}
+	bl, err = NewBoltLocknut("async_test.db", ".", []byte("secret"), false, []string{"events"})
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if v, err := bl.Get("events", "last"); err != nil || string(v) != `"last"` {
+		t.Errorf("unexpected value %s %v", v, err)
+	}
+}
@@ -0,0 +1,192 @@
+package locknut
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"time"
+)
+
+// auditBucket holds one AuditEntry per operation, keyed by its big endian sequence number
+var auditBucket = []byte(internalPrefix + "audit")
+
+// ErrAuditTampered is returned by VerifyAuditLog when the audit log is not the one the db wrote
+var ErrAuditTampered = errors.New("audit log has been tampered with")
+
+// AuditEntry records one operation on the records of the db. Hash chains it to the entry before it:
+// it is the SHA-256 of Prev and of the entry without Hash and Sig, so changing, inserting or removing
+// an entry breaks every hash after it. Sig is the Ed25519 signature of Hash, see WithAuditSigner.
+type AuditEntry struct {
+	Seq    uint64    `json:"seq"`
+	Time   time.Time `json:"time"`
+	Actor  string    `json:"actor,omitempty"`
+	Op     string    `json:"op"`
+	Bucket string    `json:"bucket,omitempty"`
+	Key    string    `json:"key,omitempty"`
+	Prev   []byte    `json:"prev,omitempty"`
+	Hash   []byte    `json:"hash"`
+	Sig    []byte    `json:"sig,omitempty"`
+}
+
+// hash computes the Hash of e
+func (e AuditEntry) hash() ([]byte, error) {
+	e.Hash, e.Sig = nil, nil
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	h.Write(e.Prev)
+	h.Write(b)
+	return h.Sum(nil), nil
+}
+
+// WithAuditLog records every operation on records, reads included, in a hash chained internal audit
+// bucket: who, from identity applied to the context of the operation, what and when. Writes are
+// logged in their own transaction, reads right after they happen, and a read whose entry cannot be
+// written fails, so no record is returned unlogged. Operations without a context are given
+// context.Background. Handles opened read only cannot write the log, their reads are not recorded.
+// The log holds bucket names and keys in the clear, never values.
+func WithAuditLog(identity func(ctx context.Context) string) Option {
+	return func(bl *BoltLocknut) {
+		bl.audit = true
+		bl.auditIdentity = identity
+	}
+}
+
+// WithAuditSigner signs every audit entry with key, so that only its holder can write entries
+// VerifyAuditLog accepts: without it, someone rewriting the file can rebuild the whole chain. It also
+// signs the exports of ExportAuditLog.
+func WithAuditSigner(key ed25519.PrivateKey) Option {
+	return func(bl *BoltLocknut) {
+		bl.auditKey = key
+	}
+}
+
+// logAudit appends an entry for op to the audit log inside the write transaction
+func (w *writeTx) logAudit(op, bucket, key string) error {
+	bl := w.bl
+	if !bl.audit {
+		return nil
+	}
+	bkt, err := w.tx.CreateBucketIfNotExists(auditBucket)
+	if err != nil {
+		return err
+	}
+
+	e := AuditEntry{Time: bl.now(), Op: op, Bucket: bucket, Key: key}
+	if bl.auditIdentity != nil {
+		ctx := w.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		e.Actor = bl.auditIdentity(ctx)
+	}
+	if _, last := bkt.Cursor().Last(); last != nil {
+		var prev AuditEntry
+		if err = json.Unmarshal(last, &prev); err != nil {
+			return fmt.Errorf("%w: %v", ErrAuditTampered, err)
+		}
+		e.Prev = prev.Hash
+	}
+	if e.Seq, err = bkt.NextSequence(); err != nil {
+		return err
+	}
+	if e.Hash, err = e.hash(); err != nil {
+		return err
+	}
+	if bl.auditKey != nil {
+		e.Sig = ed25519.Sign(bl.auditKey, e.Hash)
+	}
+
+	v, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return bkt.Put(seqKey(e.Seq), v)
+}
+
+// auditRead logs a read of bucket, key being the record or prefix read, after it happened
+func (bl *BoltLocknut) auditRead(ctx context.Context, op, bucket, key string) error {
+	if !bl.audit || bl.readOnly {
+		return nil
+	}
+	return bl.commit(ctx, func(w *writeTx) error {
+		return w.logAudit(op, bucket, key)
+	})
+}
+
+// AuditLog returns the audit entries with a sequence number greater than after, oldest first
+func (bl *BoltLocknut) AuditLog(after uint64) ([]AuditEntry, error) {
+	var err error
+	if err = bl.openDB(); err != nil {
+		return nil, err
+	}
+	defer bl.closeDB()
+
+	entries := make([]AuditEntry, 0)
+	err = bl.db.view(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket(auditBucket)
+		if bkt == nil {
+			return nil
+		}
+		c := bkt.Cursor()
+		for k, v := c.Seek(seqKey(after + 1)); k != nil; k, v = c.Next() {
+			var e AuditEntry
+			if err := json.Unmarshal(v, &e); err != nil {
+				return fmt.Errorf("%w: entry %x: %v", ErrAuditTampered, k, err)
+			}
+			entries = append(entries, e)
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// VerifyAuditLog checks the audit log is whole and unchanged: entries are numbered from 1 without
+// gaps, each hash matches its entry and chains to the one before, and, with WithAuditSigner, every
+// entry carries a valid signature, so a log written before the db had a signer no longer verifies. Entries removed from the end leave an
+// intact chain, record the hash of the last entry elsewhere to detect it.
+func (bl *BoltLocknut) VerifyAuditLog() error {
+	entries, err := bl.AuditLog(0)
+	if err != nil {
+		return err
+	}
+
+	var pub ed25519.PublicKey
+	if bl.auditKey != nil {
+		pub = bl.auditKey.Public().(ed25519.PublicKey)
+	}
+	return verifyAuditChain(entries, pub)
+}
+
+// verifyAuditChain checks entries are a whole audit log, see VerifyAuditLog, and with pub, that every
+// entry is signed by it
+func verifyAuditChain(entries []AuditEntry, pub ed25519.PublicKey) error {
+	var prev []byte
+	for i, e := range entries {
+		if e.Seq != uint64(i+1) {
+			return fmt.Errorf("%w: entry %d found at position %d", ErrAuditTampered, e.Seq, i+1)
+		}
+		if !bytes.Equal(e.Prev, prev) {
+			return fmt.Errorf("%w: entry %d does not chain to entry %d", ErrAuditTampered, e.Seq, i)
+		}
+		h, err := e.hash()
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(h, e.Hash) {
+			return fmt.Errorf("%w: entry %d does not match its hash", ErrAuditTampered, e.Seq)
+		}
+		if pub != nil && !ed25519.Verify(pub, e.Hash, e.Sig) {
+			return fmt.Errorf("%w: entry %d has no valid signature", ErrAuditTampered, e.Seq)
+		}
+		prev = e.Hash
+	}
+	return nil
+}
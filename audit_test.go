@@ -0,0 +1,187 @@
+package locknut
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+type auditActorCtx struct{}
+
+func auditActor(ctx context.Context) string {
+	actor, _ := ctx.Value(auditActorCtx{}).(string)
+	return actor
+}
+
+func TestAuditLog(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("audit_test.db")
+
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("GenerateKey: %s", err)
+	}
+	bl, err := NewBoltLocknut("audit_test.db", ".", []byte("secret"), false, []string{"people"},
+		WithAuditLog(auditActor), WithAuditSigner(key))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+
+	ctx := context.WithValue(context.Background(), auditActorCtx{}, "alice")
+	if err = bl.SaveContext(ctx, "people", "ada", "Ada"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if _, err = bl.GetOneContext(ctx, "people", "ad"); err != nil {
+		t.Fatalf("GetOne: %s", err)
+	}
+	if _, err = bl.Get("people", "ada"); err != nil {
+		t.Fatalf("Get: %s", err)
+	}
+	// reads that return nothing are not logged
+	if _, err = bl.Get("people", "nobody"); err != nil {
+		t.Fatalf("Get: %s", err)
+	}
+	if _, err = bl.GetByPrefix("people", "a"); err != nil {
+		t.Fatalf("GetByPrefix: %s", err)
+	}
+	if err = bl.Export(io.Discard, FormatJSONL, "people"); err != nil {
+		t.Fatalf("Export: %s", err)
+	}
+	if err = bl.Delete("people", "ada"); err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+
+	entries, err := bl.AuditLog(0)
+	if err != nil {
+		t.Fatalf("AuditLog: %s", err)
+	}
+	want := []AuditEntry{
+		{Actor: "alice", Op: "put", Key: "ada"},
+		{Actor: "alice", Op: "get", Key: "ada"},
+		{Op: "get", Key: "ada"},
+		{Op: "get_by_prefix", Key: "a"},
+		{Op: "export"},
+		{Op: "delete", Key: "ada"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("expected %d entries, got %+v", len(want), entries)
+	}
+	for i, e := range entries {
+		if e.Seq != uint64(i+1) || e.Actor != want[i].Actor || e.Op != want[i].Op || e.Key != want[i].Key || e.Bucket != "people" {
+			t.Errorf("entry %d: expected %+v, got %+v", i+1, want[i], e)
+		}
+	}
+	if later, err := bl.AuditLog(4); err != nil || len(later) != 2 {
+		t.Errorf("expected 2 entries after 4, got %d %v", len(later), err)
+	}
+	if err = bl.VerifyAuditLog(); err != nil {
+		t.Fatalf("VerifyAuditLog: %s", err)
+	}
+
+	// rewriting an entry, even with its hash recomputed, breaks the chain or the signature
+	tamper := func(seq uint64, fn func(e *AuditEntry)) {
+		t.Helper()
+		err := bl.write(func(w *writeTx) error {
+			bkt := w.tx.Bucket(auditBucket)
+			var e AuditEntry
+			if err := json.Unmarshal(bkt.Get(seqKey(seq)), &e); err != nil {
+				return err
+			}
+			fn(&e)
+			v, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			return bkt.Put(seqKey(seq), v)
+		})
+		if err != nil {
+			t.Fatalf("tamper: %s", err)
+		}
+	}
+	tamper(6, func(e *AuditEntry) {
+		e.Actor = "mallory"
+		e.Hash, _ = e.hash()
+	})
+	if err = bl.VerifyAuditLog(); !errors.Is(err, ErrAuditTampered) {
+		t.Errorf("expected ErrAuditTampered, got %v", err)
+	}
+	tamper(2, func(e *AuditEntry) { e.Key = "someone else" })
+	if err = bl.VerifyAuditLog(); !errors.Is(err, ErrAuditTampered) {
+		t.Errorf("expected ErrAuditTampered, got %v", err)
+	}
+}
+
+func TestAuditLogUnsigned(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("audit_unsigned_test.db")
+
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("GenerateKey: %s", err)
+	}
+	bl, err := NewBoltLocknut("audit_unsigned_test.db", ".", []byte("secret"), false, []string{"people"},
+		WithAuditLog(nil), WithAuditSigner(key))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	for _, k := range []string{"ada", "grace"} {
+		if err = bl.Save("people", k, k); err != nil {
+			t.Fatalf("Save: %s", err)
+		}
+	}
+
+	// a chain rebuilt without signatures, every hash recomputed, is still rejected
+	err = bl.write(func(w *writeTx) error {
+		bkt := w.tx.Bucket(auditBucket)
+		var prev []byte
+		for seq := uint64(1); bkt.Get(seqKey(seq)) != nil; seq++ {
+			var e AuditEntry
+			if err := json.Unmarshal(bkt.Get(seqKey(seq)), &e); err != nil {
+				return err
+			}
+			e.Actor, e.Prev, e.Sig = "mallory", prev, nil
+			h, err := e.hash()
+			if err != nil {
+				return err
+			}
+			e.Hash, prev = h, h
+			v, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			if err = bkt.Put(seqKey(seq), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("rewrite: %s", err)
+	}
+	if err = bl.VerifyAuditLog(); !errors.Is(err, ErrAuditTampered) {
+		t.Errorf("expected ErrAuditTampered, got %v", err)
+	}
+	if err = bl.ExportAuditLog(io.Discard); !errors.Is(err, ErrAuditTampered) {
+		t.Errorf("expected the export to refuse the log, got %v", err)
+	}
+}
+
+func TestAuditLogOff(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("audit_off_test.db")
+
+	bl, err := NewBoltLocknut("audit_off_test.db", ".", []byte("secret"), false, []string{"people"})
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if err = bl.Save("people", "ada", "Ada"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if entries, err := bl.AuditLog(0); err != nil || len(entries) != 0 {
+		t.Errorf("expected no entries, got %v %v", entries, err)
+	}
+}
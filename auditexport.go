@@ -0,0 +1,163 @@
+package locknut
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrNoAuditSigner is returned by ExportAuditLog for a BoltLocknut without WithAuditSigner
+var ErrNoAuditSigner = errors.New("audit log export needs an audit signer")
+
+// An audit export is JSON lines: every AuditEntry of the log, oldest first, then a trailer holding the
+// number of entries, the hash of the last one and the SHA-256 of the entry lines, signed with the
+// audit signer. The trailer is the only line starting with auditExportMagic.
+const auditExportVersion = 1
+
+var auditExportMagic = []byte(`{"locknut_audit_export":`)
+
+type auditTrailer struct {
+	Version int       `json:"locknut_audit_export"`
+	DBID    string    `json:"db"`
+	Time    time.Time `json:"time"`
+	Entries int       `json:"entries"`
+	Last    []byte    `json:"last,omitempty"`
+	Digest  []byte    `json:"digest"`
+	Sig     []byte    `json:"sig,omitempty"`
+}
+
+// signed returns the bytes Sig signs, the trailer without it
+func (t auditTrailer) signed() ([]byte, error) {
+	t.Sig = nil
+	return json.Marshal(t)
+}
+
+// AuditExport is an audit export checked by VerifyAuditExport
+type AuditExport struct {
+	// DBID is the ID of the db the log was exported from
+	DBID string
+	// Time is when the log was exported
+	Time    time.Time
+	Entries []AuditEntry
+}
+
+// ExportAuditLog writes the whole audit log to w as JSON lines ending with a trailer signed by the key
+// of WithAuditSigner, for auditors to check offline with VerifyAuditExport and the public key, without
+// the db or its secret. The entries hold bucket names and keys in the clear, never values. It fails
+// with ErrNoAuditSigner without WithAuditSigner, and with ErrAuditTampered for a log that does not
+// verify, see VerifyAuditLog, so a broken chain is never signed.
+func (bl *BoltLocknut) ExportAuditLog(w io.Writer) error {
+	if bl.auditKey == nil {
+		return ErrNoAuditSigner
+	}
+	id, err := bl.ID()
+	if err != nil {
+		return err
+	}
+	entries, err := bl.AuditLog(0)
+	if err != nil {
+		return err
+	}
+	if err = verifyAuditChain(entries, bl.auditKey.Public().(ed25519.PublicKey)); err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	digest := sha256.New()
+	out := io.MultiWriter(bw, digest)
+	for _, e := range entries {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if _, err = out.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+
+	t := auditTrailer{Version: auditExportVersion, DBID: id, Time: bl.now(), Entries: len(entries), Digest: digest.Sum(nil)}
+	if len(entries) > 0 {
+		t.Last = entries[len(entries)-1].Hash
+	}
+	signed, err := t.signed()
+	if err != nil {
+		return err
+	}
+	t.Sig = ed25519.Sign(bl.auditKey, signed)
+	line, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	if _, err = bw.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// VerifyAuditExport reads an export written by ExportAuditLog and checks it was signed with the private
+// key of pub, the public key of the audit signer obtained from a trusted source, that no line was
+// changed, added or removed, and that the entries form a whole, unbroken chain signed by the same
+// key. It fails with ErrAuditTampered otherwise.
+func VerifyAuditExport(r io.Reader, pub ed25519.PublicKey) (*AuditExport, error) {
+	if len(pub) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid audit signer public key")
+	}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), 16<<20)
+	digest := sha256.New()
+	var entries []AuditEntry
+	var trailer *auditTrailer
+	for sc.Scan() {
+		line := sc.Bytes()
+		if trailer != nil {
+			return nil, fmt.Errorf("%w: lines after the trailer", ErrAuditTampered)
+		}
+		if bytes.HasPrefix(line, auditExportMagic) {
+			trailer = &auditTrailer{}
+			if err := json.Unmarshal(line, trailer); err != nil {
+				return nil, fmt.Errorf("%w: trailer: %v", ErrAuditTampered, err)
+			}
+			continue
+		}
+		digest.Write(line)
+		digest.Write([]byte{'\n'})
+		var e AuditEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrAuditTampered, len(entries)+1, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	if trailer == nil {
+		return nil, fmt.Errorf("%w: no trailer, the export is truncated", ErrAuditTampered)
+	}
+	if trailer.Version != auditExportVersion {
+		return nil, fmt.Errorf("%w: unknown export version %d", ErrAuditTampered, trailer.Version)
+	}
+	signed, err := trailer.signed()
+	if err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(pub, signed, trailer.Sig) {
+		return nil, fmt.Errorf("%w: the trailer has no valid signature", ErrAuditTampered)
+	}
+	if !bytes.Equal(digest.Sum(nil), trailer.Digest) || len(entries) != trailer.Entries {
+		return nil, fmt.Errorf("%w: the entries are not the ones signed", ErrAuditTampered)
+	}
+	if len(entries) > 0 && !bytes.Equal(entries[len(entries)-1].Hash, trailer.Last) {
+		return nil, fmt.Errorf("%w: the last entry is not the one signed", ErrAuditTampered)
+	}
+	if err = verifyAuditChain(entries, pub); err != nil {
+		return nil, err
+	}
+	return &AuditExport{DBID: trailer.DBID, Time: trailer.Time, Entries: entries}, nil
+}
@@ -0,0 +1,72 @@
+package locknut
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestAuditExport(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("audit_export_test.db")
+
+	pub, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("GenerateKey: %s", err)
+	}
+	unsigned, err := NewBoltLocknut("audit_export_test.db", ".", []byte("secret"), false, []string{"people"},
+		WithAuditLog(nil))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if err = unsigned.ExportAuditLog(&bytes.Buffer{}); !errors.Is(err, ErrNoAuditSigner) {
+		t.Fatalf("expected ErrNoAuditSigner, got %v", err)
+	}
+	bl, err := NewBoltLocknut("audit_export_test.db", ".", []byte("secret"), false, []string{"people"},
+		WithAuditLog(nil), WithAuditSigner(key))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+
+	for _, k := range []string{"ada", "grace", "alan"} {
+		if err = bl.Save("people", k, k); err != nil {
+			t.Fatalf("Save: %s", err)
+		}
+	}
+	if _, err = bl.Get("people", "ada"); err != nil {
+		t.Fatalf("Get: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if err = bl.ExportAuditLog(&buf); err != nil {
+		t.Fatalf("ExportAuditLog: %s", err)
+	}
+	export, err := VerifyAuditExport(bytes.NewReader(buf.Bytes()), pub)
+	if err != nil {
+		t.Fatalf("VerifyAuditExport: %s", err)
+	}
+	id, _ := bl.ID()
+	if export.DBID != id || len(export.Entries) != 4 || export.Entries[3].Op != "get" {
+		t.Errorf("unexpected export %+v", export)
+	}
+
+	otherPub, _, _ := ed25519.GenerateKey(nil)
+	if _, err = VerifyAuditExport(bytes.NewReader(buf.Bytes()), otherPub); !errors.Is(err, ErrAuditTampered) {
+		t.Errorf("expected ErrAuditTampered for another key, got %v", err)
+	}
+
+	lines := bytes.SplitAfter(buf.Bytes(), []byte("\n"))
+	for name, tampered := range map[string][]byte{
+		"changed entry": bytes.Replace(buf.Bytes(), []byte(`"grace"`), []byte(`"carol"`), 1),
+		"dropped entry": bytes.Join(append(lines[:1:1], lines[2:]...), nil),
+		"no trailer":    bytes.Join(lines[:4], nil),
+		"appended":      append(append([]byte{}, buf.Bytes()...), lines[0]...),
+		"trailer":       bytes.Replace(buf.Bytes(), []byte(`"entries":4`), []byte(`"entries":3`), 1),
+	} {
+		if _, err = VerifyAuditExport(bytes.NewReader(tampered), pub); !errors.Is(err, ErrAuditTampered) {
+			t.Errorf("%s: expected ErrAuditTampered, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// Package awskms implements locknut.KeyProvider with AWS KMS, so the master key of a db lives in an
+// HSM backed KMS key and only the wrapped db key is kept next to the db.
+package awskms
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/taybart/locknut"
+)
+
+// encryptionContext binds wrapped keys to their use, KMS refuses to unwrap them with another context
+var encryptionContext = map[string]string{"locknut": "db key"}
+
+// API is the part of *kms.Client the provider uses
+type API interface {
+	Encrypt(ctx context.Context, in *kms.EncryptInput, opts ...func(*kms.Options)) (*kms.EncryptOutput, error)
+	Decrypt(ctx context.Context, in *kms.DecryptInput, opts ...func(*kms.Options)) (*kms.DecryptOutput, error)
+}
+
+// Provider wraps keys with a KMS key
+type Provider struct {
+	client  API
+	keyID   string
+	keyFile string
+}
+
+var _ locknut.KeyProvider = (*Provider)(nil)
+
+// New returns a Provider wrapping keys with the KMS key keyID, an ID, ARN or alias, and keeping the
+// wrapped db key in keyFile
+func New(client API, keyID, keyFile string) *Provider {
+	return &Provider{client: client, keyID: keyID, keyFile: keyFile}
+}
+
+// GetKey returns the db key, created on first use, see locknut.EnvelopeKey
+func (p *Provider) GetKey(ctx context.Context) ([]byte, error) {
+	return locknut.EnvelopeKey(ctx, p, p.keyFile)
+}
+
+// Wrap encrypts key with the KMS key
+func (p *Provider) Wrap(ctx context.Context, key []byte) ([]byte, error) {
+	out, err := p.client.Encrypt(ctx, &kms.EncryptInput{
+		KeyId:             aws.String(p.keyID),
+		Plaintext:         key,
+		EncryptionContext: encryptionContext,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.CiphertextBlob, nil
+}
+
+// Unwrap decrypts a key wrapped by Wrap
+func (p *Provider) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	out, err := p.client.Decrypt(ctx, &kms.DecryptInput{
+		KeyId:             aws.String(p.keyID),
+		CiphertextBlob:    wrapped,
+		EncryptionContext: encryptionContext,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Plaintext, nil
+}
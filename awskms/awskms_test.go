@@ -0,0 +1,50 @@
+package awskms
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"path/filepath"
+	"testing"
+)
+
+// fakeKMS "encrypts" by prefixing the key id, checking the encryption context on the way back
+type fakeKMS struct {
+	calls int
+}
+
+func (f *fakeKMS) Encrypt(ctx context.Context, in *kms.EncryptInput, opts ...func(*kms.Options)) (*kms.EncryptOutput, error) {
+	f.calls++
+	return &kms.EncryptOutput{CiphertextBlob: append([]byte(*in.KeyId+":"), in.Plaintext...)}, nil
+}
+
+func (f *fakeKMS) Decrypt(ctx context.Context, in *kms.DecryptInput, opts ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	f.calls++
+	prefix := []byte(*in.KeyId + ":")
+	if in.EncryptionContext["locknut"] != "db key" || !bytes.HasPrefix(in.CiphertextBlob, prefix) {
+		return nil, errors.New("InvalidCiphertextException")
+	}
+	return &kms.DecryptOutput{Plaintext: in.CiphertextBlob[len(prefix):]}, nil
+}
+
+func TestProvider(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "db.key")
+	client := &fakeKMS{}
+	p := New(client, "alias/locknut", keyFile)
+
+	key, err := p.GetKey(context.Background())
+	if err != nil || len(key) != 32 {
+		t.Fatalf("unexpected GetKey %x %v", key, err)
+	}
+	again, err := New(client, "alias/locknut", keyFile).GetKey(context.Background())
+	if err != nil || !bytes.Equal(again, key) {
+		t.Errorf("expected the same key from the key file, got %x %v", again, err)
+	}
+	if client.calls != 2 {
+		t.Errorf("expected one Encrypt and one Decrypt, got %d calls", client.calls)
+	}
+	if _, err = New(client, "alias/other", keyFile).GetKey(context.Background()); err == nil {
+		t.Errorf("expected another KMS key to fail")
+	}
+}
@@ -0,0 +1,154 @@
+package locknut
+
+import (
+	"crypto/ecdh"
+	"go.etcd.io/bbolt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// BackupOptions configures Backup
+type BackupOptions struct {
+	// Compact copies the live data into a fresh file, the way Compact does, and streams that instead
+	// of the file as it is, so the backup leaves out free pages
+	Compact bool
+	// Watermark, when set, records the changelog sequence number the backup holds under this consumer
+	// name, so TruncateChangelog keeps the changes needed to roll the backup forward
+	Watermark string
+	// Recipients, when set, encrypts the backup to these X25519 public keys, so the job taking it
+	// never holds a key able to read it back. Restoring it takes the private key of any of them,
+	// see DecryptBackup and GenerateBackupKey.
+	Recipients []*ecdh.PublicKey
+	// Location, when set, records the backup in the catalog under this location, such as the path or
+	// URL it is uploaded to, see ListBackups
+	Location string
+}
+
+// BackupInfo describes a backup written by Backup
+type BackupInfo struct {
+	// Size is the number of bytes written
+	Size int64
+	// Seq is the changelog sequence number of the last change the backup holds, 0 without a changelog
+	Seq uint64
+	// Time is when the snapshot was taken
+	Time time.Time
+	// DBID is the ID of the db, see ID
+	DBID string
+	// CatalogID is the ID of the catalog entry, 0 without a Location
+	CatalogID uint64
+	// SHA256 and MD5 are the hex digests of the bytes written, to compare with the checksums object
+	// stores report, see VerifyBackup and VerifyBackupETag
+	SHA256 string
+	MD5    string
+}
+
+// Backup writes a consistent snapshot of the db file to w while reads and writes go on. The backup
+// is a bbolt file encrypted like the db itself, it can be opened with NewBoltLocknut and the same
+// secret. With Recipients set it is encrypted once more, DecryptBackup turns it back into that file.
+func (bl *BoltLocknut) Backup(w io.Writer, opts BackupOptions) (BackupInfo, error) {
+	var info BackupInfo
+	var err error
+	if len(opts.Recipients) > 0 {
+		if err = bl.notFIPS("X25519 backup recipients"); err != nil {
+			return info, err
+		}
+	}
+	if err = bl.openDB(); err != nil {
+		return info, err
+	}
+	defer bl.closeDB()
+
+	if info.DBID, err = bl.ID(); err != nil {
+		return info, err
+	}
+
+	sums := newChecksums()
+	w = io.MultiWriter(w, sums)
+
+	var sw *sealWriter
+	if len(opts.Recipients) > 0 {
+		if sw, err = newSealWriter(w, opts.Recipients); err != nil {
+			return info, err
+		}
+		w = sw
+	}
+
+	err = bl.db.view(func(tx *bbolt.Tx) error {
+		info.Time = bl.now()
+		if bkt := tx.Bucket(changelogBucket); bkt != nil {
+			info.Seq = bkt.Sequence()
+		}
+		if !opts.Compact {
+			info.Size, err = tx.WriteTo(w)
+			return err
+		}
+		info.Size, err = bl.writeCompacted(tx, w)
+		return err
+	})
+	if err == nil && sw != nil {
+		err = sw.Close()
+		info.Size = sw.n
+	}
+	if err != nil {
+		return info, err
+	}
+	info.SHA256, info.MD5 = sums.hex()
+
+	if opts.Watermark != "" {
+		if err = bl.SetWatermark(opts.Watermark, info.Seq); err != nil {
+			return info, err
+		}
+	}
+	if opts.Location != "" {
+		info.CatalogID, err = bl.recordBackup(BackupEntry{
+			Location:  opts.Location,
+			Time:      info.Time,
+			Size:      info.Size,
+			DBID:      info.DBID,
+			Seq:       info.Seq,
+			Compacted: opts.Compact,
+			Encrypted: len(opts.Recipients) > 0,
+			SHA256:    info.SHA256,
+			MD5:       info.MD5,
+		})
+	}
+	return info, err
+}
+
+// WriteTo writes a consistent snapshot of the db file to w, the way Backup does without options, and
+// returns the number of bytes written. It makes a BoltLocknut an io.WriterTo.
+func (bl *BoltLocknut) WriteTo(w io.Writer) (int64, error) {
+	info, err := bl.Backup(w, BackupOptions{})
+	return info.Size, err
+}
+
+// writeCompacted copies the snapshot tx into a temporary file next to the db and streams it to w
+func (bl *BoltLocknut) writeCompacted(tx *bbolt.Tx, w io.Writer) (int64, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(bl.fullPath), filepath.Base(bl.fullPath)+".backup-*")
+	if err != nil {
+		return 0, err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	dst, err := bbolt.Open(tmp.Name(), 0600, &bbolt.Options{NoSync: true})
+	if err != nil {
+		return 0, err
+	}
+	err = copyDB(tx, dst, func([]byte) transformFunc { return nil })
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	f, err := os.Open(tmp.Name())
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return io.Copy(w, f)
+}
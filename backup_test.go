@@ -0,0 +1,175 @@
+package locknut
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestBackup(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("backup_test.db")
+	defer os.Remove("backup_full_test.db")
+	defer os.Remove("backup_compact_test.db")
+
+	bl, err := NewBoltLocknut("backup_test.db", ".", []byte("secret"), false, []string{"items"}, WithChangelog())
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	value := strings.Repeat("x", 1024)
+	for i := 0; i < 300; i++ {
+		if err = bl.Save("items", fmt.Sprintf("%04d", i), value); err != nil {
+			t.Fatalf("Save: %s", err)
+		}
+	}
+	for i := 1; i < 300; i++ {
+		if err = bl.Delete("items", fmt.Sprintf("%04d", i)); err != nil {
+			t.Fatalf("Delete: %s", err)
+		}
+	}
+	if _, err = bl.TruncateChangelog(600); err != nil {
+		t.Fatalf("TruncateChangelog: %s", err)
+	}
+
+	backup := func(path string, opts BackupOptions) BackupInfo {
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatalf("Create: %s", err)
+		}
+		defer f.Close()
+		info, err := bl.Backup(f, opts)
+		if err != nil {
+			t.Fatalf("Backup: %s", err)
+		}
+		return info
+	}
+	full := backup("backup_full_test.db", BackupOptions{})
+	compact := backup("backup_compact_test.db", BackupOptions{Compact: true, Watermark: "nightly"})
+
+	if compact.Size >= full.Size {
+		t.Errorf("expected the compacted backup to be smaller, %d and %d", compact.Size, full.Size)
+	}
+	if compact.Seq != 599 {
+		t.Errorf("expected the backup to hold changes up to 599, got %d", compact.Seq)
+	}
+	if marks, _ := bl.Watermarks(); marks["nightly"] != 599 {
+		t.Errorf("watermark was not recorded: %v", marks)
+	}
+
+	for _, path := range []string{"backup_full_test.db", "backup_compact_test.db"} {
+		restored, err := NewBoltLocknut(path, ".", []byte("secret"), false, nil)
+		if err != nil {
+			t.Fatalf("NewBoltLocknut %s: %s", path, err)
+		}
+		if v, err := restored.Get("items", "0000"); err != nil || string(v) != `"`+value+`"` {
+			t.Errorf("%s: record missing from backup: %v", path, err)
+		}
+		if n, _ := restored.Count("items"); n != 1 {
+			t.Errorf("%s: expected 1 record, got %d", path, n)
+		}
+	}
+}
+
+func TestBackupRecipients(t *testing.T) {
+	skipFIPS(t, "X25519 backup recipients")
+	defer os.Remove("backup_sealed_test.db")
+	defer os.Remove("backup_opened_test.db")
+
+	bl, err := NewBoltLocknut("backup_sealed_test.db", ".", []byte("secret"), false, []string{"items"})
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	for i := 0; i < 200; i++ {
+		if err = bl.Save("items", fmt.Sprintf("%04d", i), strings.Repeat("y", 1024)); err != nil {
+			t.Fatalf("Save: %s", err)
+		}
+	}
+
+	offsite, _ := GenerateBackupKey()
+	operator, _ := GenerateBackupKey()
+	stranger, _ := GenerateBackupKey()
+
+	var sealed bytes.Buffer
+	info, err := bl.Backup(&sealed, BackupOptions{Recipients: []*ecdh.PublicKey{offsite.PublicKey(), operator.PublicKey()}})
+	if err != nil {
+		t.Fatalf("Backup: %s", err)
+	}
+	if info.Size != int64(sealed.Len()) {
+		t.Errorf("expected size %d, got %d", sealed.Len(), info.Size)
+	}
+
+	if _, err = DecryptBackup(io.Discard, bytes.NewReader(sealed.Bytes()), stranger); !errors.Is(err, ErrNotRecipient) {
+		t.Errorf("expected ErrNotRecipient, got %v", err)
+	}
+	truncated := sealed.Bytes()[:sealed.Len()-10]
+	if _, err = DecryptBackup(io.Discard, bytes.NewReader(truncated), operator); !errors.Is(err, ErrBackupCorrupt) {
+		t.Errorf("expected ErrBackupCorrupt for a truncated backup, got %v", err)
+	}
+
+	f, err := os.Create("backup_opened_test.db")
+	if err != nil {
+		t.Fatalf("Create: %s", err)
+	}
+	_, err = DecryptBackup(f, bytes.NewReader(sealed.Bytes()), offsite)
+	f.Close()
+	if err != nil {
+		t.Fatalf("DecryptBackup: %s", err)
+	}
+	restored, err := NewBoltLocknut("backup_opened_test.db", ".", []byte("secret"), false, nil)
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if n, _ := restored.Count("items"); n != 200 {
+		t.Errorf("expected 200 records in the restored backup, got %d", n)
+	}
+}
+
+// failingWriter fails once it was given limit bytes
+type failingWriter struct {
+	limit int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		n := w.limit
+		w.limit = 0
+		return n, errors.New("disk full")
+	}
+	w.limit -= len(p)
+	return len(p), nil
+}
+
+func TestWriteTo(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("writeto_test.db")
+
+	bl, err := NewBoltLocknut("writeto_test.db", ".", []byte("secret"), false, []string{"items"})
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if err = bl.Save("items", "a", 1); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+
+	var buf bytes.Buffer
+	n, err := bl.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("WriteTo: %s", err)
+	}
+	if n != int64(buf.Len()) || n == 0 {
+		t.Errorf("expected the bytes written reported, got %d for %d", n, buf.Len())
+	}
+	if got := bl.GetDBBytes(); len(got) != buf.Len() {
+		t.Errorf("expected GetDBBytes to return the db, got %d bytes for %d", len(got), buf.Len())
+	}
+
+	// errors of the writer are returned
+	if _, err = bl.WriteTo(&failingWriter{limit: 100}); err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Errorf("expected the error of the writer, got %v", err)
+	}
+}
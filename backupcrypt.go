@@ -0,0 +1,231 @@
+package locknut
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrNotRecipient is returned by DecryptBackup when the backup was not encrypted to the key
+	ErrNotRecipient = errors.New("backup is not encrypted to this key")
+	// ErrBackupCorrupt is returned by DecryptBackup for a backup that was truncated or altered
+	ErrBackupCorrupt = errors.New("backup is corrupt")
+)
+
+// An encrypted backup starts with sealedBackupMagic and a recipient count, then holds for each
+// recipient an ephemeral X25519 public key and the backup key wrapped for it. The backup follows in
+// chunks of a uint32 length and the AES-GCM sealed chunk, the nonce of each chunk is its sequence
+// number with a flag marking the last one, so truncated and reordered backups fail to decrypt.
+const (
+	sealedBackupMagic = "locknut backup v1\n"
+	sealedChunkSize   = 64 << 10
+	wrappedKeySize    = 12 + 32 + 16
+	recipientSize     = 32 + wrappedKeySize
+)
+
+var keyWrapInfo = []byte("locknut backup key wrap")
+
+// GenerateBackupKey returns a new X25519 key pair for encrypting backups. Backup jobs only need the
+// public key, see BackupOptions.Recipients, the private key is only needed by DecryptBackup.
+func GenerateBackupKey() (*ecdh.PrivateKey, error) {
+	if err := notFIPSBuild("X25519 backup keys"); err != nil {
+		return nil, err
+	}
+	return ecdh.X25519().GenerateKey(rand.Reader)
+}
+
+// wrapKeyFor derives the key that wraps the backup key for the exchange between eph and recipient
+func wrapKeyFor(shared []byte, eph, recipient *ecdh.PublicKey) []byte {
+	salt := append(append([]byte(nil), eph.Bytes()...), recipient.Bytes()...)
+	return HKDF(shared, salt, keyWrapInfo, 32)
+}
+
+// sealWriter encrypts everything written to it to a set of recipients, Close writes the last chunk
+type sealWriter struct {
+	w    io.Writer
+	aead cipher.AEAD
+	aad  []byte
+	buf  []byte
+	seq  uint64
+	n    int64
+}
+
+func newSealWriter(w io.Writer, recipients []*ecdh.PublicKey) (*sealWriter, error) {
+	if len(recipients) > 255 {
+		return nil, fmt.Errorf("too many backup recipients: %d", len(recipients))
+	}
+	key, err := GetRandKey()
+	if err != nil {
+		return nil, err
+	}
+
+	header := []byte(sealedBackupMagic)
+	header = append(header, byte(len(recipients)))
+	for _, recipient := range recipients {
+		eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		shared, err := eph.ECDH(recipient)
+		if err != nil {
+			return nil, err
+		}
+		wrapped, err := Encrypt(key, wrapKeyFor(shared, eph.PublicKey(), recipient))
+		if err != nil {
+			return nil, err
+		}
+		header = append(header, eph.PublicKey().Bytes()...)
+		header = append(header, wrapped...)
+	}
+
+	aead, err := newChunkAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	sw := &sealWriter{w: w, aead: aead, aad: headerDigest(header)}
+	if err = sw.write(header); err != nil {
+		return nil, err
+	}
+	return sw, nil
+}
+
+func newChunkAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// headerDigest binds every chunk to the header, so recipients cannot be swapped or dropped
+func headerDigest(header []byte) []byte {
+	sum := sha256.Sum256(header)
+	return sum[:]
+}
+
+func chunkNonce(seq uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce, seq)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+func (sw *sealWriter) write(p []byte) error {
+	n, err := sw.w.Write(p)
+	sw.n += int64(n)
+	return err
+}
+
+func (sw *sealWriter) seal(chunk []byte, last bool) error {
+	sealed := sw.aead.Seal(make([]byte, 4, 4+len(chunk)+sw.aead.Overhead()), chunkNonce(sw.seq, last), chunk, sw.aad)
+	binary.BigEndian.PutUint32(sealed, uint32(len(sealed)-4))
+	sw.seq++
+	return sw.write(sealed)
+}
+
+func (sw *sealWriter) Write(p []byte) (int, error) {
+	sw.buf = append(sw.buf, p...)
+	for len(sw.buf) > sealedChunkSize {
+		if err := sw.seal(sw.buf[:sealedChunkSize], false); err != nil {
+			return 0, err
+		}
+		sw.buf = sw.buf[sealedChunkSize:]
+	}
+	return len(p), nil
+}
+
+func (sw *sealWriter) Close() error {
+	return sw.seal(sw.buf, true)
+}
+
+// DecryptBackup decrypts a backup written by Backup with Recipients to dst, using the private key of
+// one of the recipients. What it writes is the plain backup, a bbolt file that opens with
+// NewBoltLocknut and the secret of the db it was taken from. It fails with ErrNotRecipient if key is
+// not one of the recipients and with ErrBackupCorrupt if the backup was truncated or altered, in
+// which case dst may already hold part of it.
+func DecryptBackup(dst io.Writer, src io.Reader, key *ecdh.PrivateKey) (int64, error) {
+	if err := notFIPSBuild("X25519 backup keys"); err != nil {
+		return 0, err
+	}
+	header := make([]byte, len(sealedBackupMagic)+1)
+	if _, err := io.ReadFull(src, header); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrBackupCorrupt, err)
+	}
+	if !bytes.HasPrefix(header, []byte(sealedBackupMagic)) {
+		return 0, fmt.Errorf("%w: not an encrypted backup", ErrBackupCorrupt)
+	}
+	recipients := make([]byte, int(header[len(header)-1])*recipientSize)
+	if _, err := io.ReadFull(src, recipients); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrBackupCorrupt, err)
+	}
+	header = append(header, recipients...)
+
+	var backupKey []byte
+	for len(recipients) > 0 && backupKey == nil {
+		slot := recipients[:recipientSize]
+		recipients = recipients[recipientSize:]
+		eph, err := ecdh.X25519().NewPublicKey(slot[:32])
+		if err != nil {
+			continue
+		}
+		shared, err := key.ECDH(eph)
+		if err != nil {
+			continue
+		}
+		backupKey, _ = Decrypt(slot[32:], wrapKeyFor(shared, eph, key.PublicKey()))
+	}
+	if backupKey == nil {
+		return 0, ErrNotRecipient
+	}
+
+	aead, err := newChunkAEAD(backupKey)
+	if err != nil {
+		return 0, err
+	}
+	return openChunks(dst, src, aead, headerDigest(header), ErrBackupCorrupt)
+}
+
+// openChunks decrypts the chunks sealed by a sealWriter from src to dst, up to the last one. Errors
+// reading or opening them are wrapped in corrupt.
+func openChunks(dst io.Writer, src io.Reader, aead cipher.AEAD, aad []byte, corrupt error) (int64, error) {
+	var written int64
+	size := make([]byte, 4)
+	for seq := uint64(0); ; seq++ {
+		if _, err := io.ReadFull(src, size); err != nil {
+			return written, fmt.Errorf("%w: %v", corrupt, err)
+		}
+		n := binary.BigEndian.Uint32(size)
+		if n > sealedChunkSize+uint32(aead.Overhead()) {
+			return written, fmt.Errorf("%w: chunk of %d bytes", corrupt, n)
+		}
+		sealed := make([]byte, n)
+		if _, err := io.ReadFull(src, sealed); err != nil {
+			return written, fmt.Errorf("%w: %v", corrupt, err)
+		}
+
+		last := false
+		chunk, err := aead.Open(nil, chunkNonce(seq, false), sealed, aad)
+		if err != nil {
+			if chunk, err = aead.Open(nil, chunkNonce(seq, true), sealed, aad); err != nil {
+				return written, fmt.Errorf("%w: chunk %d does not decrypt", corrupt, seq)
+			}
+			last = true
+		}
+
+		w, err := dst.Write(chunk)
+		written += int64(w)
+		if err != nil || last {
+			return written, err
+		}
+	}
+}
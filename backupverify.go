@@ -0,0 +1,140 @@
+package locknut
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"hash"
+	"io"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrBackupNotFound is returned for a backup ID that is not in the catalog
+	ErrBackupNotFound = errors.New("backup is not in the catalog")
+	// ErrBackupMismatch is returned when the stored copy of a backup differs from what was written
+	ErrBackupMismatch = errors.New("stored backup does not match the catalog")
+	// ErrETagUnverifiable is returned by VerifyBackupETag for ETags that are not an MD5 of the object,
+	// such as those of multipart uploads
+	ErrETagUnverifiable = errors.New("etag is not an md5 of the backup")
+)
+
+// Verification is the result of checking the stored copy of a backup against the catalog
+type Verification struct {
+	Time time.Time `json:"time"`
+	// Method is "sha256" when the copy was read back and hashed, "etag" when the checksum reported by
+	// the object store was compared
+	Method string `json:"method"`
+	OK     bool   `json:"ok"`
+}
+
+// checksums hashes the bytes of a backup as it is written
+type checksums struct {
+	sha256, md5 hash.Hash
+}
+
+func newChecksums() *checksums {
+	return &checksums{sha256: sha256.New(), md5: md5.New()}
+}
+
+func (c *checksums) Write(p []byte) (int, error) {
+	c.sha256.Write(p)
+	c.md5.Write(p)
+	return len(p), nil
+}
+
+func (c *checksums) hex() (string, string) {
+	return hex.EncodeToString(c.sha256.Sum(nil)), hex.EncodeToString(c.md5.Sum(nil))
+}
+
+// VerifyBackup reads the stored copy of the backup with open, re-downloading it from object storage
+// for instance, and compares its SHA-256 with the one recorded when it was written. The result is
+// recorded in the catalog, and a copy that differs fails with ErrBackupMismatch. Errors opening or
+// reading the copy are returned without recording anything.
+func (bl *BoltLocknut) VerifyBackup(id uint64, open func(BackupEntry) (io.ReadCloser, error)) (Verification, error) {
+	entry, err := bl.backupEntry(id)
+	if err != nil {
+		return Verification{}, err
+	}
+
+	r, err := open(entry)
+	if err != nil {
+		return Verification{}, err
+	}
+	defer r.Close()
+	sum := sha256.New()
+	if _, err = io.Copy(sum, r); err != nil {
+		return Verification{}, err
+	}
+
+	return bl.recordVerification(id, "sha256", hex.EncodeToString(sum.Sum(nil)) == entry.SHA256)
+}
+
+// VerifyBackupETag compares the ETag an object store such as S3 returned for the upload of the
+// backup with the MD5 recorded when it was written, without reading the copy back. The result is
+// recorded in the catalog, and a copy that differs fails with ErrBackupMismatch. ETags of multipart
+// uploads are not an MD5 of the object and fail with ErrETagUnverifiable, use VerifyBackup for those.
+func (bl *BoltLocknut) VerifyBackupETag(id uint64, etag string) (Verification, error) {
+	entry, err := bl.backupEntry(id)
+	if err != nil {
+		return Verification{}, err
+	}
+
+	etag = strings.Trim(etag, `"`)
+	if strings.Contains(etag, "-") || len(etag) != md5.Size*2 {
+		return Verification{}, fmt.Errorf("%w: %s", ErrETagUnverifiable, etag)
+	}
+	return bl.recordVerification(id, "etag", strings.EqualFold(etag, entry.MD5))
+}
+
+// backupEntry returns the catalog entry of the backup with id
+func (bl *BoltLocknut) backupEntry(id uint64) (BackupEntry, error) {
+	var entry BackupEntry
+	var err error
+	if err = bl.openDB(); err != nil {
+		return entry, err
+	}
+	defer bl.closeDB()
+
+	err = bl.db.view(func(tx *bbolt.Tx) error {
+		return getBackupEntry(tx, id, &entry)
+	})
+	return entry, err
+}
+
+func getBackupEntry(tx *bbolt.Tx, id uint64, entry *BackupEntry) error {
+	var v []byte
+	if bkt := tx.Bucket(catalogBucket); bkt != nil {
+		v = bkt.Get(seqKey(id))
+	}
+	if v == nil {
+		return fmt.Errorf("%w: %d", ErrBackupNotFound, id)
+	}
+	return json.Unmarshal(v, entry)
+}
+
+// recordVerification stores the outcome of a check in the catalog entry of the backup with id
+func (bl *BoltLocknut) recordVerification(id uint64, method string, ok bool) (Verification, error) {
+	v := Verification{Time: bl.now(), Method: method, OK: ok}
+	err := bl.write(func(w *writeTx) error {
+		var entry BackupEntry
+		if err := getBackupEntry(w.tx, id, &entry); err != nil {
+			return err
+		}
+		entry.Verification = &v
+		stored, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		return w.tx.Bucket(catalogBucket).Put(seqKey(id), stored)
+	})
+	if err == nil && !ok {
+		err = fmt.Errorf("%w: backup %d", ErrBackupMismatch, id)
+	}
+	return v, err
+}
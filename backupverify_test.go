@@ -0,0 +1,61 @@
+package locknut
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestVerifyBackup(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("backupverify_test.db")
+
+	bl, err := NewBoltLocknut("backupverify_test.db", ".", []byte("secret"), false, []string{"items"})
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if err = bl.Save("items", "a", "one"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+
+	// the uploaded copy
+	var uploaded bytes.Buffer
+	info, err := bl.Backup(&uploaded, BackupOptions{Location: "s3://bucket/a"})
+	if err != nil {
+		t.Fatalf("Backup: %s", err)
+	}
+	sum := md5.Sum(uploaded.Bytes())
+	if info.MD5 != hex.EncodeToString(sum[:]) {
+		t.Errorf("unexpected md5 %s", info.MD5)
+	}
+
+	download := func(e BackupEntry) (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(uploaded.Bytes())), nil
+	}
+	if v, err := bl.VerifyBackup(info.CatalogID, download); err != nil || !v.OK || v.Method != "sha256" {
+		t.Errorf("unexpected verification %+v %v", v, err)
+	}
+	if _, err = bl.VerifyBackupETag(info.CatalogID, `"`+info.MD5+`"`); err != nil {
+		t.Errorf("VerifyBackupETag: %s", err)
+	}
+	if _, err = bl.VerifyBackupETag(info.CatalogID, `"`+info.MD5[:32]+`-3"`); !errors.Is(err, ErrETagUnverifiable) {
+		t.Errorf("expected ErrETagUnverifiable for a multipart etag, got %v", err)
+	}
+	if _, err = bl.VerifyBackup(99, download); !errors.Is(err, ErrBackupNotFound) {
+		t.Errorf("expected ErrBackupNotFound, got %v", err)
+	}
+
+	// a copy damaged on its way is recorded as such
+	uploaded.Bytes()[100] ^= 0xff
+	if _, err = bl.VerifyBackup(info.CatalogID, download); !errors.Is(err, ErrBackupMismatch) {
+		t.Errorf("expected ErrBackupMismatch, got %v", err)
+	}
+	entries, _ := bl.ListBackups()
+	if len(entries) != 1 || entries[0].Verification == nil || entries[0].Verification.OK {
+		t.Errorf("expected a failed verification in the catalog, got %+v", entries)
+	}
+}
@@ -0,0 +1,328 @@
+// Package badger implements locknut.Locknut on BadgerDB, for write heavy workloads where the write
+// amplification of bbolt's copy on write pages hurts. Values are encrypted exactly like BoltLocknut
+// encrypts them, and records can expire with Badger's native TTL.
+package badger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	gobadger "github.com/dgraph-io/badger/v4"
+	"github.com/taybart/locknut"
+	"time"
+)
+
+// Keys are laid out as a one byte kind followed by the name, a record key being its bucket, a zero
+// byte and the key, so a bucket and a key prefix map to a Badger key prefix
+const (
+	recordKind = 'r'
+	bucketKind = 'b'
+	metaKind   = 'm'
+)
+
+var canaryKey = []byte{metaKind, 'c', 'a', 'n', 'a', 'r', 'y'}
+
+var canaryPlaintext = []byte("locknut canary v1")
+
+// BadgerLocknut is a locknut.Locknut stored in a Badger db
+type BadgerLocknut struct {
+	db     *gobadger.DB
+	secret []byte
+}
+
+var _ locknut.Locknut = (*BadgerLocknut)(nil)
+
+// Option configures a BadgerLocknut, passed to NewBadgerLocknut
+type Option func(*gobadger.Options)
+
+// WithInMemory keeps the db in memory only, dir is ignored
+func WithInMemory() Option {
+	return func(o *gobadger.Options) {
+		*o = o.WithInMemory(true).WithDir("").WithValueDir("")
+	}
+}
+
+// WithBadgerOptions adjusts the Badger options the db is opened with
+func WithBadgerOptions(fn func(gobadger.Options) gobadger.Options) Option {
+	return func(o *gobadger.Options) {
+		*o = fn(*o)
+	}
+}
+
+// NewBadgerLocknut opens the Badger db in dir, creating it and buckets if needed. Values are
+// encrypted with the key derived from secret, and opening an existing db with another secret fails
+// with locknut.ErrWrongSecret. Badger's own logging is off unless set with WithBadgerOptions.
+func NewBadgerLocknut(dir string, secret []byte, buckets []string, opts ...Option) (*BadgerLocknut, error) {
+	options := gobadger.DefaultOptions(dir).WithLogger(nil)
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	db, err := gobadger.Open(options)
+	if err != nil {
+		return nil, err
+	}
+	bl := &BadgerLocknut{db: db, secret: locknut.DeriveKey(secret)}
+
+	if err = db.Update(bl.checkSecret); err != nil {
+		db.Close()
+		return nil, err
+	}
+	for _, b := range buckets {
+		if err = bl.CreateBucket(b); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return bl, nil
+}
+
+// Close closes the db
+func (bl *BadgerLocknut) Close() error {
+	return bl.db.Close()
+}
+
+// checkSecret verifies the secret against the canary, writing it for a new db
+func (bl *BadgerLocknut) checkSecret(txn *gobadger.Txn) error {
+	item, err := txn.Get(canaryKey)
+	if err == gobadger.ErrKeyNotFound {
+		stored, err := locknut.Encrypt(canaryPlaintext, bl.secret)
+		if err != nil {
+			return err
+		}
+		return txn.Set(canaryKey, stored)
+	}
+	if err != nil {
+		return err
+	}
+	return item.Value(func(v []byte) error {
+		plain, err := locknut.Decrypt(v, bl.secret)
+		if err != nil || !bytes.Equal(plain, canaryPlaintext) {
+			return locknut.ErrWrongSecret
+		}
+		return nil
+	})
+}
+
+func bucketKey(bucket string) []byte {
+	return append([]byte{bucketKind}, bucket...)
+}
+
+func recordKey(bucket, key string) []byte {
+	k := make([]byte, 0, len(bucket)+len(key)+2)
+	k = append(k, recordKind)
+	k = append(k, bucket...)
+	k = append(k, 0)
+	return append(k, key...)
+}
+
+// requireBucket fails with a locknut.RecordError of kind locknut.ErrBucketNotFound if bucket does
+// not exist
+func requireBucket(txn *gobadger.Txn, op, bucket string) error {
+	_, err := txn.Get(bucketKey(bucket))
+	if err == gobadger.ErrKeyNotFound {
+		return &locknut.RecordError{Op: op, Bucket: bucket, Kind: locknut.ErrBucketNotFound}
+	}
+	return err
+}
+
+// decrypt decrypts the value of item, the record key of bucket/key
+func (bl *BadgerLocknut) decrypt(bucket, key string, item *gobadger.Item) ([]byte, error) {
+	var plain []byte
+	err := item.Value(func(v []byte) error {
+		var err error
+		// Decrypt returns a new slice, so plain stays valid after the transaction
+		plain, err = locknut.Decrypt(v, bl.secret)
+		return err
+	})
+	if err != nil {
+		kind := locknut.ErrDecrypt
+		if errors.Is(err, locknut.ErrCiphertextTooShort) {
+			kind = locknut.ErrCorrupt
+		}
+		return nil, &locknut.RecordError{Op: "decrypt", Bucket: bucket, Key: key, Kind: kind, Err: err}
+	}
+	return plain, nil
+}
+
+// Save stores data as json under key in bucket
+func (bl *BadgerLocknut) Save(bucket, key string, data interface{}) error {
+	return bl.SaveWithTTL(bucket, key, data, 0)
+}
+
+// SaveWithTTL is Save for a record that expires after ttl, zero keeps it forever
+func (bl *BadgerLocknut) SaveWithTTL(bucket, key string, data interface{}, ttl time.Duration) error {
+	if data == nil {
+		return errors.New("data is nil")
+	}
+	value, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return bl.SaveBytesWithTTL(bucket, key, value, ttl)
+}
+
+// SaveBytes stores data as it is under key in bucket
+func (bl *BadgerLocknut) SaveBytes(bucket, key string, data []byte) error {
+	return bl.SaveBytesWithTTL(bucket, key, data, 0)
+}
+
+// SaveBytesWithTTL is SaveBytes for a record that expires after ttl, zero keeps it forever
+func (bl *BadgerLocknut) SaveBytesWithTTL(bucket, key string, data []byte, ttl time.Duration) error {
+	if data == nil {
+		return errors.New("data is nil")
+	}
+	if key == "" {
+		return locknut.ErrKeyInvalid
+	}
+
+	stored, err := locknut.Encrypt(data, bl.secret)
+	if err != nil {
+		return &locknut.RecordError{Op: "encrypt", Bucket: bucket, Key: key, Kind: locknut.ErrEncrypt, Err: err}
+	}
+	return bl.db.Update(func(txn *gobadger.Txn) error {
+		if err := requireBucket(txn, "put", bucket); err != nil {
+			return err
+		}
+		e := gobadger.NewEntry(recordKey(bucket, key), stored)
+		if ttl > 0 {
+			e = e.WithTTL(ttl)
+		}
+		return txn.SetEntry(e)
+	})
+}
+
+// Get returns the record stored under exactly key, or nil if there is none
+func (bl *BadgerLocknut) Get(bucket, key string) (result []byte, err error) {
+	err = bl.db.View(func(txn *gobadger.Txn) error {
+		if err := requireBucket(txn, "get", bucket); err != nil {
+			return err
+		}
+		item, err := txn.Get(recordKey(bucket, key))
+		if err == gobadger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		result, err = bl.decrypt(bucket, key, item)
+		return err
+	})
+	return result, err
+}
+
+// scan calls fn with every record of bucket whose key starts with prefix, in key order
+func (bl *BadgerLocknut) scan(txn *gobadger.Txn, op, bucket, prefix string, values bool, fn func(key string, item *gobadger.Item) (bool, error)) error {
+	if err := requireBucket(txn, op, bucket); err != nil {
+		return err
+	}
+
+	opts := gobadger.DefaultIteratorOptions
+	opts.PrefetchValues = values
+	opts.Prefix = recordKey(bucket, prefix)
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	skip := len(bucket) + 2
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		more, err := fn(string(item.Key()[skip:]), item)
+		if err != nil || !more {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetOne returns the first record whose key starts with key
+func (bl *BadgerLocknut) GetOne(bucket, key string) (result []byte, err error) {
+	if key == "" {
+		return nil, locknut.ErrKeyInvalid
+	}
+	err = bl.db.View(func(txn *gobadger.Txn) error {
+		return bl.scan(txn, "get", bucket, key, true, func(k string, item *gobadger.Item) (bool, error) {
+			var err error
+			result, err = bl.decrypt(bucket, k, item)
+			return false, err
+		})
+	})
+	return result, err
+}
+
+// GetByPrefix returns the decrypted records whose keys start with prefix
+func (bl *BadgerLocknut) GetByPrefix(bucket, prefix string) (map[string][]byte, error) {
+	results := make(map[string][]byte)
+	err := bl.db.View(func(txn *gobadger.Txn) error {
+		return bl.scan(txn, "get", bucket, prefix, true, func(k string, item *gobadger.Item) (bool, error) {
+			v, err := bl.decrypt(bucket, k, item)
+			results[k] = v
+			return true, err
+		})
+	})
+	return results, err
+}
+
+// GetKeyList returns the keys of bucket starting with prefix, in order
+func (bl *BadgerLocknut) GetKeyList(bucket, prefix string) ([]string, error) {
+	keys := make([]string, 0)
+	err := bl.db.View(func(txn *gobadger.Txn) error {
+		return bl.scan(txn, "list", bucket, prefix, false, func(k string, _ *gobadger.Item) (bool, error) {
+			keys = append(keys, k)
+			return true, nil
+		})
+	})
+	return keys, err
+}
+
+// Delete removes key from bucket
+func (bl *BadgerLocknut) Delete(bucket, key string) error {
+	if key == "" {
+		return errors.New("cannot delete, key is nil")
+	}
+	return bl.db.Update(func(txn *gobadger.Txn) error {
+		if err := requireBucket(txn, "delete", bucket); err != nil {
+			return err
+		}
+		return txn.Delete(recordKey(bucket, key))
+	})
+}
+
+// Buckets returns the names of the buckets in the db
+func (bl *BadgerLocknut) Buckets() ([]string, error) {
+	names := make([]string, 0)
+	err := bl.db.View(func(txn *gobadger.Txn) error {
+		opts := gobadger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = []byte{bucketKind}
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			names = append(names, string(it.Item().Key()[1:]))
+		}
+		return nil
+	})
+	return names, err
+}
+
+// CreateBucket creates the bucket if it does not exist yet
+func (bl *BadgerLocknut) CreateBucket(name string) error {
+	if name == "" || bytes.IndexByte([]byte(name), 0) >= 0 {
+		return locknut.ErrBucketInvalid
+	}
+	return bl.db.Update(func(txn *gobadger.Txn) error {
+		return txn.Set(bucketKey(name), nil)
+	})
+}
+
+// Count returns the number of records in bucket that have not expired
+func (bl *BadgerLocknut) Count(bucket string) (int, error) {
+	n := 0
+	err := bl.db.View(func(txn *gobadger.Txn) error {
+		return bl.scan(txn, "count", bucket, "", false, func(string, *gobadger.Item) (bool, error) {
+			n++
+			return true, nil
+		})
+	})
+	return n, err
+}
@@ -0,0 +1,95 @@
+package badger
+
+import (
+	"errors"
+	gobadger "github.com/dgraph-io/badger/v4"
+	"github.com/taybart/locknut"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBadgerLocknut(t *testing.T) {
+	defer os.RemoveAll("badger_test")
+
+	bl, err := NewBadgerLocknut("badger_test", []byte("secret"), []string{"articles"})
+	if err != nil {
+		t.Fatalf("NewBadgerLocknut: %s", err)
+	}
+
+	for k, v := range map[string]string{"a1": "one", "a2": "two", "b1": "three"} {
+		if err = bl.Save("articles", k, v); err != nil {
+			t.Fatalf("Save: %s", err)
+		}
+	}
+	if err = bl.Save("missing", "a1", "one"); !errors.Is(err, locknut.ErrBucketNotFound) {
+		t.Errorf("expected ErrBucketNotFound, got %v", err)
+	}
+
+	if v, err := bl.Get("articles", "a1"); err != nil || string(v) != `"one"` {
+		t.Errorf("unexpected Get %q %v", v, err)
+	}
+	if v, err := bl.Get("articles", "a"); err != nil || v != nil {
+		t.Errorf("expected no record for a prefix, got %q %v", v, err)
+	}
+	if v, err := bl.GetOne("articles", "a"); err != nil || string(v) != `"one"` {
+		t.Errorf("unexpected GetOne %q %v", v, err)
+	}
+	if res, err := bl.GetByPrefix("articles", "a"); err != nil || len(res) != 2 || string(res["a2"]) != `"two"` {
+		t.Errorf("unexpected GetByPrefix %v %v", res, err)
+	}
+	if keys, err := bl.GetKeyList("articles", ""); err != nil || len(keys) != 3 || keys[2] != "b1" {
+		t.Errorf("unexpected GetKeyList %v %v", keys, err)
+	}
+	if err = bl.Delete("articles", "a2"); err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+	if n, err := bl.Count("articles"); err != nil || n != 2 {
+		t.Errorf("unexpected Count %d %v", n, err)
+	}
+	if names, err := bl.Buckets(); err != nil || len(names) != 1 || names[0] != "articles" {
+		t.Errorf("unexpected Buckets %v %v", names, err)
+	}
+
+	// values are stored as BoltLocknut stores them
+	err = bl.db.View(func(txn *gobadger.Txn) error {
+		item, err := txn.Get(recordKey("articles", "a1"))
+		if err != nil {
+			return err
+		}
+		stored, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		plain, err := locknut.Decrypt(stored, locknut.DeriveKey([]byte("secret")))
+		if err != nil || string(plain) != `"one"` {
+			t.Errorf("unexpected stored value %q %v", plain, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View: %s", err)
+	}
+
+	if err = bl.SaveWithTTL("articles", "c1", "soon gone", time.Hour); err != nil {
+		t.Fatalf("SaveWithTTL: %s", err)
+	}
+	err = bl.db.View(func(txn *gobadger.Txn) error {
+		item, err := txn.Get(recordKey("articles", "c1"))
+		if err != nil {
+			return err
+		}
+		if item.ExpiresAt() == 0 {
+			t.Errorf("record saved with a ttl does not expire")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View: %s", err)
+	}
+	bl.Close()
+
+	if _, err = NewBadgerLocknut("badger_test", []byte("other secret"), nil); err != locknut.ErrWrongSecret {
+		t.Errorf("expected ErrWrongSecret, got %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+package locknut
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+	"unicode/utf8"
+)
+
+// BinaryKeys is a handle on a BoltLocknut taking and returning keys as bytes, for identifiers such
+// as UUID bytes and hashes. It is returned by Binary. Keys are stored as they are, a key saved here is
+// the string(key) of the string API. Exports, imports and the changelog keep keys that are not valid
+// UTF-8 in key_raw, base64 encoded, since json would replace their invalid bytes. The gRPC server
+// only carries UTF-8 keys.
+type BinaryKeys struct {
+	bl *BoltLocknut
+}
+
+// BinaryRecord is a record read by BinaryKeys.GetByPrefix
+type BinaryRecord struct {
+	Key   []byte
+	Value []byte
+}
+
+// Binary returns a handle on bl taking keys as bytes
+func (bl *BoltLocknut) Binary() *BinaryKeys {
+	return &BinaryKeys{bl: bl}
+}
+
+// Save is BoltLocknut.Save with key as bytes
+func (b *BinaryKeys) Save(bucket string, key []byte, data interface{}) error {
+	return b.bl.Save(bucket, string(key), data)
+}
+
+// SaveBytes is BoltLocknut.SaveBytes with key as bytes
+func (b *BinaryKeys) SaveBytes(bucket string, key []byte, data []byte) error {
+	return b.bl.SaveBytes(bucket, string(key), data)
+}
+
+// Get is BoltLocknut.Get with key as bytes
+func (b *BinaryKeys) Get(bucket string, key []byte) ([]byte, error) {
+	return b.bl.Get(bucket, string(key))
+}
+
+// GetOne is BoltLocknut.GetOne with prefix as bytes
+func (b *BinaryKeys) GetOne(bucket string, prefix []byte) ([]byte, error) {
+	return b.bl.GetOne(bucket, string(prefix))
+}
+
+// GetByPrefix is BoltLocknut.GetByPrefix with keys as bytes, returning the records in key order
+func (b *BinaryKeys) GetByPrefix(bucket string, prefix []byte) ([]BinaryRecord, error) {
+	results, err := b.bl.GetByPrefix(bucket, string(prefix))
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(results))
+	for k := range results {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	records := make([]BinaryRecord, 0, len(keys))
+	for _, k := range keys {
+		records = append(records, BinaryRecord{Key: []byte(k), Value: results[k]})
+	}
+	return records, nil
+}
+
+// GetKeyList is BoltLocknut.GetKeyList with keys as bytes
+func (b *BinaryKeys) GetKeyList(bucket string, prefix []byte) ([][]byte, error) {
+	keys, err := b.bl.GetKeyList(bucket, string(prefix))
+	if err != nil {
+		return nil, err
+	}
+	list := make([][]byte, len(keys))
+	for i, k := range keys {
+		list[i] = []byte(k)
+	}
+	return list, nil
+}
+
+// Delete is BoltLocknut.Delete with key as bytes
+func (b *BinaryKeys) Delete(bucket string, key []byte) error {
+	return b.bl.Delete(bucket, string(key))
+}
+
+// jsonKey returns key as json carries it: as is when it is valid UTF-8, otherwise as raw bytes
+func jsonKey(key string) (string, []byte) {
+	if utf8.ValidString(key) {
+		return key, nil
+	}
+	return "", []byte(key)
+}
+
+// MarshalJSON writes a key that is not valid UTF-8 to key_raw
+func (r Record) MarshalJSON() ([]byte, error) {
+	key, raw := jsonKey(r.Key)
+	return json.Marshal(struct {
+		Bucket string          `json:"bucket,omitempty"`
+		Key    string          `json:"key"`
+		KeyRaw []byte          `json:"key_raw,omitempty"`
+		Value  json.RawMessage `json:"value,omitempty"`
+		Raw    []byte          `json:"raw,omitempty"`
+	}{r.Bucket, key, raw, r.Value, r.Raw})
+}
+
+// UnmarshalJSON reads the key from key_raw when it is set
+func (r *Record) UnmarshalJSON(b []byte) error {
+	type record Record
+	aux := struct {
+		*record
+		KeyRaw []byte `json:"key_raw"`
+	}{record: (*record)(r)}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+	if aux.KeyRaw != nil {
+		r.Key = string(aux.KeyRaw)
+	}
+	return nil
+}
+
+// MarshalJSON writes a key that is not valid UTF-8 to key_raw
+func (c Change) MarshalJSON() ([]byte, error) {
+	key, raw := jsonKey(c.Key)
+	return json.Marshal(struct {
+		Seq    uint64    `json:"seq"`
+		Op     string    `json:"op"`
+		Bucket string    `json:"bucket"`
+		Key    string    `json:"key"`
+		KeyRaw []byte    `json:"key_raw,omitempty"`
+		Value  []byte    `json:"value,omitempty"`
+		Time   time.Time `json:"time"`
+	}{c.Seq, c.Op, c.Bucket, key, raw, c.Value, c.Time})
+}
+
+// UnmarshalJSON reads the key from key_raw when it is set
+func (c *Change) UnmarshalJSON(b []byte) error {
+	type change Change
+	aux := struct {
+		*change
+		KeyRaw []byte `json:"key_raw"`
+	}{change: (*change)(c)}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+	if aux.KeyRaw != nil {
+		c.Key = string(aux.KeyRaw)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package locknut
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestBinaryKeys(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("binarykeys_test.db")
+	defer os.Remove("binarykeys_import_test.db")
+
+	bl, err := NewBoltLocknut("binarykeys_test.db", ".", []byte("secret"), false, []string{"blobs"}, WithChangelog())
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	b := bl.Binary()
+	ids := [][]byte{{0xff, 0x00, 0x01}, {0xff, 0x00, 0x02}, {0xfe, 0x80}}
+	for i, id := range ids {
+		if err = b.SaveBytes("blobs", id, []byte{byte(i)}); err != nil {
+			t.Fatalf("SaveBytes: %s", err)
+		}
+	}
+
+	if v, err := b.Get("blobs", ids[2]); err != nil || !bytes.Equal(v, []byte{2}) {
+		t.Errorf("unexpected value %v %v", v, err)
+	}
+	records, err := b.GetByPrefix("blobs", []byte{0xff, 0x00})
+	if err != nil || len(records) != 2 || !bytes.Equal(records[0].Key, ids[0]) || !bytes.Equal(records[1].Key, ids[1]) {
+		t.Errorf("expected the records of the prefix in order, got %v %v", records, err)
+	}
+	keys, err := b.GetKeyList("blobs", nil)
+	if err != nil || len(keys) != 3 || !bytes.Equal(keys[0], ids[2]) {
+		t.Errorf("unexpected keys %v %v", keys, err)
+	}
+	if changes, err := bl.Changes(0, 1); err != nil || len(changes) != 1 || changes[0].Key != string(ids[0]) {
+		t.Errorf("expected the key intact in the changelog, got %+v %v", changes, err)
+	}
+
+	// keys that are not UTF-8 survive an export and import
+	var out bytes.Buffer
+	if err = bl.Export(&out, FormatJSONL, "blobs"); err != nil {
+		t.Fatalf("Export: %s", err)
+	}
+	if !strings.Contains(out.String(), `"key":"","key_raw":"/wAB"`) {
+		t.Errorf("expected raw keys in the export, got %s", out.String())
+	}
+	imported, err := NewBoltLocknut("binarykeys_import_test.db", ".", []byte("secret"), false, []string{"blobs"})
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if err = imported.Import(&out, FormatJSONL, ""); err != nil {
+		t.Fatalf("Import: %s", err)
+	}
+	if v, err := imported.Binary().Get("blobs", ids[1]); err != nil || !bytes.Equal(v, []byte{1}) {
+		t.Errorf("unexpected imported value %v %v", v, err)
+	}
+
+	if err = b.Delete("blobs", ids[0]); err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+	if v, err := b.GetOne("blobs", []byte{0xff}); err != nil || !bytes.Equal(v, []byte{1}) {
+		t.Errorf("expected the other record of the prefix, got %v %v", v, err)
+	}
+}
@@ -0,0 +1,344 @@
+package locknut
+
+import (
+	"encoding/binary"
+	"go.etcd.io/bbolt"
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+)
+
+// bloomBucket holds the bloom filters set WithBloomFilter, by record bucket. A filter is saved with the
+// id of the transaction it was saved in, so a filter the db was written to since is rebuilt.
+var bloomBucket = []byte(internalPrefix + "bloom")
+
+// bloomHeader is the size of the header of a saved filter: the transaction id, the capacity and the
+// number of hashes
+const bloomHeader = 8 + 8 + 4
+
+// bloomFilter tells the keys a bucket surely does not hold. It reflects the bucket as of the
+// transaction txid: it is built or loaded when the db is opened, follows the writes of the handle, and
+// is rebuilt by a read of a later transaction when the db was written to without it.
+type bloomFilter struct {
+	mu       sync.RWMutex
+	expected int
+	fpRate   float64
+
+	dirty    bool // not saved since it was built or changed
+	txid     int
+	gen      int // counts the builds, see bloomAdd
+	capacity int
+	changes  int // keys added and deleted since it was built
+	k        uint32
+	bits     []uint64 // nil once worn out
+
+	// addTx is the last transaction that added keys, and addGen the build they were added to, -1 when
+	// the filter was not up to date for it
+	addTx  int
+	addGen int
+}
+
+// WithBloomFilter keeps a bloom filter of the keys of bucket, sized for expected keys with a false
+// positive rate of fpRate, so Exists and Get answer most lookups of missing keys without searching
+// the bucket. GetOne looks keys up by prefix, which the filter cannot answer. Filters are loaded when
+// the db is opened, saved by Close, and rebuilt from the keys when missing or when the db was written
+// to by another handle since, and when deletes and inserts have worn them out.
+func WithBloomFilter(bucket string, expected int, fpRate float64) Option {
+	return func(bl *BoltLocknut) {
+		if expected <= 0 {
+			expected = 1000
+		}
+		if fpRate <= 0 || fpRate >= 1 {
+			fpRate = 0.01
+		}
+		if bl.blooms == nil {
+			bl.blooms = make(map[string]*bloomFilter)
+		}
+		bl.blooms[bucket] = &bloomFilter{expected: expected, fpRate: fpRate}
+	}
+}
+
+// bloomHashes returns the two hashes combined into the positions of key
+func bloomHashes(key []byte) (uint64, uint64) {
+	h := fnv.New128a()
+	h.Write(key)
+	sum := h.Sum(nil)
+	return binary.BigEndian.Uint64(sum[:8]), binary.BigEndian.Uint64(sum[8:]) | 1
+}
+
+func (f *bloomFilter) add(key []byte) {
+	h1, h2 := bloomHashes(key)
+	m := uint64(len(f.bits) * 64)
+	for i := uint64(0); i < uint64(f.k); i++ {
+		pos := (h1 + i*h2) % m
+		f.bits[pos/64] |= 1 << (pos % 64)
+	}
+}
+
+func (f *bloomFilter) has(key []byte) bool {
+	h1, h2 := bloomHashes(key)
+	m := uint64(len(f.bits) * 64)
+	for i := uint64(0); i < uint64(f.k); i++ {
+		pos := (h1 + i*h2) % m
+		if f.bits[pos/64]&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// reset sizes the filter for capacity keys, empty
+func (f *bloomFilter) reset(capacity int) {
+	m := math.Ceil(-float64(capacity) * math.Log(f.fpRate) / (math.Ln2 * math.Ln2))
+	f.capacity = capacity
+	f.k = uint32(math.Max(1, math.Round(m/float64(capacity)*math.Ln2)))
+	f.bits = make([]uint64, int(m+63)/64)
+	f.changes = 0
+}
+
+// load reads the filter of bucket saved as of tx, or builds it from the keys of the bucket
+func (f *bloomFilter) load(tx *bbolt.Tx, bucket string) {
+	f.txid = tx.ID()
+	f.gen++
+	if root := tx.Bucket(bloomBucket); root != nil {
+		v := root.Get([]byte(bucket))
+		if len(v) > bloomHeader && (len(v)-bloomHeader)%8 == 0 && int(binary.BigEndian.Uint64(v)) == tx.ID() {
+			f.capacity = int(binary.BigEndian.Uint64(v[8:]))
+			f.k = binary.BigEndian.Uint32(v[16:])
+			f.bits = make([]uint64, (len(v)-bloomHeader)/8)
+			for i := range f.bits {
+				f.bits[i] = binary.BigEndian.Uint64(v[bloomHeader+i*8:])
+			}
+			f.changes, f.dirty = 0, false
+			return
+		}
+	}
+
+	var keys [][]byte
+	if bkt := tx.Bucket([]byte(bucket)); bkt != nil {
+		c := bkt.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if v != nil {
+				keys = append(keys, k)
+			}
+		}
+	}
+	capacity := f.expected
+	if 2*len(keys) > capacity {
+		capacity = 2 * len(keys)
+	}
+	f.reset(capacity)
+	for _, k := range keys {
+		f.add(k)
+	}
+	f.dirty = true
+}
+
+// loadBloomFilters builds or loads every filter that is not up to date as of the last transaction
+// of db, for open
+func (bl *BoltLocknut) loadBloomFilters(db *boltDB) error {
+	if len(bl.blooms) == 0 {
+		return nil
+	}
+	return db.view(func(tx *bbolt.Tx) error {
+		for bucket, f := range bl.blooms {
+			f.mu.Lock()
+			if f.bits == nil || f.txid != tx.ID() {
+				f.load(tx, bucket)
+			}
+			f.mu.Unlock()
+		}
+		return nil
+	})
+}
+
+// encode returns the filter as it is saved by the transaction txid
+func (f *bloomFilter) encode(txid int) []byte {
+	v := make([]byte, bloomHeader+8*len(f.bits))
+	binary.BigEndian.PutUint64(v, uint64(txid))
+	binary.BigEndian.PutUint64(v[8:], uint64(f.capacity))
+	binary.BigEndian.PutUint32(v[16:], f.k)
+	for i, b := range f.bits {
+		binary.BigEndian.PutUint64(v[bloomHeader+i*8:], b)
+	}
+	return v
+}
+
+// bloomMayHave reports whether bucket may hold key as of tx, true for buckets without a filter. A
+// filter behind tx, the db having been written to without it, is rebuilt; reads older than the filter
+// do without it.
+func (bl *BoltLocknut) bloomMayHave(tx *bbolt.Tx, bucket string, key []byte) bool {
+	f := bl.blooms[bucket]
+	if f == nil {
+		return true
+	}
+	f.mu.RLock()
+	if f.bits != nil && f.txid == tx.ID() {
+		has := f.has(key)
+		f.mu.RUnlock()
+		bl.observeCache("bloom", !has)
+		return has
+	}
+	f.mu.RUnlock()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if tx.ID() < f.txid {
+		return true
+	}
+	if f.bits == nil || f.txid != tx.ID() {
+		f.load(tx, bucket)
+	}
+	has := f.has(key)
+	bl.observeCache("bloom", !has)
+	return has
+}
+
+// bloomAdd adds a key saved by w to the filter of its bucket. Should w fail, the filter only holds one
+// more key than the bucket, which it tolerates. A filter not up to date for w misses the key, it is
+// kept from moving to w's transaction, see bloomCommitted.
+func (w *writeTx) bloomAdd(bucket, key string) {
+	f := w.bl.blooms[bucket]
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.addTx = w.tx.ID()
+	if f.bits == nil || f.txid != w.tx.ID()-1 {
+		f.addGen = -1
+		return
+	}
+	f.addGen = f.gen
+	f.add([]byte(key))
+	f.changed()
+}
+
+// bloomDelete counts a key deleted by w, a filter cannot forget keys but is rebuilt once worn out
+func (w *writeTx) bloomDelete(bucket string) {
+	f := w.bl.blooms[bucket]
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.bits != nil {
+		f.changed()
+	}
+}
+
+// changed counts a key added or deleted, wearing the filter out after capacity of them
+func (f *bloomFilter) changed() {
+	f.dirty = true
+	if f.changes++; f.changes > f.capacity {
+		f.bits = nil
+	}
+}
+
+// bloomCommitted moves the filters up to date before the transaction txid to txid, unless it added
+// keys they do not hold: the filter was rebuilt since the keys were added, or was not up to date then.
+// The filters left behind are rebuilt by the next read.
+func (bl *BoltLocknut) bloomCommitted(txid int) {
+	for _, f := range bl.blooms {
+		f.mu.Lock()
+		if f.txid == txid-1 && (f.addTx != txid || f.addGen == f.gen) {
+			f.txid = txid
+		}
+		f.mu.Unlock()
+	}
+}
+
+// updateQuiet runs fn, which adds no records, in an update transaction of db, keeping the bloom
+// filters up to date through it
+func (bl *BoltLocknut) updateQuiet(db *boltDB, fn func(*bbolt.Tx) error) error {
+	txid := 0
+	err := db.update(func(tx *bbolt.Tx) error {
+		txid = tx.ID()
+		return fn(tx)
+	})
+	if err == nil {
+		bl.bloomCommitted(txid)
+	}
+	return err
+}
+
+// saveBloomFilters saves the filters changed since they were loaded and still up to date
+func (bl *BoltLocknut) saveBloomFilters() error {
+	if bl.readOnly {
+		return nil
+	}
+	dirty := false
+	for _, f := range bl.blooms {
+		f.mu.RLock()
+		dirty = dirty || (f.bits != nil && f.dirty)
+		f.mu.RUnlock()
+	}
+	if !dirty {
+		return nil
+	}
+	if err := bl.openDB(); err != nil {
+		return err
+	}
+	defer bl.closeDB()
+
+	var saved []*bloomFilter
+	txid := 0
+	err := bl.db.update(func(tx *bbolt.Tx) error {
+		saved, txid = saved[:0], tx.ID()
+		for bucket, f := range bl.blooms {
+			f.mu.RLock()
+			current := f.bits != nil && f.dirty && f.txid == tx.ID()-1
+			var v []byte
+			if current {
+				v = f.encode(tx.ID())
+			}
+			f.mu.RUnlock()
+			if !current {
+				continue
+			}
+			root, err := tx.CreateBucketIfNotExists(bloomBucket)
+			if err != nil {
+				return err
+			}
+			if err = root.Put([]byte(bucket), v); err != nil {
+				return err
+			}
+			saved = append(saved, f)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, f := range saved {
+		f.mu.Lock()
+		f.dirty = false
+		f.mu.Unlock()
+	}
+	bl.bloomCommitted(txid)
+	return nil
+}
+
+// Exists reports whether a record is stored under exactly key, without reading or decrypting it
+func (bl *BoltLocknut) Exists(bucket, key string) (exists bool, err error) {
+	defer bl.observe("exists", time.Now(), &err)
+	if err = bl.openDB(); err != nil {
+		return false, err
+	}
+	defer bl.closeDB()
+
+	err = bl.db.view(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket([]byte(bucket))
+		if bkt == nil {
+			return bucketNotFound("exists", bucket)
+		}
+		if !bl.bloomMayHave(tx, bucket, []byte(key)) {
+			return nil
+		}
+		expired := bl.expiredIn(tx, bucket)
+		exists = bkt.Get([]byte(key)) != nil && (expired == nil || !expired([]byte(key)))
+		return nil
+	})
+	return exists, err
+}
@@ -0,0 +1,158 @@
+package locknut
+
+import (
+	"fmt"
+	"go.etcd.io/bbolt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestBloomFilter(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("bloom_test.db")
+
+	open := func(opts ...Option) *BoltLocknut {
+		t.Helper()
+		bl, err := NewBoltLocknut("bloom_test.db", ".", []byte("secret"), false, []string{"users"}, opts...)
+		if err != nil {
+			t.Fatalf("NewBoltLocknut: %s", err)
+		}
+		return bl
+	}
+
+	bl := open(WithBloomFilter("users", 100, 0.01))
+	for i := 0; i < 50; i++ {
+		if err := bl.Save("users", fmt.Sprintf("user%d", i), i); err != nil {
+			t.Fatalf("Save: %s", err)
+		}
+	}
+	falsePositives := 0
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("user%d", i)
+		exists, err := bl.Exists("users", key)
+		if err != nil {
+			t.Fatalf("Exists: %s", err)
+		}
+		if exists != (i < 50) {
+			t.Errorf("%s: expected exists %v", key, i < 50)
+		}
+		if i >= 50 && bl.blooms["users"].has([]byte(key)) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 50 {
+		t.Errorf("expected about 1%% false positives, got %d in 950", falsePositives)
+	}
+	if v, err := bl.Get("users", "nobody"); err != nil || v != nil {
+		t.Errorf("expected nothing, got %s %v", v, err)
+	}
+
+	// keys saved after the filter was built are in it, deleted ones are not found
+	if err := bl.Save("users", "late", 1); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if err := bl.Delete("users", "user0"); err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+	if exists, err := bl.Exists("users", "late"); err != nil || !exists {
+		t.Errorf("expected late to exist, got %v %v", exists, err)
+	}
+	if exists, err := bl.Exists("users", "user0"); err != nil || exists {
+		t.Errorf("expected user0 to be gone, got %v %v", exists, err)
+	}
+	if err := bl.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+
+	// the saved filter is used as is by the next handle
+	bl = open(WithBloomFilter("users", 100, 0.01))
+	if exists, err := bl.Exists("users", "late"); err != nil || !exists {
+		t.Errorf("expected late to exist, got %v %v", exists, err)
+	}
+	if f := bl.blooms["users"]; f.dirty || f.capacity != 100 {
+		t.Errorf("expected the saved filter to be loaded, got dirty %v capacity %d", f.dirty, f.capacity)
+	}
+
+	// writes of other handles make the filter rebuild
+	other := open()
+	if err := other.Save("users", "elsewhere", 1); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if exists, err := bl.Exists("users", "elsewhere"); err != nil || !exists {
+		t.Errorf("expected elsewhere to exist, got %v %v", exists, err)
+	}
+	if v, err := bl.Get("users", "elsewhere"); err != nil || string(v) != "1" {
+		t.Errorf("unexpected value %s %v", v, err)
+	}
+
+	// worn out filters are rebuilt, sized for the keys
+	for i := 0; i < 120; i++ {
+		if err := bl.Save("users", fmt.Sprintf("more%d", i), i); err != nil {
+			t.Fatalf("Save: %s", err)
+		}
+	}
+	if exists, err := bl.Exists("users", "more119"); err != nil || !exists {
+		t.Errorf("expected more119 to exist, got %v %v", exists, err)
+	}
+	// by the open following the write that wore it out, for twice the keys the bucket had then
+	if c := bl.blooms["users"].capacity; c < 2*(49+2+100) || c > 2*(49+2+120) {
+		t.Errorf("expected the filter rebuilt for twice the keys, got a capacity of %d", c)
+	}
+}
+
+func TestBloomFilterWritePaths(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("bloom_paths_test.db")
+
+	// in batch mode the db stays open, so the filters follow the writes rather than the opens
+	bl, err := NewBoltLocknut("bloom_paths_test.db", ".", []byte("secret"), true, []string{"users", "next"},
+		WithBloomFilter("users", 100, 0.01), WithBloomFilter("next", 100, 0.01))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	defer bl.Close()
+	mayHave := func(bucket string, keys ...string) {
+		t.Helper()
+		err := bl.db.view(func(tx *bbolt.Tx) error {
+			for _, key := range keys {
+				if !bl.bloomMayHave(tx, bucket, []byte(key)) {
+					t.Errorf("%s/%s: the filter misses the key", bucket, key)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("view: %s", err)
+		}
+	}
+
+	// Undelete
+	if err = bl.Save("users", "undeleted", 1); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if err = bl.SoftDelete("users", "undeleted"); err != nil {
+		t.Fatalf("SoftDelete: %s", err)
+	}
+	if err = bl.Undelete("users", "undeleted"); err != nil {
+		t.Fatalf("Undelete: %s", err)
+	}
+	mayHave("users", "undeleted")
+
+	// the promotion of ImportStaged
+	in := `{"key":"imported","value":1}` + "\n"
+	if _, err = bl.ImportStaged(strings.NewReader(in), FormatJSONL, StagingOptions{Bucket: "users"}); err != nil {
+		t.Fatalf("ImportStaged: %s", err)
+	}
+	mayHave("users", "imported")
+
+	// replaceBucket, through SwapBuckets
+	if err = bl.Save("next", "swapped", 1); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if err = bl.SwapBuckets("users", "next"); err != nil {
+		t.Fatalf("SwapBuckets: %s", err)
+	}
+	mayHave("users", "swapped")
+	mayHave("next", "imported")
+}
@@ -0,0 +1,85 @@
+package locknut
+
+import (
+	"errors"
+	"go.etcd.io/bbolt"
+)
+
+// ErrNoBucketKeys is returned by BucketKey for a db whose records are not encrypted with bucket keys
+var ErrNoBucketKeys = errors.New("records are not encrypted with bucket keys")
+
+// bucketKeysKey is the meta record marking a db whose records are encrypted with bucket keys
+var bucketKeysKey = []byte("bucket_keys")
+
+const bucketKeyInfo = "locknut bucket key\x00"
+
+// WithBucketKeys encrypts the records of every bucket with a key of their own, derived from the secret
+// with HKDF and the name of the bucket, so a bucket's key only reads that bucket and can be handed to
+// a consumer of it, see BucketKey. The choice is recorded in the db, which uses bucket keys from then
+// on whether the option is given or not. Records written before keep their key and read as they are
+// until RotateKey or the Optimizer rewrites them. Replicas and delta sync peers must use bucket keys
+// too, they receive records as they are stored. It has no effect WithPublicKey.
+func WithBucketKeys() Option {
+	return func(bl *BoltLocknut) {
+		bl.bucketKeys = true
+	}
+}
+
+// bucketKey derives the key of the records of bucket from master
+func bucketKey(master []byte, bucket string) []byte {
+	return HKDF(master, nil, []byte(bucketKeyInfo+bucket), 32)
+}
+
+// keyFor returns the key encrypting the records of bucket
+func (bl *BoltLocknut) keyFor(bucket string) []byte {
+	if !bl.bucketKeys {
+		return bl.secret
+	}
+	return bucketKey(bl.secret, bucket)
+}
+
+// openCiphertext decrypts ct, a record of bucket or an encrypted field of one, and reports whether it
+// was encrypted with the secret itself, by a write from before the db used bucket keys
+func (bl *BoltLocknut) openCiphertext(bucket string, ct []byte) (plain []byte, legacy bool, err error) {
+	plain, err = Decrypt(ct, bl.keyFor(bucket))
+	if err != nil && bl.bucketKeys && !errors.Is(err, ErrCiphertextTooShort) {
+		if legacyPlain, legacyErr := Decrypt(ct, bl.secret); legacyErr == nil {
+			return legacyPlain, true, nil
+		}
+	}
+	return plain, false, err
+}
+
+// checkBucketKeys turns bucket keys on for a db recorded to use them, and records a db given
+// WithBucketKeys as using them unless tx is read only
+func (bl *BoltLocknut) checkBucketKeys(tx *bbolt.Tx) error {
+	if bl.secret == nil || bl.public != nil {
+		return nil
+	}
+	if meta := tx.Bucket(metaBucket); meta != nil && meta.Get(bucketKeysKey) != nil {
+		bl.bucketKeys = true
+		return nil
+	}
+	if !bl.bucketKeys || !tx.Writable() {
+		return nil
+	}
+	meta, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return err
+	}
+	return meta.Put(bucketKeysKey, []byte{1})
+}
+
+// BucketKey returns the key of the records of bucket, for a consumer that should read that bucket and
+// no other. Records of buckets without padding or transformers, saved without encrypted fields,
+// decrypt with Decrypt and it. It fails with ErrNoBucketKeys for a db not using WithBucketKeys.
+func (bl *BoltLocknut) BucketKey(bucket string) ([]byte, error) {
+	if err := bl.hold(); err != nil {
+		return nil, err
+	}
+	defer bl.release()
+	if !bl.bucketKeys || bl.secret == nil || bl.public != nil {
+		return nil, ErrNoBucketKeys
+	}
+	return bl.keyFor(bucket), nil
+}
@@ -0,0 +1,75 @@
+package locknut
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestBucketKeys(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("bucketkeys_test.db")
+
+	open := func(opts ...Option) *BoltLocknut {
+		t.Helper()
+		bl, err := NewBoltLocknut("bucketkeys_test.db", ".", []byte("secret"), false, []string{"users", "admins"}, opts...)
+		if err != nil {
+			t.Fatalf("NewBoltLocknut: %s", err)
+		}
+		return bl
+	}
+
+	bl := open()
+	if err := bl.Save("users", "legacy", "old"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if _, err := bl.BucketKey("users"); !errors.Is(err, ErrNoBucketKeys) {
+		t.Errorf("expected ErrNoBucketKeys, got %v", err)
+	}
+	bl.Close()
+
+	bl = open(WithBucketKeys())
+	if err := bl.Save("users", "ada", "user"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if err := bl.Save("admins", "root", "admin"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	users, err := bl.BucketKey("users")
+	if err != nil {
+		t.Fatalf("BucketKey: %s", err)
+	}
+	// the key of a bucket reads its records and no others
+	if plain, err := Decrypt(storedValue(t, bl, "users", "ada"), users); err != nil || string(plain) != `"user"` {
+		t.Errorf("expected the bucket key to decrypt its records, got %s %v", plain, err)
+	}
+	if _, err = Decrypt(storedValue(t, bl, "admins", "root"), users); err == nil {
+		t.Errorf("expected the key of users not to decrypt admins")
+	}
+	if _, err = Decrypt(storedValue(t, bl, "users", "ada"), DeriveKey([]byte("secret"))); err == nil {
+		t.Errorf("expected records not to be encrypted with the secret anymore")
+	}
+	if v, err := bl.Get("users", "legacy"); err != nil || string(v) != `"old"` {
+		t.Errorf("expected records written before to read, got %s %v", v, err)
+	}
+	bl.Close()
+
+	// the db keeps using bucket keys without the option
+	bl = open()
+	if v, err := bl.Get("admins", "root"); err != nil || string(v) != `"admin"` {
+		t.Errorf("unexpected value %s %v", v, err)
+	}
+	if err = bl.RotateKey([]byte("new secret")); err != nil {
+		t.Fatalf("RotateKey: %s", err)
+	}
+	users, err = bl.BucketKey("users")
+	if err != nil {
+		t.Fatalf("BucketKey: %s", err)
+	}
+	if plain, err := Decrypt(storedValue(t, bl, "users", "legacy"), users); err != nil || string(plain) != `"old"` {
+		t.Errorf("expected the rotation to move records written before to the bucket key, got %s %v", plain, err)
+	}
+	if v, err := bl.Get("users", "ada"); err != nil || string(v) != `"user"` {
+		t.Errorf("unexpected value %s %v", v, err)
+	}
+}
@@ -0,0 +1,121 @@
+package locknut
+
+import (
+	"bufio"
+	"context"
+	"go.etcd.io/bbolt"
+	"io"
+	"os"
+)
+
+// bulkBufferSize is the size of the buffer ExportBulk writes through
+const bulkBufferSize = 1 << 20
+
+// ExportBulk is Export for huge one shot exports. Rather than going through the handle of bl, it maps
+// the whole db file read only, prefaulted where the OS allows it, and streams every bucket from a single
+// read transaction, so the export is a consistent snapshot. Values go from the map to the decryption,
+// or straight to w for a db without a secret, without the per record copies of the normal read path.
+// While bl holds the file open, in batch mode, the export reads through that handle instead, since the
+// file lock would keep a second open waiting.
+func (bl *BoltLocknut) ExportBulk(w io.Writer, format Format, buckets ...string) error {
+	if err := bl.hold(); err != nil {
+		return err
+	}
+	defer bl.release()
+	db, release, err := bl.bulkDB()
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriterSize(w, bulkBufferSize)
+	write, finish, err := recordWriter(bw, format)
+	if err != nil {
+		release()
+		return err
+	}
+
+	err = db.View(func(tx *bbolt.Tx) error {
+		if len(buckets) == 0 {
+			err := tx.ForEach(func(name []byte, _ *bbolt.Bucket) error {
+				if !isInternalBucket(name) {
+					buckets = append(buckets, string(name))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		for _, bucket := range buckets {
+			bkt := tx.Bucket([]byte(bucket))
+			if bkt == nil {
+				return bucketNotFound("export", bucket)
+			}
+			err := bkt.ForEach(func(k, v []byte) error {
+				if v == nil {
+					return nil
+				}
+				dec, err := bl.bulkValue(bucket, k, v)
+				if err != nil {
+					return err
+				}
+				return write(newRecord(bucket, string(k), dec))
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	release()
+	if err != nil {
+		return err
+	}
+
+	for _, bucket := range buckets {
+		if err = bl.auditRead(context.Background(), "export", bucket, ""); err != nil {
+			return err
+		}
+	}
+	if err = finish(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// bulkDB returns the db ExportBulk reads, and the function to call once done with it
+func (bl *BoltLocknut) bulkDB() (*bbolt.DB, func(), error) {
+	bl.openMu.Lock()
+	if bl.db != nil {
+		bl.opens++
+		db := bl.db.DB
+		bl.openMu.Unlock()
+		return db, bl.closeDB, nil
+	}
+	bl.openMu.Unlock()
+
+	info, err := os.Stat(bl.fullPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	options := bl.boltOptions
+	options.ReadOnly = true
+	options.MmapFlags = bulkMmapFlags
+	if int64(options.InitialMmapSize) < info.Size() {
+		options.InitialMmapSize = int(info.Size())
+	}
+	db, err := bbolt.Open(bl.fullPath, bl.fileMode, &options)
+	if err != nil {
+		return nil, nil, err
+	}
+	return db, func() { db.Close() }, nil
+}
+
+// bulkValue is decrypt for ExportBulk: the value returned may share memory with stored, and is only
+// valid until the transaction ends
+func (bl *BoltLocknut) bulkValue(bucket string, key, stored []byte) ([]byte, error) {
+	if bl.secret == nil && bl.public == nil {
+		return stored, nil
+	}
+	return bl.decrypt(bucket, string(key), stored)
+}
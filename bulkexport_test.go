@@ -0,0 +1,76 @@
+package locknut
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestExportBulk(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("bulkexport_test.db")
+
+	bl, err := NewBoltLocknut("bulkexport_test.db", ".", []byte("secret"), false, []string{"article", "blobs"}, WithChangelog())
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	for i := 0; i < 100; i++ {
+		if err = bl.Save("article", fmt.Sprintf("ID-%04d", i), Article{ID: fmt.Sprint(i), Title: "bulk"}); err != nil {
+			t.Fatalf("Save: %s", err)
+		}
+	}
+	if err = bl.SaveBytes("blobs", "b", []byte{0xff, 0x00}); err != nil {
+		t.Fatalf("SaveBytes: %s", err)
+	}
+
+	// the output matches Export, from a read only map of the file or from the handle held in batch mode
+	for _, format := range []Format{FormatJSON, FormatJSONL, FormatCSV} {
+		var want, got bytes.Buffer
+		if err = bl.Export(&want, format); err != nil {
+			t.Fatalf("Export %s: %s", format, err)
+		}
+		if err = bl.ExportBulk(&got, format); err != nil {
+			t.Fatalf("ExportBulk %s: %s", format, err)
+		}
+		if got.String() != want.String() {
+			t.Errorf("%s: expected\n%s\ngot\n%s", format, want.String(), got.String())
+		}
+
+		bl.SetBatchMode(true)
+		got.Reset()
+		err = bl.ExportBulk(&got, format, "article", "blobs")
+		bl.SetBatchMode(false)
+		if err != nil {
+			t.Fatalf("ExportBulk in batch mode %s: %s", format, err)
+		}
+		if got.String() != want.String() {
+			t.Errorf("%s in batch mode: expected\n%s\ngot\n%s", format, want.String(), got.String())
+		}
+	}
+
+	var re *RecordError
+	if err = bl.ExportBulk(&bytes.Buffer{}, FormatJSONL, "missing"); !errors.As(err, &re) || re.Kind != ErrBucketNotFound {
+		t.Errorf("expected a missing bucket, got %v", err)
+	}
+}
+
+func TestExportBulkPlain(t *testing.T) {
+	defer os.Remove("bulkexport_plain_test.db")
+
+	bl, err := NewBoltLocknut("bulkexport_plain_test.db", ".", nil, false, []string{"notes"})
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if err = bl.Save("notes", "n", "plain"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	var buf bytes.Buffer
+	if err = bl.ExportBulk(&buf, FormatJSONL); err != nil {
+		t.Fatalf("ExportBulk: %s", err)
+	}
+	if buf.String() != `{"bucket":"notes","key":"n","value":"plain"}`+"\n" {
+		t.Errorf("unexpected export %q", buf.String())
+	}
+}
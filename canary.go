@@ -0,0 +1,107 @@
+package locknut
+
+import (
+	"bytes"
+	"go.etcd.io/bbolt"
+)
+
+// metaBucket holds records the package keeps about the db itself
+var metaBucket = []byte(internalPrefix + "meta")
+
+// canaryKey is the meta record holding canaryPlaintext encrypted with the secret
+var canaryKey = []byte("canary")
+
+var canaryPlaintext = []byte("locknut canary v1")
+
+// checkSecret verifies the secret against the canary record when the db is opened, so a wrong secret
+// fails with ErrWrongSecret at once rather than as a decrypt error on the first read. Dbs without a
+// canary, new ones or ones written by older versions, get one once the secret has decrypted a record,
+// unless tx is read only.
+func (bl *BoltLocknut) checkSecret(tx *bbolt.Tx) error {
+	// records sealed to a public key do not use the secret
+	if bl.public != nil {
+		return bl.checkRecipient(tx)
+	}
+	if bl.secret == nil {
+		return nil
+	}
+	return checkKey(tx, bl.secret)
+}
+
+// checkKey verifies key against the canary record, or the first records when there is none
+func checkKey(tx *bbolt.Tx, key []byte) error {
+	if meta := tx.Bucket(metaBucket); meta != nil {
+		if stored := meta.Get(canaryKey); stored != nil {
+			plain, err := Decrypt(append([]byte(nil), stored...), key)
+			if err != nil || !bytes.Equal(plain, canaryPlaintext) {
+				return ErrWrongSecret
+			}
+			return nil
+		}
+	}
+
+	// a db without a canary may still leave some buckets unencrypted, so the secret only has to
+	// decrypt the first record of one bucket
+	samples := firstRecords(tx)
+	for _, sample := range samples {
+		if _, err := Decrypt(append([]byte(nil), sample...), key); err == nil {
+			return saveCanary(tx, key)
+		}
+	}
+	if len(samples) > 0 {
+		return ErrWrongSecret
+	}
+	return saveCanary(tx, key)
+}
+
+// firstRecords returns the first value of every user bucket holding one
+func firstRecords(tx *bbolt.Tx) [][]byte {
+	var samples [][]byte
+	tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+		if isInternalBucket(name) {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if v != nil {
+				samples = append(samples, v)
+				break
+			}
+		}
+		return nil
+	})
+	return samples
+}
+
+// saveCanary stores the canary if tx can write
+func saveCanary(tx *bbolt.Tx, key []byte) error {
+	if !tx.Writable() {
+		return nil
+	}
+	return writeCanary(tx, key)
+}
+
+// writeCanary stores the canary encrypted with key
+func writeCanary(tx *bbolt.Tx, key []byte) error {
+	meta, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return err
+	}
+	stored, err := Encrypt(canaryPlaintext, key)
+	if err != nil {
+		return err
+	}
+	return meta.Put(canaryKey, stored)
+}
+
+// writeCanaryTo stores the canary for key in the db file at path
+func writeCanaryTo(path string, key []byte) error {
+	db, err := bbolt.Open(path, 0600, nil)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(func(tx *bbolt.Tx) error {
+		return writeCanary(tx, key)
+	})
+}
@@ -0,0 +1,48 @@
+package locknut
+
+import (
+	"go.etcd.io/bbolt"
+	"os"
+	"testing"
+)
+
+func TestWrongSecret(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("canary_test.db")
+
+	bl, err := NewBoltLocknut("canary_test.db", ".", []byte("secret"), false, []string{"pii"})
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if err = bl.Save("pii", "taylor", "value"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+
+	if _, err = NewBoltLocknut("canary_test.db", ".", []byte("other secret"), false, nil); err != ErrWrongSecret {
+		t.Errorf("expected ErrWrongSecret, got %v", err)
+	}
+	if _, err = NewBoltLocknut("canary_test.db", ".", []byte("secret"), false, nil); err != nil {
+		t.Errorf("NewBoltLocknut with the right secret: %s", err)
+	}
+
+	// a db written before the canary existed is checked against its records
+	if err = bl.openDB(); err != nil {
+		t.Fatalf("openDB: %s", err)
+	}
+	err = bl.db.update(func(tx *bbolt.Tx) error {
+		return tx.DeleteBucket(metaBucket)
+	})
+	bl.closeDB()
+	if err != nil {
+		t.Fatalf("update: %s", err)
+	}
+	if _, err = NewBoltLocknut("canary_test.db", ".", []byte("other secret"), false, nil); err != ErrWrongSecret {
+		t.Errorf("expected ErrWrongSecret without a canary, got %v", err)
+	}
+	if _, err = NewBoltLocknut("canary_test.db", ".", []byte("secret"), false, nil); err != nil {
+		t.Fatalf("NewBoltLocknut without a canary: %s", err)
+	}
+	if _, err = NewBoltLocknut("canary_test.db", ".", []byte("other secret"), false, nil); err != ErrWrongSecret {
+		t.Errorf("expected ErrWrongSecret once the canary was written, got %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+package locknut
+
+import (
+	"encoding/json"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"io"
+	"time"
+)
+
+// catalogBucket holds a BackupEntry per backup recorded by Backup, keyed by its ID
+var catalogBucket = []byte(internalPrefix + "backups")
+
+// dbIDKey is the meta record holding the ID of the db
+var dbIDKey = []byte("id")
+
+// BackupEntry describes a backup in the catalog
+type BackupEntry struct {
+	// ID numbers the entries of the catalog in the order the backups were taken
+	ID uint64 `json:"id"`
+	// Location is where the backup was written, as given in BackupOptions
+	Location string    `json:"location"`
+	Time     time.Time `json:"time"`
+	Size     int64     `json:"size"`
+	// DBID is the ID of the db the backup was taken from
+	DBID string `json:"db_id"`
+	// Seq is the changelog sequence number of the last change the backup holds
+	Seq       uint64 `json:"seq"`
+	Compacted bool   `json:"compacted,omitempty"`
+	Encrypted bool   `json:"encrypted,omitempty"`
+	// SHA256 and MD5 are the hex digests of the backup as it was written
+	SHA256 string `json:"sha256"`
+	MD5    string `json:"md5"`
+	// Verification is the latest check of the stored copy, nil until VerifyBackup or
+	// VerifyBackupETag ran
+	Verification *Verification `json:"verification,omitempty"`
+}
+
+// ID returns the UUID of the db, created the first time it is asked for. It is kept in the db file,
+// so backups and copies of the file share it.
+func (bl *BoltLocknut) ID() (string, error) {
+	var err error
+	if err = bl.openDB(); err != nil {
+		return "", err
+	}
+	defer bl.closeDB()
+
+	var id string
+	err = bl.db.view(func(tx *bbolt.Tx) error {
+		if meta := tx.Bucket(metaBucket); meta != nil {
+			id = string(meta.Get(dbIDKey))
+		}
+		return nil
+	})
+	if err != nil || id != "" || bl.readOnly {
+		return id, err
+	}
+
+	err = bl.write(func(w *writeTx) error {
+		meta, err := w.tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+		// another writer may have got there first
+		if stored := meta.Get(dbIDKey); stored != nil {
+			id = string(stored)
+			return nil
+		}
+		if id, err = newUUID(bl.randReader()); err != nil {
+			return err
+		}
+		return meta.Put(dbIDKey, []byte(id))
+	})
+	return id, err
+}
+
+// newUUID returns a random (version 4) UUID read from r
+func newUUID(r io.Reader) (string, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// recordBackup adds a backup to the catalog and returns its ID
+func (bl *BoltLocknut) recordBackup(entry BackupEntry) (uint64, error) {
+	err := bl.write(func(w *writeTx) error {
+		bkt, err := w.tx.CreateBucketIfNotExists(catalogBucket)
+		if err != nil {
+			return err
+		}
+		if entry.ID, err = bkt.NextSequence(); err != nil {
+			return err
+		}
+		v, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		return bkt.Put(seqKey(entry.ID), v)
+	})
+	return entry.ID, err
+}
+
+// ListBackups returns the backups in the catalog, newest first
+func (bl *BoltLocknut) ListBackups() ([]BackupEntry, error) {
+	var err error
+	if err = bl.openDB(); err != nil {
+		return nil, err
+	}
+	defer bl.closeDB()
+
+	entries := make([]BackupEntry, 0)
+	err = bl.db.view(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket(catalogBucket)
+		if bkt == nil {
+			return nil
+		}
+		c := bkt.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var e BackupEntry
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			entries = append(entries, e)
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// PrunePolicy selects the backups PruneBackups removes. The newest KeepLast backups and those younger
+// than MaxAge are kept, zero leaving out that rule, so the zero policy keeps everything.
+type PrunePolicy struct {
+	KeepLast int
+	MaxAge   time.Duration
+	// Remove, when set, deletes the backup itself, an entry whose Remove fails stays in the catalog
+	Remove func(BackupEntry) error
+}
+
+// PruneBackups removes the backups the policy does not keep from the catalog, calling policy.Remove
+// first for each, and returns the entries removed. It stops at the first error.
+func (bl *BoltLocknut) PruneBackups(policy PrunePolicy) ([]BackupEntry, error) {
+	pruned := make([]BackupEntry, 0)
+	if policy.KeepLast <= 0 && policy.MaxAge <= 0 {
+		return pruned, nil
+	}
+
+	entries, err := bl.ListBackups()
+	if err != nil {
+		return pruned, err
+	}
+	now := bl.now()
+	for i, e := range entries {
+		if i < policy.KeepLast || (policy.MaxAge > 0 && now.Sub(e.Time) <= policy.MaxAge) {
+			continue
+		}
+		if policy.Remove != nil {
+			if err = policy.Remove(e); err != nil {
+				return pruned, fmt.Errorf("removing backup %d at %s: %w", e.ID, e.Location, err)
+			}
+		}
+		err = bl.write(func(w *writeTx) error {
+			if bkt := w.tx.Bucket(catalogBucket); bkt != nil {
+				return bkt.Delete(seqKey(e.ID))
+			}
+			return nil
+		})
+		if err != nil {
+			return pruned, err
+		}
+		pruned = append(pruned, e)
+	}
+	return pruned, nil
+}
@@ -0,0 +1,74 @@
+package locknut
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBackupCatalog(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("catalog_test.db")
+
+	bl, err := NewBoltLocknut("catalog_test.db", ".", []byte("secret"), false, []string{"items"})
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	id, err := bl.ID()
+	if err != nil || len(id) != 36 {
+		t.Fatalf("unexpected ID %q %v", id, err)
+	}
+	if again, _ := bl.ID(); again != id {
+		t.Errorf("ID changed from %s to %s", id, again)
+	}
+
+	for _, loc := range []string{"s3://bucket/1", "s3://bucket/2", "s3://bucket/3"} {
+		info, err := bl.Backup(io.Discard, BackupOptions{Location: loc})
+		if err != nil {
+			t.Fatalf("Backup: %s", err)
+		}
+		if info.DBID != id || info.CatalogID == 0 {
+			t.Errorf("unexpected backup info %+v", info)
+		}
+	}
+	// a backup without a location is not recorded
+	if _, err = bl.Backup(io.Discard, BackupOptions{}); err != nil {
+		t.Fatalf("Backup: %s", err)
+	}
+
+	entries, err := bl.ListBackups()
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("expected 3 backups, got %v %v", entries, err)
+	}
+	if entries[0].Location != "s3://bucket/3" || entries[0].DBID != id || entries[0].Size == 0 {
+		t.Errorf("unexpected newest entry %+v", entries[0])
+	}
+
+	if pruned, err := bl.PruneBackups(PrunePolicy{}); err != nil || len(pruned) != 0 {
+		t.Errorf("expected the zero policy to keep everything, pruned %v %v", pruned, err)
+	}
+	if pruned, err := bl.PruneBackups(PrunePolicy{KeepLast: 1, MaxAge: time.Hour}); err != nil || len(pruned) != 0 {
+		t.Errorf("expected recent backups to be kept, pruned %v %v", pruned, err)
+	}
+
+	var removed []string
+	failing := errors.New("gone")
+	_, err = bl.PruneBackups(PrunePolicy{KeepLast: 1, Remove: func(e BackupEntry) error {
+		if e.Location == "s3://bucket/1" {
+			return failing
+		}
+		removed = append(removed, e.Location)
+		return nil
+	}})
+	if !errors.Is(err, failing) {
+		t.Errorf("expected the Remove error, got %v", err)
+	}
+	if len(removed) != 1 || removed[0] != "s3://bucket/2" {
+		t.Errorf("unexpected removals %v", removed)
+	}
+	if entries, _ = bl.ListBackups(); len(entries) != 2 || entries[1].Location != "s3://bucket/1" {
+		t.Errorf("expected the backup that failed to be removed to stay, got %v", entries)
+	}
+}
@@ -0,0 +1,181 @@
+// Package challenge implements locknut.KeyProvider with a key derived from the response of a device to
+// a challenge, a signature of an SSH agent or the HMAC of a hardware token such as a YubiKey, so the
+// db key never exists on disk unwrapped and unlocking a db takes the key or token. The wrapped db key
+// and the salt of the challenge are kept in a key file next to the db.
+package challenge
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/taybart/locknut"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// A key file is fileMagic, the salt of the challenge and the db key wrapped by Cryptor.WrapKey with
+// the key derived from the response
+const (
+	fileMagic = "locknut challenge v1\n"
+	saltSize  = 32
+)
+
+const keyInfo = "locknut challenge key"
+
+var (
+	// ErrKeyType is returned by the Responder of SSHAgent for keys whose signatures are not
+	// deterministic, such as ECDSA and security keys, which could never derive the same key twice
+	ErrKeyType = errors.New("challenge: key does not sign deterministically")
+	// ErrMalformed is returned by Unwrap for a key file that does not parse
+	ErrMalformed = errors.New("challenge: malformed key file")
+)
+
+// Responder answers a challenge, always with the same response for the same challenge
+type Responder interface {
+	Respond(ctx context.Context, challenge []byte) ([]byte, error)
+}
+
+// ResponderFunc adapts a function to a Responder
+type ResponderFunc func(ctx context.Context, challenge []byte) ([]byte, error)
+
+// Respond calls f
+func (f ResponderFunc) Respond(ctx context.Context, challenge []byte) ([]byte, error) {
+	return f(ctx, challenge)
+}
+
+// SSHAgent returns a Responder signing the challenge with key, held by the agent a, such as the one
+// at SSH_AUTH_SOCK. Only Ed25519 and RSA keys sign deterministically, others fail with ErrKeyType.
+func SSHAgent(a agent.Agent, key ssh.PublicKey) Responder {
+	return ResponderFunc(func(ctx context.Context, challenge []byte) ([]byte, error) {
+		var sig *ssh.Signature
+		var err error
+		switch key.Type() {
+		case ssh.KeyAlgoED25519:
+			sig, err = a.Sign(key, challenge)
+		case ssh.KeyAlgoRSA:
+			ext, ok := a.(agent.ExtendedAgent)
+			if !ok {
+				return nil, fmt.Errorf("%w: rsa needs an agent signing with SHA-256", ErrKeyType)
+			}
+			sig, err = ext.SignWithFlags(key, challenge, agent.SignatureFlagRsaSha256)
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrKeyType, key.Type())
+		}
+		if err != nil {
+			return nil, err
+		}
+		return sig.Blob, nil
+	})
+}
+
+// Command returns a Responder running name with args and the challenge in hex as the last argument,
+// its output being the response in hex. It suits the tools of hardware tokens, such as
+// Command("ykchalresp", "-2", "-x") for the HMAC-SHA1 challenge-response slot 2 of a YubiKey.
+func Command(name string, args ...string) Responder {
+	return ResponderFunc(func(ctx context.Context, challenge []byte) ([]byte, error) {
+		cmd := exec.CommandContext(ctx, name, append(args, hex.EncodeToString(challenge))...)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("challenge: %s: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+		}
+		response, err := hex.DecodeString(strings.TrimSpace(string(out)))
+		if err != nil || len(response) == 0 {
+			return nil, fmt.Errorf("challenge: %s: response is not hex", name)
+		}
+		return response, nil
+	})
+}
+
+// Provider wraps keys with a key derived from the response of a Responder
+type Provider struct {
+	responder Responder
+	keyFile   string
+}
+
+var _ locknut.KeyProvider = (*Provider)(nil)
+
+// New returns a Provider keeping the wrapped db key in keyFile
+func New(keyFile string, r Responder) *Provider {
+	return &Provider{responder: r, keyFile: keyFile}
+}
+
+// GetKey returns the db key, created on first use, see locknut.EnvelopeKey
+func (p *Provider) GetKey(ctx context.Context) ([]byte, error) {
+	return locknut.EnvelopeKey(ctx, p, p.keyFile)
+}
+
+// kek asks for the response to the challenge of salt and derives the key wrapping the db key from it
+func (p *Provider) kek(ctx context.Context, salt []byte) ([]byte, error) {
+	challenge := sha256.Sum256(append([]byte(fileMagic), salt...))
+	response, err := p.responder.Respond(ctx, challenge[:])
+	if err != nil {
+		return nil, err
+	}
+	return locknut.HKDF(response, salt, []byte(keyInfo), 32), nil
+}
+
+// Wrap encrypts key with the response to a fresh challenge
+func (p *Provider) Wrap(ctx context.Context, key []byte) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	kek, err := p.kek(ctx, salt)
+	if err != nil {
+		return nil, err
+	}
+	var c locknut.Cryptor
+	wrapped, err := c.WrapKey(key, kek)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte(fileMagic), salt...), wrapped...), nil
+}
+
+// Unwrap decrypts a key wrapped by Wrap, answering its challenge again. It fails with
+// locknut.ErrUnwrapKey if the Responder is not the one that wrapped it.
+func (p *Provider) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	if !bytes.HasPrefix(wrapped, []byte(fileMagic)) || len(wrapped) < len(fileMagic)+saltSize {
+		return nil, ErrMalformed
+	}
+	salt := wrapped[len(fileMagic) : len(fileMagic)+saltSize]
+	kek, err := p.kek(ctx, salt)
+	if err != nil {
+		return nil, err
+	}
+	var c locknut.Cryptor
+	return c.UnwrapKey(wrapped[len(fileMagic)+saltSize:], kek)
+}
+
+// Rewrap unwraps the db key with the Responder and wraps it again with to, replacing the key file, to
+// move the db to another SSH key or token without changing the db key
+func (p *Provider) Rewrap(ctx context.Context, to Responder) error {
+	wrapped, err := os.ReadFile(p.keyFile)
+	if err != nil {
+		return err
+	}
+	key, err := p.Unwrap(ctx, wrapped)
+	if err != nil {
+		return err
+	}
+	next := New(p.keyFile, to)
+	if wrapped, err = next.Wrap(ctx, key); err != nil {
+		return err
+	}
+
+	if err = locknut.WriteKeyFile(p.keyFile, wrapped, true); err != nil {
+		return err
+	}
+	p.responder = to
+	return nil
+}
@@ -0,0 +1,89 @@
+package challenge
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"github.com/taybart/locknut"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func agentKey(t *testing.T, a agent.Agent, priv interface{}) ssh.PublicKey {
+	t.Helper()
+	if err := a.Add(agent.AddedKey{PrivateKey: priv}); err != nil {
+		t.Fatalf("Add: %s", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer.PublicKey()
+}
+
+func TestSSHAgent(t *testing.T) {
+	ctx := context.Background()
+	a := agent.NewKeyring()
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	ed := agentKey(t, a, edKey)
+	other := agentKey(t, a, otherKey)
+	keyFile := filepath.Join(t.TempDir(), "db.key")
+
+	key, err := New(keyFile, SSHAgent(a, ed)).GetKey(ctx)
+	if err != nil {
+		t.Fatalf("GetKey: %s", err)
+	}
+	if got, err := New(keyFile, SSHAgent(a, ed)).GetKey(ctx); err != nil || !bytes.Equal(got, key) {
+		t.Errorf("expected the same key from the agent, got %v", err)
+	}
+	if _, err = New(keyFile, SSHAgent(a, other)).GetKey(ctx); !errors.Is(err, locknut.ErrUnwrapKey) {
+		t.Errorf("expected ErrUnwrapKey for another ssh key, got %v", err)
+	}
+
+	p := New(keyFile, SSHAgent(a, ed))
+	if err = p.Rewrap(ctx, SSHAgent(a, other)); err != nil {
+		t.Fatalf("Rewrap: %s", err)
+	}
+	if got, err := New(keyFile, SSHAgent(a, other)).GetKey(ctx); err != nil || !bytes.Equal(got, key) {
+		t.Errorf("expected the new ssh key to unwrap the same key, got %v", err)
+	}
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ec := agentKey(t, a, ecKey)
+	if _, err = New(filepath.Join(t.TempDir(), "ec.key"), SSHAgent(a, ec)).GetKey(ctx); !errors.Is(err, ErrKeyType) {
+		t.Errorf("expected ErrKeyType for an ecdsa key, got %v", err)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("no /bin/sh")
+	}
+	ctx := context.Background()
+	keyFile := filepath.Join(t.TempDir(), "db.key")
+
+	// a token answering with the challenge itself, the last argument is $0 of sh -c
+	echo := Command("/bin/sh", "-c", `printf '%s\n' "$0"`)
+	key, err := New(keyFile, echo).GetKey(ctx)
+	if err != nil {
+		t.Fatalf("GetKey: %s", err)
+	}
+	if got, err := New(keyFile, echo).GetKey(ctx); err != nil || !bytes.Equal(got, key) {
+		t.Errorf("expected the same key from the command, got %v", err)
+	}
+
+	if _, err = New(keyFile, Command("/bin/sh", "-c", "exit 1")).GetKey(ctx); err == nil {
+		t.Error("expected a failing command to fail")
+	}
+	if _, err = New(keyFile, Command("/bin/sh", "-c", "echo nothex")).GetKey(ctx); err == nil {
+		t.Error("expected output that is not hex to fail")
+	}
+}
@@ -0,0 +1,129 @@
+package locknut
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"go.etcd.io/bbolt"
+	"time"
+)
+
+// changelogBucket holds one Change per mutation, keyed by its big endian sequence number
+var changelogBucket = []byte(internalPrefix + "changelog")
+
+// The mutation kinds recorded in the changelog
+const (
+	OpPut    = "put"
+	OpDelete = "delete"
+)
+
+// Change is a single mutation recorded in the changelog. Value holds the bytes exactly as stored in
+// the db, so for an encrypted db the changelog never contains plaintext.
+type Change struct {
+	Seq    uint64    `json:"seq"`
+	Op     string    `json:"op"`
+	Bucket string    `json:"bucket"`
+	Key    string    `json:"key"`
+	Value  []byte    `json:"value,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// WithChangelog records every Save and Delete in an internal changelog bucket in the same transaction
+// as the write, which is the change feed consumed by a Replicator
+func WithChangelog() Option {
+	return func(bl *BoltLocknut) {
+		bl.changelog = true
+	}
+}
+
+func seqKey(seq uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, seq)
+	return k
+}
+
+// logChange appends c to the changelog inside the write transaction tx
+func (bl *BoltLocknut) logChange(tx *bbolt.Tx, c Change) error {
+	if !bl.changelog {
+		return nil
+	}
+
+	bkt := tx.Bucket(changelogBucket)
+	if bkt == nil {
+		return ErrNoChangelog
+	}
+
+	seq, err := bkt.NextSequence()
+	if err != nil {
+		return err
+	}
+	c.Seq = seq
+	c.Time = bl.now()
+
+	v, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return bkt.Put(seqKey(seq), v)
+}
+
+// Changes returns up to limit changes with a sequence number greater than after, oldest first.
+// A limit of 0 or less returns all remaining changes.
+func (bl *BoltLocknut) Changes(after uint64, limit int) ([]Change, error) {
+	var err error
+	if err = bl.openDB(); err != nil {
+		return nil, err
+	}
+	defer bl.closeDB()
+
+	changes := make([]Change, 0)
+	err = bl.db.view(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket(changelogBucket)
+		if bkt == nil {
+			return ErrNoChangelog
+		}
+
+		cursor := bkt.Cursor()
+		for k, v := cursor.Seek(seqKey(after + 1)); k != nil; k, v = cursor.Next() {
+			if limit > 0 && len(changes) >= limit {
+				break
+			}
+			var c Change
+			if err := json.Unmarshal(v, &c); err != nil {
+				return err
+			}
+			changes = append(changes, c)
+		}
+		return nil
+	})
+
+	return changes, err
+}
+
+// LastSeq returns the sequence number of the newest change in the changelog
+func (bl *BoltLocknut) LastSeq() (uint64, error) {
+	var err error
+	if err = bl.openDB(); err != nil {
+		return 0, err
+	}
+	defer bl.closeDB()
+
+	var seq uint64
+	err = bl.db.view(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket(changelogBucket)
+		if bkt == nil {
+			return ErrNoChangelog
+		}
+		seq = bkt.Sequence()
+		return nil
+	})
+
+	return seq, err
+}
+
+// ChangeValue returns the decrypted value of a put change, or nil for a delete
+func (bl *BoltLocknut) ChangeValue(c Change) ([]byte, error) {
+	if c.Value == nil {
+		return nil, nil
+	}
+	return bl.decrypt(c.Bucket, c.Key, c.Value)
+}
@@ -0,0 +1,67 @@
+package locknut
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/taybart/locknut/internal/testhooks"
+	"io"
+	"time"
+)
+
+// Clock tells the time to the parts of a BoltLocknut that record or compare it: the changelog, the
+// stats, config history, idempotency key expiry and the backup catalog. Tests pass a fake one with
+// WithClock to control them. Durations reported to Metrics and the auto lock timer use the real time.
+type Clock interface {
+	Now() time.Time
+}
+
+// WithClock makes the BoltLocknut tell the time with c instead of the system clock
+func WithClock(c Clock) Option {
+	return func(bl *BoltLocknut) {
+		bl.clock = c
+	}
+}
+
+// withRand makes the BoltLocknut read the nonces of the values it encrypts and the IDs it generates
+// from r instead of crypto/rand, so tests can produce the same file twice. A reader that repeats itself
+// reuses nonces, which breaks the encryption, so it is only reachable from tests, through
+// locknuttest.WithRand, and FIPS mode refuses it.
+func withRand(r io.Reader) Option {
+	return func(bl *BoltLocknut) {
+		bl.rand = r
+	}
+}
+
+func init() {
+	testhooks.WithRand = func(r io.Reader) interface{} { return withRand(r) }
+}
+
+// now returns the time of the clock
+func (bl *BoltLocknut) now() time.Time {
+	if bl.clock != nil {
+		return bl.clock.Now()
+	}
+	return time.Now()
+}
+
+// randReader returns the source of randomness
+func (bl *BoltLocknut) randReader() io.Reader {
+	if bl.rand != nil {
+		return bl.rand
+	}
+	return rand.Reader
+}
+
+// encrypt is Encrypt with a nonce read from the source of randomness
+func (bl *BoltLocknut) encrypt(plain, key []byte) ([]byte, error) {
+	return encryptWith(bl.randReader(), plain, key)
+}
+
+// randomID returns 16 random bytes in hex, naming internal buckets
+func (bl *BoltLocknut) randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(bl.randReader(), b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
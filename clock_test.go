@@ -0,0 +1,77 @@
+package locknut
+
+import (
+	"bytes"
+	"go.etcd.io/bbolt"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.t
+}
+
+func TestWithClock(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("clock_test.db")
+
+	clock := &fakeClock{t: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	bl, err := NewBoltLocknut("clock_test.db", ".", []byte("secret"), false, []string{"kids"},
+		WithChangelog(), WithClock(clock))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if err = bl.Save("kids", "a", 1); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	clock.t = clock.t.Add(time.Hour)
+	if err = bl.Save("kids", "b", 2); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+
+	changes, err := bl.Changes(0, 0)
+	if err != nil || len(changes) != 2 {
+		t.Fatalf("unexpected changes %v %v", changes, err)
+	}
+	if !changes[0].Time.Equal(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)) || changes[1].Time.Sub(changes[0].Time) != time.Hour {
+		t.Errorf("expected the changes to be timed by the clock, got %v and %v", changes[0].Time, changes[1].Time)
+	}
+}
+
+func TestWithRand(t *testing.T) {
+	defer os.Remove("clock_test.db")
+	defer os.Remove("clock_copy_test.db")
+
+	skipFIPS(t, "a source of randomness other than crypto/rand")
+	stored := func(name string) []byte {
+		bl, err := NewBoltLocknut(name, ".", []byte("secret"), false, []string{"kids"},
+			withRand(rand.New(rand.NewSource(1))))
+		if err != nil {
+			t.Fatalf("NewBoltLocknut: %s", err)
+		}
+		if err = bl.Save("kids", "a", "same"); err != nil {
+			t.Fatalf("Save: %s", err)
+		}
+		if err = bl.openDB(); err != nil {
+			t.Fatal(err)
+		}
+		defer bl.closeDB()
+		var v []byte
+		bl.db.view(func(tx *bbolt.Tx) error {
+			v = append(v, tx.Bucket([]byte("kids")).Get([]byte("a"))...)
+			return nil
+		})
+		return v
+	}
+
+	a, b := stored("clock_test.db"), stored("clock_copy_test.db")
+	if len(a) == 0 || !bytes.Equal(a, b) {
+		t.Errorf("expected the same randomness to give the same ciphertext, got %x and %x", a, b)
+	}
+}
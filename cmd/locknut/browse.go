@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/taybart/locknut"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const browseHelp = `commands:
+  buckets              list buckets
+  use <bucket>         switch to a bucket
+  ls [prefix]          list the first page of keys
+  n, p                 next and previous page
+  get <key>            show a decrypted record
+  set <key> <value>    store a value
+  edit <key>           edit a record in $EDITOR
+  del <key>            delete a record
+  help, quit
+`
+
+// browsePageSize is the number of keys shown per page
+const browsePageSize = 20
+
+// browser is the state of an interactive browse session
+type browser struct {
+	bl     *locknut.BoltLocknut
+	in     *bufio.Scanner
+	out    io.Writer
+	bucket string
+	keys   []string
+	page   int
+}
+
+// browse runs a small REPL over the db until quit or end of input
+func browse(bl *locknut.BoltLocknut, in io.Reader, out io.Writer) error {
+	b := &browser{bl: bl, in: bufio.NewScanner(in), out: out}
+	fmt.Fprint(out, browseHelp)
+
+	for {
+		fmt.Fprintf(out, "%s> ", b.bucket)
+		if !b.in.Scan() {
+			fmt.Fprintln(out)
+			return b.in.Err()
+		}
+
+		fields := strings.Fields(b.in.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		cmd, args := fields[0], fields[1:]
+		if cmd == "quit" || cmd == "exit" {
+			return nil
+		}
+
+		if err := b.run(cmd, args); err != nil {
+			fmt.Fprintln(out, "error:", err)
+		}
+	}
+}
+
+func (b *browser) run(cmd string, args []string) error {
+	if cmd == "help" {
+		fmt.Fprint(b.out, browseHelp)
+		return nil
+	}
+	if cmd == "buckets" {
+		names, err := b.bl.Buckets()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(b.out, strings.Join(names, "\n"))
+		return nil
+	}
+	if cmd == "use" {
+		if err := want(args, 1, 1, "<bucket>"); err != nil {
+			return err
+		}
+		b.bucket = args[0]
+		b.keys = nil
+		return nil
+	}
+
+	if b.bucket == "" {
+		return fmt.Errorf("no bucket selected, try: use <bucket>")
+	}
+
+	switch cmd {
+	case "ls":
+		prefix := ""
+		if len(args) > 0 {
+			prefix = args[0]
+		}
+		keys, err := b.bl.GetKeyList(b.bucket, prefix)
+		if err != nil {
+			return err
+		}
+		b.keys, b.page = keys, 0
+		b.showPage()
+	case "n":
+		if (b.page+1)*browsePageSize < len(b.keys) {
+			b.page++
+		}
+		b.showPage()
+	case "p":
+		if b.page > 0 {
+			b.page--
+		}
+		b.showPage()
+	case "get":
+		if err := want(args, 1, 1, "<key>"); err != nil {
+			return err
+		}
+		v, err := b.get(args[0])
+		if err != nil {
+			return err
+		}
+		printValue(b.out, v)
+	case "set":
+		if len(args) < 2 {
+			return fmt.Errorf("expected <key> <value>")
+		}
+		return b.bl.SaveBytes(b.bucket, args[0], []byte(strings.Join(args[1:], " ")))
+	case "edit":
+		if err := want(args, 1, 1, "<key>"); err != nil {
+			return err
+		}
+		return b.edit(args[0])
+	case "del":
+		if err := want(args, 1, 1, "<key>"); err != nil {
+			return err
+		}
+		fmt.Fprintf(b.out, "delete %s/%s? [y/N] ", b.bucket, args[0])
+		if !b.in.Scan() || strings.TrimSpace(b.in.Text()) != "y" {
+			return nil
+		}
+		return b.bl.Delete(b.bucket, args[0])
+	default:
+		return fmt.Errorf("unknown command %q, try help", cmd)
+	}
+	return nil
+}
+
+func (b *browser) showPage() {
+	start := b.page * browsePageSize
+	end := start + browsePageSize
+	if end > len(b.keys) {
+		end = len(b.keys)
+	}
+	for _, k := range b.keys[start:end] {
+		fmt.Fprintln(b.out, k)
+	}
+	fmt.Fprintf(b.out, "-- %d-%d of %d --\n", start+1, end, len(b.keys))
+}
+
+// get returns the record stored under exactly key
+func (b *browser) get(key string) ([]byte, error) {
+	keys, err := b.bl.GetKeyList(b.bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 || keys[0] != key {
+		return nil, fmt.Errorf("%s/%s not found", b.bucket, key)
+	}
+	return b.bl.GetOne(b.bucket, key)
+}
+
+// edit opens the decrypted record in $EDITOR and stores the result. The plaintext is written to a
+// private temporary file for the duration of the edit and removed afterwards.
+func (b *browser) edit(key string) error {
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		return fmt.Errorf("EDITOR is not set, use set instead")
+	}
+
+	v, err := b.get(key)
+	if err != nil {
+		return err
+	}
+	var pretty bytes.Buffer
+	if json.Indent(&pretty, v, "", "  ") == nil {
+		v = pretty.Bytes()
+	}
+
+	f, err := os.CreateTemp("", "locknut-edit-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.Write(v); err != nil {
+		f.Close()
+		return err
+	}
+	f.Close()
+
+	cmd := exec.Command(editor, f.Name())
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err = cmd.Run(); err != nil {
+		return err
+	}
+
+	edited, err := os.ReadFile(f.Name())
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(edited, v) {
+		fmt.Fprintln(b.out, "unchanged")
+		return nil
+	}
+
+	// store json compacted like Save does
+	var compact bytes.Buffer
+	if json.Compact(&compact, edited) == nil {
+		edited = compact.Bytes()
+	}
+	return b.bl.SaveBytes(b.bucket, key, edited)
+}
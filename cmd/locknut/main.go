@@ -0,0 +1,577 @@
+// Command locknut inspects and patches encrypted locknut db files.
+//
+// The secret is read from the OS keychain entry given with -keychain service/account, the file given
+// with -keyfile, the LOCKNUT_SECRET environment variable, or prompted for on the terminal, in that
+// order. With -shares k it is combined from k shares made by split-secret, prompted for one by one so
+// each holder can type in theirs. A keyfile made by keygen is unlocked with its passphrase, read from
+// LOCKNUT_KEYFILE_PASSPHRASE or prompted for.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/taybart/locknut"
+	"golang.org/x/term"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const usage = `usage: locknut [-db file] [-keychain service/account] [-keyfile file] [-shares k] <command> [args]
+
+commands:
+  get <bucket> <key>                 print a decrypted record
+  put <bucket> <key> [value]         store value, or stdin when value is omitted
+  del <bucket> <key>                 delete a record
+  ls <bucket> [prefix]               list keys
+  buckets                            list buckets
+  export [-format f] [-bulk] [bucket...]
+                                     write records to stdout as json, jsonl or csv
+  export-zip [-redact bucket:field] [bucket...]
+                                     write records to stdout as a password protected zip
+  import [-format f] [-bucket b] [file]
+                                     load records from file or stdin
+  rotate-key [-new-keyfile file]     re-encrypt everything with a new secret
+  compact                            rewrite the file to reclaim free space
+  verify                             check the file and decrypt every record
+  backup [-compact] <file>           write a consistent copy of the db to file
+  backups                            list the backups in the catalog
+  split-secret -n n -k k             split the secret into n shares, any k of which unlock the db
+  browse                             explore and edit records interactively
+  keygen [-kdf k] <file>             create a passphrase protected keyfile with a new secret
+  audit-export -key file             write the audit log to stdout, signed with the hex ed25519
+                                     seed in file
+  verify-audit -pub key [file]       check an audit export from file or stdin against the hex
+                                     ed25519 public key
+`
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "locknut:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	fs := flag.NewFlagSet("locknut", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	dbFile := fs.String("db", "locknut.db", "db file")
+	keyfile := fs.String("keyfile", "", "file holding the secret")
+	keychain := fs.String("keychain", "", "OS keychain entry holding the secret, as service/account")
+	shares := fs.Int("shares", 0, "number of secret shares to prompt for")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	args = fs.Args()
+	if len(args) == 0 {
+		fs.Usage()
+		return errors.New("no command given")
+	}
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "keygen":
+		return keygen(args)
+	case "verify-audit":
+		return verifyAudit(args)
+	}
+
+	var opts []locknut.Option
+	if cmd == "audit-export" {
+		fs := flag.NewFlagSet("audit-export", flag.ContinueOnError)
+		keyFile := fs.String("key", "", "file holding the hex ed25519 seed of the audit signer")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		key, err := readAuditKey(*keyFile)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, locknut.WithAuditSigner(key))
+	}
+
+	// only commands that write may create a new db file
+	if cmd != "put" && cmd != "import" {
+		if _, err := os.Stat(*dbFile); err != nil {
+			return err
+		}
+	}
+
+	var secret []byte
+	var err error
+	if *shares > 0 {
+		secret, err = readShares(*shares)
+	} else if *keychain != "" {
+		service, account, _ := strings.Cut(*keychain, "/")
+		secret, err = locknut.SecretFromKeychain(service, account)
+	} else {
+		secret, err = readSecret(*keyfile, "LOCKNUT_SECRET", "secret: ")
+	}
+	if err != nil {
+		return err
+	}
+
+	bl, err := locknut.NewBoltLocknut(filepath.Base(*dbFile), filepath.Dir(*dbFile), secret, false, nil, opts...)
+	if err != nil {
+		return err
+	}
+
+	switch cmd {
+	case "get":
+		return get(bl, args)
+	case "put":
+		return put(bl, args)
+	case "del":
+		return del(bl, args)
+	case "ls":
+		return ls(bl, args)
+	case "buckets":
+		return buckets(bl)
+	case "export":
+		return export(bl, args)
+	case "export-zip":
+		return exportZip(bl, args)
+	case "import":
+		return importRecords(bl, args)
+	case "rotate-key":
+		return rotateKey(bl, args)
+	case "compact":
+		return bl.Compact()
+	case "verify":
+		return verify(bl)
+	case "backup":
+		return backup(bl, args)
+	case "backups":
+		return backups(bl)
+	case "split-secret":
+		return splitSecret(secret, args)
+	case "browse":
+		return browse(bl, os.Stdin, os.Stdout)
+	case "audit-export":
+		return bl.ExportAuditLog(os.Stdout)
+	}
+
+	fs.Usage()
+	return fmt.Errorf("unknown command %q", cmd)
+}
+
+// readSecret reads the secret from keyfile, then the environment variable env, then the terminal
+func readSecret(keyfile, env, prompt string) ([]byte, error) {
+	if keyfile != "" {
+		b, err := os.ReadFile(keyfile)
+		if err != nil {
+			return nil, err
+		}
+		if locknut.IsKeyfile(b) {
+			passphrase, err := readSecret("", "LOCKNUT_KEYFILE_PASSPHRASE", "keyfile passphrase: ")
+			if err != nil {
+				return nil, err
+			}
+			return locknut.ParseKeyfile(b, passphrase)
+		}
+		return bytes.TrimRight(b, "\r\n"), nil
+	}
+
+	if s := os.Getenv(env); s != "" {
+		return []byte(s), nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("no secret given, use -keyfile or %s", env)
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	secret, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	return secret, err
+}
+
+// readShares prompts for k secret shares on the terminal and combines them
+func readShares(k int) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, errors.New("-shares needs a terminal to prompt for the shares")
+	}
+	u := locknut.NewUnlocker(k)
+	for {
+		fmt.Fprintf(os.Stderr, "share (%d left): ", u.Remaining())
+		line, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, err
+		}
+		share, err := hex.DecodeString(strings.TrimSpace(string(line)))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "not a share:", err)
+			continue
+		}
+		secret, done, err := u.Add(share)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		if done {
+			return secret, nil
+		}
+	}
+}
+
+func want(args []string, min, max int, names string) error {
+	if len(args) < min || len(args) > max {
+		return fmt.Errorf("expected %s", names)
+	}
+	return nil
+}
+
+// printValue writes v to w, indented when it is json
+func printValue(w io.Writer, v []byte) {
+	var out bytes.Buffer
+	if json.Indent(&out, v, "", "  ") == nil {
+		v = out.Bytes()
+	}
+	fmt.Fprintln(w, string(v))
+}
+
+func get(bl *locknut.BoltLocknut, args []string) error {
+	if err := want(args, 2, 2, "<bucket> <key>"); err != nil {
+		return err
+	}
+	v, err := bl.Get(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	if v == nil {
+		return fmt.Errorf("%s/%s not found", args[0], args[1])
+	}
+	printValue(os.Stdout, v)
+	return nil
+}
+
+func put(bl *locknut.BoltLocknut, args []string) error {
+	if err := want(args, 2, 3, "<bucket> <key> [value]"); err != nil {
+		return err
+	}
+
+	var value []byte
+	if len(args) == 3 {
+		value = []byte(args[2])
+	} else {
+		var err error
+		if value, err = io.ReadAll(bufio.NewReader(os.Stdin)); err != nil {
+			return err
+		}
+	}
+
+	if err := bl.CreateBucket(args[0]); err != nil {
+		return err
+	}
+	return bl.SaveBytes(args[0], args[1], value)
+}
+
+func del(bl *locknut.BoltLocknut, args []string) error {
+	if err := want(args, 2, 2, "<bucket> <key>"); err != nil {
+		return err
+	}
+	return bl.Delete(args[0], args[1])
+}
+
+func ls(bl *locknut.BoltLocknut, args []string) error {
+	if err := want(args, 1, 2, "<bucket> [prefix]"); err != nil {
+		return err
+	}
+	prefix := ""
+	if len(args) == 2 {
+		prefix = args[1]
+	}
+
+	keys, err := bl.GetKeyList(args[0], prefix)
+	if err != nil {
+		return err
+	}
+	fmt.Println(strings.Join(keys, "\n"))
+	return nil
+}
+
+func buckets(bl *locknut.BoltLocknut) error {
+	names, err := bl.Buckets()
+	if err != nil {
+		return err
+	}
+	fmt.Println(strings.Join(names, "\n"))
+	return nil
+}
+
+func export(bl *locknut.BoltLocknut, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "jsonl", "json, jsonl or csv")
+	bulk := fs.Bool("bulk", false, "map the db file read only for a large one shot export")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	f, err := locknut.ParseFormat(*format)
+	if err != nil {
+		return err
+	}
+
+	if *bulk {
+		return bl.ExportBulk(os.Stdout, f, fs.Args()...)
+	}
+	w := bufio.NewWriter(os.Stdout)
+	if err = bl.Export(w, f, fs.Args()...); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// redactFlag collects bucket:field pairs
+type redactFlag map[string][]string
+
+func (r redactFlag) String() string { return "" }
+
+func (r redactFlag) Set(s string) error {
+	bucket, field, ok := strings.Cut(s, ":")
+	if !ok || bucket == "" || field == "" {
+		return fmt.Errorf("expected bucket:field, got %q", s)
+	}
+	r[bucket] = append(r[bucket], field)
+	return nil
+}
+
+func exportZip(bl *locknut.BoltLocknut, args []string) error {
+	fs := flag.NewFlagSet("export-zip", flag.ContinueOnError)
+	redact := redactFlag{}
+	fs.Var(redact, "redact", "bucket:field to replace with [redacted], repeatable")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	password, err := readSecret("", "LOCKNUT_ZIP_PASSWORD", "zip password: ")
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	if err = bl.ExportZip(w, string(password), locknut.ZipOptions{Buckets: fs.Args(), Redact: redact}); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func importRecords(bl *locknut.BoltLocknut, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := fs.String("format", "jsonl", "json or jsonl")
+	bucket := fs.String("bucket", "", "bucket to load into, defaults to the bucket of each record")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	f, err := locknut.ParseFormat(*format)
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = os.Stdin
+	if fs.NArg() > 0 && fs.Arg(0) != "-" {
+		file, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+	return bl.Import(bufio.NewReader(r), f, *bucket)
+}
+
+func rotateKey(bl *locknut.BoltLocknut, args []string) error {
+	fs := flag.NewFlagSet("rotate-key", flag.ContinueOnError)
+	keyfile := fs.String("new-keyfile", "", "file holding the new secret")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	secret, err := readSecret(*keyfile, "LOCKNUT_NEW_SECRET", "new secret: ")
+	if err != nil {
+		return err
+	}
+	if *keyfile == "" && os.Getenv("LOCKNUT_NEW_SECRET") == "" {
+		again, err := readSecret("", "LOCKNUT_NEW_SECRET", "repeat new secret: ")
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(secret, again) {
+			return errors.New("secrets do not match")
+		}
+	}
+	return bl.RotateKey(secret)
+}
+
+func verify(bl *locknut.BoltLocknut) error {
+	report, err := bl.Verify()
+	if err != nil {
+		return err
+	}
+	for _, err := range report.Pages {
+		fmt.Println("page:", err)
+	}
+	for _, err := range report.Failed {
+		fmt.Println("record:", err)
+	}
+	if !report.OK() {
+		return fmt.Errorf("%d of %d records failed, %d page errors", len(report.Failed), report.Records, len(report.Pages))
+	}
+	fmt.Printf("%d records ok\n", report.Records)
+	return nil
+}
+
+func backup(bl *locknut.BoltLocknut, args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	compact := fs.Bool("compact", false, "leave out free pages")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := want(fs.Args(), 1, 1, "file"); err != nil {
+		return err
+	}
+
+	location, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(location, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = bl.Backup(f, locknut.BackupOptions{Compact: *compact, Location: location}); err != nil {
+		f.Close()
+		os.Remove(location)
+		return err
+	}
+	return f.Close()
+}
+
+func splitSecret(secret []byte, args []string) error {
+	fs := flag.NewFlagSet("split-secret", flag.ContinueOnError)
+	n := fs.Int("n", 5, "number of shares")
+	k := fs.Int("k", 3, "number of shares needed to unlock")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	shares, err := locknut.SplitSecret(secret, *n, *k)
+	if err != nil {
+		return err
+	}
+	for _, share := range shares {
+		fmt.Println(hex.EncodeToString(share))
+	}
+	return nil
+}
+
+func keygen(args []string) error {
+	fs := flag.NewFlagSet("keygen", flag.ContinueOnError)
+	kdf := fs.String("kdf", "argon2id", "argon2id, scrypt or pbkdf2")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := want(fs.Args(), 1, 1, "file"); err != nil {
+		return err
+	}
+
+	var k locknut.KDF
+	switch *kdf {
+	case "argon2id":
+		k = locknut.Argon2idKDF()
+	case "scrypt":
+		k = locknut.ScryptKDF()
+	case "pbkdf2":
+		k = locknut.PBKDF2KDF()
+	default:
+		return fmt.Errorf("unknown kdf %q", *kdf)
+	}
+
+	passphrase, err := readSecret("", "LOCKNUT_KEYFILE_PASSPHRASE", "keyfile passphrase: ")
+	if err != nil {
+		return err
+	}
+	if os.Getenv("LOCKNUT_KEYFILE_PASSPHRASE") == "" {
+		again, err := readSecret("", "LOCKNUT_KEYFILE_PASSPHRASE", "repeat keyfile passphrase: ")
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(passphrase, again) {
+			return errors.New("passphrases do not match")
+		}
+	}
+	if len(passphrase) == 0 {
+		return errors.New("empty passphrase")
+	}
+	_, err = locknut.GenerateKeyfile(fs.Arg(0), passphrase, k)
+	return err
+}
+
+func readAuditKey(file string) (ed25519.PrivateKey, error) {
+	if file == "" {
+		return nil, errors.New("no audit signer key given, use -key")
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("%s does not hold a hex ed25519 seed", file)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+func verifyAudit(args []string) error {
+	fs := flag.NewFlagSet("verify-audit", flag.ContinueOnError)
+	pubHex := fs.String("pub", "", "hex ed25519 public key of the audit signer")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := want(fs.Args(), 0, 1, "file"); err != nil {
+		return err
+	}
+	pub, err := hex.DecodeString(*pubHex)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return errors.New("-pub must be a hex ed25519 public key")
+	}
+
+	var r io.Reader = os.Stdin
+	if fs.NArg() == 1 {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	export, err := locknut.VerifyAuditExport(r, pub)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d entries ok, exported from db %s at %s\n", len(export.Entries), export.DBID, export.Time.Format(time.RFC3339))
+	return nil
+}
+
+func backups(bl *locknut.BoltLocknut) error {
+	entries, err := bl.ListBackups()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		fmt.Printf("%d\t%s\t%d\t%d\t%s\n", e.ID, e.Time.Format(time.RFC3339), e.Size, e.Seq, e.Location)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+// Command locknutload runs a concurrent workload against a locknut db and prints the latency
+// percentiles of its reads and writes, see the locknutload package.
+//
+//	locknutload -backend bolt -path load.db -workers 16 -duration 30s -reads 0.9 -dist zipf
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/taybart/locknut"
+	"github.com/taybart/locknut/badger"
+	"github.com/taybart/locknut/locknutload"
+	"github.com/taybart/locknut/sqlite"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+)
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "locknutload:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	fs := flag.NewFlagSet("locknutload", flag.ContinueOnError)
+	backend := fs.String("backend", "bolt", "bolt, badger or sqlite")
+	path := fs.String("path", "locknutload.db", "db file, or directory for badger")
+	secret := fs.String("secret", "locknutload", "secret the values are encrypted with")
+	var cfg locknutload.Config
+	fs.StringVar(&cfg.Bucket, "bucket", "load", "bucket the workload uses")
+	fs.IntVar(&cfg.Workers, "workers", 8, "concurrent workers")
+	fs.IntVar(&cfg.Ops, "ops", 0, "operations to run, 10000 unless -duration is set")
+	fs.DurationVar(&cfg.Duration, "duration", 0, "how long to run")
+	fs.Float64Var(&cfg.ReadRatio, "reads", 0.5, "fraction of operations that are reads")
+	fs.IntVar(&cfg.Keys, "keys", 1000, "size of the key space")
+	fs.IntVar(&cfg.ValueSize, "size", 256, "value size in bytes")
+	fs.IntVar(&cfg.ValueSizeMax, "size-max", 0, "pick value sizes between -size and this")
+	dist := fs.String("dist", "uniform", "key distribution: uniform, zipf or sequential")
+	fs.BoolVar(&cfg.Preload, "preload", true, "write every key before the workload starts")
+	fs.Int64Var(&cfg.Seed, "seed", 1, "seed of the operation sequence")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var err error
+	if cfg.Distribution, err = locknutload.ParseDistribution(*dist); err != nil {
+		return err
+	}
+
+	var l locknut.Locknut
+	switch *backend {
+	case "bolt":
+		l, err = locknut.NewBoltLocknut(filepath.Base(*path), filepath.Dir(*path), []byte(*secret), true, nil)
+	case "badger":
+		var bl *badger.BadgerLocknut
+		if bl, err = badger.NewBadgerLocknut(*path, []byte(*secret), nil); err == nil {
+			defer bl.Close()
+			l = bl
+		}
+	case "sqlite":
+		var sl *sqlite.SQLiteLocknut
+		if sl, err = sqlite.NewSQLiteLocknut(*path, []byte(*secret), nil); err == nil {
+			defer sl.Close()
+			l = sl
+		}
+	default:
+		return fmt.Errorf("unknown backend %q", *backend)
+	}
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	res, err := locknutload.Run(ctx, l, cfg)
+	if err != nil {
+		return err
+	}
+	report(res)
+	if res.FirstError != nil {
+		return errors.Join(errors.New("some operations failed"), res.FirstError)
+	}
+	return nil
+}
+
+func report(res locknutload.Result) {
+	fmt.Printf("%d ops in %s, %.0f ops/s\n\n", res.Ops(), res.Elapsed.Round(time.Millisecond), res.Throughput())
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "op\tcount\terrors\tmin\tmean\tp50\tp90\tp99\tp99.9\tmax\t")
+	for _, op := range []struct {
+		name string
+		l    locknutload.Latencies
+	}{{"read", res.Reads}, {"write", res.Writes}} {
+		l := op.l
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n",
+			op.name, l.Count, l.Errors, l.Min, l.Mean, l.P50, l.P90, l.P99, l.P999, l.Max)
+	}
+	tw.Flush()
+}
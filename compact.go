@@ -0,0 +1,45 @@
+package locknut
+
+import (
+	"errors"
+	"os"
+)
+
+// Compact rewrites the db into a new file and swaps it in place of the old one, so the space of
+// deleted records is given back to the file system. Writes are fenced off with ErrMaintenance while
+// it runs. The db is closed for the swap, so Compact fails if another operation has it open.
+func (bl *BoltLocknut) Compact() error {
+	return bl.maintain("compaction", bl.compact)
+}
+
+func (bl *BoltLocknut) compact() error {
+	if bl.opens > 0 {
+		return errors.New("cannot compact while the db is in use")
+	}
+	if bl.db != nil {
+		// batch mode keeps the db open between operations
+		bl.db.Close()
+		bl.db = nil
+	}
+
+	tmp := bl.fullPath + ".compacting"
+	if err := rewriteDB(bl.fullPath, tmp, func([]byte) transformFunc { return nil }); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, bl.fullPath); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := bl.openDB(); err != nil {
+		return err
+	}
+	defer bl.closeDB()
+	if bl.metrics != nil {
+		if info, err := os.Stat(bl.fullPath); err == nil {
+			bl.metrics.SetDBSize(info.Size())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package locknut
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCompact(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("compact_test.db")
+
+	bl, err := NewBoltLocknut("compact_test.db", ".", []byte("secret"), false, []string{"items"}, WithChangelog())
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+
+	value := strings.Repeat("x", 1024)
+	for i := 0; i < 500; i++ {
+		if err = bl.Save("items", fmt.Sprintf("%04d", i), value); err != nil {
+			t.Fatalf("Save: %s", err)
+		}
+	}
+	for i := 1; i < 500; i++ {
+		if err = bl.Delete("items", fmt.Sprintf("%04d", i)); err != nil {
+			t.Fatalf("Delete: %s", err)
+		}
+	}
+	if _, err = bl.TruncateChangelog(1001); err != nil {
+		t.Fatalf("TruncateChangelog: %s", err)
+	}
+	seq, err := bl.LastSeq()
+	if err != nil {
+		t.Fatalf("LastSeq: %s", err)
+	}
+
+	before, _ := os.Stat("compact_test.db")
+	if err = bl.Compact(); err != nil {
+		t.Fatalf("Compact: %s", err)
+	}
+	after, _ := os.Stat("compact_test.db")
+	if after.Size() >= before.Size() {
+		t.Errorf("expected the file to shrink, %d before and %d after", before.Size(), after.Size())
+	}
+
+	if v, err := bl.Get("items", "0000"); err != nil || string(v) != `"`+value+`"` {
+		t.Errorf("record lost in compaction: %v", err)
+	}
+	if last, _ := bl.LastSeq(); last != seq {
+		t.Errorf("changelog sequence changed from %d to %d", seq, last)
+	}
+	if err = bl.Save("items", "0001", "again"); err != nil {
+		t.Errorf("Save after Compact: %s", err)
+	}
+}
@@ -0,0 +1,172 @@
+package locknut
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config stores application settings in a BoltLocknut bucket. Values are saved as json and read back
+// with typed getters that fall back to a default, every Set is kept in a history bucket, and watchers
+// are told about the changes made through the Config.
+type Config struct {
+	bl      *BoltLocknut
+	bucket  string
+	history string
+
+	mu       sync.Mutex
+	watchers []func(name string, value json.RawMessage)
+}
+
+// ConfigVersion is one value a setting has held
+type ConfigVersion struct {
+	Version uint64          `json:"version"`
+	Value   json.RawMessage `json:"value"`
+	Time    time.Time       `json:"time"`
+}
+
+// NewConfig returns a Config kept in bucket, with its history in bucket+"_history". Both buckets are
+// created if they do not exist.
+func NewConfig(bl *BoltLocknut, bucket string) (*Config, error) {
+	c := &Config{bl: bl, bucket: bucket, history: bucket + "_history"}
+
+	if err := bl.CreateBucket(c.bucket); err != nil {
+		return nil, err
+	}
+	if err := bl.CreateBucket(c.history); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func historyKey(name string, version uint64) string {
+	return name + "\x00" + string(seqKey(version))
+}
+
+// Set stores value as the new version of the setting and notifies the watchers
+func (c *Config) Set(name string, value interface{}) error {
+	if name == "" || strings.Contains(name, "\x00") {
+		return ErrKeyInvalid
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	err = c.bl.write(func(w *writeTx) error {
+		version, err := w.tx.Bucket([]byte(c.history)).NextSequence()
+		if err != nil {
+			return err
+		}
+
+		entry, err := json.Marshal(ConfigVersion{Version: version, Value: raw, Time: c.bl.now()})
+		if err != nil {
+			return err
+		}
+		if err = w.put(c.history, historyKey(name, version), entry); err != nil {
+			return err
+		}
+		return w.put(c.bucket, name, raw)
+	})
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	watchers := c.watchers
+	c.mu.Unlock()
+	for _, fn := range watchers {
+		fn(name, raw)
+	}
+	return nil
+}
+
+// Get unmarshals the current value of the setting into v and reports whether it was set
+func (c *Config) Get(name string, v interface{}) (bool, error) {
+	raw, err := c.bl.Get(c.bucket, name)
+	if err != nil || raw == nil {
+		return false, err
+	}
+	return true, json.Unmarshal(raw, v)
+}
+
+// String returns the setting as a string, or def if it is not set
+func (c *Config) String(name, def string) (string, error) {
+	v := def
+	_, err := c.Get(name, &v)
+	return v, err
+}
+
+// Int returns the setting as an int, or def if it is not set
+func (c *Config) Int(name string, def int) (int, error) {
+	v := def
+	_, err := c.Get(name, &v)
+	return v, err
+}
+
+// Float64 returns the setting as a float64, or def if it is not set
+func (c *Config) Float64(name string, def float64) (float64, error) {
+	v := def
+	_, err := c.Get(name, &v)
+	return v, err
+}
+
+// Bool returns the setting as a bool, or def if it is not set
+func (c *Config) Bool(name string, def bool) (bool, error) {
+	v := def
+	_, err := c.Get(name, &v)
+	return v, err
+}
+
+// Duration returns the setting as a time.Duration, or def if it is not set. The setting may be stored
+// as nanoseconds or as a string such as "1m30s".
+func (c *Config) Duration(name string, def time.Duration) (time.Duration, error) {
+	var raw json.RawMessage
+	ok, err := c.Get(name, &raw)
+	if err != nil || !ok {
+		return def, err
+	}
+
+	var s string
+	if err = json.Unmarshal(raw, &s); err == nil {
+		return time.ParseDuration(s)
+	}
+
+	var d time.Duration
+	if err = json.Unmarshal(raw, &d); err != nil {
+		return def, err
+	}
+	return d, nil
+}
+
+// History returns every version of the setting, oldest first
+func (c *Config) History(name string) ([]ConfigVersion, error) {
+	records, err := c.bl.GetByPrefix(c.history, name+"\x00")
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]ConfigVersion, 0, len(records))
+	for _, v := range records {
+		var cv ConfigVersion
+		if err = json.Unmarshal(v, &cv); err != nil {
+			return nil, err
+		}
+		versions = append(versions, cv)
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version < versions[j].Version
+	})
+	return versions, nil
+}
+
+// Watch registers fn to be called with the new raw value after each successful Set
+func (c *Config) Watch(fn func(name string, value json.RawMessage)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.watchers = append(c.watchers, fn)
+}
@@ -0,0 +1,68 @@
+package locknut
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestConfig(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("config_test.db")
+
+	bl, err := NewBoltLocknut("config_test.db", ".", []byte("secret"), false, nil)
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+
+	c, err := NewConfig(bl, "settings")
+	if err != nil {
+		t.Fatalf("NewConfig: %s", err)
+	}
+
+	var changed []string
+	c.Watch(func(name string, value json.RawMessage) {
+		changed = append(changed, name+"="+string(value))
+	})
+
+	if port, err := c.Int("port", 8080); err != nil || port != 8080 {
+		t.Errorf("expected default port, got %d %v", port, err)
+	}
+
+	if err = c.Set("port", 9000); err != nil {
+		t.Fatalf("Set: %s", err)
+	}
+	if err = c.Set("port", 9001); err != nil {
+		t.Fatalf("Set: %s", err)
+	}
+	if err = c.Set("timeout", "1m30s"); err != nil {
+		t.Fatalf("Set: %s", err)
+	}
+	// a setting that shares a prefix must not be returned for "port"
+	if err = c.Set("portal", true); err != nil {
+		t.Fatalf("Set: %s", err)
+	}
+
+	if port, err := c.Int("port", 8080); err != nil || port != 9001 {
+		t.Errorf("expected port 9001, got %d %v", port, err)
+	}
+	if d, err := c.Duration("timeout", time.Second); err != nil || d != 90*time.Second {
+		t.Errorf("expected 90s timeout, got %s %v", d, err)
+	}
+	if b, err := c.Bool("missing", true); err != nil || !b {
+		t.Errorf("expected default bool, got %v %v", b, err)
+	}
+
+	history, err := c.History("port")
+	if err != nil {
+		t.Fatalf("History: %s", err)
+	}
+	if len(history) != 2 || string(history[0].Value) != "9000" || string(history[1].Value) != "9001" {
+		t.Errorf("unexpected history %+v", history)
+	}
+
+	if len(changed) != 4 || changed[0] != "port=9000" {
+		t.Errorf("unexpected notifications %v", changed)
+	}
+}
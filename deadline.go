@@ -0,0 +1,78 @@
+package locknut
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrDeadlinePartial is matched by the PartialError of a scan that ran out of time
+var ErrDeadlinePartial = errors.New("scan stopped at its deadline, results are partial")
+
+// PartialError is returned, with the results gathered so far, by a scan that stopped at its deadline
+// or because its context was canceled. Passing Cursor to the From variant of the scan, GetByPrefixFrom
+// or GetKeyListFrom, picks up where it stopped.
+type PartialError struct {
+	// Cursor is the key the scan stopped at, the first one not in the results
+	Cursor string
+	// Err is context.DeadlineExceeded, or the error of the context
+	Err error
+}
+
+func (e *PartialError) Error() string {
+	return ErrDeadlinePartial.Error() + ": resume at " + e.Cursor + ": " + e.Err.Error()
+}
+
+// Unwrap returns ErrDeadlinePartial and the cause
+func (e *PartialError) Unwrap() []error {
+	return []error{ErrDeadlinePartial, e.Err}
+}
+
+// WithScanBudget bounds every scan, GetByPrefix and GetKeyList, to d. A scan still running after d
+// returns what it gathered with a PartialError instead of going on. Scans given a context stop at its
+// deadline as well, whichever comes first.
+func WithScanBudget(d time.Duration) Option {
+	return func(bl *BoltLocknut) {
+		bl.scanBudget = d
+	}
+}
+
+// scanClock tells a scan when to stop
+type scanClock struct {
+	ctx      context.Context
+	deadline time.Time
+}
+
+func (bl *BoltLocknut) startScan(ctx context.Context) scanClock {
+	c := scanClock{ctx: ctx}
+	if bl.scanBudget > 0 {
+		c.deadline = time.Now().Add(bl.scanBudget)
+	}
+	if d, ok := ctx.Deadline(); ok && (c.deadline.IsZero() || d.Before(c.deadline)) {
+		c.deadline = d
+	}
+	return c
+}
+
+// stop returns a PartialError resuming at key once the scan is out of time. Scans gather at least one
+// result, so resuming them always makes progress.
+func (c scanClock) stop(key []byte, gathered int) error {
+	if gathered == 0 {
+		return nil
+	}
+	if err := c.ctx.Err(); err != nil {
+		return &PartialError{Cursor: string(key), Err: err}
+	}
+	if !c.deadline.IsZero() && !time.Now().Before(c.deadline) {
+		return &PartialError{Cursor: string(key), Err: context.DeadlineExceeded}
+	}
+	return nil
+}
+
+// scanStart returns the key a scan of prefix seeks to, cursor when it resumes an earlier scan
+func scanStart(prefix, cursor string) []byte {
+	if cursor > prefix {
+		return []byte(cursor)
+	}
+	return []byte(prefix)
+}
@@ -0,0 +1,82 @@
+package locknut
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestScanDeadline(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("deadline_test.db")
+
+	bl, err := NewBoltLocknut("deadline_test.db", ".", []byte("secret"), true, []string{"kids"})
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	for i := 0; i < 20; i++ {
+		if err = bl.Save("kids", fmt.Sprintf("kid-%02d", i), i); err != nil {
+			t.Fatalf("Save: %s", err)
+		}
+	}
+
+	// a context that is already done lets every scan gather one record
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	all := make(map[string][]byte)
+	cursor, calls := "", 0
+	for {
+		calls++
+		recs, err := bl.GetByPrefixFrom(ctx, "kids", "kid-", cursor)
+		for k, v := range recs {
+			all[k] = v
+		}
+		var partial *PartialError
+		if !errors.As(err, &partial) {
+			if err != nil {
+				t.Fatalf("GetByPrefixFrom: %s", err)
+			}
+			break
+		}
+		if !errors.Is(err, ErrDeadlinePartial) || !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected partial error %v", err)
+		}
+		if len(recs) != 1 {
+			t.Fatalf("expected one record per scan, got %d", len(recs))
+		}
+		cursor = partial.Cursor
+	}
+	if len(all) != 20 || calls != 20 {
+		t.Errorf("expected 20 records in 20 scans, got %d in %d", len(all), calls)
+	}
+
+	keys, err := bl.GetKeyListFrom(context.Background(), "kids", "kid-", "kid-15")
+	if err != nil || len(keys) != 5 || keys[0] != "kid-15" {
+		t.Errorf("unexpected resumed key list %v %v", keys, err)
+	}
+}
+
+func TestScanBudget(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("deadline_test.db")
+
+	bl, err := NewBoltLocknut("deadline_test.db", ".", []byte("secret"), true, []string{"kids"},
+		WithScanBudget(time.Nanosecond))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	bl.Save("kids", "a", 1)
+	bl.Save("kids", "b", 2)
+
+	keys, err := bl.GetKeyList("kids", "")
+	var partial *PartialError
+	if !errors.As(err, &partial) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a PartialError, got %v", err)
+	}
+	if len(keys) != 1 || keys[0] != "a" || partial.Cursor != "b" {
+		t.Errorf("unexpected partial result %v resuming at %q", keys, partial.Cursor)
+	}
+}
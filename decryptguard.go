@@ -0,0 +1,98 @@
+package locknut
+
+import (
+	"sync"
+	"time"
+)
+
+// DecryptFailure describes a failure that took the consecutive decrypt failures of a BoltLocknut past
+// the threshold set WithDecryptAlert
+type DecryptFailure struct {
+	// Consecutive is the number of failures in a row, this one included
+	Consecutive int
+	// Op is "decrypt" for a record failing authentication and "unlock" for a wrong secret given to Unlock
+	Op     string
+	Bucket string
+	Key    string
+	Err    error
+	Time   time.Time
+}
+
+// decryptGuard counts the decrypt failures not followed by a success
+type decryptGuard struct {
+	mu          sync.Mutex
+	consecutive int
+	threshold   int
+	alert       func(DecryptFailure)
+	backoff     time.Duration
+	maxBackoff  time.Duration
+}
+
+// WithDecryptAlert calls alert for every decrypt failure once more than threshold happened in a row.
+// Records failing authentication mean the file was corrupted or tampered with, wrong secrets given to
+// Unlock that someone is guessing the passphrase. A successful decrypt or Unlock resets the count.
+// alert is called inline, it should hand the failure off rather than block.
+func WithDecryptAlert(threshold int, alert func(DecryptFailure)) Option {
+	return func(bl *BoltLocknut) {
+		bl.decryptGuard.threshold = threshold
+		bl.decryptGuard.alert = alert
+	}
+}
+
+// WithUnlockBackoff makes Unlock wait before trying a secret once the consecutive decrypt failures are
+// past the threshold of WithDecryptAlert, or from the first failure without one. The wait starts at
+// base and doubles with each further failure, up to max unless it is 0.
+func WithUnlockBackoff(base, max time.Duration) Option {
+	return func(bl *BoltLocknut) {
+		bl.decryptGuard.backoff = base
+		bl.decryptGuard.maxBackoff = max
+	}
+}
+
+// DecryptFailures returns the number of decrypt failures since the last success
+func (bl *BoltLocknut) DecryptFailures() int {
+	g := &bl.decryptGuard
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.consecutive
+}
+
+// decryptFailed counts a failure and alerts when past the threshold
+func (bl *BoltLocknut) decryptFailed(op, bucket, key string, err error) {
+	g := &bl.decryptGuard
+	g.mu.Lock()
+	g.consecutive++
+	n := g.consecutive
+	g.mu.Unlock()
+
+	if g.alert != nil && n > g.threshold {
+		g.alert(DecryptFailure{Consecutive: n, Op: op, Bucket: bucket, Key: key, Err: err, Time: bl.now()})
+	}
+}
+
+// decryptSucceeded resets the count of failures
+func (bl *BoltLocknut) decryptSucceeded() {
+	g := &bl.decryptGuard
+	g.mu.Lock()
+	g.consecutive = 0
+	g.mu.Unlock()
+}
+
+// unlockDelay returns how long Unlock waits before trying a secret
+func (bl *BoltLocknut) unlockDelay() time.Duration {
+	g := &bl.decryptGuard
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	over := g.consecutive - g.threshold
+	if g.backoff <= 0 || over <= 0 {
+		return 0
+	}
+	d := g.backoff
+	for i := 1; i < over && (g.maxBackoff <= 0 || d < g.maxBackoff); i++ {
+		d *= 2
+	}
+	if g.maxBackoff > 0 && d > g.maxBackoff {
+		d = g.maxBackoff
+	}
+	return d
+}
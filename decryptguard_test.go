@@ -0,0 +1,95 @@
+package locknut
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDecryptAlert(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("decryptguard_test.db")
+
+	var alerts []DecryptFailure
+	bl, err := NewBoltLocknut("decryptguard_test.db", ".", []byte("secret"), false, []string{"notes"},
+		WithDecryptAlert(2, func(f DecryptFailure) { alerts = append(alerts, f) }))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if err = bl.Save("notes", "n", "hello"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	// flip a byte of the ciphertext
+	err = bl.write(func(w *writeTx) error {
+		bkt := w.tx.Bucket([]byte("notes"))
+		v := append([]byte(nil), bkt.Get([]byte("n"))...)
+		v[len(v)-1] ^= 1
+		return bkt.Put([]byte("n"), v)
+	})
+	if err != nil {
+		t.Fatalf("tamper: %s", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err = bl.Get("notes", "n"); !errors.Is(err, ErrDecrypt) {
+			t.Fatalf("expected ErrDecrypt, got %v", err)
+		}
+	}
+	if len(alerts) != 0 {
+		t.Fatalf("expected no alert up to the threshold, got %+v", alerts)
+	}
+	if err = bl.Unlock([]byte("guess")); !errors.Is(err, ErrWrongSecret) {
+		t.Fatalf("expected ErrWrongSecret, got %v", err)
+	}
+	if len(alerts) != 1 || alerts[0].Consecutive != 3 || alerts[0].Op != "unlock" {
+		t.Fatalf("unexpected alerts %+v", alerts)
+	}
+	if _, err = bl.Get("notes", "n"); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("expected ErrDecrypt, got %v", err)
+	}
+	if len(alerts) != 2 || alerts[1].Op != "decrypt" || alerts[1].Bucket != "notes" || alerts[1].Key != "n" {
+		t.Fatalf("unexpected alerts %+v", alerts)
+	}
+
+	// a success resets the count
+	if err = bl.Save("notes", "m", "fine"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if _, err = bl.Get("notes", "m"); err != nil {
+		t.Fatalf("Get: %s", err)
+	}
+	if n := bl.DecryptFailures(); n != 0 {
+		t.Errorf("expected the failures reset, got %d", n)
+	}
+}
+
+func TestUnlockBackoff(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("unlockbackoff_test.db")
+
+	bl, err := NewBoltLocknut("unlockbackoff_test.db", ".", []byte("secret"), false, []string{"notes"},
+		WithDecryptAlert(1, nil), WithUnlockBackoff(10*time.Millisecond, 25*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if err = bl.Save("notes", "n", "hello"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+
+	want := []time.Duration{0, 0, 10 * time.Millisecond, 20 * time.Millisecond, 25 * time.Millisecond}
+	for i, d := range want {
+		if got := bl.unlockDelay(); got != d {
+			t.Errorf("%d: expected a delay of %s, got %s", i, d, got)
+		}
+		if err = bl.Unlock([]byte("guess")); !errors.Is(err, ErrWrongSecret) {
+			t.Fatalf("expected ErrWrongSecret, got %v", err)
+		}
+	}
+	if err = bl.Unlock([]byte("secret")); err != nil {
+		t.Fatalf("Unlock: %s", err)
+	}
+	if d := bl.unlockDelay(); d != 0 {
+		t.Errorf("expected no delay after the right secret, got %s", d)
+	}
+}
@@ -0,0 +1,300 @@
+package locknut
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"hash"
+	"net/http"
+	"net/url"
+)
+
+// DigestSegments is the number of segments a bucket is split into for delta sync
+const DigestSegments = 256
+
+// BucketDigest summarizes a bucket for delta sync. Records are spread over DigestSegments segments
+// by a keyed hash of their key, and each segment hash covers the keys and plaintext of its records.
+// Hashes are keyed with the db secret, so a digest reveals nothing to a peer without it.
+type BucketDigest struct {
+	Bucket   string   `json:"bucket"`
+	Segments [][]byte `json:"segments"`
+}
+
+// SyncPeer is the other side of a delta sync, a local db or a remote SyncHandler
+type SyncPeer interface {
+	Digest(bucket string) (*BucketDigest, error)
+	// SegmentRecords returns the records of the given segments as puts holding their stored bytes
+	SegmentRecords(bucket string, segments []int) ([]Change, error)
+}
+
+func (bl *BoltLocknut) syncKey() []byte {
+	return HKDF(bl.secret, nil, []byte("locknut delta sync"), 32)
+}
+
+func segmentOf(mac []byte, key []byte) int {
+	h := hmac.New(sha256.New, mac)
+	h.Write(key)
+	return int(h.Sum(nil)[0]) % DigestSegments
+}
+
+// Digest computes the segment hashes of bucket
+func (bl *BoltLocknut) Digest(bucket string) (*BucketDigest, error) {
+	var err error
+	if err = bl.openDB(); err != nil {
+		return nil, err
+	}
+	defer bl.closeDB()
+
+	mac := bl.syncKey()
+	hashes := make([]hash.Hash, DigestSegments)
+	err = bl.db.view(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket([]byte(bucket))
+		if bkt == nil {
+			return bucketNotFound("digest", bucket)
+		}
+		return bkt.ForEach(func(k, v []byte) error {
+			if v == nil {
+				return nil
+			}
+			dec, err := bl.decrypt(bucket, string(k), v)
+			if err != nil {
+				return err
+			}
+
+			// ForEach walks keys in order, so every peer feeds the segment hashes in the same order
+			seg := segmentOf(mac, k)
+			if hashes[seg] == nil {
+				hashes[seg] = hmac.New(sha256.New, mac)
+			}
+			value := sha256.Sum256(dec)
+			hashes[seg].Write([]byte(fmt.Sprintf("%d:", len(k))))
+			hashes[seg].Write(k)
+			hashes[seg].Write(value[:])
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	d := &BucketDigest{Bucket: bucket, Segments: make([][]byte, DigestSegments)}
+	for i, h := range hashes {
+		if h != nil {
+			d.Segments[i] = h.Sum(nil)
+		}
+	}
+	return d, nil
+}
+
+// SegmentRecords returns the records of bucket that fall in the given segments
+func (bl *BoltLocknut) SegmentRecords(bucket string, segments []int) ([]Change, error) {
+	var err error
+	if err = bl.openDB(); err != nil {
+		return nil, err
+	}
+	defer bl.closeDB()
+
+	wanted := make(map[int]bool, len(segments))
+	for _, s := range segments {
+		wanted[s] = true
+	}
+
+	mac := bl.syncKey()
+	records := make([]Change, 0)
+	err = bl.db.view(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket([]byte(bucket))
+		if bkt == nil {
+			return bucketNotFound("segments", bucket)
+		}
+		return bkt.ForEach(func(k, v []byte) error {
+			if v == nil || !wanted[segmentOf(mac, k)] {
+				return nil
+			}
+			stored := make([]byte, len(v))
+			copy(stored, v)
+			records = append(records, Change{Op: OpPut, Bucket: bucket, Key: string(k), Value: stored})
+			return nil
+		})
+	})
+	return records, err
+}
+
+// DiffSegments returns the segments whose hashes differ between two digests of the same bucket
+func DiffSegments(a, b *BucketDigest) []int {
+	diff := make([]int, 0)
+	for i := 0; i < DigestSegments; i++ {
+		var x, y []byte
+		if i < len(a.Segments) {
+			x = a.Segments[i]
+		}
+		if i < len(b.Segments) {
+			y = b.Segments[i]
+		}
+		if !bytes.Equal(x, y) {
+			diff = append(diff, i)
+		}
+	}
+	return diff
+}
+
+// PullBucket makes bucket match the same bucket on peer, transferring only the records of segments
+// whose hashes differ. Local records missing on the peer are deleted. Both sides must share the
+// secret. It returns the number of records written or deleted.
+func (bl *BoltLocknut) PullBucket(peer SyncPeer, bucket string) (int, error) {
+	remote, err := peer.Digest(bucket)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = bl.CreateBucket(bucket); err != nil {
+		return 0, err
+	}
+	local, err := bl.Digest(bucket)
+	if err != nil {
+		return 0, err
+	}
+
+	diff := DiffSegments(local, remote)
+	if len(diff) == 0 {
+		return 0, nil
+	}
+
+	records, err := peer.SegmentRecords(bucket, diff)
+	if err != nil {
+		return 0, err
+	}
+
+	mac := bl.syncKey()
+	differs := make(map[int]bool, len(diff))
+	for _, s := range diff {
+		differs[s] = true
+	}
+	keep := make(map[string]bool, len(records))
+	for _, r := range records {
+		keep[r.Key] = true
+	}
+
+	n := 0
+	err = bl.write(func(w *writeTx) error {
+		var stale []string
+		err := w.tx.Bucket([]byte(bucket)).ForEach(func(k, v []byte) error {
+			if v != nil && differs[segmentOf(mac, k)] && !keep[string(k)] {
+				stale = append(stale, string(k))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range stale {
+			if err = w.delete(bucket, k); err != nil {
+				return err
+			}
+		}
+
+		for _, r := range records {
+			if err = w.putStored(bucket, r.Key, r.Value); err != nil {
+				return err
+			}
+		}
+		n = len(stale) + len(records)
+		return nil
+	})
+	return n, err
+}
+
+// SyncHandler serves bl as a SyncPeer over http: GET ?bucket= returns the digest and POST ?bucket=
+// with a JSON array of segments returns their records. It does no authentication, mount it behind
+// your own.
+func SyncHandler(bl *BoltLocknut) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bucket := r.URL.Query().Get("bucket")
+
+		var body interface{}
+		var err error
+		switch r.Method {
+		case http.MethodGet:
+			body, err = bl.Digest(bucket)
+		case http.MethodPost:
+			var segments []int
+			if err = json.NewDecoder(r.Body).Decode(&segments); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			body, err = bl.SegmentRecords(bucket, segments)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(body)
+	})
+}
+
+// httpSyncPeer talks to a remote SyncHandler
+type httpSyncPeer struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPSyncPeer returns a SyncPeer for a SyncHandler mounted at url. If client is nil
+// http.DefaultClient is used.
+func NewHTTPSyncPeer(url string, client *http.Client) SyncPeer {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return httpSyncPeer{url: url, client: client}
+}
+
+func (p httpSyncPeer) bucketURL(bucket string) string {
+	return p.url + "?bucket=" + url.QueryEscape(bucket)
+}
+
+func (p httpSyncPeer) Digest(bucket string) (*BucketDigest, error) {
+	res, err := p.client.Get(p.bucketURL(bucket))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("sync peer returned %s", res.Status)
+	}
+
+	var d BucketDigest
+	if err = json.NewDecoder(res.Body).Decode(&d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (p httpSyncPeer) SegmentRecords(bucket string, segments []int) ([]Change, error) {
+	body, err := json.Marshal(segments)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := p.client.Post(p.bucketURL(bucket), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("sync peer returned %s", res.Status)
+	}
+
+	var records []Change
+	if err = json.NewDecoder(res.Body).Decode(&records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
@@ -0,0 +1,68 @@
+package locknut
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestPullBucket(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	bucketName := "article"
+	defer os.Remove("sync_a.db")
+	defer os.Remove("sync_b.db")
+
+	a, err := NewBoltLocknut("sync_a.db", ".", []byte("secret"), false, []string{bucketName})
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	b, err := NewBoltLocknut("sync_b.db", ".", []byte("secret"), false, []string{bucketName})
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		id := fmt.Sprintf("ID-%04d", i)
+		if err = a.Save(bucketName, id, Article{ID: id, Title: "same"}); err != nil {
+			t.Fatalf("Save: %s", err)
+		}
+		if err = b.Save(bucketName, id, Article{ID: id, Title: "same"}); err != nil {
+			t.Fatalf("Save: %s", err)
+		}
+	}
+
+	// identical plaintext encrypted with different nonces still has identical digests
+	da, _ := a.Digest(bucketName)
+	db, _ := b.Digest(bucketName)
+	if diff := DiffSegments(da, db); len(diff) != 0 {
+		t.Fatalf("expected identical digests, %d segments differ", len(diff))
+	}
+
+	if err = a.Save(bucketName, "ID-0007", Article{ID: "ID-0007", Title: "changed"}); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if err = a.Delete(bucketName, "ID-0042"); err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+
+	srv := httptest.NewServer(SyncHandler(a))
+	defer srv.Close()
+
+	n, err := b.PullBucket(NewHTTPSyncPeer(srv.URL, nil), bucketName)
+	if err != nil {
+		t.Fatalf("PullBucket: %s", err)
+	}
+	if n == 0 || n > 10 {
+		t.Errorf("expected only the differing segments to be transferred, got %d records", n)
+	}
+
+	da, _ = a.Digest(bucketName)
+	db, _ = b.Digest(bucketName)
+	if diff := DiffSegments(da, db); len(diff) != 0 {
+		t.Errorf("digests still differ after pull: %v", diff)
+	}
+	if v, _ := b.Get(bucketName, "ID-0042"); v != nil {
+		t.Errorf("deleted record was not removed")
+	}
+}
@@ -0,0 +1,139 @@
+package locknut
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.etcd.io/bbolt"
+)
+
+// ErrNotDeterministic is returned by FindEqual and FindByField for values that are not encrypted
+// deterministically, see WithDeterministic
+var ErrNotDeterministic = errors.New("values are not encrypted deterministically")
+
+// WithDeterministic encrypts the records of buckets deterministically: equal values give equal
+// ciphertext, so FindEqual finds records by value without decrypting them, and records of different
+// buckets can be joined on their stored bytes. Single fields of structs are encrypted the same way by
+// tagging them locknut:"encrypt,deterministic", and found with FindByField.
+//
+// This leaks equality: anyone reading the file learns which records hold the same value, and how many
+// share it, though not the value. Use it only for values with many possible values, such as emails or
+// account numbers, never for ones with few, such as booleans or a status, whose frequencies give them
+// away. Values are encrypted with AES-SIV (RFC 5297) under keys derived from the secret, and read with
+// Decrypt like the others. It is not available with WithPublicKey, whose records are always sealed
+// with a fresh key.
+func WithDeterministic(buckets ...string) Option {
+	return func(bl *BoltLocknut) {
+		if bl.deterministic == nil {
+			bl.deterministic = make(map[string]bool)
+		}
+		for _, b := range buckets {
+			bl.deterministic[b] = true
+		}
+	}
+}
+
+// isDeterministic reports whether the records of bucket are encrypted deterministically
+func (bl *BoltLocknut) isDeterministic(bucket string) bool {
+	return bl.deterministic[bucket] && bl.public == nil
+}
+
+// sealFor encrypts data the way records of bucket are stored
+func (bl *BoltLocknut) sealFor(bucket string, data []byte) ([]byte, error) {
+	return bl.sealValue(bucket, data, bl.isDeterministic(bucket))
+}
+
+// sealValue transforms and pads data and encrypts it for bucket, deterministically when asked and the
+// db allows it
+func (bl *BoltLocknut) sealValue(bucket string, data []byte, deterministic bool) ([]byte, error) {
+	data, framed, err := bl.prepare(data)
+	if err != nil {
+		return nil, err
+	}
+	var ct []byte
+	if deterministic && bl.public == nil {
+		ct, err = encryptDeterministic(data, bl.keyFor(bucket))
+	} else {
+		ct, err = bl.seal(bucket, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return markFrame(ct, framed), nil
+}
+
+// FindEqual returns the keys of the records of bucket whose value is v, encoded as Save would encode
+// it. The bucket is scanned comparing stored bytes, nothing is decrypted, so it takes a bucket given
+// to WithDeterministic, or a db without a secret.
+func (bl *BoltLocknut) FindEqual(bucket string, v interface{}) ([]string, error) {
+	if bl.secret != nil && !bl.isDeterministic(bucket) {
+		return nil, fmt.Errorf("%w: bucket %s", ErrNotDeterministic, bucket)
+	}
+	data, err := bl.encode(bucket, v)
+	if err != nil {
+		return nil, err
+	}
+	want := data
+	if bl.secret != nil {
+		if want, err = bl.sealValue(bucket, data, true); err != nil {
+			return nil, err
+		}
+	}
+	return bl.findStored(bucket, func(stored []byte) bool {
+		return bytes.Equal(stored, want)
+	})
+}
+
+// FindByField returns the keys of the records of bucket whose field, tagged
+// locknut:"encrypt,deterministic", holds v. field is the json name of the field. Records are matched
+// on the stored ciphertext of the field without decrypting them.
+func (bl *BoltLocknut) FindByField(bucket, field string, v interface{}) ([]string, error) {
+	if bl.secret == nil || bl.public != nil {
+		return nil, fmt.Errorf("%w: field %s", ErrNotDeterministic, field)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	ct, err := bl.sealValue(bucket, data, true)
+	if err != nil {
+		return nil, err
+	}
+	want, err := sealedField(ct, true)
+	if err != nil {
+		return nil, err
+	}
+	return bl.findStored(bucket, func(stored []byte) bool {
+		if !isFieldSealed(stored) {
+			return false
+		}
+		var doc map[string]json.RawMessage
+		if json.Unmarshal(stored[len(fieldSealedMagic):], &doc) != nil {
+			return false
+		}
+		return bytes.Equal(doc[field], want)
+	})
+}
+
+// findStored returns the keys of the records of bucket whose stored value match
+func (bl *BoltLocknut) findStored(bucket string, match func(stored []byte) bool) (keys []string, err error) {
+	if err = bl.openDB(); err != nil {
+		return nil, err
+	}
+	defer bl.closeDB()
+
+	err = bl.db.view(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket([]byte(bucket))
+		if bkt == nil {
+			return bucketNotFound("find", bucket)
+		}
+		return bkt.ForEach(func(k, v []byte) error {
+			if v != nil && match(v) {
+				keys = append(keys, string(k))
+			}
+			return nil
+		})
+	})
+	return keys, err
+}
@@ -0,0 +1,117 @@
+package locknut
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+type account struct {
+	Owner string `json:"owner"`
+	Email string `json:"email" locknut:"encrypt,deterministic"`
+	IBAN  string `json:"iban" locknut:"encrypt"`
+}
+
+func TestDeterministic(t *testing.T) {
+	skipFIPS(t, "deterministic encryption")
+	defer os.Remove("deterministic_test.db")
+
+	bl, err := NewBoltLocknut("deterministic_test.db", ".", []byte("secret"), false, []string{"emails", "logins", "notes"},
+		WithDeterministic("emails", "logins"))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	for key, email := range map[string]string{"a": "ada@example.com", "b": "bob@example.com", "c": "ada@example.com"} {
+		if err = bl.Save("emails", key, email); err != nil {
+			t.Fatalf("Save: %s", err)
+		}
+	}
+	if err = bl.Save("logins", "ada", "ada@example.com"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+
+	// equal values are stored equal, across buckets too, and still read back
+	if a, c := storedValue(t, bl, "emails", "a"), storedValue(t, bl, "logins", "ada"); !bytes.Equal(a, c) {
+		t.Errorf("expected equal ciphertext, got %x and %x", a, c)
+	}
+	if v, err := bl.Get("emails", "a"); err != nil || string(v) != `"ada@example.com"` {
+		t.Errorf("unexpected record %s %v", v, err)
+	}
+
+	find := func(bucket string, v interface{}, want ...string) {
+		t.Helper()
+		keys, err := bl.FindEqual(bucket, v)
+		if err != nil {
+			t.Fatalf("FindEqual: %s", err)
+		}
+		sort.Strings(keys)
+		if !reflect.DeepEqual(keys, want) {
+			t.Errorf("expected %v, got %v", want, keys)
+		}
+	}
+	find("emails", "ada@example.com", "a", "c")
+	find("emails", "eve@example.com")
+
+	// the rotated key keeps values deterministic
+	if err = bl.RotateKey([]byte("new secret")); err != nil {
+		t.Fatalf("RotateKey: %s", err)
+	}
+	find("emails", "ada@example.com", "a", "c")
+	if err = bl.Save("emails", "d", "bob@example.com"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	find("emails", "bob@example.com", "b", "d")
+
+	if err = bl.Save("notes", "n", "hello"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if _, err = bl.FindEqual("notes", "hello"); !errors.Is(err, ErrNotDeterministic) {
+		t.Errorf("expected ErrNotDeterministic, got %v", err)
+	}
+}
+
+func TestDeterministicFields(t *testing.T) {
+	skipFIPS(t, "deterministic encryption")
+	defer os.Remove("deterministic_fields_test.db")
+
+	bl, err := NewBoltLocknut("deterministic_fields_test.db", ".", []byte("secret"), false, []string{"accounts"})
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	accounts := map[string]account{
+		"1": {Owner: "Ada", Email: "ada@example.com", IBAN: "DE00 0000"},
+		"2": {Owner: "Bob", Email: "bob@example.com", IBAN: "DE00 0000"},
+		"3": {Owner: "Ada", Email: "ada@example.com", IBAN: "FR00 0000"},
+	}
+	for key, a := range accounts {
+		if err = bl.Save("accounts", key, a); err != nil {
+			t.Fatalf("Save: %s", err)
+		}
+	}
+	if bytes.Contains(storedValue(t, bl, "accounts", "1"), []byte("ada@example.com")) {
+		t.Error("expected the email to be encrypted")
+	}
+
+	find := func(field string, v interface{}, want ...string) {
+		t.Helper()
+		keys, err := bl.FindByField("accounts", field, v)
+		if err != nil {
+			t.Fatalf("FindByField: %s", err)
+		}
+		sort.Strings(keys)
+		if !reflect.DeepEqual(keys, want) {
+			t.Errorf("expected %v, got %v", want, keys)
+		}
+	}
+	find("email", "ada@example.com", "1", "3")
+	// fields encrypted with a fresh nonce never match
+	find("iban", "DE00 0000")
+
+	if err = bl.RotateKey([]byte("new secret")); err != nil {
+		t.Fatalf("RotateKey: %s", err)
+	}
+	find("email", "bob@example.com", "2")
+}
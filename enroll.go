@@ -0,0 +1,167 @@
+package locknut
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// The prefixes of the encoded enrollment messages
+const (
+	enrollRequestPrefix = "locknut-enroll:"
+	enrollGrantPrefix   = "locknut-grant:"
+)
+
+// ErrEnrollment is returned for malformed enrollment requests and grants, or grants that were not
+// made for the request
+var ErrEnrollment = errors.New("invalid enrollment message")
+
+// EnrollmentRequest is created on a new device that wants to join a sync group. Its Encode string is
+// shown as a QR code or pasted to a device that already holds the data key, which answers with a
+// grant only this request can open.
+//
+// The request must reach the approving device over a channel the user trusts, such as scanning the
+// QR code. Both devices show the same Fingerprint so the user can confirm it was not swapped on the
+// way. The grant may travel over any channel.
+type EnrollmentRequest struct {
+	Device string
+	priv   *ecdh.PrivateKey
+}
+
+// Enrollment is an EnrollmentRequest as received by the approving device
+type Enrollment struct {
+	Device string
+	pub    *ecdh.PublicKey
+}
+
+type enrollMessage struct {
+	Device string `json:"device,omitempty"`
+	Key    []byte `json:"key"`
+	Sealed []byte `json:"sealed,omitempty"`
+}
+
+func encodeEnrollMessage(prefix string, m enrollMessage) (string, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodeEnrollMessage(prefix, s string) (enrollMessage, error) {
+	var m enrollMessage
+	if !strings.HasPrefix(s, prefix) {
+		return m, ErrEnrollment
+	}
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(s, prefix))
+	if err != nil {
+		return m, ErrEnrollment
+	}
+	if err = json.Unmarshal(b, &m); err != nil {
+		return m, ErrEnrollment
+	}
+	return m, nil
+}
+
+func fingerprint(pub *ecdh.PublicKey) string {
+	sum := sha256.Sum256(pub.Bytes())
+	code := base32.StdEncoding.EncodeToString(sum[:])[:12]
+	return code[:4] + "-" + code[4:8] + "-" + code[8:]
+}
+
+// enrollKey derives the key wrapping the secret from the X25519 shared secret and both public keys
+func enrollKey(shared, ephemeral, device []byte) []byte {
+	salt := append(append([]byte(nil), ephemeral...), device...)
+	return HKDF(shared, salt, []byte("locknut enrollment"), 32)
+}
+
+// NewEnrollmentRequest creates a request for the device with a fresh X25519 key pair
+func NewEnrollmentRequest(device string) (*EnrollmentRequest, error) {
+	if err := notFIPSBuild("X25519 enrollment"); err != nil {
+		return nil, err
+	}
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &EnrollmentRequest{Device: device, priv: priv}, nil
+}
+
+// Encode returns the request as a short string suitable for a QR code
+func (r *EnrollmentRequest) Encode() (string, error) {
+	return encodeEnrollMessage(enrollRequestPrefix, enrollMessage{Device: r.Device, Key: r.priv.PublicKey().Bytes()})
+}
+
+// Fingerprint returns the code the user compares with the one shown on the approving device
+func (r *EnrollmentRequest) Fingerprint() string {
+	return fingerprint(r.priv.PublicKey())
+}
+
+// Accept opens a grant made for this request and returns the data key
+func (r *EnrollmentRequest) Accept(grant string) ([]byte, error) {
+	m, err := decodeEnrollMessage(enrollGrantPrefix, grant)
+	if err != nil {
+		return nil, err
+	}
+
+	ephemeral, err := ecdh.X25519().NewPublicKey(m.Key)
+	if err != nil {
+		return nil, ErrEnrollment
+	}
+	shared, err := r.priv.ECDH(ephemeral)
+	if err != nil {
+		return nil, ErrEnrollment
+	}
+
+	secret, err := Decrypt(m.Sealed, enrollKey(shared, m.Key, r.priv.PublicKey().Bytes()))
+	if err != nil {
+		return nil, ErrEnrollment
+	}
+	return secret, nil
+}
+
+// ParseEnrollmentRequest decodes a request produced by EnrollmentRequest.Encode
+func ParseEnrollmentRequest(s string) (*Enrollment, error) {
+	if err := notFIPSBuild("X25519 enrollment"); err != nil {
+		return nil, err
+	}
+	m, err := decodeEnrollMessage(enrollRequestPrefix, s)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := ecdh.X25519().NewPublicKey(m.Key)
+	if err != nil {
+		return nil, ErrEnrollment
+	}
+	return &Enrollment{Device: m.Device, pub: pub}, nil
+}
+
+// Fingerprint returns the code the user compares with the one shown on the new device
+func (e *Enrollment) Fingerprint() string {
+	return fingerprint(e.pub)
+}
+
+// Grant wraps secret to the requesting device with an ephemeral X25519 key and AES-GCM. A device
+// joining a db WithKDF also needs its KDFRecord, see WithKDFRecord, which is not secret.
+func (e *Enrollment) Grant(secret []byte) (string, error) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	shared, err := priv.ECDH(e.pub)
+	if err != nil {
+		return "", err
+	}
+
+	ephemeral := priv.PublicKey().Bytes()
+	sealed, err := Encrypt(secret, enrollKey(shared, ephemeral, e.pub.Bytes()))
+	if err != nil {
+		return "", err
+	}
+	return encodeEnrollMessage(enrollGrantPrefix, enrollMessage{Key: ephemeral, Sealed: sealed})
+}
@@ -0,0 +1,51 @@
+package locknut
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEnrollment(t *testing.T) {
+	skipFIPS(t, "X25519 enrollment")
+	secret, err := GetRandKey()
+	if err != nil {
+		t.Fatalf("GetRandKey: %s", err)
+	}
+
+	req, err := NewEnrollmentRequest("laptop")
+	if err != nil {
+		t.Fatalf("NewEnrollmentRequest: %s", err)
+	}
+	code, err := req.Encode()
+	if err != nil {
+		t.Fatalf("Encode: %s", err)
+	}
+
+	e, err := ParseEnrollmentRequest(code)
+	if err != nil {
+		t.Fatalf("ParseEnrollmentRequest: %s", err)
+	}
+	if e.Device != "laptop" || e.Fingerprint() != req.Fingerprint() {
+		t.Errorf("request changed in transit: %s %s %s", e.Device, e.Fingerprint(), req.Fingerprint())
+	}
+
+	grant, err := e.Grant(secret)
+	if err != nil {
+		t.Fatalf("Grant: %s", err)
+	}
+	got, err := req.Accept(grant)
+	if err != nil {
+		t.Fatalf("Accept: %s", err)
+	}
+	if !bytes.Equal(got, secret) {
+		t.Errorf("received a different secret")
+	}
+
+	other, err := NewEnrollmentRequest("phone")
+	if err != nil {
+		t.Fatalf("NewEnrollmentRequest: %s", err)
+	}
+	if _, err = other.Accept(grant); err != ErrEnrollment {
+		t.Errorf("grant opened by another device: %v", err)
+	}
+}
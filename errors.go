@@ -0,0 +1,59 @@
+package locknut
+
+import (
+	"errors"
+	"go.etcd.io/bbolt"
+)
+
+// The kinds of RecordError, compare with errors.Is
+var (
+	// ErrBucketNotFound is returned for operations on a bucket that does not exist. It is
+	// bbolt.ErrBucketNotFound, so checks against either keep working.
+	ErrBucketNotFound = bbolt.ErrBucketNotFound
+	// ErrEncrypt is returned when a value could not be encrypted
+	ErrEncrypt = errors.New("encrypt failed")
+	// ErrDecrypt is returned when a record fails authentication with the secret. NewBoltLocknut
+	// rejects a wrong secret with ErrWrongSecret, so past it this means the record was modified.
+	ErrDecrypt = errors.New("decrypt failed")
+	// ErrCorrupt is returned when a stored value is not a well formed ciphertext
+	ErrCorrupt = errors.New("corrupt record")
+	// ErrUnknownBucket is returned for writes to a bucket WithStrictSchema does not declare
+	ErrUnknownBucket = errors.New("bucket is not declared")
+	// ErrKeyRejected is returned for writes under a key that does not match the WithKeyPattern of its
+	// bucket
+	ErrKeyRejected = errors.New("key does not match the bucket's pattern")
+)
+
+// RecordError is the error of an operation on a bucket or a record. It matches its Kind and its cause
+// with errors.Is and errors.As.
+type RecordError struct {
+	Op     string
+	Bucket string
+	Key    string
+	Kind   error
+	Err    error
+}
+
+func (e *RecordError) Error() string {
+	s := e.Op + " " + e.Bucket
+	if e.Key != "" {
+		s += "/" + e.Key
+	}
+	s += ": " + e.Kind.Error()
+	if e.Err != nil {
+		s += ": " + e.Err.Error()
+	}
+	return s
+}
+
+// Unwrap returns the kind and the cause of the error
+func (e *RecordError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+func bucketNotFound(op, bucket string) error {
+	return &RecordError{Op: op, Bucket: bucket, Kind: ErrBucketNotFound}
+}
@@ -0,0 +1,57 @@
+package locknut
+
+import (
+	"errors"
+	"go.etcd.io/bbolt"
+	"os"
+	"testing"
+)
+
+func TestRecordErrors(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("errors_test.db")
+
+	bl, err := NewBoltLocknut("errors_test.db", ".", []byte("secret"), false, []string{"articles"})
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if err = bl.Save("articles", "a1", Article{ID: "a1", Title: "one"}); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+
+	_, err = bl.GetOne("missing", "a1")
+	var re *RecordError
+	if !errors.Is(err, ErrBucketNotFound) || !errors.Is(err, bbolt.ErrBucketNotFound) || !errors.As(err, &re) || re.Bucket != "missing" {
+		t.Errorf("expected a bucket not found error for missing, got %v", err)
+	}
+
+	// a modified record fails authentication, a value too short to hold a nonce is corrupt
+	if err = bl.openDB(); err != nil {
+		t.Fatalf("openDB: %s", err)
+	}
+	err = bl.db.update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("articles"))
+		v := append([]byte(nil), b.Get([]byte("a1"))...)
+		v[len(v)-1] ^= 1
+		if err := b.Put([]byte("a1"), v); err != nil {
+			return err
+		}
+		return b.Put([]byte("a2"), []byte("xx"))
+	})
+	bl.closeDB()
+	if err != nil {
+		t.Fatalf("update: %s", err)
+	}
+
+	_, err = bl.GetOne("articles", "a1")
+	if !errors.Is(err, ErrDecrypt) || !errors.As(err, &re) || re.Key != "a1" || re.Op != "decrypt" {
+		t.Errorf("expected a decrypt error for a1, got %v", err)
+	}
+	_, err = bl.Get("articles", "a2")
+	if !errors.Is(err, ErrCorrupt) || errors.Is(err, ErrDecrypt) || !errors.Is(err, ErrCiphertextTooShort) {
+		t.Errorf("expected a corrupt record error, got %v", err)
+	}
+	if err.Error() != "decrypt articles/a2: corrupt record: ciphertext too short" {
+		t.Errorf("unexpected message %q", err)
+	}
+}
@@ -0,0 +1,174 @@
+package locknut
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"io"
+	"strings"
+)
+
+// Format is the file format used by Export and Import
+type Format int
+
+// The supported formats
+const (
+	// FormatJSON is a single json array of Record
+	FormatJSON Format = iota
+	// FormatJSONL is one json Record per line
+	FormatJSONL
+	// FormatCSV has the columns bucket, key and value, it is only supported by Export
+	FormatCSV
+)
+
+// ParseFormat returns the Format named by s, one of json, jsonl or csv
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "json":
+		return FormatJSON, nil
+	case "jsonl", "ndjson":
+		return FormatJSONL, nil
+	case "csv":
+		return FormatCSV, nil
+	}
+	return 0, fmt.Errorf("unknown format %q", s)
+}
+
+func (f Format) String() string {
+	switch f {
+	case FormatJSON:
+		return "json"
+	case FormatJSONL:
+		return "jsonl"
+	case FormatCSV:
+		return "csv"
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// Record is a single decrypted record as written by Export and read by Import. Values that are valid
+// json are kept in Value as is, any other value is base64 encoded in Raw so nothing is lost. Likewise
+// keys that are not valid UTF-8 are base64 encoded in key_raw, see BinaryKeys.
+type Record struct {
+	Bucket string          `json:"bucket,omitempty"`
+	Key    string          `json:"key"`
+	Value  json.RawMessage `json:"value,omitempty"`
+	Raw    []byte          `json:"raw,omitempty"`
+}
+
+func newRecord(bucket, key string, value []byte) Record {
+	if json.Valid(value) {
+		return Record{Bucket: bucket, Key: key, Value: value}
+	}
+	return Record{Bucket: bucket, Key: key, Raw: value}
+}
+
+// Bytes returns the value of the record as it is stored by SaveBytes
+func (r Record) Bytes() []byte {
+	if r.Value != nil {
+		return r.Value
+	}
+	return r.Raw
+}
+
+// Export decrypts the records of the given buckets, or of every bucket if none are given, and writes
+// them to w in format. Records are streamed bucket by bucket in key order.
+func (bl *BoltLocknut) Export(w io.Writer, format Format, buckets ...string) error {
+	var err error
+	if err = bl.openDB(); err != nil {
+		return err
+	}
+	defer bl.closeDB()
+
+	if len(buckets) == 0 {
+		if buckets, err = bl.Buckets(); err != nil {
+			return err
+		}
+	}
+
+	write, finish, err := recordWriter(w, format)
+	if err != nil {
+		return err
+	}
+
+	for _, bucket := range buckets {
+		err = bl.db.view(func(tx *bbolt.Tx) error {
+			bkt := tx.Bucket([]byte(bucket))
+			if bkt == nil {
+				return bucketNotFound("export", bucket)
+			}
+			return bkt.ForEach(func(k, v []byte) error {
+				if v == nil {
+					return nil
+				}
+				dec, err := bl.decrypt(bucket, string(k), v)
+				if err != nil {
+					return err
+				}
+				return write(newRecord(bucket, string(k), dec))
+			})
+		})
+		if err == nil {
+			err = bl.auditRead(context.Background(), "export", bucket, "")
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return finish()
+}
+
+// recordWriter returns the functions writing records to w in format, and finishing the output once
+// every record is written
+func recordWriter(w io.Writer, format Format) (write func(r Record) error, finish func() error, err error) {
+	finish = func() error { return nil }
+
+	switch format {
+	case FormatJSON:
+		if _, err = io.WriteString(w, "["); err != nil {
+			return nil, nil, err
+		}
+		first := true
+		write = func(r Record) error {
+			b, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+			if !first {
+				if _, err = io.WriteString(w, ","); err != nil {
+					return err
+				}
+			}
+			first = false
+			_, err = w.Write(b)
+			return err
+		}
+		finish = func() error {
+			_, err := io.WriteString(w, "]\n")
+			return err
+		}
+	case FormatJSONL:
+		enc := json.NewEncoder(w)
+		write = func(r Record) error {
+			return enc.Encode(r)
+		}
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err = cw.Write([]string{"bucket", "key", "value"}); err != nil {
+			return nil, nil, err
+		}
+		write = func(r Record) error {
+			return cw.Write([]string{r.Bucket, r.Key, string(r.Bytes())})
+		}
+		finish = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	default:
+		return nil, nil, fmt.Errorf("unsupported export format %s", format)
+	}
+	return write, finish, nil
+}
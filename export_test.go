@@ -0,0 +1,65 @@
+package locknut
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("export_test.db")
+
+	bl, err := NewBoltLocknut("export_test.db", ".", []byte("secret"), false, []string{"article", "blobs"})
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if err = bl.Save("article", "ID-0001", Article{ID: "ID-0001", Title: "exported"}); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if err = bl.SaveBytes("blobs", "b", []byte{0xff, 0x00}); err != nil {
+		t.Fatalf("SaveBytes: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if err = bl.Export(&buf, FormatJSONL); err != nil {
+		t.Fatalf("Export jsonl: %s", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", buf.String())
+	}
+	var r Record
+	if err = json.Unmarshal([]byte(lines[0]), &r); err != nil {
+		t.Fatalf("Unmarshal: %s", err)
+	}
+	if r.Bucket != "article" || r.Key != "ID-0001" || string(r.Value) != `{"id":"ID-0001","title":"exported"}` {
+		t.Errorf("unexpected record %+v", r)
+	}
+	r = Record{}
+	if err = json.Unmarshal([]byte(lines[1]), &r); err != nil {
+		t.Fatalf("Unmarshal: %s", err)
+	}
+	if !bytes.Equal(r.Bytes(), []byte{0xff, 0x00}) {
+		t.Errorf("binary value not kept: %+v", r)
+	}
+
+	buf.Reset()
+	if err = bl.Export(&buf, FormatJSON, "article"); err != nil {
+		t.Fatalf("Export json: %s", err)
+	}
+	var records []Record
+	if err = json.Unmarshal(buf.Bytes(), &records); err != nil || len(records) != 1 {
+		t.Errorf("unexpected json export %s %v", buf.String(), err)
+	}
+
+	buf.Reset()
+	if err = bl.Export(&buf, FormatCSV, "article"); err != nil {
+		t.Fatalf("Export csv: %s", err)
+	}
+	if !strings.HasPrefix(buf.String(), "bucket,key,value\narticle,ID-0001,") {
+		t.Errorf("unexpected csv export %q", buf.String())
+	}
+}
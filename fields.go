@@ -0,0 +1,215 @@
+package locknut
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// fieldSealedMagic starts the stored value of a record whose fields are encrypted one by one, the rest
+// of the value being its json with each encrypted field replaced by {"$locknut": "<ciphertext>"}
+var fieldSealedMagic = []byte("\x00locknut fields\x00")
+
+// sealedFieldKey is the only key of the object replacing an encrypted field, sealedEqualFieldKey the
+// one of a field encrypted deterministically
+const (
+	sealedFieldKey      = "$locknut"
+	sealedEqualFieldKey = "$locknut-eq"
+)
+
+type encryptedFieldsCtx struct{}
+
+// encryptedField is a field tagged locknut:"encrypt"
+type encryptedField struct {
+	// name is the json name of the field
+	name string
+	// deterministic is set by the tag option "deterministic", see WithDeterministic
+	deterministic bool
+}
+
+// encryptedFieldCache caches the encrypted fields per struct type
+var encryptedFieldCache sync.Map
+
+// encryptedFields returns the top level fields of the struct type of v tagged with locknut:"encrypt".
+// Save stores records of such types as json in which only those fields are encrypted, so the other
+// fields stay readable in the stored value, for tools that query the file. Tags on nested structs are
+// ignored, the whole field holding them is encrypted or not.
+func encryptedFields(v interface{}) []encryptedField {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+	if fields, ok := encryptedFieldCache.Load(t); ok {
+		return fields.([]encryptedField)
+	}
+
+	var fields []encryptedField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("locknut")
+		if !f.IsExported() || !hasTagOption(tag, "encrypt") {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, encryptedField{name: name, deterministic: hasTagOption(tag, "deterministic")})
+	}
+	encryptedFieldCache.Store(t, fields)
+	return fields
+}
+
+func hasTagOption(tag, option string) bool {
+	for _, o := range strings.Split(tag, ",") {
+		if strings.TrimSpace(o) == option {
+			return true
+		}
+	}
+	return false
+}
+
+// sealFields encrypts fields of the json object value, a record of bucket, and returns the stored value
+func (bl *BoltLocknut) sealFields(bucket string, value []byte, fields []encryptedField) ([]byte, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(value, &doc); err != nil {
+		return nil, err
+	}
+	for _, f := range fields {
+		raw, ok := doc[f.name]
+		if !ok {
+			continue
+		}
+		ct, err := bl.sealValue(bucket, raw, f.deterministic)
+		if err != nil {
+			return nil, err
+		}
+		if doc[f.name], err = sealedField(ct, f.deterministic && bl.public == nil); err != nil {
+			return nil, err
+		}
+	}
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte(nil), fieldSealedMagic...), out...), nil
+}
+
+func sealedField(ct []byte, deterministic bool) (json.RawMessage, error) {
+	key := sealedFieldKey
+	if deterministic {
+		key = sealedEqualFieldKey
+	}
+	return json.Marshal(map[string]string{key: base64.StdEncoding.EncodeToString(ct)})
+}
+
+// isFieldSealed reports whether stored is the value of a record with encrypted fields
+func isFieldSealed(stored []byte) bool {
+	return bytes.HasPrefix(stored, fieldSealedMagic)
+}
+
+// mapSealedFields replaces every encrypted field of the stored value with fn of its ciphertext and
+// returns the json that results
+func mapSealedFields(stored []byte, fn func(ct []byte, deterministic bool) (json.RawMessage, error)) ([]byte, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(stored[len(fieldSealedMagic):], &doc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	for name, raw := range doc {
+		if len(raw) == 0 || raw[0] != '{' {
+			continue
+		}
+		var sealed map[string]string
+		if json.Unmarshal(raw, &sealed) != nil || len(sealed) != 1 {
+			continue
+		}
+		enc, ok := sealed[sealedFieldKey]
+		deterministic := false
+		if !ok {
+			if enc, deterministic = sealed[sealedEqualFieldKey]; !deterministic {
+				continue
+			}
+		}
+		ct, err := base64.StdEncoding.DecodeString(enc)
+		if err != nil {
+			return nil, err
+		}
+		if doc[name], err = fn(ct, deterministic); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(doc)
+}
+
+// openFields returns the json of a record of bucket with encrypted fields, decrypted, and what any of
+// the fields was opened with
+func (bl *BoltLocknut) openFields(bucket string, stored []byte) (value []byte, info openInfo, err error) {
+	value, err = mapSealedFields(stored, func(ct []byte, deterministic bool) (json.RawMessage, error) {
+		var plain []byte
+		var legacy bool
+		var err error
+		ct, framed := cutFrame(ct)
+		if bl.public != nil && !deterministic {
+			plain, err = bl.unseal(ct)
+		} else {
+			plain, legacy, err = bl.openCiphertext(bucket, ct)
+		}
+		if err != nil {
+			return nil, err
+		}
+		field, names, err := bl.restoreStages(plain, framed)
+		info.legacy = info.legacy || legacy
+		info.compressed = info.compressed || bl.compresses(names)
+		return field, err
+	})
+	return value, info, err
+}
+
+// withEncryptedFields makes the value saved with ctx have fields encrypted, rather than the whole
+// value
+func withEncryptedFields(ctx context.Context, fields []encryptedField) context.Context {
+	return context.WithValue(ctx, encryptedFieldsCtx{}, fields)
+}
+
+func encryptedFieldsOf(ctx context.Context) []encryptedField {
+	if ctx == nil {
+		return nil
+	}
+	fields, _ := ctx.Value(encryptedFieldsCtx{}).([]encryptedField)
+	return fields
+}
+
+// resealFields re-encrypts the encrypted fields of a stored value, opened by open, to newKey
+func resealFields(stored []byte, open func(ct []byte) ([]byte, error), newKey []byte) ([]byte, error) {
+	out, err := mapSealedFields(stored, func(ct []byte, deterministic bool) (json.RawMessage, error) {
+		ct, framed := cutFrame(ct)
+		plain, err := open(ct)
+		if err != nil {
+			return nil, err
+		}
+		if deterministic {
+			ct, err = encryptDeterministic(plain, newKey)
+		} else {
+			ct, err = Encrypt(plain, newKey)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return sealedField(markFrame(ct, framed), deterministic)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte(nil), fieldSealedMagic...), out...), nil
+}
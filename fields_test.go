@@ -0,0 +1,79 @@
+package locknut
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"go.etcd.io/bbolt"
+	"os"
+	"testing"
+)
+
+type person struct {
+	Name  string `json:"name"`
+	SSN   string `json:"ssn" locknut:"encrypt"`
+	Email string `locknut:"encrypt"`
+	Age   int    `json:"age"`
+}
+
+func TestFieldEncryption(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("fields_test.db")
+
+	bl, err := NewBoltLocknut("fields_test.db", ".", []byte("secret"), false, []string{"people"})
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	p := person{Name: "Ada", SSN: "123-45-6789", Email: "ada@example.com", Age: 36}
+	ctx := WithIdempotencyKey(context.Background(), "save-ada")
+	if err = bl.SaveContext(ctx, "people", "ada", &p); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	// a retry is recognized even though the fields are encrypted with fresh nonces
+	if err = bl.SaveContext(ctx, "people", "ada", &p); err != nil {
+		t.Fatalf("retried Save: %s", err)
+	}
+
+	stored := storedValue(t, bl, "people", "ada")
+	if !bytes.Contains(stored, []byte(`"name":"Ada"`)) || !bytes.Contains(stored, []byte(`"age":36`)) {
+		t.Errorf("expected the untagged fields in plaintext, got %q", stored)
+	}
+	if bytes.Contains(stored, []byte("123-45-6789")) || bytes.Contains(stored, []byte("ada@example.com")) {
+		t.Errorf("expected the tagged fields to be encrypted, got %q", stored)
+	}
+
+	check := func() {
+		t.Helper()
+		raw, err := bl.Get("people", "ada")
+		if err != nil {
+			t.Fatalf("Get: %s", err)
+		}
+		var got person
+		if err = json.Unmarshal(raw, &got); err != nil || got != p {
+			t.Errorf("unexpected record %s %v", raw, err)
+		}
+	}
+	check()
+
+	if err = bl.RotateKey([]byte("new secret")); err != nil {
+		t.Fatalf("RotateKey: %s", err)
+	}
+	check()
+	if stored = storedValue(t, bl, "people", "ada"); !bytes.Contains(stored, []byte(`"name":"Ada"`)) {
+		t.Errorf("expected rotation to keep the fields encrypted one by one, got %q", stored)
+	}
+}
+
+func storedValue(t *testing.T, bl *BoltLocknut, bucket, key string) []byte {
+	t.Helper()
+	if err := bl.openDB(); err != nil {
+		t.Fatal(err)
+	}
+	defer bl.closeDB()
+	var v []byte
+	bl.db.view(func(tx *bbolt.Tx) error {
+		v = append(v, tx.Bucket([]byte(bucket)).Get([]byte(key))...)
+		return nil
+	})
+	return v
+}
@@ -0,0 +1,332 @@
+// Package filestore implements locknut.Locknut with one encrypted file per record, laid out as
+// <root>/<bucket>/<hashed key>, so records can be synced with rsync or kept in git one by one. It
+// suits small stores such as configuration: prefix scans decrypt every file of a bucket.
+package filestore
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/taybart/locknut"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// metaDir holds the files of the store itself, it is not a bucket
+const metaDir = ".locknut"
+
+var canaryPlaintext = []byte("locknut canary v1")
+
+// errMalformed is the cause of a corrupt record whose plaintext does not hold a key and a value
+var errMalformed = errors.New("malformed record")
+
+// FileLocknut is a locknut.Locknut kept as files under a root directory
+type FileLocknut struct {
+	root    string
+	secret  []byte
+	nameKey []byte
+}
+
+var _ locknut.Locknut = (*FileLocknut)(nil)
+
+// NewFileLocknut uses the directory root, creating it and buckets if needed. Each record is a file
+// holding its key and value, encrypted with the key derived from secret like BoltLocknut values, and
+// named by a keyed hash of its key so names do not leak keys. Opening an existing store with another
+// secret fails with locknut.ErrWrongSecret.
+func NewFileLocknut(root string, secret []byte, buckets []string) (*FileLocknut, error) {
+	key := locknut.DeriveKey(secret)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("locknut file names"))
+	fl := &FileLocknut{root: root, secret: key, nameKey: mac.Sum(nil)}
+
+	if err := os.MkdirAll(filepath.Join(root, metaDir), 0700); err != nil {
+		return nil, err
+	}
+	if err := fl.checkSecret(); err != nil {
+		return nil, err
+	}
+	for _, b := range buckets {
+		if err := fl.CreateBucket(b); err != nil {
+			return nil, err
+		}
+	}
+	return fl, nil
+}
+
+// checkSecret verifies the secret against the canary, writing it for a new store
+func (fl *FileLocknut) checkSecret() error {
+	path := filepath.Join(fl.root, metaDir, "canary")
+	stored, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		if stored, err = locknut.Encrypt(canaryPlaintext, fl.secret); err != nil {
+			return err
+		}
+		return writeAtomic(path, stored)
+	}
+	if err != nil {
+		return err
+	}
+	plain, err := locknut.Decrypt(stored, fl.secret)
+	if err != nil || !bytes.Equal(plain, canaryPlaintext) {
+		return locknut.ErrWrongSecret
+	}
+	return nil
+}
+
+// writeAtomic writes data to a temporary file next to path and renames it over path, so readers see
+// the old or the new record and never a partial one
+func writeAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func validBucket(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, `/\`)
+}
+
+// bucketDir returns the directory of bucket, failing with a locknut.RecordError of kind
+// locknut.ErrBucketNotFound if it does not exist
+func (fl *FileLocknut) bucketDir(op, bucket string) (string, error) {
+	dir := filepath.Join(fl.root, bucket)
+	if !validBucket(bucket) {
+		return "", &locknut.RecordError{Op: op, Bucket: bucket, Kind: locknut.ErrBucketNotFound}
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return "", &locknut.RecordError{Op: op, Bucket: bucket, Kind: locknut.ErrBucketNotFound}
+	}
+	return dir, nil
+}
+
+// fileName returns the name of the file holding key
+func (fl *FileLocknut) fileName(key string) string {
+	mac := hmac.New(sha256.New, fl.nameKey)
+	mac.Write([]byte(key))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// seal encrypts a record, its plaintext being the length of the key as a uvarint, the key and the value
+func (fl *FileLocknut) seal(key string, value []byte) ([]byte, error) {
+	plain := binary.AppendUvarint(nil, uint64(len(key)))
+	plain = append(plain, key...)
+	return locknut.Encrypt(append(plain, value...), fl.secret)
+}
+
+// open decrypts a record file and returns its key and value
+func (fl *FileLocknut) open(bucket, name string, stored []byte) (string, []byte, error) {
+	plain, err := locknut.Decrypt(stored, fl.secret)
+	if err == nil {
+		n, size := binary.Uvarint(plain)
+		if size > 0 && uint64(len(plain)-size) >= n {
+			return string(plain[size : size+int(n)]), plain[size+int(n):], nil
+		}
+		err = errMalformed
+	}
+	kind := locknut.ErrDecrypt
+	if errors.Is(err, locknut.ErrCiphertextTooShort) || err == errMalformed {
+		kind = locknut.ErrCorrupt
+	}
+	return "", nil, &locknut.RecordError{Op: "decrypt", Bucket: bucket, Key: name, Kind: kind, Err: err}
+}
+
+// Save stores data as json under key in bucket
+func (fl *FileLocknut) Save(bucket, key string, data interface{}) error {
+	if data == nil {
+		return errors.New("data is nil")
+	}
+	value, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return fl.SaveBytes(bucket, key, value)
+}
+
+// SaveBytes stores data as it is under key in bucket
+func (fl *FileLocknut) SaveBytes(bucket, key string, data []byte) error {
+	if data == nil {
+		return errors.New("data is nil")
+	}
+	if key == "" {
+		return locknut.ErrKeyInvalid
+	}
+	dir, err := fl.bucketDir("put", bucket)
+	if err != nil {
+		return err
+	}
+	stored, err := fl.seal(key, data)
+	if err != nil {
+		return &locknut.RecordError{Op: "encrypt", Bucket: bucket, Key: key, Kind: locknut.ErrEncrypt, Err: err}
+	}
+	return writeAtomic(filepath.Join(dir, fl.fileName(key)), stored)
+}
+
+// Get returns the record stored under exactly key, or nil if there is none
+func (fl *FileLocknut) Get(bucket, key string) ([]byte, error) {
+	dir, err := fl.bucketDir("get", bucket)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := os.ReadFile(filepath.Join(dir, fl.fileName(key)))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, value, err := fl.open(bucket, key, stored)
+	return value, err
+}
+
+type record struct {
+	key   string
+	value []byte
+}
+
+// scan returns the records of bucket whose keys start with prefix, sorted by key
+func (fl *FileLocknut) scan(op, bucket, prefix string) ([]record, error) {
+	dir, err := fl.bucketDir(op, bucket)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []record
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		stored, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if os.IsNotExist(err) {
+			continue // deleted since the listing
+		}
+		if err != nil {
+			return nil, err
+		}
+		key, value, err := fl.open(bucket, e.Name(), stored)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(key, prefix) {
+			records = append(records, record{key: key, value: value})
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].key < records[j].key })
+	return records, nil
+}
+
+// GetOne returns the first record whose key starts with key
+func (fl *FileLocknut) GetOne(bucket, key string) ([]byte, error) {
+	if key == "" {
+		return nil, locknut.ErrKeyInvalid
+	}
+	records, err := fl.scan("get", bucket, key)
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+	return records[0].value, nil
+}
+
+// GetByPrefix returns the decrypted records whose keys start with prefix
+func (fl *FileLocknut) GetByPrefix(bucket, prefix string) (map[string][]byte, error) {
+	records, err := fl.scan("get", bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+	results := make(map[string][]byte, len(records))
+	for _, r := range records {
+		results[r.key] = r.value
+	}
+	return results, nil
+}
+
+// GetKeyList returns the keys of bucket starting with prefix, in order
+func (fl *FileLocknut) GetKeyList(bucket, prefix string) ([]string, error) {
+	records, err := fl.scan("list", bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(records))
+	for _, r := range records {
+		keys = append(keys, r.key)
+	}
+	return keys, nil
+}
+
+// Delete removes key from bucket
+func (fl *FileLocknut) Delete(bucket, key string) error {
+	if key == "" {
+		return errors.New("cannot delete, key is nil")
+	}
+	dir, err := fl.bucketDir("delete", bucket)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(filepath.Join(dir, fl.fileName(key))); os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Buckets returns the names of the buckets in the store
+func (fl *FileLocknut) Buckets() ([]string, error) {
+	entries, err := os.ReadDir(fl.root)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0)
+	for _, e := range entries {
+		if e.IsDir() && validBucket(e.Name()) {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+// CreateBucket creates the bucket if it does not exist yet
+func (fl *FileLocknut) CreateBucket(name string) error {
+	if !validBucket(name) {
+		return locknut.ErrBucketInvalid
+	}
+	return os.MkdirAll(filepath.Join(fl.root, name), 0700)
+}
+
+// Count returns the number of records in bucket
+func (fl *FileLocknut) Count(bucket string) (int, error) {
+	dir, err := fl.bucketDir("count", bucket)
+	if err != nil {
+		return 0, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, e := range entries {
+		if !e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+			n++
+		}
+	}
+	return n, nil
+}
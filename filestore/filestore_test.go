@@ -0,0 +1,70 @@
+package filestore
+
+import (
+	"errors"
+	"github.com/taybart/locknut"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileLocknut(t *testing.T) {
+	defer os.RemoveAll("filestore_test")
+
+	fl, err := NewFileLocknut("filestore_test", []byte("secret"), []string{"articles"})
+	if err != nil {
+		t.Fatalf("NewFileLocknut: %s", err)
+	}
+
+	for k, v := range map[string]string{"a1": "one", "a2": "two", "b1": "three"} {
+		if err = fl.Save("articles", k, v); err != nil {
+			t.Fatalf("Save: %s", err)
+		}
+	}
+	if err = fl.Save("missing", "a1", "one"); !errors.Is(err, locknut.ErrBucketNotFound) {
+		t.Errorf("expected ErrBucketNotFound, got %v", err)
+	}
+	if err = fl.CreateBucket("../escape"); err != locknut.ErrBucketInvalid {
+		t.Errorf("expected ErrBucketInvalid, got %v", err)
+	}
+
+	if v, err := fl.Get("articles", "a1"); err != nil || string(v) != `"one"` {
+		t.Errorf("unexpected Get %q %v", v, err)
+	}
+	if v, err := fl.Get("articles", "a"); err != nil || v != nil {
+		t.Errorf("expected no record for a prefix, got %q %v", v, err)
+	}
+	if v, err := fl.GetOne("articles", "a"); err != nil || string(v) != `"one"` {
+		t.Errorf("unexpected GetOne %q %v", v, err)
+	}
+	if res, err := fl.GetByPrefix("articles", "a"); err != nil || len(res) != 2 || string(res["a2"]) != `"two"` {
+		t.Errorf("unexpected GetByPrefix %v %v", res, err)
+	}
+	if keys, err := fl.GetKeyList("articles", ""); err != nil || len(keys) != 3 || keys[2] != "b1" {
+		t.Errorf("unexpected GetKeyList %v %v", keys, err)
+	}
+	if err = fl.Delete("articles", "a2"); err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+	if n, err := fl.Count("articles"); err != nil || n != 2 {
+		t.Errorf("unexpected Count %d %v", n, err)
+	}
+	if names, err := fl.Buckets(); err != nil || len(names) != 1 || names[0] != "articles" {
+		t.Errorf("unexpected Buckets %v %v", names, err)
+	}
+
+	// one file per record, named without revealing the key
+	entries, err := os.ReadDir(filepath.Join("filestore_test", "articles"))
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("expected 2 record files, got %v %v", entries, err)
+	}
+	for _, e := range entries {
+		if e.Name() == "a1" || e.Name() == "b1" || len(e.Name()) != 64 {
+			t.Errorf("unexpected file name %s", e.Name())
+		}
+	}
+
+	if _, err = NewFileLocknut("filestore_test", []byte("other secret"), nil); err != locknut.ErrWrongSecret {
+		t.Errorf("expected ErrWrongSecret, got %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package locknut
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNotFIPS is returned in FIPS mode for options and operations using algorithms that are not FIPS
+// approved
+var ErrNotFIPS = errors.New("not allowed in FIPS mode")
+
+// FIPSTransformer is implemented by Transformers that only use FIPS approved algorithms, or none, and
+// may run in FIPS mode
+type FIPSTransformer interface {
+	Transformer
+	FIPSApproved() bool
+}
+
+// WithFIPS restricts the BoltLocknut to FIPS approved algorithms: AES-GCM with random or counter
+// nonces, HKDF and HMAC with SHA-256, PBKDF2, Ed25519 and ECDSA. NewBoltLocknut fails with ErrNotFIPS
+// for options relying on others, X25519 WithPublicKey and WithPrivateKey, Argon2id and scrypt WithKDF,
+// the AES-SIV of WithDeterministic, Transformers not implementing FIPSTransformer and the
+// test randomness of locknuttest.WithRand, and for a secret shorter than a key that is not derived
+// with PBKDF2, since it would be hashed into one.
+// Backups to Recipients fail with ErrNotFIPS as well. Key providers are not checked, they must be
+// validated modules themselves, such as a cloud KMS.
+//
+// Building with the locknut_fips tag turns FIPS mode on for every BoltLocknut, and makes the functions
+// of the package relying on X25519, such as NewEnrollmentRequest and DecryptBackup, and the age
+// key provider fail with ErrNotFIPS, so a binary can be attested without reviewing how it is used.
+func WithFIPS() Option {
+	return func(bl *BoltLocknut) {
+		bl.fips = true
+	}
+}
+
+// FIPSBuild reports whether the package was built with the locknut_fips tag
+func FIPSBuild() bool {
+	return fipsBuild
+}
+
+// fipsMode reports whether the BoltLocknut is restricted to FIPS approved algorithms
+func (bl *BoltLocknut) fipsMode() bool {
+	return bl.fips || fipsBuild
+}
+
+// notFIPS returns ErrNotFIPS for what in FIPS mode, nil otherwise
+func (bl *BoltLocknut) notFIPS(what string) error {
+	if !bl.fipsMode() {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrNotFIPS, what)
+}
+
+// notFIPSBuild returns ErrNotFIPS for what in a build with the locknut_fips tag, nil otherwise
+func notFIPSBuild(what string) error {
+	if !fipsBuild {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrNotFIPS, what)
+}
+
+// checkFIPS fails for the options that use algorithms FIPS mode does not allow
+func (bl *BoltLocknut) checkFIPS() error {
+	if !bl.fipsMode() {
+		return nil
+	}
+	if bl.public != nil {
+		return bl.notFIPS("X25519 record sealing")
+	}
+	if bl.rand != nil {
+		return bl.notFIPS("a source of randomness other than crypto/rand")
+	}
+	if bl.kdf != nil && bl.kdf.Algorithm != KDFPBKDF2 {
+		return bl.notFIPS(bl.kdf.Algorithm + " key derivation")
+	}
+	if len(bl.deterministic) > 0 {
+		return bl.notFIPS("deterministic encryption")
+	}
+	for _, t := range bl.transformers {
+		if f, ok := t.(FIPSTransformer); !ok || !f.FIPSApproved() {
+			return bl.notFIPS("transformer " + t.Name())
+		}
+	}
+	return nil
+}
+
+// checkFIPSKey fails in FIPS mode for a key hashed from a short secret, or derived with a KDF that is
+// not approved
+func (bl *BoltLocknut) checkFIPSKey(secret []byte, r *kdfRecord) error {
+	if secret == nil || bl.public != nil {
+		return nil
+	}
+	if r != nil && r.Algorithm != KDFPBKDF2 {
+		return bl.notFIPS(r.Algorithm + " key derivation")
+	}
+	if r == nil && len(secret) < 32 {
+		return bl.notFIPS("secret shorter than a key, derive it WithKDF(PBKDF2KDF())")
+	}
+	return nil
+}
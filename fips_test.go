@@ -0,0 +1,79 @@
+package locknut
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestFIPS(t *testing.T) {
+	defer os.Remove("fips_test.db")
+
+	open := func(secret string, opts ...Option) (*BoltLocknut, error) {
+		return NewBoltLocknut("fips_test.db", ".", []byte(secret), false, []string{"notes"}, append(opts, WithFIPS())...)
+	}
+
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, opts := range map[string][]Option{
+		"public key":    {WithPublicKey(key.PublicKey())},
+		"argon2id":      {WithKDF(KDF{Algorithm: KDFArgon2id, Time: 1, Memory: 64, Threads: 1})},
+		"deterministic": {WithDeterministic("notes")},
+		"transformer":   {WithTransformers(base64Stage{})},
+		"rand":          {withRand(rand.Reader)},
+	} {
+		os.Remove("fips_test.db")
+		if _, err = open("0123456789abcdef0123456789abcdef", opts...); !errors.Is(err, ErrNotFIPS) {
+			t.Errorf("%s: expected ErrNotFIPS, got %v", name, err)
+		}
+	}
+
+	os.Remove("fips_test.db")
+	if _, err = open("passphrase"); !errors.Is(err, ErrNotFIPS) {
+		t.Errorf("short secret: expected ErrNotFIPS, got %v", err)
+	}
+
+	bl, err := open("passphrase", WithKDF(KDF{Algorithm: KDFPBKDF2, Iterations: 10}), WithTransformers(Gzip(1)))
+	if err != nil {
+		t.Fatalf("pbkdf2: NewBoltLocknut: %s", err)
+	}
+	if err = bl.Save("notes", "a", "note"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	var buf bytes.Buffer
+	if _, err = bl.Backup(&buf, BackupOptions{Recipients: []*ecdh.PublicKey{key.PublicKey()}}); !errors.Is(err, ErrNotFIPS) {
+		t.Errorf("backup recipients: expected ErrNotFIPS, got %v", err)
+	}
+	if _, err = bl.Backup(&buf, BackupOptions{}); err != nil {
+		t.Errorf("Backup: %s", err)
+	}
+	bl.Close()
+
+	// a key of full length is used as it is
+	os.Remove("fips_test.db")
+	bl, err = open("0123456789abcdef0123456789abcdef")
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	bl.Close()
+
+	if FIPSBuild() {
+		if _, err = NewEnrollmentRequest("phone"); !errors.Is(err, ErrNotFIPS) {
+			t.Errorf("enrollment: expected ErrNotFIPS, got %v", err)
+		}
+	}
+}
+
+// skipFIPS skips a test of what the build with the locknut_fips tag refuses, a short secret hashed into
+// a key for most of them
+func skipFIPS(t *testing.T, what string) {
+	t.Helper()
+	if FIPSBuild() {
+		t.Skip(what + " is not allowed in FIPS mode")
+	}
+}
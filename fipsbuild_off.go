@@ -0,0 +1,6 @@
+//go:build !locknut_fips
+
+package locknut
+
+// fipsBuild is false without the locknut_fips build tag, FIPS mode is then set WithFIPS
+const fipsBuild = false
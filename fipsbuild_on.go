@@ -0,0 +1,6 @@
+//go:build locknut_fips
+
+package locknut
+
+// fipsBuild turns FIPS mode on for every BoltLocknut and the functions of the package
+const fipsBuild = true
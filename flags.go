@@ -0,0 +1,222 @@
+package locknut
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Flag is a feature flag stored encrypted in a Flags bucket. A flag is on for a subject when it is
+// Enabled and the subject is one of the Targets, or the subject's stable hash falls within the first
+// Percentage percent of subjects. A plain boolean flag is Enabled with a Percentage of 100.
+type Flag struct {
+	Name       string   `json:"name"`
+	Enabled    bool     `json:"enabled"`
+	Percentage float64  `json:"percentage"`
+	Targets    []string `json:"targets,omitempty"`
+}
+
+// On reports whether the flag is on for subject
+func (fl Flag) On(subject string) bool {
+	if !fl.Enabled {
+		return false
+	}
+	for _, t := range fl.Targets {
+		if t == subject {
+			return true
+		}
+	}
+	if fl.Percentage >= 100 {
+		return true
+	}
+
+	// hash the flag name with the subject so rollouts of different flags are independent
+	sum := sha256.Sum256([]byte(fl.Name + ":" + subject))
+	slot := binary.BigEndian.Uint64(sum[:8]) % 10000
+	return float64(slot) < fl.Percentage*100
+}
+
+// Flags evaluates feature flags locally from an in memory copy of a bucket. The copy is loaded on first
+// use and invalidated by Set, Delete, Invalidate or a change seen by Poll.
+type Flags struct {
+	bl     *BoltLocknut
+	bucket string
+
+	mu       sync.RWMutex
+	cache    map[string]Flag
+	watchers []func(Flag)
+}
+
+// NewFlags returns the flags kept in bucket, creating it if needed
+func NewFlags(bl *BoltLocknut, bucket string) (*Flags, error) {
+	if err := bl.CreateBucket(bucket); err != nil {
+		return nil, err
+	}
+	return &Flags{bl: bl, bucket: bucket}, nil
+}
+
+func (f *Flags) load() (map[string]Flag, error) {
+	records, err := f.bl.GetByPrefix(f.bucket, "")
+	if err != nil {
+		return nil, err
+	}
+
+	flags := make(map[string]Flag, len(records))
+	for name, v := range records {
+		var fl Flag
+		if err = json.Unmarshal(v, &fl); err != nil {
+			return nil, err
+		}
+		flags[name] = fl
+	}
+	return flags, nil
+}
+
+func (f *Flags) flags() (map[string]Flag, error) {
+	f.mu.RLock()
+	cache := f.cache
+	f.mu.RUnlock()
+	f.bl.observeCache("flags", cache != nil)
+	if cache != nil {
+		return cache, nil
+	}
+
+	cache, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	f.cache = cache
+	f.mu.Unlock()
+	return cache, nil
+}
+
+// Get returns the named flag and whether it exists
+func (f *Flags) Get(name string) (Flag, bool, error) {
+	flags, err := f.flags()
+	if err != nil {
+		return Flag{}, false, err
+	}
+	fl, ok := flags[name]
+	return fl, ok, nil
+}
+
+// Enabled reports whether the named flag is on for subject. Unknown flags and load errors are off.
+func (f *Flags) Enabled(name, subject string) bool {
+	fl, ok, err := f.Get(name)
+	if err != nil || !ok {
+		return false
+	}
+	return fl.On(subject)
+}
+
+// Set stores the flag and notifies the watchers
+func (f *Flags) Set(fl Flag) error {
+	if fl.Name == "" {
+		return ErrKeyInvalid
+	}
+	if err := f.bl.Save(f.bucket, fl.Name, fl); err != nil {
+		return err
+	}
+
+	f.Invalidate()
+	f.notify(fl)
+	return nil
+}
+
+// SetBool stores a plain on/off flag
+func (f *Flags) SetBool(name string, on bool) error {
+	return f.Set(Flag{Name: name, Enabled: on, Percentage: 100})
+}
+
+// SetPercentage stores a flag that is on for pct percent of subjects
+func (f *Flags) SetPercentage(name string, pct float64) error {
+	return f.Set(Flag{Name: name, Enabled: true, Percentage: pct})
+}
+
+// SetTargets stores a flag that is on only for the listed subjects
+func (f *Flags) SetTargets(name string, subjects ...string) error {
+	return f.Set(Flag{Name: name, Enabled: true, Targets: subjects})
+}
+
+// Delete removes the named flag
+func (f *Flags) Delete(name string) error {
+	if err := f.bl.Delete(f.bucket, name); err != nil {
+		return err
+	}
+
+	f.Invalidate()
+	f.notify(Flag{Name: name})
+	return nil
+}
+
+// Invalidate drops the in memory copy so the next evaluation reloads the bucket
+func (f *Flags) Invalidate() {
+	f.mu.Lock()
+	f.cache = nil
+	f.mu.Unlock()
+}
+
+// Watch registers fn to be called with the new value of a flag whenever it changes. A deleted flag is
+// passed as a disabled Flag with only its Name set.
+func (f *Flags) Watch(fn func(Flag)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.watchers = append(f.watchers, fn)
+}
+
+func (f *Flags) notify(fl Flag) {
+	f.mu.RLock()
+	watchers := f.watchers
+	f.mu.RUnlock()
+	for _, fn := range watchers {
+		fn(fl)
+	}
+}
+
+// Poll reloads the bucket every interval until ctx is done, replacing the in memory copy and notifying
+// the watchers of flags changed by other processes sharing the db file
+func (f *Flags) Poll(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		f.mu.RLock()
+		old := f.cache
+		f.mu.RUnlock()
+
+		fresh, err := f.load()
+		if err != nil {
+			return err
+		}
+
+		f.mu.Lock()
+		f.cache = fresh
+		f.mu.Unlock()
+
+		if old == nil {
+			continue
+		}
+		for name, fl := range fresh {
+			if prev, ok := old[name]; !ok || !reflect.DeepEqual(prev, fl) {
+				f.notify(fl)
+			}
+		}
+		for name := range old {
+			if _, ok := fresh[name]; !ok {
+				f.notify(Flag{Name: name})
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package locknut
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestFlags(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("flags_test.db")
+
+	bl, err := NewBoltLocknut("flags_test.db", ".", []byte("secret"), false, nil)
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	flags, err := NewFlags(bl, "flags")
+	if err != nil {
+		t.Fatalf("NewFlags: %s", err)
+	}
+
+	var seen []string
+	flags.Watch(func(fl Flag) { seen = append(seen, fl.Name) })
+
+	if flags.Enabled("dark-mode", "taylor") {
+		t.Errorf("unknown flag should be off")
+	}
+
+	if err = flags.SetBool("dark-mode", true); err != nil {
+		t.Fatalf("SetBool: %s", err)
+	}
+	if !flags.Enabled("dark-mode", "taylor") {
+		t.Errorf("boolean flag should be on")
+	}
+
+	if err = flags.SetTargets("beta", "taylor"); err != nil {
+		t.Fatalf("SetTargets: %s", err)
+	}
+	if !flags.Enabled("beta", "taylor") || flags.Enabled("beta", "someone") {
+		t.Errorf("targeted flag evaluated wrong")
+	}
+
+	if err = flags.SetPercentage("rollout", 25); err != nil {
+		t.Fatalf("SetPercentage: %s", err)
+	}
+	on := 0
+	for i := 0; i < 4000; i++ {
+		subject := fmt.Sprintf("user-%d", i)
+		first := flags.Enabled("rollout", subject)
+		if first != flags.Enabled("rollout", subject) {
+			t.Fatalf("evaluation is not stable for %s", subject)
+		}
+		if first {
+			on++
+		}
+	}
+	if on < 800 || on > 1200 {
+		t.Errorf("expected about 1000 of 4000 subjects on, got %d", on)
+	}
+
+	if err = flags.Delete("beta"); err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+	if flags.Enabled("beta", "taylor") {
+		t.Errorf("deleted flag should be off")
+	}
+
+	if len(seen) != 4 {
+		t.Errorf("unexpected notifications %v", seen)
+	}
+}
@@ -0,0 +1,88 @@
+// Package gcpkms implements locknut.KeyProvider with Google Cloud KMS, so the master key of a db lives
+// in a Cloud KMS key, HSM backed with the HSM protection level, and only the wrapped db key is kept
+// next to the db.
+package gcpkms
+
+import (
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"context"
+	"errors"
+	"github.com/googleapis/gax-go/v2"
+	"github.com/taybart/locknut"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"hash/crc32"
+)
+
+// additionalData binds wrapped keys to their use, Cloud KMS refuses to unwrap them without it
+var additionalData = []byte("locknut db key")
+
+// ErrIntegrity is returned when a request or response was corrupted in transit, as told by the
+// CRC32C checksums Cloud KMS exchanges
+var ErrIntegrity = errors.New("cloud kms checksum mismatch")
+
+// API is the part of *kms.KeyManagementClient the provider uses
+type API interface {
+	Encrypt(ctx context.Context, req *kmspb.EncryptRequest, opts ...gax.CallOption) (*kmspb.EncryptResponse, error)
+	Decrypt(ctx context.Context, req *kmspb.DecryptRequest, opts ...gax.CallOption) (*kmspb.DecryptResponse, error)
+}
+
+// Provider wraps keys with a Cloud KMS key
+type Provider struct {
+	client  API
+	keyName string
+	keyFile string
+}
+
+var _ locknut.KeyProvider = (*Provider)(nil)
+
+// New returns a Provider wrapping keys with the Cloud KMS key keyName, of the form
+// projects/*/locations/*/keyRings/*/cryptoKeys/*, and keeping the wrapped db key in keyFile
+func New(client API, keyName, keyFile string) *Provider {
+	return &Provider{client: client, keyName: keyName, keyFile: keyFile}
+}
+
+func checksum(b []byte) *wrapperspb.Int64Value {
+	return wrapperspb.Int64(int64(crc32.Checksum(b, crc32.MakeTable(crc32.Castagnoli))))
+}
+
+// GetKey returns the db key, created on first use, see locknut.EnvelopeKey
+func (p *Provider) GetKey(ctx context.Context) ([]byte, error) {
+	return locknut.EnvelopeKey(ctx, p, p.keyFile)
+}
+
+// Wrap encrypts key with the Cloud KMS key
+func (p *Provider) Wrap(ctx context.Context, key []byte) ([]byte, error) {
+	resp, err := p.client.Encrypt(ctx, &kmspb.EncryptRequest{
+		Name:                              p.keyName,
+		Plaintext:                         key,
+		PlaintextCrc32C:                   checksum(key),
+		AdditionalAuthenticatedData:       additionalData,
+		AdditionalAuthenticatedDataCrc32C: checksum(additionalData),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !resp.VerifiedPlaintextCrc32C || !resp.VerifiedAdditionalAuthenticatedDataCrc32C ||
+		resp.CiphertextCrc32C.GetValue() != checksum(resp.Ciphertext).GetValue() {
+		return nil, ErrIntegrity
+	}
+	return resp.Ciphertext, nil
+}
+
+// Unwrap decrypts a key wrapped by Wrap
+func (p *Provider) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	resp, err := p.client.Decrypt(ctx, &kmspb.DecryptRequest{
+		Name:                              p.keyName,
+		Ciphertext:                        wrapped,
+		CiphertextCrc32C:                  checksum(wrapped),
+		AdditionalAuthenticatedData:       additionalData,
+		AdditionalAuthenticatedDataCrc32C: checksum(additionalData),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.PlaintextCrc32C.GetValue() != checksum(resp.Plaintext).GetValue() {
+		return nil, ErrIntegrity
+	}
+	return resp.Plaintext, nil
+}
@@ -0,0 +1,60 @@
+package gcpkms
+
+import (
+	"bytes"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"context"
+	"errors"
+	"github.com/googleapis/gax-go/v2"
+	"path/filepath"
+	"testing"
+)
+
+// fakeKMS "encrypts" by prefixing the key name and answers with the checksums Cloud KMS sends
+type fakeKMS struct {
+	corrupt bool
+}
+
+func (f *fakeKMS) Encrypt(ctx context.Context, req *kmspb.EncryptRequest, opts ...gax.CallOption) (*kmspb.EncryptResponse, error) {
+	ct := append([]byte(req.Name+":"), req.Plaintext...)
+	resp := &kmspb.EncryptResponse{
+		Name:                    req.Name,
+		Ciphertext:              ct,
+		CiphertextCrc32C:        checksum(ct),
+		VerifiedPlaintextCrc32C: req.PlaintextCrc32C.GetValue() == checksum(req.Plaintext).GetValue(),
+		VerifiedAdditionalAuthenticatedDataCrc32C: req.AdditionalAuthenticatedDataCrc32C.GetValue() == checksum(req.AdditionalAuthenticatedData).GetValue(),
+	}
+	if f.corrupt {
+		resp.Ciphertext = append([]byte{'x'}, ct...)
+	}
+	return resp, nil
+}
+
+func (f *fakeKMS) Decrypt(ctx context.Context, req *kmspb.DecryptRequest, opts ...gax.CallOption) (*kmspb.DecryptResponse, error) {
+	prefix := []byte(req.Name + ":")
+	if !bytes.Equal(req.AdditionalAuthenticatedData, additionalData) || !bytes.HasPrefix(req.Ciphertext, prefix) {
+		return nil, errors.New("invalid ciphertext")
+	}
+	pt := req.Ciphertext[len(prefix):]
+	return &kmspb.DecryptResponse{Plaintext: pt, PlaintextCrc32C: checksum(pt)}, nil
+}
+
+func TestProvider(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "db.key")
+	const name = "projects/p/locations/global/keyRings/r/cryptoKeys/locknut"
+	client := &fakeKMS{}
+
+	key, err := New(client, name, keyFile).GetKey(context.Background())
+	if err != nil || len(key) != 32 {
+		t.Fatalf("unexpected GetKey %x %v", key, err)
+	}
+	again, err := New(client, name, keyFile).GetKey(context.Background())
+	if err != nil || !bytes.Equal(again, key) {
+		t.Errorf("expected the same key from the key file, got %x %v", again, err)
+	}
+
+	client.corrupt = true
+	if _, err = New(client, name, keyFile).Wrap(context.Background(), key); !errors.Is(err, ErrIntegrity) {
+		t.Errorf("expected ErrIntegrity for a corrupted response, got %v", err)
+	}
+}
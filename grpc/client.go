@@ -0,0 +1,83 @@
+package grpc
+
+import (
+	"context"
+	"github.com/taybart/locknut/grpc/pb"
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"io"
+)
+
+// Client is a convenience wrapper around the generated Locknut client
+type Client struct {
+	c pb.LocknutClient
+}
+
+// NewClient returns a Client using conn, typically a *grpc.ClientConn
+func NewClient(conn gogrpc.ClientConnInterface) *Client {
+	return &Client{c: pb.NewLocknutClient(conn)}
+}
+
+// WithIdempotencyKey returns a context that sends key as the idempotency key of a Save or Delete, so
+// retrying the call with the same context applies it once
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, IdempotencyKeyMetadata, key)
+}
+
+// Save stores value under key, creating the bucket if needed
+func (c *Client) Save(ctx context.Context, bucket, key string, value []byte) error {
+	_, err := c.c.Save(ctx, &pb.SaveRequest{Bucket: bucket, Key: key, Value: value})
+	return err
+}
+
+// Get returns the record stored under exactly key, or nil if there is none
+func (c *Client) Get(ctx context.Context, bucket, key string) ([]byte, error) {
+	res, err := c.c.Get(ctx, &pb.GetRequest{Bucket: bucket, Key: key})
+	if err != nil || !res.Found {
+		return nil, err
+	}
+	if res.Value == nil {
+		return []byte{}, nil
+	}
+	return res.Value, nil
+}
+
+// List returns the keys of bucket starting with prefix
+func (c *Client) List(ctx context.Context, bucket, prefix string) ([]string, error) {
+	res, err := c.c.List(ctx, &pb.ListRequest{Bucket: bucket, Prefix: prefix})
+	if err != nil {
+		return nil, err
+	}
+	return res.Keys, nil
+}
+
+// Delete removes a record
+func (c *Client) Delete(ctx context.Context, bucket, key string) error {
+	_, err := c.c.Delete(ctx, &pb.DeleteRequest{Bucket: bucket, Key: key})
+	return err
+}
+
+// Watch calls fn for every change in bucket, or in all buckets when empty, to keys starting with
+// prefix made after the sequence number after. It returns when ctx is done, the stream ends or fn
+// returns an error.
+func (c *Client) Watch(ctx context.Context, bucket, prefix string, after uint64, fn func(*pb.Event) error) error {
+	stream, err := c.c.Watch(ctx, &pb.WatchRequest{Bucket: bucket, Prefix: prefix, After: after})
+	if err != nil {
+		return err
+	}
+	for {
+		ev, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if err = fn(ev); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,264 @@
+// Package grpc serves a BoltLocknut over gRPC so services in other languages can use the same
+// encrypted data. The service is defined in locknut.proto, the generated code lives in grpc/pb.
+package grpc
+
+//go:generate protoc --go_out=pb --go_opt=paths=source_relative --go-grpc_out=pb --go-grpc_opt=paths=source_relative locknut.proto
+
+import (
+	"context"
+	"errors"
+	"github.com/taybart/locknut"
+	"github.com/taybart/locknut/grpc/pb"
+	"github.com/taybart/locknut/server"
+	"github.com/taybart/log"
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// watchBatch is the number of changelog entries read per poll by Watch
+const watchBatch = 100
+
+// IdempotencyKeyMetadata is the request metadata carrying the idempotency key of a Save or Delete, so
+// a retried call is applied once, see locknut.WithIdempotencyKey
+const IdempotencyKeyMetadata = "idempotency-key"
+
+// idempotent passes the idempotency key of the incoming request on to the BoltLocknut
+func idempotent(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if keys := md.Get(IdempotencyKeyMetadata); len(keys) > 0 && keys[0] != "" {
+		return locknut.WithIdempotencyKey(ctx, keys[0])
+	}
+	return ctx
+}
+
+// Server implements the Locknut service over a BoltLocknut. Every call must be authenticated, see
+// Auth. When the BoltLocknut has a policy, see locknut.WithPolicy, every call acts as the subject of
+// its caller and is limited to what the policy grants it.
+type Server struct {
+	pb.UnimplementedLocknutServer
+	bl *locknut.BoltLocknut
+
+	// PollInterval is how often Watch checks the changelog for new changes
+	PollInterval time.Duration
+	// Auth identifies the caller of a request, from the metadata of the call as headers and the TLS
+	// state of its connection. By default the bearer tokens of the policy are checked, see
+	// server.PolicyTokens; without a policy, calls are refused until Auth is set.
+	Auth server.Authenticator
+}
+
+// store is what a call acts through, the BoltLocknut or the ScopedLocknut of the caller
+type store interface {
+	SaveBytesContext(ctx context.Context, bucket, key string, data []byte) error
+	Get(bucket, key string) ([]byte, error)
+	GetKeyList(bucket, prefix string) ([]string, error)
+	DeleteContext(ctx context.Context, bucket, key string) error
+	CreateBucket(name string) error
+}
+
+// errUnauthenticated is returned to callers that are not authenticated
+var errUnauthenticated = status.Error(codes.Unauthenticated, "unauthenticated")
+
+// callRequest returns the call of ctx as the http request Authenticators identify callers from
+func callRequest(ctx context.Context) *http.Request {
+	r := &http.Request{Method: http.MethodPost, URL: &url.URL{}, Header: make(http.Header)}
+	r.URL.Path, _ = gogrpc.Method(ctx)
+	md, _ := metadata.FromIncomingContext(ctx)
+	for k, vs := range md {
+		for _, v := range vs {
+			r.Header.Add(k, v)
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			r.TLS = &info.State
+		}
+	}
+	return r.WithContext(ctx)
+}
+
+// store returns what the call of ctx acts through, and the subject of its caller
+func (s *Server) store(ctx context.Context) (store, string, error) {
+	policy := s.bl.Policy()
+	auth := s.Auth
+	if auth == nil && policy != nil {
+		auth = server.PolicyTokens(policy)
+	}
+	if auth == nil {
+		return nil, "", errUnauthenticated
+	}
+	id, err := auth.Authenticate(callRequest(ctx))
+	if err != nil || id.Subject == "" {
+		return nil, "", errUnauthenticated
+	}
+	if policy == nil {
+		return s.bl, id.Subject, nil
+	}
+	return s.bl.As(id.Subject), id.Subject, nil
+}
+
+// NewServer returns a Server for bl
+func NewServer(bl *locknut.BoltLocknut) *Server {
+	return &Server{bl: bl, PollInterval: 500 * time.Millisecond}
+}
+
+// Register adds a Server for bl to s
+func Register(s *gogrpc.Server, bl *locknut.BoltLocknut) *Server {
+	srv := NewServer(bl)
+	pb.RegisterLocknutServer(s, srv)
+	return srv
+}
+
+// toStatus maps package errors to grpc status codes
+func toStatus(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, locknut.ErrBucketNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, locknut.ErrKeyInvalid), errors.Is(err, locknut.ErrBucketInvalid):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, locknut.ErrNoChangelog), errors.Is(err, locknut.ErrIdempotencyConflict):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, locknut.ErrDecrypt), errors.Is(err, locknut.ErrCorrupt):
+		return status.Error(codes.DataLoss, err.Error())
+	case errors.Is(err, locknut.ErrPermissionDenied):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, locknut.ErrReadOnly), errors.Is(err, locknut.ErrLocked):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, locknut.ErrMaintenance), errors.Is(err, locknut.ErrKeyUnavailable):
+		// both end on their own, the call can be retried
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	log.Error("grpc", err)
+	return status.Error(codes.Internal, "internal error")
+}
+
+// Save stores the value, creating the bucket if needed
+func (s *Server) Save(ctx context.Context, req *pb.SaveRequest) (*pb.SaveResponse, error) {
+	st, _, err := s.store(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	err = st.SaveBytesContext(idempotent(ctx), req.Bucket, req.Key, req.Value)
+	if errors.Is(err, locknut.ErrBucketNotFound) {
+		// the bucket is only created when missing, creating it on every call costs a write
+		if err = st.CreateBucket(req.Bucket); err == nil {
+			err = st.SaveBytesContext(idempotent(ctx), req.Bucket, req.Key, req.Value)
+		}
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.SaveResponse{}, nil
+}
+
+// Get returns the record stored under exactly the key
+func (s *Server) Get(ctx context.Context, req *pb.GetRequest) (*pb.GetResponse, error) {
+	st, _, err := s.store(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	v, err := st.Get(req.Bucket, req.Key)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.GetResponse{Found: v != nil, Value: v}, nil
+}
+
+// List returns the keys starting with the prefix
+func (s *Server) List(ctx context.Context, req *pb.ListRequest) (*pb.ListResponse, error) {
+	st, _, err := s.store(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	keys, err := st.GetKeyList(req.Bucket, req.Prefix)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.ListResponse{Keys: keys}, nil
+}
+
+// Delete removes the record
+func (s *Server) Delete(ctx context.Context, req *pb.DeleteRequest) (*pb.DeleteResponse, error) {
+	st, _, err := s.store(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if err := st.DeleteContext(idempotent(ctx), req.Bucket, req.Key); err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.DeleteResponse{}, nil
+}
+
+// Watch polls the changelog and streams the matching changes until the client goes away. With a
+// policy, only changes to buckets the caller may read are sent.
+func (s *Server) Watch(req *pb.WatchRequest, stream pb.Locknut_WatchServer) error {
+	_, principal, err := s.store(stream.Context())
+	if err != nil {
+		return toStatus(err)
+	}
+	policy := s.bl.Policy()
+
+	ticker := time.NewTicker(s.PollInterval)
+	defer ticker.Stop()
+
+	after := req.After
+	for {
+		changes, err := s.bl.Changes(after, watchBatch)
+		if err != nil {
+			return toStatus(err)
+		}
+
+		for _, c := range changes {
+			after = c.Seq
+			if (req.Bucket != "" && c.Bucket != req.Bucket) || !strings.HasPrefix(c.Key, req.Prefix) {
+				continue
+			}
+			if policy != nil && !policy.Allowed(principal, c.Bucket, locknut.PermRead) {
+				continue
+			}
+			if err = s.send(stream, c); err != nil {
+				return err
+			}
+		}
+		if len(changes) == watchBatch {
+			continue
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) send(stream pb.Locknut_WatchServer, c locknut.Change) error {
+	ev := &pb.Event{
+		Seq:          c.Seq,
+		Op:           pb.Event_PUT,
+		Bucket:       c.Bucket,
+		Key:          c.Key,
+		TimeUnixNano: c.Time.UnixNano(),
+	}
+	if c.Op == locknut.OpDelete {
+		ev.Op = pb.Event_DELETE
+	}
+
+	var err error
+	if ev.Value, err = s.bl.ChangeValue(c); err != nil {
+		return toStatus(err)
+	}
+	return stream.Send(ev)
+}
@@ -0,0 +1,216 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/taybart/locknut"
+	"github.com/taybart/locknut/grpc/pb"
+	"github.com/taybart/locknut/server"
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestServer(t *testing.T) {
+	if locknut.FIPSBuild() {
+		t.Skip("a short secret is not allowed in FIPS mode")
+	}
+	defer os.Remove("grpc_test.db")
+
+	bl, err := locknut.NewBoltLocknut("grpc_test.db", ".", []byte("secret"), false, nil, locknut.WithChangelog())
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+
+	lis := bufconn.Listen(1 << 20)
+	s := gogrpc.NewServer()
+	srv := Register(s, bl)
+	srv.PollInterval = 10 * time.Millisecond
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := gogrpc.Dial("bufnet",
+		gogrpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		gogrpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Dial: %s", err)
+	}
+	defer conn.Close()
+	c := NewClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// without a policy every call is refused until the server authenticates its callers
+	if err = c.Save(ctx, "articles", "a1", []byte("one")); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected unauthenticated without an authenticator, got %v", err)
+	}
+	srv.Auth = server.StaticTokens(map[string]server.Identity{"t0ken": {Subject: "ci"}})
+	if err = c.Save(ctx, "articles", "a1", []byte("one")); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected unauthenticated without a token, got %v", err)
+	}
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer t0ken")
+
+	if err = c.Save(ctx, "articles", "a1", []byte(`{"title":"one"}`)); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if err = c.Save(ctx, "articles", "b1", []byte(`{"title":"two"}`)); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+
+	v, err := c.Get(ctx, "articles", "a1")
+	if err != nil || string(v) != `{"title":"one"}` {
+		t.Errorf("unexpected Get %q %v", v, err)
+	}
+	if v, err = c.Get(ctx, "articles", "a"); err != nil || v != nil {
+		t.Errorf("expected no record for a prefix, got %q %v", v, err)
+	}
+	if _, err = c.Get(ctx, "missing", "a1"); status.Code(err) != codes.NotFound {
+		t.Errorf("expected not found, got %v", err)
+	}
+
+	keys, err := c.List(ctx, "articles", "a")
+	if err != nil || len(keys) != 1 || keys[0] != "a1" {
+		t.Errorf("unexpected List %v %v", keys, err)
+	}
+
+	if err = c.Delete(ctx, "articles", "b1"); err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+
+	var events []*pb.Event
+	watchCtx, stop := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() {
+		done <- c.Watch(watchCtx, "articles", "", 0, func(ev *pb.Event) error {
+			events = append(events, ev)
+			if len(events) == 4 {
+				stop()
+			}
+			return nil
+		})
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	if err = c.Save(ctx, "articles", "c1", []byte("three")); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	<-done
+
+	if len(events) != 4 {
+		t.Fatalf("expected 4 events, got %d", len(events))
+	}
+	if events[2].Op != pb.Event_DELETE || events[2].Key != "b1" {
+		t.Errorf("unexpected delete event %v", events[2])
+	}
+	if events[3].Key != "c1" || string(events[3].Value) != "three" {
+		t.Errorf("unexpected put event %v", events[3])
+	}
+
+	// a retried Save is applied once, even after the record changed in between
+	retry := WithIdempotencyKey(ctx, "req-1")
+	if err = c.Save(retry, "articles", "d1", []byte("first")); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if err = c.Save(ctx, "articles", "d1", []byte("second")); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if err = c.Save(retry, "articles", "d1", []byte("first")); err != nil {
+		t.Fatalf("retried Save: %s", err)
+	}
+	if v, _ = c.Get(ctx, "articles", "d1"); string(v) != "second" {
+		t.Errorf("retried Save was applied again, got %q", v)
+	}
+	if err = c.Save(retry, "articles", "d1", []byte("other")); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected failed precondition for a reused key, got %v", err)
+	}
+}
+
+func TestServerPolicy(t *testing.T) {
+	if locknut.FIPSBuild() {
+		t.Skip("a short secret is not allowed in FIPS mode")
+	}
+	defer os.Remove("grpc_policy_test.db")
+
+	policy := locknut.NewPolicy()
+	policy.Grant("reader", "articles", locknut.PermRead)
+	policy.AddToken("reader-token", "reader")
+	policy.Grant("writer", locknut.AnyBucket, locknut.PermAll)
+	policy.AddToken("writer-token", "writer")
+
+	bl, err := locknut.NewBoltLocknut("grpc_policy_test.db", ".", []byte("secret"), false, nil, locknut.WithPolicy(policy))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+
+	lis := bufconn.Listen(1 << 20)
+	s := gogrpc.NewServer()
+	Register(s, bl)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := gogrpc.Dial("bufnet",
+		gogrpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		gogrpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Dial: %s", err)
+	}
+	defer conn.Close()
+	c := NewClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	as := func(token string) context.Context {
+		return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+	}
+
+	if err = c.Save(ctx, "articles", "a1", []byte("one")); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected unauthenticated without a token, got %v", err)
+	}
+	if err = c.Save(as("nope"), "articles", "a1", []byte("one")); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected unauthenticated with an unknown token, got %v", err)
+	}
+	if err = c.Save(as("writer-token"), "articles", "a1", []byte("one")); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if v, err := c.Get(as("reader-token"), "articles", "a1"); err != nil || string(v) != "one" {
+		t.Errorf("unexpected Get %q %v", v, err)
+	}
+	if err = c.Save(as("reader-token"), "articles", "a2", []byte("two")); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected permission denied, got %v", err)
+	}
+	if err = c.Delete(as("reader-token"), "articles", "a1"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected permission denied, got %v", err)
+	}
+	if _, err = c.List(as("reader-token"), "drafts", ""); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected permission denied, got %v", err)
+	}
+}
+
+func TestToStatus(t *testing.T) {
+	for _, c := range []struct {
+		err  error
+		code codes.Code
+	}{
+		{locknut.ErrReadOnly, codes.FailedPrecondition},
+		{locknut.ErrLocked, codes.FailedPrecondition},
+		{fmt.Errorf("%w: key rotation", locknut.ErrMaintenance), codes.Unavailable},
+		{locknut.ErrKeyUnavailable, codes.Unavailable},
+		{errors.New("disk /var/lib/locknut.db is full"), codes.Internal},
+	} {
+		if code := status.Code(toStatus(c.err)); code != c.code {
+			t.Errorf("expected %s for %v, got %s", c.code, c.err, code)
+		}
+	}
+	if msg := status.Convert(toStatus(errors.New("disk /var/lib/locknut.db is full"))).Message(); msg != "internal error" {
+		t.Errorf("expected internal errors to be hidden, got %q", msg)
+	}
+}
@@ -0,0 +1,85 @@
+package locknut
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"time"
+)
+
+// ErrUnhealthy is matched by the errors of Health, which name the check that failed
+var ErrUnhealthy = errors.New("db is unhealthy")
+
+// healthTimeout bounds Health when its context has no deadline
+const healthTimeout = 5 * time.Second
+
+// Health checks that the db works end to end, for health endpoints: the file opens, the canary record
+// decrypts with the key, and a value written to a Scratch reads back the same. Read only dbs skip the
+// write. The checks must pass before the deadline of ctx, or 5 seconds when it has none; checks still
+// running then finish in the background. Errors match ErrUnhealthy and their cause.
+func (bl *BoltLocknut) Health(ctx context.Context) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, healthTimeout)
+		defer cancel()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- bl.checkHealth()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("%w: deadline: %w", ErrUnhealthy, ctx.Err())
+	}
+}
+
+// checkHealth runs the checks of Health
+func (bl *BoltLocknut) checkHealth() error {
+	if err := bl.openDB(); err != nil {
+		return fmt.Errorf("%w: open: %w", ErrUnhealthy, err)
+	}
+	defer bl.closeDB()
+
+	err := bl.db.view(func(tx *bbolt.Tx) error {
+		return bl.checkSecret(tx)
+	})
+	if err != nil {
+		return fmt.Errorf("%w: canary: %w", ErrUnhealthy, err)
+	}
+	if bl.readOnly {
+		return nil
+	}
+	if err = bl.roundTrip(); err != nil {
+		return fmt.Errorf("%w: round trip: %w", ErrUnhealthy, err)
+	}
+	return nil
+}
+
+// roundTrip writes a random value to a Scratch and reads it back
+func (bl *BoltLocknut) roundTrip() error {
+	want, err := GetRandKey()
+	if err != nil {
+		return err
+	}
+	s, err := bl.NewScratch()
+	if err != nil {
+		return err
+	}
+	if err = s.Put("health", want); err != nil {
+		s.Close()
+		return err
+	}
+	got, err := s.Get("health")
+	if cerr := s.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && !bytes.Equal(got, want) {
+		err = errors.New("value read back differs from the one written")
+	}
+	return err
+}
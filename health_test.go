@@ -0,0 +1,48 @@
+package locknut
+
+import (
+	"context"
+	"errors"
+	"go.etcd.io/bbolt"
+	"os"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("health_test.db")
+
+	bl, err := NewBoltLocknut("health_test.db", ".", []byte("secret"), false, []string{"users"})
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if err = bl.Health(context.Background()); err != nil {
+		t.Fatalf("expected a healthy db, got %s", err)
+	}
+	if names, err := bl.Buckets(); err != nil || len(names) != 1 {
+		t.Errorf("expected the round trip to leave nothing behind, got %v %v", names, err)
+	}
+
+	// a canary the key does not decrypt anymore
+	db, err := bbolt.Open("health_test.db", 0600, nil)
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(metaBucket).Put(canaryKey, []byte("garbage that is not a ciphertext at all"))
+	})
+	db.Close()
+	if err != nil {
+		t.Fatalf("Update: %s", err)
+	}
+	if err = bl.Health(context.Background()); !errors.Is(err, ErrUnhealthy) || !errors.Is(err, ErrWrongSecret) {
+		t.Errorf("expected the canary check to fail, got %v", err)
+	}
+
+	if err = bl.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	if err = bl.Health(context.Background()); !errors.Is(err, ErrUnhealthy) || !errors.Is(err, ErrLocked) {
+		t.Errorf("expected a closed db to be unhealthy, got %v", err)
+	}
+}
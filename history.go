@@ -0,0 +1,185 @@
+package locknut
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"go.etcd.io/bbolt"
+	"sort"
+)
+
+// ErrHistoryUnavailable is returned when the changelog no longer reaches back far enough to
+// reconstruct a record at the requested sequence number
+var ErrHistoryUnavailable = errors.New("history is not available at that sequence number")
+
+// recordHistory is what the changelog says about one record relative to a sequence number
+type recordHistory struct {
+	at    *Change // the latest change at or before the sequence number
+	after bool    // whether the record changed after it
+}
+
+// changelogHistory reads the changelog and summarizes it per bucket and key relative to seq. It
+// also reports whether the changelog is complete, meaning it reaches back to the first change, and
+// fails with ErrHistoryUnavailable if changes made after seq were truncated.
+func changelogHistory(tx *bbolt.Tx, seq uint64, match func(bucket, key string) bool) (map[[2]string]*recordHistory, bool, error) {
+	bkt := tx.Bucket(changelogBucket)
+	if bkt == nil {
+		return nil, false, ErrNoChangelog
+	}
+
+	oldest := bkt.Sequence() + 1
+	if k, _ := bkt.Cursor().First(); k != nil {
+		oldest = binary.BigEndian.Uint64(k)
+	}
+	if seq+1 < oldest {
+		return nil, false, ErrHistoryUnavailable
+	}
+
+	complete := oldest == 1
+	history := make(map[[2]string]*recordHistory)
+	err := bkt.ForEach(func(k, v []byte) error {
+		var c Change
+		if err := json.Unmarshal(v, &c); err != nil {
+			return err
+		}
+		if !match(c.Bucket, c.Key) {
+			return nil
+		}
+
+		id := [2]string{c.Bucket, c.Key}
+		h := history[id]
+		if h == nil {
+			h = &recordHistory{}
+			history[id] = h
+		}
+		if c.Seq <= seq {
+			h.at = &c
+		} else {
+			h.after = true
+		}
+		return nil
+	})
+	return history, complete, err
+}
+
+// storedAt returns the stored value of a record at the sequence number the history was read for,
+// given its current stored value, or nil if the record did not exist then
+func (h *recordHistory) storedAt(current []byte, complete bool) ([]byte, error) {
+	switch {
+	case h == nil || !h.after:
+		return current, nil
+	case h.at != nil:
+		return h.at.Value, nil
+	case complete:
+		// every change is known and the first one came later, so the record did not exist yet
+		return nil, nil
+	}
+	return nil, ErrHistoryUnavailable
+}
+
+// GetAt returns the value key held in bucket just after the change with sequence number seq was
+// applied, or nil if it did not exist then. It needs the changelog, and fails with
+// ErrHistoryUnavailable when the changes needed were truncated.
+func (bl *BoltLocknut) GetAt(bucket, key string, seq uint64) ([]byte, error) {
+	var err error
+	if err = bl.openDB(); err != nil {
+		return nil, err
+	}
+	defer bl.closeDB()
+
+	var stored []byte
+	err = bl.db.view(func(tx *bbolt.Tx) error {
+		history, complete, err := changelogHistory(tx, seq, func(b, k string) bool {
+			return b == bucket && k == key
+		})
+		if err != nil {
+			return err
+		}
+
+		var current []byte
+		if bkt := tx.Bucket([]byte(bucket)); bkt != nil {
+			current = bkt.Get([]byte(key))
+		}
+		stored, err = history[[2]string{bucket, key}].storedAt(current, complete)
+		return err
+	})
+	if err != nil || stored == nil {
+		return nil, err
+	}
+	return bl.decrypt(bucket, key, stored)
+}
+
+// ScanAt calls fn with every record as it was just after the change with sequence number seq was
+// applied, ordered by bucket and key. Like GetAt it needs the changelog.
+func (bl *BoltLocknut) ScanAt(seq uint64, fn func(bucket, key string, value []byte) error) error {
+	var err error
+	if err = bl.openDB(); err != nil {
+		return err
+	}
+	defer bl.closeDB()
+
+	records := make(map[[2]string][]byte)
+	err = bl.db.view(func(tx *bbolt.Tx) error {
+		history, complete, err := changelogHistory(tx, seq, func(string, string) bool { return true })
+		if err != nil {
+			return err
+		}
+
+		err = tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+			if isInternalBucket(name) {
+				return nil
+			}
+			return b.ForEach(func(k, v []byte) error {
+				if v == nil {
+					return nil
+				}
+				id := [2]string{string(name), string(k)}
+				stored, err := history[id].storedAt(v, complete)
+				if stored != nil {
+					records[id] = append([]byte(nil), stored...)
+				}
+				return err
+			})
+		})
+		if err != nil {
+			return err
+		}
+
+		// records deleted since seq are only found in the changelog
+		for id, h := range history {
+			if _, ok := records[id]; ok || h.at == nil || h.at.Op != OpPut {
+				continue
+			}
+			if bkt := tx.Bucket([]byte(id[0])); bkt != nil && bkt.Get([]byte(id[1])) != nil {
+				continue
+			}
+			records[id] = h.at.Value
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	ids := make([][2]string, 0, len(records))
+	for id := range records {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if ids[i][0] != ids[j][0] {
+			return ids[i][0] < ids[j][0]
+		}
+		return ids[i][1] < ids[j][1]
+	})
+
+	for _, id := range ids {
+		v, err := bl.decrypt(id[0], id[1], records[id])
+		if err != nil {
+			return err
+		}
+		if err = fn(id[0], id[1], v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package locknut
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestTimeTravel(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("history_test.db")
+
+	bl, err := NewBoltLocknut("history_test.db", ".", []byte("secret"), false, []string{"a", "b"}, WithChangelog())
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+
+	steps := []func() error{
+		func() error { return bl.SaveBytes("a", "k1", []byte("v1")) }, // 1
+		func() error { return bl.SaveBytes("a", "k2", []byte("x1")) }, // 2
+		func() error { return bl.SaveBytes("a", "k1", []byte("v2")) }, // 3
+		func() error { return bl.Delete("a", "k2") },                  // 4
+		func() error { return bl.SaveBytes("b", "k3", []byte("y1")) }, // 5
+	}
+	for _, step := range steps {
+		if err = step(); err != nil {
+			t.Fatalf("step: %s", err)
+		}
+	}
+
+	for _, tc := range []struct {
+		key  string
+		seq  uint64
+		want string
+	}{
+		{"k1", 0, ""},
+		{"k1", 1, "v1"},
+		{"k1", 2, "v1"},
+		{"k1", 3, "v2"},
+		{"k1", 99, "v2"},
+		{"k2", 1, ""},
+		{"k2", 3, "x1"},
+		{"k2", 4, ""},
+	} {
+		v, err := bl.GetAt("a", tc.key, tc.seq)
+		if err != nil {
+			t.Fatalf("GetAt: %s", err)
+		}
+		if string(v) != tc.want {
+			t.Errorf("GetAt(%s, %d): expected %q, got %q", tc.key, tc.seq, tc.want, v)
+		}
+	}
+
+	scan := func(seq uint64) []string {
+		var got []string
+		err := bl.ScanAt(seq, func(bucket, key string, value []byte) error {
+			got = append(got, bucket+"/"+key+"="+string(value))
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("ScanAt: %s", err)
+		}
+		return got
+	}
+	if got := scan(3); !reflect.DeepEqual(got, []string{"a/k1=v2", "a/k2=x1"}) {
+		t.Errorf("unexpected scan at 3: %v", got)
+	}
+	if got := scan(5); !reflect.DeepEqual(got, []string{"a/k1=v2", "b/k3=y1"}) {
+		t.Errorf("unexpected scan at 5: %v", got)
+	}
+
+	defer os.Remove("history_plain_test.db")
+	plain, err := NewBoltLocknut("history_plain_test.db", ".", []byte("secret"), false, []string{"a"})
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if _, err = plain.GetAt("a", "k1", 1); err != ErrNoChangelog {
+		t.Errorf("expected ErrNoChangelog, got %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package locknut
+
+import (
+	"context"
+)
+
+// Hooks are functions a BoltLocknut configured WithHooks calls around its operations, to validate,
+// redact, count or invalidate caches without wrapping every call. Any of them may be nil.
+type Hooks struct {
+	// BeforeSave is called with the value given to Save or SaveBytes, or of a record read by Import
+	// and ImportStaged, before it is validated and encrypted. It returns the value to store, or an
+	// error rejecting the save.
+	BeforeSave func(ctx context.Context, bucket, key string, value []byte) ([]byte, error)
+	// AfterSave is called once a save, or the batch of an imported record, is committed
+	AfterSave func(ctx context.Context, bucket, key string, value []byte)
+	// AfterGet is called with every value Get, GetOne, GetByPrefix and Query read, before it is returned. It
+	// returns the value to return, nil to hide the record, or an error failing the read.
+	AfterGet func(ctx context.Context, bucket, key string, value []byte) ([]byte, error)
+	// BeforeDelete is called before Delete, an error rejects the delete
+	BeforeDelete func(ctx context.Context, bucket, key string) error
+	// AfterDelete is called once a delete is committed
+	AfterDelete func(ctx context.Context, bucket, key string)
+}
+
+// WithHooks adds h to the hooks of the BoltLocknut. Hooks nest like middleware: the Before hooks run
+// in the order they were added, the After hooks in the reverse order. Hooks are called outside of
+// transactions, they may use the BoltLocknut.
+func WithHooks(h Hooks) Option {
+	return func(bl *BoltLocknut) {
+		bl.hooks = append(bl.hooks, h)
+	}
+}
+
+func (bl *BoltLocknut) beforeSave(ctx context.Context, bucket, key string, value []byte) ([]byte, error) {
+	for _, h := range bl.hooks {
+		if h.BeforeSave == nil {
+			continue
+		}
+		var err error
+		if value, err = h.BeforeSave(ctx, bucket, key, value); err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+func (bl *BoltLocknut) afterSave(ctx context.Context, bucket, key string, value []byte) {
+	for i := len(bl.hooks) - 1; i >= 0; i-- {
+		if h := bl.hooks[i]; h.AfterSave != nil {
+			h.AfterSave(ctx, bucket, key, value)
+		}
+	}
+}
+
+func (bl *BoltLocknut) afterGet(ctx context.Context, bucket, key string, value []byte) ([]byte, error) {
+	for i := len(bl.hooks) - 1; i >= 0 && value != nil; i-- {
+		if h := bl.hooks[i]; h.AfterGet != nil {
+			var err error
+			if value, err = h.AfterGet(ctx, bucket, key, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return value, nil
+}
+
+func (bl *BoltLocknut) beforeDelete(ctx context.Context, bucket, key string) error {
+	for _, h := range bl.hooks {
+		if h.BeforeDelete == nil {
+			continue
+		}
+		if err := h.BeforeDelete(ctx, bucket, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (bl *BoltLocknut) afterDelete(ctx context.Context, bucket, key string) {
+	for i := len(bl.hooks) - 1; i >= 0; i-- {
+		if h := bl.hooks[i]; h.AfterDelete != nil {
+			h.AfterDelete(ctx, bucket, key)
+		}
+	}
+}
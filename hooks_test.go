@@ -0,0 +1,111 @@
+package locknut
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHooks(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("hooks_test.db")
+
+	var calls []string
+	errReadOnlyKey := errors.New("key is read only")
+	trace := func(name string) Hooks {
+		return Hooks{
+			BeforeSave: func(ctx context.Context, bucket, key string, value []byte) ([]byte, error) {
+				calls = append(calls, name+" before save "+key)
+				if key == "locked" {
+					return nil, errReadOnlyKey
+				}
+				return value, nil
+			},
+			AfterSave: func(ctx context.Context, bucket, key string, value []byte) {
+				calls = append(calls, name+" after save "+key)
+			},
+			BeforeDelete: func(ctx context.Context, bucket, key string) error {
+				calls = append(calls, name+" before delete "+key)
+				return nil
+			},
+			AfterDelete: func(ctx context.Context, bucket, key string) {
+				calls = append(calls, name+" after delete "+key)
+			},
+		}
+	}
+	// redacts emails on the way out and hides drafts
+	redact := Hooks{
+		BeforeSave: func(ctx context.Context, bucket, key string, value []byte) ([]byte, error) {
+			return bytes.TrimSpace(value), nil
+		},
+		AfterGet: func(ctx context.Context, bucket, key string, value []byte) ([]byte, error) {
+			if bytes.HasPrefix(value, []byte("draft:")) {
+				return nil, nil
+			}
+			return bytes.ReplaceAll(value, []byte("ada@example.com"), []byte("[email]")), nil
+		},
+	}
+
+	bl, err := NewBoltLocknut("hooks_test.db", ".", []byte("secret"), false, []string{"notes"},
+		WithHooks(trace("outer")), WithHooks(redact), WithHooks(trace("inner")))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+
+	if err = bl.SaveBytes("notes", "a", []byte("  mail ada@example.com  ")); err != nil {
+		t.Fatalf("SaveBytes: %s", err)
+	}
+	if err = bl.SaveBytes("notes", "b", []byte("draft: secret plans")); err != nil {
+		t.Fatalf("SaveBytes: %s", err)
+	}
+	if err = bl.SaveBytes("notes", "locked", []byte("x")); !errors.Is(err, errReadOnlyKey) {
+		t.Errorf("expected the hook's error, got %v", err)
+	}
+	if n, err := bl.Count("notes"); err != nil || n != 2 {
+		t.Errorf("expected 2 records, got %d %v", n, err)
+	}
+	if err = bl.Delete("notes", "b"); err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+
+	want := []string{
+		"outer before save a", "inner before save a", "inner after save a", "outer after save a",
+		"outer before save b", "inner before save b", "inner after save b", "outer after save b",
+		"outer before save locked",
+		"outer before delete b", "inner before delete b", "inner after delete b", "outer after delete b",
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("expected %q, got %q", want, calls)
+	}
+
+	if err = bl.SaveBytes("notes", "b", []byte("draft: again")); err != nil {
+		t.Fatalf("SaveBytes: %s", err)
+	}
+	if v, err := bl.Get("notes", "a"); err != nil || string(v) != "mail [email]" {
+		t.Errorf("unexpected Get %q %v", v, err)
+	}
+	if v, err := bl.GetOne("notes", "b"); err != nil || v != nil {
+		t.Errorf("expected the draft hidden, got %q %v", v, err)
+	}
+	if all, err := bl.GetByPrefix("notes", ""); err != nil || len(all) != 1 || string(all["a"]) != "mail [email]" {
+		t.Errorf("unexpected GetByPrefix %q %v", all, err)
+	}
+
+	// imported records go through the save hooks too
+	calls = nil
+	if err = bl.Import(strings.NewReader(`{"key":"c","value":"imported"}`+"\n"), FormatJSONL, "notes"); err != nil {
+		t.Fatalf("Import: %s", err)
+	}
+	want = []string{"outer before save c", "inner before save c", "inner after save c", "outer after save c"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("expected %q, got %q", want, calls)
+	}
+	err = bl.Import(strings.NewReader(`{"key":"locked","value":"imported"}`+"\n"), FormatJSONL, "notes")
+	if !errors.Is(err, errReadOnlyKey) {
+		t.Errorf("expected the hook's error, got %v", err)
+	}
+}
@@ -0,0 +1,284 @@
+//go:build go1.24
+
+// Package hybrid implements locknut.KeyProvider with a post-quantum hybrid of X25519 and ML-KEM-768
+// (FIPS 203), wrapping the db key to one or more recipients the way the age package does. The key
+// that wraps the db key for a recipient is derived from both shared secrets, so an attacker who
+// records the key file today and gets a quantum computer later still has to break ML-KEM, and one who
+// breaks ML-KEM still has to break X25519. It needs Go 1.24 for crypto/mlkem. X25519 is not an
+// approved algorithm, so like the age package it fails with locknut.ErrNotFIPS in a build with the
+// locknut_fips tag.
+package hybrid
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/mlkem"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/taybart/locknut"
+	"os"
+	"strings"
+)
+
+const (
+	identityPrefix  = "locknut-hybrid-identity:"
+	recipientPrefix = "locknut-hybrid-recipient:"
+)
+
+// A wrapped key is fileMagic, the number of stanzas as a uint16 and one stanza per recipient:
+//
+//	ephemeral X25519 public key (32) | ML-KEM-768 ciphertext (1088) | db key wrapped by Cryptor.WrapKey
+//
+// the wrapped key being prefixed with its uint16 length. The key wrapping the db key is HKDF-SHA256
+// of both shared secrets, bound to the ephemeral key, the ciphertext and the recipient.
+const fileMagic = "locknut hybrid v1\n"
+
+const wrapInfo = "locknut hybrid wrap"
+
+var (
+	// ErrNoMatch is returned by Unwrap when none of the identities is a recipient of the key
+	ErrNoMatch = errors.New("hybrid: no identity matches a recipient")
+	// ErrMalformed is returned for identities, recipients and wrapped keys that do not parse
+	ErrMalformed = errors.New("hybrid: malformed input")
+)
+
+// Identity is the private half of a recipient, an X25519 and an ML-KEM-768 decapsulation key
+type Identity struct {
+	x *ecdh.PrivateKey
+	m *mlkem.DecapsulationKey768
+}
+
+// Recipient is a public key the db key is wrapped to
+type Recipient struct {
+	x *ecdh.PublicKey
+	m *mlkem.EncapsulationKey768
+}
+
+// GenerateIdentity returns a new identity
+func GenerateIdentity() (*Identity, error) {
+	x, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	m, err := mlkem.GenerateKey768()
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{x: x, m: m}, nil
+}
+
+// Recipient returns the recipient of the identity
+func (id *Identity) Recipient() *Recipient {
+	return &Recipient{x: id.x.PublicKey(), m: id.m.EncapsulationKey()}
+}
+
+// String encodes the identity, to be kept as secret as the db key, see ParseIdentity
+func (id *Identity) String() string {
+	return identityPrefix + base64.RawURLEncoding.EncodeToString(append(id.x.Bytes(), id.m.Bytes()...))
+}
+
+// ParseIdentity decodes an identity encoded by Identity.String
+func ParseIdentity(s string) (*Identity, error) {
+	b, err := decode(identityPrefix, s, 32+mlkem.SeedSize)
+	if err != nil {
+		return nil, err
+	}
+	x, err := ecdh.X25519().NewPrivateKey(b[:32])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	m, err := mlkem.NewDecapsulationKey768(b[32:])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return &Identity{x: x, m: m}, nil
+}
+
+// String encodes the recipient, see ParseRecipient
+func (r *Recipient) String() string {
+	return recipientPrefix + base64.RawURLEncoding.EncodeToString(append(r.x.Bytes(), r.m.Bytes()...))
+}
+
+// ParseRecipient decodes a recipient encoded by Recipient.String
+func ParseRecipient(s string) (*Recipient, error) {
+	b, err := decode(recipientPrefix, s, 32+mlkem.EncapsulationKeySize768)
+	if err != nil {
+		return nil, err
+	}
+	x, err := ecdh.X25519().NewPublicKey(b[:32])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	m, err := mlkem.NewEncapsulationKey768(b[32:])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return &Recipient{x: x, m: m}, nil
+}
+
+func decode(prefix, s string, size int) ([]byte, error) {
+	if !strings.HasPrefix(s, prefix) {
+		return nil, ErrMalformed
+	}
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(strings.TrimPrefix(s, prefix)))
+	if err != nil || len(b) != size {
+		return nil, ErrMalformed
+	}
+	return b, nil
+}
+
+// Provider wraps keys to hybrid recipients and unwraps them with identities
+type Provider struct {
+	recipients []*Recipient
+	identities []*Identity
+	keyFile    string
+}
+
+var _ locknut.KeyProvider = (*Provider)(nil)
+
+// Option configures a Provider, passed to New
+type Option func(*Provider)
+
+// WithRecipients adds recipients the db key is wrapped to, needed to create the key and by Rewrap
+func WithRecipients(recipients ...*Recipient) Option {
+	return func(p *Provider) {
+		p.recipients = append(p.recipients, recipients...)
+	}
+}
+
+// WithIdentities adds identities tried to unwrap the db key, one matching any recipient will do
+func WithIdentities(identities ...*Identity) Option {
+	return func(p *Provider) {
+		p.identities = append(p.identities, identities...)
+	}
+}
+
+// New returns a Provider keeping the wrapped db key in keyFile
+func New(keyFile string, opts ...Option) *Provider {
+	p := &Provider{keyFile: keyFile}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// GetKey returns the db key, created on first use, see locknut.EnvelopeKey
+func (p *Provider) GetKey(ctx context.Context) ([]byte, error) {
+	return locknut.EnvelopeKey(ctx, p, p.keyFile)
+}
+
+// wrapKey derives the key wrapping the db key for a recipient from both shared secrets
+func wrapKey(sharedX, sharedM, eph, ct []byte, r *Recipient) []byte {
+	secret := append(append([]byte(nil), sharedX...), sharedM...)
+	salt := bytes.Join([][]byte{eph, ct, r.x.Bytes(), r.m.Bytes()}, nil)
+	return locknut.HKDF(secret, salt, []byte(wrapInfo), 32)
+}
+
+// Wrap encrypts key to every recipient
+func (p *Provider) Wrap(ctx context.Context, key []byte) ([]byte, error) {
+	if locknut.FIPSBuild() {
+		return nil, fmt.Errorf("hybrid: %w", locknut.ErrNotFIPS)
+	}
+	if len(p.recipients) == 0 {
+		return nil, errors.New("hybrid: no recipients")
+	}
+	out := append([]byte(fileMagic), 0, 0)
+	binary.BigEndian.PutUint16(out[len(fileMagic):], uint16(len(p.recipients)))
+
+	var c locknut.Cryptor
+	for _, r := range p.recipients {
+		eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		sharedX, err := eph.ECDH(r.x)
+		if err != nil {
+			return nil, err
+		}
+		sharedM, ct := r.m.Encapsulate()
+
+		wrapped, err := c.WrapKey(key, wrapKey(sharedX, sharedM, eph.PublicKey().Bytes(), ct, r))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, eph.PublicKey().Bytes()...)
+		out = append(out, ct...)
+		out = binary.BigEndian.AppendUint16(out, uint16(len(wrapped)))
+		out = append(out, wrapped...)
+	}
+	return out, nil
+}
+
+// Unwrap decrypts a key wrapped by Wrap with the first identity matching one of its recipients
+func (p *Provider) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	if locknut.FIPSBuild() {
+		return nil, fmt.Errorf("hybrid: %w", locknut.ErrNotFIPS)
+	}
+	if len(p.identities) == 0 {
+		return nil, errors.New("hybrid: no identities")
+	}
+	if !bytes.HasPrefix(wrapped, []byte(fileMagic)) || len(wrapped) < len(fileMagic)+2 {
+		return nil, ErrMalformed
+	}
+	rest := wrapped[len(fileMagic):]
+	n := int(binary.BigEndian.Uint16(rest))
+	rest = rest[2:]
+
+	var c locknut.Cryptor
+	for i := 0; i < n; i++ {
+		if len(rest) < 32+mlkem.CiphertextSize768+2 {
+			return nil, ErrMalformed
+		}
+		eph, ct := rest[:32], rest[32:32+mlkem.CiphertextSize768]
+		rest = rest[32+mlkem.CiphertextSize768:]
+		size := int(binary.BigEndian.Uint16(rest))
+		if len(rest) < 2+size {
+			return nil, ErrMalformed
+		}
+		sealed := rest[2 : 2+size]
+		rest = rest[2+size:]
+
+		ephKey, err := ecdh.X25519().NewPublicKey(eph)
+		if err != nil {
+			return nil, ErrMalformed
+		}
+		for _, id := range p.identities {
+			sharedX, err := id.x.ECDH(ephKey)
+			if err != nil {
+				continue
+			}
+			sharedM, err := id.m.Decapsulate(ct)
+			if err != nil {
+				return nil, ErrMalformed
+			}
+			if key, err := c.UnwrapKey(sealed, wrapKey(sharedX, sharedM, eph, ct, id.Recipient())); err == nil {
+				return key, nil
+			}
+		}
+	}
+	return nil, ErrNoMatch
+}
+
+// Rewrap unwraps the db key with the identities and wraps it again to the recipients given
+// WithRecipients, in fresh stanzas with new X25519 and ML-KEM-768 encapsulations, replacing the key
+// file. The db key is kept, so adding or removing recipients does not re-encrypt the db. The stanza
+// of a removed recipient is gone from the new file only: copies of the old one still open with its
+// identity, so follow up with RotateKey when that identity must lose access.
+func (p *Provider) Rewrap(ctx context.Context) error {
+	wrapped, err := os.ReadFile(p.keyFile)
+	if err != nil {
+		return err
+	}
+	key, err := p.Unwrap(ctx, wrapped)
+	if err != nil {
+		return err
+	}
+	if wrapped, err = p.Wrap(ctx, key); err != nil {
+		return err
+	}
+	return locknut.WriteKeyFile(p.keyFile, wrapped, true)
+}
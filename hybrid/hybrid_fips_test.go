@@ -0,0 +1,27 @@
+//go:build go1.24 && locknut_fips
+
+package hybrid
+
+import (
+	"context"
+	"errors"
+	"github.com/taybart/locknut"
+	"path/filepath"
+	"testing"
+)
+
+func TestProviderFIPS(t *testing.T) {
+	ctx := context.Background()
+	id, _ := GenerateIdentity()
+	p := New(filepath.Join(t.TempDir(), "db.key"), WithRecipients(id.Recipient()), WithIdentities(id))
+
+	if _, err := p.GetKey(ctx); !errors.Is(err, locknut.ErrNotFIPS) {
+		t.Errorf("GetKey: expected ErrNotFIPS, got %v", err)
+	}
+	if _, err := p.Wrap(ctx, []byte("0123456789abcdef0123456789abcdef")); !errors.Is(err, locknut.ErrNotFIPS) {
+		t.Errorf("Wrap: expected ErrNotFIPS, got %v", err)
+	}
+	if _, err := p.Unwrap(ctx, []byte(fileMagic)); !errors.Is(err, locknut.ErrNotFIPS) {
+		t.Errorf("Unwrap: expected ErrNotFIPS, got %v", err)
+	}
+}
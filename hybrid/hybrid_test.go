@@ -0,0 +1,96 @@
+//go:build go1.24
+
+package hybrid
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/taybart/locknut"
+	"path/filepath"
+	"testing"
+)
+
+func TestProvider(t *testing.T) {
+	if locknut.FIPSBuild() {
+		t.Skip("X25519 is not allowed in FIPS mode")
+	}
+	ctx := context.Background()
+	alice, _ := GenerateIdentity()
+	bob, _ := GenerateIdentity()
+	carol, _ := GenerateIdentity()
+	keyFile := filepath.Join(t.TempDir(), "db.key")
+
+	key, err := New(keyFile,
+		WithRecipients(alice.Recipient(), bob.Recipient()),
+		WithIdentities(alice),
+	).GetKey(ctx)
+	if err != nil {
+		t.Fatalf("GetKey: %s", err)
+	}
+	if len(key) != 32 {
+		t.Fatalf("expected a 32 byte key, got %d", len(key))
+	}
+
+	if got, err := New(keyFile, WithIdentities(bob)).GetKey(ctx); err != nil || !bytes.Equal(got, key) {
+		t.Errorf("expected the second recipient to unwrap the key, got %v", err)
+	}
+	if _, err = New(keyFile, WithIdentities(carol)).GetKey(ctx); !errors.Is(err, ErrNoMatch) {
+		t.Errorf("expected ErrNoMatch for an identity that is not a recipient, got %v", err)
+	}
+
+	// carol replaces bob
+	admin := New(keyFile, WithRecipients(alice.Recipient(), carol.Recipient()), WithIdentities(alice))
+	if err = admin.Rewrap(ctx); err != nil {
+		t.Fatalf("Rewrap: %s", err)
+	}
+	if got, err := New(keyFile, WithIdentities(carol)).GetKey(ctx); err != nil || !bytes.Equal(got, key) {
+		t.Errorf("expected the new recipient to unwrap the same key, got %v", err)
+	}
+	if _, err = New(keyFile, WithIdentities(bob)).GetKey(ctx); !errors.Is(err, ErrNoMatch) {
+		t.Errorf("expected the removed recipient to fail, got %v", err)
+	}
+}
+
+func TestUnwrapTampered(t *testing.T) {
+	if locknut.FIPSBuild() {
+		t.Skip("X25519 is not allowed in FIPS mode")
+	}
+	ctx := context.Background()
+	id, _ := GenerateIdentity()
+	p := New("", WithRecipients(id.Recipient()), WithIdentities(id))
+
+	wrapped, err := p.Wrap(ctx, []byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("Wrap: %s", err)
+	}
+	// flip a byte of the ML-KEM ciphertext, the X25519 half alone must not unwrap the key
+	wrapped[len(fileMagic)+2+32+10] ^= 1
+	if _, err = p.Unwrap(ctx, wrapped); !errors.Is(err, ErrNoMatch) {
+		t.Errorf("expected ErrNoMatch, got %v", err)
+	}
+	if _, err = p.Unwrap(ctx, wrapped[:40]); !errors.Is(err, ErrMalformed) {
+		t.Errorf("expected ErrMalformed for a truncated key, got %v", err)
+	}
+}
+
+func TestEncoding(t *testing.T) {
+	id, _ := GenerateIdentity()
+	parsed, err := ParseIdentity(id.String())
+	if err != nil {
+		t.Fatalf("ParseIdentity: %s", err)
+	}
+	if parsed.Recipient().String() != id.Recipient().String() {
+		t.Error("expected the parsed identity to have the same recipient")
+	}
+	r, err := ParseRecipient(id.Recipient().String())
+	if err != nil {
+		t.Fatalf("ParseRecipient: %s", err)
+	}
+	if r.String() != id.Recipient().String() {
+		t.Error("expected the recipient to round trip")
+	}
+	if _, err = ParseRecipient(id.String()); !errors.Is(err, ErrMalformed) {
+		t.Errorf("expected ErrMalformed for an identity parsed as a recipient, got %v", err)
+	}
+}
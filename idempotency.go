@@ -0,0 +1,146 @@
+package locknut
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"go.etcd.io/bbolt"
+	"io"
+	"time"
+)
+
+// idempotencyBucket records the idempotency keys of applied writes. Its "keys" bucket maps a key to
+// its expiry and the fingerprint of the write, its "expiry" bucket orders the keys by expiry so
+// expired ones are swept without a full scan. Fingerprints are keyed with the db secret, or with a
+// random key kept in the bucket when the db has none, so they do not give away the values written.
+var idempotencyBucket = []byte(internalPrefix + "idempotency")
+
+// defaultIdempotencyTTL is how long an idempotency key is remembered unless WithIdempotencyTTL is set
+const defaultIdempotencyTTL = 24 * time.Hour
+
+// ErrIdempotencyConflict is returned when an idempotency key is reused for a different write
+var ErrIdempotencyConflict = errors.New("idempotency key was used for a different write")
+
+type idempotencyKeyCtx struct{}
+
+// WithIdempotencyKey returns a context that makes SaveContext, SaveBytesContext and DeleteContext
+// apply their write at most once for key. A retry with the same key and the same write is a no-op
+// that succeeds, reusing the key for a different write fails with ErrIdempotencyConflict. Keys are
+// remembered for the ttl set with WithIdempotencyTTL, a day by default. A retry coming after RotateKey
+// is taken for a different write.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyCtx{}, key)
+}
+
+func idempotencyKey(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	key, _ := ctx.Value(idempotencyKeyCtx{}).(string)
+	return key
+}
+
+// WithIdempotencyTTL sets how long idempotency keys are remembered
+func WithIdempotencyTTL(ttl time.Duration) Option {
+	return func(bl *BoltLocknut) {
+		bl.idempotencyTTL = ttl
+	}
+}
+
+// idempotencyMacKey holds, in idempotencyBucket, the random key of the fingerprints of a db without a
+// secret, such as a write only or unencrypted one
+var idempotencyMacKey = []byte("mac")
+
+// fingerprintKey returns the key of the write fingerprints, derived from the secret, or for a db
+// without one the random key kept in root, created on first use. Records sealed to a public key do not
+// use the secret, which is then the key of an empty one anyone can derive.
+func (bl *BoltLocknut) fingerprintKey(root *bbolt.Bucket) ([]byte, error) {
+	if bl.secret != nil && bl.public == nil && !bytes.Equal(bl.secret, deriveSecret(nil)) {
+		return HKDF(bl.secret, nil, []byte("locknut idempotency"), 32), nil
+	}
+	if k := root.Get(idempotencyMacKey); k != nil {
+		return append([]byte(nil), k...), nil
+	}
+	k := make([]byte, 32)
+	if _, err := io.ReadFull(bl.randReader(), k); err != nil {
+		return nil, err
+	}
+	return k, root.Put(idempotencyMacKey, k)
+}
+
+// writeFingerprint identifies a write, so a retry can be told apart from a different write
+func writeFingerprint(mac []byte, op, bucket, key string, data []byte) []byte {
+	h := hmac.New(sha256.New, mac)
+	for _, part := range [][]byte{[]byte(op), []byte(bucket), []byte(key), data} {
+		h.Write(part)
+		h.Write([]byte{0})
+	}
+	return h.Sum(nil)
+}
+
+// claimIdempotencyKey records the idempotency key of the context for the write, and reports whether
+// the write was already applied under it. The record is part of the transaction, so it is dropped
+// when the write fails.
+func (w *writeTx) claimIdempotencyKey(op, bucket, key string, data []byte) (bool, error) {
+	idemKey := idempotencyKey(w.ctx)
+	if idemKey == "" {
+		return false, nil
+	}
+
+	root, err := w.tx.CreateBucketIfNotExists(idempotencyBucket)
+	if err != nil {
+		return false, err
+	}
+	keys, err := root.CreateBucketIfNotExists([]byte("keys"))
+	if err != nil {
+		return false, err
+	}
+	expiry, err := root.CreateBucketIfNotExists([]byte("expiry"))
+	if err != nil {
+		return false, err
+	}
+
+	now := w.bl.now()
+	if err = sweepIdempotencyKeys(keys, expiry, now); err != nil {
+		return false, err
+	}
+
+	mac, err := w.bl.fingerprintKey(root)
+	if err != nil {
+		return false, err
+	}
+	fingerprint := writeFingerprint(mac, op, bucket, key, data)
+	if v := keys.Get([]byte(idemKey)); v != nil {
+		if !bytes.Equal(v[8:], fingerprint) {
+			return true, ErrIdempotencyConflict
+		}
+		return true, nil
+	}
+
+	ttl := w.bl.idempotencyTTL
+	if ttl <= 0 {
+		ttl = defaultIdempotencyTTL
+	}
+	expires := seqKey(uint64(now.Add(ttl).UnixNano()))
+	if err = keys.Put([]byte(idemKey), append(expires, fingerprint...)); err != nil {
+		return false, err
+	}
+	return false, expiry.Put(append(expires, idemKey...), nil)
+}
+
+// sweepIdempotencyKeys forgets the keys that expired before now
+func sweepIdempotencyKeys(keys, expiry *bbolt.Bucket, now time.Time) error {
+	limit := seqKey(uint64(now.UnixNano()))
+	c := expiry.Cursor()
+	for k, _ := c.First(); k != nil && bytes.Compare(k[:8], limit) < 0; k, _ = c.First() {
+		if err := keys.Delete(k[8:]); err != nil {
+			return err
+		}
+		if err := c.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
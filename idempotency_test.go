@@ -0,0 +1,169 @@
+package locknut
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"go.etcd.io/bbolt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("idempotency_test.db")
+
+	bl, err := NewBoltLocknut("idempotency_test.db", ".", []byte("secret"), false, []string{"counters"},
+		WithIdempotencyTTL(time.Hour))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+
+	ctx := WithIdempotencyKey(context.Background(), "req-1")
+	if err = bl.SaveContext(ctx, "counters", "visits", 1); err != nil {
+		t.Fatalf("SaveContext: %s", err)
+	}
+	if err = bl.Save("counters", "visits", 2); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if err = bl.SaveContext(ctx, "counters", "visits", 1); err != nil {
+		t.Fatalf("retried SaveContext: %s", err)
+	}
+	if v, _ := bl.Get("counters", "visits"); string(v) != "2" {
+		t.Errorf("retried write was applied again, got %s", v)
+	}
+	if err = bl.SaveContext(ctx, "counters", "visits", 3); err != ErrIdempotencyConflict {
+		t.Errorf("expected ErrIdempotencyConflict, got %v", err)
+	}
+
+	del := WithIdempotencyKey(context.Background(), "req-2")
+	if err = bl.DeleteContext(del, "counters", "visits"); err != nil {
+		t.Fatalf("DeleteContext: %s", err)
+	}
+	if err = bl.Save("counters", "visits", 4); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if err = bl.DeleteContext(del, "counters", "visits"); err != nil {
+		t.Fatalf("retried DeleteContext: %s", err)
+	}
+	if v, _ := bl.Get("counters", "visits"); string(v) != "4" {
+		t.Errorf("retried delete was applied again, got %s", v)
+	}
+
+	// a failed write does not use up its key
+	failed := WithIdempotencyKey(context.Background(), "req-3")
+	if err = bl.SaveContext(failed, "missing", "a", 1); err == nil {
+		t.Fatalf("expected an error saving to a missing bucket")
+	}
+	if err = bl.SaveContext(failed, "counters", "a", 1); err != nil {
+		t.Errorf("SaveContext after a failed write with the same key: %s", err)
+	}
+
+	// the fingerprint is keyed, a plain hash of the value would be checked against guesses
+	if err = bl.openDB(); err != nil {
+		t.Fatalf("openDB: %s", err)
+	}
+	defer bl.closeDB()
+	err = bl.db.view(func(tx *bbolt.Tx) error {
+		root := tx.Bucket(idempotencyBucket)
+		stored := root.Bucket([]byte("keys")).Get([]byte("req-1"))[8:]
+		mac, err := bl.fingerprintKey(root)
+		if err != nil {
+			return err
+		}
+		if want := writeFingerprint(mac, "save", "counters", "visits", []byte("1")); !bytes.Equal(stored, want) {
+			t.Errorf("expected the fingerprint %x, got %x", want, stored)
+		}
+		if unkeyed := writeFingerprint(nil, "save", "counters", "visits", []byte("1")); bytes.Equal(stored, unkeyed) {
+			t.Errorf("expected the fingerprint keyed with the secret")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("view: %s", err)
+	}
+}
+
+func TestIdempotencyKeyNoSecret(t *testing.T) {
+	skipFIPS(t, "X25519 record sealing")
+	defer os.Remove("idempotency_plain_test.db")
+
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %s", err)
+	}
+	bl, err := NewBoltLocknut("idempotency_plain_test.db", ".", nil, false, []string{"counters"},
+		WithPublicKey(key.PublicKey()))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	ctx := WithIdempotencyKey(context.Background(), "req-1")
+	for i := 0; i < 2; i++ {
+		if err = bl.SaveContext(ctx, "counters", "visits", 1); err != nil {
+			t.Fatalf("SaveContext: %s", err)
+		}
+	}
+	if err = bl.SaveContext(ctx, "counters", "visits", 2); err != ErrIdempotencyConflict {
+		t.Errorf("expected ErrIdempotencyConflict, got %v", err)
+	}
+
+	// a write only db has no secret, the fingerprint is keyed with a random key of the db
+	if err = bl.openDB(); err != nil {
+		t.Fatalf("openDB: %s", err)
+	}
+	defer bl.closeDB()
+	err = bl.db.view(func(tx *bbolt.Tx) error {
+		root := tx.Bucket(idempotencyBucket)
+		mac := root.Get(idempotencyMacKey)
+		if len(mac) != 32 {
+			t.Fatalf("expected a 32 byte fingerprint key, got %x", mac)
+		}
+		stored := root.Bucket([]byte("keys")).Get([]byte("req-1"))[8:]
+		if want := writeFingerprint(mac, "save", "counters", "visits", []byte("1")); !bytes.Equal(stored, want) {
+			t.Errorf("expected the fingerprint %x, got %x", want, stored)
+		}
+		if unkeyed := writeFingerprint(nil, "save", "counters", "visits", []byte("1")); bytes.Equal(stored, unkeyed) {
+			t.Errorf("expected the fingerprint keyed")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("view: %s", err)
+	}
+}
+
+func TestIdempotencyKeyExpiry(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("idempotency_expiry_test.db")
+
+	bl, err := NewBoltLocknut("idempotency_expiry_test.db", ".", []byte("secret"), false, []string{"counters"},
+		WithIdempotencyTTL(time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+
+	ctx := WithIdempotencyKey(context.Background(), "req-1")
+	if err = bl.SaveContext(ctx, "counters", "visits", 1); err != nil {
+		t.Fatalf("SaveContext: %s", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if err = bl.SaveContext(ctx, "counters", "visits", 2); err != nil {
+		t.Errorf("expected an expired key to be reusable, got %v", err)
+	}
+
+	if err = bl.openDB(); err != nil {
+		t.Fatalf("openDB: %s", err)
+	}
+	defer bl.closeDB()
+	err = bl.db.view(func(tx *bbolt.Tx) error {
+		if n := tx.Bucket(idempotencyBucket).Bucket([]byte("expiry")).Stats().KeyN; n != 1 {
+			t.Errorf("expected the expired key to be swept, got %d keys", n)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("view: %s", err)
+	}
+}
@@ -0,0 +1,193 @@
+package locknut
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// importBatchSize is the number of records written per transaction by Import
+const importBatchSize = 1000
+
+// WithImportWorkers sets the number of goroutines Import encrypts records with, GOMAXPROCS by default
+func WithImportWorkers(n int) Option {
+	return func(bl *BoltLocknut) {
+		bl.importWorkers = n
+	}
+}
+
+// importBatch is a batch of records with their encrypted values, or the error that ended the import
+type importBatch struct {
+	records []Record
+	stored  [][]byte
+	err     error
+}
+
+// Import reads the records in r, encrypts them and bulk loads them in large transactions. Records are
+// written to bucket, or to their own Bucket field when bucket is "", creating buckets as needed.
+// It reads the FormatJSON and FormatJSONL documents written by Export. Decoding, encryption and
+// writing overlap: while a batch is written the next one is decoded and encrypted by a pool of
+// workers, see WithImportWorkers. The BeforeSave hooks are called for every record as it is decoded,
+// the AfterSave hooks once its batch is committed.
+func (bl *BoltLocknut) Import(r io.Reader, format Format, bucket string) error {
+	return bl.importRecords(r, format, bucket, func(b importBatch) error {
+		if err := bl.writeBatch(b); err != nil {
+			return err
+		}
+		for _, rec := range b.records {
+			bl.afterSave(context.Background(), rec.Bucket, rec.Key, rec.Bytes())
+		}
+		return nil
+	})
+}
+
+// importRecords decodes the records in r, passes them through the BeforeSave hooks and encrypts them,
+// handing each batch to write
+func (bl *BoltLocknut) importRecords(r io.Reader, format Format, bucket string, write func(importBatch) error) error {
+	dec := json.NewDecoder(r)
+
+	switch format {
+	case FormatJSON:
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+			return fmt.Errorf("import: expected a json array")
+		}
+	case FormatJSONL:
+	default:
+		return fmt.Errorf("unsupported import format %s", format)
+	}
+
+	batches := make(chan importBatch, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(batches)
+		send := func(b importBatch) bool {
+			select {
+			case batches <- b:
+				return b.err == nil
+			case <-done:
+				return false
+			}
+		}
+
+		batch := make([]Record, 0, importBatchSize)
+		for dec.More() {
+			var rec Record
+			if err := dec.Decode(&rec); err != nil {
+				send(importBatch{err: err})
+				return
+			}
+			if bucket != "" {
+				rec.Bucket = bucket
+			}
+			if rec.Bucket == "" || rec.Key == "" {
+				send(importBatch{err: fmt.Errorf("import: record without bucket or key")})
+				return
+			}
+			if len(bl.hooks) > 0 {
+				value, err := bl.beforeSave(context.Background(), rec.Bucket, rec.Key, rec.Bytes())
+				if err != nil {
+					send(importBatch{err: err})
+					return
+				}
+				rec = newRecord(rec.Bucket, rec.Key, value)
+			}
+
+			batch = append(batch, rec)
+			if len(batch) == importBatchSize {
+				if !send(bl.sealBatch(batch)) {
+					return
+				}
+				batch = make([]Record, 0, importBatchSize)
+			}
+		}
+		if len(batch) > 0 {
+			send(bl.sealBatch(batch))
+		}
+	}()
+
+	for b := range batches {
+		if b.err != nil {
+			return b.err
+		}
+		if err := write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sealBatch encrypts the values of records with the import workers
+func (bl *BoltLocknut) sealBatch(records []Record) importBatch {
+	b := importBatch{records: records, stored: make([][]byte, len(records))}
+	if bl.secret == nil && bl.public == nil {
+		for i, rec := range records {
+			b.stored[i] = rec.Bytes()
+		}
+		return b
+	}
+
+	workers := bl.importWorkers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < len(records); i += workers {
+				start := time.Now()
+				stored, err := bl.sealFor(records[i].Bucket, records[i].Bytes())
+				if err != nil {
+					errs[w] = &RecordError{Op: "encrypt", Bucket: records[i].Bucket, Key: records[i].Key, Kind: ErrEncrypt, Err: err}
+					return
+				}
+				bl.observeCrypto("encrypt", start)
+				b.stored[i] = stored
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			b.err = err
+			break
+		}
+	}
+	return b
+}
+
+// writeBatch writes the encrypted records in a single transaction
+func (bl *BoltLocknut) writeBatch(b importBatch) error {
+	return bl.write(func(w *writeTx) error {
+		for i, rec := range b.records {
+			if err := bl.checkSchema("import", rec.Bucket, rec.Key); err != nil {
+				return err
+			}
+			if err := bl.checkLimits("import", rec.Bucket, rec.Key, rec.Bytes()); err != nil {
+				return err
+			}
+			if err := bl.validateValue("import", rec.Bucket, rec.Key, rec.Bytes()); err != nil {
+				return err
+			}
+			if _, err := w.tx.CreateBucketIfNotExists([]byte(rec.Bucket)); err != nil {
+				return err
+			}
+			if err := w.putStored(rec.Bucket, rec.Key, b.stored[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,106 @@
+package locknut
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestImport(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("import_test.db")
+	defer os.Remove("import_copy_test.db")
+
+	bl, err := NewBoltLocknut("import_test.db", ".", []byte("secret"), false, nil)
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+
+	var in strings.Builder
+	for i := 0; i < importBatchSize+10; i++ {
+		fmt.Fprintf(&in, `{"key":"ID-%04d","value":{"id":"ID-%04d","title":"imported"}}`+"\n", i, i)
+	}
+	if err = bl.Import(strings.NewReader(in.String()), FormatJSONL, "article"); err != nil {
+		t.Fatalf("Import: %s", err)
+	}
+
+	keys, err := bl.GetKeyList("article", "")
+	if err != nil || len(keys) != importBatchSize+10 {
+		t.Fatalf("expected %d keys, got %d %v", importBatchSize+10, len(keys), err)
+	}
+
+	raw, err := bl.GetOne("article", "ID-0042")
+	if err != nil {
+		t.Fatalf("GetOne: %s", err)
+	}
+	var a Article
+	if err = json.Unmarshal(raw, &a); err != nil || a.ID != "ID-0042" {
+		t.Errorf("unexpected record %s %v", raw, err)
+	}
+
+	// an export can be imported as is into another db
+	if err = bl.SaveBytes("article", "blob", []byte{0xff}); err != nil {
+		t.Fatalf("SaveBytes: %s", err)
+	}
+	var buf bytes.Buffer
+	if err = bl.Export(&buf, FormatJSON); err != nil {
+		t.Fatalf("Export: %s", err)
+	}
+	cp, err := NewBoltLocknut("import_copy_test.db", ".", []byte("other secret"), false, nil)
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if err = cp.Import(&buf, FormatJSON, ""); err != nil {
+		t.Fatalf("Import json: %s", err)
+	}
+	if raw, err = cp.GetOne("article", "blob"); err != nil || !bytes.Equal(raw, []byte{0xff}) {
+		t.Errorf("round trip lost a binary value: %v %v", raw, err)
+	}
+}
+
+func TestImportWorkers(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("import_workers_test.db")
+
+	bl, err := NewBoltLocknut("import_workers_test.db", ".", []byte("secret"), false, nil, WithImportWorkers(3))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+
+	n := 3*importBatchSize + 7
+	var in strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&in, `{"bucket":"article","key":"ID-%05d","value":{"id":"ID-%05d"}}`+"\n", i, i)
+	}
+	if err = bl.Import(strings.NewReader(in.String()), FormatJSONL, ""); err != nil {
+		t.Fatalf("Import: %s", err)
+	}
+
+	records, err := bl.GetByPrefix("article", "")
+	if err != nil || len(records) != n {
+		t.Fatalf("expected %d records, got %d %v", n, len(records), err)
+	}
+	for _, i := range []int{0, importBatchSize, n - 1} {
+		key := fmt.Sprintf("ID-%05d", i)
+		var a Article
+		if err = json.Unmarshal(records[key], &a); err != nil || a.ID != key {
+			t.Errorf("record %s holds %s %v", key, records[key], err)
+		}
+	}
+
+	// a bad record fails the import after the batches before it were written
+	in.Reset()
+	for i := 0; i < importBatchSize; i++ {
+		fmt.Fprintf(&in, `{"bucket":"other","key":"K-%05d","value":1}`+"\n", i)
+	}
+	in.WriteString(`{"bucket":"other","value":1}` + "\n")
+	if err = bl.Import(strings.NewReader(in.String()), FormatJSONL, ""); err == nil {
+		t.Fatalf("expected an error for a record without a key")
+	}
+	if keys, _ := bl.GetKeyList("other", ""); len(keys) != importBatchSize {
+		t.Errorf("expected the first batch to be written, got %d keys", len(keys))
+	}
+}
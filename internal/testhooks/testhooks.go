@@ -0,0 +1,9 @@
+// Package testhooks hands package locknuttest the options of package locknut that are only safe in
+// tests, so they are out of reach of code outside the module
+package testhooks
+
+import "io"
+
+// WithRand is set by package locknut to a function returning the locknut.Option making a BoltLocknut
+// read its randomness from r
+var WithRand func(r io.Reader) interface{}
@@ -0,0 +1,372 @@
+package locknut
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// JSONSchema is a compiled JSON Schema, see CompileJSONSchema and WithJSONSchema
+type JSONSchema struct {
+	// always holds the result of the boolean schemas true and false
+	always *bool
+
+	types            []string
+	properties       map[string]*JSONSchema
+	required         []string
+	additional       *JSONSchema
+	items            *JSONSchema
+	minItems         *int
+	maxItems         *int
+	enum             []string // canonical json of the values
+	minimum          *float64
+	maximum          *float64
+	exclusiveMinimum *float64
+	exclusiveMaximum *float64
+	minLength        *int
+	maxLength        *int
+	pattern          *regexp.Regexp
+	allOf            []*JSONSchema
+	anyOf            []*JSONSchema
+	not              *JSONSchema
+}
+
+// SchemaProblem is one way a value does not match a JSONSchema
+type SchemaProblem struct {
+	// Path is the JSON pointer of the offending part of the value, empty for the value itself
+	Path    string
+	Message string
+}
+
+// SchemaError lists every problem a value has with a JSONSchema
+type SchemaError struct {
+	Problems []SchemaProblem
+}
+
+func (e *SchemaError) Error() string {
+	parts := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		path := p.Path
+		if path == "" {
+			path = "/"
+		}
+		parts[i] = path + ": " + p.Message
+	}
+	return strings.Join(parts, "; ")
+}
+
+// CompileJSONSchema compiles schema, a JSON Schema using the keywords type, properties, required,
+// additionalProperties, items, minItems, maxItems, enum, const, minimum, maximum, exclusiveMinimum,
+// exclusiveMaximum, minLength, maxLength, pattern, allOf, anyOf and not. Annotations such as title or
+// $schema are ignored, references and other assertions are rejected rather than silently skipped.
+func CompileJSONSchema(schema []byte) (*JSONSchema, error) {
+	return compileSchema(schema, "")
+}
+
+// ignoredKeywords are the keywords compileSchema skips, annotations that assert nothing
+var ignoredKeywords = map[string]bool{
+	"$schema": true, "$id": true, "$comment": true, "title": true, "description": true,
+	"default": true, "examples": true, "deprecated": true, "readOnly": true, "writeOnly": true,
+	"format": true,
+}
+
+func compileSchema(raw json.RawMessage, path string) (*JSONSchema, error) {
+	var b bool
+	if json.Unmarshal(raw, &b) == nil {
+		return &JSONSchema{always: &b}, nil
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("schema %s: %v", schemaPath(path), err)
+	}
+
+	s := &JSONSchema{}
+	keys := make([]string, 0, len(doc))
+	for k := range doc {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := doc[k]
+		var err error
+		switch k {
+		case "type":
+			var one string
+			if json.Unmarshal(v, &one) == nil {
+				s.types = []string{one}
+			} else {
+				err = json.Unmarshal(v, &s.types)
+			}
+			for _, t := range s.types {
+				switch t {
+				case "null", "boolean", "object", "array", "number", "integer", "string":
+				default:
+					err = fmt.Errorf("unknown type %q", t)
+				}
+			}
+		case "properties":
+			var props map[string]json.RawMessage
+			if err = json.Unmarshal(v, &props); err == nil {
+				s.properties = make(map[string]*JSONSchema, len(props))
+				for name, p := range props {
+					if s.properties[name], err = compileSchema(p, path+"/properties/"+name); err != nil {
+						return nil, err
+					}
+				}
+			}
+		case "required":
+			err = json.Unmarshal(v, &s.required)
+		case "additionalProperties":
+			s.additional, err = compileSchema(v, path+"/additionalProperties")
+		case "items":
+			s.items, err = compileSchema(v, path+"/items")
+		case "minItems":
+			err = json.Unmarshal(v, &s.minItems)
+		case "maxItems":
+			err = json.Unmarshal(v, &s.maxItems)
+		case "enum", "const":
+			var values []json.RawMessage
+			if k == "const" {
+				values = []json.RawMessage{v}
+			} else {
+				err = json.Unmarshal(v, &values)
+			}
+			for _, value := range values {
+				c, cerr := canonicalJSON(value)
+				if cerr != nil {
+					err = cerr
+				}
+				s.enum = append(s.enum, c)
+			}
+		case "minimum":
+			err = json.Unmarshal(v, &s.minimum)
+		case "maximum":
+			err = json.Unmarshal(v, &s.maximum)
+		case "exclusiveMinimum":
+			err = json.Unmarshal(v, &s.exclusiveMinimum)
+		case "exclusiveMaximum":
+			err = json.Unmarshal(v, &s.exclusiveMaximum)
+		case "minLength":
+			err = json.Unmarshal(v, &s.minLength)
+		case "maxLength":
+			err = json.Unmarshal(v, &s.maxLength)
+		case "pattern":
+			var p string
+			if err = json.Unmarshal(v, &p); err == nil {
+				s.pattern, err = regexp.Compile(p)
+			}
+		case "allOf", "anyOf":
+			var subs []json.RawMessage
+			if err = json.Unmarshal(v, &subs); err == nil {
+				for i, sub := range subs {
+					c, err := compileSchema(sub, fmt.Sprintf("%s/%s/%d", path, k, i))
+					if err != nil {
+						return nil, err
+					}
+					if k == "allOf" {
+						s.allOf = append(s.allOf, c)
+					} else {
+						s.anyOf = append(s.anyOf, c)
+					}
+				}
+			}
+		case "not":
+			s.not, err = compileSchema(v, path+"/not")
+		default:
+			if !ignoredKeywords[k] {
+				err = fmt.Errorf("unsupported keyword")
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("schema %s/%s: %v", path, k, err)
+		}
+	}
+	return s, nil
+}
+
+func schemaPath(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+// canonicalJSON returns v decoded and encoded again, so equal values compare equal as strings
+func canonicalJSON(v []byte) (string, error) {
+	var x interface{}
+	d := json.NewDecoder(bytes.NewReader(v))
+	d.UseNumber()
+	if err := d.Decode(&x); err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(x)
+	return string(b), err
+}
+
+// Validate checks the json value against s, returning a *SchemaError listing every problem
+func (s *JSONSchema) Validate(value []byte) error {
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(value))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return &SchemaError{Problems: []SchemaProblem{{Message: "not json: " + err.Error()}}}
+	}
+	if problems := s.check(v, ""); len(problems) > 0 {
+		return &SchemaError{Problems: problems}
+	}
+	return nil
+}
+
+// check returns the problems of v, found at path of the value
+func (s *JSONSchema) check(v interface{}, path string) []SchemaProblem {
+	if s.always != nil {
+		if *s.always {
+			return nil
+		}
+		return []SchemaProblem{{Path: path, Message: "not allowed"}}
+	}
+
+	var problems []SchemaProblem
+	fail := func(format string, args ...interface{}) {
+		problems = append(problems, SchemaProblem{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if len(s.types) > 0 && !hasType(v, s.types) {
+		fail("expected %s, got %s", strings.Join(s.types, " or "), typeOf(v))
+		return problems
+	}
+	if len(s.enum) > 0 {
+		c, _ := json.Marshal(v)
+		found := false
+		for _, e := range s.enum {
+			found = found || e == string(c)
+		}
+		if !found {
+			fail("must be one of %s", strings.Join(s.enum, ", "))
+		}
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, name := range s.required {
+			if _, ok := v[name]; !ok {
+				fail("missing required property %q", name)
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			sub := path + "/" + escapePointer(name)
+			if p, ok := s.properties[name]; ok {
+				problems = append(problems, p.check(v[name], sub)...)
+			} else if s.additional != nil {
+				if s.additional.always != nil && !*s.additional.always {
+					problems = append(problems, SchemaProblem{Path: sub, Message: "unexpected property"})
+				} else {
+					problems = append(problems, s.additional.check(v[name], sub)...)
+				}
+			}
+		}
+	case []interface{}:
+		if s.minItems != nil && len(v) < *s.minItems {
+			fail("expected at least %d items, got %d", *s.minItems, len(v))
+		}
+		if s.maxItems != nil && len(v) > *s.maxItems {
+			fail("expected at most %d items, got %d", *s.maxItems, len(v))
+		}
+		if s.items != nil {
+			for i, item := range v {
+				problems = append(problems, s.items.check(item, path+"/"+strconv.Itoa(i))...)
+			}
+		}
+	case json.Number:
+		f, _ := v.Float64()
+		if s.minimum != nil && f < *s.minimum {
+			fail("must be at least %v", *s.minimum)
+		}
+		if s.maximum != nil && f > *s.maximum {
+			fail("must be at most %v", *s.maximum)
+		}
+		if s.exclusiveMinimum != nil && f <= *s.exclusiveMinimum {
+			fail("must be more than %v", *s.exclusiveMinimum)
+		}
+		if s.exclusiveMaximum != nil && f >= *s.exclusiveMaximum {
+			fail("must be less than %v", *s.exclusiveMaximum)
+		}
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.minLength != nil && n < *s.minLength {
+			fail("must be at least %d characters", *s.minLength)
+		}
+		if s.maxLength != nil && n > *s.maxLength {
+			fail("must be at most %d characters", *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			fail("must match %s", s.pattern)
+		}
+	}
+
+	for _, sub := range s.allOf {
+		problems = append(problems, sub.check(v, path)...)
+	}
+	if len(s.anyOf) > 0 {
+		matched := false
+		for _, sub := range s.anyOf {
+			if len(sub.check(v, path)) == 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("matches none of anyOf")
+		}
+	}
+	if s.not != nil && len(s.not.check(v, path)) == 0 {
+		fail("must not match the schema of not")
+	}
+	return problems
+}
+
+func hasType(v interface{}, types []string) bool {
+	actual := typeOf(v)
+	for _, t := range types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// typeOf returns the JSON Schema type of a decoded value, integer for whole numbers
+func typeOf(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case json.Number:
+		if f, err := v.Float64(); err == nil && f == math.Trunc(f) && !math.IsInf(f, 0) {
+			return "integer"
+		}
+		return "number"
+	}
+	return "string"
+}
+
+// escapePointer escapes a property name as a JSON pointer token
+func escapePointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
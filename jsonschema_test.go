@@ -0,0 +1,117 @@
+package locknut
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const personSchema = `{
+	"$schema": "https://json-schema.org/draft/2020-12/schema",
+	"type": "object",
+	"required": ["name", "age"],
+	"additionalProperties": false,
+	"properties": {
+		"name": {"type": "string", "minLength": 1, "maxLength": 20},
+		"age": {"type": "integer", "minimum": 0, "exclusiveMaximum": 150},
+		"email": {"type": "string", "pattern": "^[^@]+@[^@]+$"},
+		"role": {"enum": ["admin", "user"]},
+		"tags": {"type": "array", "maxItems": 2, "items": {"type": "string"}},
+		"a/b": {"anyOf": [{"type": "null"}, {"type": "number", "not": {"const": 13}}]}
+	}
+}`
+
+func TestJSONSchema(t *testing.T) {
+	s, err := CompileJSONSchema([]byte(personSchema))
+	if err != nil {
+		t.Fatalf("CompileJSONSchema: %s", err)
+	}
+
+	for doc, want := range map[string][]SchemaProblem{
+		`{"name":"Ada","age":36,"email":"ada@example.com","role":"admin","tags":["x"],"a/b":null}`: nil,
+		`{"name":"Ada","age":36.0}`: nil,
+		`{"name":"","age":36.5}`: {
+			{Path: "/age", Message: "expected integer, got number"},
+			{Path: "/name", Message: "must be at least 1 characters"},
+		},
+		`{"age":150,"email":"nope","extra":1}`: {
+			{Message: `missing required property "name"`},
+			{Path: "/age", Message: "must be less than 150"},
+			{Path: "/email", Message: "must match ^[^@]+@[^@]+$"},
+			{Path: "/extra", Message: "unexpected property"},
+		},
+		`{"name":"Ada","age":1,"role":"root","tags":["x",2,"z"],"a/b":13}`: {
+			{Path: "/a~1b", Message: "matches none of anyOf"},
+			{Path: "/role", Message: `must be one of "admin", "user"`},
+			{Path: "/tags", Message: "expected at most 2 items, got 3"},
+			{Path: "/tags/1", Message: "expected string, got integer"},
+		},
+		`[1]`: {{Message: "expected object, got array"}},
+	} {
+		err := s.Validate([]byte(doc))
+		var got []SchemaProblem
+		var se *SchemaError
+		if errors.As(err, &se) {
+			got = se.Problems
+		} else if err != nil {
+			t.Fatalf("%s: unexpected error %v", doc, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %+v, got %+v", doc, want, got)
+		}
+	}
+
+	for _, bad := range []string{`{"$ref":"#/x"}`, `{"type":"text"}`, `{"pattern":"("}`, `[]`} {
+		if _, err := CompileJSONSchema([]byte(bad)); err == nil {
+			t.Errorf("%s: expected a compile error", bad)
+		}
+	}
+}
+
+func TestBucketValidators(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("jsonschema_test.db")
+
+	s, err := CompileJSONSchema([]byte(personSchema))
+	if err != nil {
+		t.Fatalf("CompileJSONSchema: %s", err)
+	}
+	bl, err := NewBoltLocknut("jsonschema_test.db", ".", []byte("secret"), false, []string{"people", "notes"},
+		WithJSONSchema("people", s),
+		WithValidator("notes", func(v []byte) error {
+			if len(v) > 10 {
+				return errors.New("note too long")
+			}
+			return nil
+		}))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+
+	if err = bl.Save("people", "ada", map[string]interface{}{"name": "Ada", "age": 36}); err != nil {
+		t.Errorf("Save: %s", err)
+	}
+	err = bl.Save("people", "bob", map[string]interface{}{"name": "Bob"})
+	var se *SchemaError
+	if !errors.Is(err, ErrValidation) || !errors.As(err, &se) || len(se.Problems) != 1 {
+		t.Errorf("expected a schema error, got %v", err)
+	}
+	if err = bl.SaveBytes("people", "raw", []byte("not json")); !errors.Is(err, ErrValidation) {
+		t.Errorf("expected ErrValidation, got %v", err)
+	}
+	if err = bl.Save("notes", "n", "short"); err != nil {
+		t.Errorf("Save: %s", err)
+	}
+	if err = bl.Save("notes", "n", "a long note"); err == nil || !strings.Contains(err.Error(), "note too long") {
+		t.Errorf("expected the validator's error, got %v", err)
+	}
+	err = bl.Import(strings.NewReader(`{"bucket":"people","key":"eve","value":{"name":"Eve","age":-1}}`), FormatJSONL, "")
+	if !errors.Is(err, ErrValidation) {
+		t.Errorf("expected imports validated, got %v", err)
+	}
+	if n, err := bl.Count("people"); err != nil || n != 1 {
+		t.Errorf("expected 1 record, got %d %v", n, err)
+	}
+}
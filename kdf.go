@@ -0,0 +1,227 @@
+package locknut
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+	"io"
+)
+
+// The algorithms of KDF
+const (
+	KDFArgon2id = "argon2id"
+	KDFScrypt   = "scrypt"
+	KDFPBKDF2   = "pbkdf2-sha256"
+)
+
+// ErrUnknownKDF is returned by NewBoltLocknut for a KDF it does not implement, given WithKDF or
+// recorded in the db
+var ErrUnknownKDF = errors.New("unknown key derivation function")
+
+// ErrKDFMismatch is returned by NewBoltLocknut given WithKDFRecord for a db whose key is derived
+// another way
+var ErrKDFMismatch = errors.New("db key is not derived with the given KDF record")
+
+// kdfKey is the meta record holding the KDF the key of the db is derived with, and its salt
+var kdfKey = []byte("kdf")
+
+const kdfSaltSize = 16
+
+// KDF is a password based key derivation function and its parameters, see WithKDF. Only the fields of
+// its Algorithm are used.
+type KDF struct {
+	Algorithm string `json:"algorithm"`
+
+	// Time, Memory in KiB and Threads are the parameters of Argon2id
+	Time    uint32 `json:"time,omitempty"`
+	Memory  uint32 `json:"memory,omitempty"`
+	Threads uint8  `json:"threads,omitempty"`
+
+	// N, R and P are the parameters of scrypt
+	N int `json:"n,omitempty"`
+	R int `json:"r,omitempty"`
+	P int `json:"p,omitempty"`
+
+	// Iterations is the parameter of PBKDF2-HMAC-SHA256
+	Iterations int `json:"iterations,omitempty"`
+}
+
+// Argon2idKDF returns Argon2id with the parameters recommended by RFC 9106 for memory constrained
+// environments: 3 passes over 64MB with 4 threads
+func Argon2idKDF() KDF {
+	return KDF{Algorithm: KDFArgon2id, Time: 3, Memory: 64 << 10, Threads: 4}
+}
+
+// ScryptKDF returns scrypt with N=32768, r=8 and p=1, taking 32MB
+func ScryptKDF() KDF {
+	return KDF{Algorithm: KDFScrypt, N: 1 << 15, R: 8, P: 1}
+}
+
+// PBKDF2KDF returns PBKDF2-HMAC-SHA256 with 600000 iterations, for environments that only allow FIPS
+// approved algorithms
+func PBKDF2KDF() KDF {
+	return KDF{Algorithm: KDFPBKDF2, Iterations: 600000}
+}
+
+// kdfRecord is the KDF recorded in a db, with the salt of its key
+type kdfRecord struct {
+	KDF
+	Salt []byte `json:"salt"`
+}
+
+// WithKDF derives the key of a new db from the secret with kdf and a random salt, rather than using
+// the secret as the key, hashed when shorter than one. Use it when the secret is a passphrase. The KDF,
+// its parameters and the salt are recorded in the db and used by every later open, Unlock and
+// RotateKey, whether WithKDF is given then or not, so the option only picks the KDF of new dbs. An
+// existing db keeps its KDF until RotateKey, which moves it to the one given WithKDF.
+//
+// The salt makes the key of each db its own, so the same passphrase derives another key in another
+// db. Replicas, delta sync peers and enrolled devices receive records as they are stored and must
+// derive the key of the source: create them WithKDFRecord and the KDFRecord of the source rather than
+// WithKDF, and hand them the new record after RotateKey, which picks a new salt.
+func WithKDF(kdf KDF) Option {
+	return func(bl *BoltLocknut) {
+		bl.kdf = &kdf
+	}
+}
+
+// WithKDFRecord derives the key of the db with record, returned by KDFRecord on another db, so the
+// secret of that db derives the same key here. A new db records it in place of a KDF given WithKDF,
+// and NewBoltLocknut fails with ErrKDFMismatch for a db whose key is derived another way. The record
+// holds the KDF, its parameters and the salt, none of them secret.
+func WithKDFRecord(record []byte) Option {
+	return func(bl *BoltLocknut) {
+		bl.peerKDF = record
+	}
+}
+
+// KDFRecord returns the KDF the key of the db is derived with, its parameters and salt, for
+// WithKDFRecord, or nil for a db without a KDF
+func (bl *BoltLocknut) KDFRecord() ([]byte, error) {
+	if bl.keyKDF == nil {
+		return nil, nil
+	}
+	return json.Marshal(bl.keyKDF)
+}
+
+// derive returns the key of secret under the KDF and salt of r
+func (r *kdfRecord) derive(secret []byte) ([]byte, error) {
+	switch r.Algorithm {
+	case KDFArgon2id:
+		if r.Time == 0 || r.Memory == 0 || r.Threads == 0 {
+			return nil, fmt.Errorf("%w: argon2id needs time, memory and threads", ErrUnknownKDF)
+		}
+		return argon2.IDKey(secret, r.Salt, r.Time, r.Memory, r.Threads, 32), nil
+	case KDFScrypt:
+		return scrypt.Key(secret, r.Salt, r.N, r.R, r.P, 32)
+	case KDFPBKDF2:
+		if r.Iterations <= 0 {
+			return nil, fmt.Errorf("%w: pbkdf2 needs iterations", ErrUnknownKDF)
+		}
+		return pbkdf2.Key(secret, r.Salt, r.Iterations, 32, sha256.New), nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownKDF, r.Algorithm)
+}
+
+// equal reports whether o derives the keys r does
+func (r *kdfRecord) equal(o *kdfRecord) bool {
+	return r.KDF == o.KDF && bytes.Equal(r.Salt, o.Salt)
+}
+
+// newKDFRecord returns kdf with a fresh salt read from rand
+func newKDFRecord(rand io.Reader, kdf KDF) (*kdfRecord, error) {
+	r := &kdfRecord{KDF: kdf, Salt: make([]byte, kdfSaltSize)}
+	if _, err := io.ReadFull(rand, r.Salt); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// putKDF records r in the meta bucket of tx
+func putKDF(tx *bbolt.Tx, r *kdfRecord) error {
+	v, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	meta, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return err
+	}
+	return meta.Put(kdfKey, v)
+}
+
+// applyKDF derives the key from secret with the KDF recorded in the db, recording the one given
+// WithKDF in a new db. Dbs without one keep the key SetSecret derived.
+func (bl *BoltLocknut) applyKDF(tx *bbolt.Tx, secret []byte) error {
+	if secret == nil || bl.public != nil {
+		return nil
+	}
+	var r *kdfRecord
+	if meta := tx.Bucket(metaBucket); meta != nil {
+		if v := meta.Get(kdfKey); v != nil {
+			r = &kdfRecord{}
+			if err := json.Unmarshal(v, r); err != nil {
+				return fmt.Errorf("%w: %v", ErrCorrupt, err)
+			}
+		}
+	}
+
+	// only a db that holds nothing encrypted with the key yet starts using a KDF
+	isNew := r == nil && tx.Bucket(metaBucket) == nil && len(firstRecords(tx)) == 0
+	if bl.peerKDF != nil {
+		peer := &kdfRecord{}
+		if err := json.Unmarshal(bl.peerKDF, peer); err != nil || len(peer.Salt) == 0 {
+			return fmt.Errorf("%w: malformed KDF record", ErrUnknownKDF)
+		}
+		switch {
+		case r != nil:
+			if !r.equal(peer) {
+				return ErrKDFMismatch
+			}
+		case !isNew:
+			return ErrKDFMismatch
+		case tx.Writable():
+			if err := putKDF(tx, peer); err != nil {
+				return err
+			}
+		}
+		r = peer
+	}
+
+	if r == nil {
+		if bl.kdf == nil || !isNew || !tx.Writable() {
+			return bl.checkFIPSKey(secret, nil)
+		}
+		var err error
+		if r, err = newKDFRecord(bl.randReader(), *bl.kdf); err != nil {
+			return err
+		}
+		if err = putKDF(tx, r); err != nil {
+			return err
+		}
+	}
+
+	if err := bl.checkFIPSKey(secret, r); err != nil {
+		return err
+	}
+	key, err := r.derive(secret)
+	if err != nil {
+		return err
+	}
+	bl.keyKDF = r
+	return bl.setKey(key)
+}
+
+// deriveKey returns the key secret stands for in the db
+func (bl *BoltLocknut) deriveKey(secret []byte) ([]byte, error) {
+	if bl.keyKDF == nil {
+		return deriveSecret(secret), nil
+	}
+	return bl.keyKDF.derive(secret)
+}
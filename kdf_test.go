@@ -0,0 +1,142 @@
+package locknut
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestKDF(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("kdf_test.db")
+
+	open := func(secret string, opts ...Option) (*BoltLocknut, error) {
+		return NewBoltLocknut("kdf_test.db", ".", []byte(secret), false, []string{"notes"}, opts...)
+	}
+
+	// cheap parameters, the defaults take a good fraction of a second on purpose
+	for _, kdf := range []KDF{
+		{Algorithm: KDFArgon2id, Time: 1, Memory: 64, Threads: 1},
+		{Algorithm: KDFScrypt, N: 16, R: 1, P: 1},
+		{Algorithm: KDFPBKDF2, Iterations: 10},
+	} {
+		os.Remove("kdf_test.db")
+		bl, err := open("passphrase", WithKDF(kdf))
+		if err != nil {
+			t.Fatalf("%s: NewBoltLocknut: %s", kdf.Algorithm, err)
+		}
+		if err = bl.Save("notes", "a", "note"); err != nil {
+			t.Fatalf("%s: Save: %s", kdf.Algorithm, err)
+		}
+		if bytes.Equal(bl.secret, DeriveKey([]byte("passphrase"))) {
+			t.Errorf("%s: expected the key derived with the kdf", kdf.Algorithm)
+		}
+		bl.Close()
+
+		// the recorded kdf is used without the option
+		if bl, err = open("passphrase"); err != nil {
+			t.Fatalf("%s: NewBoltLocknut: %s", kdf.Algorithm, err)
+		}
+		if v, err := bl.Get("notes", "a"); err != nil || string(v) != `"note"` {
+			t.Errorf("%s: unexpected value %s %v", kdf.Algorithm, v, err)
+		}
+		if err = bl.Lock(); err != nil {
+			t.Fatalf("Lock: %s", err)
+		}
+		if err = bl.Unlock([]byte("passphrase")); err != nil {
+			t.Errorf("%s: Unlock: %s", kdf.Algorithm, err)
+		}
+		bl.Close()
+		if _, err = open("wrong"); !errors.Is(err, ErrWrongSecret) {
+			t.Errorf("%s: expected ErrWrongSecret, got %v", kdf.Algorithm, err)
+		}
+	}
+
+	// an existing db keeps its key until it is rotated
+	os.Remove("kdf_test.db")
+	bl, err := open("passphrase")
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if err = bl.Save("notes", "a", "note"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	bl.Close()
+	pbkdf2 := WithKDF(KDF{Algorithm: KDFPBKDF2, Iterations: 10})
+	if bl, err = open("passphrase", pbkdf2); err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if bl.keyKDF != nil {
+		t.Errorf("expected the db to keep its key")
+	}
+	if err = bl.RotateKey([]byte("new passphrase")); err != nil {
+		t.Fatalf("RotateKey: %s", err)
+	}
+	bl.Close()
+	if bl, err = open("new passphrase"); err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if bl.keyKDF == nil || bl.keyKDF.Algorithm != KDFPBKDF2 {
+		t.Errorf("expected the rotation to move the db to pbkdf2, got %v", bl.keyKDF)
+	}
+	if v, err := bl.Get("notes", "a"); err != nil || string(v) != `"note"` {
+		t.Errorf("unexpected value %s %v", v, err)
+	}
+	bl.Close()
+
+	os.Remove("kdf_test.db")
+	if _, err = open("passphrase", WithKDF(KDF{Algorithm: "md5"})); !errors.Is(err, ErrUnknownKDF) {
+		t.Errorf("expected ErrUnknownKDF, got %v", err)
+	}
+}
+
+func TestKDFRecord(t *testing.T) {
+	defer os.Remove("kdf_source_test.db")
+	defer os.Remove("kdf_peer_test.db")
+	defer os.Remove("kdf_other_test.db")
+
+	kdf := WithKDF(KDF{Algorithm: KDFPBKDF2, Iterations: 10})
+	src, err := NewBoltLocknut("kdf_source_test.db", ".", []byte("passphrase"), false, []string{"notes"}, kdf)
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if err = src.Save("notes", "a", "note"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	record, err := src.KDFRecord()
+	if err != nil || record == nil {
+		t.Fatalf("KDFRecord: %v %s", record, err)
+	}
+
+	// a peer with its own salt derives another key from the same passphrase
+	other, err := NewBoltLocknut("kdf_other_test.db", ".", []byte("passphrase"), false, []string{"notes"}, kdf)
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if bytes.Equal(other.secret, src.secret) {
+		t.Fatalf("expected a key of its own")
+	}
+	if _, err = NewBoltLocknut("kdf_other_test.db", ".", []byte("passphrase"), false, nil, WithKDFRecord(record)); !errors.Is(err, ErrKDFMismatch) {
+		t.Errorf("expected ErrKDFMismatch, got %v", err)
+	}
+
+	peer, err := NewBoltLocknut("kdf_peer_test.db", ".", []byte("passphrase"), false, []string{"notes"}, kdf, WithKDFRecord(record))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if _, err = peer.PullBucket(src, "notes"); err != nil {
+		t.Fatalf("PullBucket: %s", err)
+	}
+	if v, err := peer.Get("notes", "a"); err != nil || string(v) != `"note"` {
+		t.Errorf("expected the pulled record to decrypt, got %q %v", v, err)
+	}
+
+	// the record is kept in the peer, later opens need only the passphrase
+	if peer, err = NewBoltLocknut("kdf_peer_test.db", ".", []byte("passphrase"), false, nil); err != nil {
+		t.Fatalf("NewBoltLocknut reopen: %s", err)
+	}
+	if !bytes.Equal(peer.secret, src.secret) {
+		t.Errorf("expected the key of the source after reopening")
+	}
+}
@@ -0,0 +1,55 @@
+package locknut
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+var (
+	// ErrSecretNotFound is returned by SecretFromKeychain when the keychain holds no such secret
+	ErrSecretNotFound = errors.New("secret not found in keychain")
+	// ErrKeychainUnsupported is returned by SecretFromKeychain on systems without a supported keychain
+	ErrKeychainUnsupported = errors.New("no supported keychain on this system")
+)
+
+// SecretFromKeychain reads the secret stored for service and account in the keychain of the OS, so
+// it never has to sit in an environment variable or a config file:
+//
+//	macOS: the login keychain, a generic password added with
+//	  security add-generic-password -s <service> -a <account> -w
+//	Linux: the Secret Service (GNOME Keyring, KWallet), an item stored with
+//	  secret-tool store --label locknut service <service> account <account>
+//	Windows: the Credential Manager, a generic credential named <service>:<account>
+//
+// It fails with ErrSecretNotFound when there is no such secret.
+func SecretFromKeychain(service, account string) ([]byte, error) {
+	if service == "" || account == "" {
+		return nil, errors.New("keychain service and account are required")
+	}
+	return readKeychain(service, account)
+}
+
+// runKeychainTool runs the command line tool of a keychain and returns what it printed, a failure
+// without output meaning the secret does not exist
+func runKeychainTool(cmd *exec.Cmd) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		if msg := bytes.TrimSpace(stderr.Bytes()); len(msg) > 0 && !bytes.Contains(msg, []byte("could not be found")) {
+			return nil, fmt.Errorf("%s: %s", cmd.Path, msg)
+		}
+		return nil, ErrSecretNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	out = bytes.TrimSuffix(out, []byte("\n"))
+	if len(out) == 0 {
+		return nil, ErrSecretNotFound
+	}
+	return out, nil
+}
@@ -0,0 +1,7 @@
+package locknut
+
+import "os/exec"
+
+func readKeychain(service, account string) ([]byte, error) {
+	return runKeychainTool(exec.Command("security", "find-generic-password", "-s", service, "-a", account, "-w"))
+}
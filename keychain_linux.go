@@ -0,0 +1,7 @@
+package locknut
+
+import "os/exec"
+
+func readKeychain(service, account string) ([]byte, error) {
+	return runKeychainTool(exec.Command("secret-tool", "lookup", "service", service, "account", account))
+}
@@ -0,0 +1,7 @@
+//go:build !darwin && !linux && !windows
+
+package locknut
+
+func readKeychain(service, account string) ([]byte, error) {
+	return nil, ErrKeychainUnsupported
+}
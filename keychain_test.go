@@ -0,0 +1,27 @@
+package locknut
+
+import (
+	"errors"
+	"os/exec"
+	"runtime"
+	"testing"
+)
+
+func TestRunKeychainTool(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell")
+	}
+
+	if out, err := runKeychainTool(exec.Command("sh", "-c", `printf 'secret\n'`)); err != nil || string(out) != "secret" {
+		t.Errorf("unexpected secret %q %v", out, err)
+	}
+	if _, err := runKeychainTool(exec.Command("sh", "-c", "exit 1")); !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("expected ErrSecretNotFound for a silent failure, got %v", err)
+	}
+	if _, err := runKeychainTool(exec.Command("sh", "-c", "echo locked >&2; exit 1")); err == nil || errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("expected the tool's error, got %v", err)
+	}
+	if _, err := SecretFromKeychain("", "account"); err == nil {
+		t.Errorf("expected an error without a service")
+	}
+}
@@ -0,0 +1,126 @@
+package locknut
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+var (
+	// ErrKeyfile is returned by LoadKeyfile for a file that is not a keyfile, or in a version it does
+	// not know
+	ErrKeyfile = errors.New("not a locknut keyfile")
+	// ErrKeyfilePassphrase is returned by LoadKeyfile for a wrong passphrase, or a keyfile that was
+	// altered
+	ErrKeyfilePassphrase = errors.New("wrong keyfile passphrase")
+)
+
+// A keyfile, locknut.key by convention, is a JSON object holding the key wrapped by Cryptor.WrapKey
+// with a key derived from the passphrase, and the KDF, its parameters and salt:
+//
+//	{"locknut_keyfile":1,"kdf":{"algorithm":"argon2id",...,"salt":"..."},"key":"..."}
+const keyfileVersion = 1
+
+var keyfileMagic = []byte(`{"locknut_keyfile":`)
+
+type keyfile struct {
+	Version int        `json:"locknut_keyfile"`
+	KDF     *kdfRecord `json:"kdf"`
+	Key     []byte     `json:"key"`
+}
+
+// GenerateKeyfile creates a random key, writes it to a new keyfile at path protected by passphrase,
+// and returns it, to pass to NewBoltLocknut. The key is wrapped with a key derived from the passphrase
+// with kdf, Argon2idKDF() unless FIPS approved algorithms are needed. It fails if path exists, so a key
+// in use is never overwritten.
+func GenerateKeyfile(path string, passphrase []byte, kdf KDF) ([]byte, error) {
+	if kdf.Algorithm != KDFPBKDF2 {
+		if err := notFIPSBuild(kdf.Algorithm + " key derivation"); err != nil {
+			return nil, err
+		}
+	}
+	r, err := newKDFRecord(rand.Reader, kdf)
+	if err != nil {
+		return nil, err
+	}
+	kek, err := r.derive(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	key, err := GetRandKey()
+	if err != nil {
+		return nil, err
+	}
+	var c Cryptor
+	wrapped, err := c.WrapKey(key, kek)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(keyfile{Version: keyfileVersion, KDF: r, Key: wrapped})
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = f.Write(append(b, '\n')); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return key, nil
+}
+
+// LoadKeyfile returns the key of the keyfile at path, unwrapped with passphrase. It fails with
+// ErrKeyfilePassphrase for a wrong passphrase.
+func LoadKeyfile(path string, passphrase []byte) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKeyfile(b, passphrase)
+}
+
+// ParseKeyfile is LoadKeyfile for the content of a keyfile
+func ParseKeyfile(b, passphrase []byte) ([]byte, error) {
+	if !IsKeyfile(b) {
+		return nil, ErrKeyfile
+	}
+	var kf keyfile
+	if err := json.Unmarshal(b, &kf); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrKeyfile, err)
+	}
+	if kf.Version != keyfileVersion || kf.KDF == nil {
+		return nil, fmt.Errorf("%w: version %d", ErrKeyfile, kf.Version)
+	}
+	if kf.KDF.Algorithm != KDFPBKDF2 {
+		if err := notFIPSBuild(kf.KDF.Algorithm + " key derivation"); err != nil {
+			return nil, err
+		}
+	}
+	kek, err := kf.KDF.derive(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	var c Cryptor
+	key, err := c.UnwrapKey(kf.Key, kek)
+	if errors.Is(err, ErrUnwrapKey) {
+		return nil, ErrKeyfilePassphrase
+	}
+	return key, err
+}
+
+// IsKeyfile reports whether b looks like a keyfile, rather than a file holding a plain secret
+func IsKeyfile(b []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(b), keyfileMagic)
+}
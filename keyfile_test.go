@@ -0,0 +1,51 @@
+package locknut
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeyfile(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	path := filepath.Join(t.TempDir(), "locknut.key")
+	kdf := KDF{Algorithm: KDFArgon2id, Time: 1, Memory: 64, Threads: 1}
+
+	key, err := GenerateKeyfile(path, []byte("correct horse"), kdf)
+	if err != nil {
+		t.Fatalf("GenerateKeyfile: %s", err)
+	}
+	if len(key) != 32 {
+		t.Fatalf("expected a 32 byte key, got %d", len(key))
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600, got %v", info.Mode().Perm())
+	}
+	if _, err = GenerateKeyfile(path, []byte("other"), kdf); !errors.Is(err, os.ErrExist) {
+		t.Errorf("expected an existing keyfile not to be overwritten, got %v", err)
+	}
+
+	got, err := LoadKeyfile(path, []byte("correct horse"))
+	if err != nil || !bytes.Equal(got, key) {
+		t.Fatalf("expected the generated key, got %v", err)
+	}
+	if _, err = LoadKeyfile(path, []byte("wrong horse")); !errors.Is(err, ErrKeyfilePassphrase) {
+		t.Errorf("expected ErrKeyfilePassphrase, got %v", err)
+	}
+
+	b, _ := os.ReadFile(path)
+	if !IsKeyfile(b) {
+		t.Error("expected IsKeyfile")
+	}
+	if IsKeyfile([]byte("a plain secret")) {
+		t.Error("expected a plain secret not to be a keyfile")
+	}
+	if _, err = ParseKeyfile([]byte("a plain secret"), nil); !errors.Is(err, ErrKeyfile) {
+		t.Errorf("expected ErrKeyfile, got %v", err)
+	}
+	if _, err = ParseKeyfile(bytes.Replace(b, []byte(`"locknut_keyfile":1`), []byte(`"locknut_keyfile":9`), 1), nil); !errors.Is(err, ErrKeyfile) {
+		t.Errorf("expected ErrKeyfile for an unknown version, got %v", err)
+	}
+}
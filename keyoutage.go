@@ -0,0 +1,261 @@
+package locknut
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/taybart/log"
+	"sync"
+	"time"
+)
+
+// ErrKeyUnavailable is returned, as the KeyOutagePolicy of WithKeyProvider says, while the key provider
+// does not give out the key of the db
+var ErrKeyUnavailable = errors.New("key provider unavailable")
+
+// keyProviderTimeout bounds a single call to the key provider
+const keyProviderTimeout = 5 * time.Second
+
+// defaultKeyQueue is the number of writes KeyOutageQueueWrites holds by default
+const defaultKeyQueue = 1000
+
+// KeyOutagePolicy says what a BoltLocknut configured WithKeyProvider does while the provider is out
+type KeyOutagePolicy int
+
+// The outage policies
+const (
+	// KeyOutageFailFast fails every operation with ErrKeyUnavailable
+	KeyOutageFailFast KeyOutagePolicy = iota
+	// KeyOutageServeReads serves reads with the key held in memory and fails writes with
+	// ErrKeyUnavailable
+	KeyOutageServeReads
+	// KeyOutageQueueWrites serves reads as KeyOutageServeReads does, and holds Save, SaveBytes and
+	// Delete in memory to apply them, in order, once the provider is back. Queued writes are not seen
+	// by reads until then, and are lost if the process exits. Other writes fail with ErrKeyUnavailable,
+	// as do writes past the limit of WithKeyOutageQueue.
+	KeyOutageQueueWrites
+)
+
+func (p KeyOutagePolicy) String() string {
+	switch p {
+	case KeyOutageFailFast:
+		return "fail-fast"
+	case KeyOutageServeReads:
+		return "serve-reads"
+	case KeyOutageQueueWrites:
+		return "queue-writes"
+	}
+	return fmt.Sprintf("KeyOutagePolicy(%d)", int(p))
+}
+
+// KeyStatus is the state of the key provider as last seen
+type KeyStatus struct {
+	// Available is false during an outage
+	Available bool
+	// Since is when the provider was found out, zero when available
+	Since time.Time
+	// Err is the error of the provider that started the outage
+	Err error
+	// Queued is the number of writes waiting for the provider
+	Queued int
+}
+
+// keySource checks that the key provider still gives out the key of the db
+type keySource struct {
+	mu         sync.Mutex
+	provider   KeyProvider
+	refresh    time.Duration
+	policy     KeyOutagePolicy
+	maxQueue   int
+	checked    time.Time
+	checking   bool
+	since      time.Time
+	err        error
+	queue      []queuedWrite
+	drainMu    sync.Mutex // held while queued writes are applied, so they keep their order
+	queueLimit bool
+}
+
+// queuedWrite is a write held during an outage
+type queuedWrite struct {
+	ctx context.Context
+	fn  func(w *writeTx) error
+}
+
+// WithKeyProvider asks p for the key of the db every refresh, so revoking access to the master key cuts
+// off a running process too. The secret passed to NewBoltLocknut is still the key, usually read from p
+// just before. When p fails, or gives out another key, the db is in an outage handled as policy says
+// until p gives out the key again. Each call to p is reported to Metrics as the "key_provider"
+// operation, and each operation during an outage as a miss of the "key" cache.
+func WithKeyProvider(p KeyProvider, refresh time.Duration, policy KeyOutagePolicy) Option {
+	return func(bl *BoltLocknut) {
+		bl.keys.provider = p
+		bl.keys.refresh = refresh
+		bl.keys.policy = policy
+	}
+}
+
+// WithKeyOutageQueue sets how many writes KeyOutageQueueWrites holds, 1000 by default
+func WithKeyOutageQueue(n int) Option {
+	return func(bl *BoltLocknut) {
+		bl.keys.maxQueue = n
+		bl.keys.queueLimit = true
+	}
+}
+
+// KeyStatus returns the state of the key provider set WithKeyProvider
+func (bl *BoltLocknut) KeyStatus() KeyStatus {
+	k := &bl.keys
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return KeyStatus{Available: k.since.IsZero(), Since: k.since, Err: k.err, Queued: len(k.queue)}
+}
+
+// checkKeyProvider asks the provider for the key when it was last asked more than refresh ago, and
+// fails with ErrKeyUnavailable during an outage under KeyOutageFailFast. A single caller asks at a
+// time, the others go by the last answer.
+func (bl *BoltLocknut) checkKeyProvider() error {
+	k := &bl.keys
+	if k.provider == nil {
+		return nil
+	}
+	k.mu.Lock()
+	if !k.checking && bl.now().Sub(k.checked) >= k.refresh {
+		k.checking = true
+		k.mu.Unlock()
+		bl.askKeyProvider()
+		k.mu.Lock()
+	}
+	down := !k.since.IsZero()
+	k.mu.Unlock()
+
+	if bl.metrics != nil {
+		bl.metrics.ObserveCache("key", !down)
+	}
+	if down && k.policy == KeyOutageFailFast {
+		return ErrKeyUnavailable
+	}
+	return nil
+}
+
+// askKeyProvider gets the key from the provider and records the outage it starts or ends
+func (bl *BoltLocknut) askKeyProvider() {
+	k := &bl.keys
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), keyProviderTimeout)
+	secret, err := k.provider.GetKey(ctx)
+	cancel()
+	var key []byte
+	if err == nil {
+		key, err = bl.deriveKey(secret)
+	}
+	if err == nil {
+		bl.session.mu.Lock()
+		if bl.secret != nil && subtle.ConstantTimeCompare(key, bl.secret) != 1 {
+			err = ErrWrongSecret
+		}
+		bl.session.mu.Unlock()
+		wipe(key)
+	}
+	if bl.metrics != nil {
+		bl.metrics.ObserveOp("key_provider", time.Since(start), err)
+	}
+
+	k.mu.Lock()
+	k.checking = false
+	k.checked = bl.now()
+	if err != nil {
+		if k.since.IsZero() {
+			k.since, k.err = k.checked, err
+			log.Warn("key provider unavailable, "+k.policy.String(), err)
+		}
+		k.mu.Unlock()
+		return
+	}
+	recovered := !k.since.IsZero()
+	k.since, k.err = time.Time{}, nil
+	queued := len(k.queue) > 0
+	k.mu.Unlock()
+
+	if recovered {
+		log.Info("key provider available again")
+	}
+	if queued {
+		go bl.drainKeyQueue()
+	}
+}
+
+// writeOrQueue is writeContext, holding fn during an outage under KeyOutageQueueWrites
+func (bl *BoltLocknut) writeOrQueue(ctx context.Context, fn func(w *writeTx) error) error {
+	k := &bl.keys
+	if k.provider != nil && k.policy == KeyOutageQueueWrites && !bl.readOnly {
+		if err := bl.checkKeyProvider(); err != nil {
+			return err
+		}
+		k.mu.Lock()
+		if !k.since.IsZero() {
+			defer k.mu.Unlock()
+			max := defaultKeyQueue
+			if k.queueLimit {
+				max = k.maxQueue
+			}
+			if len(k.queue) >= max {
+				return fmt.Errorf("%w: %d writes already queued", ErrKeyUnavailable, len(k.queue))
+			}
+			k.queue = append(k.queue, queuedWrite{ctx: ctx, fn: fn})
+			return nil
+		}
+		k.mu.Unlock()
+	}
+	return bl.writeContext(ctx, fn)
+}
+
+// keyWritable checks the key provider and fails with ErrKeyUnavailable during an outage, and otherwise applies the writes queued
+// during the last one first
+func (bl *BoltLocknut) keyWritable() error {
+	k := &bl.keys
+	if k.provider == nil {
+		return nil
+	}
+	if err := bl.checkKeyProvider(); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	down, queued := !k.since.IsZero(), len(k.queue) > 0
+	k.mu.Unlock()
+	if down {
+		return ErrKeyUnavailable
+	}
+	if queued {
+		bl.drainKeyQueue()
+	}
+	return nil
+}
+
+// drainKeyQueue applies the writes queued during an outage. Writes that fail are logged and dropped,
+// their callers were told they succeeded long ago.
+func (bl *BoltLocknut) drainKeyQueue() {
+	k := &bl.keys
+	k.drainMu.Lock()
+	defer k.drainMu.Unlock()
+	for {
+		k.mu.Lock()
+		if len(k.queue) == 0 || !k.since.IsZero() {
+			k.mu.Unlock()
+			return
+		}
+		q := k.queue[0]
+		k.queue = k.queue[1:]
+		k.mu.Unlock()
+
+		if err := bl.applyWrite(q.ctx, q.fn); err != nil {
+			log.Error("queued write", err)
+		}
+	}
+}
+
+// queueing reports whether writes may be queued, in which case their data must be copied
+func (k *keySource) queueing() bool {
+	return k.provider != nil && k.policy == KeyOutageQueueWrites
+}
@@ -0,0 +1,119 @@
+package locknut
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyProvider gives out key, or fails while down
+type flakyProvider struct {
+	xorWrapper
+	mu   sync.Mutex
+	key  []byte
+	down bool
+}
+
+func (p *flakyProvider) GetKey(ctx context.Context) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.down {
+		return nil, errors.New("service unavailable")
+	}
+	return p.key, nil
+}
+
+func (p *flakyProvider) set(down bool) {
+	p.mu.Lock()
+	p.down = down
+	p.mu.Unlock()
+}
+
+func TestKeyOutage(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	for _, policy := range []KeyOutagePolicy{KeyOutageFailFast, KeyOutageServeReads, KeyOutageQueueWrites} {
+		t.Run(policy.String(), func(t *testing.T) {
+			defer os.Remove("keyoutage_test.db")
+
+			clock := &fakeClock{t: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+			p := &flakyProvider{key: []byte("secret")}
+			bl, err := NewBoltLocknut("keyoutage_test.db", ".", []byte("secret"), false, []string{"notes"},
+				WithClock(clock), WithKeyProvider(p, time.Minute, policy), WithKeyOutageQueue(1))
+			if err != nil {
+				t.Fatalf("NewBoltLocknut: %s", err)
+			}
+			if err = bl.Save("notes", "a", "one"); err != nil {
+				t.Fatalf("Save: %s", err)
+			}
+
+			// the outage is only noticed at the next refresh
+			p.set(true)
+			if _, err = bl.Get("notes", "a"); err != nil {
+				t.Fatalf("Get before the refresh: %s", err)
+			}
+			clock.t = clock.t.Add(time.Minute)
+
+			_, err = bl.Get("notes", "a")
+			if policy == KeyOutageFailFast {
+				if !errors.Is(err, ErrKeyUnavailable) {
+					t.Errorf("expected ErrKeyUnavailable, got %v", err)
+				}
+			} else if err != nil {
+				t.Errorf("expected reads served, got %v", err)
+			}
+			if s := bl.KeyStatus(); s.Available || !s.Since.Equal(clock.t) || s.Err == nil {
+				t.Errorf("unexpected status %+v", s)
+			}
+
+			err = bl.Save("notes", "b", "two")
+			if policy == KeyOutageQueueWrites {
+				if err != nil {
+					t.Errorf("expected the write queued, got %v", err)
+				}
+				if err = bl.Save("notes", "c", "three"); !errors.Is(err, ErrKeyUnavailable) {
+					t.Errorf("expected a full queue, got %v", err)
+				}
+				if s := bl.KeyStatus(); s.Queued != 1 {
+					t.Errorf("expected 1 write queued, got %d", s.Queued)
+				}
+			} else if !errors.Is(err, ErrKeyUnavailable) {
+				t.Errorf("expected ErrKeyUnavailable, got %v", err)
+			}
+			// writes that cannot be queued fail
+			if err = bl.CreateBucket("more"); !errors.Is(err, ErrKeyUnavailable) {
+				t.Errorf("expected ErrKeyUnavailable, got %v", err)
+			}
+
+			// the provider is asked again at the next refresh
+			p.set(false)
+			clock.t = clock.t.Add(time.Minute)
+			if err = bl.Delete("notes", "a"); err != nil {
+				t.Fatalf("Delete: %s", err)
+			}
+			if s := bl.KeyStatus(); !s.Available || s.Queued != 0 {
+				t.Errorf("unexpected status %+v", s)
+			}
+			v, err := bl.Get("notes", "b")
+			if err != nil {
+				t.Fatalf("Get: %s", err)
+			}
+			if queued := v != nil; queued != (policy == KeyOutageQueueWrites) {
+				t.Errorf("unexpected record %s", v)
+			}
+		})
+	}
+}
+
+func TestKeyProviderRotated(t *testing.T) {
+	defer os.Remove("keyrotated_test.db")
+
+	p := &flakyProvider{key: []byte("another secret")}
+	_, err := NewBoltLocknut("keyrotated_test.db", ".", []byte("secret"), false, []string{"notes"},
+		WithKeyProvider(p, time.Minute, KeyOutageFailFast))
+	if !errors.Is(err, ErrKeyUnavailable) {
+		t.Errorf("expected ErrKeyUnavailable for a provider giving out another key, got %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package locknut
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// KeyProvider gives out the key of the db, kept envelope encrypted under a master key that lives in a
+// key management service such as AWS KMS, GCP KMS or Vault transit, see the awskms, gcpkms and vault
+// packages, or wrapped to the X25519 recipients of the age package, or the X25519 and ML-KEM hybrid
+// recipients of the hybrid package for keys that must outlive quantum computers, or by an SSH agent
+// or hardware token with the challenge package, or sealed to the TPM of the machine with the tpm
+// package. GetKey's result is the secret passed to NewBoltLocknut.
+type KeyProvider interface {
+	KeyWrapper
+	// GetKey returns the db key, unwrapped by the master key
+	GetKey(ctx context.Context) ([]byte, error)
+}
+
+// KeyWrapper encrypts and decrypts keys with a master key that never leaves the service holding it
+type KeyWrapper interface {
+	Wrap(ctx context.Context, key []byte) ([]byte, error)
+	Unwrap(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// EnvelopeKey returns the db key kept wrapped by w in the file at path, the usual GetKey of a
+// KeyProvider. The first call creates a random key and stores it wrapped, so the plain key is never
+// written to disk and reading the db takes both the file and access to the master key.
+func EnvelopeKey(ctx context.Context, w KeyWrapper, path string) ([]byte, error) {
+	wrapped, err := os.ReadFile(path)
+	if err == nil {
+		return w.Unwrap(ctx, wrapped)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	key, err := GetRandKey()
+	if err != nil {
+		return nil, err
+	}
+	if wrapped, err = w.Wrap(ctx, key); err != nil {
+		return nil, err
+	}
+	// a concurrent first call may have won, its key is the one to use
+	if err = WriteKeyFile(path, wrapped, false); errors.Is(err, os.ErrExist) {
+		return EnvelopeKey(ctx, w, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// WriteKeyFile writes a wrapped key to the file at path, readable by its owner only, through a
+// temporary file synced to disk, so a crash never leaves a partial key behind. With replace it
+// replaces the file, for key providers rewrapping their key. Without, it fails with an error matching
+// os.ErrExist when the file exists, so two processes creating the key cannot overwrite each other's.
+func WriteKeyFile(path string, wrapped []byte, replace bool) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(wrapped); err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if replace {
+		return os.Rename(tmp.Name(), path)
+	}
+	return os.Link(tmp.Name(), path)
+}
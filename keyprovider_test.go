@@ -0,0 +1,86 @@
+package locknut
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// xorWrapper stands in for a key management service
+type xorWrapper struct {
+	fail bool
+}
+
+func (x xorWrapper) Wrap(ctx context.Context, key []byte) ([]byte, error) {
+	if x.fail {
+		return nil, errors.New("service unavailable")
+	}
+	out := make([]byte, len(key))
+	for i := range key {
+		out[i] = key[i] ^ 0x5a
+	}
+	return out, nil
+}
+
+func (x xorWrapper) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	return x.Wrap(ctx, wrapped)
+}
+
+func TestEnvelopeKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.key")
+
+	if _, err := EnvelopeKey(context.Background(), xorWrapper{fail: true}, path); err == nil {
+		t.Fatalf("expected the wrapper's error")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected no key file after a failure, got %v", err)
+	}
+
+	key, err := EnvelopeKey(context.Background(), xorWrapper{}, path)
+	if err != nil || len(key) != 32 {
+		t.Fatalf("unexpected key %x %v", key, err)
+	}
+	stored, _ := os.ReadFile(path)
+	if bytes.Equal(stored, key) {
+		t.Errorf("the key was stored unwrapped")
+	}
+	again, err := EnvelopeKey(context.Background(), xorWrapper{}, path)
+	if err != nil || !bytes.Equal(again, key) {
+		t.Errorf("expected the stored key, got %x %v", again, err)
+	}
+
+	bl, err := NewBoltLocknut("keyprovider_test.db", t.TempDir(), key, false, []string{"items"})
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if err = bl.Save("items", "a", "one"); err != nil {
+		t.Errorf("Save: %s", err)
+	}
+}
+
+func TestWriteKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.key")
+
+	if err := WriteKeyFile(path, []byte("first"), false); err != nil {
+		t.Fatalf("WriteKeyFile: %s", err)
+	}
+	if err := WriteKeyFile(path, []byte("second"), false); !errors.Is(err, os.ErrExist) {
+		t.Errorf("expected os.ErrExist, got %v", err)
+	}
+	if err := WriteKeyFile(path, []byte("rewrapped"), true); err != nil {
+		t.Fatalf("WriteKeyFile replace: %s", err)
+	}
+	if b, err := os.ReadFile(path); err != nil || string(b) != "rewrapped" {
+		t.Errorf("expected the replaced key, got %q %v", b, err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("expected a file readable by its owner only, got %v %v", fi.Mode(), err)
+	}
+	// no temporary file is left behind
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("expected only the key file, got %d entries", len(entries))
+	}
+}
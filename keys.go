@@ -0,0 +1,109 @@
+package locknut
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrBadKey is returned by SplitKey and the ParseKey functions for keys Key did not build
+var ErrBadKey = errors.New("not a composite key")
+
+// The bytes of composite keys. Every part ends with keyEnd, which sorts before anything a part holds,
+// so keys sort part by part and Key of the first parts is a prefix of exactly the keys starting with
+// them. keyEnd and keyEscape in parts are escaped by keyEscape, keeping their order.
+const (
+	keyEnd    = '\x00'
+	keyEscape = '\x01'
+)
+
+// Key builds a composite key from parts, such as Key(tenant, "invoice", KeyInt(n)). Keys sort part by
+// part, unlike keys joined with ":" where "a:b" sorts after "a-c:b", and Key of the first parts is
+// the prefix to give GetByPrefix or GetKeyList for the records under them. Parts may hold any text,
+// separators included; use KeyInt, KeyUint and KeyTime for parts that must sort as numbers or times.
+func Key(parts ...string) string {
+	var b strings.Builder
+	for _, p := range parts {
+		for i := 0; i < len(p); i++ {
+			switch c := p[i]; c {
+			case keyEnd, keyEscape:
+				b.WriteByte(keyEscape)
+				b.WriteByte(c + 1)
+			default:
+				b.WriteByte(c)
+			}
+		}
+		b.WriteByte(keyEnd)
+	}
+	return b.String()
+}
+
+// SplitKey returns the parts of a key Key built
+func SplitKey(key string) ([]string, error) {
+	var parts []string
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		switch c := key[i]; c {
+		case keyEnd:
+			parts = append(parts, b.String())
+			b.Reset()
+		case keyEscape:
+			if i++; i == len(key) || (key[i] != keyEnd+1 && key[i] != keyEscape+1) {
+				return nil, fmt.Errorf("%w: bad escape at %d in %q", ErrBadKey, i, key)
+			}
+			b.WriteByte(key[i] - 1)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	if b.Len() > 0 {
+		return nil, fmt.Errorf("%w: unterminated part in %q", ErrBadKey, key)
+	}
+	return parts, nil
+}
+
+// KeyUint returns the part of a key for n, 16 hex digits of its big endian bytes so parts sort as
+// their numbers do
+func KeyUint(n uint64) string {
+	return fmt.Sprintf("%016x", n)
+}
+
+// ParseKeyUint returns the number of a part KeyUint built
+func ParseKeyUint(part string) (uint64, error) {
+	if len(part) != 16 {
+		return 0, fmt.Errorf("%w: %q is not a number", ErrBadKey, part)
+	}
+	n, err := strconv.ParseUint(part, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q is not a number", ErrBadKey, part)
+	}
+	return n, nil
+}
+
+// KeyInt returns the part of a key for n, negative numbers sorting before positive ones
+func KeyInt(n int64) string {
+	return KeyUint(uint64(n) ^ 1<<63)
+}
+
+// ParseKeyInt returns the number of a part KeyInt built
+func ParseKeyInt(part string) (int64, error) {
+	n, err := ParseKeyUint(part)
+	return int64(n ^ 1<<63), err
+}
+
+// KeyTime returns the part of a key for t, to the nanosecond and whatever its location, so parts sort
+// as their times do. Times must be within the years 1678 to 2262, see time.Time.UnixNano.
+func KeyTime(t time.Time) string {
+	return KeyInt(t.UnixNano())
+}
+
+// ParseKeyTime returns the time, in UTC, of a part KeyTime built
+func ParseKeyTime(part string) (time.Time, error) {
+	n, err := ParseKeyInt(part)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, n).UTC(), nil
+}
@@ -0,0 +1,118 @@
+package locknut
+
+import (
+	"errors"
+	"math"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestKey(t *testing.T) {
+	for _, parts := range [][]string{
+		{"tenant", "invoice", "42"},
+		{"a:b", "", "c\x00d", "\x01\x02"},
+		{""},
+	} {
+		got, err := SplitKey(Key(parts...))
+		if err != nil || !reflect.DeepEqual(got, parts) {
+			t.Errorf("expected %q, got %q %v", parts, got, err)
+		}
+	}
+	if parts, err := SplitKey(""); err != nil || len(parts) != 0 {
+		t.Errorf("expected no parts, got %q %v", parts, err)
+	}
+	for _, bad := range []string{"a", "a\x00b", "a\x01", "a\x01\x05\x00"} {
+		if _, err := SplitKey(bad); !errors.Is(err, ErrBadKey) {
+			t.Errorf("%q: expected ErrBadKey, got %v", bad, err)
+		}
+	}
+
+	// keys sort part by part
+	sorted := []string{
+		Key("a"),
+		Key("a", "b"),
+		Key("a", "b\x00"),
+		Key("a", "c"),
+		Key("a\x00", "a"),
+		Key("a-c", "b"),
+		Key("ab"),
+	}
+	keys := append([]string(nil), sorted...)
+	sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, sorted) {
+		t.Errorf("expected %q, got %q", sorted, keys)
+	}
+}
+
+func TestKeyNumbers(t *testing.T) {
+	ints := []int64{math.MinInt64, -1000, -1, 0, 1, 255, 256, math.MaxInt64}
+	for i, n := range ints {
+		if got, err := ParseKeyInt(KeyInt(n)); err != nil || got != n {
+			t.Errorf("expected %d, got %d %v", n, got, err)
+		}
+		if i > 0 && KeyInt(ints[i-1]) >= KeyInt(n) {
+			t.Errorf("expected %d to sort before %d", ints[i-1], n)
+		}
+	}
+	if got, err := ParseKeyUint(KeyUint(math.MaxUint64)); err != nil || got != math.MaxUint64 {
+		t.Errorf("expected MaxUint64, got %d %v", got, err)
+	}
+	for _, bad := range []string{"", "12", "zzzzzzzzzzzzzzzz", "+000000000000001"} {
+		if _, err := ParseKeyInt(bad); !errors.Is(err, ErrBadKey) {
+			t.Errorf("%q: expected ErrBadKey, got %v", bad, err)
+		}
+	}
+
+	earlier := time.Date(1969, 7, 20, 20, 17, 0, 0, time.UTC)
+	later := time.Date(2024, 2, 29, 12, 0, 0, 1, time.FixedZone("CET", 3600))
+	if KeyTime(earlier) >= KeyTime(later) {
+		t.Error("expected times to sort in order")
+	}
+	if got, err := ParseKeyTime(KeyTime(later)); err != nil || !got.Equal(later) || got.Location() != time.UTC {
+		t.Errorf("expected %s, got %s %v", later, got, err)
+	}
+}
+
+func TestKeyPrefix(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("keys_test.db")
+
+	bl, err := NewBoltLocknut("keys_test.db", ".", []byte("secret"), false, []string{"events"})
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	for _, k := range []string{
+		Key("acme", "order", KeyInt(10)),
+		Key("acme", "order", KeyInt(9)),
+		Key("acme", "orders", KeyInt(1)),
+		Key("acme:order", KeyInt(1)),
+	} {
+		if err = bl.Save("events", k, "event"); err != nil {
+			t.Fatalf("Save: %s", err)
+		}
+	}
+
+	keys, err := bl.GetKeyList("events", Key("acme", "order"))
+	if err != nil {
+		t.Fatalf("GetKeyList: %s", err)
+	}
+	var ids []int64
+	for _, k := range keys {
+		parts, err := SplitKey(k)
+		if err != nil {
+			t.Fatalf("SplitKey: %s", err)
+		}
+		id, err := ParseKeyInt(parts[2])
+		if err != nil {
+			t.Fatalf("ParseKeyInt: %s", err)
+		}
+		ids = append(ids, id)
+	}
+	if !reflect.DeepEqual(ids, []int64{9, 10}) {
+		t.Errorf("expected the orders 9 and 10 in order, got %v", ids)
+	}
+}
@@ -0,0 +1,317 @@
+package locknut
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"regexp"
+	"strings"
+)
+
+// labelsBucket holds, in a bucket per record bucket, the labels of records as json encrypted with the
+// key of the records of the bucket, see sealLabels
+var labelsBucket = []byte(internalPrefix + "labels")
+
+var (
+	// ErrInvalidLabel is returned for a label whose key or value is not allowed, see Labels
+	ErrInvalidLabel = errors.New("invalid label")
+	// ErrBadSelector is returned by SelectByLabel for a selector it cannot parse
+	ErrBadSelector = errors.New("bad label selector")
+	// ErrRecordNotFound is returned by SetLabels for a key without a record
+	ErrRecordNotFound = errors.New("record not found")
+)
+
+// labelKeyPattern is what label keys look like, such as source, batch-id or team/owner
+var labelKeyPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]{0,61}[A-Za-z0-9])?$`)
+
+// maxLabelValue is the longest label value
+const maxLabelValue = 255
+
+// Labels are small key=value pairs of operational metadata attached to a record, such as its source,
+// batch id or classification, for SelectByLabel to find records by without decrypting them. Keys are
+// up to 63 letters, digits and . _ / -, starting and ending with a letter or a digit. Values are up to
+// 255 bytes without commas. Labels are kept next to the record rather than in its envelope, so
+// selecting records only decrypts their labels, and are encrypted with the key of the record: handles
+// that cannot read records, write only ones, cannot read labels either.
+type Labels map[string]string
+
+func (l Labels) validate() error {
+	for k, v := range l {
+		if !labelKeyPattern.MatchString(k) {
+			return fmt.Errorf("%w: key %q", ErrInvalidLabel, k)
+		}
+		if len(v) > maxLabelValue || strings.Contains(v, ",") {
+			return fmt.Errorf("%w: value of %s", ErrInvalidLabel, k)
+		}
+	}
+	return nil
+}
+
+type labelsCtx struct{}
+
+// WithLabels makes the records saved with ctx carry labels. Labels stay with a record when it is saved
+// again without any, and go when it is deleted.
+func WithLabels(ctx context.Context, labels Labels) context.Context {
+	return context.WithValue(ctx, labelsCtx{}, labels)
+}
+
+func labelsOf(ctx context.Context) Labels {
+	if ctx == nil {
+		return nil
+	}
+	labels, _ := ctx.Value(labelsCtx{}).(Labels)
+	return labels
+}
+
+// setLabels stores the labels of bucket/key, removing them when labels is empty
+func (w *writeTx) setLabels(bucket, key string, labels Labels) error {
+	if len(labels) == 0 {
+		return w.deleteLabels(bucket, key)
+	}
+	if err := labels.validate(); err != nil {
+		return err
+	}
+	root, err := w.tx.CreateBucketIfNotExists(labelsBucket)
+	if err != nil {
+		return err
+	}
+	bkt, err := root.CreateBucketIfNotExists([]byte(bucket))
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(labels)
+	if err != nil {
+		return err
+	}
+	if b, err = w.bl.sealLabels(bucket, b); err != nil {
+		return err
+	}
+	return bkt.Put([]byte(key), b)
+}
+
+// putSealedLabels stores labels sealed by sealLabels for bucket as they are
+func (w *writeTx) putSealedLabels(bucket, key string, sealed []byte) error {
+	root, err := w.tx.CreateBucketIfNotExists(labelsBucket)
+	if err != nil {
+		return err
+	}
+	bkt, err := root.CreateBucketIfNotExists([]byte(bucket))
+	if err != nil {
+		return err
+	}
+	return bkt.Put([]byte(key), sealed)
+}
+
+// sealLabels encrypts the json of labels the way records of bucket are encrypted, with the key of the
+// bucket or to the public key, leaving out the transformers and padding. A db without a secret keeps
+// them in the clear, like its records.
+func (bl *BoltLocknut) sealLabels(bucket string, b []byte) ([]byte, error) {
+	if bl.secret == nil && bl.public == nil {
+		return b, nil
+	}
+	return bl.seal(bucket, b)
+}
+
+// openLabels decrypts labels of bucket sealed by sealLabels, failing for any that do not authenticate
+func (bl *BoltLocknut) openLabels(bucket string, stored []byte) ([]byte, error) {
+	if bl.secret == nil && bl.public == nil {
+		return append([]byte(nil), stored...), nil
+	}
+	if bl.public != nil {
+		return bl.unseal(stored)
+	}
+	plain, _, err := bl.openCiphertext(bucket, stored)
+	return plain, err
+}
+
+// labelResealer returns the transform opening labels of bucket from and encrypting them with key
+func (bl *BoltLocknut) labelResealer(from string, key []byte) transformFunc {
+	return func(v []byte) ([]byte, error) {
+		plain, err := bl.openLabels(from, v)
+		if err != nil {
+			return nil, err
+		}
+		return bl.encrypt(plain, key)
+	}
+}
+
+// rotateLabels re-encrypts the labels of every bucket, fn giving the transform of a bucket
+func rotateLabels(root *bbolt.Bucket, fn func(bucket string) transformFunc) error {
+	var names []string
+	err := root.ForEach(func(name, _ []byte) error {
+		names = append(names, string(name))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if b := root.Bucket([]byte(name)); b != nil {
+			if err = rewriteValues(b, fn(name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deleteLabels removes the labels of bucket/key
+func (w *writeTx) deleteLabels(bucket, key string) error {
+	root := w.tx.Bucket(labelsBucket)
+	if root == nil {
+		return nil
+	}
+	bkt := root.Bucket([]byte(bucket))
+	if bkt == nil {
+		return nil
+	}
+	return bkt.Delete([]byte(key))
+}
+
+// readLabels returns the labels of bucket/key in tx
+func (bl *BoltLocknut) readLabels(tx *bbolt.Tx, bucket, key []byte) (Labels, error) {
+	root := tx.Bucket(labelsBucket)
+	if root == nil {
+		return nil, nil
+	}
+	bkt := root.Bucket(bucket)
+	if bkt == nil {
+		return nil, nil
+	}
+	b := bkt.Get(key)
+	if b == nil {
+		return nil, nil
+	}
+	b, err := bl.openLabels(string(bucket), b)
+	if err != nil {
+		kind := ErrDecrypt
+		if errors.Is(err, ErrWriteOnly) {
+			kind, err = ErrWriteOnly, nil
+		}
+		return nil, &RecordError{Op: "labels", Bucket: string(bucket), Key: string(key), Kind: kind, Err: err}
+	}
+	var labels Labels
+	if err := json.Unmarshal(b, &labels); err != nil {
+		return nil, fmt.Errorf("%w: labels of %s/%s: %v", ErrCorrupt, bucket, key, err)
+	}
+	return labels, nil
+}
+
+// SetLabels replaces the labels of the record stored under key, an empty labels removes them
+func (bl *BoltLocknut) SetLabels(bucket, key string, labels Labels) error {
+	return bl.write(func(w *writeTx) error {
+		bkt := w.tx.Bucket([]byte(bucket))
+		if bkt == nil {
+			return bucketNotFound("label", bucket)
+		}
+		if bkt.Get([]byte(key)) == nil {
+			return fmt.Errorf("%w: %s/%s", ErrRecordNotFound, bucket, key)
+		}
+		return w.setLabels(bucket, key, labels)
+	})
+}
+
+// GetLabels returns the labels of the record stored under key, nil when it has none
+func (bl *BoltLocknut) GetLabels(bucket, key string) (labels Labels, err error) {
+	if err = bl.openDB(); err != nil {
+		return nil, err
+	}
+	defer bl.closeDB()
+
+	err = bl.db.view(func(tx *bbolt.Tx) error {
+		labels, err = bl.readLabels(tx, []byte(bucket), []byte(key))
+		return err
+	})
+	return labels, err
+}
+
+// labelRequirement is one comma separated part of a selector
+type labelRequirement struct {
+	key   string
+	value string
+	// op is one of "=", "!=", "exists" and "!exists"
+	op string
+}
+
+func (r labelRequirement) matches(labels Labels) bool {
+	v, ok := labels[r.key]
+	switch r.op {
+	case "=":
+		return ok && v == r.value
+	case "!=":
+		return !ok || v != r.value
+	case "exists":
+		return ok
+	}
+	return !ok
+}
+
+// parseSelector parses a selector such as "source=etl,classification!=pii,batch-id,!archived"
+func parseSelector(selector string) ([]labelRequirement, error) {
+	var reqs []labelRequirement
+	for _, part := range strings.Split(selector, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		var r labelRequirement
+		if k, v, ok := strings.Cut(part, "!="); ok {
+			r = labelRequirement{key: k, value: v, op: "!="}
+		} else if k, v, ok := strings.Cut(part, "=="); ok {
+			r = labelRequirement{key: k, value: v, op: "="}
+		} else if k, v, ok := strings.Cut(part, "="); ok {
+			r = labelRequirement{key: k, value: v, op: "="}
+		} else if strings.HasPrefix(part, "!") {
+			r = labelRequirement{key: part[1:], op: "!exists"}
+		} else {
+			r = labelRequirement{key: part, op: "exists"}
+		}
+		r.key, r.value = strings.TrimSpace(r.key), strings.TrimSpace(r.value)
+		if !labelKeyPattern.MatchString(r.key) {
+			return nil, fmt.Errorf("%w: %q", ErrBadSelector, part)
+		}
+		reqs = append(reqs, r)
+	}
+	return reqs, nil
+}
+
+// SelectByLabel returns, in key order, the keys of the records of bucket whose labels match selector:
+// comma separated requirements that must all hold, key=value, key!=value, key for a label that is set
+// and !key for one that is not. Records without labels match the negative requirements, and an empty
+// selector matches every record. Only labels are decrypted, values are not.
+func (bl *BoltLocknut) SelectByLabel(bucket, selector string) (keys []string, err error) {
+	reqs, err := parseSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	if err = bl.openDB(); err != nil {
+		return nil, err
+	}
+	defer bl.closeDB()
+
+	err = bl.db.view(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket([]byte(bucket))
+		if bkt == nil {
+			return bucketNotFound("select", bucket)
+		}
+		return bkt.ForEach(func(k, v []byte) error {
+			if v == nil {
+				return nil
+			}
+			labels, err := bl.readLabels(tx, []byte(bucket), k)
+			if err != nil {
+				return err
+			}
+			for _, r := range reqs {
+				if !r.matches(labels) {
+					return nil
+				}
+			}
+			keys = append(keys, string(k))
+			return nil
+		})
+	})
+	return keys, err
+}
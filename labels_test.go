@@ -0,0 +1,190 @@
+package locknut
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"go.etcd.io/bbolt"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestLabels(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("labels_test.db")
+
+	bl, err := NewBoltLocknut("labels_test.db", ".", []byte("secret"), false, []string{"events"})
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	save := func(key string, labels Labels) {
+		t.Helper()
+		if err := bl.SaveContext(WithLabels(context.Background(), labels), "events", key, key); err != nil {
+			t.Fatalf("Save: %s", err)
+		}
+	}
+	save("a", Labels{"source": "etl", "batch-id": "1"})
+	save("b", Labels{"source": "etl", "batch-id": "2", "classification": "pii"})
+	save("c", Labels{"source": "api"})
+	save("d", nil)
+
+	// saving again without labels keeps them
+	if err = bl.Save("events", "a", "a again"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if labels, err := bl.GetLabels("events", "a"); err != nil || labels["batch-id"] != "1" {
+		t.Errorf("unexpected labels %v %v", labels, err)
+	}
+
+	for selector, want := range map[string][]string{
+		"source=etl":                         {"a", "b"},
+		"source==etl, classification!=pii":   {"a"},
+		"classification":                     {"b"},
+		"!source":                            {"d"},
+		"source!=etl":                        {"c", "d"},
+		"":                                   {"a", "b", "c", "d"},
+		"source=etl,batch-id=2,!nonexistent": {"b"},
+		"source=nowhere":                     nil,
+	} {
+		keys, err := bl.SelectByLabel("events", selector)
+		if err != nil {
+			t.Fatalf("SelectByLabel(%q): %s", selector, err)
+		}
+		if !reflect.DeepEqual(keys, want) {
+			t.Errorf("SelectByLabel(%q): expected %v, got %v", selector, want, keys)
+		}
+	}
+
+	if err = bl.SetLabels("events", "c", Labels{"source": "etl"}); err != nil {
+		t.Fatalf("SetLabels: %s", err)
+	}
+	if err = bl.SetLabels("events", "b", nil); err != nil {
+		t.Fatalf("SetLabels: %s", err)
+	}
+	if err = bl.Delete("events", "a"); err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+	if keys, err := bl.SelectByLabel("events", "source=etl"); err != nil || !reflect.DeepEqual(keys, []string{"c"}) {
+		t.Errorf("unexpected %v %v", keys, err)
+	}
+	// a new record under a deleted key does not inherit its labels
+	if err = bl.Save("events", "a", "new"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if labels, err := bl.GetLabels("events", "a"); err != nil || labels != nil {
+		t.Errorf("expected no labels, got %v %v", labels, err)
+	}
+
+	if err = bl.SetLabels("events", "missing", Labels{"a": "b"}); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expected ErrRecordNotFound, got %v", err)
+	}
+	if err = bl.SetLabels("events", "c", Labels{"bad key!": "x"}); !errors.Is(err, ErrInvalidLabel) {
+		t.Errorf("expected ErrInvalidLabel, got %v", err)
+	}
+	if err = bl.SaveContext(WithLabels(context.Background(), Labels{"k": "a,b"}), "events", "e", "e"); !errors.Is(err, ErrInvalidLabel) {
+		t.Errorf("expected ErrInvalidLabel, got %v", err)
+	}
+	if _, err = bl.SelectByLabel("events", "=x"); !errors.Is(err, ErrBadSelector) {
+		t.Errorf("expected ErrBadSelector, got %v", err)
+	}
+}
+
+func TestLabelsEncrypted(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("labels_sealed_test.db")
+
+	bl, err := NewBoltLocknut("labels_sealed_test.db", ".", []byte("secret"), false, []string{"events", "archive"},
+		WithBucketKeys())
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	ctx := WithLabels(context.Background(), Labels{"classification": "pii"})
+	if err = bl.SaveContext(ctx, "events", "a", "a"); err != nil {
+		t.Fatalf("SaveContext: %s", err)
+	}
+
+	raw := func(bucket, key string) []byte {
+		t.Helper()
+		if err := bl.openDB(); err != nil {
+			t.Fatalf("openDB: %s", err)
+		}
+		defer bl.closeDB()
+		var v []byte
+		bl.db.view(func(tx *bbolt.Tx) error {
+			v = nestedValue(tx, labelsBucket, bucket, key)
+			return nil
+		})
+		return v
+	}
+	if v := raw("events", "a"); v == nil || bytes.Contains(v, []byte("pii")) {
+		t.Errorf("expected the labels encrypted, got %q", v)
+	}
+
+	// labels that do not authenticate, injected in the clear for instance, are refused
+	if err = bl.Save("events", "b", "b"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if err = bl.openDB(); err != nil {
+		t.Fatalf("openDB: %s", err)
+	}
+	err = bl.db.update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(labelsBucket).Bucket([]byte("events")).Put([]byte("b"), []byte(`{"source":"etl"}`))
+	})
+	bl.closeDB()
+	if err != nil {
+		t.Fatalf("update: %s", err)
+	}
+	if keys, err := bl.SelectByLabel("events", "source=etl"); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("expected ErrDecrypt, got %v %v", keys, err)
+	}
+	if err = bl.SetLabels("events", "b", Labels{"source": "etl"}); err != nil {
+		t.Fatalf("SetLabels: %s", err)
+	}
+
+	// labels move with their records, and are encrypted with the keys they move to
+	if err = bl.RotateKey([]byte("another secret")); err != nil {
+		t.Fatalf("RotateKey: %s", err)
+	}
+	if err = bl.SwapBuckets("events", "archive"); err != nil {
+		t.Fatalf("SwapBuckets: %s", err)
+	}
+	if keys, err := bl.SelectByLabel("archive", "classification=pii"); err != nil || !reflect.DeepEqual(keys, []string{"a"}) {
+		t.Errorf("unexpected %v %v", keys, err)
+	}
+	if keys, err := bl.SelectByLabel("archive", "source=etl"); err != nil || !reflect.DeepEqual(keys, []string{"b"}) {
+		t.Errorf("unexpected %v %v", keys, err)
+	}
+}
+
+func TestLabelsWriteOnly(t *testing.T) {
+	skipFIPS(t, "X25519 record sealing")
+	defer os.Remove("labels_writeonly_test.db")
+
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %s", err)
+	}
+	bl, err := NewBoltLocknut("labels_writeonly_test.db", ".", nil, false, []string{"events"},
+		WithPublicKey(key.PublicKey()))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	ctx := WithLabels(context.Background(), Labels{"source": "etl"})
+	if err = bl.SaveContext(ctx, "events", "a", "a"); err != nil {
+		t.Fatalf("SaveContext: %s", err)
+	}
+	if _, err = bl.GetLabels("events", "a"); !errors.Is(err, ErrWriteOnly) {
+		t.Errorf("expected ErrWriteOnly, got %v", err)
+	}
+
+	reader, err := NewBoltLocknut("labels_writeonly_test.db", ".", nil, false, nil, WithPrivateKey(key))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if keys, err := reader.SelectByLabel("events", "source=etl"); err != nil || !reflect.DeepEqual(keys, []string{"a"}) {
+		t.Errorf("unexpected %v %v", keys, err)
+	}
+}
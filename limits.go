@@ -0,0 +1,85 @@
+package locknut
+
+import (
+	"errors"
+	"fmt"
+	"go.etcd.io/bbolt"
+)
+
+var (
+	// ErrValueTooLarge is returned for writes of a value larger than the limit set WithMaxValueSize
+	ErrValueTooLarge = errors.New("value is too large")
+	// ErrKeyTooLong is returned for writes under a key longer than the limit set WithMaxKeySize
+	ErrKeyTooLong = errors.New("key is too long")
+)
+
+// WithMaxValueSize rejects values larger than size bytes, before they are encrypted, with a RecordError
+// of kind ErrValueTooLarge. Save checks the value once it is encoded and before it is copied, so an
+// accidental huge value fails instead of exhausting memory. Without it values are limited to what
+// bbolt stores, about 2GB.
+func WithMaxValueSize(size int) Option {
+	return func(bl *BoltLocknut) {
+		bl.maxValueSize = size
+	}
+}
+
+// WithMaxKeySize rejects keys longer than size bytes with a RecordError of kind ErrKeyTooLong. Without
+// it, or above it, keys are limited to what bbolt stores, 32768 bytes.
+func WithMaxKeySize(size int) Option {
+	return func(bl *BoltLocknut) {
+		bl.maxKeySize = size
+	}
+}
+
+// checkLimits fails if key or value are over the limits of the db
+func (bl *BoltLocknut) checkLimits(op, bucket, key string, value []byte) error {
+	maxKey := bbolt.MaxKeySize
+	if bl.maxKeySize > 0 && bl.maxKeySize < maxKey {
+		maxKey = bl.maxKeySize
+	}
+	if len(key) > maxKey {
+		short := key
+		if len(short) > 64 {
+			// the key itself would drown the message
+			short = short[:64] + "..."
+		}
+		return &RecordError{Op: op, Bucket: bucket, Key: short, Kind: ErrKeyTooLong,
+			Err: fmt.Errorf("key is %d bytes, the limit is %d", len(key), maxKey)}
+	}
+	if maxValue := bl.maxValue(); len(value) > maxValue {
+		return &RecordError{Op: op, Bucket: bucket, Key: key, Kind: ErrValueTooLarge,
+			Err: fmt.Errorf("value is %d bytes, the limit is %d", len(value), maxValue)}
+	}
+	return nil
+}
+
+// maxValue is the largest value the db takes, in bytes
+func (bl *BoltLocknut) maxValue() int {
+	if bl.maxValueSize > 0 && bl.maxValueSize < bbolt.MaxValueSize {
+		return bl.maxValueSize
+	}
+	return bbolt.MaxValueSize
+}
+
+// ValueSize returns the size in bytes of the record stored under key as it is stored, encrypted and
+// padded, which is what it takes in the db file. A key without a record fails with ErrRecordNotFound.
+func (bl *BoltLocknut) ValueSize(bucket, key string) (size int, err error) {
+	if err = bl.openDB(); err != nil {
+		return 0, err
+	}
+	defer bl.closeDB()
+
+	err = bl.db.view(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket([]byte(bucket))
+		if bkt == nil {
+			return bucketNotFound("value_size", bucket)
+		}
+		v := bkt.Get([]byte(key))
+		if expired := bl.expiredIn(tx, bucket); v == nil || (expired != nil && expired([]byte(key))) {
+			return fmt.Errorf("%w: %s/%s", ErrRecordNotFound, bucket, key)
+		}
+		size = len(v)
+		return nil
+	})
+	return size, err
+}
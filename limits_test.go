@@ -0,0 +1,61 @@
+package locknut
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLimits(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("limits_test.db")
+
+	bl, err := NewBoltLocknut("limits_test.db", ".", []byte("secret"), false, []string{"docs"},
+		WithMaxValueSize(64), WithMaxKeySize(16))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+
+	var re *RecordError
+	err = bl.Save("docs", "big", strings.Repeat("x", 100))
+	if !errors.Is(err, ErrValueTooLarge) || !errors.As(err, &re) || re.Key != "big" {
+		t.Errorf("expected ErrValueTooLarge for big, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "value is 102 bytes, the limit is 64") {
+		t.Errorf("expected the sizes in the error, got %v", err)
+	}
+	if err = bl.SaveBytes("docs", strings.Repeat("k", 17), []byte("1")); !errors.Is(err, ErrKeyTooLong) {
+		t.Errorf("expected ErrKeyTooLong, got %v", err)
+	}
+	if err = bl.SaveAsync("docs", "big", strings.Repeat("x", 100)); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("expected SaveAsync to reject the value when queueing it, got %v", err)
+	}
+	in := `{"key":"big","value":"` + strings.Repeat("x", 100) + `"}` + "\n"
+	if err = bl.Import(strings.NewReader(in), FormatJSONL, "docs"); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("expected Import to reject the value, got %v", err)
+	}
+	_, err = bl.ImportStaged(strings.NewReader(in), FormatJSONL, StagingOptions{Bucket: "docs"})
+	if !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("expected ImportStaged to reject the value, got %v", err)
+	}
+	if keys, err := bl.GetKeyList("docs", ""); err != nil || len(keys) != 0 {
+		t.Errorf("expected nothing saved, got %v %v", keys, err)
+	}
+
+	// the limit is on the value as given, the stored size includes the encryption
+	value := strings.Repeat("x", 62)
+	if err = bl.Save("docs", "fits", value); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	size, err := bl.ValueSize("docs", "fits")
+	if err != nil {
+		t.Fatalf("ValueSize: %s", err)
+	}
+	if size <= 64 {
+		t.Errorf("expected the encrypted size above the value's, got %d", size)
+	}
+	if _, err = bl.ValueSize("docs", "missing"); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expected ErrRecordNotFound, got %v", err)
+	}
+}
@@ -2,14 +2,22 @@ package locknut
 
 import (
 	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/ed25519"
 	"crypto/sha256"
-	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/taybart/log"
 	"go.etcd.io/bbolt"
 	"io"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type boltDB struct {
@@ -25,67 +33,224 @@ type BoltLocknut struct {
 	buckets   []string
 	batchMode bool
 	db        *boltDB
+	opens     int
+	openMu    sync.Mutex // guards db and opens, shared by concurrent operations
+	readOnly  bool
+
+	boltOptions   bbolt.Options
+	fileMode      os.FileMode
+	dirMode       os.FileMode
+	strictMode    bool
+	public        *ecdh.PublicKey
+	private       *ecdh.PrivateKey
+	deterministic map[string]bool
+	padSizes      []int
+	transformers  []Transformer
+	mlock         bool
+
+	fence           fence
+	maintenanceWait time.Duration
+	idempotencyTTL  time.Duration
+	importWorkers   int
+	scanBudget      time.Duration
+	clock           Clock
+	rand            io.Reader
+	session         session
+	decryptGuard    decryptGuard
+	keys            keySource
+	lastOp          atomic.Int64 // unix nanoseconds, see Optimizer
+
+	statsPath    string
+	stats        statsQueue
+	changelog    bool
+	recordMeta   bool
+	searchPath   string
+	searchFields []IndexField
+	types        map[string]*typeSpec
+	typeBuckets  map[reflect.Type]string
+	strictSchema bool
+	declared     map[string]bool
+	keyPatterns  map[string]*regexp.Regexp
+	validators   map[string][]func([]byte) error
+	maxValueSize int
+	bucketKeys   bool
+	kdf          *KDF
+	fips         bool
+	keyKDF       *kdfRecord
+	peerKDF      []byte
+	maxKeySize   int
+	metrics      Metrics
+	tracer       Tracer
+	hooks        []Hooks
+	policy       *Policy
+	blooms       map[string]*bloomFilter
+	async        asyncQueue
+
+	audit         bool
+	auditIdentity func(ctx context.Context) string
+	auditKey      ed25519.PrivateKey
 }
 
+// Option configures optional behaviour of a BoltLocknut, passed to NewBoltLocknut
+type Option func(*BoltLocknut)
+
+// Locknut is the encrypted bucket and key store, implemented by BoltLocknut and by the other backends
+// in the badger, sqlite, filestore and redis packages. Values are encrypted with the key derived from
+// the secret by DeriveKey, so every backend reads what the others write.
+type Locknut interface {
+	Save(bucket, key string, data interface{}) error
+	SaveBytes(bucket, key string, data []byte) error
+	Get(bucket, key string) ([]byte, error)
+	GetOne(bucket, key string) ([]byte, error)
+	GetByPrefix(bucket, prefix string) (map[string][]byte, error)
+	GetKeyList(bucket, prefix string) ([]string, error)
+	Delete(bucket, key string) error
+	Buckets() ([]string, error)
+	CreateBucket(name string) error
+	Count(bucket string) (int, error)
+}
+
+var _ Locknut = (*BoltLocknut)(nil)
+
 // The key error messages generated in the package
 var (
 	ErrFileNameInvalid = errors.New("invalid file name")
 	ErrPathInvalid     = errors.New("invalid path name")
 	ErrKeyInvalid      = errors.New("invalid key or key is nil")
+	ErrBucketInvalid   = errors.New("invalid bucket name")
+	ErrNoChangelog     = errors.New("changelog is not enabled")
+	ErrNoSearchIndex   = errors.New("search index is not enabled")
+	ErrNotIndexed      = errors.New("field is not indexed")
+	ErrWrongSecret     = errors.New("secret does not match the db")
+	ErrReadOnly        = errors.New("db is open read only")
+	ErrPermissions     = errors.New("db file permissions are broader than allowed")
 )
 
+// internalPrefix marks buckets used by the package itself, they are skipped when listing or counting user buckets
+const internalPrefix = "__locknut_"
+
+func isInternalBucket(name []byte) bool {
+	return bytes.HasPrefix(name, []byte(internalPrefix))
+}
+
 // NewBoltLocknut The main function to initialize the the DB manager for all DB related operations
 // 	name: the db file name, such as mydb.dat, mytest.db
 // 	path: the db file's path, can be "" or any other director
 // 	secret: the secret value if you want to encrypt the values; if you don't want to encrypt the data, simply put it as ""
 // 	batchMode: to control whether to close the db file after each db operation
 // 	buckets: the buckets in the db file to be initialized if the db file does not existed
-func NewBoltLocknut(name, path string, secret []byte, batchMode bool, buckets []string) (*BoltLocknut, error) {
+// 	opts: optional features such as WithStatsDB
+func NewBoltLocknut(name, path string, secret []byte, batchMode bool, buckets []string, opts ...Option) (*BoltLocknut, error) {
+	bl := &BoltLocknut{
+		name:      name,
+		path:      path,
+		fullPath:  filepath.Join(path, name),
+		batchMode: batchMode,
+		buckets:   buckets,
+		fileMode:  0600,
+	}
+
+	bl.SetSecret(secret)
+
+	for _, opt := range opts {
+		opt(bl)
+	}
+	bl.declareBuckets()
+	if err := bl.checkFIPS(); err != nil {
+		return nil, err
+	}
+	if bl.mlock {
+		if err := mlock(bl.secret); err != nil {
+			return nil, err
+		}
+	}
+
 	var info os.FileInfo
 	if path != "" {
+		if bl.dirMode != 0 {
+			if err := os.MkdirAll(path, bl.dirMode); err != nil {
+				return nil, err
+			}
+		}
 		info, err := os.Stat(path)
 		if err != nil || !info.Mode().IsDir() {
 			return nil, ErrPathInvalid
 		}
 	}
 
-	fullPath := filepath.Join(path, name)
-	info, err := os.Stat(fullPath)
+	info, err := os.Stat(bl.fullPath)
 	if err == nil && !info.Mode().IsRegular() {
 		return nil, ErrFileNameInvalid
 	}
-
-	if err != nil {
-		log.Debugf("NewBoltLocknut DB file %s does not exist, will be created", fullPath)
+	if err == nil && bl.strictMode && info.Mode().Perm()&^bl.fileMode != 0 {
+		return nil, fmt.Errorf("%w: %s is %v, expected at most %v", ErrPermissions, bl.fullPath, info.Mode().Perm(), bl.fileMode)
 	}
 
-	bl := &BoltLocknut{
-		name:      name,
-		path:      path,
-		fullPath:  fullPath,
-		batchMode: batchMode,
-		buckets:   buckets,
+	if err != nil {
+		log.Debugf("NewBoltLocknut DB file %s does not exist, will be created", bl.fullPath)
 	}
 
-	bl.SetSecret(secret)
-
 	if err = bl.openDB(); err != nil {
 		return nil, err
 	}
 	defer bl.closeDB()
 
-	return bl, err
+	check := func(fn func(*bbolt.Tx) error) error { return bl.updateQuiet(bl.db, fn) }
+	if bl.readOnly {
+		check = bl.db.view
+	}
+	if err = check(func(tx *bbolt.Tx) error { return bl.applyKDF(tx, secret) }); err != nil {
+		return nil, err
+	}
+	if err = check(bl.checkSecret); err != nil {
+		return nil, err
+	}
+	if err = check(bl.checkBucketKeys); err != nil {
+		return nil, err
+	}
+	if bl.readOnly {
+		return bl, nil
+	}
+	if err = bl.updateQuiet(bl.db, sweepScratch); err != nil {
+		return nil, err
+	}
+
+	if bl.statsPath != "" {
+		if err = bl.rebuildStats(); err != nil {
+			return nil, err
+		}
+	}
+	if bl.searchPath != "" {
+		if err = bl.initSearchIndex(); err != nil {
+			return nil, err
+		}
+	}
+
+	return bl, nil
 }
 
 // SetSecret is to set the AES Cryptor key, if the key is nil, the cryptor is not initialized; otherwise
 // the cryptor is initialized, including the key and Cipher block that can be used directly for encrypt and decrypt functions
 func (bl *BoltLocknut) SetSecret(secret []byte) {
-	bl.secret = secret
+	// failing to lock the key in memory is reported by NewBoltLocknut
+	bl.setKey(deriveSecret(secret))
+}
+
+// DeriveKey returns the AES key a secret stands for, for backends and tools that encrypt values the
+// way BoltLocknut does
+func DeriveKey(secret []byte) []byte {
+	return deriveSecret(secret)
+}
+
+// deriveSecret turns a secret into the AES key, hashing secrets shorter than a full key
+func deriveSecret(secret []byte) []byte {
 	if len(secret) < 32 {
 		log.Verbose("Key too short, using hash")
 		sh := sha256.Sum256(secret)
-		bl.secret = sh[:]
+		return sh[:]
 	}
+	// a copy, Lock wipes the key and must not wipe the caller's secret with it
+	return append([]byte(nil), secret...)
 }
 
 // SetBatchMode is to set the batchMode for the boltdb. The boltdb file is always open in the file system unless the Close() is called.
@@ -96,22 +261,54 @@ func (bl *BoltLocknut) SetBatchMode(mode bool) {
 	bl.batchMode = mode
 	//if the batch mode is turned off, close DB directly
 	if !mode {
-		bl.closeDB()
+		bl.closeFile()
 	}
 }
 
-// This function creates the db file if it doesn't exist, and also initialize the buckets
+// This function creates the db file if it doesn't exist, and also initialize the buckets.
+// Calls may nest, the db stays open until the matching closeDB of the outermost call. It fails with
+// ErrLocked while the db is locked, and holds the key until closeDB, see hold.
 func (bl *BoltLocknut) openDB() error {
-	if bl.batchMode && bl.db != nil {
+	if err := bl.hold(); err != nil {
+		return err
+	}
+	err := bl.checkKeyProvider()
+	if err == nil {
+		err = bl.open()
+	}
+	if err != nil {
+		bl.release()
+	}
+	return err
+}
+
+// open is openDB without the check for a lock
+func (bl *BoltLocknut) open() error {
+	bl.openMu.Lock()
+	defer bl.openMu.Unlock()
+	if bl.db != nil {
+		bl.opens++
 		return nil
 	}
 
-	d, err := bbolt.Open(bl.fullPath, 0600, nil)
+	options := bl.boltOptions
+	options.ReadOnly = bl.readOnly
+	d, err := bbolt.Open(bl.fullPath, bl.fileMode, &options)
 	if err != nil {
 		return err
 	}
 
 	db := &boltDB{d}
+	// before initbuckets, which adds no keys and so keeps the filters up to date
+	if err = bl.loadBloomFilters(db); err != nil {
+		db.Close()
+		return err
+	}
+	if bl.readOnly {
+		bl.db = db
+		bl.opens = 1
+		return nil
+	}
 
 	initbuckets := func(tx *bbolt.Tx) error {
 		for _, bname := range bl.buckets {
@@ -119,22 +316,39 @@ func (bl *BoltLocknut) openDB() error {
 				return err
 			}
 		}
+		if bl.changelog {
+			if _, err := tx.CreateBucketIfNotExists(changelogBucket); err != nil {
+				return err
+			}
+		}
 		return nil
 	}
 
-	if err = db.update(initbuckets); err != nil {
+	if err = bl.updateQuiet(db, initbuckets); err != nil {
 		db.Close()
 		return err
 	}
 
 	bl.db = db
+	bl.opens = 1
 	return nil
 }
 
 // The closeDB function closes the db when the bl.db is not nil and the batchmode is false.
 // When the bl batchmode is true, please set it to be false in order to close the DB.
 func (bl *BoltLocknut) closeDB() {
-	if !bl.batchMode && bl.db != nil {
+	bl.closeFile()
+	bl.release()
+}
+
+// closeFile is closeDB for open, which holds no key
+func (bl *BoltLocknut) closeFile() {
+	bl.openMu.Lock()
+	defer bl.openMu.Unlock()
+	if bl.opens > 0 {
+		bl.opens--
+	}
+	if !bl.batchMode && bl.opens == 0 && bl.db != nil {
 		bl.db.Close()
 		bl.db = nil
 	}
@@ -156,16 +370,228 @@ func (db *boltDB) update(fn func(*bbolt.Tx) error) error {
 	return db.DB.Update(wrapper)
 }
 
+// writeTx is a single update transaction shared by the mutating operations. It encrypts values, logs
+// changes and collects the stats deltas and search index updates that are applied once the
+// transaction has committed.
+type writeTx struct {
+	bl      *BoltLocknut
+	ctx     context.Context
+	tx      *bbolt.Tx
+	deltas  map[string]statsDelta
+	indexed []indexOp
+}
+
+// write opens the db and runs fn in one update transaction
+func (bl *BoltLocknut) write(fn func(w *writeTx) error) error {
+	return bl.writeContext(context.Background(), fn)
+}
+
+// writeContext is write with the context used to trace the encryption of values
+func (bl *BoltLocknut) writeContext(ctx context.Context, fn func(w *writeTx) error) error {
+	if bl.readOnly {
+		return ErrReadOnly
+	}
+	if err := bl.keyWritable(); err != nil {
+		return err
+	}
+	return bl.applyWrite(ctx, fn)
+}
+
+// applyWrite is writeContext without the check of the key provider
+func (bl *BoltLocknut) applyWrite(ctx context.Context, fn func(w *writeTx) error) error {
+	if err := bl.fence.enter(bl.maintenanceWait); err != nil {
+		return err
+	}
+	defer bl.fence.leave()
+	return bl.commit(ctx, fn)
+}
+
+// commit runs fn in one update transaction, without checking for maintenance, then applies the stats
+// and search index updates it collected
+func (bl *BoltLocknut) commit(ctx context.Context, fn func(w *writeTx) error) error {
+	if err := bl.openDB(); err != nil {
+		return err
+	}
+	defer bl.closeDB()
+	return bl.commitOpen(ctx, fn)
+}
+
+// commitOpen is commit with the db already open
+func (bl *BoltLocknut) commitOpen(ctx context.Context, fn func(w *writeTx) error) error {
+	var err error
+	w := &writeTx{bl: bl, ctx: ctx, deltas: make(map[string]statsDelta)}
+	txid := 0
+	err = bl.db.update(func(tx *bbolt.Tx) error {
+		w.tx, txid = tx, tx.ID()
+		if err := fn(w); err != nil {
+			return err
+		}
+		if bl.metrics != nil {
+			bl.metrics.SetDBSize(tx.Size())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	bl.bloomCommitted(txid)
+	bl.updateStats(w.deltas)
+	bl.updateSearchIndex(w.indexed)
+	return nil
+}
+
+// put stores data under key, encrypting it first if the secret is set
+func (w *writeTx) put(bucket, key string, data []byte) error {
+	if err := w.bl.checkSchema("put", bucket, key); err != nil {
+		return err
+	}
+	if err := w.bl.checkLimits("put", bucket, key, data); err != nil {
+		return err
+	}
+	if err := w.bl.validateValue("put", bucket, key, data); err != nil {
+		return err
+	}
+	stored := data
+	if w.bl.secret != nil || w.bl.public != nil {
+		var err error
+		//encrypt the content before store in the db
+		start := time.Now()
+		_, end := w.bl.startSpan(w.ctx, "locknut.encrypt", bucket)
+		if fields := encryptedFieldsOf(w.ctx); len(fields) > 0 {
+			stored, err = w.bl.sealFields(bucket, data, fields)
+		} else {
+			stored, err = w.bl.sealFor(bucket, data)
+		}
+		end(err)
+		if err != nil {
+			return &RecordError{Op: "encrypt", Bucket: bucket, Key: key, Kind: ErrEncrypt, Err: err}
+		}
+		w.bl.observeCrypto("encrypt", start)
+	}
+	if labels := labelsOf(w.ctx); len(labels) > 0 {
+		if err := w.setLabels(bucket, key, labels); err != nil {
+			return err
+		}
+	}
+	if err := w.setExpiry(bucket, key); err != nil {
+		return err
+	}
+	added := w.bl.putStats(data, stored)
+	if len(encryptedFieldsOf(w.ctx)) > 0 {
+		// the fields went through the pipeline one by one, and the record reads back as the json of
+		// the document, so count it the way it is counted when it is replaced or deleted
+		added = w.bl.recordStats(bucket, stored)
+	}
+	if err := w.putRecord(bucket, key, stored, added); err != nil {
+		return err
+	}
+	// after putRecord, which counts the value replaced with the stats of the meta it replaces
+	if w.bl.recordMeta {
+		return w.touchRecordMeta(bucket, key, stored, added)
+	}
+	return nil
+}
+
+// putStored stores the bytes as they are, for values that are already in their stored form
+func (w *writeTx) putStored(bucket, key string, stored []byte) error {
+	return w.putRecord(bucket, key, stored, w.bl.recordStats(bucket, stored))
+}
+
+// putRecord stores the bytes as they are, adding added to the stats of bucket
+func (w *writeTx) putRecord(bucket, key string, stored []byte, added statsDelta) error {
+	bkt := w.tx.Bucket([]byte(bucket))
+	if bkt == nil {
+		return bucketNotFound("put", bucket)
+	}
+
+	delta := added.sub(w.storedStats(bucket, key, bkt.Get([]byte(key))))
+	if err := bkt.Put([]byte(key), stored); err != nil {
+		return err
+	}
+	w.deltas[bucket] = w.deltas[bucket].add(delta)
+	w.index(bucket, key, stored)
+	w.bloomAdd(bucket, key)
+	if err := w.merkleSet(bucket, key, stored); err != nil {
+		return err
+	}
+	if err := w.logAudit("put", bucket, key); err != nil {
+		return err
+	}
+
+	return w.bl.logChange(w.tx, Change{Op: OpPut, Bucket: bucket, Key: key, Value: stored})
+}
+
+// delete removes key from bucket
+func (w *writeTx) delete(bucket, key string) error {
+	bkt := w.tx.Bucket([]byte(bucket))
+	if bkt == nil {
+		return bucketNotFound("delete", bucket)
+	}
+
+	delta := statsDelta{}.sub(w.storedStats(bucket, key, bkt.Get([]byte(key))))
+	if err := bkt.Delete([]byte(key)); err != nil {
+		return err
+	}
+	w.deltas[bucket] = w.deltas[bucket].add(delta)
+	w.index(bucket, key, nil)
+	w.bloomDelete(bucket)
+	if err := w.merkleSet(bucket, key, nil); err != nil {
+		return err
+	}
+	if err := w.deleteLabels(bucket, key); err != nil {
+		return err
+	}
+	if err := w.deleteRecordMeta(bucket, key); err != nil {
+		return err
+	}
+	if err := w.deleteExpiry(bucket, key); err != nil {
+		return err
+	}
+	if err := w.logAudit("delete", bucket, key); err != nil {
+		return err
+	}
+
+	return w.bl.logChange(w.tx, Change{Op: OpDelete, Bucket: bucket, Key: key})
+}
+
+// index queues a search index update for a record of an indexed bucket
+func (w *writeTx) index(bucket, key string, stored []byte) {
+	if w.bl.searchPath == "" || len(w.bl.indexedFields(bucket)) == 0 {
+		return
+	}
+	// write only handles cannot read what they index
+	if w.bl.public != nil && w.bl.private == nil {
+		return
+	}
+	w.indexed = append(w.indexed, indexOp{bucket: bucket, key: key, stored: stored})
+}
+
 // GetByPrefix function returns the byte arrays for those records matched with specified Prefix. If the secret is set,
-// the function returns the decrypted content.
+// the function returns the decrypted content. The values are copies owned by the caller, they stay valid after the db is closed.
 func (bl *BoltLocknut) GetByPrefix(bucket, prefix string) (map[string][]byte, error) {
-	var err error
+	return bl.GetByPrefixContext(context.Background(), bucket, prefix)
+}
+
+// GetByPrefixContext is GetByPrefix traced as a child of the span in ctx. It stops at the deadline of
+// ctx, see PartialError.
+func (bl *BoltLocknut) GetByPrefixContext(ctx context.Context, bucket, prefix string) (map[string][]byte, error) {
+	return bl.GetByPrefixFrom(ctx, bucket, prefix, "")
+}
+
+// GetByPrefixFrom is GetByPrefixContext resuming at cursor, the Cursor of the PartialError of an
+// earlier scan, or starting from the first key when it is ""
+func (bl *BoltLocknut) GetByPrefixFrom(ctx context.Context, bucket, prefix, cursor string) (results map[string][]byte, err error) {
+	defer bl.observe("get_by_prefix", time.Now(), &err)
+	ctx, end := bl.startSpan(ctx, "locknut.GetByPrefix", bucket)
+	defer func() { end(err) }()
 	if err = bl.openDB(); err != nil {
 		return nil, err
 	}
 	defer bl.closeDB()
 
-	results := make(map[string][]byte)
+	results = make(map[string][]byte)
+	clock := bl.startScan(ctx)
 
 	seekPrefix := func(tx *bbolt.Tx) error {
 		prefixKey := []byte(prefix)
@@ -173,33 +599,52 @@ func (bl *BoltLocknut) GetByPrefix(bucket, prefix string) (map[string][]byte, er
 		bkt := tx.Bucket([]byte(bucket))
 
 		if bkt == nil {
-			return bbolt.ErrBucketNotFound
+			return bucketNotFound("get", bucket)
 		}
 
-		cursor := bkt.Cursor()
-		for k, v := cursor.Seek(prefixKey); bytes.HasPrefix(k, prefixKey); k, v = cursor.Next() {
+		expired := bl.expiredIn(tx, bucket)
+		c := bkt.Cursor()
+		for k, v := c.Seek(scanStart(prefix, cursor)); bytes.HasPrefix(k, prefixKey); k, v = c.Next() {
 			if len(prefixKey) == 0 && len(k) == 0 && len(v) == 0 { // corner case
 				break
 			}
-			if bl.secret != nil {
-				//secret key is set, decrypt the content before return
-				content := make([]byte, len(v))
-				copy(content, v)
-
-				dec, err := Decrypt(content, bl.secret)
-				if err != nil {
-					return errors.New("Decrypt error from db " + err.Error())
-				}
-				results[string(k)] = dec
-			} else {
-				results[string(k)] = v
+			if v == nil || (expired != nil && expired(k)) { // nested bucket or expired record
+				continue
 			}
+			if err := clock.stop(k, len(results)); err != nil {
+				return err
+			}
+			dec, err := bl.decryptContext(ctx, bucket, string(k), v)
+			if err != nil {
+				return err
+			}
+			results[string(k)] = dec
 		}
 		return nil
 	}
 
-	if err = bl.db.view(seekPrefix); err != nil {
+	if err = bl.db.view(seekPrefix); err != nil && !errors.Is(err, ErrDeadlinePartial) {
 		log.Error("GetByPrefix return", err)
+		return results, err
+	}
+	if len(results) > 0 {
+		if aerr := bl.auditRead(ctx, "get_by_prefix", bucket, prefix); aerr != nil {
+			return nil, aerr
+		}
+	}
+	for k, v := range results {
+		if len(bl.hooks) == 0 {
+			break
+		}
+		v, herr := bl.afterGet(ctx, bucket, k, v)
+		if herr != nil {
+			return nil, herr
+		}
+		if v == nil {
+			delete(results, k)
+		} else {
+			results[k] = v
+		}
 	}
 
 	return results, err
@@ -207,14 +652,20 @@ func (bl *BoltLocknut) GetByPrefix(bucket, prefix string) (map[string][]byte, er
 
 // GetKeyList function returns the string array for keys with specified Prefix.
 func (bl *BoltLocknut) GetKeyList(bucket, prefix string) ([]string, error) {
-	var err error
-	var results []string
+	return bl.GetKeyListFrom(context.Background(), bucket, prefix, "")
+}
+
+// GetKeyListFrom is GetKeyList stopping at the deadline of ctx, see PartialError, and resuming at
+// cursor, the Cursor of the PartialError of an earlier scan, unless it is ""
+func (bl *BoltLocknut) GetKeyListFrom(ctx context.Context, bucket, prefix, cursor string) (results []string, err error) {
+	defer bl.observe("get_key_list", time.Now(), &err)
 	if err = bl.openDB(); err != nil {
 		return nil, err
 	}
 	defer bl.closeDB()
 
 	results = make([]string, 0)
+	clock := bl.startScan(ctx)
 
 	seekPrefix := func(tx *bbolt.Tx) error {
 		prefixKey := []byte(prefix)
@@ -222,29 +673,144 @@ func (bl *BoltLocknut) GetKeyList(bucket, prefix string) ([]string, error) {
 		bkt := tx.Bucket([]byte(bucket))
 
 		if bkt == nil {
-			return bbolt.ErrBucketNotFound
+			return bucketNotFound("list", bucket)
 		}
 
-		cursor := bkt.Cursor()
-		for k, _ := cursor.Seek(prefixKey); k != nil && bytes.HasPrefix(k, prefixKey); k, _ = cursor.Next() {
+		expired := bl.expiredIn(tx, bucket)
+		c := bkt.Cursor()
+		for k, _ := c.Seek(scanStart(prefix, cursor)); k != nil && bytes.HasPrefix(k, prefixKey); k, _ = c.Next() {
+			if expired != nil && expired(k) {
+				continue
+			}
+			if err := clock.stop(k, len(results)); err != nil {
+				return err
+			}
 			results = append(results, string(k))
 		}
 		return nil
 	}
 
-	if err = bl.db.view(seekPrefix); err != nil {
+	if err = bl.db.view(seekPrefix); err != nil && !errors.Is(err, ErrDeadlinePartial) {
 		log.Error("GetByPrefix return", err)
 	}
 
 	return results, err
 }
 
+// decryptContext is decrypt traced as a child of the span in ctx
+func (bl *BoltLocknut) decryptContext(ctx context.Context, bucket, key string, stored []byte) ([]byte, error) {
+	_, end := bl.startSpan(ctx, "locknut.decrypt", bucket)
+	dec, err := bl.decrypt(bucket, key, stored)
+	end(err)
+	return dec, err
+}
+
+// decrypt returns the stored value of bucket/key, decrypted if the secret is set. The result never
+// shares memory with stored, which may point into the mmap of a transaction and is only valid until
+// it ends, so values read through decrypt can be returned to callers as they are.
+func (bl *BoltLocknut) decrypt(bucket, key string, stored []byte) ([]byte, error) {
+	if bl.secret == nil && bl.public == nil {
+		if bl.session.wiped.Load() {
+			return nil, &RecordError{Op: "decrypt", Bucket: bucket, Key: key, Kind: ErrLocked}
+		}
+		content := make([]byte, len(stored))
+		copy(content, stored)
+		return content, nil
+	}
+
+	start := time.Now()
+	dec, _, err := bl.openStored(bucket, stored)
+	if err != nil {
+		kind := ErrDecrypt
+		if errors.Is(err, ErrCiphertextTooShort) || errors.Is(err, ErrCorrupt) {
+			kind = ErrCorrupt
+		} else if errors.Is(err, ErrWriteOnly) {
+			kind, err = ErrWriteOnly, nil
+		}
+		if kind == ErrDecrypt {
+			bl.decryptFailed("decrypt", bucket, key, err)
+		}
+		return nil, &RecordError{Op: "decrypt", Bucket: bucket, Key: key, Kind: kind, Err: err}
+	}
+	bl.observeCrypto("decrypt", start)
+	bl.decryptSucceeded()
+	return dec, nil
+}
+
+// openInfo is what openStored found out about a value besides its plaintext
+type openInfo struct {
+	// legacy is set for values encrypted with the secret itself, see openCiphertext
+	legacy bool
+	// compressed is set for values that went through a compressing stage
+	compressed bool
+}
+
+// openStored decrypts and restores a stored value of bucket
+func (bl *BoltLocknut) openStored(bucket string, stored []byte) (value []byte, info openInfo, err error) {
+	if isFieldSealed(stored) {
+		value, info, err = bl.openFields(bucket, stored)
+		return value, info, err
+	}
+	ct, framed := cutFrame(stored)
+	if bl.public != nil {
+		value, err = bl.unseal(ct)
+	} else {
+		value, info.legacy, err = bl.openCiphertext(bucket, ct)
+	}
+	if err != nil {
+		return nil, openInfo{}, err
+	}
+	value, names, err := bl.restoreStages(value, framed)
+	info.compressed = info.compressed || bl.compresses(names)
+	return value, info, err
+}
+
+// Get returns the record stored under exactly key, decrypted if the secret is set, or nil if there is
+// none. Unlike GetOne it never returns a record whose key only starts with key.
+func (bl *BoltLocknut) Get(bucket, key string) (result []byte, err error) {
+	defer bl.observe("get", time.Now(), &err)
+	if err = bl.openDB(); err != nil {
+		return nil, err
+	}
+	defer bl.closeDB()
+
+	err = bl.db.view(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket([]byte(bucket))
+		if bkt == nil {
+			return bucketNotFound("get", bucket)
+		}
+
+		if !bl.bloomMayHave(tx, bucket, []byte(key)) {
+			return nil
+		}
+		v := bkt.Get([]byte(key))
+		if expired := bl.expiredIn(tx, bucket); v == nil || (expired != nil && expired([]byte(key))) {
+			return nil
+		}
+		result, err = bl.decrypt(bucket, key, v)
+		return err
+	})
+	if err == nil && result != nil {
+		if err = bl.auditRead(context.Background(), "get", bucket, key); err != nil {
+			return nil, err
+		}
+		return bl.afterGet(context.Background(), bucket, key, result)
+	}
+
+	return result, err
+}
+
 // GetOne function returns the first record containing the key, If the secret is set,
 // the function returns the decrypted content.
 func (bl *BoltLocknut) GetOne(bucket, key string) ([]byte, error) {
-	var err error
-	var result []byte
+	return bl.GetOneContext(context.Background(), bucket, key)
+}
 
+// GetOneContext is GetOne traced as a child of the span in ctx
+func (bl *BoltLocknut) GetOneContext(ctx context.Context, bucket, key string) (result []byte, err error) {
+	defer bl.observe("get_one", time.Now(), &err)
+	ctx, end := bl.startSpan(ctx, "locknut.GetOne", bucket)
+	defer func() { end(err) }()
 	if err = bl.openDB(); err != nil {
 		return nil, err
 	}
@@ -254,31 +820,28 @@ func (bl *BoltLocknut) GetOne(bucket, key string) ([]byte, error) {
 		return nil, ErrKeyInvalid
 	}
 
+	var found string
 	seek := func(tx *bbolt.Tx) error {
 		prefixKey := []byte(key)
 
 		bkt := tx.Bucket([]byte(bucket))
 
 		if bkt == nil {
-			return bbolt.ErrBucketNotFound
+			return bucketNotFound("get", bucket)
 		}
 
+		expired := bl.expiredIn(tx, bucket)
 		cursor := bkt.Cursor()
 		k, v := cursor.Seek(prefixKey)
-
-		content := make([]byte, len(v))
-		copy(content, v)
+		for expired != nil && k != nil && bytes.HasPrefix(k, prefixKey) && expired(k) {
+			k, v = cursor.Next()
+		}
 
 		if k != nil && bytes.HasPrefix(k, prefixKey) {
-			if bl.secret != nil {
-				dec, err := Decrypt(content, bl.secret)
-				if err != nil {
-					return errors.New("Decrypt error from db " + err.Error())
-				}
-				result = dec
-			} else {
-				result = content
-			}
+			var err error
+			found = string(k)
+			result, err = bl.decryptContext(ctx, bucket, found, v)
+			return err
 		}
 
 		return nil
@@ -287,128 +850,204 @@ func (bl *BoltLocknut) GetOne(bucket, key string) ([]byte, error) {
 	if err := bl.db.view(seek); err != nil {
 		return nil, err
 	}
+	if result != nil {
+		if err = bl.auditRead(ctx, "get", bucket, found); err != nil {
+			return nil, err
+		}
+		if result, err = bl.afterGet(ctx, bucket, found, result); err != nil {
+			return nil, err
+		}
+	}
 
 	return result, nil
 }
 
 // Save function stores the record into the db file. If the secret value is set, the function
-// encrypts the content before storing into the db.
+// encrypts the content before storing into the db. Records of a bucket declared with RegisterType
+// must be of its type, pass its validators and are encoded with its codec, others are stored as json.
+// Structs with fields tagged locknut:"encrypt" only have those fields encrypted, the rest of their json
+// is stored as is.
 func (bl *BoltLocknut) Save(bucket, key string, data interface{}) error {
-	var err error
+	return bl.SaveContext(context.Background(), bucket, key, data)
+}
 
-	if err = bl.openDB(); err != nil {
+// SaveContext is Save traced as a child of the span in ctx, applied at most once for the idempotency
+// key of ctx, see WithIdempotencyKey
+func (bl *BoltLocknut) SaveContext(ctx context.Context, bucket, key string, data interface{}) error {
+	ctx, value, err := bl.prepareSave(ctx, bucket, data)
+	if err != nil {
 		return err
 	}
-	defer bl.closeDB()
+	return bl.SaveBytesContext(ctx, bucket, key, value)
+}
 
+// prepareSave encodes data for a save in bucket, and returns the context carrying how to store it
+func (bl *BoltLocknut) prepareSave(ctx context.Context, bucket string, data interface{}) (context.Context, []byte, error) {
 	if data == nil {
-		return errors.New("data is nil")
+		return ctx, nil, errors.New("data is nil")
 	}
 
-	save := func(tx *bbolt.Tx) error {
-		var err error
-		bkt := tx.Bucket([]byte(bucket))
-
-		value, err := json.Marshal(data)
-		if err != nil {
-			return err
-		}
-		if bl.secret != nil {
-			//encrypt the content before store in the db
-			enc, err := Encrypt(value, bl.secret)
-			if err != nil {
-				return errors.New("Encrypt error from db " + err.Error())
-			}
-
-			if err = bkt.Put([]byte(key), enc); err != nil {
-				return err
-			}
-		} else {
-			if err = bkt.Put([]byte(key), value); err != nil {
-				return err
-			}
-		}
-
-		return nil
+	value, err := bl.encode(bucket, data)
+	if err != nil {
+		return ctx, nil, err
 	}
 
-	return bl.db.update(save)
+	// records with fields tagged locknut:"encrypt" have those encrypted instead of the whole value
+	if fields := encryptedFields(data); len(fields) > 0 && bl.storesJSON(bucket) {
+		ctx = withEncryptedFields(ctx, fields)
+	}
+	if bl.recordMeta && contentTypeOf(ctx) == "" && bl.storesJSON(bucket) {
+		ctx = WithContentType(ctx, ContentTypeJSON)
+	}
+	return ctx, value, nil
 }
 
 // SaveBytes function stores the record into the db file. If the secret value is set, the function
 // encrypts the content before storing into the db.
 func (bl *BoltLocknut) SaveBytes(bucket, key string, data []byte) error {
-	var err error
+	return bl.SaveBytesContext(context.Background(), bucket, key, data)
+}
 
-	if err = bl.openDB(); err != nil {
+// SaveBytesContext is SaveBytes traced as a child of the span in ctx, applied at most once for the
+// idempotency key of ctx
+func (bl *BoltLocknut) SaveBytesContext(ctx context.Context, bucket, key string, data []byte) (err error) {
+	defer bl.observe("save", time.Now(), &err)
+	ctx, end := bl.startSpan(ctx, "locknut.Save", bucket)
+	defer func() { end(err) }()
+	if data == nil {
+		return errors.New("data is nil")
+	}
+	if err = bl.checkLimits("save", bucket, key, data); err != nil {
 		return err
 	}
-	defer bl.closeDB()
+	if data, err = bl.beforeSave(ctx, bucket, key, data); err != nil {
+		return err
+	}
+	if bl.keys.queueing() {
+		data = append([]byte(nil), data...)
+	}
 
-	if data == nil {
-		return errors.New("data is nil")
+	err = bl.writeOrQueue(ctx, func(w *writeTx) error {
+		if applied, err := w.claimIdempotencyKey("save", bucket, key, data); applied || err != nil {
+			return err
+		}
+		return w.put(bucket, key, data)
+	})
+	if err == nil {
+		bl.afterSave(ctx, bucket, key, data)
 	}
+	return err
+}
 
-	save := func(tx *bbolt.Tx) error {
-		var err error
-		bkt := tx.Bucket([]byte(bucket))
+// Buckets returns the names of the buckets in the db, excluding the ones used internally by the package
+func (bl *BoltLocknut) Buckets() ([]string, error) {
+	var err error
+	if err = bl.openDB(); err != nil {
+		return nil, err
+	}
+	defer bl.closeDB()
 
-		if bl.secret != nil {
-			//encrypt the content before store in the db
-			enc, err := Encrypt(data, bl.secret)
-			if err != nil {
-				return errors.New("Encrypt error from db " + err.Error())
+	names := make([]string, 0)
+	err = bl.db.view(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bbolt.Bucket) error {
+			if !isInternalBucket(name) {
+				names = append(names, string(name))
 			}
+			return nil
+		})
+	})
+	return names, err
+}
 
-			if err = bkt.Put([]byte(key), enc); err != nil {
-				return err
-			}
-		} else {
-			if err = bkt.Put([]byte(key), data); err != nil {
-				return err
-			}
-		}
+// Count returns the number of records in bucket
+func (bl *BoltLocknut) Count(bucket string) (int, error) {
+	var err error
+	if err = bl.openDB(); err != nil {
+		return 0, err
+	}
+	defer bl.closeDB()
 
+	n := 0
+	err = bl.db.view(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket([]byte(bucket))
+		if bkt == nil {
+			return bucketNotFound("count", bucket)
+		}
+		n = bkt.Stats().KeyN
 		return nil
+	})
+	return n, err
+}
+
+// CreateBucket creates the bucket if it does not exist yet and adds it to the buckets initialized on open
+func (bl *BoltLocknut) CreateBucket(name string) error {
+	if name == "" || isInternalBucket([]byte(name)) {
+		return ErrBucketInvalid
+	}
+	if err := bl.checkBucket("create", name); err != nil {
+		return err
+	}
+
+	err := bl.write(func(w *writeTx) error {
+		_, err := w.tx.CreateBucketIfNotExists([]byte(name))
+		return err
+	})
+	if err != nil {
+		return err
 	}
 
-	return bl.db.update(save)
+	for _, b := range bl.buckets {
+		if b == name {
+			return nil
+		}
+	}
+	bl.buckets = append(bl.buckets, name)
+	return nil
 }
 
 // Delete function deletes the record specified by the key.
 func (bl *BoltLocknut) Delete(bucket, key string) error {
-	var err error
-
-	if err = bl.openDB(); err != nil {
-		return err
-	}
-	defer bl.closeDB()
+	return bl.DeleteContext(context.Background(), bucket, key)
+}
 
+// DeleteContext is Delete applied at most once for the idempotency key of ctx
+func (bl *BoltLocknut) DeleteContext(ctx context.Context, bucket, key string) (err error) {
+	defer bl.observe("delete", time.Now(), &err)
 	if key == "" {
 		return errors.New("cannot delete, key is nil")
 	}
+	if err = bl.beforeDelete(ctx, bucket, key); err != nil {
+		return err
+	}
 
-	delete := func(tx *bbolt.Tx) error {
-		bkt := tx.Bucket([]byte(bucket))
-		if err := bkt.Delete([]byte(key)); err != nil {
+	err = bl.writeOrQueue(ctx, func(w *writeTx) error {
+		if applied, err := w.claimIdempotencyKey("delete", bucket, key, nil); applied || err != nil {
 			return err
 		}
-		return nil
+		return w.delete(bucket, key)
+	})
+	if err == nil {
+		bl.afterDelete(ctx, bucket, key)
 	}
-
-	return bl.db.update(delete)
+	return err
 }
 
-// GetDBBytes extracts a byte representation of db
-// @TODO: move to exporting directly to stream writer
-func (bl BoltLocknut) GetDBBytes() []byte {
-	r, w := io.Pipe()
+// maxDBBytes is the size of the largest db GetDBBytes copies into memory
+const maxDBBytes = 64 << 20
+
+// GetDBBytes extracts a byte representation of db. Dbs larger than 64MB are not copied, it logs the
+// error and returns nil then, like for any other error.
+//
+// Deprecated: use WriteTo or Backup, which stream the db and return their errors.
+func (bl *BoltLocknut) GetDBBytes() []byte {
+	if info, err := os.Stat(bl.fullPath); err == nil && info.Size() > maxDBBytes {
+		log.Error("GetDBBytes", fmt.Errorf("db is %d bytes, over the %d it copies into memory", info.Size(), maxDBBytes))
+		return nil
+	}
 	var buf bytes.Buffer
-	go bl.db.View(func(tx *bbolt.Tx) error {
-		defer w.Close()
-		_, err := tx.WriteTo(w)
-		return err
-	})
-	buf.ReadFrom(r)
+	if _, err := bl.Backup(&buf, BackupOptions{}); err != nil {
+		log.Error("GetDBBytes", err)
+		return nil
+	}
 	return buf.Bytes()
 }
@@ -0,0 +1,297 @@
+// Package locknutload generates concurrent workloads against any locknut.Locknut and reports the
+// latency percentiles of its reads and writes, for capacity planning. The locknutload command runs it
+// against a db file.
+package locknutload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/taybart/locknut"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Distribution is how the keys of a workload are picked
+type Distribution int
+
+const (
+	// Uniform picks every key with the same probability
+	Uniform Distribution = iota
+	// Zipf picks a few hot keys most of the time, as real traffic tends to
+	Zipf
+	// Sequential walks the key space in order, each worker from its own offset
+	Sequential
+)
+
+// ParseDistribution returns the Distribution named s: uniform, zipf or sequential
+func ParseDistribution(s string) (Distribution, error) {
+	switch s {
+	case "uniform":
+		return Uniform, nil
+	case "zipf":
+		return Zipf, nil
+	case "sequential":
+		return Sequential, nil
+	}
+	return 0, fmt.Errorf("unknown key distribution %q", s)
+}
+
+func (d Distribution) String() string {
+	switch d {
+	case Uniform:
+		return "uniform"
+	case Zipf:
+		return "zipf"
+	case Sequential:
+		return "sequential"
+	}
+	return fmt.Sprintf("Distribution(%d)", int(d))
+}
+
+// Config describes a workload. Zero fields take the defaults noted.
+type Config struct {
+	// Bucket is the bucket the workload uses, created if needed, "load" by default
+	Bucket string
+	// Workers is the number of goroutines issuing operations, 8 by default
+	Workers int
+	// Ops is the number of operations to run in total. When Duration is set as well the workload ends
+	// with whichever comes first, when neither is set it runs 10000 operations.
+	Ops int
+	// Duration bounds how long the workload runs
+	Duration time.Duration
+	// ReadRatio is the fraction of operations that are reads, the rest being writes
+	ReadRatio float64
+	// Keys is the size of the key space, 1000 by default
+	Keys int
+	// ValueSize is the size of written values, 256 bytes by default. With ValueSizeMax above it sizes
+	// are picked uniformly between the two.
+	ValueSize    int
+	ValueSizeMax int
+	// Distribution is how keys are picked
+	Distribution Distribution
+	// Preload writes every key once before the workload starts, so reads find records
+	Preload bool
+	// Seed makes the sequence of operations reproducible
+	Seed int64
+}
+
+func (c *Config) defaults() {
+	if c.Bucket == "" {
+		c.Bucket = "load"
+	}
+	if c.Workers <= 0 {
+		c.Workers = 8
+	}
+	if c.Ops <= 0 && c.Duration <= 0 {
+		c.Ops = 10000
+	}
+	if c.Keys <= 0 {
+		c.Keys = 1000
+	}
+	if c.ValueSize <= 0 {
+		c.ValueSize = 256
+	}
+}
+
+// Latencies summarizes the latencies of one kind of operation
+type Latencies struct {
+	Count, Errors       int
+	Min, Mean, Max      time.Duration
+	P50, P90, P99, P999 time.Duration
+}
+
+func summarize(samples []time.Duration, errs int) Latencies {
+	l := Latencies{Count: len(samples), Errors: errs}
+	if len(samples) == 0 {
+		return l
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	var total time.Duration
+	for _, s := range samples {
+		total += s
+	}
+	at := func(p float64) time.Duration {
+		i := int(math.Ceil(p*float64(len(samples)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return samples[i]
+	}
+	l.Min, l.Max, l.Mean = samples[0], samples[len(samples)-1], total/time.Duration(len(samples))
+	l.P50, l.P90, l.P99, l.P999 = at(0.50), at(0.90), at(0.99), at(0.999)
+	return l
+}
+
+// Result is the outcome of a workload
+type Result struct {
+	Elapsed       time.Duration
+	Reads, Writes Latencies
+	// FirstError is the first error an operation returned, failed operations are counted and the
+	// workload goes on
+	FirstError error
+}
+
+// Ops returns the number of operations run
+func (r Result) Ops() int {
+	return r.Reads.Count + r.Writes.Count
+}
+
+// Throughput returns the operations run per second
+func (r Result) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Ops()) / r.Elapsed.Seconds()
+}
+
+// worker is the state of one goroutine of the workload
+type worker struct {
+	cfg    *Config
+	rnd    *rand.Rand
+	zipf   *rand.Zipf
+	next   int
+	value  []byte
+	reads  []time.Duration
+	writes []time.Duration
+	errs   [2]int
+	err    error
+}
+
+func (w *worker) key() string {
+	var n int
+	switch w.cfg.Distribution {
+	case Zipf:
+		n = int(w.zipf.Uint64())
+	case Sequential:
+		n = w.next % w.cfg.Keys
+		w.next++
+	default:
+		n = w.rnd.Intn(w.cfg.Keys)
+	}
+	return fmt.Sprintf("key-%08d", n)
+}
+
+func (w *worker) valueOfSize() []byte {
+	size := w.cfg.ValueSize
+	if w.cfg.ValueSizeMax > size {
+		size += w.rnd.Intn(w.cfg.ValueSizeMax - size + 1)
+	}
+	return w.value[:size]
+}
+
+// run issues operations until claim says to stop
+func (w *worker) run(l locknut.Locknut, claim func() bool) {
+	for claim() {
+		read := w.rnd.Float64() < w.cfg.ReadRatio
+		key := w.key()
+		start := time.Now()
+		var err error
+		if read {
+			_, err = l.Get(w.cfg.Bucket, key)
+		} else {
+			err = l.SaveBytes(w.cfg.Bucket, key, w.valueOfSize())
+		}
+		took := time.Since(start)
+
+		i := 1
+		if read {
+			i = 0
+			w.reads = append(w.reads, took)
+		} else {
+			w.writes = append(w.writes, took)
+		}
+		if err != nil {
+			w.errs[i]++
+			if w.err == nil {
+				w.err = err
+			}
+		}
+	}
+}
+
+// Run runs the workload of cfg against l until it completes or ctx is done
+func Run(ctx context.Context, l locknut.Locknut, cfg Config) (Result, error) {
+	cfg.defaults()
+	if cfg.ReadRatio < 0 || cfg.ReadRatio > 1 {
+		return Result{}, errors.New("locknutload: read ratio must be between 0 and 1")
+	}
+	if err := l.CreateBucket(cfg.Bucket); err != nil {
+		return Result{}, err
+	}
+
+	size := cfg.ValueSize
+	if cfg.ValueSizeMax > size {
+		size = cfg.ValueSizeMax
+	}
+	workers := make([]*worker, cfg.Workers)
+	for i := range workers {
+		rnd := rand.New(rand.NewSource(cfg.Seed + int64(i)))
+		w := &worker{cfg: &cfg, rnd: rnd, value: make([]byte, size), next: i * cfg.Keys / cfg.Workers}
+		rnd.Read(w.value)
+		if cfg.Distribution == Zipf {
+			w.zipf = rand.NewZipf(rnd, 1.1, 1, uint64(cfg.Keys-1))
+		}
+		workers[i] = w
+	}
+
+	if cfg.Preload {
+		for n := 0; n < cfg.Keys; n++ {
+			if err := l.SaveBytes(cfg.Bucket, fmt.Sprintf("key-%08d", n), workers[0].valueOfSize()); err != nil {
+				return Result{}, fmt.Errorf("locknutload: preload: %w", err)
+			}
+		}
+	}
+
+	if cfg.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Duration)
+		defer cancel()
+	}
+	var mu sync.Mutex
+	issued := 0
+	claim := func() bool {
+		if ctx.Err() != nil {
+			return false
+		}
+		if cfg.Ops <= 0 {
+			return true
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if issued == cfg.Ops {
+			return false
+		}
+		issued++
+		return true
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for _, w := range workers {
+		wg.Add(1)
+		go func(w *worker) {
+			defer wg.Done()
+			w.run(l, claim)
+		}(w)
+	}
+	wg.Wait()
+
+	res := Result{Elapsed: time.Since(start)}
+	var reads, writes []time.Duration
+	var readErrs, writeErrs int
+	for _, w := range workers {
+		reads = append(reads, w.reads...)
+		writes = append(writes, w.writes...)
+		readErrs += w.errs[0]
+		writeErrs += w.errs[1]
+		if res.FirstError == nil {
+			res.FirstError = w.err
+		}
+	}
+	res.Reads, res.Writes = summarize(reads, readErrs), summarize(writes, writeErrs)
+	return res, nil
+}
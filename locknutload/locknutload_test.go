@@ -0,0 +1,74 @@
+package locknutload
+
+import (
+	"context"
+	"github.com/taybart/locknut"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	if locknut.FIPSBuild() {
+		t.Skip("a short secret is not allowed in FIPS mode")
+	}
+	bl, err := locknut.NewBoltLocknut("load.db", t.TempDir(), []byte("secret"), true, nil)
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+
+	res, err := Run(context.Background(), bl, Config{
+		Workers:      4,
+		Ops:          500,
+		ReadRatio:    0.8,
+		Keys:         100,
+		ValueSize:    16,
+		ValueSizeMax: 64,
+		Distribution: Zipf,
+		Preload:      true,
+	})
+	if err != nil {
+		t.Fatalf("Run: %s", err)
+	}
+	if res.Ops() != 500 {
+		t.Errorf("expected 500 operations, got %d", res.Ops())
+	}
+	if res.FirstError != nil || res.Reads.Errors+res.Writes.Errors != 0 {
+		t.Errorf("unexpected errors: %v", res.FirstError)
+	}
+	if res.Reads.Count < 300 || res.Writes.Count == 0 {
+		t.Errorf("unexpected read/write mix %d/%d", res.Reads.Count, res.Writes.Count)
+	}
+	r := res.Reads
+	if r.Min > r.P50 || r.P50 > r.P90 || r.P90 > r.P99 || r.P99 > r.Max {
+		t.Errorf("percentiles out of order: %+v", r)
+	}
+	if n, _ := bl.Count("load"); n != 100 {
+		t.Errorf("expected the key space to be preloaded, got %d keys", n)
+	}
+}
+
+func TestRunDuration(t *testing.T) {
+	bl, err := locknut.NewBoltLocknut("load.db", t.TempDir(), nil, true, nil)
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	start := time.Now()
+	res, err := Run(context.Background(), bl, Config{Duration: 50 * time.Millisecond, Distribution: Sequential})
+	if err != nil {
+		t.Fatalf("Run: %s", err)
+	}
+	if time.Since(start) > time.Second || res.Ops() == 0 {
+		t.Errorf("expected a run of about 50ms, got %d ops in %s", res.Ops(), time.Since(start))
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	var samples []time.Duration
+	for i := 100; i >= 1; i-- {
+		samples = append(samples, time.Duration(i)*time.Millisecond)
+	}
+	l := summarize(samples, 0)
+	if l.P50 != 50*time.Millisecond || l.P99 != 99*time.Millisecond || l.Max != 100*time.Millisecond {
+		t.Errorf("unexpected summary %+v", l)
+	}
+}
@@ -0,0 +1,273 @@
+// Package locknuttest helps testing code that uses locknut: Fake is an in-memory locknut.Locknut, New
+// opens a BoltLocknut in a temporary directory removed with the test, and RequireRecord checks what a
+// store holds.
+package locknuttest
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"github.com/taybart/locknut"
+	"github.com/taybart/locknut/internal/testhooks"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Fake is a locknut.Locknut holding its records in memory, unencrypted. It behaves like BoltLocknut
+// for the methods of the interface: Save stores json, reads of missing keys return nil, and operations
+// on a bucket that does not exist fail with a locknut.RecordError of kind locknut.ErrBucketNotFound.
+// It is safe for concurrent use.
+type Fake struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string][]byte
+}
+
+var _ locknut.Locknut = (*Fake)(nil)
+
+// NewFake returns an empty Fake with buckets created
+func NewFake(buckets ...string) *Fake {
+	f := &Fake{buckets: make(map[string]map[string][]byte)}
+	for _, b := range buckets {
+		f.buckets[b] = make(map[string][]byte)
+	}
+	return f
+}
+
+// bucket returns the records of bucket, the caller holds mu
+func (f *Fake) bucket(op, bucket string) (map[string][]byte, error) {
+	records, ok := f.buckets[bucket]
+	if !ok {
+		return nil, &locknut.RecordError{Op: op, Bucket: bucket, Kind: locknut.ErrBucketNotFound}
+	}
+	return records, nil
+}
+
+// scan returns the keys of bucket starting with prefix in order, the caller holds mu
+func (f *Fake) scan(op, bucket, prefix string) (map[string][]byte, []string, error) {
+	records, err := f.bucket(op, bucket)
+	if err != nil {
+		return nil, nil, err
+	}
+	keys := make([]string, 0)
+	for k := range records {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return records, keys, nil
+}
+
+// Save stores data as json under key in bucket
+func (f *Fake) Save(bucket, key string, data interface{}) error {
+	if data == nil {
+		return errors.New("data is nil")
+	}
+	value, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return f.SaveBytes(bucket, key, value)
+}
+
+// SaveBytes stores a copy of data under key in bucket
+func (f *Fake) SaveBytes(bucket, key string, data []byte) error {
+	if data == nil {
+		return errors.New("data is nil")
+	}
+	if key == "" {
+		return locknut.ErrKeyInvalid
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	records, err := f.bucket("put", bucket)
+	if err != nil {
+		return err
+	}
+	records[key] = append([]byte(nil), data...)
+	return nil
+}
+
+// Get returns the record stored under exactly key, or nil if there is none
+func (f *Fake) Get(bucket, key string) ([]byte, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	records, err := f.bucket("get", bucket)
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := records[key]; ok {
+		return append([]byte(nil), v...), nil
+	}
+	return nil, nil
+}
+
+// GetOne returns the first record whose key starts with key
+func (f *Fake) GetOne(bucket, key string) ([]byte, error) {
+	if key == "" {
+		return nil, locknut.ErrKeyInvalid
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	records, keys, err := f.scan("get", bucket, key)
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+	return append([]byte(nil), records[keys[0]]...), nil
+}
+
+// GetByPrefix returns the records whose keys start with prefix
+func (f *Fake) GetByPrefix(bucket, prefix string) (map[string][]byte, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	records, keys, err := f.scan("get", bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+	results := make(map[string][]byte, len(keys))
+	for _, k := range keys {
+		results[k] = append([]byte(nil), records[k]...)
+	}
+	return results, nil
+}
+
+// GetKeyList returns the keys of bucket starting with prefix, in order
+func (f *Fake) GetKeyList(bucket, prefix string) ([]string, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	_, keys, err := f.scan("list", bucket, prefix)
+	return keys, err
+}
+
+// Delete removes key from bucket
+func (f *Fake) Delete(bucket, key string) error {
+	if key == "" {
+		return errors.New("cannot delete, key is nil")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	records, err := f.bucket("delete", bucket)
+	if err != nil {
+		return err
+	}
+	delete(records, key)
+	return nil
+}
+
+// Buckets returns the names of the buckets, in order
+func (f *Fake) Buckets() ([]string, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	names := make([]string, 0, len(f.buckets))
+	for name := range f.buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// CreateBucket creates the bucket if it does not exist yet
+func (f *Fake) CreateBucket(name string) error {
+	if name == "" {
+		return locknut.ErrBucketInvalid
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.buckets[name]; !ok {
+		f.buckets[name] = make(map[string][]byte)
+	}
+	return nil
+}
+
+// Count returns the number of records in bucket
+func (f *Fake) Count(bucket string) (int, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	records, err := f.bucket("count", bucket)
+	return len(records), err
+}
+
+// Secret returns a secret derived from seed, the same for the same seed, so tests reopen their dbs and
+// reproduce their failures without keeping keys around. It must never be used outside tests.
+func Secret(seed string) []byte {
+	sum := sha256.Sum256([]byte("locknuttest " + seed))
+	return sum[:]
+}
+
+// New opens a BoltLocknut with buckets in a temporary directory, encrypted with the Secret of the name
+// of the test, and closes it and removes the directory once the test is done. It fails the test if the
+// db cannot be opened.
+func New(t testing.TB, buckets []string, opts ...locknut.Option) *locknut.BoltLocknut {
+	t.Helper()
+	bl, err := locknut.NewBoltLocknut("test.db", t.TempDir(), Secret(t.Name()), false, buckets, opts...)
+	if err != nil {
+		t.Fatalf("locknuttest: opening the db: %s", err)
+	}
+	t.Cleanup(func() {
+		if err := bl.Close(); err != nil {
+			t.Errorf("locknuttest: closing the db: %s", err)
+		}
+	})
+	return bl
+}
+
+// WithRand makes a BoltLocknut read the nonces of the values it encrypts and the IDs it generates from
+// r instead of crypto/rand, so a test can produce the same file twice. A reader that repeats itself
+// reuses nonces, which breaks the encryption, so it only exists here, and NewBoltLocknut fails with
+// locknut.ErrNotFIPS for it in FIPS mode.
+func WithRand(r io.Reader) locknut.Option {
+	return testhooks.WithRand(r).(locknut.Option)
+}
+
+// RequireRecord fails the test unless l holds want under key in bucket. A []byte want is compared to
+// the stored bytes as they are, any other want to the stored json, the way Save would have encoded it.
+func RequireRecord(t testing.TB, l locknut.Locknut, bucket, key string, want interface{}) {
+	t.Helper()
+	got, err := l.Get(bucket, key)
+	if err != nil {
+		t.Fatalf("locknuttest: reading %s/%s: %s", bucket, key, err)
+		return
+	}
+	if got == nil {
+		t.Fatalf("locknuttest: no record %s/%s", bucket, key)
+		return
+	}
+	if raw, ok := want.([]byte); ok {
+		if string(got) != string(raw) {
+			t.Fatalf("locknuttest: record %s/%s is %q, want %q", bucket, key, got, raw)
+		}
+		return
+	}
+
+	wantJSON, err := json.Marshal(want)
+	if err != nil {
+		t.Fatalf("locknuttest: encoding the wanted record: %s", err)
+		return
+	}
+	var gotValue, wantValue interface{}
+	if err = json.Unmarshal(got, &gotValue); err != nil {
+		t.Fatalf("locknuttest: record %s/%s is not json: %q", bucket, key, got)
+		return
+	}
+	json.Unmarshal(wantJSON, &wantValue)
+	if !reflect.DeepEqual(gotValue, wantValue) {
+		t.Fatalf("locknuttest: record %s/%s is %s, want %s", bucket, key, got, wantJSON)
+	}
+}
+
+// RequireNoRecord fails the test if l holds a record under key in bucket
+func RequireNoRecord(t testing.TB, l locknut.Locknut, bucket, key string) {
+	t.Helper()
+	got, err := l.Get(bucket, key)
+	if err != nil {
+		t.Fatalf("locknuttest: reading %s/%s: %s", bucket, key, err)
+		return
+	}
+	if got != nil {
+		t.Fatalf("locknuttest: unexpected record %s/%s: %q", bucket, key, got)
+	}
+}
@@ -0,0 +1,95 @@
+package locknuttest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/taybart/locknut"
+	"math/rand"
+	"testing"
+)
+
+// recorder is a testing.TB recording failures instead of failing the test
+type recorder struct {
+	testing.TB
+	failed string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.failed = fmt.Sprintf(format, args...)
+}
+
+func TestFake(t *testing.T) {
+	f := NewFake("users")
+	if err := f.Save("users", "ada", map[string]int{"age": 36}); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if err := f.SaveBytes("users", "alan", []byte(`{"age":41}`)); err != nil {
+		t.Fatalf("SaveBytes: %s", err)
+	}
+	if err := f.Save("missing", "ada", 1); !errors.Is(err, locknut.ErrBucketNotFound) {
+		t.Errorf("expected ErrBucketNotFound, got %v", err)
+	}
+	if v, err := f.GetOne("users", "a"); err != nil || string(v) != `{"age":36}` {
+		t.Errorf("unexpected GetOne %s %v", v, err)
+	}
+	if keys, err := f.GetKeyList("users", "al"); err != nil || len(keys) != 1 || keys[0] != "alan" {
+		t.Errorf("unexpected GetKeyList %v %v", keys, err)
+	}
+	if res, err := f.GetByPrefix("users", ""); err != nil || len(res) != 2 {
+		t.Errorf("unexpected GetByPrefix %v %v", res, err)
+	}
+	if err := f.Delete("users", "alan"); err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+	if n, err := f.Count("users"); err != nil || n != 1 {
+		t.Errorf("unexpected Count %d %v", n, err)
+	}
+
+	RequireRecord(t, f, "users", "ada", struct {
+		Age int `json:"age"`
+	}{36})
+	RequireNoRecord(t, f, "users", "alan")
+
+	r := &recorder{TB: t}
+	RequireRecord(r, f, "users", "ada", map[string]int{"age": 37})
+	if r.failed != `locknuttest: record users/ada is {"age":36}, want {"age":37}` {
+		t.Errorf("unexpected failure %q", r.failed)
+	}
+	r.failed = ""
+	RequireRecord(r, f, "users", "alan", 1)
+	if r.failed != "locknuttest: no record users/alan" {
+		t.Errorf("unexpected failure %q", r.failed)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if !bytes.Equal(Secret("a"), Secret("a")) || bytes.Equal(Secret("a"), Secret("b")) {
+		t.Errorf("expected secrets to depend on their seed only")
+	}
+
+	bl := New(t, []string{"users"})
+	if err := bl.SaveBytes("users", "ada", []byte("raw")); err != nil {
+		t.Fatalf("SaveBytes: %s", err)
+	}
+	RequireRecord(t, bl, "users", "ada", []byte("raw"))
+}
+
+func TestWithRand(t *testing.T) {
+	if locknut.FIPSBuild() {
+		t.Skip("FIPS mode refuses a source of randomness other than crypto/rand")
+	}
+	id := func() string {
+		bl := New(t, []string{"people"}, WithRand(rand.New(rand.NewSource(1))))
+		id, err := bl.ID()
+		if err != nil {
+			t.Fatalf("ID: %s", err)
+		}
+		return id
+	}
+	if a, b := id(), id(); a != b {
+		t.Errorf("expected the same randomness to give the same db ID, got %s and %s", a, b)
+	}
+}
@@ -0,0 +1,124 @@
+package locknut
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrMaintenance is returned by writes made while a maintenance operation such as a key rotation
+// runs, and by a maintenance operation started while another one runs
+var ErrMaintenance = errors.New("db is under maintenance")
+
+// fence keeps writes out of the db while a maintenance operation runs. Maintenance spans several
+// steps, transactions or the swap of the secret, that must not interleave with other writes.
+type fence struct {
+	mu      sync.Mutex
+	op      string        // the running maintenance operation, empty if none
+	done    chan struct{} // closed when op ends
+	writers int           // writes in progress
+	drained chan struct{} // closed when the writes in progress have finished, if maintenance waits
+}
+
+// WithMaintenanceWait makes writes made during maintenance wait up to d for it to end instead of
+// failing with ErrMaintenance at once
+func WithMaintenanceWait(d time.Duration) Option {
+	return func(bl *BoltLocknut) {
+		bl.maintenanceWait = d
+	}
+}
+
+// enter registers a write, waiting up to wait for a running maintenance operation to end
+func (f *fence) enter(wait time.Duration) error {
+	var deadline <-chan time.Time
+	f.mu.Lock()
+	for f.op != "" {
+		op, done := f.op, f.done
+		f.mu.Unlock()
+		if wait <= 0 {
+			return fmt.Errorf("%w: %s", ErrMaintenance, op)
+		}
+		if deadline == nil {
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			deadline = timer.C
+		}
+		select {
+		case <-done:
+		case <-deadline:
+			return fmt.Errorf("%w: %s", ErrMaintenance, op)
+		}
+		f.mu.Lock()
+	}
+	f.writers++
+	f.mu.Unlock()
+	return nil
+}
+
+// leave ends a write registered with enter
+func (f *fence) leave() {
+	f.mu.Lock()
+	f.writers--
+	if f.writers == 0 && f.drained != nil {
+		close(f.drained)
+		f.drained = nil
+	}
+	f.mu.Unlock()
+}
+
+// begin starts the maintenance operation op once the writes in progress have finished
+func (f *fence) begin(op string) error {
+	f.mu.Lock()
+	if f.op != "" {
+		running := f.op
+		f.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrMaintenance, running)
+	}
+	f.op, f.done = op, make(chan struct{})
+	var drained chan struct{}
+	if f.writers > 0 {
+		f.drained = make(chan struct{})
+		drained = f.drained
+	}
+	f.mu.Unlock()
+
+	if drained != nil {
+		<-drained
+	}
+	return nil
+}
+
+// end finishes the running maintenance operation and lets writes through again
+func (f *fence) end() {
+	f.mu.Lock()
+	f.op = ""
+	close(f.done)
+	f.mu.Unlock()
+}
+
+// maintain runs fn as the maintenance operation op, with every other write fenced off
+func (bl *BoltLocknut) maintain(op string, fn func() error) error {
+	if bl.readOnly {
+		return ErrReadOnly
+	}
+	if err := bl.fence.begin(op); err != nil {
+		return err
+	}
+	defer bl.fence.end()
+	return fn()
+}
+
+// BeginMaintenance fences off writes for maintenance done outside the BoltLocknut, such as restoring
+// the file from a backup, until end is called. Writes fail with ErrMaintenance meanwhile, or wait for
+// it to end if WithMaintenanceWait is set. BeginMaintenance waits for writes in progress to finish.
+func (bl *BoltLocknut) BeginMaintenance(op string) (end func(), err error) {
+	if bl.readOnly {
+		return nil, ErrReadOnly
+	}
+	if err = bl.fence.begin(op); err != nil {
+		return nil, err
+	}
+	var once sync.Once
+	return func() { once.Do(bl.fence.end) }, nil
+}
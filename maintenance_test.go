@@ -0,0 +1,74 @@
+package locknut
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMaintenance(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("maintenance_test.db")
+
+	bl, err := NewBoltLocknut("maintenance_test.db", ".", []byte("secret"), false, []string{"items"})
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+
+	end, err := bl.BeginMaintenance("restore")
+	if err != nil {
+		t.Fatalf("BeginMaintenance: %s", err)
+	}
+	if err = bl.Save("items", "a", "value"); !errors.Is(err, ErrMaintenance) {
+		t.Errorf("expected ErrMaintenance from Save, got %v", err)
+	}
+	if err = bl.RotateKey([]byte("new secret")); !errors.Is(err, ErrMaintenance) {
+		t.Errorf("expected ErrMaintenance from RotateKey, got %v", err)
+	}
+	if _, err = bl.Get("items", "a"); err != nil {
+		t.Errorf("reads should go through during maintenance, got %v", err)
+	}
+	end()
+	end()
+
+	if err = bl.Save("items", "a", "value"); err != nil {
+		t.Fatalf("Save after maintenance: %s", err)
+	}
+	if err = bl.RotateKey([]byte("new secret")); err != nil {
+		t.Fatalf("RotateKey: %s", err)
+	}
+	if v, err := bl.Get("items", "a"); err != nil || string(v) != `"value"` {
+		t.Errorf("unexpected value after rotation %q %v", v, err)
+	}
+}
+
+func TestMaintenanceWait(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("maintenance_wait_test.db")
+
+	bl, err := NewBoltLocknut("maintenance_wait_test.db", ".", []byte("secret"), false, []string{"items"},
+		WithMaintenanceWait(time.Second))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+
+	end, err := bl.BeginMaintenance("compaction")
+	if err != nil {
+		t.Fatalf("BeginMaintenance: %s", err)
+	}
+	saved := make(chan error)
+	go func() {
+		saved <- bl.Save("items", "a", "value")
+	}()
+
+	select {
+	case err = <-saved:
+		t.Fatalf("Save did not wait for maintenance: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	end()
+	if err = <-saved; err != nil {
+		t.Errorf("queued Save: %s", err)
+	}
+}
@@ -0,0 +1,68 @@
+package locknut
+
+import "errors"
+
+// ErrMlockUnsupported is returned by NewBoltLocknut for WithMlock on systems it is not implemented on
+var ErrMlockUnsupported = errors.New("locking memory is not supported on this system")
+
+// WithMlock locks the pages holding the key in memory, so they are never written to swap. It is
+// implemented on Linux, where the process needs RLIMIT_MEMLOCK or CAP_IPC_LOCK for it, elsewhere
+// NewBoltLocknut fails with ErrMlockUnsupported.
+func WithMlock() Option {
+	return func(bl *BoltLocknut) {
+		bl.mlock = true
+	}
+}
+
+// wipe overwrites b with zeros
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// setKey replaces the key, wiping the previous one, and locks it in memory with WithMlock
+func (bl *BoltLocknut) setKey(key []byte) error {
+	if bl.secret != nil {
+		if bl.mlock {
+			munlock(bl.secret)
+		}
+		wipe(bl.secret)
+	}
+	bl.secret = key
+	if bl.mlock && key != nil {
+		return mlock(key)
+	}
+	return nil
+}
+
+// Close applies the writes queued by SaveAsync, and the stats updates the stats db could not take yet,
+// closes the db file, whatever the batch mode, and wipes the key from memory. Operations fail with
+// ErrLocked afterwards.
+func (bl *BoltLocknut) Close() error {
+	bl.stopAsync()
+	if err := bl.saveBloomFilters(); err != nil {
+		return err
+	}
+	if err := bl.closeStats(); err != nil {
+		return err
+	}
+	if err := bl.Lock(); err != nil {
+		return err
+	}
+	bl.openMu.Lock()
+	defer bl.openMu.Unlock()
+	if bl.db != nil {
+		err := bl.db.Close()
+		bl.db = nil
+		bl.opens = 0
+		return err
+	}
+	return nil
+}
+
+// Wipe overwrites the plaintext held by the Cryptor with zeros
+func (c *Cryptor) Wipe() {
+	wipe(c.plain)
+	c.plain = nil
+}
@@ -0,0 +1,11 @@
+package locknut
+
+import "syscall"
+
+func mlock(b []byte) error {
+	return syscall.Mlock(b)
+}
+
+func munlock(b []byte) {
+	syscall.Munlock(b)
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package locknut
+
+func mlock(b []byte) error {
+	return ErrMlockUnsupported
+}
+
+func munlock(b []byte) {}
@@ -0,0 +1,66 @@
+package locknut
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestCloseWipesKey(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("memory_test.db")
+
+	bl, err := NewBoltLocknut("memory_test.db", ".", []byte("secret"), true, []string{"vault"})
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if err = bl.Save("vault", "a", 1); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+
+	key := bl.secret
+	if err = bl.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	if !bytes.Equal(key, make([]byte, len(key))) || bl.secret != nil {
+		t.Error("expected Close to wipe the key")
+	}
+	if bl.db != nil {
+		t.Error("expected Close to close the file in batch mode")
+	}
+	if _, err = bl.Get("vault", "a"); !errors.Is(err, ErrLocked) {
+		t.Errorf("expected ErrLocked after Close, got %v", err)
+	}
+}
+
+func TestCryptorWipe(t *testing.T) {
+	plain := []byte("plaintext")
+	c := NewCryptor(plain)
+	if _, err := c.EncryptWithNewKey(); err != nil {
+		t.Fatalf("EncryptWithNewKey: %s", err)
+	}
+	c.Wipe()
+	if !bytes.Equal(plain, make([]byte, len(plain))) {
+		t.Errorf("expected Wipe to zero the plaintext, got %q", plain)
+	}
+}
+
+func TestWithMlock(t *testing.T) {
+	defer os.Remove("memory_test.db")
+
+	bl, err := NewBoltLocknut("memory_test.db", ".", []byte("secret"), false, []string{"vault"}, WithMlock())
+	if errors.Is(err, ErrMlockUnsupported) {
+		t.Skip(err)
+	}
+	if err != nil {
+		// mlock needs RLIMIT_MEMLOCK, which sandboxes may not grant
+		t.Skipf("mlock: %s", err)
+	}
+	if err = bl.Save("vault", "a", 1); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if err = bl.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+}
@@ -0,0 +1,298 @@
+package locknut
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"go.etcd.io/bbolt"
+)
+
+// ErrNoProof is returned by Prove for a key without a record
+var ErrNoProof = errors.New("no record to prove")
+
+// MerkleProof shows that a record is part of the bucket whose RootHash is known, see VerifyProof
+type MerkleProof struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	// Index is the position of the record in the bucket, in key order, and Size the number of records
+	Index int `json:"index"`
+	Size  int `json:"size"`
+	// Value is the SHA-256 of the stored, encrypted, value of the record
+	Value []byte `json:"value"`
+	// Path holds the hashes of the sibling subtrees from the leaf up to the root
+	Path [][]byte `json:"path"`
+}
+
+// merkleLeaf is the leaf hash of a record, valueHash being the SHA-256 of its stored value
+func merkleLeaf(key, valueHash []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0})
+	var n [binary.MaxVarintLen64]byte
+	h.Write(n[:binary.PutUvarint(n[:], uint64(len(key)))])
+	h.Write(key)
+	h.Write(valueHash)
+	return h.Sum(nil)
+}
+
+func merkleNode(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// merkleSplit is the largest power of two smaller than n
+func merkleSplit(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// merkleRoot is the root of the tree over leaves, built as the Merkle trees of certificate
+// transparency, RFC 6962
+func merkleRoot(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		h := sha256.Sum256(nil)
+		return h[:]
+	case 1:
+		return leaves[0]
+	}
+	k := merkleSplit(len(leaves))
+	return merkleNode(merkleRoot(leaves[:k]), merkleRoot(leaves[k:]))
+}
+
+// merklePath is the audit path of the leaf m
+func merklePath(m int, leaves [][]byte) [][]byte {
+	if len(leaves) <= 1 {
+		return nil
+	}
+	k := merkleSplit(len(leaves))
+	if m < k {
+		return append(merklePath(m, leaves[:k]), merkleRoot(leaves[k:]))
+	}
+	return append(merklePath(m-k, leaves[k:]), merkleRoot(leaves[:k]))
+}
+
+// merkleBucket holds the Merkle tree of every record bucket, as a nested bucket mapping each key to
+// the SHA-256 of its stored value, kept up to date by the writes. merkleRootBucket holds the roots
+// computed from them, by record bucket, which the writes changing a tree drop.
+var (
+	merkleBucket     = []byte(internalPrefix + "merkle")
+	merkleRootBucket = []byte(internalPrefix + "merkle_root")
+)
+
+// merkleTree returns the tree of bucket, seeding it from the records of the bucket the first time
+func merkleTree(tx *bbolt.Tx, bucket string) (*bbolt.Bucket, error) {
+	root, err := tx.CreateBucketIfNotExists(merkleBucket)
+	if err != nil {
+		return nil, err
+	}
+	if t := root.Bucket([]byte(bucket)); t != nil {
+		return t, nil
+	}
+	t, err := root.CreateBucket([]byte(bucket))
+	if err != nil {
+		return nil, err
+	}
+	bkt := tx.Bucket([]byte(bucket))
+	if bkt == nil {
+		return t, nil
+	}
+	return t, bkt.ForEach(func(k, v []byte) error {
+		if v == nil {
+			return nil
+		}
+		h := sha256.Sum256(v)
+		return t.Put(k, h[:])
+	})
+}
+
+// merkleSet keeps the tree of bucket up to date with the value stored under key, nil once deleted.
+// The root is computed again by the next RootHash.
+func (w *writeTx) merkleSet(bucket, key string, stored []byte) error {
+	t, err := merkleTree(w.tx, bucket)
+	if err != nil {
+		return err
+	}
+	if stored == nil {
+		err = t.Delete([]byte(key))
+	} else {
+		h := sha256.Sum256(stored)
+		err = t.Put([]byte(key), h[:])
+	}
+	if err != nil {
+		return err
+	}
+	if roots := w.tx.Bucket(merkleRootBucket); roots != nil {
+		return roots.Delete([]byte(bucket))
+	}
+	return nil
+}
+
+// dropMerkleTrees removes every tree and root, for the transactions rewriting the stored values
+// wholesale. The trees are seeded again by the next writes or RootHash.
+func dropMerkleTrees(tx *bbolt.Tx) error {
+	for _, name := range [][]byte{merkleBucket, merkleRootBucket} {
+		if tx.Bucket(name) == nil {
+			continue
+		}
+		if err := tx.DeleteBucket(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// merkleLeaves returns the leaf hashes of bucket as of tx in key order, and the index and value hash
+// of key among them, -1 when it has no record. They come from the tree of the bucket, or from its
+// records when it has none yet.
+func merkleLeaves(tx *bbolt.Tx, op, bucket, key string) (leaves [][]byte, index int, value []byte, err error) {
+	index = -1
+	bkt := tx.Bucket([]byte(bucket))
+	if bkt == nil {
+		return nil, -1, nil, bucketNotFound(op, bucket)
+	}
+	hash := func(v []byte) []byte {
+		h := sha256.Sum256(v)
+		return h[:]
+	}
+	if root := tx.Bucket(merkleBucket); root != nil && root.Bucket([]byte(bucket)) != nil {
+		bkt = root.Bucket([]byte(bucket))
+		hash = func(v []byte) []byte {
+			return append([]byte(nil), v...)
+		}
+	}
+	err = bkt.ForEach(func(k, v []byte) error {
+		if v == nil {
+			return nil
+		}
+		h := hash(v)
+		if index < 0 && key != "" && string(k) == key {
+			index, value = len(leaves), h
+		}
+		leaves = append(leaves, merkleLeaf(k, h))
+		return nil
+	})
+	return leaves, index, value, err
+}
+
+// RootHash returns the Merkle root of bucket, a tree over the keys and stored, encrypted, values of
+// its records in key order. A root recorded elsewhere, by an auditor or in a signed log, later shows
+// whether any record was added, changed or deleted since. The tree is kept up to date by the writes
+// and the root stored once computed; a value changed by writing to the bolt file directly no longer
+// matches its proof, see VerifyProof. Roots take no key to compute or check: anyone with a copy of the
+// file gets the same root, and Prove shows single records are in it.
+func (bl *BoltLocknut) RootHash(bucket string) (root []byte, err error) {
+	if err = bl.openDB(); err != nil {
+		return nil, err
+	}
+	defer bl.closeDB()
+
+	err = bl.db.view(func(tx *bbolt.Tx) error {
+		if tx.Bucket([]byte(bucket)) == nil {
+			return bucketNotFound("root hash", bucket)
+		}
+		if roots := tx.Bucket(merkleRootBucket); roots != nil {
+			root = append([]byte(nil), roots.Get([]byte(bucket))...)
+		}
+		return nil
+	})
+	if err != nil || len(root) > 0 {
+		return root, err
+	}
+
+	compute := func(tx *bbolt.Tx) error {
+		leaves, _, _, err := merkleLeaves(tx, "root hash", bucket, "")
+		root = merkleRoot(leaves)
+		return err
+	}
+	if bl.readOnly {
+		return root, bl.db.view(compute)
+	}
+	err = bl.updateQuiet(bl.db, func(tx *bbolt.Tx) error {
+		if _, err := merkleTree(tx, bucket); err != nil {
+			return err
+		}
+		if err := compute(tx); err != nil {
+			return err
+		}
+		roots, err := tx.CreateBucketIfNotExists(merkleRootBucket)
+		if err != nil {
+			return err
+		}
+		return roots.Put([]byte(bucket), root)
+	})
+	return root, err
+}
+
+// Prove returns the proof that the record stored under key is part of the bucket as of its current
+// RootHash, for VerifyProof. It is built from the tree of the bucket, without reading the records.
+func (bl *BoltLocknut) Prove(bucket, key string) (*MerkleProof, error) {
+	if err := bl.openDB(); err != nil {
+		return nil, err
+	}
+	defer bl.closeDB()
+
+	var leaves [][]byte
+	index, value := -1, []byte(nil)
+	err := bl.db.view(func(tx *bbolt.Tx) (err error) {
+		leaves, index, value, err = merkleLeaves(tx, "prove", bucket, key)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("%w: %s/%s", ErrNoProof, bucket, key)
+	}
+	return &MerkleProof{
+		Bucket: bucket,
+		Key:    key,
+		Index:  index,
+		Size:   len(leaves),
+		Value:  value,
+		Path:   merklePath(index, leaves),
+	}, nil
+}
+
+// VerifyProof reports whether p proves its record is part of the bucket whose root hash is root. It
+// needs neither the db nor its key, with stored the record as found in the file, such as by an
+// auditor reading a copy of it, it also checks the proof is about that very value.
+func VerifyProof(root []byte, p *MerkleProof, stored []byte) bool {
+	if p == nil || p.Index < 0 || p.Index >= p.Size {
+		return false
+	}
+	if stored != nil {
+		h := sha256.Sum256(stored)
+		if !bytes.Equal(h[:], p.Value) {
+			return false
+		}
+	}
+
+	// RFC 9162, 2.1.3.2
+	fn, sn := p.Index, p.Size-1
+	r := merkleLeaf([]byte(p.Key), p.Value)
+	for _, sibling := range p.Path {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			r = merkleNode(sibling, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = merkleNode(r, sibling)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(r, root)
+}
@@ -0,0 +1,112 @@
+package locknut
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestMerkle(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("merkle_test.db")
+
+	bl, err := NewBoltLocknut("merkle_test.db", ".", []byte("secret"), false, []string{"records"})
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	empty, err := bl.RootHash("records")
+	if err != nil {
+		t.Fatalf("RootHash: %s", err)
+	}
+
+	// every size checks the uneven trees too
+	var keys []string
+	for n := 1; n <= 13; n++ {
+		key := fmt.Sprintf("key-%02d", n)
+		keys = append(keys, key)
+		if err = bl.Save("records", key, n); err != nil {
+			t.Fatalf("Save: %s", err)
+		}
+		root, err := bl.RootHash("records")
+		if err != nil {
+			t.Fatalf("RootHash: %s", err)
+		}
+		for _, k := range keys {
+			p, err := bl.Prove("records", k)
+			if err != nil {
+				t.Fatalf("Prove: %s", err)
+			}
+			if !VerifyProof(root, p, storedValue(t, bl, "records", k)) {
+				t.Errorf("size %d: proof of %s does not verify", n, k)
+			}
+			if VerifyProof(empty, p, nil) {
+				t.Errorf("size %d: proof of %s verifies against the wrong root", n, k)
+			}
+		}
+	}
+
+	root, _ := bl.RootHash("records")
+	if again, _ := bl.RootHash("records"); !bytes.Equal(root, again) {
+		t.Error("expected the root to be stable")
+	}
+	p, _ := bl.Prove("records", "key-05")
+	if VerifyProof(root, p, []byte("another value")) {
+		t.Error("expected the proof not to verify another value")
+	}
+	forged := *p
+	forged.Key = "key-06"
+	if VerifyProof(root, &forged, nil) {
+		t.Error("expected the proof not to verify another key")
+	}
+
+	// the root seeded from the records is the one kept by the writes
+	err = bl.write(func(w *writeTx) error {
+		return dropMerkleTrees(w.tx)
+	})
+	if err != nil {
+		t.Fatalf("write: %s", err)
+	}
+	if seeded, _ := bl.RootHash("records"); !bytes.Equal(root, seeded) {
+		t.Error("expected the seeded root to match the kept one")
+	}
+
+	// a change made to the file directly keeps the root, but not the proof
+	err = bl.write(func(w *writeTx) error {
+		return w.tx.Bucket([]byte("records")).Put([]byte("key-05"), []byte("tampered"))
+	})
+	if err != nil {
+		t.Fatalf("write: %s", err)
+	}
+	if kept, _ := bl.RootHash("records"); !bytes.Equal(root, kept) {
+		t.Error("expected the stored root")
+	}
+	if p, _ = bl.Prove("records", "key-05"); VerifyProof(root, p, storedValue(t, bl, "records", "key-05")) {
+		t.Error("expected the tampered value not to verify")
+	}
+	if err = bl.Save("records", "key-05", 5); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if changed, _ := bl.RootHash("records"); bytes.Equal(root, changed) {
+		t.Error("expected the root to change")
+	}
+
+	// rotating the key rewrites every value, and the trees with them
+	if err = bl.RotateKey([]byte("another secret")); err != nil {
+		t.Fatalf("RotateKey: %s", err)
+	}
+	rotated, _ := bl.RootHash("records")
+	if bytes.Equal(root, rotated) {
+		t.Error("expected the root to change")
+	}
+	if p, _ = bl.Prove("records", "key-05"); !VerifyProof(rotated, p, storedValue(t, bl, "records", "key-05")) {
+		t.Error("expected the proof to verify after the rotation")
+	}
+	if err = bl.Delete("records", "key-05"); err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+	if _, err = bl.Prove("records", "key-05"); !errors.Is(err, ErrNoProof) {
+		t.Errorf("expected ErrNoProof, got %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package locknut
+
+import (
+	"time"
+)
+
+// Metrics receives measurements from a BoltLocknut configured WithMetrics. Methods are called inline
+// with the operations, so implementations must be cheap and safe for concurrent use. The prometheus
+// subpackage provides a ready made implementation.
+type Metrics interface {
+	// ObserveOp records an operation such as "save" or "get_one", how long it took and its error
+	ObserveOp(op string, d time.Duration, err error)
+	// ObserveCrypto records the time spent in a single "encrypt" or "decrypt" of a value
+	ObserveCrypto(op string, d time.Duration)
+	// ObserveCache records a lookup in the named in memory cache, such as the one kept by Flags
+	ObserveCache(cache string, hit bool)
+	// SetDBSize records the size of the db in bytes after each write
+	SetDBSize(bytes int64)
+}
+
+// WithMetrics reports operation counts and latencies, encryption time, cache hits and the db size to m
+func WithMetrics(m Metrics) Option {
+	return func(bl *BoltLocknut) {
+		bl.metrics = m
+	}
+}
+
+// observe reports an operation started at start, err points at the result of the operation so it
+// can be deferred
+func (bl *BoltLocknut) observe(op string, start time.Time, err *error) {
+	bl.lastOp.Store(bl.now().UnixNano())
+	if bl.metrics != nil {
+		bl.metrics.ObserveOp(op, time.Since(start), *err)
+	}
+}
+
+func (bl *BoltLocknut) observeCrypto(op string, start time.Time) {
+	if bl.metrics != nil {
+		bl.metrics.ObserveCrypto(op, time.Since(start))
+	}
+}
+
+func (bl *BoltLocknut) observeCache(cache string, hit bool) {
+	if bl.metrics != nil {
+		bl.metrics.ObserveCache(cache, hit)
+	}
+}
@@ -0,0 +1,72 @@
+package locknut
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingMetrics struct {
+	mu     sync.Mutex
+	ops    map[string]int
+	crypto map[string]int
+	size   int64
+}
+
+func (m *recordingMetrics) ObserveOp(op string, d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		op += " error"
+	}
+	m.ops[op]++
+}
+
+func (m *recordingMetrics) ObserveCrypto(op string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.crypto[op]++
+}
+
+func (m *recordingMetrics) ObserveCache(cache string, hit bool) {}
+
+func (m *recordingMetrics) SetDBSize(bytes int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.size = bytes
+}
+
+func TestMetrics(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("metrics_test.db")
+
+	m := &recordingMetrics{ops: map[string]int{}, crypto: map[string]int{}}
+	bl, err := NewBoltLocknut("metrics_test.db", ".", []byte("secret"), false, []string{"articles"}, WithMetrics(m))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+
+	if err = bl.Save("articles", "a1", Article{ID: "a1", Title: "one"}); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if _, err = bl.GetByPrefix("articles", "a"); err != nil {
+		t.Fatalf("GetByPrefix: %s", err)
+	}
+	bl.GetOne("articles", "")
+	if err = bl.Delete("articles", "a1"); err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+
+	for op, n := range map[string]int{"save": 1, "get_by_prefix": 1, "get_one error": 1, "delete": 1} {
+		if m.ops[op] != n {
+			t.Errorf("expected %d %s, got %d", n, op, m.ops[op])
+		}
+	}
+	if m.crypto["encrypt"] != 1 || m.crypto["decrypt"] != 1 {
+		t.Errorf("unexpected crypto observations %v", m.crypto)
+	}
+	if m.size == 0 {
+		t.Error("expected the db size to be reported")
+	}
+}
@@ -0,0 +1,157 @@
+package locknut
+
+import (
+	"fmt"
+	"go.etcd.io/bbolt"
+	"os"
+)
+
+// migrateBatchSize is the number of records written per transaction when rewriting a db file
+const migrateBatchSize = 1000
+
+// EncryptExisting encrypts the values of an existing unencrypted bbolt file in place, so it can be
+// opened with NewBoltLocknut and the same secret. buckets limits the buckets that are encrypted, nil
+// encrypts every bucket; other buckets are kept unchanged. The file is rewritten into a temporary copy
+// that replaces the original only once complete, an error leaves the original untouched.
+func EncryptExisting(dbPath string, secret []byte, buckets []string) error {
+	tmp := dbPath + ".encrypting"
+	if err := EncryptExistingTo(dbPath, tmp, secret, buckets); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dbPath)
+}
+
+// EncryptExistingTo writes an encrypted copy of the unencrypted bbolt file at srcPath to dstPath,
+// which must not exist yet. See EncryptExisting for the meaning of buckets.
+func EncryptExistingTo(srcPath, dstPath string, secret []byte, buckets []string) error {
+	key := deriveSecret(secret)
+	encrypt := func(v []byte) ([]byte, error) {
+		return Encrypt(v, key)
+	}
+
+	selected := make(map[string]bool)
+	for _, b := range buckets {
+		selected[b] = true
+	}
+
+	err := rewriteDB(srcPath, dstPath, func(bucket []byte) transformFunc {
+		if len(buckets) == 0 || selected[string(bucket)] {
+			return encrypt
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return writeCanaryTo(dstPath, key)
+}
+
+// transformFunc rewrites a single value while copying a db
+type transformFunc func(v []byte) ([]byte, error)
+
+// rewriteDB copies every bucket of srcPath into a new file at dstPath in batches, passing the values
+// of each top level bucket through the transform returned for it. A nil transform copies values as
+// they are, nested buckets are always copied as they are.
+func rewriteDB(srcPath, dstPath string, transformFor func(bucket []byte) transformFunc) error {
+	info, err := os.Stat(srcPath)
+	if err != nil {
+		return err
+	}
+	if _, err = os.Stat(dstPath); err == nil {
+		return fmt.Errorf("%s already exists", dstPath)
+	}
+
+	src, err := bbolt.Open(srcPath, 0600, &bbolt.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := bbolt.Open(dstPath, info.Mode().Perm(), nil)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	return src.View(func(tx *bbolt.Tx) error {
+		return copyDB(tx, dst, transformFor)
+	})
+}
+
+// copyDB copies every bucket of the snapshot tx into dst, see rewriteDB
+func copyDB(tx *bbolt.Tx, dst *bbolt.DB, transformFor func(bucket []byte) transformFunc) error {
+	return tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+		return copyBucket(b, dst, [][]byte{name}, transformFor(name))
+	})
+}
+
+type kv struct {
+	k, v []byte
+}
+
+// copyBucket copies src into the bucket at path in dst, committing every migrateBatchSize records
+func copyBucket(src *bbolt.Bucket, dst *bbolt.DB, path [][]byte, transform transformFunc) error {
+	var batch []kv
+	flush := func() error {
+		return dst.Update(func(tx *bbolt.Tx) error {
+			bkt, err := tx.CreateBucketIfNotExists(path[0])
+			if err != nil {
+				return err
+			}
+			for _, name := range path[1:] {
+				if bkt, err = bkt.CreateBucketIfNotExists(name); err != nil {
+					return err
+				}
+			}
+			if err = bkt.SetSequence(src.Sequence()); err != nil {
+				return err
+			}
+			for _, r := range batch {
+				if err = bkt.Put(r.k, r.v); err != nil {
+					return err
+				}
+			}
+			batch = batch[:0]
+			return nil
+		})
+	}
+
+	var nested [][]byte
+	err := src.ForEach(func(k, v []byte) error {
+		if v == nil {
+			nested = append(nested, k)
+			return nil
+		}
+
+		value := make([]byte, len(v))
+		copy(value, v)
+		if transform != nil {
+			var err error
+			if value, err = transform(value); err != nil {
+				return fmt.Errorf("%s/%s: %v", path[len(path)-1], k, err)
+			}
+		}
+
+		batch = append(batch, kv{k: append([]byte(nil), k...), v: value})
+		if len(batch) >= migrateBatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// always flush so empty buckets and their sequence are created too
+	if err = flush(); err != nil {
+		return err
+	}
+
+	for _, name := range nested {
+		sub := append(append([][]byte(nil), path...), name)
+		if err = copyBucket(src.Bucket(name), dst, sub, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package locknut
+
+import (
+	"go.etcd.io/bbolt"
+	"os"
+	"testing"
+)
+
+func TestEncryptExisting(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("migrate_test.db")
+
+	db, err := bbolt.Open("migrate_test.db", 0600, nil)
+	if err != nil {
+		t.Fatalf("bbolt.Open: %s", err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		pii, err := tx.CreateBucket([]byte("pii"))
+		if err != nil {
+			return err
+		}
+		if err = pii.Put([]byte("taylor"), []byte(`{"Name":"taylor"}`)); err != nil {
+			return err
+		}
+		other, err := tx.CreateBucket([]byte("other"))
+		if err != nil {
+			return err
+		}
+		return other.Put([]byte("plain"), []byte("kept as is"))
+	})
+	db.Close()
+	if err != nil {
+		t.Fatalf("seed: %s", err)
+	}
+
+	if err = EncryptExisting("migrate_test.db", []byte("secret"), []string{"pii"}); err != nil {
+		t.Fatalf("EncryptExisting: %s", err)
+	}
+
+	bl, err := NewBoltLocknut("migrate_test.db", ".", []byte("secret"), false, nil)
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	dec, err := bl.GetOne("pii", "taylor")
+	if err != nil {
+		t.Fatalf("GetOne: %s", err)
+	}
+	if string(dec) != `{"Name":"taylor"}` {
+		t.Errorf("unexpected decrypted value %s", dec)
+	}
+
+	if err = bl.openDB(); err != nil {
+		t.Fatalf("openDB: %s", err)
+	}
+	err = bl.db.view(func(tx *bbolt.Tx) error {
+		if v := tx.Bucket([]byte("other")).Get([]byte("plain")); string(v) != "kept as is" {
+			t.Errorf("unselected bucket was changed: %q", v)
+		}
+		return nil
+	})
+	bl.closeDB()
+	if err != nil {
+		t.Fatalf("view: %s", err)
+	}
+}
@@ -0,0 +1,7 @@
+package locknut
+
+import "syscall"
+
+// bulkMmapFlags prefaults the map of ExportBulk, so the export reads the file sequentially up front
+// rather than a page fault at a time
+const bulkMmapFlags = syscall.MAP_POPULATE
@@ -0,0 +1,6 @@
+//go:build !linux
+
+package locknut
+
+// bulkMmapFlags are the mmap flags of ExportBulk, none where MAP_POPULATE does not exist
+const bulkMmapFlags = 0
@@ -0,0 +1,202 @@
+package locknut
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"go.etcd.io/bbolt"
+	"math"
+	"time"
+)
+
+// Optimizer rewrites, a few at a time while the db is idle, the records whose stored form no longer
+// matches the configuration of the BoltLocknut: records written before WithPadding was set or with
+// other padding sizes, records written through other WithTransformers stages, and records of buckets
+// given to WithDeterministic since, or no longer. The value of a record is never changed, only how it
+// is stored. Records with encrypted fields, see fields.go, are left as they are.
+type Optimizer struct {
+	bl *BoltLocknut
+
+	// IdleAfter is how long the db must have served no Get, Save or Delete for Run to step, a second
+	// by default
+	IdleAfter time.Duration
+	// Budget bounds the bytes of records read and written per step, 1MiB by default, 0 for no bound
+	Budget int
+
+	// bucket and key are where the next step starts, key being the last key looked at
+	bucket string
+	key    []byte
+}
+
+// NewOptimizer returns an Optimizer of bl
+func NewOptimizer(bl *BoltLocknut) *Optimizer {
+	return &Optimizer{bl: bl, IdleAfter: time.Second, Budget: 1 << 20}
+}
+
+// rewrite is a record to store again, old being its stored value when it was read
+type rewrite struct {
+	bucket string
+	key    []byte
+	old    []byte
+	stored []byte
+}
+
+// Step looks at records from where the last step stopped until it went through Budget bytes, and
+// rewrites the outdated ones in a single transaction. It returns the number of records rewritten and
+// whether it reached the end of the last bucket, in which case the next step starts over.
+func (o *Optimizer) Step() (rewritten int, wrapped bool, err error) {
+	bl := o.bl
+	if bl.secret == nil && bl.public == nil {
+		return 0, true, nil
+	}
+	if err = bl.openDB(); err != nil {
+		return 0, false, err
+	}
+	defer bl.closeDB()
+
+	var todo []rewrite
+	err = bl.db.view(func(tx *bbolt.Tx) error {
+		spent := 0
+		c := tx.Cursor()
+		name, _ := c.Seek([]byte(o.bucket))
+		for ; name != nil; name, _ = c.Next() {
+			bkt := tx.Bucket(name)
+			if isInternalBucket(name) || bkt == nil {
+				continue
+			}
+			if string(name) != o.bucket {
+				o.bucket, o.key = string(name), nil
+			}
+
+			bc := bkt.Cursor()
+			k, v := bc.First()
+			if o.key != nil {
+				if k, v = bc.Seek(o.key); bytes.Equal(k, o.key) {
+					k, v = bc.Next()
+				}
+			}
+			for ; k != nil; k, v = bc.Next() {
+				if o.Budget > 0 && spent >= o.Budget {
+					return nil
+				}
+				o.key = append(o.key[:0], k...)
+				if v == nil {
+					continue
+				}
+				spent += len(v)
+				stored, err := bl.reseal(o.bucket, v)
+				if err != nil {
+					return err
+				}
+				if stored != nil {
+					spent += len(stored)
+					todo = append(todo, rewrite{bucket: o.bucket, key: append([]byte(nil), k...), old: append([]byte(nil), v...), stored: stored})
+				}
+			}
+		}
+		o.bucket, o.key, wrapped = "", nil, true
+		return nil
+	})
+	if err != nil || len(todo) == 0 {
+		return 0, wrapped, err
+	}
+
+	err = bl.write(func(w *writeTx) error {
+		for _, r := range todo {
+			bkt := w.tx.Bucket([]byte(r.bucket))
+			// records written since they were read are left for the next pass
+			if bkt == nil || !bytes.Equal(bkt.Get(r.key), r.old) {
+				continue
+			}
+			if err := bkt.Put(r.key, r.stored); err != nil {
+				return err
+			}
+			if err := w.merkleSet(r.bucket, string(r.key), r.stored); err != nil {
+				return err
+			}
+			w.deltas[r.bucket] = w.deltas[r.bucket].add(w.bl.recordStats(r.bucket, r.stored).sub(w.bl.recordStats(r.bucket, r.old)))
+			err := bl.logChange(w.tx, Change{Op: OpPut, Bucket: r.bucket, Key: string(r.key), Value: r.stored})
+			if err != nil {
+				return err
+			}
+			rewritten++
+		}
+		return nil
+	})
+	return rewritten, wrapped, err
+}
+
+// reseal returns the stored value of a record of bucket as the current configuration would write it,
+// or nil when stored is already that
+func (bl *BoltLocknut) reseal(bucket string, stored []byte) ([]byte, error) {
+	if isFieldSealed(stored) || (bl.public != nil && bl.private == nil) {
+		return nil, nil
+	}
+	var raw []byte
+	var legacy bool
+	var err error
+	ct, framed := cutFrame(stored)
+	if bl.public != nil {
+		raw, err = bl.unseal(ct)
+	} else {
+		raw, legacy, err = bl.openCiphertext(bucket, append([]byte(nil), ct...))
+	}
+	if err != nil {
+		return nil, &RecordError{Op: "optimize", Bucket: bucket, Kind: ErrDecrypt, Err: err}
+	}
+	value, err := bl.restore(raw, framed)
+	if err != nil {
+		return nil, err
+	}
+
+	// a frame is current when it went through the stages of the pipeline and is padded to the sizes
+	outdated := legacy || framed != (len(bl.transformers) > 0 || len(bl.padSizes) > 0)
+	if framed && !outdated {
+		names, _, size, err := parseFrame(raw)
+		if err != nil {
+			return nil, err
+		}
+		outdated = !bl.currentStages(names) || !bytes.Equal(raw, bl.pad(raw[:size]))
+	}
+	if !outdated && bl.public == nil {
+		det, err := encryptDeterministic(raw, bl.keyFor(bucket))
+		if err != nil {
+			return nil, err
+		}
+		outdated = bytes.Equal(ct, det) != bl.isDeterministic(bucket)
+	}
+	if !outdated {
+		return nil, nil
+	}
+	return bl.sealFor(bucket, value)
+}
+
+// idleFor returns how long ago the last Get, Save or Delete finished
+func (bl *BoltLocknut) idleFor() time.Duration {
+	last := bl.lastOp.Load()
+	if last == 0 {
+		return time.Duration(math.MaxInt64)
+	}
+	return bl.now().Sub(time.Unix(0, last))
+}
+
+// Run steps every interval while the db is idle, until ctx is done. Steps that find the db under
+// maintenance are skipped.
+func (o *Optimizer) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		if o.bl.idleFor() < o.IdleAfter {
+			continue
+		}
+		if _, _, err := o.Step(); err != nil && !errors.Is(err, ErrMaintenance) {
+			return err
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package locknut
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestOptimizer(t *testing.T) {
+	skipFIPS(t, "deterministic encryption")
+	defer os.Remove("optimizer_test.db")
+
+	bl, err := NewBoltLocknut("optimizer_test.db", ".", []byte("secret"), false, []string{"emails", "notes"})
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	for i := 0; i < 20; i++ {
+		if err = bl.Save("emails", fmt.Sprintf("%02d", i), fmt.Sprintf("user%d@example.com", i%5)); err != nil {
+			t.Fatalf("Save: %s", err)
+		}
+		if err = bl.Save("notes", fmt.Sprintf("%02d", i), "note"); err != nil {
+			t.Fatalf("Save: %s", err)
+		}
+	}
+
+	bl, err = NewBoltLocknut("optimizer_test.db", ".", []byte("secret"), false, []string{"emails", "notes"},
+		WithPadding(128), WithDeterministic("emails"), WithChangelog())
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	o := NewOptimizer(bl)
+	o.Budget = 500
+
+	total, steps := 0, 0
+	for wrapped := false; !wrapped; steps++ {
+		var n int
+		if n, wrapped, err = o.Step(); err != nil {
+			t.Fatalf("Step: %s", err)
+		}
+		total += n
+	}
+	if total != 40 || steps < 2 {
+		t.Errorf("expected 40 records rewritten over several steps, got %d in %d", total, steps)
+	}
+
+	overhead := len(storedValue(t, bl, "notes", "00")) - 128
+	if overhead != len(frameMagic)+28 {
+		t.Errorf("expected the notes to be padded, got an overhead of %d", overhead)
+	}
+	if keys, err := bl.FindEqual("emails", "user1@example.com"); err != nil || len(keys) != 4 {
+		t.Errorf("expected 4 emails found, got %v %v", keys, err)
+	}
+	if v, err := bl.Get("emails", "07"); err != nil || string(v) != `"user2@example.com"` {
+		t.Errorf("unexpected value %s %v", v, err)
+	}
+	if changes, err := bl.Changes(0, 0); err != nil || len(changes) != 40 {
+		t.Errorf("expected the rewrites in the changelog, got %d %v", len(changes), err)
+	}
+
+	// up to date records are left alone
+	if n, wrapped, err := NewOptimizer(bl).Step(); n != 0 || !wrapped || err != nil {
+		t.Errorf("expected nothing to do, got %d %v %v", n, wrapped, err)
+	}
+
+	// records of a bucket no longer deterministic go back to random nonces
+	bl, err = NewBoltLocknut("optimizer_test.db", ".", []byte("secret"), false, []string{"emails", "notes"},
+		WithPadding(128))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	o = NewOptimizer(bl)
+	o.IdleAfter = 0
+	if err = o.Run(ctx, 10*time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected Run to stop with ctx, got %v", err)
+	}
+	if a, b := storedValue(t, bl, "emails", "01"), storedValue(t, bl, "emails", "06"); string(a) == string(b) {
+		t.Error("expected the emails to be encrypted with random nonces again")
+	}
+}
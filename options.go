@@ -0,0 +1,75 @@
+package locknut
+
+import (
+	"go.etcd.io/bbolt"
+	"os"
+	"time"
+)
+
+// ErrTimeout is returned by NewBoltLocknut and every operation when the db file stays locked by
+// another process for longer than the timeout set with WithOpenTimeout
+var ErrTimeout = bbolt.ErrTimeout
+
+// WithOpenTimeout makes opening the db fail with ErrTimeout after d instead of waiting indefinitely
+// while another process holds the file lock
+func WithOpenTimeout(d time.Duration) Option {
+	return func(bl *BoltLocknut) {
+		bl.boltOptions.Timeout = d
+	}
+}
+
+// WithNoSync skips the fsync after each commit. It speeds up bulk loads, but a crash can lose or
+// corrupt the db, so it is only meant for data that can be loaded again.
+func WithNoSync() Option {
+	return func(bl *BoltLocknut) {
+		bl.boltOptions.NoSync = true
+	}
+}
+
+// WithNoFreelistSync keeps the freelist out of the db file, making commits faster on large dbs at
+// the cost of a scan of the file when it is opened
+func WithNoFreelistSync() Option {
+	return func(bl *BoltLocknut) {
+		bl.boltOptions.NoFreelistSync = true
+	}
+}
+
+// WithInitialMmapSize maps size bytes of the db file up front, so read transactions do not block
+// writes that grow the file until it exceeds size
+func WithInitialMmapSize(size int) Option {
+	return func(bl *BoltLocknut) {
+		bl.boltOptions.InitialMmapSize = size
+	}
+}
+
+// WithPageSize sets the page size of a db file created by NewBoltLocknut, the OS page size by
+// default. It has no effect on existing files.
+func WithPageSize(size int) Option {
+	return func(bl *BoltLocknut) {
+		bl.boltOptions.PageSize = size
+	}
+}
+
+// WithFileMode sets the permissions a new db file, and its stats db, see WithStatsDB, are created with,
+// 0600 by default
+func WithFileMode(mode os.FileMode) Option {
+	return func(bl *BoltLocknut) {
+		bl.fileMode = mode
+	}
+}
+
+// WithCreateDir creates the path passed to NewBoltLocknut, and any missing parents, with perm
+// instead of requiring it to exist
+func WithCreateDir(perm os.FileMode) Option {
+	return func(bl *BoltLocknut) {
+		bl.dirMode = perm
+	}
+}
+
+// WithStrictPermissions makes NewBoltLocknut fail with ErrPermissions when an existing db file grants
+// any permission beyond the file mode, 0600 unless WithFileMode is set
+func WithStrictPermissions() Option {
+	return func(bl *BoltLocknut) {
+		bl.strictMode = true
+	}
+}
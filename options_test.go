@@ -0,0 +1,85 @@
+package locknut
+
+import (
+	"errors"
+	"go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOpenTimeout(t *testing.T) {
+	defer os.Remove("options_test.db")
+
+	db, err := bbolt.Open("options_test.db", 0600, nil)
+	if err != nil {
+		t.Fatalf("bbolt.Open: %s", err)
+	}
+	_, err = NewBoltLocknut("options_test.db", ".", []byte("secret"), false, nil, WithOpenTimeout(50*time.Millisecond))
+	db.Close()
+	if err != ErrTimeout {
+		t.Errorf("expected ErrTimeout while the file is locked, got %v", err)
+	}
+}
+
+func TestPageSize(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("options_page_test.db")
+
+	bl, err := NewBoltLocknut("options_page_test.db", ".", []byte("secret"), true, []string{"items"},
+		WithPageSize(16384), WithNoSync(), WithNoFreelistSync(), WithInitialMmapSize(1<<20))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	defer bl.SetBatchMode(false)
+	if err = bl.Save("items", "a", "value"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if err = bl.openDB(); err != nil {
+		t.Fatalf("openDB: %s", err)
+	}
+	if size := bl.db.Info().PageSize; size != 16384 {
+		t.Errorf("expected a page size of 16384, got %d", size)
+	}
+	if !bl.db.NoSync {
+		t.Errorf("NoSync was not passed to bbolt")
+	}
+}
+
+func TestFileMode(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.RemoveAll("options_dir_test")
+
+	dir := filepath.Join("options_dir_test", "nested")
+	if _, err := NewBoltLocknut("mode.db", dir, []byte("secret"), false, nil); err != ErrPathInvalid {
+		t.Errorf("expected ErrPathInvalid for a missing dir, got %v", err)
+	}
+
+	statsPath := filepath.Join(dir, "mode.stats.db")
+	_, err := NewBoltLocknut("mode.db", dir, []byte("secret"), false, nil, WithCreateDir(0700), WithFileMode(0640),
+		WithStatsDB(statsPath))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	path := filepath.Join(dir, "mode.db")
+	for _, p := range []string{path, statsPath} {
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatalf("Stat: %s", err)
+		}
+		if info.Mode().Perm() != 0640 {
+			t.Errorf("%s: expected mode 0640, got %v", p, info.Mode().Perm())
+		}
+	}
+
+	if _, err = NewBoltLocknut("mode.db", dir, []byte("secret"), false, nil, WithFileMode(0640), WithStrictPermissions()); err != nil {
+		t.Errorf("NewBoltLocknut with matching permissions: %s", err)
+	}
+	if err = os.Chmod(path, 0644); err != nil {
+		t.Fatalf("Chmod: %s", err)
+	}
+	if _, err = NewBoltLocknut("mode.db", dir, []byte("secret"), false, nil, WithFileMode(0640), WithStrictPermissions()); !errors.Is(err, ErrPermissions) {
+		t.Errorf("expected ErrPermissions, got %v", err)
+	}
+}
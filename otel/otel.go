@@ -0,0 +1,44 @@
+// Package otel traces BoltLocknut operations with OpenTelemetry.
+//
+//	bl, err := locknut.NewBoltLocknut(name, path, secret, false, buckets, otel.WithTracerProvider(tp))
+//	v, err := bl.GetOneContext(r.Context(), "users", id)
+package otel
+
+import (
+	"context"
+	"github.com/taybart/locknut"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName identifies the spans created by this package
+const instrumentationName = "github.com/taybart/locknut"
+
+type tracer struct {
+	t trace.Tracer
+}
+
+// NewTracer returns a locknut.Tracer creating spans with tp
+func NewTracer(tp trace.TracerProvider) locknut.Tracer {
+	return &tracer{t: tp.Tracer(instrumentationName)}
+}
+
+// WithTracerProvider is the option tracing a BoltLocknut with tp
+func WithTracerProvider(tp trace.TracerProvider) locknut.Option {
+	return locknut.WithTracer(NewTracer(tp))
+}
+
+// Start implements locknut.Tracer. Keys and values are never recorded, only the bucket.
+func (t *tracer) Start(ctx context.Context, name, bucket string) (context.Context, func(error)) {
+	ctx, span := t.t.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(attribute.String("locknut.bucket", bucket)))
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
@@ -0,0 +1,60 @@
+package otel
+
+import (
+	"context"
+	"github.com/taybart/locknut"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"os"
+	"testing"
+)
+
+func TestTracing(t *testing.T) {
+	if locknut.FIPSBuild() {
+		t.Skip("a short secret is not allowed in FIPS mode")
+	}
+	defer os.Remove("otel_test.db")
+
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+
+	bl, err := locknut.NewBoltLocknut("otel_test.db", ".", []byte("secret"), false, []string{"b"}, WithTracerProvider(tp))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "request")
+	if err = bl.SaveContext(ctx, "b", "k1", map[string]string{"a": "b"}); err != nil {
+		t.Fatalf("SaveContext: %s", err)
+	}
+	if _, err = bl.GetOneContext(ctx, "b", "k1"); err != nil {
+		t.Fatalf("GetOneContext: %s", err)
+	}
+	if _, err = bl.GetByPrefix("missing", ""); err == nil {
+		t.Fatal("expected an error for a missing bucket")
+	}
+	parent.End()
+
+	spans := rec.Ended()
+	byName := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range spans {
+		byName[s.Name()] = s
+	}
+
+	save, enc := byName["locknut.Save"], byName["locknut.encrypt"]
+	get, dec := byName["locknut.GetOne"], byName["locknut.decrypt"]
+	if save == nil || enc == nil || get == nil || dec == nil {
+		t.Fatalf("missing spans, got %d", len(spans))
+	}
+	if save.Parent().SpanID() != parent.SpanContext().SpanID() || enc.Parent().SpanID() != save.SpanContext().SpanID() {
+		t.Error("expected encrypt under Save under the request span")
+	}
+	if dec.Parent().SpanID() != get.SpanContext().SpanID() {
+		t.Error("expected decrypt under GetOne")
+	}
+
+	failed := byName["locknut.GetByPrefix"]
+	if failed == nil || failed.Status().Code.String() != "Error" || failed.Parent().IsValid() {
+		t.Errorf("expected a failed root GetByPrefix span, got %v", failed)
+	}
+}
@@ -0,0 +1,45 @@
+package locknut
+
+import (
+	"sort"
+)
+
+// WithPadding pads values to fixed sizes before they are encrypted, so the size of a stored record
+// does not tell what kind of record it is, for attackers who can read the file. A value is padded to
+// the smallest of sizes it fits in, and values larger than every size to a multiple of the largest:
+// WithPadding(256, 1024, 4096) stores values of up to 256 bytes, less the header of the frame, in
+// 256 bytes, and a value of 5000 bytes in 8192. Encrypted fields, see fields.go, are padded one by
+// one. Records written before padding was enabled are read as they are.
+func WithPadding(sizes ...int) Option {
+	return func(bl *BoltLocknut) {
+		bl.padSizes = bl.padSizes[:0]
+		for _, s := range sizes {
+			if s > 0 {
+				bl.padSizes = append(bl.padSizes, s)
+			}
+		}
+		sort.Ints(bl.padSizes)
+	}
+}
+
+// pad returns frame padded with zeros to the padding sizes, or frame when there are none
+func (bl *BoltLocknut) pad(frame []byte) []byte {
+	if len(bl.padSizes) == 0 {
+		return frame
+	}
+	size := 0
+	for _, s := range bl.padSizes {
+		if len(frame) <= s {
+			size = s
+			break
+		}
+	}
+	if size == 0 {
+		largest := bl.padSizes[len(bl.padSizes)-1]
+		size = (len(frame) + largest - 1) / largest * largest
+	}
+
+	out := make([]byte, size)
+	copy(out, frame)
+	return out
+}
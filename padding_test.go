@@ -0,0 +1,113 @@
+package locknut
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestPadding(t *testing.T) {
+	skipFIPS(t, "deterministic encryption")
+	defer os.Remove("padding_test.db")
+
+	// a record written before padding is enabled
+	bl, err := NewBoltLocknut("padding_test.db", ".", []byte("secret"), false, []string{"notes"})
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if err = bl.SaveBytes("notes", "old", []byte("unpadded")); err != nil {
+		t.Fatalf("SaveBytes: %s", err)
+	}
+
+	bl, err = NewBoltLocknut("padding_test.db", ".", []byte("secret"), false, []string{"notes"},
+		WithPadding(1024, 256), WithDeterministic("notes"))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	values := map[string]string{"a": "x", "b": "a somewhat longer note", "c": string(make([]byte, 300)), "d": string(make([]byte, 2000))}
+	for key, v := range values {
+		if err = bl.SaveBytes("notes", key, []byte(v)); err != nil {
+			t.Fatalf("SaveBytes: %s", err)
+		}
+	}
+
+	// the nonce and tag of AES-GCM come on top of the padded size
+	overhead := len(storedValue(t, bl, "notes", "a")) - 256
+	for key, size := range map[string]int{"a": 256, "b": 256, "c": 1024, "d": 2048} {
+		if got := len(storedValue(t, bl, "notes", key)) - overhead; got != size {
+			t.Errorf("%s: expected %d bytes, got %d", key, size, got)
+		}
+	}
+
+	values["old"] = "unpadded"
+	for key, v := range values {
+		got, err := bl.Get("notes", key)
+		if err != nil || string(got) != v {
+			t.Errorf("%s: unexpected value of %d bytes, %v", key, len(got), err)
+		}
+	}
+
+	if err = bl.Save("notes", "e", "find me"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if keys, err := bl.FindEqual("notes", "find me"); err != nil || len(keys) != 1 || keys[0] != "e" {
+		t.Errorf("FindEqual: unexpected %v %v", keys, err)
+	}
+
+	if err = bl.RotateKey([]byte("new secret")); err != nil {
+		t.Fatalf("RotateKey: %s", err)
+	}
+	if got := len(storedValue(t, bl, "notes", "b")) - overhead; got != 256 {
+		t.Errorf("expected the rotated record to stay padded, got %d bytes", got)
+	}
+	if got, err := bl.Get("notes", "b"); err != nil || string(got) != values["b"] {
+		t.Errorf("unexpected value %q %v", got, err)
+	}
+}
+
+func TestFrame(t *testing.T) {
+	bl := &BoltLocknut{padSizes: []int{64}}
+	frame, framed, err := bl.prepare([]byte("value"))
+	if err != nil || !framed || len(frame) != 64 {
+		t.Fatalf("expected a frame of 64 bytes, got %d %v %v", len(frame), framed, err)
+	}
+	if v, err := bl.restore(frame, true); err != nil || string(v) != "value" {
+		t.Errorf("unexpected %q %v", v, err)
+	}
+	// only values marked as frames are read as frames
+	if v, err := bl.restore(frame, false); err != nil || !bytes.Equal(v, frame) {
+		t.Errorf("unexpected %q %v", v, err)
+	}
+	frame[2] = 0xff
+	if _, err := bl.restore(frame, true); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("expected ErrCorrupt, got %v", err)
+	}
+}
+
+func TestFrameLookalike(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("frame_test.db")
+
+	// values starting the way frames and their marks do are saved like any other
+	values := [][]byte{
+		append([]byte{frameV1, 0, 0, 0, 0, 1}, "x"...),
+		append(append([]byte(nil), frameMagic...), 1, 0, 0, 0, 0, 0),
+		[]byte("\x00locknut pad\x00\x00\x00\x00\x01xy"),
+	}
+	for _, opts := range [][]Option{nil, {WithPadding(64)}} {
+		bl, err := NewBoltLocknut("frame_test.db", ".", []byte("secret"), false, []string{"notes"}, opts...)
+		if err != nil {
+			t.Fatalf("NewBoltLocknut: %s", err)
+		}
+		for i, v := range values {
+			if err = bl.SaveBytes("notes", fmt.Sprint(i), v); err != nil {
+				t.Fatalf("SaveBytes: %s", err)
+			}
+			if got, err := bl.Get("notes", fmt.Sprint(i)); err != nil || !bytes.Equal(got, v) {
+				t.Errorf("%d: expected %q, got %q %v", i, v, got, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Package prometheus exports the metrics of a BoltLocknut to Prometheus.
+//
+//	m := prometheus.NewMetrics("myapp")
+//	prom.MustRegister(m)
+//	bl, err := locknut.NewBoltLocknut(name, path, secret, false, buckets, locknut.WithMetrics(m))
+package prometheus
+
+import (
+	prom "github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+// Metrics implements locknut.Metrics and prometheus.Collector
+type Metrics struct {
+	ops     *prom.CounterVec
+	latency *prom.HistogramVec
+	crypto  *prom.HistogramVec
+	cache   *prom.CounterVec
+	size    prom.Gauge
+}
+
+// NewMetrics returns the collectors, with names prefixed by namespace when it is not empty:
+//
+//	locknut_operations_total{op,result}          operations by result, "ok" or "error"
+//	locknut_operation_duration_seconds{op}       operation latency
+//	locknut_crypto_duration_seconds{op}          time spent encrypting and decrypting single values
+//	locknut_cache_requests_total{cache,result}   cache lookups by result, "hit" or "miss"
+//	locknut_db_size_bytes                        size of the db after the last write
+func NewMetrics(namespace string) *Metrics {
+	return &Metrics{
+		ops: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "locknut",
+			Name:      "operations_total",
+			Help:      "Number of locknut operations by result.",
+		}, []string{"op", "result"}),
+		latency: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "locknut",
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of locknut operations.",
+			Buckets:   prom.ExponentialBuckets(0.0001, 2, 16),
+		}, []string{"op"}),
+		crypto: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "locknut",
+			Name:      "crypto_duration_seconds",
+			Help:      "Time spent encrypting or decrypting a single value.",
+			Buckets:   prom.ExponentialBuckets(0.000001, 2, 16),
+		}, []string{"op"}),
+		cache: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "locknut",
+			Name:      "cache_requests_total",
+			Help:      "Cache lookups by result.",
+		}, []string{"cache", "result"}),
+		size: prom.NewGauge(prom.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "locknut",
+			Name:      "db_size_bytes",
+			Help:      "Size of the locknut db after the last write.",
+		}),
+	}
+}
+
+// ObserveOp implements locknut.Metrics
+func (m *Metrics) ObserveOp(op string, d time.Duration, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	m.ops.WithLabelValues(op, result).Inc()
+	m.latency.WithLabelValues(op).Observe(d.Seconds())
+}
+
+// ObserveCrypto implements locknut.Metrics
+func (m *Metrics) ObserveCrypto(op string, d time.Duration) {
+	m.crypto.WithLabelValues(op).Observe(d.Seconds())
+}
+
+// ObserveCache implements locknut.Metrics
+func (m *Metrics) ObserveCache(cache string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.cache.WithLabelValues(cache, result).Inc()
+}
+
+// SetDBSize implements locknut.Metrics
+func (m *Metrics) SetDBSize(bytes int64) {
+	m.size.Set(float64(bytes))
+}
+
+// Describe implements prometheus.Collector
+func (m *Metrics) Describe(ch chan<- *prom.Desc) {
+	m.ops.Describe(ch)
+	m.latency.Describe(ch)
+	m.crypto.Describe(ch)
+	m.cache.Describe(ch)
+	m.size.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (m *Metrics) Collect(ch chan<- prom.Metric) {
+	m.ops.Collect(ch)
+	m.latency.Collect(ch)
+	m.crypto.Collect(ch)
+	m.cache.Collect(ch)
+	m.size.Collect(ch)
+}
@@ -0,0 +1,59 @@
+package prometheus
+
+import (
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/taybart/locknut"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	if locknut.FIPSBuild() {
+		t.Skip("a short secret is not allowed in FIPS mode")
+	}
+	defer os.Remove("prometheus_test.db")
+
+	m := NewMetrics("")
+	bl, err := locknut.NewBoltLocknut("prometheus_test.db", ".", []byte("secret"), false, []string{"b"}, locknut.WithMetrics(m))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+
+	if err = bl.SaveBytes("b", "k", []byte("v")); err != nil {
+		t.Fatalf("SaveBytes: %s", err)
+	}
+	if _, err = bl.GetOne("b", "k"); err != nil {
+		t.Fatalf("GetOne: %s", err)
+	}
+	bl.Get("missing", "k")
+
+	if v := testutil.ToFloat64(m.ops.WithLabelValues("save", "ok")); v != 1 {
+		t.Errorf("expected 1 save, got %v", v)
+	}
+	if v := testutil.ToFloat64(m.ops.WithLabelValues("get", "error")); v != 1 {
+		t.Errorf("expected 1 failed get, got %v", v)
+	}
+	if v := testutil.ToFloat64(m.size); v <= 0 {
+		t.Errorf("expected a db size, got %v", v)
+	}
+
+	expected := `
+# HELP locknut_cache_requests_total Cache lookups by result.
+# TYPE locknut_cache_requests_total counter
+locknut_cache_requests_total{cache="flags",result="hit"} 1
+locknut_cache_requests_total{cache="flags",result="miss"} 1
+`
+	flags, err := locknut.NewFlags(bl, "flags")
+	if err != nil {
+		t.Fatalf("NewFlags: %s", err)
+	}
+	flags.Enabled("a", "taylor")
+	flags.Enabled("a", "taylor")
+	if err = testutil.CollectAndCompare(m, strings.NewReader(expected), "locknut_cache_requests_total"); err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(m, "locknut_crypto_duration_seconds"); n != 2 {
+		t.Errorf("expected encrypt and decrypt series, got %d", n)
+	}
+}
@@ -0,0 +1,316 @@
+package locknut
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/taybart/log"
+	"go.etcd.io/bbolt"
+	"strings"
+	"time"
+)
+
+// ErrBadQuery is returned by Run for conditions it cannot evaluate
+var ErrBadQuery = errors.New("bad query")
+
+// The operators of Where
+var queryOps = map[string]bool{
+	"=": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true, "contains": true, "in": true,
+}
+
+// condition is a Where of a Query, value decoded the way json decodes records
+type condition struct {
+	field string
+	path  []string
+	op    string
+	value interface{}
+}
+
+// Query filters the json records of a bucket by their fields, decrypting them in the process that
+// holds the secret rather than handing every record to the caller. It is built by Query and its
+// conditions are added with Where, every one of them must hold for a record to be returned.
+type Query struct {
+	bl     *BoltLocknut
+	bucket string
+	conds  []condition
+	limit  int
+	err    error
+}
+
+// Query returns a query over the records of bucket
+func (bl *BoltLocknut) Query(bucket string) *Query {
+	return &Query{bl: bl, bucket: bucket}
+}
+
+// Query is BoltLocknut.Query, given PermRead on bucket. The check happens in Run.
+func (s *ScopedLocknut) Query(bucket string) *Query {
+	q := s.bl.Query(bucket)
+	q.err = s.check("query", bucket, PermRead)
+	return q
+}
+
+// Where adds a condition on field, a dotted path into the record, compared to value with op: one of
+// =, !=, <, <=, >, >=, contains and in. Numbers compare as numbers and strings as strings, values of
+// other types only equal. A field holding an array matches when any of its items does, and != matches
+// records where no item equals value, records without field included. contains matches strings
+// holding value, in matches fields equal to any item of value, which must be a slice.
+func (q *Query) Where(field, op string, value interface{}) *Query {
+	if q.err != nil {
+		return q
+	}
+	if !queryOps[op] {
+		q.err = fmt.Errorf("%w: unknown operator %q", ErrBadQuery, op)
+		return q
+	}
+	v, err := queryValue(value)
+	if err != nil {
+		q.err = fmt.Errorf("%w: %s %s: %s", ErrBadQuery, field, op, err)
+		return q
+	}
+	if _, ok := v.([]interface{}); ok != (op == "in") {
+		q.err = fmt.Errorf("%w: only in takes a slice, %s %s %v", ErrBadQuery, field, op, value)
+		return q
+	}
+	q.conds = append(q.conds, condition{field: field, path: strings.Split(field, "."), op: op, value: v})
+	return q
+}
+
+// Limit stops the query after n records, 0 for no limit
+func (q *Query) Limit(n int) *Query {
+	q.limit = n
+	return q
+}
+
+// Run returns the records matching the query in key order. Records that are not json never match.
+func (q *Query) Run() ([]Record, error) {
+	return q.RunContext(context.Background())
+}
+
+// RunContext is Run with a context. When an = condition is on a field of the search index, see
+// WithSearchIndex, only the records Lookup returns for it are decrypted, the others are left alone.
+// Like GetByPrefixFrom, a query out of time returns what it matched so far with a PartialError. The
+// limit counts the records matched before AfterGet hooks, see WithHooks, drop any.
+func (q *Query) RunContext(ctx context.Context) (records []Record, err error) {
+	if q.err != nil {
+		return nil, q.err
+	}
+	bl := q.bl
+	defer bl.observe("query", time.Now(), &err)
+	ctx, end := bl.startSpan(ctx, "locknut.Query", q.bucket)
+	defer func() { end(err) }()
+
+	candidates, err := q.candidates()
+	if err != nil {
+		return nil, err
+	}
+	if err = bl.openDB(); err != nil {
+		return nil, err
+	}
+	defer bl.closeDB()
+
+	clock := bl.startScan(ctx)
+	err = bl.db.view(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket([]byte(q.bucket))
+		if bkt == nil {
+			return bucketNotFound("query", q.bucket)
+		}
+		visit := func(k, v []byte) (bool, error) {
+			if v == nil {
+				return true, nil
+			}
+			if err := clock.stop(k, len(records)); err != nil {
+				return false, err
+			}
+			dec, err := bl.decryptContext(ctx, q.bucket, string(k), v)
+			if err != nil {
+				return false, err
+			}
+			if q.matches(dec) {
+				records = append(records, newRecord(q.bucket, string(k), dec))
+			}
+			return q.limit <= 0 || len(records) < q.limit, nil
+		}
+
+		if candidates != nil {
+			for _, k := range candidates {
+				if more, err := visit([]byte(k), bkt.Get([]byte(k))); !more || err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		c := bkt.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if more, err := visit(k, v); !more || err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, ErrDeadlinePartial) {
+		log.Error("Query return", err)
+		return records, err
+	}
+	if len(records) > 0 {
+		if aerr := bl.auditRead(ctx, "query", q.bucket, ""); aerr != nil {
+			return nil, aerr
+		}
+	}
+	if len(bl.hooks) > 0 {
+		kept := records[:0]
+		for _, r := range records {
+			v, herr := bl.afterGet(ctx, q.bucket, r.Key, r.Bytes())
+			if herr != nil {
+				return nil, herr
+			}
+			if v != nil {
+				kept = append(kept, newRecord(q.bucket, r.Key, v))
+			}
+		}
+		records = kept
+	}
+	return records, err
+}
+
+// candidates returns the keys an indexed = condition narrows the query to, or nil to scan the bucket
+func (q *Query) candidates() ([]string, error) {
+	if q.bl.searchPath == "" {
+		return nil, nil
+	}
+	for _, c := range q.conds {
+		if c.op != "=" {
+			continue
+		}
+		if _, ok := q.bl.indexedField(q.bucket, c.field); !ok {
+			continue
+		}
+		values := fieldValues(c.value, nil)
+		if len(values) != 1 {
+			continue
+		}
+		keys, err := q.bl.Lookup(q.bucket, c.field, values[0])
+		if err != nil {
+			return nil, err
+		}
+		return keys, nil
+	}
+	return nil, nil
+}
+
+// matches reports whether the json value dec holds every condition. The index only narrows the
+// candidates, its analyzer folds case, so indexed conditions are checked again here.
+func (q *Query) matches(dec []byte) bool {
+	var doc interface{}
+	if json.Unmarshal(dec, &doc) != nil {
+		return false
+	}
+	for _, c := range q.conds {
+		if !c.holds(fieldItems(doc, c.path)) {
+			return false
+		}
+	}
+	return true
+}
+
+// holds reports whether the values of a record's field meet c
+func (c condition) holds(values []interface{}) bool {
+	if c.op == "!=" {
+		for _, v := range values {
+			if cmp, ok := compareValues(v, c.value); ok && cmp == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	for _, v := range values {
+		switch c.op {
+		case "contains":
+			s, ok := v.(string)
+			sub, subOK := c.value.(string)
+			if ok && subOK && strings.Contains(s, sub) {
+				return true
+			}
+		case "in":
+			for _, item := range c.value.([]interface{}) {
+				if cmp, ok := compareValues(v, item); ok && cmp == 0 {
+					return true
+				}
+			}
+		default:
+			cmp, ok := compareValues(v, c.value)
+			if !ok {
+				continue
+			}
+			if c.op == "=" && cmp == 0 || c.op == "<" && cmp < 0 || c.op == "<=" && cmp <= 0 ||
+				c.op == ">" && cmp > 0 || c.op == ">=" && cmp >= 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// compareValues orders two decoded json values, ok being false when they cannot be ordered. Values
+// that are neither numbers nor strings are only ordered when equal.
+func compareValues(a, b interface{}) (cmp int, ok bool) {
+	switch a := a.(type) {
+	case float64:
+		b, ok := b.(float64)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case a < b:
+			return -1, true
+		case a > b:
+			return 1, true
+		}
+		return 0, true
+	case string:
+		b, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(a, b), true
+	case bool, nil:
+		return 0, a == b
+	}
+	return 0, false
+}
+
+// fieldItems returns the values at path in doc, the items of arrays on the way flattened
+func fieldItems(doc interface{}, path []string) []interface{} {
+	switch d := doc.(type) {
+	case map[string]interface{}:
+		if len(path) == 0 {
+			return []interface{}{d}
+		}
+		v, ok := d[path[0]]
+		if !ok {
+			return nil
+		}
+		return fieldItems(v, path[1:])
+	case []interface{}:
+		var values []interface{}
+		for _, item := range d {
+			values = append(values, fieldItems(item, path)...)
+		}
+		return values
+	}
+	if len(path) != 0 {
+		return nil
+	}
+	return []interface{}{doc}
+}
+
+// queryValue returns value as json decodes it, so it compares with the fields of records
+func queryValue(value interface{}) (interface{}, error) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	err = json.Unmarshal(b, &v)
+	return v, err
+}
@@ -0,0 +1,125 @@
+package locknut
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestQuery(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("query_test.db")
+	defer os.Remove("query_test.idx")
+
+	bl, err := NewBoltLocknut("query_test.db", ".", []byte("secret"), false, []string{"users", "empty"},
+		WithSearchIndex("query_test.idx", IndexField{Bucket: "users", Field: "status"}))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	type user struct {
+		Status  string            `json:"status"`
+		Age     int               `json:"age"`
+		Tags    []string          `json:"tags,omitempty"`
+		Address map[string]string `json:"address,omitempty"`
+	}
+	users := map[string]user{
+		"ada":   {Status: "active", Age: 36, Tags: []string{"math", "engines"}, Address: map[string]string{"city": "London"}},
+		"alan":  {Status: "Active", Age: 41, Tags: []string{"math"}},
+		"grace": {Status: "active", Age: 85, Address: map[string]string{"city": "Arlington"}},
+		"linus": {Status: "retired", Age: 9},
+	}
+	for k, u := range users {
+		if err = bl.Save("users", k, u); err != nil {
+			t.Fatalf("Save: %s", err)
+		}
+	}
+	if err = bl.SaveBytes("users", "raw", []byte("not json")); err != nil {
+		t.Fatalf("SaveBytes: %s", err)
+	}
+
+	keys := func(q *Query) string {
+		t.Helper()
+		records, err := q.Run()
+		if err != nil {
+			t.Fatalf("Run: %s", err)
+		}
+		s := ""
+		for _, r := range records {
+			s += r.Key + " "
+		}
+		return s
+	}
+	for _, c := range []struct {
+		q    *Query
+		want string
+	}{
+		// the index folds case, the query does not
+		{bl.Query("users").Where("status", "=", "active"), "ada grace "},
+		{bl.Query("users").Where("status", "=", "active").Limit(1), "ada "},
+		{bl.Query("users").Where("status", "!=", "active"), "alan linus "},
+		{bl.Query("users").Where("age", ">=", 41).Where("age", "<", 85), "alan "},
+		{bl.Query("users").Where("age", "<", 40), "ada linus "},
+		{bl.Query("users").Where("tags", "=", "math"), "ada alan "},
+		{bl.Query("users").Where("address.city", "contains", "on"), "ada grace "},
+		{bl.Query("users").Where("status", "in", []string{"Active", "retired"}), "alan linus "},
+		{bl.Query("users").Where("address", "!=", nil).Where("age", ">", "40"), ""},
+		{bl.Query("users"), "ada alan grace linus "},
+		{bl.Query("empty").Where("status", "=", "active"), ""},
+	} {
+		if got := keys(c.q); got != c.want {
+			t.Errorf("expected %q, got %q", c.want, got)
+		}
+	}
+
+	for _, q := range []*Query{
+		bl.Query("users").Where("status", "~", "active"),
+		bl.Query("users").Where("status", "in", "active"),
+		bl.Query("users").Where("status", "=", []string{"active"}),
+		bl.Query("users").Where("status", "=", make(chan int)),
+	} {
+		if _, err = q.Run(); !errors.Is(err, ErrBadQuery) {
+			t.Errorf("expected ErrBadQuery, got %v", err)
+		}
+	}
+	if _, err = bl.Query("missing").Run(); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("expected ErrBucketNotFound, got %v", err)
+	}
+
+	// queries run as a principal need read on the bucket
+	p := NewPolicy()
+	p.Grant("reader", "users", PermRead)
+	bl.policy = p
+	if records, err := bl.As("reader").Query("users").Where("age", ">", 80).Run(); err != nil || len(records) != 1 {
+		t.Errorf("expected grace, got %v %v", records, err)
+	}
+	if _, err = bl.As("someone").Query("users").Run(); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("expected ErrPermissionDenied, got %v", err)
+	}
+}
+
+func TestQueryHooks(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("query_hooks_test.db")
+
+	bl, err := NewBoltLocknut("query_hooks_test.db", ".", []byte("secret"), false, []string{"items"},
+		WithHooks(Hooks{AfterGet: func(ctx context.Context, bucket, key string, value []byte) ([]byte, error) {
+			if key == "item1" {
+				return nil, nil
+			}
+			return value, nil
+		}}))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err = bl.Save("items", fmt.Sprintf("item%d", i), map[string]int{"n": i}); err != nil {
+			t.Fatalf("Save: %s", err)
+		}
+	}
+	records, err := bl.Query("items").Where("n", ">=", 0).Run()
+	if err != nil || len(records) != 2 || records[0].Key != "item0" || records[1].Key != "item2" {
+		t.Errorf("expected the hook to drop item1, got %v %v", records, err)
+	}
+}
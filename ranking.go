@@ -0,0 +1,95 @@
+package locknut
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+)
+
+// ErrCursorInvalid is returned for a search cursor that was not produced by SearchPage
+var ErrCursorInvalid = errors.New("invalid search cursor")
+
+// SearchHit is a record matching a search, Score is the number of times the query terms appear in
+// the searched field
+type SearchHit struct {
+	Key   string `json:"key"`
+	Score int    `json:"score"`
+}
+
+// SearchResults is one page of ranked search hits
+type SearchResults struct {
+	Hits []SearchHit `json:"hits"`
+	// Total is the number of records matching the query
+	Total int `json:"total"`
+	// Next is the cursor of the following page, empty on the last page
+	Next string `json:"next,omitempty"`
+}
+
+// searchCursor is the position of the last hit of a page in the ranking
+type searchCursor struct {
+	Score int    `json:"s"`
+	Key   string `json:"k"`
+}
+
+func (c searchCursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (searchCursor, error) {
+	var c searchCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(b, &c) != nil {
+		return c, ErrCursorInvalid
+	}
+	return c, nil
+}
+
+// ranksBefore reports whether a hit is listed before the position c, hits are ordered by score,
+// highest first, then by key
+func (c searchCursor) ranksBefore(h SearchHit) bool {
+	return h.Score > c.Score || (h.Score == c.Score && h.Key <= c.Key)
+}
+
+// SearchPage returns up to limit hits for query ranked by term frequency, starting after cursor, or
+// at the top for an empty cursor. Hits are ordered by score then key, and cursors hold the position
+// of the last hit rather than an offset, so paging stays stable while records are written: a record
+// is never listed twice unless its score changes.
+func (bl *BoltLocknut) SearchPage(bucket, field, query string, limit int, cursor string) (*SearchResults, error) {
+	var after *searchCursor
+	if cursor != "" {
+		c, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		after = &c
+	}
+
+	matches, err := bl.match(bucket, field, query)
+	if err != nil {
+		return nil, err
+	}
+
+	hits := make([]SearchHit, 0, len(matches))
+	for k, score := range matches {
+		h := SearchHit{Key: k, Score: score}
+		if after == nil || !after.ranksBefore(h) {
+			hits = append(hits, h)
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Key < hits[j].Key
+	})
+
+	res := &SearchResults{Hits: hits, Total: len(matches)}
+	if limit > 0 && len(hits) > limit {
+		res.Hits = hits[:limit]
+		last := res.Hits[limit-1]
+		res.Next = searchCursor{Score: last.Score, Key: last.Key}.encode()
+	}
+	return res, nil
+}
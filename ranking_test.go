@@ -0,0 +1,69 @@
+package locknut
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestSearchPage(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("ranking_test.db")
+	defer os.Remove("ranking_test.idx")
+
+	bl, err := NewBoltLocknut("ranking_test.db", ".", []byte("secret"), false, []string{"notes"},
+		WithSearchIndex("ranking_test.idx", IndexField{Bucket: "notes", Field: "body"}))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+
+	notes := map[string]string{
+		"a": "go go go",
+		"b": "go",
+		"c": "go go",
+		"d": "go go",
+		"e": "rust",
+	}
+	for k, body := range notes {
+		if err = bl.Save("notes", k, map[string]string{"body": body}); err != nil {
+			t.Fatalf("Save: %s", err)
+		}
+	}
+
+	var keys []string
+	cursor, pages := "", 0
+	for {
+		res, err := bl.SearchPage("notes", "body", "go", 2, cursor)
+		if err != nil {
+			t.Fatalf("SearchPage: %s", err)
+		}
+		if pages == 0 && res.Total != 4 {
+			t.Errorf("expected 4 matches, got %d", res.Total)
+		}
+		for _, h := range res.Hits {
+			keys = append(keys, fmt.Sprintf("%s:%d", h.Key, h.Score))
+		}
+		pages++
+
+		if pages == 1 {
+			// a record added behind the cursor does not shift the following pages
+			if err = bl.Save("notes", "0", map[string]string{"body": "go go go go"}); err != nil {
+				t.Fatalf("Save: %s", err)
+			}
+		}
+		if res.Next == "" {
+			break
+		}
+		cursor = res.Next
+	}
+
+	want := []string{"a:3", "c:2", "d:2", "b:1"}
+	if pages != 2 || !reflect.DeepEqual(keys, want) {
+		t.Errorf("expected %v in 2 pages, got %v in %d", want, keys, pages)
+	}
+
+	if _, err = bl.SearchPage("notes", "body", "go", 2, "nope!"); err != ErrCursorInvalid {
+		t.Errorf("expected ErrCursorInvalid, got %v", err)
+	}
+}
@@ -0,0 +1,11 @@
+package locknut
+
+// WithReadOnly opens the db file read only, so reporting jobs can read a live file without being able
+// to change it. Every mutating method returns ErrReadOnly, buckets are not created on open and the
+// stats and search index dbs are read but never rebuilt. bbolt locks the file for as long as it is
+// open, so a read only open waits while a writer in batch mode holds it.
+func WithReadOnly() Option {
+	return func(bl *BoltLocknut) {
+		bl.readOnly = true
+	}
+}
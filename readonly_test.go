@@ -0,0 +1,43 @@
+package locknut
+
+import (
+	"os"
+	"testing"
+)
+
+func TestReadOnly(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("readonly_test.db")
+
+	bl, err := NewBoltLocknut("readonly_test.db", ".", []byte("secret"), false, []string{"reports"})
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if err = bl.Save("reports", "q1", "value"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+
+	ro, err := NewBoltLocknut("readonly_test.db", ".", []byte("secret"), false, []string{"missing"}, WithReadOnly())
+	if err != nil {
+		t.Fatalf("NewBoltLocknut read only: %s", err)
+	}
+	if v, err := ro.Get("reports", "q1"); err != nil || string(v) != `"value"` {
+		t.Errorf("unexpected read only value %q %v", v, err)
+	}
+	if err = ro.Save("reports", "q2", "value"); err != ErrReadOnly {
+		t.Errorf("expected ErrReadOnly from Save, got %v", err)
+	}
+	if err = ro.Delete("reports", "q1"); err != ErrReadOnly {
+		t.Errorf("expected ErrReadOnly from Delete, got %v", err)
+	}
+	if err = ro.CreateBucket("other"); err != ErrReadOnly {
+		t.Errorf("expected ErrReadOnly from CreateBucket, got %v", err)
+	}
+	if buckets, err := ro.Buckets(); err != nil || len(buckets) != 1 {
+		t.Errorf("buckets were created on a read only open: %v %v", buckets, err)
+	}
+
+	if _, err = NewBoltLocknut("readonly_test.db", ".", []byte("other secret"), false, nil, WithReadOnly()); err != ErrWrongSecret {
+		t.Errorf("expected ErrWrongSecret, got %v", err)
+	}
+}
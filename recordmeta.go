@@ -0,0 +1,217 @@
+package locknut
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"time"
+)
+
+// recordMetaBucket holds, in a bucket per record bucket, the RecordMeta of records as json
+var recordMetaBucket = []byte(internalPrefix + "record_meta")
+
+// ContentTypeJSON is the content type of records written by Save
+const ContentTypeJSON = "application/json"
+
+// RecordMeta is what a BoltLocknut configured WithRecordMeta keeps about each record next to its value.
+// It is stored in the clear.
+type RecordMeta struct {
+	// Created is when the record was first saved, Updated when it was last saved
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+	// ContentType is the media type of the value, ContentTypeJSON for Save, given WithContentType for
+	// SaveBytes
+	ContentType string `json:"content_type,omitempty"`
+	// SchemaVersion is the version the value was written with, given WithSchemaVersion
+	SchemaVersion int `json:"schema_version,omitempty"`
+}
+
+// WithRecordMeta keeps a RecordMeta for every record saved, returned by Meta
+func WithRecordMeta() Option {
+	return func(bl *BoltLocknut) {
+		bl.recordMeta = true
+	}
+}
+
+type contentTypeCtx struct{}
+
+type schemaVersionCtx struct{}
+
+// WithContentType makes the records saved with ctx carry the content type ct in their RecordMeta.
+// Records saved again without one keep theirs.
+func WithContentType(ctx context.Context, ct string) context.Context {
+	return context.WithValue(ctx, contentTypeCtx{}, ct)
+}
+
+// WithSchemaVersion makes the records saved with ctx carry version in their RecordMeta. Records saved
+// again without one keep theirs.
+func WithSchemaVersion(ctx context.Context, version int) context.Context {
+	return context.WithValue(ctx, schemaVersionCtx{}, version)
+}
+
+func contentTypeOf(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	ct, _ := ctx.Value(contentTypeCtx{}).(string)
+	return ct
+}
+
+func schemaVersionOf(ctx context.Context) int {
+	if ctx == nil {
+		return 0
+	}
+	v, _ := ctx.Value(schemaVersionCtx{}).(int)
+	return v
+}
+
+// recordMetaEntry is how a RecordMeta is stored, with the stats of the value when there is a stats db
+type recordMetaEntry struct {
+	RecordMeta
+	Stats *metaStats `json:"stats,omitempty"`
+}
+
+// metaStats is what the value of a record adds to the stats of its bucket, kept in its record meta so
+// replacing or deleting the record does not open the value again. It is not kept under WithPadding,
+// which the size of the value would defeat. Tag is the end of the stored value, the tag of its
+// ciphertext, telling whether the value was encrypted again since, by the Optimizer for instance.
+type metaStats struct {
+	Stored     int    `json:"stored"`
+	Plain      int64  `json:"plain"`
+	Version    string `json:"version"`
+	Compressed bool   `json:"compressed,omitempty"`
+	Tag        []byte `json:"tag"`
+}
+
+// metaTagSize is the size of the Tag of metaStats, that of an AES-GCM tag
+const metaTagSize = 16
+
+// newMetaStats returns the metaStats of stored, added to its bucket's stats as added, nil when there is
+// nothing worth keeping
+func (bl *BoltLocknut) newMetaStats(stored []byte, added statsDelta) *metaStats {
+	if bl.statsPath == "" || len(bl.padSizes) > 0 || added.encrypted == 0 || len(stored) < metaTagSize {
+		return nil
+	}
+	s := &metaStats{
+		Stored:     len(stored),
+		Plain:      added.plain,
+		Compressed: added.compressed > 0,
+		Tag:        append([]byte(nil), stored[len(stored)-metaTagSize:]...),
+	}
+	for v := range added.versions {
+		s.Version = v
+	}
+	return s
+}
+
+// describes reports whether s was kept for stored
+func (s *metaStats) describes(stored []byte) bool {
+	return s.Stored == len(stored) && len(stored) >= metaTagSize &&
+		bytes.Equal(s.Tag, stored[len(stored)-metaTagSize:])
+}
+
+// delta is recordStats of the value s describes
+func (s *metaStats) delta() statsDelta {
+	d := statsDelta{keys: 1, bytes: int64(s.Stored), plain: s.Plain, encrypted: 1,
+		versions: map[string]int{s.Version: 1}}
+	if s.Compressed {
+		d.compressed = 1
+	}
+	return d
+}
+
+// storedStats is recordStats of stored, the value under bucket/key, taken from its record meta when
+// that describes it rather than by opening the value
+func (w *writeTx) storedStats(bucket, key string, stored []byte) statsDelta {
+	if stored != nil && w.bl.recordMeta && w.bl.statsPath != "" {
+		var entry recordMetaEntry
+		if v := nestedValue(w.tx, recordMetaBucket, bucket, key); v != nil && json.Unmarshal(v, &entry) == nil {
+			if entry.Stats != nil && entry.Stats.describes(stored) {
+				return entry.Stats.delta()
+			}
+		}
+	}
+	return w.bl.recordStats(bucket, stored)
+}
+
+// touchRecordMeta updates the RecordMeta of bucket/key for a save of stored, added to the stats of
+// bucket as added
+func (w *writeTx) touchRecordMeta(bucket, key string, stored []byte, added statsDelta) error {
+	root, err := w.tx.CreateBucketIfNotExists(recordMetaBucket)
+	if err != nil {
+		return err
+	}
+	bkt, err := root.CreateBucketIfNotExists([]byte(bucket))
+	if err != nil {
+		return err
+	}
+
+	now := w.bl.now().UTC()
+	entry := recordMetaEntry{RecordMeta: RecordMeta{Created: now}}
+	if b := bkt.Get([]byte(key)); b != nil {
+		if err := json.Unmarshal(b, &entry); err != nil {
+			return fmt.Errorf("%w: meta of %s/%s: %v", ErrCorrupt, bucket, key, err)
+		}
+	}
+	entry.Updated = now
+	if ct := contentTypeOf(w.ctx); ct != "" {
+		entry.ContentType = ct
+	}
+	if v := schemaVersionOf(w.ctx); v != 0 {
+		entry.SchemaVersion = v
+	}
+	entry.Stats = w.bl.newMetaStats(stored, added)
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return bkt.Put([]byte(key), b)
+}
+
+// deleteRecordMeta removes the RecordMeta of bucket/key
+func (w *writeTx) deleteRecordMeta(bucket, key string) error {
+	root := w.tx.Bucket(recordMetaBucket)
+	if root == nil {
+		return nil
+	}
+	bkt := root.Bucket([]byte(bucket))
+	if bkt == nil {
+		return nil
+	}
+	return bkt.Delete([]byte(key))
+}
+
+// Meta returns the RecordMeta of the record stored under key. Records saved before WithRecordMeta was
+// set have a zero RecordMeta, a key without a record fails with ErrRecordNotFound.
+func (bl *BoltLocknut) Meta(bucket, key string) (meta RecordMeta, err error) {
+	if err = bl.openDB(); err != nil {
+		return meta, err
+	}
+	defer bl.closeDB()
+
+	err = bl.db.view(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket([]byte(bucket))
+		if bkt == nil {
+			return bucketNotFound("meta", bucket)
+		}
+		if bkt.Get([]byte(key)) == nil {
+			return fmt.Errorf("%w: %s/%s", ErrRecordNotFound, bucket, key)
+		}
+		root := tx.Bucket(recordMetaBucket)
+		if root == nil {
+			return nil
+		}
+		if b := root.Bucket([]byte(bucket)); b != nil {
+			if v := b.Get([]byte(key)); v != nil {
+				if err := json.Unmarshal(v, &meta); err != nil {
+					return fmt.Errorf("%w: meta of %s/%s: %v", ErrCorrupt, bucket, key, err)
+				}
+			}
+		}
+		return nil
+	})
+	return meta, err
+}
@@ -0,0 +1,77 @@
+package locknut
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRecordMeta(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("recordmeta_test.db")
+
+	clock := &fakeClock{t: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	bl, err := NewBoltLocknut("recordmeta_test.db", ".", []byte("secret"), false, []string{"people", "files"},
+		WithClock(clock), WithRecordMeta())
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	created := clock.t
+
+	if err = bl.SaveContext(WithSchemaVersion(context.Background(), 2), "people", "ada", Article{Title: "Ada"}); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	ctx := WithContentType(context.Background(), "image/png")
+	if err = bl.SaveBytesContext(ctx, "files", "logo", []byte{0x89, 'P', 'N', 'G'}); err != nil {
+		t.Fatalf("SaveBytes: %s", err)
+	}
+
+	// saving again moves Updated and keeps the rest
+	clock.t = clock.t.Add(time.Hour)
+	if err = bl.Save("people", "ada", Article{Title: "Ada Lovelace"}); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	meta, err := bl.Meta("people", "ada")
+	if err != nil {
+		t.Fatalf("Meta: %s", err)
+	}
+	want := RecordMeta{Created: created, Updated: clock.t, ContentType: ContentTypeJSON, SchemaVersion: 2}
+	if meta != want {
+		t.Errorf("expected %+v, got %+v", want, meta)
+	}
+	if meta, err = bl.Meta("files", "logo"); err != nil || meta.ContentType != "image/png" || !meta.Updated.Equal(created) {
+		t.Errorf("unexpected meta %+v %v", meta, err)
+	}
+
+	if err = bl.Delete("people", "ada"); err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+	if _, err = bl.Meta("people", "ada"); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expected ErrRecordNotFound, got %v", err)
+	}
+	// meta goes with the record, a new one starts over
+	if err = bl.Save("people", "ada", Article{Title: "Ada"}); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if meta, err = bl.Meta("people", "ada"); err != nil || !meta.Created.Equal(clock.t) || meta.SchemaVersion != 0 {
+		t.Errorf("unexpected meta %+v %v", meta, err)
+	}
+}
+
+func TestRecordMetaOff(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("recordmeta_off_test.db")
+
+	bl, err := NewBoltLocknut("recordmeta_off_test.db", ".", []byte("secret"), false, []string{"people"})
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if err = bl.Save("people", "ada", "Ada"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if meta, err := bl.Meta("people", "ada"); err != nil || meta != (RecordMeta{}) {
+		t.Errorf("expected a zero meta, got %+v %v", meta, err)
+	}
+}
@@ -0,0 +1,383 @@
+// Package redis implements locknut.Locknut on Redis, so the same code runs against a local bolt file
+// and a Redis shared by a cluster. Values are encrypted client side exactly like BoltLocknut encrypts
+// them before they are SET, Redis never sees a plaintext value. Records saved with SaveWithTTL expire
+// like keys given an EXPIRE.
+package redis
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/taybart/locknut"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scanCount is the COUNT hint passed to SCAN
+const scanCount = 1000
+
+var canaryPlaintext = []byte("locknut canary v1")
+
+// RedisLocknut is a locknut.Locknut stored in Redis. Every key it uses starts with its namespace,
+// records are kept under <namespace>r:<bucket>\x00<key> and the bucket names in the set
+// <namespace>buckets.
+type RedisLocknut struct {
+	client    goredis.UniversalClient
+	secret    []byte
+	namespace string
+	timeout   time.Duration
+}
+
+var _ locknut.Locknut = (*RedisLocknut)(nil)
+
+// Option configures a RedisLocknut, passed to NewRedisLocknut
+type Option func(*RedisLocknut)
+
+// WithNamespace sets the prefix of every key, "locknut:" by default, so several stores can share a
+// Redis
+func WithNamespace(ns string) Option {
+	return func(rl *RedisLocknut) {
+		rl.namespace = ns
+	}
+}
+
+// WithTimeout bounds every call made to Redis, there is no bound by default beyond the timeouts of
+// the client
+func WithTimeout(d time.Duration) Option {
+	return func(rl *RedisLocknut) {
+		rl.timeout = d
+	}
+}
+
+// NewRedisLocknut uses client, a go-redis Client, ClusterClient or any UniversalClient, creating
+// buckets if needed. Values are encrypted with the key derived from secret, and using a
+// namespace written with another secret fails with locknut.ErrWrongSecret. Closing the RedisLocknut
+// closes the client.
+func NewRedisLocknut(client goredis.UniversalClient, secret []byte, buckets []string, opts ...Option) (*RedisLocknut, error) {
+	rl := &RedisLocknut{client: client, secret: locknut.DeriveKey(secret), namespace: "locknut:"}
+	for _, opt := range opts {
+		opt(rl)
+	}
+
+	if err := rl.checkSecret(); err != nil {
+		return nil, err
+	}
+	for _, b := range buckets {
+		if err := rl.CreateBucket(b); err != nil {
+			return nil, err
+		}
+	}
+	return rl, nil
+}
+
+// Close closes the client
+func (rl *RedisLocknut) Close() error {
+	return rl.client.Close()
+}
+
+func (rl *RedisLocknut) context() (context.Context, context.CancelFunc) {
+	if rl.timeout > 0 {
+		return context.WithTimeout(context.Background(), rl.timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// checkSecret verifies the secret against the canary, writing it for a new namespace
+func (rl *RedisLocknut) checkSecret() error {
+	ctx, cancel := rl.context()
+	defer cancel()
+
+	stored, err := locknut.Encrypt(canaryPlaintext, rl.secret)
+	if err != nil {
+		return err
+	}
+	created, err := rl.client.SetNX(ctx, rl.canaryKey(), stored, 0).Result()
+	if err != nil || created {
+		return err
+	}
+
+	if stored, err = rl.client.Get(ctx, rl.canaryKey()).Bytes(); err != nil {
+		return err
+	}
+	plain, err := locknut.Decrypt(stored, rl.secret)
+	if err != nil || !bytes.Equal(plain, canaryPlaintext) {
+		return locknut.ErrWrongSecret
+	}
+	return nil
+}
+
+func (rl *RedisLocknut) canaryKey() string {
+	return rl.namespace + "canary"
+}
+
+func (rl *RedisLocknut) bucketsKey() string {
+	return rl.namespace + "buckets"
+}
+
+func (rl *RedisLocknut) recordKey(bucket, key string) string {
+	return rl.namespace + "r:" + bucket + "\x00" + key
+}
+
+// globEscaper escapes the characters SCAN MATCH patterns give a meaning to
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// requireBucket fails with a locknut.RecordError of kind locknut.ErrBucketNotFound if bucket does
+// not exist
+func (rl *RedisLocknut) requireBucket(ctx context.Context, op, bucket string) error {
+	ok, err := rl.client.SIsMember(ctx, rl.bucketsKey(), bucket).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return &locknut.RecordError{Op: op, Bucket: bucket, Kind: locknut.ErrBucketNotFound}
+	}
+	return nil
+}
+
+func (rl *RedisLocknut) decrypt(bucket, key string, stored []byte) ([]byte, error) {
+	plain, err := locknut.Decrypt(stored, rl.secret)
+	if err != nil {
+		kind := locknut.ErrDecrypt
+		if errors.Is(err, locknut.ErrCiphertextTooShort) {
+			kind = locknut.ErrCorrupt
+		}
+		return nil, &locknut.RecordError{Op: "decrypt", Bucket: bucket, Key: key, Kind: kind, Err: err}
+	}
+	return plain, nil
+}
+
+// Save stores data as json under key in bucket
+func (rl *RedisLocknut) Save(bucket, key string, data interface{}) error {
+	return rl.SaveWithTTL(bucket, key, data, 0)
+}
+
+// SaveWithTTL is Save for a record that expires after ttl, zero keeps it forever
+func (rl *RedisLocknut) SaveWithTTL(bucket, key string, data interface{}, ttl time.Duration) error {
+	if data == nil {
+		return errors.New("data is nil")
+	}
+	value, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return rl.SaveBytesWithTTL(bucket, key, value, ttl)
+}
+
+// SaveBytes stores data as it is under key in bucket
+func (rl *RedisLocknut) SaveBytes(bucket, key string, data []byte) error {
+	return rl.SaveBytesWithTTL(bucket, key, data, 0)
+}
+
+// SaveBytesWithTTL is SaveBytes for a record that expires after ttl, zero keeps it forever
+func (rl *RedisLocknut) SaveBytesWithTTL(bucket, key string, data []byte, ttl time.Duration) error {
+	if data == nil {
+		return errors.New("data is nil")
+	}
+	if key == "" {
+		return locknut.ErrKeyInvalid
+	}
+
+	stored, err := locknut.Encrypt(data, rl.secret)
+	if err != nil {
+		return &locknut.RecordError{Op: "encrypt", Bucket: bucket, Key: key, Kind: locknut.ErrEncrypt, Err: err}
+	}
+
+	ctx, cancel := rl.context()
+	defer cancel()
+	if err = rl.requireBucket(ctx, "put", bucket); err != nil {
+		return err
+	}
+	return rl.client.Set(ctx, rl.recordKey(bucket, key), stored, ttl).Err()
+}
+
+// Get returns the record stored under exactly key, or nil if there is none
+func (rl *RedisLocknut) Get(bucket, key string) ([]byte, error) {
+	ctx, cancel := rl.context()
+	defer cancel()
+	if err := rl.requireBucket(ctx, "get", bucket); err != nil {
+		return nil, err
+	}
+
+	stored, err := rl.client.Get(ctx, rl.recordKey(bucket, key)).Bytes()
+	if err == goredis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rl.decrypt(bucket, key, stored)
+}
+
+// scanKeys returns the keys of bucket starting with prefix in order, found with SCAN MATCH on every
+// master when the client is a cluster client
+func (rl *RedisLocknut) scanKeys(ctx context.Context, op, bucket, prefix string) ([]string, error) {
+	if err := rl.requireBucket(ctx, op, bucket); err != nil {
+		return nil, err
+	}
+
+	match := globEscaper.Replace(rl.recordKey(bucket, prefix)) + "*"
+	skip := len(rl.recordKey(bucket, ""))
+	var mu sync.Mutex
+	var keys []string
+	scan := func(ctx context.Context, c goredis.UniversalClient) error {
+		it := c.Scan(ctx, 0, match, scanCount).Iterator()
+		for it.Next(ctx) {
+			mu.Lock()
+			keys = append(keys, it.Val()[skip:])
+			mu.Unlock()
+		}
+		return it.Err()
+	}
+
+	var err error
+	if cluster, ok := rl.client.(*goredis.ClusterClient); ok {
+		err = cluster.ForEachMaster(ctx, func(ctx context.Context, master *goredis.Client) error {
+			return scan(ctx, master)
+		})
+	} else {
+		err = scan(ctx, rl.client)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// SCAN may return a key more than once
+	sort.Strings(keys)
+	unique := keys[:0]
+	for i, k := range keys {
+		if i == 0 || k != keys[i-1] {
+			unique = append(unique, k)
+		}
+	}
+	return unique, nil
+}
+
+// getAll returns the decrypted records under keys, leaving out those that expired or were deleted
+// since they were listed
+func (rl *RedisLocknut) getAll(ctx context.Context, bucket string, keys []string) (map[string][]byte, error) {
+	cmds := make([]*goredis.StringCmd, len(keys))
+	_, err := rl.client.Pipelined(ctx, func(p goredis.Pipeliner) error {
+		for i, k := range keys {
+			cmds[i] = p.Get(ctx, rl.recordKey(bucket, k))
+		}
+		return nil
+	})
+	if err != nil && err != goredis.Nil {
+		return nil, err
+	}
+
+	results := make(map[string][]byte, len(keys))
+	for i, cmd := range cmds {
+		stored, err := cmd.Bytes()
+		if err == goredis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if results[keys[i]], err = rl.decrypt(bucket, keys[i], stored); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// GetOne returns the first record whose key starts with key
+func (rl *RedisLocknut) GetOne(bucket, key string) ([]byte, error) {
+	if key == "" {
+		return nil, locknut.ErrKeyInvalid
+	}
+	ctx, cancel := rl.context()
+	defer cancel()
+
+	keys, err := rl.scanKeys(ctx, "get", bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	// the first key may expire between SCAN and GET, so fall back on the next ones
+	for _, k := range keys {
+		stored, err := rl.client.Get(ctx, rl.recordKey(bucket, k)).Bytes()
+		if err == goredis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return rl.decrypt(bucket, k, stored)
+	}
+	return nil, nil
+}
+
+// GetByPrefix returns the decrypted records whose keys start with prefix
+func (rl *RedisLocknut) GetByPrefix(bucket, prefix string) (map[string][]byte, error) {
+	ctx, cancel := rl.context()
+	defer cancel()
+
+	keys, err := rl.scanKeys(ctx, "get", bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+	return rl.getAll(ctx, bucket, keys)
+}
+
+// GetKeyList returns the keys of bucket starting with prefix, in order
+func (rl *RedisLocknut) GetKeyList(bucket, prefix string) ([]string, error) {
+	ctx, cancel := rl.context()
+	defer cancel()
+
+	keys, err := rl.scanKeys(ctx, "list", bucket, prefix)
+	if keys == nil && err == nil {
+		keys = make([]string, 0)
+	}
+	return keys, err
+}
+
+// Delete removes key from bucket
+func (rl *RedisLocknut) Delete(bucket, key string) error {
+	if key == "" {
+		return errors.New("cannot delete, key is nil")
+	}
+	ctx, cancel := rl.context()
+	defer cancel()
+
+	if err := rl.requireBucket(ctx, "delete", bucket); err != nil {
+		return err
+	}
+	return rl.client.Del(ctx, rl.recordKey(bucket, key)).Err()
+}
+
+// Buckets returns the names of the buckets, in order
+func (rl *RedisLocknut) Buckets() ([]string, error) {
+	ctx, cancel := rl.context()
+	defer cancel()
+
+	names, err := rl.client.SMembers(ctx, rl.bucketsKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// CreateBucket creates the bucket if it does not exist yet
+func (rl *RedisLocknut) CreateBucket(name string) error {
+	if name == "" || strings.IndexByte(name, 0) >= 0 {
+		return locknut.ErrBucketInvalid
+	}
+	ctx, cancel := rl.context()
+	defer cancel()
+	return rl.client.SAdd(ctx, rl.bucketsKey(), name).Err()
+}
+
+// Count returns the number of records in bucket that have not expired
+func (rl *RedisLocknut) Count(bucket string) (int, error) {
+	ctx, cancel := rl.context()
+	defer cancel()
+
+	keys, err := rl.scanKeys(ctx, "count", bucket, "")
+	return len(keys), err
+}
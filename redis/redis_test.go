@@ -0,0 +1,84 @@
+package redis
+
+import (
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/taybart/locknut"
+	"testing"
+	"time"
+)
+
+func TestRedisLocknut(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := goredis.NewClient(&goredis.Options{Addr: mr.Addr()})
+
+	rl, err := NewRedisLocknut(client, []byte("secret"), []string{"articles"})
+	if err != nil {
+		t.Fatalf("NewRedisLocknut: %s", err)
+	}
+
+	for k, v := range map[string]string{"a1": "one", "a2": "two", "b1": "three", "a*": "glob"} {
+		if err = rl.Save("articles", k, v); err != nil {
+			t.Fatalf("Save: %s", err)
+		}
+	}
+	if err = rl.Save("missing", "a1", "one"); !errors.Is(err, locknut.ErrBucketNotFound) {
+		t.Errorf("expected ErrBucketNotFound, got %v", err)
+	}
+
+	if v, err := rl.Get("articles", "a1"); err != nil || string(v) != `"one"` {
+		t.Errorf("unexpected Get %q %v", v, err)
+	}
+	if v, err := rl.Get("articles", "a"); err != nil || v != nil {
+		t.Errorf("expected no record for a prefix, got %q %v", v, err)
+	}
+	if v, err := rl.GetOne("articles", "a"); err != nil || string(v) != `"glob"` {
+		t.Errorf("unexpected GetOne %q %v", v, err)
+	}
+	if res, err := rl.GetByPrefix("articles", "a"); err != nil || len(res) != 3 || string(res["a2"]) != `"two"` {
+		t.Errorf("unexpected GetByPrefix %v %v", res, err)
+	}
+	// glob characters in a prefix match only themselves
+	if keys, err := rl.GetKeyList("articles", "a*"); err != nil || len(keys) != 1 || keys[0] != "a*" {
+		t.Errorf("unexpected GetKeyList for a glob %v %v", keys, err)
+	}
+	if keys, err := rl.GetKeyList("articles", ""); err != nil || len(keys) != 4 || keys[3] != "b1" {
+		t.Errorf("unexpected GetKeyList %v %v", keys, err)
+	}
+	if err = rl.Delete("articles", "a2"); err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+	if n, err := rl.Count("articles"); err != nil || n != 3 {
+		t.Errorf("unexpected Count %d %v", n, err)
+	}
+	if names, err := rl.Buckets(); err != nil || len(names) != 1 || names[0] != "articles" {
+		t.Errorf("unexpected Buckets %v %v", names, err)
+	}
+
+	// values are stored as BoltLocknut stores them
+	stored, err := mr.Get(rl.recordKey("articles", "a1"))
+	if err != nil {
+		t.Fatalf("Get: %s", err)
+	}
+	plain, err := locknut.Decrypt([]byte(stored), locknut.DeriveKey([]byte("secret")))
+	if err != nil || string(plain) != `"one"` {
+		t.Errorf("unexpected stored value %q %v", plain, err)
+	}
+
+	if err = rl.SaveWithTTL("articles", "c1", "soon gone", time.Minute); err != nil {
+		t.Fatalf("SaveWithTTL: %s", err)
+	}
+	mr.FastForward(2 * time.Minute)
+	if v, err := rl.Get("articles", "c1"); err != nil || v != nil {
+		t.Errorf("expected the record to expire, got %q %v", v, err)
+	}
+
+	if _, err = NewRedisLocknut(client, []byte("other secret"), nil); err != locknut.ErrWrongSecret {
+		t.Errorf("expected ErrWrongSecret, got %v", err)
+	}
+	if _, err = NewRedisLocknut(client, []byte("other secret"), nil, WithNamespace("other:")); err != nil {
+		t.Errorf("expected a new namespace to accept another secret, got %v", err)
+	}
+	rl.Close()
+}
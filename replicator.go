@@ -0,0 +1,262 @@
+package locknut
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"net/http"
+	"time"
+)
+
+// replicaBucket holds the resume token of a db that is the target of a Replicator
+var replicaBucket = []byte(internalPrefix + "replica")
+
+var appliedKey = []byte("applied")
+
+// ReplicaTarget receives the changes tailed from a source BoltLocknut
+type ReplicaTarget interface {
+	// Applied returns the sequence number of the last change applied, used as the resume token
+	Applied() (uint64, error)
+	// Apply applies the changes in order and advances the resume token atomically with them
+	Apply(changes []Change) error
+}
+
+// Replicator tails the changelog of a source BoltLocknut and applies it to a target, keeping a warm
+// standby copy. The source must be opened WithChangelog and the target must share its secret.
+type Replicator struct {
+	src *BoltLocknut
+	dst ReplicaTarget
+
+	// BatchSize is the number of changes sent to the target per Apply
+	BatchSize int
+	// Name identifies the target in the watermarks of the source, which keep the changes it has not
+	// applied yet from being truncated. Replicators to different targets need different names.
+	Name string
+}
+
+// NewReplicator creates a replicator from src to dst
+func NewReplicator(src *BoltLocknut, dst ReplicaTarget) *Replicator {
+	return &Replicator{src: src, dst: dst, BatchSize: 500, Name: "replica"}
+}
+
+// Sync applies every change the target has not seen yet and returns the number of changes applied.
+// It fails with ErrHistoryUnavailable if changes the target needs were truncated from the changelog,
+// in which case the target has to be seeded again.
+func (r *Replicator) Sync() (int, error) {
+	applied, err := r.dst.Applied()
+	if err != nil {
+		return 0, err
+	}
+	if err = r.src.SetWatermark(r.Name, applied); err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for {
+		changes, err := r.src.Changes(applied, r.BatchSize)
+		if err != nil {
+			return total, err
+		}
+		if len(changes) == 0 {
+			return total, nil
+		}
+		if changes[0].Seq != applied+1 {
+			return total, ErrHistoryUnavailable
+		}
+
+		if err = r.dst.Apply(changes); err != nil {
+			return total, err
+		}
+		total += len(changes)
+		applied = changes[len(changes)-1].Seq
+		if err = r.src.SetWatermark(r.Name, applied); err != nil {
+			return total, err
+		}
+	}
+}
+
+// Run calls Sync every interval until ctx is done
+func (r *Replicator) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.Sync(); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// localTarget applies changes to a BoltLocknut in the same process
+type localTarget struct {
+	bl *BoltLocknut
+}
+
+// NewLocalTarget returns a ReplicaTarget writing to bl, typically a db file on another disk
+func NewLocalTarget(bl *BoltLocknut) ReplicaTarget {
+	return localTarget{bl: bl}
+}
+
+func (t localTarget) Applied() (uint64, error) {
+	return t.bl.replicaApplied()
+}
+
+func (t localTarget) Apply(changes []Change) error {
+	return t.bl.applyChanges(changes)
+}
+
+func (bl *BoltLocknut) replicaApplied() (uint64, error) {
+	var err error
+	if err = bl.openDB(); err != nil {
+		return 0, err
+	}
+	defer bl.closeDB()
+
+	var applied uint64
+	err = bl.db.view(func(tx *bbolt.Tx) error {
+		applied = appliedSeq(tx)
+		return nil
+	})
+	return applied, err
+}
+
+func appliedSeq(tx *bbolt.Tx) uint64 {
+	bkt := tx.Bucket(replicaBucket)
+	if bkt == nil {
+		return 0
+	}
+	v := bkt.Get(appliedKey)
+	if len(v) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(v)
+}
+
+// applyChanges writes the stored bytes of each change verbatim, skipping changes already applied
+func (bl *BoltLocknut) applyChanges(changes []Change) error {
+	return bl.write(func(w *writeTx) error {
+		applied := appliedSeq(w.tx)
+		for _, c := range changes {
+			if c.Seq <= applied {
+				continue
+			}
+
+			if _, err := w.tx.CreateBucketIfNotExists([]byte(c.Bucket)); err != nil {
+				return err
+			}
+
+			var err error
+			switch c.Op {
+			case OpPut:
+				err = w.putStored(c.Bucket, c.Key, c.Value)
+			case OpDelete:
+				err = w.delete(c.Bucket, c.Key)
+			default:
+				err = fmt.Errorf("unknown change op %q", c.Op)
+			}
+			if err != nil {
+				return err
+			}
+			applied = c.Seq
+		}
+
+		bkt, err := w.tx.CreateBucketIfNotExists(replicaBucket)
+		if err != nil {
+			return err
+		}
+		return bkt.Put(appliedKey, seqKey(applied))
+	})
+}
+
+type appliedResponse struct {
+	Applied uint64 `json:"applied"`
+}
+
+// ReplicaHandler serves bl as a replication target over http: GET returns the resume token and POST
+// applies a JSON array of changes. It does no authentication, mount it behind your own.
+func ReplicaHandler(bl *BoltLocknut) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			applied, err := bl.replicaApplied()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(appliedResponse{Applied: applied})
+		case http.MethodPost:
+			var changes []Change
+			if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := bl.applyChanges(changes); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// httpTarget applies changes to a remote ReplicaHandler
+type httpTarget struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPTarget returns a ReplicaTarget talking to a ReplicaHandler mounted at url. If client is nil
+// http.DefaultClient is used.
+func NewHTTPTarget(url string, client *http.Client) ReplicaTarget {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return httpTarget{url: url, client: client}
+}
+
+func (t httpTarget) Applied() (uint64, error) {
+	res, err := t.client.Get(t.url)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("replica returned %s", res.Status)
+	}
+
+	var body appliedResponse
+	if err = json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return 0, err
+	}
+	return body.Applied, nil
+}
+
+func (t httpTarget) Apply(changes []Change) error {
+	body, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+
+	res, err := t.client.Post(t.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("replica returned %s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package locknut
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestReplicator(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	bucketName := "article"
+	defer os.Remove("replica_src.db")
+	defer os.Remove("replica_dst.db")
+	defer os.Remove("replica_remote.db")
+
+	src, err := NewBoltLocknut("replica_src.db", ".", []byte("secret"), false, []string{bucketName}, WithChangelog())
+	if err != nil {
+		t.Fatalf("NewBoltLocknut src: %s", err)
+	}
+	dst, err := NewBoltLocknut("replica_dst.db", ".", []byte("secret"), false, nil)
+	if err != nil {
+		t.Fatalf("NewBoltLocknut dst: %s", err)
+	}
+
+	for _, id := range []string{"ID-0001", "ID-0002", "ID-0003"} {
+		if err = src.Save(bucketName, id, Article{ID: id, Title: "replicated"}); err != nil {
+			t.Fatalf("Save return err: %s", err)
+		}
+	}
+	if err = src.Delete(bucketName, "ID-0002"); err != nil {
+		t.Fatalf("Delete return err: %s", err)
+	}
+
+	r := NewReplicator(src, NewLocalTarget(dst))
+	r.BatchSize = 2
+	n, err := r.Sync()
+	if err != nil {
+		t.Fatalf("Sync return err: %s", err)
+	}
+	if n != 4 {
+		t.Errorf("expected 4 changes applied, got %d", n)
+	}
+
+	// resuming applies nothing new
+	if n, err = r.Sync(); err != nil || n != 0 {
+		t.Errorf("expected resumed sync to be a no-op, got %d %v", n, err)
+	}
+
+	keys, err := dst.GetKeyList(bucketName, "")
+	if err != nil {
+		t.Fatalf("GetKeyList return err: %s", err)
+	}
+	if len(keys) != 2 || keys[0] != "ID-0001" || keys[1] != "ID-0003" {
+		t.Errorf("unexpected replica keys %v", keys)
+	}
+
+	bytes, err := dst.GetOne(bucketName, "ID-0003")
+	if err != nil {
+		t.Fatalf("GetOne return err: %s", err)
+	}
+	var a Article
+	if err = json.Unmarshal(bytes, &a); err != nil || a.ID != "ID-0003" {
+		t.Errorf("unexpected replica record %s %v", bytes, err)
+	}
+
+	remote, err := NewBoltLocknut("replica_remote.db", ".", []byte("secret"), false, nil)
+	if err != nil {
+		t.Fatalf("NewBoltLocknut remote: %s", err)
+	}
+	srv := httptest.NewServer(ReplicaHandler(remote))
+	defer srv.Close()
+
+	if n, err = NewReplicator(src, NewHTTPTarget(srv.URL, nil)).Sync(); err != nil || n != 4 {
+		t.Fatalf("http Sync returned %d %v", n, err)
+	}
+	if keys, err = remote.GetKeyList(bucketName, ""); err != nil || len(keys) != 2 {
+		t.Errorf("unexpected remote keys %v %v", keys, err)
+	}
+}
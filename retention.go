@@ -0,0 +1,140 @@
+package locknut
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"time"
+)
+
+// watermarkBucket holds, per changelog consumer, the sequence number it has processed up to
+var watermarkBucket = []byte(internalPrefix + "watermarks")
+
+// ErrConsumerBehind is returned when truncating the changelog would drop changes a consumer has not
+// processed yet
+var ErrConsumerBehind = errors.New("changelog consumer has not caught up")
+
+// SetWatermark records that the named changelog consumer, such as a replica or a backup job, has
+// processed every change up to seq. TruncateChangelog never removes changes a consumer still needs.
+// Replicators record their watermark under their Name on every Sync.
+func (bl *BoltLocknut) SetWatermark(name string, seq uint64) error {
+	return bl.write(func(w *writeTx) error {
+		bkt, err := w.tx.CreateBucketIfNotExists(watermarkBucket)
+		if err != nil {
+			return err
+		}
+		return bkt.Put([]byte(name), seqKey(seq))
+	})
+}
+
+// DeleteWatermark forgets a consumer that is gone for good, so it no longer holds back truncation
+func (bl *BoltLocknut) DeleteWatermark(name string) error {
+	return bl.write(func(w *writeTx) error {
+		bkt := w.tx.Bucket(watermarkBucket)
+		if bkt == nil {
+			return nil
+		}
+		return bkt.Delete([]byte(name))
+	})
+}
+
+// Watermarks returns the recorded consumers with their watermarks
+func (bl *BoltLocknut) Watermarks() (map[string]uint64, error) {
+	var err error
+	if err = bl.openDB(); err != nil {
+		return nil, err
+	}
+	defer bl.closeDB()
+
+	marks := make(map[string]uint64)
+	err = bl.db.view(func(tx *bbolt.Tx) error {
+		marks = readWatermarks(tx)
+		return nil
+	})
+	return marks, err
+}
+
+func readWatermarks(tx *bbolt.Tx) map[string]uint64 {
+	marks := make(map[string]uint64)
+	if bkt := tx.Bucket(watermarkBucket); bkt != nil {
+		bkt.ForEach(func(k, v []byte) error {
+			marks[string(k)] = binary.BigEndian.Uint64(v)
+			return nil
+		})
+	}
+	return marks
+}
+
+// TruncateChangelog removes the changes with a sequence number lower than before and returns how
+// many were removed. It fails with ErrConsumerBehind, removing nothing, if a consumer with a
+// watermark still needs any of them. Sequence numbers keep counting up after a truncation, and
+// GetAt and ScanAt report ErrHistoryUnavailable for the history that was removed.
+func (bl *BoltLocknut) TruncateChangelog(before uint64) (int, error) {
+	removed := 0
+	err := bl.write(func(w *writeTx) error {
+		for name, mark := range readWatermarks(w.tx) {
+			if mark+1 < before {
+				return fmt.Errorf("%w: %s is at %d", ErrConsumerBehind, name, mark)
+			}
+		}
+
+		var err error
+		removed, err = truncateChangelog(w.tx, func(c *Change) bool { return c.Seq < before })
+		return err
+	})
+	return removed, err
+}
+
+// TruncateChangelogBefore removes the changes made before t, the retention form of
+// TruncateChangelog. Changes still needed by a consumer with a watermark are kept rather than
+// failing, so it is safe to run on a schedule.
+func (bl *BoltLocknut) TruncateChangelogBefore(t time.Time) (int, error) {
+	removed := 0
+	err := bl.write(func(w *writeTx) error {
+		marks := readWatermarks(w.tx)
+		var min uint64
+		first := true
+		for _, mark := range marks {
+			if first || mark < min {
+				min, first = mark, false
+			}
+		}
+
+		var err error
+		removed, err = truncateChangelog(w.tx, func(c *Change) bool {
+			return c.Time.Before(t) && (len(marks) == 0 || c.Seq <= min)
+		})
+		return err
+	})
+	return removed, err
+}
+
+// truncateChangelog removes changes from the oldest on while remove returns true
+func truncateChangelog(tx *bbolt.Tx, remove func(c *Change) bool) (int, error) {
+	bkt := tx.Bucket(changelogBucket)
+	if bkt == nil {
+		return 0, ErrNoChangelog
+	}
+
+	var keys [][]byte
+	cursor := bkt.Cursor()
+	for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+		var c Change
+		if err := json.Unmarshal(v, &c); err != nil {
+			return 0, err
+		}
+		if !remove(&c) {
+			break
+		}
+		keys = append(keys, append([]byte(nil), k...))
+	}
+
+	for _, k := range keys {
+		if err := bkt.Delete(k); err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
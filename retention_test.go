@@ -0,0 +1,90 @@
+package locknut
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTruncateChangelog(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("retention_test.db")
+	defer os.Remove("retention_replica_test.db")
+
+	src, err := NewBoltLocknut("retention_test.db", ".", []byte("secret"), false, []string{"a"}, WithChangelog())
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	dst, err := NewBoltLocknut("retention_replica_test.db", ".", []byte("secret"), false, nil)
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+
+	for _, k := range []string{"k1", "k2", "k3"} {
+		if err = src.SaveBytes("a", k, []byte(k)); err != nil {
+			t.Fatalf("SaveBytes: %s", err)
+		}
+	}
+	r := NewReplicator(src, NewLocalTarget(dst))
+	if _, err = r.Sync(); err != nil {
+		t.Fatalf("Sync: %s", err)
+	}
+	for _, k := range []string{"k4", "k5"} {
+		if err = src.SaveBytes("a", k, []byte(k)); err != nil {
+			t.Fatalf("SaveBytes: %s", err)
+		}
+	}
+
+	// the replica is at 3, so 4 and 5 must stay
+	if _, err = src.TruncateChangelog(5); !errors.Is(err, ErrConsumerBehind) {
+		t.Fatalf("expected ErrConsumerBehind, got %v", err)
+	}
+	n, err := src.TruncateChangelog(4)
+	if err != nil || n != 3 {
+		t.Fatalf("expected 3 changes removed, got %d %v", n, err)
+	}
+
+	if _, err = src.GetAt("a", "k2", 1); !errors.Is(err, ErrHistoryUnavailable) {
+		t.Errorf("expected truncated history, got %v", err)
+	}
+	if v, err := src.GetAt("a", "k2", 3); err != nil || string(v) != "k2" {
+		t.Errorf("unexpected GetAt at the truncation point %q %v", v, err)
+	}
+	if v, err := src.GetAt("a", "k4", 4); err != nil || string(v) != "k4" {
+		t.Errorf("unexpected GetAt after truncation %q %v", v, err)
+	}
+
+	if n, err = r.Sync(); err != nil || n != 2 {
+		t.Fatalf("expected the replica to catch up with 2 changes, got %d %v", n, err)
+	}
+
+	// retention by time only removes what the replica has applied
+	if err = src.SaveBytes("a", "k6", []byte("k6")); err != nil {
+		t.Fatalf("SaveBytes: %s", err)
+	}
+	if n, err = src.TruncateChangelogBefore(time.Now().Add(time.Minute)); err != nil || n != 2 {
+		t.Errorf("expected 2 changes removed, got %d %v", n, err)
+	}
+	if changes, _ := src.Changes(0, 0); len(changes) != 1 || changes[0].Key != "k6" {
+		t.Errorf("expected only k6 left, got %v", changes)
+	}
+
+	// a new replica cannot start from a truncated changelog
+	defer os.Remove("retention_new_test.db")
+	fresh, err := NewBoltLocknut("retention_new_test.db", ".", []byte("secret"), false, nil)
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	r2 := NewReplicator(src, NewLocalTarget(fresh))
+	r2.Name = "fresh"
+	if _, err = r2.Sync(); !errors.Is(err, ErrHistoryUnavailable) {
+		t.Errorf("expected ErrHistoryUnavailable, got %v", err)
+	}
+	if err = src.DeleteWatermark("fresh"); err != nil {
+		t.Fatalf("DeleteWatermark: %s", err)
+	}
+	if marks, _ := src.Watermarks(); len(marks) != 1 || marks["replica"] != 5 {
+		t.Errorf("unexpected watermarks %v", marks)
+	}
+}
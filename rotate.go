@@ -0,0 +1,185 @@
+package locknut
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go.etcd.io/bbolt"
+)
+
+// RotateKey re-encrypts every record with newSecret in a single transaction and switches the
+// BoltLocknut to it, so either every record uses the new key or, on error, none does. Values held in
+// the changelog, records kept by SoftDelete, records staged by a running ImportStaged and labels are
+// re-encrypted too, and the search index, keyed from
+// the secret, is rebuilt. Other writes are fenced off with ErrMaintenance until the rotation is
+// complete. Reads wait while the records are committed and the key swapped, which starts once the reads
+// running have ended, new ones waiting meanwhile. The new key is derived with a new salt and the KDF of the db, or the one given WithKDF.
+func (bl *BoltLocknut) RotateKey(newSecret []byte) error {
+	if bl.public != nil {
+		return errors.New("rotate key: records are sealed to a public key, not encrypted with the secret")
+	}
+	return bl.maintain("key rotation", func() error {
+		return bl.rotateKey(newSecret)
+	})
+}
+
+func (bl *BoltLocknut) rotateKey(newSecret []byte) error {
+	// the new key is derived with a fresh salt, and with the KDF given WithKDF when there is one
+	newKDF := bl.keyKDF
+	if bl.kdf != nil {
+		newKDF = &kdfRecord{KDF: *bl.kdf}
+	}
+	newKey := deriveSecret(newSecret)
+	if newKDF != nil {
+		var err error
+		if newKDF, err = newKDFRecord(bl.randReader(), newKDF.KDF); err != nil {
+			return err
+		}
+		if newKey, err = newKDF.derive(newSecret); err != nil {
+			return err
+		}
+	}
+	keyOf := func(bucket string) []byte {
+		if bl.bucketKeys {
+			return bucketKey(newKey, bucket)
+		}
+		return newKey
+	}
+	reencrypt := func(bucket string) transformFunc {
+		return bl.resealer(bucket, bucket, keyOf(bucket))
+	}
+	relabel := func(bucket string) transformFunc {
+		return bl.labelResealer(bucket, keyOf(bucket))
+	}
+
+	// reads are held off from the commit to the key swap, they would otherwise open records with the
+	// other key
+	if err := bl.pause(); err != nil {
+		return err
+	}
+	if err := bl.open(); err != nil {
+		bl.resume()
+		return err
+	}
+	err := bl.commitOpen(context.Background(), func(w *writeTx) error {
+		err := w.tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+			if string(name) == string(changelogBucket) {
+				return rotateChangelog(b, reencrypt)
+			}
+			if string(name) == string(tombstoneBucket) {
+				return rotateTombstones(b, reencrypt, relabel)
+			}
+			if string(name) == string(labelsBucket) {
+				return rotateLabels(b, relabel)
+			}
+			if string(name) == string(stagingBucket) {
+				return rotateStaging(b, reencrypt)
+			}
+			if isInternalBucket(name) {
+				return nil
+			}
+			return rewriteValues(b, reencrypt(string(name)))
+		})
+		if err != nil {
+			return err
+		}
+		// the trees hash the values just rewritten
+		if err = dropMerkleTrees(w.tx); err != nil {
+			return err
+		}
+		if newKDF != nil {
+			if err = putKDF(w.tx, newKDF); err != nil {
+				return err
+			}
+		}
+		return writeCanary(w.tx, newKey)
+	})
+	if err == nil {
+		bl.keyKDF = newKDF
+		err = bl.setKey(newKey)
+	}
+	bl.closeFile()
+	bl.resume()
+	if err != nil {
+		return err
+	}
+	if bl.searchPath != "" {
+		return bl.RebuildSearchIndex()
+	}
+	return nil
+}
+
+// resealer returns the transform opening a stored value of bucket from with the current keys and
+// encrypting it again with key, the way records of bucket are stored
+func (bl *BoltLocknut) resealer(from, bucket string, key []byte) transformFunc {
+	open := func(ct []byte) ([]byte, error) {
+		plain, _, err := bl.openCiphertext(from, ct)
+		return plain, err
+	}
+	return func(v []byte) ([]byte, error) {
+		if isFieldSealed(v) {
+			return resealFields(v, open, key)
+		}
+		dec, err := bl.decrypt(from, "", v)
+		if err != nil {
+			return nil, err
+		}
+		dec, framed, err := bl.prepare(dec)
+		if err != nil {
+			return nil, err
+		}
+		var ct []byte
+		if bl.isDeterministic(bucket) {
+			ct, err = encryptDeterministic(dec, key)
+		} else {
+			ct, err = bl.encrypt(dec, key)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return markFrame(ct, framed), nil
+	}
+}
+
+// rewriteValues replaces every value of b with fn(value)
+func rewriteValues(b *bbolt.Bucket, fn transformFunc) error {
+	var rewritten []kv
+	err := b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			return nil
+		}
+		nv, err := fn(v)
+		if err != nil {
+			return err
+		}
+		rewritten = append(rewritten, kv{k: append([]byte(nil), k...), v: nv})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, r := range rewritten {
+		if err = b.Put(r.k, r.v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rotateChangelog re-encrypts the stored values kept in the changelog entries, with fn of their bucket
+func rotateChangelog(b *bbolt.Bucket, fn func(bucket string) transformFunc) error {
+	return rewriteValues(b, func(v []byte) ([]byte, error) {
+		var c Change
+		if err := json.Unmarshal(v, &c); err != nil {
+			return nil, err
+		}
+		if c.Value != nil {
+			var err error
+			if c.Value, err = fn(c.Bucket)(c.Value); err != nil {
+				return nil, err
+			}
+		}
+		return json.Marshal(c)
+	})
+}
@@ -0,0 +1,117 @@
+package locknut
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRotateKey(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	bucketName := "article"
+	defer os.Remove("rotate_test.db")
+
+	bl, err := NewBoltLocknut("rotate_test.db", ".", []byte("old secret"), false, []string{bucketName}, WithChangelog())
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if err = bl.Save(bucketName, "ID-0001", Article{ID: "ID-0001", Title: "rotated"}); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+
+	if err = bl.RotateKey([]byte("new secret")); err != nil {
+		t.Fatalf("RotateKey: %s", err)
+	}
+
+	reopened, err := NewBoltLocknut("rotate_test.db", ".", []byte("new secret"), false, nil)
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	raw, err := reopened.GetOne(bucketName, "ID-0001")
+	if err != nil {
+		t.Fatalf("GetOne with new secret: %s", err)
+	}
+	var a Article
+	if err = json.Unmarshal(raw, &a); err != nil || a.Title != "rotated" {
+		t.Errorf("unexpected record %s %v", raw, err)
+	}
+
+	changes, err := bl.Changes(0, 0)
+	if err != nil || len(changes) != 1 {
+		t.Fatalf("Changes: %v %v", changes, err)
+	}
+	if _, err = Decrypt(changes[0].Value, reopened.secret); err != nil {
+		t.Errorf("changelog value was not re-encrypted: %s", err)
+	}
+
+	if _, err = NewBoltLocknut("rotate_test.db", ".", []byte("old secret"), false, nil); err != ErrWrongSecret {
+		t.Errorf("expected the old secret to be rejected, got %v", err)
+	}
+}
+
+func TestRotateKeyDuringReads(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("rotate_reads_test.db")
+
+	var bl *BoltLocknut
+	// a hook reading from the db while holding it
+	nested := Hooks{AfterGet: func(ctx context.Context, bucket, key string, value []byte) ([]byte, error) {
+		if _, err := bl.Count(bucket); err != nil {
+			return nil, err
+		}
+		return value, nil
+	}}
+	bl, err := NewBoltLocknut("rotate_reads_test.db", ".", []byte("old secret"), false, []string{"vault"},
+		WithHooks(nested))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	defer bl.Close()
+	var in strings.Builder
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&in, `{"key":"k%04d","value":"value"}`+"\n", i)
+	}
+	if err = bl.Import(strings.NewReader(in.String()), FormatJSONL, "vault"); err != nil {
+		t.Fatalf("Import: %s", err)
+	}
+
+	stop := make(chan struct{})
+	failures := make(chan string, 1)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			values, err := bl.GetByPrefix("vault", "k00")
+			if err != nil || len(values) != 100 {
+				failures <- fmt.Sprintf("read %d records, %v", len(values), err)
+				return
+			}
+			// let RotateKey run on machines with a single CPU
+			runtime.Gosched()
+		}
+	}()
+
+	for i, secret := range []string{"new secret", "old secret", "new secret"} {
+		if err = bl.RotateKey([]byte(secret)); err != nil {
+			t.Fatalf("RotateKey %d: %s", i, err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	select {
+	case f := <-failures:
+		t.Errorf("read during the rotation failed: %s", f)
+	default:
+	}
+}
@@ -0,0 +1,85 @@
+package locknut
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// WithStrictSchema only lets records be written to the buckets declared when the db is opened, those
+// passed to NewBoltLocknut and those of RegisterType. Saving, importing or creating any other bucket
+// fails with ErrUnknownBucket, so a typo in a bucket name is caught instead of filling a new bucket.
+func WithStrictSchema() Option {
+	return func(bl *BoltLocknut) {
+		bl.strictSchema = true
+	}
+}
+
+// WithKeyPattern only lets records be written to bucket under keys matching re, others fail with
+// ErrKeyRejected. It applies with or without WithStrictSchema.
+func WithKeyPattern(bucket string, re *regexp.Regexp) Option {
+	return func(bl *BoltLocknut) {
+		if bl.keyPatterns == nil {
+			bl.keyPatterns = make(map[string]*regexp.Regexp)
+		}
+		bl.keyPatterns[bucket] = re
+	}
+}
+
+// WithValidator checks every value written to bucket with fn before it is encrypted, a failure is
+// returned wrapped in a RecordError of kind ErrValidation. Validators of a bucket run in the order they
+// were given.
+func WithValidator(bucket string, fn func(value []byte) error) Option {
+	return func(bl *BoltLocknut) {
+		if bl.validators == nil {
+			bl.validators = make(map[string][]func([]byte) error)
+		}
+		bl.validators[bucket] = append(bl.validators[bucket], fn)
+	}
+}
+
+// WithJSONSchema checks every value written to bucket against s, see WithValidator. The *SchemaError
+// of a value that does not match lists every problem it has.
+func WithJSONSchema(bucket string, s *JSONSchema) Option {
+	return WithValidator(bucket, s.Validate)
+}
+
+// declareBuckets records the buckets declared by NewBoltLocknut and its options, run once they were
+// applied
+func (bl *BoltLocknut) declareBuckets() {
+	if !bl.strictSchema {
+		return
+	}
+	bl.declared = make(map[string]bool)
+	for _, b := range bl.buckets {
+		bl.declared[b] = true
+	}
+}
+
+// checkBucket fails with ErrUnknownBucket for a bucket the strict schema does not declare
+func (bl *BoltLocknut) checkBucket(op, bucket string) error {
+	if bl.strictSchema && !bl.declared[bucket] {
+		return &RecordError{Op: op, Bucket: bucket, Kind: ErrUnknownBucket}
+	}
+	return nil
+}
+
+// checkSchema fails if the schema does not let key be written to bucket
+func (bl *BoltLocknut) checkSchema(op, bucket, key string) error {
+	if err := bl.checkBucket(op, bucket); err != nil {
+		return err
+	}
+	if re := bl.keyPatterns[bucket]; re != nil && !re.MatchString(key) {
+		return &RecordError{Op: op, Bucket: bucket, Key: key, Kind: ErrKeyRejected, Err: fmt.Errorf("key does not match %s", re)}
+	}
+	return nil
+}
+
+// validateValue runs the validators of bucket on value
+func (bl *BoltLocknut) validateValue(op, bucket, key string, value []byte) error {
+	for _, validate := range bl.validators[bucket] {
+		if err := validate(value); err != nil {
+			return &RecordError{Op: op, Bucket: bucket, Key: key, Kind: ErrValidation, Err: err}
+		}
+	}
+	return nil
+}
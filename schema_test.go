@@ -0,0 +1,79 @@
+package locknut
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"regexp"
+	"testing"
+)
+
+func TestStrictSchema(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("schema_test.db")
+
+	bl, err := NewBoltLocknut("schema_test.db", ".", []byte("secret"), false, []string{"kids"},
+		RegisterType[Article]("articles"),
+		WithStrictSchema(),
+		WithKeyPattern("kids", regexp.MustCompile(`^kid-[0-9]+$`)),
+	)
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+
+	if err = bl.Save("kids", "kid-1", "ok"); err != nil {
+		t.Errorf("Save to a declared bucket: %s", err)
+	}
+	if err = SaveTyped(bl, "a", Article{ID: "a"}); err != nil {
+		t.Errorf("Save to a registered bucket: %s", err)
+	}
+
+	err = bl.Save("kdis", "kid-1", "typo")
+	var re *RecordError
+	if !errors.Is(err, ErrUnknownBucket) || !errors.As(err, &re) || re.Bucket != "kdis" {
+		t.Errorf("expected ErrUnknownBucket, got %v", err)
+	}
+	if err = bl.CreateBucket("kdis"); !errors.Is(err, ErrUnknownBucket) {
+		t.Errorf("expected CreateBucket to fail with ErrUnknownBucket, got %v", err)
+	}
+	if names, _ := bl.Buckets(); len(names) != 2 {
+		t.Errorf("expected only the declared buckets, got %v", names)
+	}
+
+	if err = bl.Save("kids", "jidds", "bad key"); !errors.Is(err, ErrKeyRejected) {
+		t.Errorf("expected ErrKeyRejected, got %v", err)
+	}
+
+	in := `{"bucket":"kids","key":"kid-2","value":"imported"}` + "\n" +
+		`{"bucket":"jidds","key":"kid-3","value":"typo"}` + "\n"
+	if err = bl.Import(bytes.NewBufferString(in), FormatJSONL, ""); !errors.Is(err, ErrUnknownBucket) {
+		t.Errorf("expected Import to fail with ErrUnknownBucket, got %v", err)
+	}
+}
+
+func TestKeyPatternWithoutStrictSchema(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("schema_test.db")
+
+	bl, err := NewBoltLocknut("schema_test.db", ".", []byte("secret"), false, nil,
+		WithKeyPattern("users", regexp.MustCompile(`^u:`)),
+	)
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if err = bl.CreateBucket("other"); err != nil {
+		t.Fatalf("CreateBucket: %s", err)
+	}
+	if err = bl.Save("other", "anything", 1); err != nil {
+		t.Errorf("expected undeclared buckets to be writable, got %v", err)
+	}
+	if err = bl.CreateBucket("users"); err != nil {
+		t.Fatalf("CreateBucket: %s", err)
+	}
+	if err = bl.Save("users", "u:1", 1); err != nil {
+		t.Errorf("Save: %s", err)
+	}
+	if err = bl.Save("users", "1", 1); !errors.Is(err, ErrKeyRejected) {
+		t.Errorf("expected ErrKeyRejected, got %v", err)
+	}
+}
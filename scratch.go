@@ -0,0 +1,194 @@
+package locknut
+
+import (
+	"errors"
+	"go.etcd.io/bbolt"
+	"sync"
+)
+
+// scratchBucket holds a bucket per open Scratch, named by its ID
+var scratchBucket = []byte(internalPrefix + "scratch")
+
+// ErrScratchClosed is returned by the methods of a Scratch after Close
+var ErrScratchClosed = errors.New("scratch space is closed")
+
+// Scratch is an ephemeral encrypted bucket for intermediate data that has to be on disk but must not
+// outlive the work it is for, such as records staged by an import. Its values are encrypted with a
+// random key that is only ever held in memory, so they are unreadable once the Scratch is gone. Close
+// deletes the bucket. A Scratch left behind by a process that exited without closing it is removed
+// the next time the db is opened, so a db file must not be opened again while another handle on it
+// still uses a Scratch.
+//
+// Writes to a Scratch are not logged in the changelog, counted in the stats or indexed for search.
+type Scratch struct {
+	bl   *BoltLocknut
+	name []byte
+
+	mu  sync.RWMutex
+	key []byte
+}
+
+// NewScratch creates an empty Scratch
+func (bl *BoltLocknut) NewScratch() (*Scratch, error) {
+	key, err := GetRandKey()
+	if err != nil {
+		return nil, err
+	}
+	id, err := bl.randomID()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Scratch{bl: bl, name: []byte(id), key: key}
+	err = bl.write(func(w *writeTx) error {
+		root, err := w.tx.CreateBucketIfNotExists(scratchBucket)
+		if err != nil {
+			return err
+		}
+		_, err = root.CreateBucket(s.name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// bucket returns the bucket of the Scratch in tx
+func (s *Scratch) bucket(tx *bbolt.Tx) *bbolt.Bucket {
+	if root := tx.Bucket(scratchBucket); root != nil {
+		return root.Bucket(s.name)
+	}
+	return nil
+}
+
+// update runs fn on the bucket of the Scratch in an update transaction
+func (s *Scratch) update(fn func(bkt *bbolt.Bucket) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.key == nil {
+		return ErrScratchClosed
+	}
+	return s.bl.write(func(w *writeTx) error {
+		bkt := s.bucket(w.tx)
+		if bkt == nil {
+			return ErrScratchClosed
+		}
+		return fn(bkt)
+	})
+}
+
+// view runs fn on the bucket of the Scratch in a read transaction
+func (s *Scratch) view(fn func(bkt *bbolt.Bucket) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.key == nil {
+		return ErrScratchClosed
+	}
+
+	var err error
+	if err = s.bl.openDB(); err != nil {
+		return err
+	}
+	defer s.bl.closeDB()
+	return s.bl.db.view(func(tx *bbolt.Tx) error {
+		bkt := s.bucket(tx)
+		if bkt == nil {
+			return ErrScratchClosed
+		}
+		return fn(bkt)
+	})
+}
+
+// Put stores data under key
+func (s *Scratch) Put(key string, data []byte) error {
+	return s.update(func(bkt *bbolt.Bucket) error {
+		stored, err := s.bl.encrypt(data, s.key)
+		if err != nil {
+			return &RecordError{Op: "encrypt", Bucket: "scratch", Key: key, Kind: ErrEncrypt, Err: err}
+		}
+		return bkt.Put([]byte(key), stored)
+	})
+}
+
+// Get returns the value stored under key, nil when there is none
+func (s *Scratch) Get(key string) ([]byte, error) {
+	var plain []byte
+	err := s.view(func(bkt *bbolt.Bucket) error {
+		stored := bkt.Get([]byte(key))
+		if stored == nil {
+			return nil
+		}
+		var err error
+		plain, err = s.decrypt(key, stored)
+		return err
+	})
+	return plain, err
+}
+
+// Delete removes key
+func (s *Scratch) Delete(key string) error {
+	return s.update(func(bkt *bbolt.Bucket) error {
+		return bkt.Delete([]byte(key))
+	})
+}
+
+// ForEach calls fn for every value in key order, stopping at the first error
+func (s *Scratch) ForEach(fn func(key string, value []byte) error) error {
+	return s.view(func(bkt *bbolt.Bucket) error {
+		return bkt.ForEach(func(k, stored []byte) error {
+			plain, err := s.decrypt(string(k), stored)
+			if err != nil {
+				return err
+			}
+			return fn(string(k), plain)
+		})
+	})
+}
+
+func (s *Scratch) decrypt(key string, stored []byte) ([]byte, error) {
+	plain, err := Decrypt(append([]byte(nil), stored...), s.key)
+	if err != nil {
+		return nil, &RecordError{Op: "decrypt", Bucket: "scratch", Key: key, Kind: ErrDecrypt, Err: err}
+	}
+	return plain, nil
+}
+
+// Close deletes the Scratch and everything stored in it. Closing it again does nothing.
+func (s *Scratch) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.key == nil {
+		return nil
+	}
+	err := s.bl.write(func(w *writeTx) error {
+		root := w.tx.Bucket(scratchBucket)
+		if root == nil || root.Bucket(s.name) == nil {
+			return nil
+		}
+		return root.DeleteBucket(s.name)
+	})
+	if err != nil {
+		return err
+	}
+	for i := range s.key {
+		s.key[i] = 0
+	}
+	s.key = nil
+	return nil
+}
+
+// sweepScratch removes the Scratch buckets left behind by handles that were not closed, their keys
+// are gone with the process that created them so nothing in them can be read anymore, and the
+// staging areas of imports that did not finish.
+func sweepScratch(tx *bbolt.Tx) error {
+	for _, name := range [][]byte{scratchBucket, stagingBucket} {
+		if tx.Bucket(name) == nil {
+			continue
+		}
+		if err := tx.DeleteBucket(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
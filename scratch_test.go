@@ -0,0 +1,110 @@
+package locknut
+
+import (
+	"bytes"
+	"errors"
+	"go.etcd.io/bbolt"
+	"os"
+	"testing"
+)
+
+func TestScratch(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("scratch_test.db")
+
+	bl, err := NewBoltLocknut("scratch_test.db", ".", []byte("secret"), false, []string{"kids"})
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+
+	s, err := bl.NewScratch()
+	if err != nil {
+		t.Fatalf("NewScratch: %s", err)
+	}
+	for _, k := range []string{"b", "a", "c"} {
+		if err = s.Put(k, []byte("staged "+k)); err != nil {
+			t.Fatalf("Put: %s", err)
+		}
+	}
+	if err = s.Delete("c"); err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+	if v, err := s.Get("a"); err != nil || string(v) != "staged a" {
+		t.Errorf("unexpected Get %q %v", v, err)
+	}
+	if v, err := s.Get("c"); err != nil || v != nil {
+		t.Errorf("expected no value for a deleted key, got %q %v", v, err)
+	}
+	var keys []string
+	s.ForEach(func(k string, v []byte) error {
+		keys = append(keys, k)
+		return nil
+	})
+	if len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+		t.Errorf("unexpected keys %v", keys)
+	}
+	if names, _ := bl.Buckets(); len(names) != 1 {
+		t.Errorf("expected the scratch bucket to be hidden, got %v", names)
+	}
+
+	raw, _ := os.ReadFile("scratch_test.db")
+	if bytes.Contains(raw, []byte("staged")) {
+		t.Error("expected scratch values to be encrypted")
+	}
+
+	if err = s.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	if err = s.Close(); err != nil {
+		t.Errorf("expected a second Close to do nothing, got %v", err)
+	}
+	if _, err = s.Get("a"); !errors.Is(err, ErrScratchClosed) {
+		t.Errorf("expected ErrScratchClosed, got %v", err)
+	}
+	if n := scratchCount(t, bl); n != 0 {
+		t.Errorf("expected Close to delete the scratch bucket, %d left", n)
+	}
+}
+
+func TestScratchSweptOnOpen(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("scratch_test.db")
+
+	bl, err := NewBoltLocknut("scratch_test.db", ".", []byte("secret"), false, nil)
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	s, err := bl.NewScratch()
+	if err != nil {
+		t.Fatalf("NewScratch: %s", err)
+	}
+	if err = s.Put("a", []byte("left behind")); err != nil {
+		t.Fatalf("Put: %s", err)
+	}
+
+	// the process "crashes" without closing s, the next open removes it
+	bl, err = NewBoltLocknut("scratch_test.db", ".", []byte("secret"), false, nil)
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if n := scratchCount(t, bl); n != 0 {
+		t.Errorf("expected the scratch bucket to be swept, %d left", n)
+	}
+}
+
+func scratchCount(t *testing.T, bl *BoltLocknut) int {
+	t.Helper()
+	if err := bl.openDB(); err != nil {
+		t.Fatal(err)
+	}
+	defer bl.closeDB()
+
+	n := 0
+	bl.db.view(func(tx *bbolt.Tx) error {
+		if root := tx.Bucket(scratchBucket); root != nil {
+			n = root.Stats().BucketN - 1
+		}
+		return nil
+	})
+	return n
+}
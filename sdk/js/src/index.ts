@@ -0,0 +1,81 @@
+// Reference implementation of the locknut record encryption, so browser and node clients can read and
+// write the values a locknut db stores. It mirrors deriveSecret, Encrypt and Decrypt of the Go
+// package and is tested against the vectors in testdata/vectors.json, generated by the Go tests.
+//
+// A stored value is a 12 byte random nonce followed by the AES-GCM sealed plaintext and its 16 byte
+// tag. The AES key is the secret itself when it is at least 32 bytes long, otherwise its SHA-256
+// hash. Values saved with Save are JSON, SaveBytes stores the bytes as they are.
+
+const nonceSize = 12
+const tagSize = 16
+
+/** DecryptError is thrown when a stored value fails authentication with the key */
+export class DecryptError extends Error {
+  constructor(message = "record fails authentication, the secret is wrong or the record was modified") {
+    super(message)
+    this.name = "DecryptError"
+  }
+}
+
+const encoder = new TextEncoder()
+const decoder = new TextDecoder()
+
+function subtle(): SubtleCrypto {
+  return globalThis.crypto.subtle
+}
+
+function bytes(secret: Uint8Array | string): Uint8Array {
+  return typeof secret === "string" ? encoder.encode(secret) : secret
+}
+
+/** deriveKeyBytes returns the raw AES key for secret, like deriveSecret in Go */
+export async function deriveKeyBytes(secret: Uint8Array | string): Promise<Uint8Array> {
+  const raw = bytes(secret)
+  if (raw.length < 32) {
+    return new Uint8Array(await subtle().digest("SHA-256", raw))
+  }
+  return raw
+}
+
+/** deriveKey returns the AES-GCM key for secret */
+export async function deriveKey(secret: Uint8Array | string): Promise<CryptoKey> {
+  const raw = await deriveKeyBytes(secret)
+  return subtle().importKey("raw", raw, { name: "AES-GCM" }, false, ["encrypt", "decrypt"])
+}
+
+/** encrypt seals plain with key into the stored form of a value */
+export async function encrypt(plain: Uint8Array, key: CryptoKey): Promise<Uint8Array> {
+  const nonce = globalThis.crypto.getRandomValues(new Uint8Array(nonceSize))
+  const sealed = new Uint8Array(await subtle().encrypt({ name: "AES-GCM", iv: nonce }, key, plain))
+  const stored = new Uint8Array(nonceSize + sealed.length)
+  stored.set(nonce)
+  stored.set(sealed, nonceSize)
+  return stored
+}
+
+/** decrypt opens a stored value with key, throwing DecryptError if it fails authentication */
+export async function decrypt(stored: Uint8Array, key: CryptoKey): Promise<Uint8Array> {
+  if (stored.length < nonceSize + tagSize) {
+    throw new DecryptError("ciphertext too short")
+  }
+  try {
+    const plain = await subtle().decrypt(
+      { name: "AES-GCM", iv: stored.subarray(0, nonceSize) },
+      key,
+      stored.subarray(nonceSize),
+    )
+    return new Uint8Array(plain)
+  } catch {
+    throw new DecryptError()
+  }
+}
+
+/** encryptJSON stores value as Save does */
+export async function encryptJSON(value: unknown, key: CryptoKey): Promise<Uint8Array> {
+  return encrypt(encoder.encode(JSON.stringify(value)), key)
+}
+
+/** decryptJSON reads a value stored by Save */
+export async function decryptJSON<T = unknown>(stored: Uint8Array, key: CryptoKey): Promise<T> {
+  return JSON.parse(decoder.decode(await decrypt(stored, key))) as T
+}
@@ -0,0 +1,43 @@
+import assert from "node:assert/strict"
+import { readFileSync } from "node:fs"
+import { test } from "node:test"
+import { DecryptError, decrypt, decryptJSON, deriveKey, deriveKeyBytes, encrypt, encryptJSON } from "../src/index.js"
+
+interface Vector {
+  name: string
+  secret: string
+  key: string
+  plaintext: string
+  stored: string
+  valid: boolean
+}
+
+// the vectors are regenerated with go test -run TestVectors -update-vectors from the repo root
+const vectors: Vector[] = JSON.parse(readFileSync(new URL("../../testdata/vectors.json", import.meta.url), "utf8"))
+
+const hex = (b: Uint8Array) => Buffer.from(b).toString("hex")
+const fromHex = (s: string) => new Uint8Array(Buffer.from(s, "hex"))
+const fromBase64 = (s: string) => new Uint8Array(Buffer.from(s, "base64"))
+
+for (const v of vectors) {
+  test(v.name, async () => {
+    assert.equal(hex(await deriveKeyBytes(fromHex(v.secret))), v.key)
+
+    const key = await deriveKey(fromHex(v.secret))
+    if (!v.valid) {
+      await assert.rejects(decrypt(fromBase64(v.stored), key), DecryptError)
+      return
+    }
+    assert.equal(hex(await decrypt(fromBase64(v.stored), key)), v.plaintext)
+
+    const stored = await encrypt(fromHex(v.plaintext), key)
+    assert.equal(hex(await decrypt(stored, key)), v.plaintext)
+  })
+}
+
+test("json values round trip", async () => {
+  const key = await deriveKey("secret")
+  const stored = await encryptJSON({ name: "taylor" }, key)
+  assert.deepEqual(await decryptJSON(stored, key), { name: "taylor" })
+  await assert.rejects(decrypt(stored, await deriveKey("other secret")), DecryptError)
+})
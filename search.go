@@ -0,0 +1,510 @@
+package locknut
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/taybart/log"
+	"go.etcd.io/bbolt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The buckets of the search index db. Both are keyed by HMACs and hold encrypted values, so the
+// index file reveals neither terms nor record keys.
+var (
+	// termsBucket maps a term id to the encrypted postings of the term, record key to term frequency
+	termsBucket = []byte("terms")
+	// docsBucket maps a record id to the encrypted list of term ids the record contributes, which is
+	// what lets an update or delete take the old terms out of the index
+	docsBucket = []byte("docs")
+)
+
+// The kinds of terms kept in the index
+const (
+	tokenTerm = "t" // a word of a field, for Search
+	gramTerm  = "g" // a part of a word of a field, for Search on fields with Analyzer.NGram
+	valueTerm = "v" // the whole value of a field, for Lookup
+)
+
+// IndexField is a json field of the records in Bucket made searchable. Nested fields are written with
+// dots, such as "address.city". Arrays are indexed element by element.
+type IndexField struct {
+	Bucket   string
+	Field    string
+	Analyzer Analyzer
+}
+
+// WithSearchIndex maintains an encrypted search index of fields in a separate db at path, so the main
+// db stays compact. The index is updated after every committed write and built from scratch when the
+// file does not exist yet; use RebuildSearchIndex after records were written without the option.
+func WithSearchIndex(path string, fields ...IndexField) Option {
+	return func(bl *BoltLocknut) {
+		bl.searchPath = path
+		bl.searchFields = append(bl.searchFields, fields...)
+	}
+}
+
+// indexOp is a committed write to a record of an indexed bucket, stored is nil for a delete
+type indexOp struct {
+	bucket, key string
+	stored      []byte
+}
+
+// postings maps record keys to the number of times the term appears in the record
+type postings map[string]int
+
+func (bl *BoltLocknut) searchMACKey() []byte {
+	return HKDF(bl.secret, nil, []byte("locknut search mac"), 32)
+}
+
+func (bl *BoltLocknut) searchEncKey() []byte {
+	return HKDF(bl.secret, nil, []byte("locknut search encryption"), 32)
+}
+
+// indexedFields returns the fields indexed for bucket
+func (bl *BoltLocknut) indexedFields(bucket string) []IndexField {
+	var fields []IndexField
+	for _, f := range bl.searchFields {
+		if f.Bucket == bucket {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// indexedField returns the IndexField of field in bucket and whether it is indexed
+func (bl *BoltLocknut) indexedField(bucket, field string) (IndexField, bool) {
+	for _, f := range bl.indexedFields(bucket) {
+		if f.Field == field {
+			return f, true
+		}
+	}
+	return IndexField{}, false
+}
+
+// termID is the blind index of a term, the hex HMAC over its kind, location and text
+func termID(mac []byte, kind, bucket, field, text string) string {
+	h := hmac.New(sha256.New, mac)
+	h.Write([]byte(kind + "\x00" + bucket + "\x00" + field + "\x00" + text))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func docID(mac []byte, bucket, key string) []byte {
+	h := hmac.New(sha256.New, mac)
+	h.Write([]byte("doc\x00" + bucket + "\x00" + key))
+	return h.Sum(nil)
+}
+
+// fieldValues returns the scalar values found at the dotted path of doc as strings
+func fieldValues(doc interface{}, path []string) []string {
+	switch d := doc.(type) {
+	case map[string]interface{}:
+		if len(path) == 0 {
+			return nil
+		}
+		return fieldValues(d[path[0]], path[1:])
+	case []interface{}:
+		var values []string
+		for _, item := range d {
+			values = append(values, fieldValues(item, path)...)
+		}
+		return values
+	case nil:
+		return nil
+	}
+	if len(path) != 0 {
+		return nil
+	}
+	if s, ok := doc.(string); ok {
+		return []string{s}
+	}
+	return []string{fmt.Sprint(doc)}
+}
+
+// docTerms returns the term ids of a record with their frequencies
+func (bl *BoltLocknut) docTerms(mac []byte, bucket string, plain []byte) map[string]int {
+	terms := make(map[string]int)
+	var doc interface{}
+	if json.Unmarshal(plain, &doc) != nil {
+		return terms
+	}
+
+	for _, f := range bl.indexedFields(bucket) {
+		for _, v := range fieldValues(doc, strings.Split(f.Field, ".")) {
+			terms[termID(mac, valueTerm, bucket, f.Field, f.Analyzer.value(v))] = 1
+			for _, word := range f.Analyzer.words(v) {
+				terms[termID(mac, tokenTerm, bucket, f.Field, word)]++
+				if f.Analyzer.NGram < 2 {
+					continue
+				}
+				for _, gram := range ngrams(word, f.Analyzer.NGram) {
+					terms[termID(mac, gramTerm, bucket, f.Field, gram)]++
+				}
+			}
+		}
+	}
+	return terms
+}
+
+// openSearchDB opens the search index db, failing fast if another process holds it for too long
+func (bl *BoltLocknut) openSearchDB(readOnly bool) (*bbolt.DB, error) {
+	return bbolt.Open(bl.searchPath, 0600, &bbolt.Options{ReadOnly: readOnly, Timeout: time.Second})
+}
+
+func readSealed(b *bbolt.Bucket, key []byte, encKey []byte, v interface{}) (bool, error) {
+	stored := b.Get(key)
+	if stored == nil {
+		return false, nil
+	}
+	plain, err := Decrypt(append([]byte(nil), stored...), encKey)
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(plain, v)
+}
+
+func writeSealed(b *bbolt.Bucket, key []byte, encKey []byte, v interface{}) error {
+	plain, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	sealed, err := Encrypt(plain, encKey)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, sealed)
+}
+
+// reindex replaces the terms of one record in the index with terms, which may be empty
+func reindex(tx *bbolt.Tx, mac, encKey []byte, bucket, key string, terms map[string]int) error {
+	termsB, err := tx.CreateBucketIfNotExists(termsBucket)
+	if err != nil {
+		return err
+	}
+	docsB, err := tx.CreateBucketIfNotExists(docsBucket)
+	if err != nil {
+		return err
+	}
+
+	id := docID(mac, bucket, key)
+	var old []string
+	if _, err = readSealed(docsB, id, encKey, &old); err != nil {
+		return err
+	}
+
+	for _, t := range old {
+		if _, ok := terms[t]; ok {
+			continue
+		}
+		p := postings{}
+		if _, err = readSealed(termsB, []byte(t), encKey, &p); err != nil {
+			return err
+		}
+		delete(p, key)
+		if len(p) == 0 {
+			err = termsB.Delete([]byte(t))
+		} else {
+			err = writeSealed(termsB, []byte(t), encKey, p)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	ids := make([]string, 0, len(terms))
+	for t, tf := range terms {
+		p := postings{}
+		if _, err = readSealed(termsB, []byte(t), encKey, &p); err != nil {
+			return err
+		}
+		p[key] = tf
+		if err = writeSealed(termsB, []byte(t), encKey, p); err != nil {
+			return err
+		}
+		ids = append(ids, t)
+	}
+
+	if len(ids) == 0 {
+		return docsB.Delete(id)
+	}
+	return writeSealed(docsB, id, encKey, ids)
+}
+
+// updateSearchIndex applies committed writes to the search index. Like stats the index is derived
+// data, so a failure is logged and can be repaired with RebuildSearchIndex.
+func (bl *BoltLocknut) updateSearchIndex(ops []indexOp) {
+	if len(ops) == 0 {
+		return
+	}
+
+	db, err := bl.openSearchDB(false)
+	if err != nil {
+		log.Error("updateSearchIndex open", err)
+		return
+	}
+	defer db.Close()
+
+	mac, encKey := bl.searchMACKey(), bl.searchEncKey()
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, op := range ops {
+			terms := map[string]int{}
+			if op.stored != nil {
+				plain, err := bl.decrypt(op.bucket, op.key, op.stored)
+				if err != nil {
+					return err
+				}
+				terms = bl.docTerms(mac, op.bucket, plain)
+			}
+			if err := reindex(tx, mac, encKey, op.bucket, op.key, terms); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error("updateSearchIndex", err)
+	}
+}
+
+// RebuildSearchIndex discards the search index and indexes every record of the indexed buckets again
+func (bl *BoltLocknut) RebuildSearchIndex() error {
+	if bl.searchPath == "" {
+		return ErrNoSearchIndex
+	}
+	if bl.readOnly {
+		return ErrReadOnly
+	}
+
+	var err error
+	if err = bl.openDB(); err != nil {
+		return err
+	}
+	defer bl.closeDB()
+
+	mac := bl.searchMACKey()
+	allTerms := make(map[string]postings)
+	docs := make(map[string][]string)
+
+	err = bl.db.view(func(tx *bbolt.Tx) error {
+		seen := make(map[string]bool)
+		for _, f := range bl.searchFields {
+			if seen[f.Bucket] {
+				continue
+			}
+			seen[f.Bucket] = true
+
+			bkt := tx.Bucket([]byte(f.Bucket))
+			if bkt == nil {
+				continue
+			}
+			err := bkt.ForEach(func(k, v []byte) error {
+				if v == nil {
+					return nil
+				}
+				plain, err := bl.decrypt(f.Bucket, string(k), v)
+				if err != nil {
+					return err
+				}
+				id := string(docID(mac, f.Bucket, string(k)))
+				for t, tf := range bl.docTerms(mac, f.Bucket, plain) {
+					if allTerms[t] == nil {
+						allTerms[t] = postings{}
+					}
+					allTerms[t][string(k)] = tf
+					docs[id] = append(docs[id], t)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	db, err := bl.openSearchDB(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	encKey := bl.searchEncKey()
+	return db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{termsBucket, docsBucket} {
+			if tx.Bucket(name) != nil {
+				if err := tx.DeleteBucket(name); err != nil {
+					return err
+				}
+			}
+		}
+		termsB, err := tx.CreateBucket(termsBucket)
+		if err != nil {
+			return err
+		}
+		docsB, err := tx.CreateBucket(docsBucket)
+		if err != nil {
+			return err
+		}
+
+		for t, p := range allTerms {
+			if err = writeSealed(termsB, []byte(t), encKey, p); err != nil {
+				return err
+			}
+		}
+		for id, ids := range docs {
+			if err = writeSealed(docsB, []byte(id), encKey, ids); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// initSearchIndex builds the index when its file does not exist yet
+func (bl *BoltLocknut) initSearchIndex() error {
+	if _, err := os.Stat(bl.searchPath); err == nil {
+		return nil
+	}
+	return bl.RebuildSearchIndex()
+}
+
+// readPostings returns the postings of each term id, empty for unknown terms
+func (bl *BoltLocknut) readPostings(ids []string) ([]postings, error) {
+	db, err := bl.openSearchDB(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	encKey := bl.searchEncKey()
+	results := make([]postings, len(ids))
+	err = db.View(func(tx *bbolt.Tx) error {
+		termsB := tx.Bucket(termsBucket)
+		for i, id := range ids {
+			results[i] = postings{}
+			if termsB == nil {
+				continue
+			}
+			if _, err := readSealed(termsB, []byte(id), encKey, &results[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return results, err
+}
+
+// queryTerms returns the term ids a record must contain to match query. On n-gram fields words of at
+// least NGram characters are matched by their n-grams, so they also match inside longer words.
+func queryTerms(mac []byte, f IndexField, query string) []string {
+	var ids []string
+	for _, word := range f.Analyzer.words(query) {
+		var grams []string
+		if f.Analyzer.NGram >= 2 {
+			grams = ngrams(word, f.Analyzer.NGram)
+		}
+		if len(grams) == 0 {
+			ids = append(ids, termID(mac, tokenTerm, f.Bucket, f.Field, word))
+			continue
+		}
+		for _, gram := range grams {
+			ids = append(ids, termID(mac, gramTerm, f.Bucket, f.Field, gram))
+		}
+	}
+	return ids
+}
+
+// match returns the records of bucket whose field contains every word of query, with the summed
+// frequency of the query terms in each record
+func (bl *BoltLocknut) match(bucket, field, query string) (map[string]int, error) {
+	if bl.searchPath == "" {
+		return nil, ErrNoSearchIndex
+	}
+	if err := bl.hold(); err != nil {
+		return nil, err
+	}
+	defer bl.release()
+	f, ok := bl.indexedField(bucket, field)
+	if !ok {
+		return nil, ErrNotIndexed
+	}
+
+	matches := make(map[string]int)
+	ids := queryTerms(bl.searchMACKey(), f, query)
+	if len(ids) == 0 {
+		return matches, nil
+	}
+	lists, err := bl.readPostings(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, tf := range lists[0] {
+		all := true
+		for _, p := range lists[1:] {
+			n, ok := p[k]
+			if !ok {
+				all = false
+				break
+			}
+			tf += n
+		}
+		if all {
+			matches[k] = tf
+		}
+	}
+	return matches, nil
+}
+
+// Search returns the keys of the records in bucket whose field contains every word of query, sorted.
+// Words are compared after the field's Analyzer has been applied to both sides.
+func (bl *BoltLocknut) Search(bucket, field, query string) ([]string, error) {
+	matches, err := bl.match(bucket, field, query)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(matches))
+	for k := range matches {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Lookup returns the keys of the records in bucket whose field equals value, ignoring surrounding
+// space and, unless the field's Analyzer says otherwise, case, sorted. It is the blind index
+// counterpart of a secondary index.
+func (bl *BoltLocknut) Lookup(bucket, field, value string) ([]string, error) {
+	if bl.searchPath == "" {
+		return nil, ErrNoSearchIndex
+	}
+	if err := bl.hold(); err != nil {
+		return nil, err
+	}
+	defer bl.release()
+	f, ok := bl.indexedField(bucket, field)
+	if !ok {
+		return nil, ErrNotIndexed
+	}
+
+	lists, err := bl.readPostings([]string{termID(bl.searchMACKey(), valueTerm, bucket, field, f.Analyzer.value(value))})
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(lists[0]))
+	for k := range lists[0] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
@@ -0,0 +1,107 @@
+package locknut
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+)
+
+type contact struct {
+	Name    string   `json:"name"`
+	Email   string   `json:"email"`
+	Tags    []string `json:"tags"`
+	Address struct {
+		City string `json:"city"`
+	} `json:"address"`
+}
+
+func TestSearchIndex(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("search_test.db")
+	defer os.Remove("search_test.idx")
+
+	fields := []IndexField{
+		{Bucket: "contacts", Field: "name"},
+		{Bucket: "contacts", Field: "email"},
+		{Bucket: "contacts", Field: "tags"},
+		{Bucket: "contacts", Field: "address.city"},
+	}
+	bl, err := NewBoltLocknut("search_test.db", ".", []byte("secret"), false, []string{"contacts"},
+		WithSearchIndex("search_test.idx", fields...))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+
+	var ada, alan contact
+	ada.Name, ada.Email, ada.Tags, ada.Address.City = "Ada Lovelace", "ada@example.com", []string{"math"}, "London"
+	alan.Name, alan.Email, alan.Tags, alan.Address.City = "Alan Turing", "alan@example.com", []string{"math", "crypto"}, "Wilmslow"
+	if err = bl.Save("contacts", "ada", ada); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if err = bl.Save("contacts", "alan", alan); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+
+	check := func(name string, got []string, err error, want ...string) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if want == nil {
+			want = []string{}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %v, got %v", name, want, got)
+		}
+	}
+
+	keys, err := bl.Search("contacts", "tags", "math")
+	check("tags", keys, err, "ada", "alan")
+	keys, err = bl.Search("contacts", "name", "TURING alan")
+	check("name", keys, err, "alan")
+	keys, err = bl.Search("contacts", "address.city", "london")
+	check("city", keys, err, "ada")
+	keys, err = bl.Lookup("contacts", "email", " Ada@Example.com")
+	check("email", keys, err, "ada")
+	if _, err = bl.Search("contacts", "phone", "1"); err != ErrNotIndexed {
+		t.Errorf("expected ErrNotIndexed, got %v", err)
+	}
+
+	// updates and deletes take the old terms out
+	ada.Address.City = "Paris"
+	if err = bl.Save("contacts", "ada", ada); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	keys, err = bl.Search("contacts", "address.city", "london")
+	check("moved", keys, err)
+	if err = bl.Delete("contacts", "alan"); err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+	keys, err = bl.Search("contacts", "tags", "math")
+	check("deleted", keys, err, "ada")
+
+	// the index file holds neither terms nor keys
+	idx, err := os.ReadFile("search_test.idx")
+	if err != nil {
+		t.Fatalf("ReadFile: %s", err)
+	}
+	for _, s := range []string{"paris", "Paris", "math", "ada"} {
+		if bytes.Contains(idx, []byte(s)) {
+			t.Errorf("index contains %q", s)
+		}
+	}
+
+	os.Remove("search_test.idx")
+	if err = bl.RebuildSearchIndex(); err != nil {
+		t.Fatalf("RebuildSearchIndex: %s", err)
+	}
+	keys, err = bl.Search("contacts", "address.city", "paris")
+	check("rebuilt", keys, err, "ada")
+
+	if err = bl.RotateKey([]byte("new secret")); err != nil {
+		t.Fatalf("RotateKey: %s", err)
+	}
+	keys, err = bl.Lookup("contacts", "email", "ada@example.com")
+	check("rotated", keys, err, "ada")
+}
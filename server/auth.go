@@ -0,0 +1,150 @@
+package server
+
+import (
+	"crypto/subtle"
+	"crypto/x509"
+	"errors"
+	"github.com/taybart/locknut"
+	"net/http"
+	"strings"
+)
+
+// ErrUnauthenticated is returned by an Authenticator that cannot identify the caller
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Identity is the authenticated caller of a request
+type Identity struct {
+	Subject string
+	Groups  []string
+	// Actor is the service that authenticated when it acts on behalf of Subject, and empty otherwise
+	Actor string
+}
+
+// OnBehalfOfHeader carries the subject a privileged service acts for, see Impersonation
+const OnBehalfOfHeader = "Locknut-On-Behalf-Of"
+
+// ErrImpersonationDenied is returned when the caller may not act on behalf of the asserted subject
+var ErrImpersonationDenied = errors.New("impersonation denied")
+
+// Authenticator identifies the caller of a request
+type Authenticator interface {
+	Authenticate(r *http.Request) (Identity, error)
+}
+
+// AuthenticatorFunc adapts a function to an Authenticator
+type AuthenticatorFunc func(r *http.Request) (Identity, error)
+
+// Authenticate calls f(r)
+func (f AuthenticatorFunc) Authenticate(r *http.Request) (Identity, error) {
+	return f(r)
+}
+
+// Chain tries each authenticator in turn and returns the first identity found
+func Chain(auths ...Authenticator) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (Identity, error) {
+		for _, a := range auths {
+			if id, err := a.Authenticate(r); err == nil {
+				return id, nil
+			}
+		}
+		return Identity{}, ErrUnauthenticated
+	})
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header
+func bearerToken(r *http.Request) (string, bool) {
+	h := r.Header.Get("Authorization")
+	if len(h) < 7 || !strings.EqualFold(h[:7], "bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(h[7:]), true
+}
+
+// StaticTokens authenticates bearer tokens against a fixed map of token to identity
+func StaticTokens(tokens map[string]Identity) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (Identity, error) {
+		token, ok := bearerToken(r)
+		if !ok {
+			return Identity{}, ErrUnauthenticated
+		}
+		for t, id := range tokens {
+			if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+				return id, nil
+			}
+		}
+		return Identity{}, ErrUnauthenticated
+	})
+}
+
+// PolicyTokens authenticates bearer tokens against the tokens of p, see locknut.Policy.AddToken, the
+// identity being the principal of the token
+func PolicyTokens(p *locknut.Policy) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (Identity, error) {
+		token, ok := bearerToken(r)
+		if !ok {
+			return Identity{}, ErrUnauthenticated
+		}
+		principal, ok := p.Principal(token)
+		if !ok {
+			return Identity{}, ErrUnauthenticated
+		}
+		return Identity{Subject: principal}, nil
+	})
+}
+
+// BasicAuth authenticates http basic credentials with check, which returns the identity of a valid
+// user and password
+func BasicAuth(check func(user, password string) (Identity, bool)) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (Identity, error) {
+		user, password, ok := r.BasicAuth()
+		if !ok {
+			return Identity{}, ErrUnauthenticated
+		}
+		if id, ok := check(user, password); ok {
+			return id, nil
+		}
+		return Identity{}, ErrUnauthenticated
+	})
+}
+
+// ClientCert maps the verified client certificate of a mutual TLS connection to an identity. The
+// http.Server must verify client certificates, for example with tls.RequireAndVerifyClientCert, only
+// verified chains are considered. A nil mapping uses the certificate's common name as the subject
+// and its organizational units as the groups.
+func ClientCert(mapping func(cert *x509.Certificate) (Identity, error)) Authenticator {
+	if mapping == nil {
+		mapping = func(cert *x509.Certificate) (Identity, error) {
+			if cert.Subject.CommonName == "" {
+				return Identity{}, ErrUnauthenticated
+			}
+			return Identity{Subject: cert.Subject.CommonName, Groups: cert.Subject.OrganizationalUnit}, nil
+		}
+	}
+	return AuthenticatorFunc(func(r *http.Request) (Identity, error) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+			return Identity{}, ErrUnauthenticated
+		}
+		return mapping(r.TLS.VerifiedChains[0][0])
+	})
+}
+
+// Impersonation lets privileged callers act on behalf of end users. The caller is authenticated with
+// auth, and when the request carries an OnBehalfOfHeader, allowed decides whether that caller may
+// assert the subject. The returned identity is the asserted subject, without groups, with the caller
+// recorded as its Actor, so access checks apply to the end user and audit events show both.
+func Impersonation(auth Authenticator, allowed func(actor Identity, subject string) bool) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (Identity, error) {
+		actor, err := auth.Authenticate(r)
+		if err != nil {
+			return Identity{}, err
+		}
+		subject := strings.TrimSpace(r.Header.Get(OnBehalfOfHeader))
+		if subject == "" {
+			return actor, nil
+		}
+		if actor.Actor != "" || allowed == nil || !allowed(actor, subject) {
+			return Identity{}, ErrImpersonationDenied
+		}
+		return Identity{Subject: subject, Actor: actor.Subject}, nil
+	})
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/taybart/locknut"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStaticTokensAndBasic(t *testing.T) {
+	auth := Chain(
+		StaticTokens(map[string]Identity{"t0ken": {Subject: "ci"}}),
+		BasicAuth(func(user, password string) (Identity, bool) {
+			return Identity{Subject: user}, user == "taylor" && password == "hunter2"
+		}),
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer t0ken")
+	if id, err := auth.Authenticate(req); err != nil || id.Subject != "ci" {
+		t.Errorf("expected ci, got %v %v", id, err)
+	}
+
+	req.Header.Set("Authorization", "Bearer wrong")
+	if _, err := auth.Authenticate(req); err != ErrUnauthenticated {
+		t.Errorf("expected unauthenticated, got %v", err)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.SetBasicAuth("taylor", "hunter2")
+	if id, err := auth.Authenticate(req); err != nil || id.Subject != "taylor" {
+		t.Errorf("expected taylor, got %v %v", id, err)
+	}
+
+	req.SetBasicAuth("taylor", "nope")
+	if _, err := auth.Authenticate(req); err != ErrUnauthenticated {
+		t.Errorf("expected unauthenticated, got %v", err)
+	}
+}
+
+func TestPolicyTokens(t *testing.T) {
+	policy := locknut.NewPolicy()
+	policy.AddToken("t0ken", "alice")
+	auth := PolicyTokens(policy)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if _, err := auth.Authenticate(req); err != ErrUnauthenticated {
+		t.Errorf("expected unauthenticated without a token, got %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer t0ken")
+	if id, err := auth.Authenticate(req); err != nil || id.Subject != "alice" {
+		t.Errorf("expected alice, got %v %v", id, err)
+	}
+	req.Header.Set("Authorization", "Bearer wrong")
+	if _, err := auth.Authenticate(req); err != ErrUnauthenticated {
+		t.Errorf("expected unauthenticated, got %v", err)
+	}
+}
+
+func TestClientCert(t *testing.T) {
+	auth := ClientCert(nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if _, err := auth.Authenticate(req); err != ErrUnauthenticated {
+		t.Errorf("expected unauthenticated without tls, got %v", err)
+	}
+
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "svc-a", OrganizationalUnit: []string{"ops"}}}
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	if _, err := auth.Authenticate(req); err != ErrUnauthenticated {
+		t.Errorf("expected unverified certificate to be rejected, got %v", err)
+	}
+
+	req.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+	id, err := auth.Authenticate(req)
+	if err != nil {
+		t.Fatalf("Authenticate: %s", err)
+	}
+	if id.Subject != "svc-a" || len(id.Groups) != 1 || id.Groups[0] != "ops" {
+		t.Errorf("unexpected identity %v", id)
+	}
+}
+
+func TestImpersonation(t *testing.T) {
+	auth := Impersonation(
+		StaticTokens(map[string]Identity{"svc": {Subject: "backend"}, "usr": {Subject: "mallory"}}),
+		func(actor Identity, subject string) bool { return actor.Subject == "backend" },
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer svc")
+	if id, err := auth.Authenticate(req); err != nil || id.Subject != "backend" || id.Actor != "" {
+		t.Errorf("expected backend itself, got %v %v", id, err)
+	}
+
+	req.Header.Set(OnBehalfOfHeader, "taylor")
+	id, err := auth.Authenticate(req)
+	if err != nil {
+		t.Fatalf("Authenticate: %s", err)
+	}
+	if id.Subject != "taylor" || id.Actor != "backend" {
+		t.Errorf("unexpected identity %v", id)
+	}
+
+	req.Header.Set("Authorization", "Bearer usr")
+	if _, err = auth.Authenticate(req); err != ErrImpersonationDenied {
+		t.Errorf("expected impersonation denied, got %v", err)
+	}
+}
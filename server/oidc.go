@@ -0,0 +1,390 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OIDCConfig configures validation of OpenID Connect id or access tokens sent as bearer tokens
+type OIDCConfig struct {
+	// Issuer must match the iss claim. Unless JWKSURL is set, the keys are discovered from
+	// Issuer + "/.well-known/openid-configuration".
+	Issuer string
+	// Audience must be one of the aud claim values
+	Audience string
+	// JWKSURL is the location of the issuer's signing keys
+	JWKSURL string
+	// GroupsClaim names the claim holding the groups of the identity, "groups" by default
+	GroupsClaim string
+	// Leeway is the clock skew allowed when checking exp and nbf
+	Leeway time.Duration
+	// KeyTTL is how long fetched signing keys are used before the key set is fetched again, so keys
+	// the issuer rotated out stop validating, an hour by default
+	KeyTTL time.Duration
+	// Client is used to fetch the discovery document and keys, a client with a timeout of
+	// defaultOIDCTimeout by default
+	Client *http.Client
+}
+
+// defaultOIDCTimeout bounds the requests to the issuer of an OIDCConfig without a Client
+const defaultOIDCTimeout = 10 * time.Second
+
+// ErrInvalidToken is returned for tokens that fail validation
+var ErrInvalidToken = errors.New("invalid token")
+
+// minRSABits is the size of the smallest RSA signing key accepted
+const minRSABits = 2048
+
+type oidc struct {
+	cfg OIDCConfig
+
+	mu       sync.Mutex
+	keys     map[string]crypto.PublicKey
+	fetched  time.Time     // when keys were fetched
+	tried    time.Time     // when the key set was last fetched, successfully or not
+	fetching chan struct{} // closed when the fetch in progress ends, nil when there is none
+}
+
+// OIDC authenticates JWT bearer tokens signed by an OpenID Connect provider with RS256, RS384,
+// RS512, ES256 on P-256 or ES384 on P-384. RSA keys must have at least 2048 bits. The subject is the
+// sub claim. Signing keys are cached for KeyTTL and refetched when a token names an unknown key id.
+func OIDC(cfg OIDCConfig) Authenticator {
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	if cfg.KeyTTL <= 0 {
+		cfg.KeyTTL = time.Hour
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: defaultOIDCTimeout}
+	}
+	return &oidc{cfg: cfg}
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+func (o *oidc) Authenticate(r *http.Request) (Identity, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return Identity{}, ErrUnauthenticated
+	}
+	claims, err := o.verify(token)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return Identity{}, ErrInvalidToken
+	}
+	id := Identity{Subject: sub}
+	if groups, ok := claims[o.cfg.GroupsClaim].([]interface{}); ok {
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				id.Groups = append(id.Groups, s)
+			}
+		}
+	}
+	return id, nil
+}
+
+// verify checks the signature and standard claims of token and returns its claims
+func (o *oidc) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	key, err := o.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err = verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if err = o.checkClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (o *oidc) checkClaims(claims map[string]interface{}) error {
+	now := time.Now()
+	if iss, _ := claims["iss"].(string); iss != o.cfg.Issuer {
+		return fmt.Errorf("%w: issuer %q", ErrInvalidToken, iss)
+	}
+
+	audOK := false
+	switch aud := claims["aud"].(type) {
+	case string:
+		audOK = aud == o.cfg.Audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == o.cfg.Audience {
+				audOK = true
+			}
+		}
+	}
+	if !audOK {
+		return fmt.Errorf("%w: audience", ErrInvalidToken)
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(o.cfg.Leeway)) {
+		return fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(o.cfg.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return fmt.Errorf("%w: not yet valid", ErrInvalidToken)
+	}
+	return nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	var h crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		h = crypto.SHA256
+	case "RS384", "ES384":
+		h = crypto.SHA384
+	case "RS512":
+		h = crypto.SHA512
+	default:
+		return fmt.Errorf("%w: unsupported alg %q", ErrInvalidToken, alg)
+	}
+
+	var digest []byte
+	switch h {
+	case crypto.SHA256:
+		sum := sha256.Sum256(signed)
+		digest = sum[:]
+	case crypto.SHA384:
+		sum := sha512.Sum384(signed)
+		digest = sum[:]
+	case crypto.SHA512:
+		sum := sha512.Sum512(signed)
+		digest = sum[:]
+	}
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if alg[0] != 'R' || k.N.BitLen() < minRSABits {
+			return ErrInvalidToken
+		}
+		if rsa.VerifyPKCS1v15(k, h, digest, sig) != nil {
+			return ErrInvalidToken
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		// the alg names the curve as well as the hash, a token must not pick another curve than its key
+		var curve elliptic.Curve
+		switch alg {
+		case "ES256":
+			curve = elliptic.P256()
+		case "ES384":
+			curve = elliptic.P384()
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if curve == nil || k.Curve != curve || len(sig) != 2*size {
+			return ErrInvalidToken
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return ErrInvalidToken
+		}
+		return nil
+	}
+	return ErrInvalidToken
+}
+
+// key returns the signing key kid, refetching the key set once it is older than KeyTTL, and at most
+// once a minute for unknown ids or after a failed fetch. Expired keys are never used, not even when
+// the refetch fails, while a failed refetch for an unknown id keeps the keys that have not expired.
+// The fetch runs outside the lock, concurrent calls needing one wait for it.
+func (o *oidc) key(kid string) (crypto.PublicKey, error) {
+	o.mu.Lock()
+	for {
+		if k, ok := o.cached(kid); ok {
+			o.mu.Unlock()
+			return k, nil
+		}
+		if o.fetching == nil {
+			break
+		}
+		done := o.fetching
+		o.mu.Unlock()
+		<-done
+		o.mu.Lock()
+	}
+
+	// an expired key set is fetched again right away, unless that already failed within the minute
+	expired := time.Since(o.fetched) >= o.cfg.KeyTTL
+	if retry := expired && !o.tried.After(o.fetched); !retry && time.Since(o.tried) < time.Minute {
+		o.mu.Unlock()
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+	}
+	done := make(chan struct{})
+	o.fetching, o.tried = done, time.Now()
+	o.mu.Unlock()
+
+	keys, err := o.fetchKeys()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.fetching = nil
+	close(done)
+	if err == nil {
+		o.keys, o.fetched = keys, o.tried
+	}
+	if k, ok := o.cached(kid); ok {
+		return k, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+}
+
+// cached returns the signing key kid if it is known and has not expired, with o locked
+func (o *oidc) cached(kid string) (crypto.PublicKey, bool) {
+	k, ok := o.keys[kid]
+	if !ok || time.Since(o.fetched) >= o.cfg.KeyTTL {
+		return nil, false
+	}
+	return k, true
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (o *oidc) getJSON(url string, v interface{}) error {
+	res, err := o.cfg.Client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %s: %s", url, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+func (o *oidc) fetchKeys() (map[string]crypto.PublicKey, error) {
+	url := o.cfg.JWKSURL
+	if url == "" {
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := o.getJSON(strings.TrimRight(o.cfg.Issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, err
+		}
+		url = discovery.JWKSURI
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := o.getJSON(url, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if pub, err := k.publicKey(); err == nil {
+			keys[k.Kid] = pub
+		}
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if pub.N.BitLen() < minRSABits {
+			return nil, fmt.Errorf("RSA key of %d bits, at least %d needed", pub.N.BitLen(), minRSABits)
+		}
+		return pub, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("point not on curve")
+		}
+		return pub, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
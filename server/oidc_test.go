@@ -0,0 +1,244 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func signJWT(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	enc := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("Marshal: %s", err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := enc(map[string]string{"alg": "RS256", "kid": kid}) + "." + enc(claims)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatalf("SignPKCS1v15: %s", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestOIDC(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %s", err)
+	}
+
+	var issuer string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": issuer, "jwks_uri": issuer + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "k1",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	idp := httptest.NewServer(mux)
+	defer idp.Close()
+	issuer = idp.URL
+
+	auth := OIDC(OIDCConfig{Issuer: issuer, Audience: "locknut", Client: idp.Client()})
+	claims := func(aud string, exp time.Time) map[string]interface{} {
+		return map[string]interface{}{
+			"iss":    issuer,
+			"aud":    aud,
+			"sub":    "taylor",
+			"groups": []string{"admins"},
+			"exp":    exp.Unix(),
+		}
+	}
+	authenticate := func(token string) (Identity, error) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return auth.Authenticate(req)
+	}
+
+	id, err := authenticate(signJWT(t, key, "k1", claims("locknut", time.Now().Add(time.Hour))))
+	if err != nil {
+		t.Fatalf("Authenticate: %s", err)
+	}
+	if id.Subject != "taylor" || len(id.Groups) != 1 || id.Groups[0] != "admins" {
+		t.Errorf("unexpected identity %v", id)
+	}
+
+	bad := map[string]string{
+		"expired":       signJWT(t, key, "k1", claims("locknut", time.Now().Add(-time.Hour))),
+		"wrong aud":     signJWT(t, key, "k1", claims("other", time.Now().Add(time.Hour))),
+		"unknown kid":   signJWT(t, key, "k2", claims("locknut", time.Now().Add(time.Hour))),
+		"tampered body": signJWT(t, key, "k1", claims("locknut", time.Now().Add(time.Hour)))[:40] + "x",
+	}
+	for name, token := range bad {
+		if _, err := authenticate(token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: expected invalid token, got %v", name, err)
+		}
+	}
+}
+
+// signES384 signs a token claiming ES384 with key, whatever its curve
+func signES384(t *testing.T, key *ecdsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	enc := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("Marshal: %s", err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := enc(map[string]string{"alg": "ES384", "kid": kid}) + "." + enc(claims)
+	sum := sha512.Sum384([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, key, sum[:])
+	if err != nil {
+		t.Fatalf("Sign: %s", err)
+	}
+	size := (key.Curve.Params().BitSize + 7) / 8
+	sig := make([]byte, 2*size)
+	r.FillBytes(sig[:size])
+	s.FillBytes(sig[size:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestOIDCKeys(t *testing.T) {
+	rsaJWK := func(kid string, key *rsa.PrivateKey) map[string]string {
+		return map[string]string{
+			"kty": "RSA",
+			"kid": kid,
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}
+	}
+	ecJWK := func(kid string, key *ecdsa.PrivateKey) map[string]string {
+		return map[string]string{
+			"kty": "EC",
+			"kid": kid,
+			"crv": key.Curve.Params().Name,
+			"x":   base64.RawURLEncoding.EncodeToString(key.X.Bytes()),
+			"y":   base64.RawURLEncoding.EncodeToString(key.Y.Bytes()),
+		}
+	}
+	old, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rotated, _ := rsa.GenerateKey(rand.Reader, 2048)
+	small, _ := rsa.GenerateKey(rand.Reader, 1024)
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+
+	var mu sync.Mutex
+	keys := []map[string]string{rsaJWK("old", old), rsaJWK("small", small), ecJWK("p256", p256), ecJWK("p384", p384)}
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	}))
+	defer idp.Close()
+
+	ttl := 50 * time.Millisecond
+	auth := OIDC(OIDCConfig{Issuer: "issuer", Audience: "locknut", JWKSURL: idp.URL, KeyTTL: ttl, Client: idp.Client()})
+	claims := map[string]interface{}{"iss": "issuer", "aud": "locknut", "sub": "taylor", "exp": time.Now().Add(time.Hour).Unix()}
+	authenticate := func(token string) error {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		_, err := auth.Authenticate(req)
+		return err
+	}
+
+	if err := authenticate(signES384(t, p384, "p384", claims)); err != nil {
+		t.Fatalf("ES384 on P-384: %s", err)
+	}
+	if err := authenticate(signES384(t, p256, "p256", claims)); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("ES384 on P-256: expected invalid token, got %v", err)
+	}
+	if err := authenticate(signJWT(t, small, "small", claims)); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("1024 bit RSA key: expected invalid token, got %v", err)
+	}
+
+	// once the issuer rotated a key out, it stops validating when the cached keys expire
+	if err := authenticate(signJWT(t, old, "old", claims)); err != nil {
+		t.Fatalf("Authenticate: %s", err)
+	}
+	mu.Lock()
+	keys = []map[string]string{rsaJWK("rotated", rotated)}
+	mu.Unlock()
+	time.Sleep(ttl)
+	if err := authenticate(signJWT(t, old, "old", claims)); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("rotated out key: expected invalid token, got %v", err)
+	}
+	if err := authenticate(signJWT(t, rotated, "rotated", claims)); err != nil {
+		t.Errorf("rotated key: %s", err)
+	}
+}
+
+func TestOIDCKeysFetchFailure(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	var mu sync.Mutex
+	fetches, failing := 0, false
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetches++
+		if failing {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "current",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	defer idp.Close()
+
+	auth := OIDC(OIDCConfig{Issuer: "issuer", Audience: "locknut", JWKSURL: idp.URL, Client: idp.Client()})
+	claims := map[string]interface{}{"iss": "issuer", "aud": "locknut", "sub": "taylor", "exp": time.Now().Add(time.Hour).Unix()}
+	authenticate := func(token string) error {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		_, err := auth.Authenticate(req)
+		return err
+	}
+	if err := authenticate(signJWT(t, key, "current", claims)); err != nil {
+		t.Fatalf("Authenticate: %s", err)
+	}
+
+	// a failed refetch for an unknown id keeps the keys, and is not tried again within the minute
+	mu.Lock()
+	failing = true
+	mu.Unlock()
+	auth.(*oidc).mu.Lock()
+	auth.(*oidc).tried = time.Now().Add(-2 * time.Minute)
+	auth.(*oidc).mu.Unlock()
+	for i := 0; i < 3; i++ {
+		if err := authenticate(signJWT(t, key, "unknown", claims)); err == nil {
+			t.Errorf("expected the unknown key to fail")
+		}
+	}
+	if err := authenticate(signJWT(t, key, "current", claims)); err != nil {
+		t.Errorf("expected the known key to still validate, got %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if fetches != 2 {
+		t.Errorf("expected 2 fetches, got %d", fetches)
+	}
+}
@@ -0,0 +1,312 @@
+// Package server exposes a BoltLocknut over http.
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/taybart/locknut"
+	"github.com/taybart/log"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// uiPageSize is the number of keys listed per page
+const uiPageSize = 50
+
+// redacted replaces the values of redacted fields
+const redacted = "[redacted]"
+
+// UIConfig configures the read-only web UI
+type UIConfig struct {
+	// Auth identifies the caller, every page requires an identity
+	Auth Authenticator
+	// CanView reports whether id may see decrypted records in bucket. If nil nobody may, and the UI
+	// only shows buckets, key counts and keys.
+	CanView func(id Identity, bucket string) bool
+	// Redact lists, per bucket, the json fields replaced by "[redacted]" before a record is shown.
+	// Nested fields are written with dots, such as "address.street".
+	Redact map[string][]string
+	// CanViewRecord, when set, is also consulted before a single record is shown, for access rules
+	// that depend on the key such as records owned by the subject
+	CanViewRecord func(id Identity, bucket, key string) bool
+	// Audit, when set, receives an event for every record shown or refused
+	Audit func(AuditEvent)
+}
+
+// AuditEvent records an access to a record. Subject is the identity the access was checked for and
+// Actor the service acting on its behalf, if any.
+type AuditEvent struct {
+	Time    time.Time `json:"time"`
+	Subject string    `json:"subject"`
+	Actor   string    `json:"actor,omitempty"`
+	Action  string    `json:"action"`
+	Bucket  string    `json:"bucket"`
+	Key     string    `json:"key"`
+	Allowed bool      `json:"allowed"`
+}
+
+// PolicyCanView returns a UIConfig.CanView letting an identity see the records of the buckets p grants
+// PermRead on to its subject or to one of its groups
+func PolicyCanView(p *locknut.Policy) func(id Identity, bucket string) bool {
+	return func(id Identity, bucket string) bool {
+		if p.Allowed(id.Subject, bucket, locknut.PermRead) {
+			return true
+		}
+		for _, g := range id.Groups {
+			if p.Allowed(g, bucket, locknut.PermRead) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+type ui struct {
+	bl  *locknut.BoltLocknut
+	cfg UIConfig
+}
+
+// NewUI returns a read-only web UI showing the buckets of bl with their key counts, the keys of a
+// bucket and, for authorized callers, decrypted records with redaction applied. Mount it under a
+// prefix with http.StripPrefix.
+func NewUI(bl *locknut.BoltLocknut, cfg UIConfig) http.Handler {
+	return &ui{bl: bl, cfg: cfg}
+}
+
+func (u *ui) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if u.cfg.Auth == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := u.cfg.Auth.Authenticate(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	parts := strings.SplitN(strings.Trim(r.URL.Path, "/"), "/", 2)
+	switch {
+	case parts[0] == "":
+		u.buckets(w, id)
+	case len(parts) == 1 && !strings.HasSuffix(r.URL.Path, "/"):
+		// keep a trailing slash so the relative links of the key list resolve
+		http.Redirect(w, r, parts[0]+"/", http.StatusMovedPermanently)
+	case len(parts) == 1 || parts[1] == "":
+		u.keys(w, r, id, parts[0])
+	default:
+		u.record(w, id, parts[0], parts[1])
+	}
+}
+
+type bucketRow struct {
+	Name  string
+	Count int
+}
+
+func (u *ui) buckets(w http.ResponseWriter, id Identity) {
+	names, err := u.bl.Buckets()
+	if err != nil {
+		internalError(w, "buckets", err)
+		return
+	}
+
+	rows := make([]bucketRow, 0, len(names))
+	for _, name := range names {
+		n, err := u.bl.Count(name)
+		if err != nil {
+			internalError(w, "count", err)
+			return
+		}
+		rows = append(rows, bucketRow{Name: name, Count: n})
+	}
+	render(w, bucketsPage, map[string]interface{}{"Identity": id, "Buckets": rows})
+}
+
+func (u *ui) keys(w http.ResponseWriter, r *http.Request, id Identity, bucket string) {
+	prefix := r.URL.Query().Get("prefix")
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 0 {
+		page = 0
+	}
+
+	keys, err := u.bl.GetKeyList(bucket, prefix)
+	if errors.Is(err, locknut.ErrBucketNotFound) {
+		http.NotFound(w, nil)
+		return
+	}
+	if err != nil {
+		internalError(w, "keys", err)
+		return
+	}
+
+	// pages past the end, as large as they come, show the last one
+	last := 0
+	if len(keys) > 0 {
+		last = (len(keys) - 1) / uiPageSize
+	}
+	if page > last {
+		page = last
+	}
+	start := page * uiPageSize
+	end := start + uiPageSize
+	if end > len(keys) {
+		end = len(keys)
+	}
+
+	data := map[string]interface{}{
+		"Identity": id,
+		"Bucket":   bucket,
+		"Prefix":   prefix,
+		"Keys":     keys[start:end],
+		"Total":    len(keys),
+		"CanView":  u.canView(id, bucket),
+	}
+	if page > 0 {
+		data["Prev"] = strconv.Itoa(page - 1)
+	}
+	if end < len(keys) {
+		data["Next"] = strconv.Itoa(page + 1)
+	}
+	render(w, keysPage, data)
+}
+
+func (u *ui) record(w http.ResponseWriter, id Identity, bucket, key string) {
+	allowed := u.canView(id, bucket) && (u.cfg.CanViewRecord == nil || u.cfg.CanViewRecord(id, bucket, key))
+	u.audit(id, "view", bucket, key, allowed)
+	if !allowed {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	v, err := u.bl.Get(bucket, key)
+	if err != nil {
+		internalError(w, "record", err)
+		return
+	}
+	if v == nil {
+		http.NotFound(w, nil)
+		return
+	}
+
+	render(w, recordPage, map[string]interface{}{
+		"Identity": id,
+		"Bucket":   bucket,
+		"Key":      key,
+		"Value":    redact(v, u.cfg.Redact[bucket]),
+	})
+}
+
+func (u *ui) canView(id Identity, bucket string) bool {
+	return u.cfg.CanView != nil && u.cfg.CanView(id, bucket)
+}
+
+func (u *ui) audit(id Identity, action, bucket, key string, allowed bool) {
+	if u.cfg.Audit == nil {
+		return
+	}
+	u.cfg.Audit(AuditEvent{
+		Time:    time.Now(),
+		Subject: id.Subject,
+		Actor:   id.Actor,
+		Action:  action,
+		Bucket:  bucket,
+		Key:     key,
+		Allowed: allowed,
+	})
+}
+
+// redact returns v indented, with the listed fields replaced. Values that are not json objects are
+// shown as they are unless redaction rules exist for the bucket, in which case they are hidden.
+func redact(v []byte, fields []string) string {
+	var doc interface{}
+	if err := json.Unmarshal(v, &doc); err != nil {
+		if len(fields) > 0 {
+			return redacted
+		}
+		return string(v)
+	}
+
+	for _, f := range fields {
+		redactPath(doc, strings.Split(f, "."))
+	}
+
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return redacted
+	}
+	return string(out)
+}
+
+func redactPath(doc interface{}, path []string) {
+	switch d := doc.(type) {
+	case map[string]interface{}:
+		child, ok := d[path[0]]
+		if !ok {
+			return
+		}
+		if len(path) == 1 {
+			d[path[0]] = redacted
+			return
+		}
+		redactPath(child, path[1:])
+	case []interface{}:
+		for _, item := range d {
+			redactPath(item, path)
+		}
+	}
+}
+
+func render(w http.ResponseWriter, t *template.Template, data interface{}) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		internalError(w, "render", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	buf.WriteTo(w)
+}
+
+// internalError logs err and answers with a generic status, the error may tell about the db or its
+// records
+func internalError(w http.ResponseWriter, op string, err error) {
+	log.Error("ui "+op, err)
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+const layout = `<!doctype html>
+<html><head><meta charset="utf-8"><title>locknut</title>
+<style>body{font-family:sans-serif;margin:2em}td{padding:.2em 1em}pre{background:#f4f4f4;padding:1em}</style>
+</head><body><p>signed in as {{.Identity.Subject}}{{with .Identity.Actor}} via {{.}}{{end}}</p>{{template "content" .}}</body></html>`
+
+var funcs = template.FuncMap{"path": url.PathEscape}
+
+func page(content string) *template.Template {
+	t := template.Must(template.New("layout").Funcs(funcs).Parse(layout))
+	template.Must(t.New("content").Parse(content))
+	return t
+}
+
+var bucketsPage = page(`<h1>buckets</h1><table>
+{{range .Buckets}}<tr><td><a href="{{path .Name}}/">{{.Name}}</a></td><td>{{.Count}} keys</td></tr>{{end}}
+</table>`)
+
+var keysPage = page(`<h1><a href="../">buckets</a> / {{.Bucket}}</h1>
+<form><input name="prefix" value="{{.Prefix}}" placeholder="prefix"><button>filter</button></form>
+<p>{{.Total}} keys</p><ul>
+{{range .Keys}}<li>{{if $.CanView}}<a href="{{path .}}">{{.}}</a>{{else}}{{.}}{{end}}</li>{{end}}
+</ul>
+{{with .Prev}}<a href="?prefix={{$.Prefix}}&page={{.}}">previous</a>{{end}}
+{{with .Next}}<a href="?prefix={{$.Prefix}}&page={{.}}">next</a>{{end}}`)
+
+var recordPage = page(`<h1><a href="../">buckets</a> / <a href="./">{{.Bucket}}</a> / {{.Key}}</h1>
+<pre>{{.Value}}</pre>`)
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/taybart/locknut"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+type person struct {
+	Name string `json:"name"`
+	SSN  string `json:"ssn"`
+}
+
+func get(t *testing.T, srv *httptest.Server, path, user string) (int, string) {
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+	if user != "" {
+		req.Header.Set("X-User", user)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s: %s", path, err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	return res.StatusCode, string(body)
+}
+
+func TestUI(t *testing.T) {
+	if locknut.FIPSBuild() {
+		t.Skip("a short secret is not allowed in FIPS mode")
+	}
+	defer os.Remove("ui_test.db")
+
+	bl, err := locknut.NewBoltLocknut("ui_test.db", ".", []byte("secret"), false, []string{"pii"})
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if err = bl.Save("pii", "taylor", person{Name: "taylor", SSN: "123-45-6789"}); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+
+	var events []AuditEvent
+	srv := httptest.NewServer(NewUI(bl, UIConfig{
+		Auth: AuthenticatorFunc(func(r *http.Request) (Identity, error) {
+			if u := r.Header.Get("X-User"); u != "" {
+				return Identity{Subject: u, Actor: r.Header.Get("X-Actor")}, nil
+			}
+			return Identity{}, errors.New("no user")
+		}),
+		CanView: func(id Identity, bucket string) bool { return id.Subject == "admin" },
+		Redact:  map[string][]string{"pii": {"ssn"}},
+		CanViewRecord: func(id Identity, bucket, key string) bool {
+			return id.Actor == "" || id.Subject == key
+		},
+		Audit: func(e AuditEvent) { events = append(events, e) },
+	}))
+	defer srv.Close()
+
+	if code, _ := get(t, srv, "/", ""); code != http.StatusUnauthorized {
+		t.Errorf("expected unauthorized, got %d", code)
+	}
+
+	code, body := get(t, srv, "/", "viewer")
+	if code != http.StatusOK || !strings.Contains(body, "1 keys") {
+		t.Errorf("unexpected bucket page %d %s", code, body)
+	}
+
+	if code, body = get(t, srv, "/pii/", "viewer"); code != http.StatusOK || !strings.Contains(body, "taylor") {
+		t.Errorf("unexpected key page %d %s", code, body)
+	}
+	// a page past the end shows the last one, however large
+	if code, body = get(t, srv, "/pii/?page=9223372036854775807", "viewer"); code != http.StatusOK || !strings.Contains(body, "taylor") {
+		t.Errorf("unexpected last key page %d %s", code, body)
+	}
+	if code, _ = get(t, srv, "/pii/taylor", "viewer"); code != http.StatusForbidden {
+		t.Errorf("expected forbidden record for viewer, got %d", code)
+	}
+
+	code, body = get(t, srv, "/pii/taylor", "admin")
+	if code != http.StatusOK || !strings.Contains(body, "taylor") || strings.Contains(body, "123-45-6789") {
+		t.Errorf("unexpected record page %d %s", code, body)
+	}
+
+	// a service acting for another user is held to the record rule of that user
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/pii/taylor", nil)
+	req.Header.Set("X-User", "admin")
+	req.Header.Set("X-Actor", "backend")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET: %s", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("expected forbidden on behalf of admin, got %d", res.StatusCode)
+	}
+
+	if len(events) != 3 {
+		t.Fatalf("expected 3 audit events, got %d", len(events))
+	}
+	if e := events[2]; e.Subject != "admin" || e.Actor != "backend" || e.Allowed || e.Key != "taylor" {
+		t.Errorf("unexpected audit event %+v", e)
+	}
+
+	if code, body = get(t, srv, "/missing/", "viewer"); code != http.StatusNotFound || strings.Contains(body, "missing") {
+		t.Errorf("unexpected missing bucket page %d %s", code, body)
+	}
+	// with a multiple of the page size, the last page is the last full one
+	bl.CreateBucket("bulk")
+	for i := 0; i < 2*uiPageSize; i++ {
+		bl.Save("bulk", fmt.Sprintf("k%03d", i), i)
+	}
+	if code, body = get(t, srv, "/bulk/?page=5", "viewer"); code != http.StatusOK || !strings.Contains(body, "k099") {
+		t.Errorf("unexpected last bulk page %d %s", code, body)
+	}
+}
+
+func TestPolicyCanView(t *testing.T) {
+	p := locknut.NewPolicy()
+	p.Grant("alice", "notes", locknut.PermRead)
+	p.Grant("ops", "logs", locknut.PermRead)
+	p.Grant("bob", "drafts", locknut.PermWrite)
+	canView := PolicyCanView(p)
+
+	for _, c := range []struct {
+		id     Identity
+		bucket string
+		want   bool
+	}{
+		{Identity{Subject: "alice"}, "notes", true},
+		{Identity{Subject: "alice"}, "logs", false},
+		{Identity{Subject: "alice", Groups: []string{"ops"}}, "logs", true},
+		{Identity{Subject: "bob"}, "drafts", false},
+	} {
+		if got := canView(c.id, c.bucket); got != c.want {
+			t.Errorf("%+v on %s: expected %v, got %v", c.id, c.bucket, c.want, got)
+		}
+	}
+}
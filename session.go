@@ -0,0 +1,249 @@
+package locknut
+
+import (
+	"crypto/subtle"
+	"errors"
+	"go.etcd.io/bbolt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrLocked is returned by every operation on a BoltLocknut that was locked, until it is unlocked
+var ErrLocked = errors.New("db is locked")
+
+// session tracks whether the key of the db is held in memory, and locks it after a while of idling
+type session struct {
+	mu      sync.Mutex
+	locked  bool
+	idle    time.Duration
+	timer   *time.Timer
+	holds   int        // operations using the key, see hold
+	paused  bool       // the key is being swapped, see pause
+	pending bool       // a pause waits for the holds to end, new ones wait meanwhile
+	grace   uint64     // bumped every pauseGrace while a pause is pending, letting waiting holds in
+	drained *sync.Cond // signalled when holds drops to zero and when a pause ends
+	wiped   atomic.Bool
+}
+
+// WithAutoLock locks the db once no operation used it for d, wiping the key from memory as Lock does.
+// Desktop apps keeping passwords in locknut use it to lock the vault left unattended.
+func WithAutoLock(d time.Duration) Option {
+	return func(bl *BoltLocknut) {
+		bl.session.idle = d
+	}
+}
+
+// touch fails with ErrLocked when the db is locked, and otherwise restarts the auto lock timer
+func (bl *BoltLocknut) touch() error {
+	s := &bl.session
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.locked {
+		return ErrLocked
+	}
+	bl.restartAutoLock()
+	return nil
+}
+
+// pauseGrace is how long a pending pause holds new holds off before letting the waiting ones in
+const pauseGrace = 50 * time.Millisecond
+
+// hold is touch for an operation using the key, which Lock waits for before wiping it. Every hold that
+// succeeds must be released. It waits for a key swap in progress to end, and for a pending one to start.
+func (bl *BoltLocknut) hold() error {
+	s := &bl.session
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	grace := s.grace
+	for s.paused || (s.pending && s.grace == grace) {
+		s.cond().Wait()
+	}
+	if s.locked {
+		return ErrLocked
+	}
+	s.holds++
+	bl.restartAutoLock()
+	return nil
+}
+
+// release ends a hold
+func (bl *BoltLocknut) release() {
+	s := &bl.session
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.holds--
+	if s.holds == 0 && s.drained != nil {
+		s.drained.Broadcast()
+	}
+}
+
+// waitHolds waits for the operations holding the key to end, with the session locked
+func (s *session) waitHolds() {
+	for s.holds > 0 {
+		s.cond().Wait()
+	}
+}
+
+// cond returns the condition holds and pauses are waited on, with the session locked
+func (s *session) cond() *sync.Cond {
+	if s.drained == nil {
+		s.drained = sync.NewCond(&s.mu)
+	}
+	return s.drained
+}
+
+// pause waits for the operations holding the key to end and makes new ones wait until resume, so a
+// key swap is never seen half done. New holds wait while it waits too, so a steady stream of
+// overlapping reads cannot keep it out. A hold cannot tell whether it nests in another, a read from a
+// hook for instance, and waiting would deadlock a nested one, so the holds waiting are let in every
+// pauseGrace. It fails with ErrLocked when the db is locked. The caller must not hold the key, nor take
+// a hold, until it resumes.
+func (bl *BoltLocknut) pause() error {
+	s := &bl.session
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.pending || s.paused {
+		s.cond().Wait()
+	}
+	s.pending = true
+	var timer *time.Timer
+	timer = time.AfterFunc(pauseGrace, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.pending {
+			s.grace++
+			s.cond().Broadcast()
+			timer.Reset(pauseGrace)
+		}
+	})
+	for s.holds > 0 {
+		s.cond().Wait()
+	}
+	timer.Stop()
+	s.pending = false
+	if s.locked {
+		s.cond().Broadcast()
+		return ErrLocked
+	}
+	s.paused = true
+	return nil
+}
+
+// resume ends a pause
+func (bl *BoltLocknut) resume() {
+	s := &bl.session
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = false
+	s.cond().Broadcast()
+}
+
+// restartAutoLock restarts the auto lock timer, if any, with the session locked
+func (bl *BoltLocknut) restartAutoLock() {
+	s := &bl.session
+	if s.idle <= 0 {
+		return
+	}
+	if s.timer == nil {
+		s.timer = time.AfterFunc(s.idle, bl.autoLock)
+	} else {
+		s.timer.Reset(s.idle)
+	}
+}
+
+// autoLock locks the db when the auto lock timer fires, trying again later if a maintenance operation
+// is running
+func (bl *BoltLocknut) autoLock() {
+	if errors.Is(bl.Lock(), ErrMaintenance) {
+		bl.session.mu.Lock()
+		bl.session.timer.Reset(bl.session.idle)
+		bl.session.mu.Unlock()
+	}
+}
+
+// Lock wipes the key from memory. Every operation fails with ErrLocked until Unlock is given the
+// secret again. Writes in progress are waited for, like a maintenance operation does, a running
+// maintenance operation makes Lock fail with ErrMaintenance. Reads in progress are waited for too,
+// while new ones fail with ErrLocked, the reads of a Snapshot fail from then on.
+func (bl *BoltLocknut) Lock() error {
+	if !bl.readOnly {
+		if err := bl.fence.begin("lock"); err != nil {
+			return err
+		}
+		defer bl.fence.end()
+	}
+
+	s := &bl.session
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.locked {
+		s.locked = true
+		if s.timer != nil {
+			s.timer.Stop()
+		}
+	}
+	s.waitHolds()
+	// an Unlock may have come in while waiting
+	if !s.locked || s.wiped.Load() {
+		return nil
+	}
+	s.wiped.Store(true)
+	return bl.setKey(nil)
+}
+
+// Unlock checks secret against the db and, if it matches, holds its key in memory again. A secret
+// that does not match fails with ErrWrongSecret and leaves the db locked. Unlocking a db that is not
+// locked only checks the secret. After repeated failures it waits before checking, see
+// WithUnlockBackoff.
+func (bl *BoltLocknut) Unlock(secret []byte) error {
+	if d := bl.unlockDelay(); d > 0 {
+		time.Sleep(d)
+	}
+	key, err := bl.deriveKey(secret)
+	if err != nil {
+		return err
+	}
+
+	s := &bl.session
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := bl.open(); err != nil {
+		return err
+	}
+	defer bl.closeFile()
+	err = bl.db.view(func(tx *bbolt.Tx) error {
+		return checkKey(tx, key)
+	})
+	if err == nil && !s.locked && subtle.ConstantTimeCompare(key, bl.secret) != 1 {
+		err = ErrWrongSecret
+	}
+	if errors.Is(err, ErrWrongSecret) {
+		bl.decryptFailed("unlock", "", "", err)
+	}
+	if err != nil {
+		return err
+	}
+	bl.decryptSucceeded()
+	if !s.locked {
+		return nil
+	}
+
+	// a Lock may still wait for reads to end before it wipes the key
+	s.waitHolds()
+	if err := bl.setKey(key); err != nil {
+		return err
+	}
+	s.wiped.Store(false)
+	s.locked = false
+	bl.restartAutoLock()
+	return nil
+}
+
+// Locked reports whether the db is locked
+func (bl *BoltLocknut) Locked() bool {
+	bl.session.mu.Lock()
+	defer bl.session.mu.Unlock()
+	return bl.session.locked
+}
@@ -0,0 +1,202 @@
+package locknut
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	defer os.Remove("session_test.db")
+
+	secret := []byte("a secret of exactly 32 bytes...!")
+	bl, err := NewBoltLocknut("session_test.db", ".", secret, false, []string{"vault"})
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if err = bl.Save("vault", "email", "hunter2"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+
+	if err = bl.Lock(); err != nil {
+		t.Fatalf("Lock: %s", err)
+	}
+	if string(secret) != "a secret of exactly 32 bytes...!" {
+		t.Error("expected Lock to leave the caller's secret alone")
+	}
+	if !bl.Locked() {
+		t.Error("expected the db to be locked")
+	}
+	if _, err = bl.Get("vault", "email"); !errors.Is(err, ErrLocked) {
+		t.Errorf("expected Get to fail with ErrLocked, got %v", err)
+	}
+	if err = bl.Save("vault", "other", "x"); !errors.Is(err, ErrLocked) {
+		t.Errorf("expected Save to fail with ErrLocked, got %v", err)
+	}
+
+	if err = bl.Unlock([]byte("wrong")); !errors.Is(err, ErrWrongSecret) {
+		t.Errorf("expected ErrWrongSecret, got %v", err)
+	}
+	if !bl.Locked() {
+		t.Error("expected a wrong secret to leave the db locked")
+	}
+	if err = bl.Unlock(secret); err != nil {
+		t.Fatalf("Unlock: %s", err)
+	}
+	if v, err := bl.Get("vault", "email"); err != nil || string(v) != `"hunter2"` {
+		t.Errorf("unexpected Get after Unlock %s %v", v, err)
+	}
+}
+
+func TestAutoLock(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("session_test.db")
+
+	bl, err := NewBoltLocknut("session_test.db", ".", []byte("secret"), false, []string{"vault"},
+		WithAutoLock(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+
+	// use keeps it unlocked
+	for i := 0; i < 5; i++ {
+		time.Sleep(20 * time.Millisecond)
+		if _, err = bl.Get("vault", "email"); err != nil {
+			t.Fatalf("expected the db to stay unlocked while used, got %v", err)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for !bl.Locked() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !bl.Locked() {
+		t.Fatal("expected the db to lock itself once idle")
+	}
+	if err = bl.Unlock([]byte("secret")); err != nil {
+		t.Fatalf("Unlock: %s", err)
+	}
+	if _, err = bl.Get("vault", "email"); err != nil {
+		t.Errorf("expected Get after Unlock to succeed, got %v", err)
+	}
+}
+
+func TestLockDuringReads(t *testing.T) {
+	defer os.Remove("session_reads_test.db")
+
+	secret := []byte("a secret of exactly 32 bytes...!")
+	bl, err := NewBoltLocknut("session_reads_test.db", ".", secret, true, []string{"vault"})
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	defer bl.Close()
+	for i := 0; i < 50; i++ {
+		if err = bl.Save("vault", fmt.Sprintf("k%02d", i), "value"); err != nil {
+			t.Fatalf("Save: %s", err)
+		}
+	}
+
+	stop := make(chan struct{})
+	failures := make(chan string, 2)
+	var wg sync.WaitGroup
+	for r := 0; r < 2; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				values, err := bl.GetByPrefix("vault", "")
+				if err != nil {
+					if !errors.Is(err, ErrLocked) {
+						failures <- err.Error()
+						return
+					}
+					// let Unlock run on machines with a single CPU
+					runtime.Gosched()
+					continue
+				}
+				for k, v := range values {
+					if string(v) != `"value"` {
+						failures <- fmt.Sprintf("%s read as %q", k, v)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 30; i++ {
+		runtime.Gosched()
+		if err = bl.Lock(); err != nil {
+			t.Fatalf("Lock: %s", err)
+		}
+		if err = bl.Unlock(secret); err != nil {
+			t.Fatalf("Unlock: %s", err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	close(failures)
+	for f := range failures {
+		t.Error(f)
+	}
+}
+
+func TestPauseDuringOverlappingReads(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("session_pause_test.db")
+
+	bl, err := NewBoltLocknut("session_pause_test.db", ".", []byte("secret"), false, nil)
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	defer bl.Close()
+
+	// readers overlapping each other, so there is never a moment without a hold
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			time.Sleep(time.Duration(i) * time.Millisecond)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if err := bl.hold(); err != nil {
+					return
+				}
+				time.Sleep(2 * time.Millisecond)
+				bl.release()
+			}
+		}(i)
+	}
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	paused := make(chan error, 1)
+	go func() { paused <- bl.pause() }()
+	select {
+	case err = <-paused:
+		if err != nil {
+			t.Fatalf("pause: %s", err)
+		}
+		bl.resume()
+	case <-time.After(5 * time.Second):
+		t.Fatal("pause was kept out by the overlapping reads")
+	}
+}
@@ -0,0 +1,202 @@
+package locknut
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidShares is returned by CombineShares and Unlocker for shares that cannot be combined
+var ErrInvalidShares = errors.New("invalid secret shares")
+
+// SplitSecret splits secret into n shares, any k of which give it back with CombineShares while fewer
+// reveal nothing about it, so no single holder can decrypt the db. It is Shamir's secret sharing over
+// GF(2^8), applied to each byte of the secret. A share is one byte longer than the secret, the last
+// byte being its x coordinate. 2 <= k <= n <= 255.
+func SplitSecret(secret []byte, n, k int) ([][]byte, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("split secret: empty secret")
+	}
+	if k < 2 || n < k || n > 255 {
+		return nil, fmt.Errorf("split secret: need 2 <= k <= n <= 255, got n=%d k=%d", n, k)
+	}
+
+	// distinct non zero x coordinates, in random order so the shares do not give away their count
+	xs := make([]byte, 255)
+	for i := range xs {
+		xs[i] = byte(i + 1)
+	}
+	for i := len(xs) - 1; i > 0; i-- {
+		j, err := randIndex(i + 1)
+		if err != nil {
+			return nil, err
+		}
+		xs[i], xs[j] = xs[j], xs[i]
+	}
+
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][len(secret)] = xs[i]
+	}
+
+	coeffs := make([]byte, k)
+	for b, s := range secret {
+		// a random polynomial of degree at most k-1 whose value at 0 is the secret byte. Every
+		// coefficient is uniform, zero included: any k-1 shares are then uniform whatever the byte, while
+		// ruling zero out for the top one would make a share rule out one value of the byte.
+		if _, err := rand.Read(coeffs[1:]); err != nil {
+			return nil, err
+		}
+		coeffs[0] = s
+		for _, share := range shares {
+			share[b] = gfEval(coeffs, share[len(secret)])
+		}
+	}
+	return shares, nil
+}
+
+// randIndex returns a uniformly random int in [0, n), n <= 256, drawing bytes again rather than
+// reducing them modulo n, which would favor the low values
+func randIndex(n int) (int, error) {
+	limit := 256 - 256%n
+	b := make([]byte, 1)
+	for {
+		if _, err := rand.Read(b); err != nil {
+			return 0, err
+		}
+		if int(b[0]) < limit {
+			return int(b[0]) % n, nil
+		}
+	}
+}
+
+// CombineShares returns the secret split by SplitSecret from at least k of its shares. Combining fewer
+// than k shares, or shares of different secrets, gives a wrong secret rather than an error, which the
+// db detects with ErrWrongSecret when it is opened.
+func CombineShares(shares ...[]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, fmt.Errorf("%w: need at least 2 shares", ErrInvalidShares)
+	}
+	size := len(shares[0])
+	if size < 2 {
+		return nil, fmt.Errorf("%w: share too short", ErrInvalidShares)
+	}
+	xs := make([]byte, len(shares))
+	seen := make(map[byte]bool)
+	for i, share := range shares {
+		if len(share) != size {
+			return nil, fmt.Errorf("%w: shares differ in length", ErrInvalidShares)
+		}
+		x := share[size-1]
+		if x == 0 || seen[x] {
+			return nil, fmt.Errorf("%w: duplicate or malformed share", ErrInvalidShares)
+		}
+		seen[x] = true
+		xs[i] = x
+	}
+
+	// Lagrange interpolation at 0, the basis only depends on the x coordinates
+	basis := make([]byte, len(shares))
+	for i := range shares {
+		l := byte(1)
+		for j := range shares {
+			if i != j {
+				// x_j / (x_j - x_i), subtraction being xor
+				l = gfMul(l, gfMul(xs[j], gfInv(xs[j]^xs[i])))
+			}
+		}
+		basis[i] = l
+	}
+
+	secret := make([]byte, size-1)
+	for b := range secret {
+		var s byte
+		for i, share := range shares {
+			s ^= gfMul(share[b], basis[i])
+		}
+		secret[b] = s
+	}
+	return secret, nil
+}
+
+// Unlocker collects the shares of a secret, handed in one at a time by their holders, until it has
+// the threshold it was split with
+type Unlocker struct {
+	threshold int
+	shares    [][]byte
+}
+
+// NewUnlocker returns an Unlocker for a secret split into shares any threshold of which combine
+func NewUnlocker(threshold int) *Unlocker {
+	return &Unlocker{threshold: threshold}
+}
+
+// Add records a share and, once threshold distinct shares were added, returns the secret with done
+// set. Adding a share twice is an error that leaves the shares added so far in place.
+func (u *Unlocker) Add(share []byte) (secret []byte, done bool, err error) {
+	for _, s := range u.shares {
+		if len(s) != len(share) {
+			return nil, false, fmt.Errorf("%w: shares differ in length", ErrInvalidShares)
+		}
+		if len(share) > 0 && s[len(s)-1] == share[len(share)-1] {
+			return nil, false, fmt.Errorf("%w: share was already added", ErrInvalidShares)
+		}
+	}
+	u.shares = append(u.shares, append([]byte(nil), share...))
+	if len(u.shares) < u.threshold {
+		return nil, false, nil
+	}
+	secret, err = CombineShares(u.shares...)
+	return secret, err == nil, err
+}
+
+// Remaining returns the number of shares still needed
+func (u *Unlocker) Remaining() int {
+	if n := u.threshold - len(u.shares); n > 0 {
+		return n
+	}
+	return 0
+}
+
+// Reset forgets the shares added so far
+func (u *Unlocker) Reset() {
+	for _, s := range u.shares {
+		for i := range s {
+			s[i] = 0
+		}
+	}
+	u.shares = nil
+}
+
+// gfEval evaluates the polynomial with coeffs, lowest degree first, at x with Horner's method
+func gfEval(coeffs []byte, x byte) byte {
+	var y byte
+	for i := len(coeffs) - 1; i >= 0; i-- {
+		y = gfMul(y, x) ^ coeffs[i]
+	}
+	return y
+}
+
+// gfMul multiplies in GF(2^8) with the AES polynomial x^8+x^4+x^3+x+1, without branching on the
+// operands
+func gfMul(a, b byte) byte {
+	var p byte
+	for i := 0; i < 8; i++ {
+		p ^= -(b & 1) & a
+		carry := -(a >> 7)
+		a = a<<1 ^ carry&0x1b
+		b >>= 1
+	}
+	return p
+}
+
+// gfInv returns the inverse of a non zero a, a^254
+func gfInv(a byte) byte {
+	r := a
+	for i := 0; i < 6; i++ {
+		a = gfMul(a, a)
+		r = gfMul(r, a)
+	}
+	return gfMul(r, r)
+}
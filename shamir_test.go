@@ -0,0 +1,116 @@
+package locknut
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSplitSecret(t *testing.T) {
+	secret := []byte("correct horse battery staple")
+	shares, err := SplitSecret(secret, 5, 3)
+	if err != nil {
+		t.Fatalf("SplitSecret: %s", err)
+	}
+	if len(shares) != 5 || len(shares[0]) != len(secret)+1 {
+		t.Fatalf("unexpected shares %d of %d bytes", len(shares), len(shares[0]))
+	}
+
+	// every set of 3 shares gives the secret back
+	for i := 0; i < 5; i++ {
+		for j := i + 1; j < 5; j++ {
+			for k := j + 1; k < 5; k++ {
+				got, err := CombineShares(shares[k], shares[i], shares[j])
+				if err != nil || !bytes.Equal(got, secret) {
+					t.Errorf("shares %d %d %d: got %q %v", i, j, k, got, err)
+				}
+			}
+		}
+	}
+	if got, err := CombineShares(shares...); err != nil || !bytes.Equal(got, secret) {
+		t.Errorf("all shares: got %q %v", got, err)
+	}
+	if got, _ := CombineShares(shares[0], shares[1]); bytes.Equal(got, secret) {
+		t.Error("expected 2 shares not to give the secret back")
+	}
+
+	if _, err = CombineShares(shares[0], shares[0]); !errors.Is(err, ErrInvalidShares) {
+		t.Errorf("expected ErrInvalidShares for duplicate shares, got %v", err)
+	}
+	if _, err = SplitSecret(secret, 2, 3); err == nil {
+		t.Error("expected k > n to fail")
+	}
+	if _, err = SplitSecret(secret, 3, 1); err == nil {
+		t.Error("expected k < 2 to fail")
+	}
+}
+
+func TestSplitSecretHidesBytes(t *testing.T) {
+	// with k=2 a share byte is s + a*x for a uniform a, so it equals the secret byte s a 256th of the
+	// time: a share that never did would rule out that value of every byte
+	secret := bytes.Repeat([]byte{0x5a}, 4096)
+	equal, total := 0, 0
+	for i := 0; i < 50; i++ {
+		shares, err := SplitSecret(secret, 2, 2)
+		if err != nil {
+			t.Fatalf("SplitSecret: %s", err)
+		}
+		for b, s := range secret {
+			if shares[0][b] == s {
+				equal++
+			}
+			total++
+		}
+	}
+	// 800 expected, the bounds are over 7 standard deviations away
+	if want := total / 256; equal < want-200 || equal > want+200 {
+		t.Errorf("a share byte equalled the secret byte %d times in %d, expected about %d", equal, total, want)
+	}
+}
+
+func TestGFInv(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		if p := gfMul(byte(a), gfInv(byte(a))); p != 1 {
+			t.Fatalf("%d * inv(%d) = %d", a, a, p)
+		}
+	}
+}
+
+func TestRandIndex(t *testing.T) {
+	for _, n := range []int{1, 3, 255, 256} {
+		seen := make(map[int]bool)
+		for i := 0; i < 100*n; i++ {
+			j, err := randIndex(n)
+			if err != nil {
+				t.Fatalf("randIndex: %s", err)
+			}
+			if j < 0 || j >= n {
+				t.Fatalf("randIndex(%d) = %d", n, j)
+			}
+			seen[j] = true
+		}
+		if len(seen) != n {
+			t.Errorf("randIndex(%d) gave %d distinct values", n, len(seen))
+		}
+	}
+}
+
+func TestUnlocker(t *testing.T) {
+	secret, _ := GetRandKey()
+	shares, err := SplitSecret(secret, 3, 2)
+	if err != nil {
+		t.Fatalf("SplitSecret: %s", err)
+	}
+
+	u := NewUnlocker(2)
+	if got, done, err := u.Add(shares[2]); err != nil || done || got != nil || u.Remaining() != 1 {
+		t.Fatalf("unexpected first Add %v %v %v", got, done, err)
+	}
+	if _, _, err = u.Add(shares[2]); !errors.Is(err, ErrInvalidShares) {
+		t.Errorf("expected ErrInvalidShares for a share added twice, got %v", err)
+	}
+	got, done, err := u.Add(shares[0])
+	if err != nil || !done || !bytes.Equal(got, secret) {
+		t.Errorf("unexpected second Add %v %v", done, err)
+	}
+}
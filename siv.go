@@ -0,0 +1,160 @@
+package locknut
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+)
+
+// sivMagic starts the values encrypted with AES-SIV by encryptDeterministic, which Decrypt tells from
+// the nonce of an AES-GCM ciphertext by it
+var sivMagic = []byte("\x00locknut siv\x00")
+
+var (
+	sivMacInfo = []byte("locknut siv mac")
+	sivEncInfo = []byte("locknut siv enc")
+)
+
+// errSIVOpen is returned for an AES-SIV ciphertext that was altered or encrypted with another key
+var errSIVOpen = errors.New("siv: message authentication failed")
+
+// encryptDeterministic encrypts plain with AES-SIV (RFC 5297), whose IV is a CMAC of plain, so the
+// same plain and key always give the same ciphertext. Unlike a nonce derived for AES-GCM, two values
+// sharing an IV reveal nothing but their equality, whatever the number of values. The S2V and CTR keys
+// are derived from key. The result is sivMagic, the IV and the ciphertext, and reads with Decrypt.
+func encryptDeterministic(plain, key []byte) ([]byte, error) {
+	mac, ctr, err := sivCiphers(key)
+	if err != nil {
+		return nil, err
+	}
+	out := append([]byte(nil), sivMagic...)
+	return append(out, sivSeal(mac, ctr, plain)...), nil
+}
+
+// decryptSIV opens a value encrypted by encryptDeterministic
+func decryptSIV(ciphertext, key []byte) ([]byte, error) {
+	ciphertext = ciphertext[len(sivMagic):]
+	if len(ciphertext) < aes.BlockSize {
+		return nil, ErrCiphertextTooShort
+	}
+	mac, ctr, err := sivCiphers(key)
+	if err != nil {
+		return nil, err
+	}
+	return sivOpen(mac, ctr, ciphertext)
+}
+
+// sivCiphers returns the block ciphers of the S2V and CTR keys derived from key
+func sivCiphers(key []byte) (mac, ctr cipher.Block, err error) {
+	if mac, err = aes.NewCipher(HKDF(key, nil, sivMacInfo, len(key))); err != nil {
+		return nil, nil, err
+	}
+	if ctr, err = aes.NewCipher(HKDF(key, nil, sivEncInfo, len(key))); err != nil {
+		return nil, nil, err
+	}
+	return mac, ctr, nil
+}
+
+// sivSeal is the AES-SIV encryption of plain with the additional data ad, the IV followed by the
+// ciphertext
+func sivSeal(mac, ctr cipher.Block, plain []byte, ad ...[]byte) []byte {
+	iv := s2v(mac, append(ad, plain)...)
+	out := make([]byte, aes.BlockSize+len(plain))
+	copy(out, iv)
+	sivCTR(ctr, iv).XORKeyStream(out[aes.BlockSize:], plain)
+	return out
+}
+
+// sivOpen is the AES-SIV decryption of sealed, checking its IV against the plaintext and ad
+func sivOpen(mac, ctr cipher.Block, sealed []byte, ad ...[]byte) ([]byte, error) {
+	iv, ct := sealed[:aes.BlockSize], sealed[aes.BlockSize:]
+	plain := make([]byte, len(ct))
+	sivCTR(ctr, iv).XORKeyStream(plain, ct)
+	if subtle.ConstantTimeCompare(s2v(mac, append(ad, plain)...), iv) != 1 {
+		wipe(plain)
+		return nil, errSIVOpen
+	}
+	return plain, nil
+}
+
+// sivCTR returns the CTR stream of the IV, with the bits 31 and 63 cleared as RFC 5297 asks
+func sivCTR(block cipher.Block, iv []byte) cipher.Stream {
+	q := append([]byte(nil), iv...)
+	q[8] &= 0x7f
+	q[12] &= 0x7f
+	return cipher.NewCTR(block, q)
+}
+
+// s2v is the S2V function of RFC 5297 over inputs, the last one being the plaintext
+func s2v(block cipher.Block, inputs ...[]byte) []byte {
+	d := cmac(block, make([]byte, aes.BlockSize))
+	for _, s := range inputs[:len(inputs)-1] {
+		d = dbl(d)
+		subtle.XORBytes(d, d, cmac(block, s))
+	}
+	last := inputs[len(inputs)-1]
+	var t []byte
+	if len(last) >= aes.BlockSize {
+		t = append([]byte(nil), last...)
+		end := t[len(t)-aes.BlockSize:]
+		subtle.XORBytes(end, end, d)
+	} else {
+		t = dbl(d)
+		padded := make([]byte, aes.BlockSize)
+		copy(padded, last)
+		padded[len(last)] = 0x80
+		subtle.XORBytes(t, t, padded)
+	}
+	return cmac(block, t)
+}
+
+// cmac is AES-CMAC (RFC 4493) of msg
+func cmac(block cipher.Block, msg []byte) []byte {
+	k1 := make([]byte, aes.BlockSize)
+	block.Encrypt(k1, k1)
+	k1 = dbl(k1)
+	k2 := dbl(k1)
+
+	n := (len(msg) + aes.BlockSize - 1) / aes.BlockSize
+	last := make([]byte, aes.BlockSize)
+	if n > 0 && len(msg)%aes.BlockSize == 0 {
+		subtle.XORBytes(last, msg[(n-1)*aes.BlockSize:], k1)
+	} else {
+		if n == 0 {
+			n = 1
+		}
+		copy(last, msg[(n-1)*aes.BlockSize:])
+		last[len(msg)-(n-1)*aes.BlockSize] = 0x80
+		subtle.XORBytes(last, last, k2)
+	}
+
+	x := make([]byte, aes.BlockSize)
+	for i := 0; i < n-1; i++ {
+		subtle.XORBytes(x, x, msg[i*aes.BlockSize:(i+1)*aes.BlockSize])
+		block.Encrypt(x, x)
+	}
+	subtle.XORBytes(x, x, last)
+	block.Encrypt(x, x)
+	return x
+}
+
+// dbl is the doubling of a block in GF(2^128) of RFC 5297, returned as a new block
+func dbl(b []byte) []byte {
+	out := make([]byte, len(b))
+	var carry byte
+	for i := len(b) - 1; i >= 0; i-- {
+		out[i] = b[i]<<1 | carry
+		carry = b[i] >> 7
+	}
+	if carry != 0 {
+		out[len(out)-1] ^= 0x87
+	}
+	return out
+}
+
+// isSIV reports whether ciphertext was encrypted by encryptDeterministic
+func isSIV(ciphertext []byte) bool {
+	return bytes.HasPrefix(ciphertext, sivMagic)
+}
@@ -0,0 +1,48 @@
+package locknut
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestSIV(t *testing.T) {
+	// RFC 5297, A.1
+	key, _ := hex.DecodeString("fffefdfcfbfaf9f8f7f6f5f4f3f2f1f0f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff")
+	ad, _ := hex.DecodeString("101112131415161718191a1b1c1d1e1f2021222324252627")
+	plain, _ := hex.DecodeString("112233445566778899aabbccddee")
+	want, _ := hex.DecodeString("85632d07c6e8f37f950acd320a2ecc9340c02b9690c4dc04daef7f6afe5c")
+
+	mac, _ := aes.NewCipher(key[:16])
+	ctr, _ := aes.NewCipher(key[16:])
+	sealed := sivSeal(mac, ctr, plain, ad)
+	if !bytes.Equal(sealed, want) {
+		t.Fatalf("expected %x, got %x", want, sealed)
+	}
+	if got, err := sivOpen(mac, ctr, sealed, ad); err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("unexpected opened value %x %v", got, err)
+	}
+	sealed[len(sealed)-1] ^= 1
+	if _, err := sivOpen(mac, ctr, sealed, ad); !errors.Is(err, errSIVOpen) {
+		t.Errorf("expected errSIVOpen for an altered ciphertext, got %v", err)
+	}
+
+	// values of any length round trip through Decrypt
+	dbKey, _ := GetRandKey()
+	for _, n := range []int{0, 1, 15, 16, 17, 32, 100} {
+		value := bytes.Repeat([]byte{'v'}, n)
+		ct, err := encryptDeterministic(value, dbKey)
+		if err != nil {
+			t.Fatalf("encryptDeterministic: %s", err)
+		}
+		again, _ := encryptDeterministic(value, dbKey)
+		if !bytes.Equal(ct, again) {
+			t.Errorf("%d bytes: expected the same ciphertext", n)
+		}
+		if got, err := Decrypt(ct, dbKey); err != nil || !bytes.Equal(got, value) {
+			t.Errorf("%d bytes: unexpected decrypted value %q %v", n, got, err)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package locknut
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"go.etcd.io/bbolt"
+	"sync"
+)
+
+// ErrSnapshotClosed is returned by the reads of a ReadTx after Close
+var ErrSnapshotClosed = errors.New("snapshot is closed")
+
+// ReadTx reads the db as it was when Snapshot was called, every read seeing the same state whatever
+// is written meanwhile. It holds a bbolt read transaction: writes that need the db file to grow past
+// the size set WithInitialMmapSize wait for it to close, so it should be closed as soon as the reads
+// are done, and it must be closed before the BoltLocknut is.
+type ReadTx interface {
+	Get(bucket, key string) ([]byte, error)
+	GetOne(bucket, key string) ([]byte, error)
+	GetByPrefix(bucket, prefix string) (map[string][]byte, error)
+	GetKeyList(bucket, prefix string) ([]string, error)
+	Buckets() ([]string, error)
+	Close() error
+}
+
+// auditedRead is a read of a snapshot, logged to the audit log when it is closed
+type auditedRead struct {
+	op, bucket, key string
+}
+
+// snapshot is the ReadTx of a BoltLocknut
+type snapshot struct {
+	bl *BoltLocknut
+
+	mu    sync.Mutex // guards tx, which is not safe for concurrent use, and reads
+	tx    *bbolt.Tx
+	reads []auditedRead
+}
+
+// Snapshot returns a ReadTx over the current state of the db, for reads across buckets and calls that
+// are consistent with each other. Its reads are decrypted and passed to the AfterGet hooks like the
+// reads of the BoltLocknut, and logged to the audit log when it is closed, since the log cannot be
+// written while the snapshot is open.
+func (bl *BoltLocknut) Snapshot() (ReadTx, error) {
+	// the snapshot holds the key for each read, not for its lifetime, so it does not keep Lock waiting
+	if err := bl.touch(); err != nil {
+		return nil, err
+	}
+	if err := bl.checkKeyProvider(); err != nil {
+		return nil, err
+	}
+	if err := bl.open(); err != nil {
+		return nil, err
+	}
+	tx, err := bl.db.Begin(false)
+	if err != nil {
+		bl.closeFile()
+		return nil, err
+	}
+	return &snapshot{bl: bl, tx: tx}, nil
+}
+
+// Close ends the read transaction and logs the reads to the audit log
+func (s *snapshot) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tx == nil {
+		return nil
+	}
+	err := s.tx.Rollback()
+	s.tx = nil
+	s.bl.closeFile()
+
+	for _, r := range s.reads {
+		if aerr := s.bl.auditRead(context.Background(), r.op, r.bucket, r.key); aerr != nil && err == nil {
+			err = aerr
+		}
+	}
+	s.reads = nil
+	return err
+}
+
+// read runs fn with the transaction of the snapshot
+func (s *snapshot) read(fn func(tx *bbolt.Tx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tx == nil {
+		return ErrSnapshotClosed
+	}
+	if err := s.bl.hold(); err != nil {
+		return err
+	}
+	defer s.bl.release()
+	return fn(s.tx)
+}
+
+// Get is BoltLocknut.Get on the snapshot
+func (s *snapshot) Get(bucket, key string) (result []byte, err error) {
+	err = s.read(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket([]byte(bucket))
+		if bkt == nil {
+			return bucketNotFound("get", bucket)
+		}
+		v := bkt.Get([]byte(key))
+		if expired := s.bl.expiredIn(tx, bucket); v == nil || (expired != nil && expired([]byte(key))) {
+			return nil
+		}
+		if result, err = s.bl.decrypt(bucket, key, v); err != nil {
+			return err
+		}
+		s.reads = append(s.reads, auditedRead{"get", bucket, key})
+		return nil
+	})
+	if err != nil || result == nil {
+		return nil, err
+	}
+	return s.bl.afterGet(context.Background(), bucket, key, result)
+}
+
+// GetOne is BoltLocknut.GetOne on the snapshot
+func (s *snapshot) GetOne(bucket, key string) (result []byte, err error) {
+	if key == "" {
+		return nil, ErrKeyInvalid
+	}
+	var found string
+	err = s.read(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket([]byte(bucket))
+		if bkt == nil {
+			return bucketNotFound("get", bucket)
+		}
+		expired := s.bl.expiredIn(tx, bucket)
+		c := bkt.Cursor()
+		for k, v := c.Seek([]byte(key)); k != nil && bytes.HasPrefix(k, []byte(key)); k, v = c.Next() {
+			if v == nil || (expired != nil && expired(k)) {
+				continue
+			}
+			found = string(k)
+			if result, err = s.bl.decrypt(bucket, found, v); err != nil {
+				return err
+			}
+			s.reads = append(s.reads, auditedRead{"get", bucket, found})
+			return nil
+		}
+		return nil
+	})
+	if err != nil || result == nil {
+		return nil, err
+	}
+	return s.bl.afterGet(context.Background(), bucket, found, result)
+}
+
+// GetByPrefix is BoltLocknut.GetByPrefix on the snapshot
+func (s *snapshot) GetByPrefix(bucket, prefix string) (map[string][]byte, error) {
+	results := make(map[string][]byte)
+	err := s.read(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket([]byte(bucket))
+		if bkt == nil {
+			return bucketNotFound("get", bucket)
+		}
+		expired := s.bl.expiredIn(tx, bucket)
+		c := bkt.Cursor()
+		for k, v := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, v = c.Next() {
+			if v == nil || (expired != nil && expired(k)) {
+				continue
+			}
+			dec, err := s.bl.decrypt(bucket, string(k), v)
+			if err != nil {
+				return err
+			}
+			results[string(k)] = dec
+		}
+		if len(results) > 0 {
+			s.reads = append(s.reads, auditedRead{"get_by_prefix", bucket, prefix})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range results {
+		if len(s.bl.hooks) == 0 {
+			break
+		}
+		v, err := s.bl.afterGet(context.Background(), bucket, k, v)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			delete(results, k)
+		} else {
+			results[k] = v
+		}
+	}
+	return results, nil
+}
+
+// GetKeyList is BoltLocknut.GetKeyList on the snapshot
+func (s *snapshot) GetKeyList(bucket, prefix string) ([]string, error) {
+	keys := make([]string, 0)
+	err := s.read(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket([]byte(bucket))
+		if bkt == nil {
+			return bucketNotFound("list", bucket)
+		}
+		expired := s.bl.expiredIn(tx, bucket)
+		c := bkt.Cursor()
+		for k, _ := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, _ = c.Next() {
+			if expired == nil || !expired(k) {
+				keys = append(keys, string(k))
+			}
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// Buckets is BoltLocknut.Buckets on the snapshot
+func (s *snapshot) Buckets() ([]string, error) {
+	names := make([]string, 0)
+	err := s.read(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bbolt.Bucket) error {
+			if !isInternalBucket(name) {
+				names = append(names, string(name))
+			}
+			return nil
+		})
+	})
+	return names, err
+}
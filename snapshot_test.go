@@ -0,0 +1,79 @@
+package locknut
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("snapshot_test.db")
+
+	bl, err := NewBoltLocknut("snapshot_test.db", ".", []byte("secret"), false, []string{"accounts", "ledger"},
+		WithAuditLog(nil), WithInitialMmapSize(1<<20))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if err = bl.Save("accounts", "ada", 100); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if err = bl.Save("ledger", "0001", "open ada"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+
+	snap, err := bl.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot: %s", err)
+	}
+	// writes after the snapshot are not seen by it
+	if err = bl.Save("accounts", "ada", 50); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if err = bl.Save("ledger", "0002", "ada pays 50"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if v, err := snap.Get("accounts", "ada"); err != nil || string(v) != "100" {
+		t.Errorf("expected the balance as of the snapshot, got %s %v", v, err)
+	}
+	if v, err := snap.GetOne("accounts", "a"); err != nil || string(v) != "100" {
+		t.Errorf("expected the balance as of the snapshot, got %s %v", v, err)
+	}
+	if entries, err := snap.GetByPrefix("ledger", ""); err != nil || len(entries) != 1 {
+		t.Errorf("expected one ledger entry, got %v %v", entries, err)
+	}
+	if keys, err := snap.GetKeyList("ledger", "000"); err != nil || len(keys) != 1 {
+		t.Errorf("expected one ledger key, got %v %v", keys, err)
+	}
+	if names, err := snap.Buckets(); err != nil || len(names) != 2 {
+		t.Errorf("unexpected buckets %v %v", names, err)
+	}
+	if _, err = snap.Get("missing", "ada"); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("expected ErrBucketNotFound, got %v", err)
+	}
+	if v, err := bl.Get("accounts", "ada"); err != nil || string(v) != "50" {
+		t.Errorf("expected the new balance outside the snapshot, got %s %v", v, err)
+	}
+
+	// reads are audited once the snapshot is closed
+	before, err := bl.AuditLog(0)
+	if err != nil {
+		t.Fatalf("AuditLog: %s", err)
+	}
+	if err = snap.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	after, err := bl.AuditLog(0)
+	if err != nil {
+		t.Fatalf("AuditLog: %s", err)
+	}
+	if len(after)-len(before) != 3 {
+		t.Errorf("expected the 3 reads of the snapshot audited, got %d", len(after)-len(before))
+	}
+	if _, err = snap.Get("accounts", "ada"); !errors.Is(err, ErrSnapshotClosed) {
+		t.Errorf("expected ErrSnapshotClosed, got %v", err)
+	}
+	if err = snap.Close(); err != nil {
+		t.Errorf("expected closing twice to be a no-op, got %v", err)
+	}
+}
@@ -0,0 +1,265 @@
+package locknut
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"time"
+)
+
+// tombstoneBucket holds, in a bucket per record bucket, the records removed by SoftDelete
+var tombstoneBucket = []byte(internalPrefix + "tombstones")
+
+// ErrRecordExists is returned by Undelete when a record was saved under the key since it was deleted
+var ErrRecordExists = errors.New("record exists")
+
+// tombstone is a soft deleted record, its value and labels still in their stored form
+type tombstone struct {
+	Deleted      time.Time       `json:"deleted"`
+	Value        []byte          `json:"value"`
+	SealedLabels []byte          `json:"sealed_labels,omitempty"`
+	Meta         json.RawMessage `json:"meta,omitempty"`
+}
+
+// DeletedRecord is a record SoftDelete removed, see ListDeleted
+type DeletedRecord struct {
+	Key     string
+	Deleted time.Time
+}
+
+// SoftDelete removes the record stored under key from bucket like Delete does, so reads no longer see
+// it, but keeps it, still encrypted, with its labels and meta, until Undelete brings it back or
+// PurgeDeleted drops it. Soft deleting a key deleted before replaces the record kept for it.
+func (bl *BoltLocknut) SoftDelete(bucket, key string) error {
+	return bl.write(func(w *writeTx) error {
+		bkt := w.tx.Bucket([]byte(bucket))
+		if bkt == nil {
+			return bucketNotFound("soft delete", bucket)
+		}
+		stored := bkt.Get([]byte(key))
+		if stored == nil {
+			return fmt.Errorf("%w: %s/%s", ErrRecordNotFound, bucket, key)
+		}
+		t := tombstone{
+			Deleted:      w.bl.now().UTC(),
+			Value:        append([]byte(nil), stored...),
+			SealedLabels: nestedValue(w.tx, labelsBucket, bucket, key),
+			Meta:         nestedValue(w.tx, recordMetaBucket, bucket, key),
+		}
+		b, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+
+		root, err := w.tx.CreateBucketIfNotExists(tombstoneBucket)
+		if err != nil {
+			return err
+		}
+		tb, err := root.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		if err = tb.Put([]byte(key), b); err != nil {
+			return err
+		}
+		return w.delete(bucket, key)
+	})
+}
+
+// Undelete brings back the record SoftDelete removed from bucket under key. It fails with
+// ErrRecordNotFound when there is none, and with ErrRecordExists when a record was saved under key
+// since.
+func (bl *BoltLocknut) Undelete(bucket, key string) error {
+	return bl.write(func(w *writeTx) error {
+		bkt := w.tx.Bucket([]byte(bucket))
+		if bkt == nil {
+			return bucketNotFound("undelete", bucket)
+		}
+		t, err := readTombstone(w.tx, bucket, key)
+		if err != nil {
+			return err
+		}
+		if bkt.Get([]byte(key)) != nil {
+			return fmt.Errorf("%w: %s/%s", ErrRecordExists, bucket, key)
+		}
+
+		if err = w.putStored(bucket, key, t.Value); err != nil {
+			return err
+		}
+		if t.SealedLabels != nil {
+			if err = w.putSealedLabels(bucket, key, t.SealedLabels); err != nil {
+				return err
+			}
+		}
+		if t.Meta != nil {
+			root, err := w.tx.CreateBucketIfNotExists(recordMetaBucket)
+			if err != nil {
+				return err
+			}
+			mb, err := root.CreateBucketIfNotExists([]byte(bucket))
+			if err != nil {
+				return err
+			}
+			if err = mb.Put([]byte(key), t.Meta); err != nil {
+				return err
+			}
+		}
+		return w.tx.Bucket(tombstoneBucket).Bucket([]byte(bucket)).Delete([]byte(key))
+	})
+}
+
+// ListDeleted returns the records of bucket SoftDelete removed, in key order
+func (bl *BoltLocknut) ListDeleted(bucket string) ([]DeletedRecord, error) {
+	var err error
+	if err = bl.openDB(); err != nil {
+		return nil, err
+	}
+	defer bl.closeDB()
+
+	var deleted []DeletedRecord
+	err = bl.db.view(func(tx *bbolt.Tx) error {
+		root := tx.Bucket(tombstoneBucket)
+		if root == nil {
+			return nil
+		}
+		tb := root.Bucket([]byte(bucket))
+		if tb == nil {
+			return nil
+		}
+		return tb.ForEach(func(k, v []byte) error {
+			var t tombstone
+			if err := json.Unmarshal(v, &t); err != nil {
+				return fmt.Errorf("%w: tombstone of %s/%s: %v", ErrCorrupt, bucket, k, err)
+			}
+			deleted = append(deleted, DeletedRecord{Key: string(k), Deleted: t.Deleted})
+			return nil
+		})
+	})
+	return deleted, err
+}
+
+// PurgeDeleted drops the records soft deleted more than olderThan ago, in every bucket, and returns
+// how many it dropped. They cannot be brought back afterwards.
+func (bl *BoltLocknut) PurgeDeleted(olderThan time.Duration) (int, error) {
+	cutoff := bl.now().Add(-olderThan)
+	purged := 0
+	err := bl.write(func(w *writeTx) error {
+		purged = 0
+		root := w.tx.Bucket(tombstoneBucket)
+		if root == nil {
+			return nil
+		}
+		var empty [][]byte
+		err := root.ForEach(func(name, _ []byte) error {
+			tb := root.Bucket(name)
+			if tb == nil {
+				return nil
+			}
+			var expired [][]byte
+			err := tb.ForEach(func(k, v []byte) error {
+				var t tombstone
+				if err := json.Unmarshal(v, &t); err != nil {
+					return fmt.Errorf("%w: tombstone of %s/%s: %v", ErrCorrupt, name, k, err)
+				}
+				if t.Deleted.Before(cutoff) {
+					expired = append(expired, append([]byte(nil), k...))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, k := range expired {
+				if err := tb.Delete(k); err != nil {
+					return err
+				}
+			}
+			purged += len(expired)
+			if tb.Stats().KeyN == len(expired) {
+				empty = append(empty, append([]byte(nil), name...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, name := range empty {
+			if err := root.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return purged, err
+}
+
+// readTombstone returns what SoftDelete kept of bucket/key
+func readTombstone(tx *bbolt.Tx, bucket, key string) (tombstone, error) {
+	var t tombstone
+	v := nestedValue(tx, tombstoneBucket, bucket, key)
+	if v == nil {
+		return t, fmt.Errorf("%w: no deleted record %s/%s", ErrRecordNotFound, bucket, key)
+	}
+	if err := json.Unmarshal(v, &t); err != nil {
+		return t, fmt.Errorf("%w: tombstone of %s/%s: %v", ErrCorrupt, bucket, key, err)
+	}
+	return t, nil
+}
+
+// nestedValue returns a copy of the value of key in the bucket named bucket of the internal bucket
+// root, or nil
+func nestedValue(tx *bbolt.Tx, root []byte, bucket, key string) []byte {
+	r := tx.Bucket(root)
+	if r == nil {
+		return nil
+	}
+	b := r.Bucket([]byte(bucket))
+	if b == nil {
+		return nil
+	}
+	if v := b.Get([]byte(key)); v != nil {
+		return append([]byte(nil), v...)
+	}
+	return nil
+}
+
+// rotateTombstones re-encrypts the values and labels kept by SoftDelete, fn and labels giving their
+// transforms for a bucket
+func rotateTombstones(root *bbolt.Bucket, fn, labels func(bucket string) transformFunc) error {
+	var names []string
+	err := root.ForEach(func(name, _ []byte) error {
+		names = append(names, string(name))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		tb := root.Bucket([]byte(name))
+		if tb == nil {
+			continue
+		}
+		reencrypt, relabel := fn(name), labels(name)
+		err := rewriteValues(tb, func(v []byte) ([]byte, error) {
+			var t tombstone
+			if err := json.Unmarshal(v, &t); err != nil {
+				return nil, err
+			}
+			var err error
+			if t.Value, err = reencrypt(t.Value); err != nil {
+				return nil, err
+			}
+			if t.SealedLabels != nil {
+				if t.SealedLabels, err = relabel(t.SealedLabels); err != nil {
+					return nil, err
+				}
+			}
+			return json.Marshal(t)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package locknut
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSoftDelete(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("softdelete_test.db")
+
+	clock := &fakeClock{t: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	bl, err := NewBoltLocknut("softdelete_test.db", ".", []byte("secret"), false, []string{"people"},
+		WithClock(clock), WithRecordMeta())
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	ctx := WithLabels(context.Background(), Labels{"classification": "pii"})
+	for _, key := range []string{"ada", "bob"} {
+		if err = bl.SaveContext(ctx, "people", key, key); err != nil {
+			t.Fatalf("Save: %s", err)
+		}
+	}
+
+	if err = bl.SoftDelete("people", "ada"); err != nil {
+		t.Fatalf("SoftDelete: %s", err)
+	}
+	if err = bl.SoftDelete("people", "nobody"); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expected ErrRecordNotFound, got %v", err)
+	}
+	if v, err := bl.Get("people", "ada"); err != nil || v != nil {
+		t.Errorf("expected the record hidden, got %s %v", v, err)
+	}
+	if n, err := bl.Count("people"); err != nil || n != 1 {
+		t.Errorf("expected 1 record, got %d %v", n, err)
+	}
+	if deleted, err := bl.ListDeleted("people"); err != nil || len(deleted) != 1 || deleted[0].Key != "ada" || !deleted[0].Deleted.Equal(clock.t) {
+		t.Errorf("unexpected deleted records %+v %v", deleted, err)
+	}
+
+	// the record survives a key rotation
+	if err = bl.RotateKey([]byte("new secret")); err != nil {
+		t.Fatalf("RotateKey: %s", err)
+	}
+	if err = bl.Undelete("people", "ada"); err != nil {
+		t.Fatalf("Undelete: %s", err)
+	}
+	if v, err := bl.Get("people", "ada"); err != nil || string(v) != `"ada"` {
+		t.Errorf("unexpected value %s %v", v, err)
+	}
+	if labels, err := bl.GetLabels("people", "ada"); err != nil || labels["classification"] != "pii" {
+		t.Errorf("expected the labels back, got %v %v", labels, err)
+	}
+	if meta, err := bl.Meta("people", "ada"); err != nil || meta.ContentType != ContentTypeJSON {
+		t.Errorf("expected the meta back, got %+v %v", meta, err)
+	}
+	if err = bl.Undelete("people", "ada"); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expected ErrRecordNotFound, got %v", err)
+	}
+
+	// a record saved since is not overwritten
+	if err = bl.SoftDelete("people", "ada"); err != nil {
+		t.Fatalf("SoftDelete: %s", err)
+	}
+	if err = bl.Save("people", "ada", "another ada"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if err = bl.Undelete("people", "ada"); !errors.Is(err, ErrRecordExists) {
+		t.Errorf("expected ErrRecordExists, got %v", err)
+	}
+
+	clock.t = clock.t.Add(48 * time.Hour)
+	if err = bl.SoftDelete("people", "bob"); err != nil {
+		t.Fatalf("SoftDelete: %s", err)
+	}
+	if n, err := bl.PurgeDeleted(24 * time.Hour); err != nil || n != 1 {
+		t.Errorf("expected 1 record purged, got %d %v", n, err)
+	}
+	if deleted, err := bl.ListDeleted("people"); err != nil || len(deleted) != 1 || deleted[0].Key != "bob" {
+		t.Errorf("unexpected deleted records %+v %v", deleted, err)
+	}
+	if n, err := bl.PurgeDeleted(0); err != nil || n != 0 {
+		t.Errorf("expected nothing purged, got %d %v", n, err)
+	}
+	clock.t = clock.t.Add(time.Second)
+	if n, err := bl.PurgeDeleted(0); err != nil || n != 1 {
+		t.Errorf("expected 1 record purged, got %d %v", n, err)
+	}
+	if err = bl.Undelete("people", "bob"); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expected ErrRecordNotFound, got %v", err)
+	}
+}
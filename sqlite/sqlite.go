@@ -0,0 +1,307 @@
+// Package sqlite implements locknut.Locknut on SQLite, for deployments that already operate SQLite
+// files. Records are kept in a single table with a bucket column, their values encrypted exactly like
+// BoltLocknut encrypts them. The driver is the pure Go modernc.org/sqlite, so no cgo is needed.
+package sqlite
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/taybart/locknut"
+	_ "modernc.org/sqlite"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS buckets (name TEXT PRIMARY KEY) WITHOUT ROWID;
+CREATE TABLE IF NOT EXISTS records (
+	bucket TEXT NOT NULL REFERENCES buckets(name),
+	key    BLOB NOT NULL,
+	value  BLOB NOT NULL,
+	PRIMARY KEY (bucket, key)
+) WITHOUT ROWID;
+CREATE TABLE IF NOT EXISTS meta (name TEXT PRIMARY KEY, value BLOB NOT NULL) WITHOUT ROWID;
+`
+
+var canaryPlaintext = []byte("locknut canary v1")
+
+// SQLiteLocknut is a locknut.Locknut stored in a SQLite db
+type SQLiteLocknut struct {
+	db     *sql.DB
+	secret []byte
+}
+
+var _ locknut.Locknut = (*SQLiteLocknut)(nil)
+
+// NewSQLiteLocknut opens the SQLite db at path, creating it, its tables and buckets if needed. Values
+// are encrypted with the key derived from secret, and opening an existing db with another secret
+// fails with locknut.ErrWrongSecret.
+func NewSQLiteLocknut(path string, secret []byte, buckets []string) (*SQLiteLocknut, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	sl := &SQLiteLocknut{db: db, secret: locknut.DeriveKey(secret)}
+
+	if err = sl.init(buckets); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return sl, nil
+}
+
+func (sl *SQLiteLocknut) init(buckets []string) error {
+	if _, err := sl.db.Exec(schema); err != nil {
+		return err
+	}
+	if err := sl.checkSecret(); err != nil {
+		return err
+	}
+	for _, b := range buckets {
+		if err := sl.CreateBucket(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the db
+func (sl *SQLiteLocknut) Close() error {
+	return sl.db.Close()
+}
+
+// checkSecret verifies the secret against the canary, writing it for a new db
+func (sl *SQLiteLocknut) checkSecret() error {
+	var stored []byte
+	err := sl.db.QueryRow(`SELECT value FROM meta WHERE name = 'canary'`).Scan(&stored)
+	if err == sql.ErrNoRows {
+		if stored, err = locknut.Encrypt(canaryPlaintext, sl.secret); err != nil {
+			return err
+		}
+		_, err = sl.db.Exec(`INSERT INTO meta (name, value) VALUES ('canary', ?)`, stored)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	plain, err := locknut.Decrypt(stored, sl.secret)
+	if err != nil || !bytes.Equal(plain, canaryPlaintext) {
+		return locknut.ErrWrongSecret
+	}
+	return nil
+}
+
+// queryer is a *sql.DB or a *sql.Tx
+type queryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// requireBucket fails with a locknut.RecordError of kind locknut.ErrBucketNotFound if bucket does
+// not exist
+func requireBucket(q queryer, op, bucket string) error {
+	var n int
+	if err := q.QueryRow(`SELECT COUNT(*) FROM buckets WHERE name = ?`, bucket).Scan(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		return &locknut.RecordError{Op: op, Bucket: bucket, Kind: locknut.ErrBucketNotFound}
+	}
+	return nil
+}
+
+func (sl *SQLiteLocknut) decrypt(bucket, key string, stored []byte) ([]byte, error) {
+	plain, err := locknut.Decrypt(stored, sl.secret)
+	if err != nil {
+		kind := locknut.ErrDecrypt
+		if errors.Is(err, locknut.ErrCiphertextTooShort) {
+			kind = locknut.ErrCorrupt
+		}
+		return nil, &locknut.RecordError{Op: "decrypt", Bucket: bucket, Key: key, Kind: kind, Err: err}
+	}
+	return plain, nil
+}
+
+// Save stores data as json under key in bucket
+func (sl *SQLiteLocknut) Save(bucket, key string, data interface{}) error {
+	if data == nil {
+		return errors.New("data is nil")
+	}
+	value, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return sl.SaveBytes(bucket, key, value)
+}
+
+// SaveBytes stores data as it is under key in bucket
+func (sl *SQLiteLocknut) SaveBytes(bucket, key string, data []byte) error {
+	if data == nil {
+		return errors.New("data is nil")
+	}
+	if key == "" {
+		return locknut.ErrKeyInvalid
+	}
+	stored, err := locknut.Encrypt(data, sl.secret)
+	if err != nil {
+		return &locknut.RecordError{Op: "encrypt", Bucket: bucket, Key: key, Kind: locknut.ErrEncrypt, Err: err}
+	}
+
+	tx, err := sl.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err = requireBucket(tx, "put", bucket); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO records (bucket, key, value) VALUES (?, ?, ?)
+		ON CONFLICT (bucket, key) DO UPDATE SET value = excluded.value`, bucket, []byte(key), stored)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Get returns the record stored under exactly key, or nil if there is none
+func (sl *SQLiteLocknut) Get(bucket, key string) ([]byte, error) {
+	if err := requireBucket(sl.db, "get", bucket); err != nil {
+		return nil, err
+	}
+	var stored []byte
+	err := sl.db.QueryRow(`SELECT value FROM records WHERE bucket = ? AND key = ?`, bucket, []byte(key)).Scan(&stored)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sl.decrypt(bucket, key, stored)
+}
+
+// prefixQuery selects the records of a bucket whose keys start with a prefix, in key order. Keys are
+// blobs, so they compare byte by byte like bbolt keys.
+const prefixQuery = `FROM records WHERE bucket = ? AND key >= ? AND substr(key, 1, ?) = ? ORDER BY key`
+
+func prefixArgs(bucket, prefix string) []interface{} {
+	p := []byte(prefix)
+	return []interface{}{bucket, p, len(p), p}
+}
+
+// scan calls fn with every record of bucket whose key starts with prefix, until fn returns false
+func (sl *SQLiteLocknut) scan(op, bucket, prefix string, fn func(key string, stored []byte) (bool, error)) error {
+	if err := requireBucket(sl.db, op, bucket); err != nil {
+		return err
+	}
+	rows, err := sl.db.Query(`SELECT key, value `+prefixQuery, prefixArgs(bucket, prefix)...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key, stored []byte
+		if err = rows.Scan(&key, &stored); err != nil {
+			return err
+		}
+		more, err := fn(string(key), stored)
+		if err != nil || !more {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// GetOne returns the first record whose key starts with key
+func (sl *SQLiteLocknut) GetOne(bucket, key string) (result []byte, err error) {
+	if key == "" {
+		return nil, locknut.ErrKeyInvalid
+	}
+	err = sl.scan("get", bucket, key, func(k string, stored []byte) (bool, error) {
+		result, err = sl.decrypt(bucket, k, stored)
+		return false, err
+	})
+	return result, err
+}
+
+// GetByPrefix returns the decrypted records whose keys start with prefix
+func (sl *SQLiteLocknut) GetByPrefix(bucket, prefix string) (map[string][]byte, error) {
+	results := make(map[string][]byte)
+	err := sl.scan("get", bucket, prefix, func(k string, stored []byte) (bool, error) {
+		v, err := sl.decrypt(bucket, k, stored)
+		results[k] = v
+		return true, err
+	})
+	return results, err
+}
+
+// GetKeyList returns the keys of bucket starting with prefix, in order
+func (sl *SQLiteLocknut) GetKeyList(bucket, prefix string) ([]string, error) {
+	if err := requireBucket(sl.db, "list", bucket); err != nil {
+		return nil, err
+	}
+	rows, err := sl.db.Query(`SELECT key `+prefixQuery, prefixArgs(bucket, prefix)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]string, 0)
+	for rows.Next() {
+		var key []byte
+		if err = rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, string(key))
+	}
+	return keys, rows.Err()
+}
+
+// Delete removes key from bucket
+func (sl *SQLiteLocknut) Delete(bucket, key string) error {
+	if key == "" {
+		return errors.New("cannot delete, key is nil")
+	}
+	if err := requireBucket(sl.db, "delete", bucket); err != nil {
+		return err
+	}
+	_, err := sl.db.Exec(`DELETE FROM records WHERE bucket = ? AND key = ?`, bucket, []byte(key))
+	return err
+}
+
+// Buckets returns the names of the buckets in the db
+func (sl *SQLiteLocknut) Buckets() ([]string, error) {
+	rows, err := sl.db.Query(`SELECT name FROM buckets ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// CreateBucket creates the bucket if it does not exist yet
+func (sl *SQLiteLocknut) CreateBucket(name string) error {
+	if name == "" {
+		return locknut.ErrBucketInvalid
+	}
+	_, err := sl.db.Exec(`INSERT INTO buckets (name) VALUES (?) ON CONFLICT DO NOTHING`, name)
+	return err
+}
+
+// Count returns the number of records in bucket
+func (sl *SQLiteLocknut) Count(bucket string) (int, error) {
+	if err := requireBucket(sl.db, "count", bucket); err != nil {
+		return 0, err
+	}
+	var n int
+	err := sl.db.QueryRow(`SELECT COUNT(*) FROM records WHERE bucket = ?`, bucket).Scan(&n)
+	return n, err
+}
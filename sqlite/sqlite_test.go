@@ -0,0 +1,71 @@
+package sqlite
+
+import (
+	"errors"
+	"github.com/taybart/locknut"
+	"os"
+	"testing"
+)
+
+func TestSQLiteLocknut(t *testing.T) {
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		defer os.Remove("sqlite_test.db" + suffix)
+	}
+
+	sl, err := NewSQLiteLocknut("sqlite_test.db", []byte("secret"), []string{"articles"})
+	if err != nil {
+		t.Fatalf("NewSQLiteLocknut: %s", err)
+	}
+
+	for k, v := range map[string]string{"a1": "one", "a2": "two", "a%": "percent", "b1": "three"} {
+		if err = sl.Save("articles", k, v); err != nil {
+			t.Fatalf("Save: %s", err)
+		}
+	}
+	if err = sl.Save("articles", "a1", "one again"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if err = sl.Save("missing", "a1", "one"); !errors.Is(err, locknut.ErrBucketNotFound) {
+		t.Errorf("expected ErrBucketNotFound, got %v", err)
+	}
+
+	if v, err := sl.Get("articles", "a1"); err != nil || string(v) != `"one again"` {
+		t.Errorf("unexpected Get %q %v", v, err)
+	}
+	if v, err := sl.Get("articles", "a"); err != nil || v != nil {
+		t.Errorf("expected no record for a prefix, got %q %v", v, err)
+	}
+	if v, err := sl.GetOne("articles", "a"); err != nil || string(v) != `"percent"` {
+		t.Errorf("unexpected GetOne %q %v", v, err)
+	}
+	if res, err := sl.GetByPrefix("articles", "a"); err != nil || len(res) != 3 || string(res["a2"]) != `"two"` {
+		t.Errorf("unexpected GetByPrefix %v %v", res, err)
+	}
+	keys, err := sl.GetKeyList("articles", "")
+	if err != nil || len(keys) != 4 || keys[0] != "a%" || keys[3] != "b1" {
+		t.Errorf("unexpected GetKeyList %v %v", keys, err)
+	}
+	if err = sl.Delete("articles", "a2"); err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+	if n, err := sl.Count("articles"); err != nil || n != 3 {
+		t.Errorf("unexpected Count %d %v", n, err)
+	}
+	if names, err := sl.Buckets(); err != nil || len(names) != 1 || names[0] != "articles" {
+		t.Errorf("unexpected Buckets %v %v", names, err)
+	}
+
+	// values are stored as BoltLocknut stores them
+	var stored []byte
+	if err = sl.db.QueryRow(`SELECT value FROM records WHERE key = ?`, []byte("b1")).Scan(&stored); err != nil {
+		t.Fatalf("QueryRow: %s", err)
+	}
+	if plain, err := locknut.Decrypt(stored, locknut.DeriveKey([]byte("secret"))); err != nil || string(plain) != `"three"` {
+		t.Errorf("unexpected stored value %q %v", plain, err)
+	}
+	sl.Close()
+
+	if _, err = NewSQLiteLocknut("sqlite_test.db", []byte("other secret"), nil); err != locknut.ErrWrongSecret {
+		t.Errorf("expected ErrWrongSecret, got %v", err)
+	}
+}
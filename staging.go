@@ -0,0 +1,396 @@
+package locknut
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"io"
+	"reflect"
+	"sort"
+)
+
+// stagingBucket holds a bucket per running ImportStaged, named by its ID, with a bucket of staged
+// records per target bucket
+var stagingBucket = []byte(internalPrefix + "staging")
+
+// sideBuckets are the internal buckets keeping data about records in a bucket per record bucket,
+// which SwapBuckets exchanges along with the records
+var sideBuckets = [][]byte{labelsBucket, recordMetaBucket, expiryBucket}
+
+// ErrStagingCheck is returned by ImportStaged when the staged records fail a check of StagingOptions
+var ErrStagingCheck = errors.New("staged import failed its checks")
+
+// StagingOptions are the checks ImportStaged runs on the staged records before promoting them
+type StagingOptions struct {
+	// Bucket is the bucket records are loaded into, "" for the Bucket field of each record
+	Bucket string
+	// Counts, when set, is the number of records expected in each bucket. Buckets missing from it
+	// must not receive any record.
+	Counts map[string]int
+	// SHA256, when set, is the hex digest the input must have
+	SHA256 string
+	// Validate, when set, is called for every record as it is staged
+	Validate func(Record) error
+}
+
+// StagingReport describes a staged import
+type StagingReport struct {
+	// Counts is the number of records loaded into each bucket
+	Counts map[string]int
+	// SHA256 is the hex digest of the input
+	SHA256 string
+}
+
+// ImportStaged is a two phase Import. The records in r are first loaded into a hidden staging area,
+// checked against the schema (see WithStrictSchema and RegisterType) and the checks of opts, then
+// promoted in a single transaction: each target bucket is replaced by its staged records. An import
+// failing at any point leaves the live buckets untouched, so a bulk load never leaves a half written
+// bucket behind. Checks failing after the input was read return ErrStagingCheck with the report. The
+// BeforeSave hooks are called for every record as it is staged, the After hooks are not called by the
+// promotion, which replaces whole buckets.
+func (bl *BoltLocknut) ImportStaged(r io.Reader, format Format, opts StagingOptions) (StagingReport, error) {
+	report := StagingReport{Counts: make(map[string]int)}
+
+	id, err := bl.randomID()
+	if err != nil {
+		return report, err
+	}
+	name := []byte(id)
+	defer bl.write(func(w *writeTx) error {
+		if root := w.tx.Bucket(stagingBucket); root != nil && root.Bucket(name) != nil {
+			return root.DeleteBucket(name)
+		}
+		return nil
+	})
+
+	sum := sha256.New()
+	err = bl.importRecords(io.TeeReader(r, sum), format, opts.Bucket, func(b importBatch) error {
+		for _, rec := range b.records {
+			if err := bl.validateRecord(rec); err != nil {
+				return err
+			}
+			if opts.Validate != nil {
+				if err := opts.Validate(rec); err != nil {
+					return &RecordError{Op: "import", Bucket: rec.Bucket, Key: rec.Key, Kind: ErrValidation, Err: err}
+				}
+			}
+		}
+		return bl.write(func(w *writeTx) error {
+			staging, err := stagingArea(w.tx, name)
+			if err != nil {
+				return err
+			}
+			for i, rec := range b.records {
+				bkt, err := staging.CreateBucketIfNotExists([]byte(rec.Bucket))
+				if err != nil {
+					return err
+				}
+				if bkt.Get([]byte(rec.Key)) == nil {
+					report.Counts[rec.Bucket]++
+				}
+				if err = bkt.Put([]byte(rec.Key), b.stored[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return report, err
+	}
+	// the decoder stops at the last record, the digest covers the whole input
+	if _, err = io.Copy(sum, r); err != nil {
+		return report, err
+	}
+	report.SHA256 = hex.EncodeToString(sum.Sum(nil))
+
+	if err = opts.check(report); err != nil {
+		return report, err
+	}
+	return report, bl.promote(name)
+}
+
+// check compares the report of a staged import with the expected counts and digest
+func (opts StagingOptions) check(report StagingReport) error {
+	if opts.SHA256 != "" && opts.SHA256 != report.SHA256 {
+		return fmt.Errorf("%w: input sha256 is %s, expected %s", ErrStagingCheck, report.SHA256, opts.SHA256)
+	}
+	if opts.Counts == nil {
+		return nil
+	}
+	for bucket, n := range report.Counts {
+		if n != opts.Counts[bucket] {
+			return fmt.Errorf("%w: %d records for %s, expected %d", ErrStagingCheck, n, bucket, opts.Counts[bucket])
+		}
+	}
+	for bucket, n := range opts.Counts {
+		if n != report.Counts[bucket] {
+			return fmt.Errorf("%w: %d records for %s, expected %d", ErrStagingCheck, report.Counts[bucket], bucket, n)
+		}
+	}
+	return nil
+}
+
+// stagingArea returns the staging bucket of the import with name, creating it as needed
+func stagingArea(tx *bbolt.Tx, name []byte) (*bbolt.Bucket, error) {
+	root, err := tx.CreateBucketIfNotExists(stagingBucket)
+	if err != nil {
+		return nil, err
+	}
+	return root.CreateBucketIfNotExists(name)
+}
+
+// promote replaces every bucket staged by the import with name by its staged records, in one
+// transaction
+func (bl *BoltLocknut) promote(name []byte) error {
+	return bl.write(func(w *writeTx) error {
+		staging, err := stagingArea(w.tx, name)
+		if err != nil {
+			return err
+		}
+		var buckets []string
+		staging.ForEach(func(k, v []byte) error {
+			buckets = append(buckets, string(k))
+			return nil
+		})
+		sort.Strings(buckets)
+
+		for _, bucket := range buckets {
+			if err = w.replaceBucket(bucket, staging.Bucket([]byte(bucket)), bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// rotateStaging re-encrypts the records staged by running imports, fn giving the transform of a
+// bucket, so they read with the new key once promoted
+func rotateStaging(root *bbolt.Bucket, fn func(bucket string) transformFunc) error {
+	var staged [][2][]byte
+	err := root.ForEach(func(id, _ []byte) error {
+		if area := root.Bucket(id); area != nil {
+			return area.ForEach(func(name, _ []byte) error {
+				staged = append(staged, [2][]byte{append([]byte(nil), id...), append([]byte(nil), name...)})
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, s := range staged {
+		if bkt := root.Bucket(s[0]).Bucket(s[1]); bkt != nil {
+			if err = rewriteValues(bkt, fn(string(s[1]))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// SwapBuckets exchanges the records of buckets a and b in a single transaction, for blue-green
+// deployments of data: load the next version into a bucket of its own, with ImportStaged for instance,
+// then swap it with the live one. The labels, RecordMeta and expiry of the records move with them. Both
+// buckets must exist.
+func (bl *BoltLocknut) SwapBuckets(a, b string) error {
+	for _, name := range []string{a, b} {
+		if name == "" || isInternalBucket([]byte(name)) {
+			return ErrBucketInvalid
+		}
+		if err := bl.checkBucket("swap", name); err != nil {
+			return err
+		}
+	}
+	if a == b {
+		return nil
+	}
+	id, err := bl.randomID()
+	if err != nil {
+		return err
+	}
+
+	return bl.write(func(w *writeTx) error {
+		bktA, bktB := w.tx.Bucket([]byte(a)), w.tx.Bucket([]byte(b))
+		if bktA == nil {
+			return bucketNotFound("swap", a)
+		}
+		if bktB == nil {
+			return bucketNotFound("swap", b)
+		}
+
+		// a and the data kept about its records are copied aside before they are overwritten with b
+		name := []byte(id)
+		tmp, err := stagingArea(w.tx, name)
+		if err != nil {
+			return err
+		}
+		records, err := tmp.CreateBucket([]byte("records"))
+		if err != nil {
+			return err
+		}
+		if err = copyInto(records, bktA); err != nil {
+			return err
+		}
+		for _, side := range sideBuckets {
+			if src := nestedBucket(w.tx, side, a); src != nil {
+				dst, err := tmp.CreateBucket(side)
+				if err != nil {
+					return err
+				}
+				if err = copyInto(dst, src); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err = w.replaceBucket(a, bktB, b); err != nil {
+			return err
+		}
+		err = w.replaceSide(a, b, func(side []byte) *bbolt.Bucket {
+			return nestedBucket(w.tx, side, b)
+		})
+		if err != nil {
+			return err
+		}
+		if err = w.replaceBucket(b, records, a); err != nil {
+			return err
+		}
+		err = w.replaceSide(b, a, func(side []byte) *bbolt.Bucket {
+			return tmp.Bucket(side)
+		})
+		if err != nil {
+			return err
+		}
+		return w.tx.Bucket(stagingBucket).DeleteBucket(name)
+	})
+}
+
+// replaceSide makes the data kept about the records of bucket in each of sideBuckets a copy of
+// src(side), kept for bucket from, removing it when src returns nil. Labels from another bucket are
+// encrypted again for bucket, like its records.
+func (w *writeTx) replaceSide(bucket, from string, src func(side []byte) *bbolt.Bucket) error {
+	for _, side := range sideBuckets {
+		kept := src(side)
+		root := w.tx.Bucket(side)
+		if root == nil && kept == nil {
+			continue
+		}
+		root, err := w.tx.CreateBucketIfNotExists(side)
+		if err != nil {
+			return err
+		}
+		if root.Bucket([]byte(bucket)) != nil {
+			if err = root.DeleteBucket([]byte(bucket)); err != nil {
+				return err
+			}
+		}
+		if kept == nil {
+			continue
+		}
+		dst, err := root.CreateBucket([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		if err = copyInto(dst, kept); err != nil {
+			return err
+		}
+		if string(side) == string(labelsBucket) && from != bucket && w.bl.secret != nil && w.bl.public == nil {
+			if err = rewriteValues(dst, w.bl.labelResealer(from, w.bl.keyFor(bucket))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// nestedBucket returns the bucket named bucket in root, nil when either is missing
+func nestedBucket(tx *bbolt.Tx, root []byte, bucket string) *bbolt.Bucket {
+	if r := tx.Bucket(root); r != nil {
+		return r.Bucket([]byte(bucket))
+	}
+	return nil
+}
+
+// copyInto copies the values and nested buckets of src into dst
+func copyInto(dst, src *bbolt.Bucket) error {
+	return src.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return dst.Put(k, v)
+		}
+		sub, err := dst.CreateBucketIfNotExists(k)
+		if err != nil {
+			return err
+		}
+		return copyInto(sub, src.Bucket(k))
+	})
+}
+
+// replaceBucket makes the records of bucket those of src, stored for bucket from, creating it as
+// needed. Records are put and deleted one by one so the changelog, the stats and the search index
+// follow. Records from another bucket are sealed again for bucket, whose key differs WithBucketKeys
+// and whose records may be deterministic when from's are not.
+func (w *writeTx) replaceBucket(bucket string, src *bbolt.Bucket, from string) error {
+	live, err := w.tx.CreateBucketIfNotExists([]byte(bucket))
+	if err != nil {
+		return err
+	}
+
+	var gone []string
+	live.ForEach(func(k, v []byte) error {
+		if src.Get(k) == nil {
+			gone = append(gone, string(k))
+		}
+		return nil
+	})
+	for _, key := range gone {
+		if err = w.delete(bucket, key); err != nil {
+			return err
+		}
+	}
+	reseal := func(v []byte) ([]byte, error) {
+		return append([]byte(nil), v...), nil
+	}
+	// records sealed to a public key or kept in clear read the same from any bucket
+	if from != bucket && w.bl.secret != nil && w.bl.public == nil {
+		reseal = w.bl.resealer(from, bucket, w.bl.keyFor(bucket))
+	}
+	return src.ForEach(func(k, v []byte) error {
+		stored, err := reseal(v)
+		if err != nil {
+			return err
+		}
+		return w.putStored(bucket, string(k), stored)
+	})
+}
+
+// validateRecord checks rec against the schema, the limits of the db, the validators of its bucket and
+// those of the type registered for its bucket
+func (bl *BoltLocknut) validateRecord(rec Record) error {
+	if err := bl.checkSchema("import", rec.Bucket, rec.Key); err != nil {
+		return err
+	}
+	if err := bl.checkLimits("import", rec.Bucket, rec.Key, rec.Bytes()); err != nil {
+		return err
+	}
+	if err := bl.validateValue("import", rec.Bucket, rec.Key, rec.Bytes()); err != nil {
+		return err
+	}
+	spec := bl.types[rec.Bucket]
+	if spec == nil {
+		return nil
+	}
+	v := reflect.New(spec.typ)
+	if err := spec.codec.Unmarshal(rec.Bytes(), v.Interface()); err != nil {
+		return &RecordError{Op: "import", Bucket: rec.Bucket, Key: rec.Key, Kind: ErrValidation, Err: err}
+	}
+	for _, validate := range spec.validators {
+		if err := validate(v.Elem().Interface()); err != nil {
+			return &RecordError{Op: "import", Bucket: rec.Bucket, Key: rec.Key, Kind: ErrValidation, Err: err}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package locknut
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"go.etcd.io/bbolt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImportStaged(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("staging_test.db")
+
+	bl, err := NewBoltLocknut("staging_test.db", ".", []byte("secret"), false, nil,
+		RegisterType[Article]("article", Validate(func(a Article) error {
+			if a.ID == "" {
+				return errors.New("id is required")
+			}
+			return nil
+		})),
+	)
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	for _, key := range []string{"ID-0001", "ID-0009"} {
+		if err = bl.Save("article", key, Article{ID: key, Title: "live"}); err != nil {
+			t.Fatalf("Save: %s", err)
+		}
+	}
+
+	in := `{"key":"ID-0001","value":{"id":"ID-0001","title":"imported"}}` + "\n" +
+		`{"key":"ID-0002","value":{"id":"ID-0002","title":"imported"}}` + "\n"
+	sum := sha256.Sum256([]byte(in))
+
+	// failing checks leave the live bucket as it was
+	_, err = bl.ImportStaged(strings.NewReader(in), FormatJSONL, StagingOptions{Bucket: "article", Counts: map[string]int{"article": 3}})
+	if !errors.Is(err, ErrStagingCheck) {
+		t.Fatalf("expected ErrStagingCheck for a wrong count, got %v", err)
+	}
+	_, err = bl.ImportStaged(strings.NewReader(in), FormatJSONL, StagingOptions{Bucket: "article", SHA256: strings.Repeat("0", 64)})
+	if !errors.Is(err, ErrStagingCheck) {
+		t.Fatalf("expected ErrStagingCheck for a wrong digest, got %v", err)
+	}
+	bad := in + `{"key":"ID-0003","value":{"title":"no id"}}` + "\n"
+	if _, err = bl.ImportStaged(strings.NewReader(bad), FormatJSONL, StagingOptions{Bucket: "article"}); !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected ErrValidation, got %v", err)
+	}
+	if keys, _ := bl.GetKeyList("article", ""); len(keys) != 2 || keys[1] != "ID-0009" {
+		t.Fatalf("expected the live bucket untouched, got %v", keys)
+	}
+
+	report, err := bl.ImportStaged(strings.NewReader(in), FormatJSONL, StagingOptions{
+		Bucket: "article",
+		Counts: map[string]int{"article": 2},
+		SHA256: hex.EncodeToString(sum[:]),
+	})
+	if err != nil {
+		t.Fatalf("ImportStaged: %s", err)
+	}
+	if report.Counts["article"] != 2 || report.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if keys, _ := bl.GetKeyList("article", ""); len(keys) != 2 || keys[0] != "ID-0001" || keys[1] != "ID-0002" {
+		t.Errorf("expected the bucket to be replaced by the import, got %v", keys)
+	}
+	a, _, err := GetTyped[Article](bl, "ID-0001")
+	if err != nil || a.Title != "imported" {
+		t.Errorf("unexpected record %+v %v", a, err)
+	}
+
+	if err = bl.openDB(); err != nil {
+		t.Fatal(err)
+	}
+	defer bl.closeDB()
+	bl.db.view(func(tx *bbolt.Tx) error {
+		if root := tx.Bucket(stagingBucket); root != nil && root.Stats().BucketN > 1 {
+			t.Errorf("expected the staging areas to be removed, %d left", root.Stats().BucketN-1)
+		}
+		return nil
+	})
+}
+
+func TestImportStagedRotateKey(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("staging_test.db")
+
+	bl, err := NewBoltLocknut("staging_test.db", ".", []byte("old secret"), false, []string{"article"})
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	defer bl.Close()
+
+	// a record staged by an import still running when the key is rotated
+	name := []byte("import")
+	stored, err := bl.sealFor("article", []byte(`"staged"`))
+	if err != nil {
+		t.Fatalf("sealFor: %s", err)
+	}
+	err = bl.write(func(w *writeTx) error {
+		staging, err := stagingArea(w.tx, name)
+		if err != nil {
+			return err
+		}
+		bkt, err := staging.CreateBucket([]byte("article"))
+		if err != nil {
+			return err
+		}
+		return bkt.Put([]byte("k"), stored)
+	})
+	if err != nil {
+		t.Fatalf("staging: %s", err)
+	}
+
+	if err = bl.RotateKey([]byte("new secret")); err != nil {
+		t.Fatalf("RotateKey: %s", err)
+	}
+	if err = bl.promote(name); err != nil {
+		t.Fatalf("promote: %s", err)
+	}
+	if v, err := bl.GetOne("article", "k"); err != nil || string(v) != `"staged"` {
+		t.Errorf("unexpected promoted record %s %v", v, err)
+	}
+}
+
+func TestSwapBuckets(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("staging_test.db")
+
+	bl, err := NewBoltLocknut("staging_test.db", ".", []byte("secret"), false, []string{"blue", "green"},
+		WithRecordMeta())
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	bl.Save("blue", "shared", "blue")
+	blueMeta, _ := bl.Meta("blue", "shared")
+	bl.Save("blue", "only-blue", "blue")
+	bl.SaveWithTTL("green", "shared", "green", time.Hour)
+	bl.SaveWithTTL("green", "only-green", "green", time.Hour)
+	bl.SetLabels("blue", "shared", Labels{"color": "blue"})
+	bl.SetLabels("green", "only-green", Labels{"color": "green"})
+
+	if err = bl.SwapBuckets("blue", "green"); err != nil {
+		t.Fatalf("SwapBuckets: %s", err)
+	}
+	if keys, _ := bl.GetKeyList("blue", ""); len(keys) != 2 || keys[0] != "only-green" {
+		t.Errorf("unexpected blue keys %v", keys)
+	}
+	if keys, _ := bl.GetKeyList("green", ""); len(keys) != 2 || keys[0] != "only-blue" {
+		t.Errorf("unexpected green keys %v", keys)
+	}
+	if v, err := bl.GetOne("blue", "shared"); err != nil || string(v) != `"green"` {
+		t.Errorf("unexpected blue record %s %v", v, err)
+	}
+	if v, err := bl.GetOne("green", "shared"); err != nil || string(v) != `"blue"` {
+		t.Errorf("unexpected green record %s %v", v, err)
+	}
+	// labels move with their records
+	for _, c := range []struct{ bucket, key, color string }{
+		{"blue", "shared", ""}, {"blue", "only-green", "green"}, {"green", "shared", "blue"},
+	} {
+		if labels, err := bl.GetLabels(c.bucket, c.key); err != nil || labels["color"] != c.color {
+			t.Errorf("unexpected labels of %s/%s %v %v", c.bucket, c.key, labels, err)
+		}
+	}
+	// and so does their metadata
+	if meta, err := bl.Meta("green", "shared"); err != nil || !meta.Created.Equal(blueMeta.Created) {
+		t.Errorf("unexpected meta of green/shared %v %v, expected %v", meta, err, blueMeta)
+	}
+	// and their expiry
+	for _, c := range []struct {
+		bucket, key string
+		expires     bool
+	}{
+		{"blue", "shared", true}, {"blue", "only-green", true}, {"green", "shared", false},
+	} {
+		if expires, err := bl.Expires(c.bucket, c.key); err != nil || expires.IsZero() == c.expires {
+			t.Errorf("unexpected expiry of %s/%s %v %v", c.bucket, c.key, expires, err)
+		}
+	}
+	if n, err := bl.SweepExpired(0); err != nil || n != 0 {
+		t.Errorf("unexpected sweep %d %v", n, err)
+	}
+
+	if err = bl.SwapBuckets("blue", "missing"); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("expected ErrBucketNotFound, got %v", err)
+	}
+	if err = bl.SwapBuckets("blue", internalPrefix+"meta"); !errors.Is(err, ErrBucketInvalid) {
+		t.Errorf("expected ErrBucketInvalid, got %v", err)
+	}
+}
+
+func TestSwapBucketsReseal(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("staging_test.db")
+
+	bl, err := NewBoltLocknut("staging_test.db", ".", []byte("secret"), false, []string{"blue", "green"},
+		WithBucketKeys())
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	bl.Save("blue", "k", "blue")
+	bl.Save("green", "k", "green")
+	if err = bl.SwapBuckets("blue", "green"); err != nil {
+		t.Fatalf("SwapBuckets: %s", err)
+	}
+	// the records are sealed with the key of the bucket they moved to
+	if v, err := bl.GetOne("blue", "k"); err != nil || string(v) != `"green"` {
+		t.Errorf("unexpected blue record %s %v", v, err)
+	}
+	if v, err := bl.GetOne("green", "k"); err != nil || string(v) != `"blue"` {
+		t.Errorf("unexpected green record %s %v", v, err)
+	}
+	bl.Close()
+	os.Remove("staging_test.db")
+
+	bl, err = NewBoltLocknut("staging_test.db", ".", []byte("secret"), false, []string{"blue", "green"},
+		WithDeterministic("blue"))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	defer bl.Close()
+	bl.Save("green", "k", "ada@example.com")
+	if err = bl.SwapBuckets("blue", "green"); err != nil {
+		t.Fatalf("SwapBuckets: %s", err)
+	}
+	// the record moved into the deterministic bucket is found by value
+	if keys, err := bl.FindEqual("blue", "ada@example.com"); err != nil || len(keys) != 1 || keys[0] != "k" {
+		t.Errorf("unexpected keys %v %v", keys, err)
+	}
+}
@@ -0,0 +1,466 @@
+package locknut
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/taybart/log"
+	"go.etcd.io/bbolt"
+	"sync"
+	"time"
+)
+
+// statsBucket is the bucket in the stats db holding one BucketStats record per data bucket
+var statsBucket = []byte("stats")
+
+// The key versions of BucketStats, the keys records can be encrypted with
+const (
+	// KeyVersionSecret is the secret itself, what every record is encrypted with in a db without
+	// bucket keys, and what records written before a db used bucket keys are until they are rewritten
+	KeyVersionSecret = "secret"
+	// KeyVersionBucket is the key of the bucket, see WithBucketKeys
+	KeyVersionBucket = "bucket"
+	// KeyVersionPublic is the public key of WithPublicKey
+	KeyVersionPublic = "public"
+)
+
+// BucketStats is the summary kept per bucket in the stats db. It never contains keys or payloads.
+type BucketStats struct {
+	Keys  int   `json:"keys"`
+	Bytes int64 `json:"bytes"`
+	// PlainBytes is the size of the values before they went through the transformers, padding and
+	// encryption
+	PlainBytes int64 `json:"plain_bytes"`
+	// Encrypted is the number of records stored encrypted, which falls short of Keys while
+	// EncryptExisting left the bucket out
+	Encrypted int `json:"encrypted"`
+	// KeyVersions counts the encrypted records by the key they are encrypted with, one of the
+	// KeyVersion constants, tracking how far the Optimizer moved a bucket to bucket keys
+	KeyVersions map[string]int `json:"key_versions,omitempty"`
+	// Compressed is the number of records that went through a CompressingTransformer, such as Gzip
+	Compressed int       `json:"compressed"`
+	LastWrite  time.Time `json:"last_write"`
+}
+
+// StorageRatio returns the stored bytes per plaintext byte, 1 for an empty bucket
+func (s BucketStats) StorageRatio() float64 {
+	if s.PlainBytes == 0 {
+		return 1
+	}
+	return float64(s.Bytes) / float64(s.PlainBytes)
+}
+
+// EncryptedRatio returns the share of records stored encrypted, 1 for an empty bucket
+func (s BucketStats) EncryptedRatio() float64 {
+	if s.Keys == 0 {
+		return 1
+	}
+	return float64(s.Encrypted) / float64(s.Keys)
+}
+
+// CompressedRatio returns the share of records stored compressed, 0 for an empty bucket
+func (s BucketStats) CompressedRatio() float64 {
+	if s.Keys == 0 {
+		return 0
+	}
+	return float64(s.Compressed) / float64(s.Keys)
+}
+
+// KeyVersionRatio returns the share of the encrypted records encrypted with the key version, 0 for a
+// bucket without encrypted records
+func (s BucketStats) KeyVersionRatio(version string) float64 {
+	if s.Encrypted == 0 {
+		return 0
+	}
+	return float64(s.KeyVersions[version]) / float64(s.Encrypted)
+}
+
+// encryptionOverhead is what Encrypt adds to a value, the nonce and the tag
+const encryptionOverhead = 12 + 16
+
+// statsDelta is the change a single write made to a bucket
+type statsDelta struct {
+	keys       int
+	bytes      int64
+	plain      int64
+	encrypted  int
+	versions   map[string]int
+	compressed int
+	last       time.Time // when the last write of the delta committed, set once queued
+}
+
+// WithStatsDB keeps a small secondary db at path with per bucket counts, stored sizes and last write
+// times. The stats db is not encrypted and holds no payloads, so monitoring agents can read it with
+// ReadStats without ever holding the data key. It is updated in the background a moment after the
+// writes, and on Close. With WithRecordMeta, the stats of a record are kept in its meta, so replacing or
+// deleting it does not decrypt the old value.
+func WithStatsDB(path string) Option {
+	return func(bl *BoltLocknut) {
+		bl.statsPath = path
+	}
+}
+
+// ReadStats opens the stats db at path read only and returns the stats for every bucket
+func ReadStats(path string) (map[string]BucketStats, error) {
+	// read only, the file is never created with the mode
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	results := make(map[string]BucketStats)
+	err = db.View(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket(statsBucket)
+		if bkt == nil {
+			return nil
+		}
+		return bkt.ForEach(func(k, v []byte) error {
+			var s BucketStats
+			if err := json.Unmarshal(v, &s); err != nil {
+				return err
+			}
+			results[string(k)] = s
+			return nil
+		})
+	})
+	return results, err
+}
+
+// keyVersion returns the key version records written now are encrypted with
+func (bl *BoltLocknut) keyVersion() string {
+	switch {
+	case bl.public != nil:
+		return KeyVersionPublic
+	case bl.bucketKeys:
+		return KeyVersionBucket
+	}
+	return KeyVersionSecret
+}
+
+// putStats is what put adds to the stats of a bucket, storing data as stored
+func (bl *BoltLocknut) putStats(data, stored []byte) statsDelta {
+	d := statsDelta{keys: 1, bytes: int64(len(stored)), plain: int64(len(data))}
+	if bl.secret != nil || bl.public != nil {
+		d.encrypted = 1
+		d.versions = map[string]int{bl.keyVersion(): 1}
+		if bl.compresses(bl.stageNames()) {
+			d.compressed = 1
+		}
+	}
+	return d
+}
+
+// recordStats is what a stored value of bucket adds to the stats of its bucket, for values put does
+// not write, and for the values writes replace or delete. The plaintext size and key version are read
+// by opening the value, only done when there is a stats db. A value that does not open, such as one
+// of a bucket EncryptExisting left out, counts as not encrypted. Write only dbs cannot open theirs, so
+// their size is estimated from the stored size, which padding makes too large.
+func (bl *BoltLocknut) recordStats(bucket string, stored []byte) statsDelta {
+	if stored == nil || bl.statsPath == "" {
+		return statsDelta{}
+	}
+	d := statsDelta{keys: 1, bytes: int64(len(stored)), plain: int64(len(stored))}
+	if bl.secret == nil && bl.public == nil {
+		return d
+	}
+
+	value, info, err := bl.openStored(bucket, stored)
+	if errors.Is(err, ErrWriteOnly) {
+		d.plain -= int64(len(bl.public.Bytes()) + encryptionOverhead)
+		d.encrypted, d.versions = 1, map[string]int{KeyVersionPublic: 1}
+		return d
+	}
+	if err != nil {
+		return d
+	}
+	version := bl.keyVersion()
+	if info.legacy {
+		version = KeyVersionSecret
+	}
+	d.plain, d.encrypted, d.versions = int64(len(value)), 1, map[string]int{version: 1}
+	if info.compressed {
+		d.compressed = 1
+	}
+	return d
+}
+
+// openStatsDB opens the stats db for writing, created with the file mode of the db, failing fast if a
+// reader holds it for too long
+func (bl *BoltLocknut) openStatsDB() (*bbolt.DB, error) {
+	return bbolt.Open(bl.statsPath, bl.fileMode, &bbolt.Options{Timeout: time.Second})
+}
+
+// statsInterval is the least time between two updates of the stats db
+const statsInterval = 100 * time.Millisecond
+
+// statsQueue is the deltas the stats db has not taken yet and the goroutine applying them
+type statsQueue struct {
+	flushMu sync.Mutex // serializes the updates of the stats db
+
+	mu      sync.Mutex            // guards pending and the channels
+	pending map[string]statsDelta // deltas the stats db has not taken yet
+	kick    chan struct{}         // wakes the goroutine, nil while it is not running
+	quit    chan struct{}
+	stopped chan struct{}
+}
+
+// updateStats queues the deltas of a committed write for the stats db. A goroutine applies the deltas
+// queued meanwhile in one update, at most once per statsInterval, so writes never wait on the stats db.
+// The deltas of an update that fails, while a reader holds the stats db for too long for instance, are
+// kept and applied with the next one, or on Close. Stats are derived data, so a failure there is logged
+// rather than failing the write that already succeeded.
+func (bl *BoltLocknut) updateStats(deltas map[string]statsDelta) {
+	if bl.statsPath == "" || len(deltas) == 0 {
+		return
+	}
+	q := &bl.stats
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.pending == nil {
+		q.pending = make(map[string]statsDelta)
+	}
+	now := bl.now()
+	for bucket, delta := range deltas {
+		d := q.pending[bucket].add(delta)
+		d.last = now
+		q.pending[bucket] = d
+	}
+	if q.kick == nil {
+		q.kick, q.quit, q.stopped = make(chan struct{}, 1), make(chan struct{}), make(chan struct{})
+		go bl.applyStats(q.kick, q.quit, q.stopped)
+	}
+	select {
+	case q.kick <- struct{}{}:
+	default:
+	}
+}
+
+// applyStats applies the queued deltas whenever kick wakes it, until quit is closed
+func (bl *BoltLocknut) applyStats(kick, quit, stopped chan struct{}) {
+	defer close(stopped)
+	for {
+		select {
+		case <-kick:
+		case <-quit:
+			return
+		}
+		if err := bl.flushStats(); err != nil {
+			log.Error("updateStats", err)
+		}
+
+		wait := time.NewTimer(statsInterval)
+		select {
+		case <-wait.C:
+		case <-quit:
+			wait.Stop()
+			return
+		}
+	}
+}
+
+// flushStats applies the queued deltas to the stats db, queueing them again when that fails
+func (bl *BoltLocknut) flushStats() error {
+	q := &bl.stats
+	q.flushMu.Lock()
+	defer q.flushMu.Unlock()
+
+	q.mu.Lock()
+	pending := q.pending
+	q.pending = nil
+	q.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	err := bl.writeStats(pending)
+	if err != nil {
+		q.mu.Lock()
+		for bucket, delta := range q.pending {
+			d := pending[bucket].add(delta)
+			d.last = delta.last
+			pending[bucket] = d
+		}
+		q.pending = pending
+		q.mu.Unlock()
+	}
+	return err
+}
+
+// writeStats adds deltas to the stats db
+func (bl *BoltLocknut) writeStats(deltas map[string]statsDelta) error {
+	db, err := bl.openStatsDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bbolt.Tx) error {
+		bkt, err := tx.CreateBucketIfNotExists(statsBucket)
+		if err != nil {
+			return err
+		}
+
+		for bucket, delta := range deltas {
+			var s BucketStats
+			if v := bkt.Get([]byte(bucket)); v != nil {
+				if err := json.Unmarshal(v, &s); err != nil {
+					return err
+				}
+			}
+			s.Keys += delta.keys
+			s.Bytes += delta.bytes
+			s.PlainBytes += delta.plain
+			s.Encrypted += delta.encrypted
+			s.addVersions(delta.versions, 1)
+			s.Compressed += delta.compressed
+			s.LastWrite = delta.last
+
+			v, err := json.Marshal(s)
+			if err != nil {
+				return err
+			}
+			if err = bkt.Put([]byte(bucket), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// closeStats stops the goroutine applying the deltas and applies those still queued, for Close
+func (bl *BoltLocknut) closeStats() error {
+	if bl.statsPath == "" {
+		return nil
+	}
+	q := &bl.stats
+	q.mu.Lock()
+	quit, stopped := q.quit, q.stopped
+	q.kick, q.quit, q.stopped = nil, nil, nil
+	q.mu.Unlock()
+	if quit != nil {
+		close(quit)
+		<-stopped
+	}
+	return bl.flushStats()
+}
+
+// rebuildStats recounts every bucket in the main db and rewrites the stats db, keeping known last write times
+func (bl *BoltLocknut) rebuildStats() error {
+	counted := make(map[string]BucketStats)
+	err := bl.db.view(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+			if isInternalBucket(name) {
+				return nil
+			}
+			var s BucketStats
+			err := b.ForEach(func(k, v []byte) error {
+				if v == nil {
+					return nil
+				}
+				d := bl.recordStats(string(name), v)
+				s.Keys += d.keys
+				s.Bytes += d.bytes
+				s.PlainBytes += d.plain
+				s.Encrypted += d.encrypted
+				s.addVersions(d.versions, 1)
+				s.Compressed += d.compressed
+				return nil
+			})
+			counted[string(name)] = s
+			return err
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	bl.stats.flushMu.Lock()
+	defer bl.stats.flushMu.Unlock()
+	db, err := bl.openStatsDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bbolt.Tx) error {
+		bkt, err := tx.CreateBucketIfNotExists(statsBucket)
+		if err != nil {
+			return err
+		}
+
+		var stale [][]byte
+		err = bkt.ForEach(func(k, v []byte) error {
+			s, ok := counted[string(k)]
+			if !ok {
+				stale = append(stale, k)
+				return nil
+			}
+			var prev BucketStats
+			if err := json.Unmarshal(v, &prev); err == nil {
+				s.LastWrite = prev.LastWrite
+				counted[string(k)] = s
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range stale {
+			if err := bkt.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		for name, s := range counted {
+			v, err := json.Marshal(s)
+			if err != nil {
+				return err
+			}
+			if err = bkt.Put([]byte(name), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// addVersions adds the key version counts times sign to s, dropping the versions no record has left
+func (s *BucketStats) addVersions(versions map[string]int, sign int) {
+	for v, n := range versions {
+		if s.KeyVersions == nil {
+			s.KeyVersions = make(map[string]int)
+		}
+		s.KeyVersions[v] += sign * n
+		if s.KeyVersions[v] == 0 {
+			delete(s.KeyVersions, v)
+		}
+	}
+}
+
+func (d statsDelta) add(o statsDelta) statsDelta {
+	return d.combine(o, 1)
+}
+
+func (d statsDelta) sub(o statsDelta) statsDelta {
+	return d.combine(o, -1)
+}
+
+func (d statsDelta) combine(o statsDelta, sign int) statsDelta {
+	r := statsDelta{
+		keys:       d.keys + sign*o.keys,
+		bytes:      d.bytes + int64(sign)*o.bytes,
+		plain:      d.plain + int64(sign)*o.plain,
+		encrypted:  d.encrypted + sign*o.encrypted,
+		compressed: d.compressed + sign*o.compressed,
+	}
+	if len(d.versions)+len(o.versions) > 0 {
+		r.versions = make(map[string]int)
+		for v, n := range d.versions {
+			r.versions[v] += n
+		}
+		for v, n := range o.versions {
+			r.versions[v] += sign * n
+		}
+	}
+	return r
+}
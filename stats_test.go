@@ -0,0 +1,341 @@
+package locknut
+
+import (
+	"bytes"
+	"go.etcd.io/bbolt"
+	"os"
+	"testing"
+)
+
+func TestStatsDB(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	bucketName := "article"
+	defer os.Remove("stats_test.db")
+	defer os.Remove("stats_test.stats.db")
+
+	bl, err := NewBoltLocknut("stats_test.db", ".", []byte("secret"), false, []string{bucketName, "empty"},
+		WithStatsDB("stats_test.stats.db"))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+
+	for _, id := range []string{"ID-0001", "ID-0002"} {
+		if err = bl.Save(bucketName, id, Article{ID: id, Title: "stats"}); err != nil {
+			t.Fatalf("Save return err: %s", err)
+		}
+	}
+	if err = bl.Delete(bucketName, "ID-0001"); err != nil {
+		t.Fatalf("Delete return err: %s", err)
+	}
+
+	flushStats(t, bl)
+	stats, err := ReadStats("stats_test.stats.db")
+	if err != nil {
+		t.Fatalf("ReadStats return err: %s", err)
+	}
+
+	s := stats[bucketName]
+	if s.Keys != 1 {
+		t.Errorf("expected 1 key, got %d", s.Keys)
+	}
+	if s.Bytes <= 0 {
+		t.Errorf("expected stored bytes, got %d", s.Bytes)
+	}
+	if s.LastWrite.IsZero() {
+		t.Errorf("expected last write time to be set")
+	}
+	if _, ok := stats["empty"]; !ok {
+		t.Errorf("expected empty bucket in stats")
+	}
+
+	// reopening recounts from the main db and keeps the same numbers
+	if _, err = NewBoltLocknut("stats_test.db", ".", []byte("secret"), false, nil,
+		WithStatsDB("stats_test.stats.db")); err != nil {
+		t.Fatalf("NewBoltLocknut reopen: %s", err)
+	}
+	stats, err = ReadStats("stats_test.stats.db")
+	if err != nil {
+		t.Fatalf("ReadStats return err: %s", err)
+	}
+	if stats[bucketName].Keys != 1 || stats[bucketName].Bytes != s.Bytes {
+		t.Errorf("rebuilt stats differ: %+v vs %+v", stats[bucketName], s)
+	}
+}
+
+func TestStatsDBBusy(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("stats_busy_test.db")
+	defer os.Remove("stats_busy_test.stats.db")
+
+	bl, err := NewBoltLocknut("stats_busy_test.db", ".", []byte("secret"), false, []string{"article"},
+		WithStatsDB("stats_busy_test.stats.db"))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+
+	// a reader holding the stats db past the timeout delays the updates, it does not lose them
+	reader, err := bbolt.Open("stats_busy_test.stats.db", 0644, &bbolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	if err = bl.Save("article", "ID-0001", Article{ID: "ID-0001"}); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	reader.Close()
+	if err = bl.Save("article", "ID-0002", Article{ID: "ID-0002"}); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	flushStats(t, bl)
+	if stats, err := ReadStats("stats_busy_test.stats.db"); err != nil || stats["article"].Keys != 2 {
+		t.Errorf("expected 2 keys, got %+v %v", stats["article"], err)
+	}
+
+	// and Close applies what is left
+	reader, err = bbolt.Open("stats_busy_test.stats.db", 0644, &bbolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	if err = bl.Delete("article", "ID-0001"); err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+	reader.Close()
+	if err = bl.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	if stats, err := ReadStats("stats_busy_test.stats.db"); err != nil || stats["article"].Keys != 1 {
+		t.Errorf("expected 1 key, got %+v %v", stats["article"], err)
+	}
+}
+
+func TestStatsRatios(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("stats_ratio_test.db")
+	defer os.Remove("stats_ratio_test.stats.db")
+
+	// a db where EncryptExisting left the plain bucket out
+	db, err := bbolt.Open("stats_ratio_test.db", 0600, nil)
+	if err != nil {
+		t.Fatalf("bbolt.Open: %s", err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, b := range []string{"secret", "plain"} {
+			bkt, err := tx.CreateBucket([]byte(b))
+			if err != nil {
+				return err
+			}
+			if err = bkt.Put([]byte("k"), []byte("0123456789")); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	db.Close()
+	if err != nil {
+		t.Fatalf("Update: %s", err)
+	}
+	if err = EncryptExisting("stats_ratio_test.db", []byte("secret"), []string{"secret"}); err != nil {
+		t.Fatalf("EncryptExisting: %s", err)
+	}
+
+	bl, err := NewBoltLocknut("stats_ratio_test.db", ".", []byte("secret"), false, nil,
+		WithStatsDB("stats_ratio_test.stats.db"))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	stats, err := ReadStats("stats_ratio_test.stats.db")
+	if err != nil {
+		t.Fatalf("ReadStats: %s", err)
+	}
+	if s := stats["secret"]; s.PlainBytes != 10 || s.Bytes != 10+encryptionOverhead || s.EncryptedRatio() != 1 {
+		t.Errorf("unexpected stats for the encrypted bucket %+v", s)
+	}
+	if s := stats["plain"]; s.PlainBytes != 10 || s.StorageRatio() != 1 || s.EncryptedRatio() != 0 {
+		t.Errorf("unexpected stats for the plain bucket %+v", s)
+	}
+
+	// writes keep the numbers up to date
+	if err = bl.SaveBytes("secret", "k", []byte("01234")); err != nil {
+		t.Fatalf("SaveBytes: %s", err)
+	}
+	if err = bl.SaveBytes("secret", "k2", []byte("01234")); err != nil {
+		t.Fatalf("SaveBytes: %s", err)
+	}
+	if err = bl.Delete("secret", "k2"); err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+	flushStats(t, bl)
+	stats, _ = ReadStats("stats_ratio_test.stats.db")
+	if s := stats["secret"]; s.Keys != 1 || s.PlainBytes != 5 || s.Encrypted != 1 {
+		t.Errorf("unexpected stats after writes %+v", s)
+	}
+}
+
+func TestStatsPlainBytes(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("stats_plain_test.db")
+	defer os.Remove("stats_plain_test.stats.db")
+
+	bl, err := NewBoltLocknut("stats_plain_test.db", ".", []byte("secret"), false, []string{"people"},
+		WithStatsDB("stats_plain_test.stats.db"), WithPadding(256))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	for _, v := range []string{"0123456789", "01234"} {
+		if err = bl.SaveBytes("people", "k", []byte(v)); err != nil {
+			t.Fatalf("SaveBytes: %s", err)
+		}
+	}
+	if err = bl.SaveBytes("people", "k2", []byte("0123")); err != nil {
+		t.Fatalf("SaveBytes: %s", err)
+	}
+	flushStats(t, bl)
+	stats, _ := ReadStats("stats_plain_test.stats.db")
+	if s := stats["people"]; s.PlainBytes != 9 || s.Bytes != int64(2*(len(frameMagic)+256+encryptionOverhead)) {
+		t.Errorf("expected padded records to count their values, got %+v", s)
+	}
+
+	// records from before the db used bucket keys keep the secret until the Optimizer rewrites them
+	bl, err = NewBoltLocknut("stats_plain_test.db", ".", []byte("secret"), false, nil,
+		WithStatsDB("stats_plain_test.stats.db"), WithPadding(256), WithBucketKeys())
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if err = bl.SaveBytes("people", "k3", []byte("012")); err != nil {
+		t.Fatalf("SaveBytes: %s", err)
+	}
+	flushStats(t, bl)
+	stats, _ = ReadStats("stats_plain_test.stats.db")
+	s := stats["people"]
+	if s.PlainBytes != 12 || s.KeyVersions[KeyVersionSecret] != 2 || s.KeyVersions[KeyVersionBucket] != 1 {
+		t.Errorf("unexpected key versions %+v", s)
+	}
+	if _, _, err = NewOptimizer(bl).Step(); err != nil {
+		t.Fatalf("Step: %s", err)
+	}
+	flushStats(t, bl)
+	stats, _ = ReadStats("stats_plain_test.stats.db")
+	if s := stats["people"]; s.PlainBytes != 12 || s.KeyVersionRatio(KeyVersionBucket) != 1 || len(s.KeyVersions) != 1 {
+		t.Errorf("expected every record to use the bucket key, got %+v", s)
+	}
+
+	// reopening recounts every record the same way
+	if _, err = NewBoltLocknut("stats_plain_test.db", ".", []byte("secret"), false, nil,
+		WithStatsDB("stats_plain_test.stats.db")); err != nil {
+		t.Fatalf("NewBoltLocknut reopen: %s", err)
+	}
+	rebuilt, _ := ReadStats("stats_plain_test.stats.db")
+	if r := rebuilt["people"]; r.PlainBytes != 12 || r.Keys != 3 || r.KeyVersions[KeyVersionBucket] != 3 {
+		t.Errorf("rebuilt stats differ: %+v", r)
+	}
+}
+
+func TestStatsCompressed(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("stats_gzip_test.db")
+	defer os.Remove("stats_gzip_test.stats.db")
+
+	bl, err := NewBoltLocknut("stats_gzip_test.db", ".", []byte("secret"), false, []string{"people"},
+		WithStatsDB("stats_gzip_test.stats.db"))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if err = bl.SaveBytes("people", "plain", []byte("not compressed")); err != nil {
+		t.Fatalf("SaveBytes: %s", err)
+	}
+
+	flushStats(t, bl)
+	bl, err = NewBoltLocknut("stats_gzip_test.db", ".", []byte("secret"), false, nil,
+		WithStatsDB("stats_gzip_test.stats.db"), WithTransformers(Gzip(9)))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	long := bytes.Repeat([]byte("compressed "), 100)
+	for _, k := range []string{"a", "b"} {
+		if err = bl.SaveBytes("people", k, long); err != nil {
+			t.Fatalf("SaveBytes: %s", err)
+		}
+	}
+	if err = bl.Delete("people", "b"); err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+	flushStats(t, bl)
+	stats, _ := ReadStats("stats_gzip_test.stats.db")
+	s := stats["people"]
+	if s.Compressed != 1 || s.CompressedRatio() != 0.5 || s.PlainBytes != int64(len(long)+len("not compressed")) {
+		t.Errorf("unexpected stats %+v", s)
+	}
+	if s.StorageRatio() >= 1 {
+		t.Errorf("expected compression to show in the storage ratio, got %f", s.StorageRatio())
+	}
+
+	// the Optimizer compresses the record written before the pipeline had Gzip
+	if _, _, err = NewOptimizer(bl).Step(); err != nil {
+		t.Fatalf("Step: %s", err)
+	}
+	flushStats(t, bl)
+	stats, _ = ReadStats("stats_gzip_test.stats.db")
+	if s := stats["people"]; s.Compressed != 2 {
+		t.Errorf("expected every record compressed, got %+v", s)
+	}
+}
+
+func TestStatsRecordMeta(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("stats_meta_test.db")
+	defer os.Remove("stats_meta_test.stats.db")
+
+	bl, err := NewBoltLocknut("stats_meta_test.db", ".", []byte("secret"), false, []string{"people"},
+		WithStatsDB("stats_meta_test.stats.db"), WithRecordMeta(), WithTransformers(Gzip(9)))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	long := bytes.Repeat([]byte("compressed "), 100)
+	for _, v := range [][]byte{long, []byte("0123456789")} {
+		if err = bl.SaveBytes("people", "k", v); err != nil {
+			t.Fatalf("SaveBytes: %s", err)
+		}
+	}
+	if err = bl.SaveBytes("people", "k2", long); err != nil {
+		t.Fatalf("SaveBytes: %s", err)
+	}
+
+	// the stats of the value replaced come from its record meta, the value itself being unreadable
+	if err = bl.openDB(); err != nil {
+		t.Fatalf("openDB: %s", err)
+	}
+	err = bl.db.update(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket([]byte("people"))
+		v := append([]byte(nil), bkt.Get([]byte("k2"))...)
+		v[0] ^= 0xff
+		return bkt.Put([]byte("k2"), v)
+	})
+	bl.closeDB()
+	if err != nil {
+		t.Fatalf("update: %s", err)
+	}
+	if err = bl.Delete("people", "k2"); err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+	flushStats(t, bl)
+	stats, _ := ReadStats("stats_meta_test.stats.db")
+	if s := stats["people"]; s.Keys != 1 || s.PlainBytes != 10 || s.Compressed != 1 || s.Encrypted != 1 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+
+	if err = bl.Delete("people", "k"); err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+	flushStats(t, bl)
+	stats, _ = ReadStats("stats_meta_test.stats.db")
+	if s := stats["people"]; s.Keys != 0 || s.PlainBytes != 0 || s.Bytes != 0 || len(s.KeyVersions) != 0 {
+		t.Errorf("unexpected stats after delete %+v", s)
+	}
+}
+
+// flushStats applies the stats of the writes of bl, which the stats db takes asynchronously
+func flushStats(t *testing.T, bl *BoltLocknut) {
+	t.Helper()
+	if err := bl.flushStats(); err != nil {
+		t.Fatalf("flushStats: %s", err)
+	}
+}
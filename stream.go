@@ -0,0 +1,73 @@
+package locknut
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrStreamCorrupt is returned by DecryptStream for a stream that was truncated or altered, or that
+// was encrypted with another key
+var ErrStreamCorrupt = errors.New("stream is corrupt")
+
+// A stream starts with streamMagic and a random salt, the key of the stream is derived from the key
+// and the salt so no two streams share one. The data follows in the chunks of encrypted backups, a
+// uint32 length and the AES-GCM sealed chunk, bound to the header and numbered, the last one flagged.
+const (
+	streamMagic    = "locknut stream v1\n"
+	streamSaltSize = 32
+)
+
+var streamKeyInfo = []byte("locknut stream key")
+
+// EncryptStream encrypts src to dst with key, an AES key such as those of GetRandKey, in chunks of
+// 64KB so streams of any size are encrypted in constant memory. It returns the number of bytes written
+// to dst. It does not use the Cryptor, which is only there to group the primitives of the package.
+func (c *Cryptor) EncryptStream(dst io.Writer, src io.Reader, key []byte) (int64, error) {
+	if _, err := aes.NewCipher(key); err != nil {
+		return 0, err
+	}
+	salt := make([]byte, streamSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return 0, err
+	}
+	aead, err := newChunkAEAD(HKDF(key, salt, streamKeyInfo, 32))
+	if err != nil {
+		return 0, err
+	}
+
+	header := append([]byte(streamMagic), salt...)
+	sw := &sealWriter{w: dst, aead: aead, aad: headerDigest(header)}
+	if err = sw.write(header); err != nil {
+		return sw.n, err
+	}
+	if _, err = io.Copy(sw, src); err != nil {
+		return sw.n, err
+	}
+	err = sw.Close()
+	return sw.n, err
+}
+
+// DecryptStream decrypts a stream written by EncryptStream from src to dst with the same key, and
+// returns the number of bytes written to dst. A stream that was truncated or altered, or encrypted with
+// another key, fails with ErrStreamCorrupt, dst may already hold part of it then.
+func (c *Cryptor) DecryptStream(dst io.Writer, src io.Reader, key []byte) (int64, error) {
+	if _, err := aes.NewCipher(key); err != nil {
+		return 0, err
+	}
+	header := make([]byte, len(streamMagic)+streamSaltSize)
+	if _, err := io.ReadFull(src, header); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrStreamCorrupt, err)
+	}
+	if !bytes.HasPrefix(header, []byte(streamMagic)) {
+		return 0, fmt.Errorf("%w: not an encrypted stream", ErrStreamCorrupt)
+	}
+	aead, err := newChunkAEAD(HKDF(key, header[len(streamMagic):], streamKeyInfo, 32))
+	if err != nil {
+		return 0, err
+	}
+	return openChunks(dst, src, aead, headerDigest(header), ErrStreamCorrupt)
+}
@@ -0,0 +1,63 @@
+package locknut
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestCryptorStream(t *testing.T) {
+	key, err := GetRandKey()
+	if err != nil {
+		t.Fatalf("GetRandKey: %s", err)
+	}
+	var c Cryptor
+
+	for _, size := range []int{0, 10, sealedChunkSize, 3*sealedChunkSize + 7} {
+		plain := make([]byte, size)
+		for i := range plain {
+			plain[i] = byte(i * 7)
+		}
+		var sealed bytes.Buffer
+		n, err := c.EncryptStream(&sealed, bytes.NewReader(plain), key)
+		if err != nil {
+			t.Fatalf("EncryptStream: %s", err)
+		}
+		if n != int64(sealed.Len()) {
+			t.Errorf("expected %d bytes written reported, got %d", sealed.Len(), n)
+		}
+		var opened bytes.Buffer
+		if n, err = c.DecryptStream(&opened, bytes.NewReader(sealed.Bytes()), key); err != nil {
+			t.Fatalf("DecryptStream %d: %s", size, err)
+		}
+		if n != int64(size) || !bytes.Equal(opened.Bytes(), plain) {
+			t.Errorf("%d bytes did not round trip, got %d", size, n)
+		}
+	}
+
+	var sealed bytes.Buffer
+	if _, err = c.EncryptStream(&sealed, bytes.NewReader(make([]byte, 2*sealedChunkSize+1)), key); err != nil {
+		t.Fatalf("EncryptStream: %s", err)
+	}
+	other, _ := GetRandKey()
+	truncated := sealed.Bytes()[:sealed.Len()-100]
+	altered := append([]byte(nil), sealed.Bytes()...)
+	altered[len(altered)/2] ^= 1
+	for name, tc := range map[string]struct {
+		stream []byte
+		key    []byte
+	}{
+		"wrong key": {sealed.Bytes(), other},
+		"truncated": {truncated, key},
+		"altered":   {altered, key},
+		"garbage":   {[]byte("not a stream at all, not even close to one"), key},
+	} {
+		if _, err = c.DecryptStream(io.Discard, bytes.NewReader(tc.stream), tc.key); !errors.Is(err, ErrStreamCorrupt) {
+			t.Errorf("%s: expected ErrStreamCorrupt, got %v", name, err)
+		}
+	}
+	if _, err = c.EncryptStream(io.Discard, bytes.NewReader(nil), []byte("short")); err == nil {
+		t.Errorf("expected a key of the wrong size to fail")
+	}
+}
@@ -0,0 +1,217 @@
+// Package tiered implements locknut.Locknut as a local store, usually a BoltLocknut, caching a remote
+// one such as the redis backend or a locknut server. Writes go through to the remote store before the
+// cache, reads are served from the cache while the record is younger than the TTL, so edge nodes read
+// locally and every write is durable centrally.
+package tiered
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/taybart/locknut"
+	"sync"
+	"time"
+)
+
+// TieredLocknut is a locknut.Locknut caching remote in local
+type TieredLocknut struct {
+	local  locknut.Locknut
+	remote locknut.Locknut
+	ttl    time.Duration
+	stale  bool
+	now    func() time.Time
+
+	mu sync.Mutex
+	// fetched holds when each cached record, or its absence, was last read from or written to remote.
+	// Records cached by an earlier process are not in it, so they are refreshed on first use.
+	fetched map[[2]string]time.Time
+	// buckets holds the buckets known to exist locally
+	buckets map[string]bool
+}
+
+var _ locknut.Locknut = (*TieredLocknut)(nil)
+
+// Option configures a TieredLocknut, passed to NewTieredLocknut
+type Option func(*TieredLocknut)
+
+// WithTTL sets how long a cached record is served without asking remote, one minute by default
+func WithTTL(d time.Duration) Option {
+	return func(tl *TieredLocknut) {
+		tl.ttl = d
+	}
+}
+
+// WithStaleOnError serves cached records past their TTL when remote fails, rather than the error
+func WithStaleOnError() Option {
+	return func(tl *TieredLocknut) {
+		tl.stale = true
+	}
+}
+
+// NewTieredLocknut caches remote in local. local is a cache only, it may be emptied at any time.
+func NewTieredLocknut(local, remote locknut.Locknut, opts ...Option) *TieredLocknut {
+	tl := &TieredLocknut{
+		local:   local,
+		remote:  remote,
+		ttl:     time.Minute,
+		now:     time.Now,
+		fetched: make(map[[2]string]time.Time),
+		buckets: make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(tl)
+	}
+	return tl
+}
+
+// fresh reports whether the cached bucket/key can be served without asking remote
+func (tl *TieredLocknut) fresh(bucket, key string) bool {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	t, ok := tl.fetched[[2]string{bucket, key}]
+	return ok && tl.now().Sub(t) < tl.ttl
+}
+
+func (tl *TieredLocknut) touch(bucket, key string) {
+	tl.mu.Lock()
+	tl.fetched[[2]string{bucket, key}] = tl.now()
+	tl.mu.Unlock()
+}
+
+// Invalidate drops key from the cache, the next read asks remote
+func (tl *TieredLocknut) Invalidate(bucket, key string) {
+	tl.mu.Lock()
+	delete(tl.fetched, [2]string{bucket, key})
+	tl.mu.Unlock()
+}
+
+// InvalidateAll drops every record from the cache
+func (tl *TieredLocknut) InvalidateAll() {
+	tl.mu.Lock()
+	tl.fetched = make(map[[2]string]time.Time)
+	tl.mu.Unlock()
+}
+
+// localBucket creates bucket in local the first time it is used
+func (tl *TieredLocknut) localBucket(bucket string) error {
+	tl.mu.Lock()
+	known := tl.buckets[bucket]
+	tl.mu.Unlock()
+	if known {
+		return nil
+	}
+	if err := tl.local.CreateBucket(bucket); err != nil {
+		return err
+	}
+	tl.mu.Lock()
+	tl.buckets[bucket] = true
+	tl.mu.Unlock()
+	return nil
+}
+
+// cache stores a value read from or written to remote, nil caching its absence
+func (tl *TieredLocknut) cache(bucket, key string, value []byte) error {
+	if err := tl.localBucket(bucket); err != nil {
+		return err
+	}
+	var err error
+	if value == nil {
+		err = tl.local.Delete(bucket, key)
+	} else {
+		err = tl.local.SaveBytes(bucket, key, value)
+	}
+	if err != nil {
+		// a cache that could not be updated must not serve the old value
+		tl.Invalidate(bucket, key)
+		return err
+	}
+	tl.touch(bucket, key)
+	return nil
+}
+
+// Save stores data as json under key in bucket
+func (tl *TieredLocknut) Save(bucket, key string, data interface{}) error {
+	if data == nil {
+		return errors.New("data is nil")
+	}
+	value, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return tl.SaveBytes(bucket, key, value)
+}
+
+// SaveBytes stores data in remote, then in the cache
+func (tl *TieredLocknut) SaveBytes(bucket, key string, data []byte) error {
+	if err := tl.remote.SaveBytes(bucket, key, data); err != nil {
+		return err
+	}
+	return tl.cache(bucket, key, data)
+}
+
+// Get returns the record stored under exactly key, from the cache while it is fresh
+func (tl *TieredLocknut) Get(bucket, key string) ([]byte, error) {
+	if tl.fresh(bucket, key) {
+		return tl.local.Get(bucket, key)
+	}
+
+	value, err := tl.remote.Get(bucket, key)
+	if err != nil {
+		if tl.stale && !errors.Is(err, locknut.ErrBucketNotFound) {
+			if cached, lerr := tl.local.Get(bucket, key); lerr == nil && cached != nil {
+				return cached, nil
+			}
+		}
+		return nil, err
+	}
+	return value, tl.cache(bucket, key, value)
+}
+
+// GetOne returns the first record whose key starts with key, from remote
+func (tl *TieredLocknut) GetOne(bucket, key string) ([]byte, error) {
+	return tl.remote.GetOne(bucket, key)
+}
+
+// GetByPrefix returns the records whose keys start with prefix from remote, caching them
+func (tl *TieredLocknut) GetByPrefix(bucket, prefix string) (map[string][]byte, error) {
+	results, err := tl.remote.GetByPrefix(bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range results {
+		if err = tl.cache(bucket, k, v); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// GetKeyList returns the keys of bucket starting with prefix, from remote
+func (tl *TieredLocknut) GetKeyList(bucket, prefix string) ([]string, error) {
+	return tl.remote.GetKeyList(bucket, prefix)
+}
+
+// Delete removes key from remote, then from the cache
+func (tl *TieredLocknut) Delete(bucket, key string) error {
+	if err := tl.remote.Delete(bucket, key); err != nil {
+		return err
+	}
+	return tl.cache(bucket, key, nil)
+}
+
+// Buckets returns the names of the buckets in remote
+func (tl *TieredLocknut) Buckets() ([]string, error) {
+	return tl.remote.Buckets()
+}
+
+// CreateBucket creates the bucket in remote and in the cache
+func (tl *TieredLocknut) CreateBucket(name string) error {
+	if err := tl.remote.CreateBucket(name); err != nil {
+		return err
+	}
+	return tl.localBucket(name)
+}
+
+// Count returns the number of records in bucket, from remote
+func (tl *TieredLocknut) Count(bucket string) (int, error) {
+	return tl.remote.Count(bucket)
+}
@@ -0,0 +1,99 @@
+package tiered
+
+import (
+	"errors"
+	"github.com/taybart/locknut"
+	"testing"
+	"time"
+)
+
+// countingLocknut counts the Gets that reach the remote store and can be made to fail
+type countingLocknut struct {
+	locknut.Locknut
+	gets int
+	down bool
+}
+
+func (c *countingLocknut) Get(bucket, key string) ([]byte, error) {
+	c.gets++
+	if c.down {
+		return nil, errors.New("remote is down")
+	}
+	return c.Locknut.Get(bucket, key)
+}
+
+func TestTieredLocknut(t *testing.T) {
+	if locknut.FIPSBuild() {
+		t.Skip("a short secret is not allowed in FIPS mode")
+	}
+	dir := t.TempDir()
+	central, err := locknut.NewBoltLocknut("remote.db", dir, []byte("central secret"), false, []string{"articles"})
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	local, err := locknut.NewBoltLocknut("local.db", dir, []byte("edge secret"), false, nil)
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+
+	remote := &countingLocknut{Locknut: central}
+	now := time.Now()
+	tl := NewTieredLocknut(local, remote, WithTTL(time.Minute), WithStaleOnError())
+	tl.now = func() time.Time { return now }
+
+	if err = tl.Save("articles", "a1", "one"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if v, err := central.Get("articles", "a1"); err != nil || string(v) != `"one"` {
+		t.Errorf("write did not go through to remote: %q %v", v, err)
+	}
+
+	// written records are served from the cache
+	if v, err := tl.Get("articles", "a1"); err != nil || string(v) != `"one"` || remote.gets != 0 {
+		t.Errorf("unexpected Get %q %v with %d remote gets", v, err, remote.gets)
+	}
+
+	// a change made elsewhere shows once the TTL is over
+	if err = central.Save("articles", "a1", "uno"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if v, _ := tl.Get("articles", "a1"); string(v) != `"one"` {
+		t.Errorf("expected the cached value within the TTL, got %q", v)
+	}
+	now = now.Add(2 * time.Minute)
+	if v, _ := tl.Get("articles", "a1"); string(v) != `"uno"` || remote.gets != 1 {
+		t.Errorf("expected the remote value after the TTL, got %q with %d remote gets", v, remote.gets)
+	}
+
+	// absence is cached too
+	if v, err := tl.Get("articles", "missing"); err != nil || v != nil {
+		t.Errorf("unexpected Get %q %v", v, err)
+	}
+	tl.Get("articles", "missing")
+	if remote.gets != 2 {
+		t.Errorf("expected the absent record to be cached, %d remote gets", remote.gets)
+	}
+
+	// stale records are served while remote is down
+	now = now.Add(2 * time.Minute)
+	remote.down = true
+	if v, err := tl.Get("articles", "a1"); err != nil || string(v) != `"uno"` {
+		t.Errorf("expected the stale value, got %q %v", v, err)
+	}
+	remote.down = false
+
+	if err = tl.Delete("articles", "a1"); err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+	if v, _ := central.Get("articles", "a1"); v != nil {
+		t.Errorf("delete did not go through to remote")
+	}
+	if v, _ := tl.Get("articles", "a1"); v != nil {
+		t.Errorf("expected the deleted record to be gone from the cache, got %q", v)
+	}
+
+	tl.InvalidateAll()
+	if _, err = tl.Get("articles", "a1"); err != nil || remote.gets != 4 {
+		t.Errorf("expected InvalidateAll to send reads to remote, %d remote gets %v", remote.gets, err)
+	}
+}
@@ -0,0 +1,48 @@
+// Package tpm implements locknut.KeyProvider with the TPM 2.0 of the machine, sealing the db key to
+// its storage root key so the key file only unseals on that machine, and with WithPCRs only in the
+// boot state it was sealed in. It suits kiosks and edge devices keeping records on local disks, which
+// may be taken away from the machine. It opens /dev/tpmrm0 or /dev/tpm0 on Linux and the TPM Base
+// Services on Windows, on other systems every call fails with ErrUnsupported.
+package tpm
+
+import (
+	"errors"
+	"github.com/taybart/locknut"
+	"io"
+)
+
+// ErrMalformed is returned by Unwrap for a key file that does not parse
+var ErrMalformed = errors.New("tpm: malformed key file")
+
+// ErrUnsupported is returned by every call of a Provider on systems other than Linux and Windows
+var ErrUnsupported = errors.New("tpm: not supported on this system")
+
+// Provider seals keys to the storage root key of a TPM
+type Provider struct {
+	open    func() (io.ReadWriteCloser, error)
+	pcrs    []int
+	keyFile string
+}
+
+var _ locknut.KeyProvider = (*Provider)(nil)
+
+// Option configures a Provider, passed to New
+type Option func(*Provider)
+
+// WithPCRs binds sealed keys to the current SHA-256 values of pcrs, so they only unseal while the
+// PCRs hold the same values, such as 0 to 7 for the firmware, boot loader and Secure Boot state. An
+// update of any of them, a firmware update for one, locks the key out: Rewrap before it, with
+// WithPCRs naming only PCRs it does not change.
+func WithPCRs(pcrs ...int) Option {
+	return func(p *Provider) {
+		p.pcrs = append(p.pcrs, pcrs...)
+	}
+}
+
+// WithDevice opens the TPM with open rather than the default device, such as a TPM simulator or a
+// device at another path
+func WithDevice(open func() (io.ReadWriteCloser, error)) Option {
+	return func(p *Provider) {
+		p.open = open
+	}
+}
@@ -0,0 +1,102 @@
+//go:build linux || windows
+
+package tpm
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/go-tpm-tools/client"
+	pb "github.com/google/go-tpm-tools/proto/tpm"
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/taybart/locknut"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"os"
+)
+
+// New returns a Provider keeping the sealed db key in keyFile
+func New(keyFile string, opts ...Option) *Provider {
+	p := &Provider{
+		keyFile: keyFile,
+		open:    func() (io.ReadWriteCloser, error) { return tpm2.OpenTPM() },
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// GetKey returns the db key, created on first use, see locknut.EnvelopeKey
+func (p *Provider) GetKey(ctx context.Context) ([]byte, error) {
+	return locknut.EnvelopeKey(ctx, p, p.keyFile)
+}
+
+// withSRK opens the TPM and calls fn with its storage root key
+func (p *Provider) withSRK(fn func(*client.Key) error) error {
+	rw, err := p.open()
+	if err != nil {
+		return fmt.Errorf("tpm: open: %w", err)
+	}
+	defer rw.Close()
+	srk, err := client.StorageRootKeyECC(rw)
+	if err != nil {
+		return fmt.Errorf("tpm: storage root key: %w", err)
+	}
+	defer srk.Close()
+	return fn(srk)
+}
+
+// Wrap seals key to the TPM, bound to the current values of the PCRs given WithPCRs
+func (p *Provider) Wrap(ctx context.Context, key []byte) ([]byte, error) {
+	var sealed *pb.SealedBytes
+	err := p.withSRK(func(srk *client.Key) error {
+		var err error
+		opts := client.SealOpts{}
+		if len(p.pcrs) > 0 {
+			opts.Current = tpm2.PCRSelection{Hash: tpm2.AlgSHA256, PCRs: p.pcrs}
+		}
+		if sealed, err = srk.Seal(key, opts); err != nil {
+			return fmt.Errorf("tpm: seal: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(sealed)
+}
+
+// Unwrap unseals a key sealed by Wrap. It fails on another machine, or when the PCRs it was bound to
+// changed.
+func (p *Provider) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	sealed := &pb.SealedBytes{}
+	if err := proto.Unmarshal(wrapped, sealed); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	var key []byte
+	err := p.withSRK(func(srk *client.Key) error {
+		var err error
+		if key, err = srk.Unseal(sealed, client.UnsealOpts{}); err != nil {
+			return fmt.Errorf("tpm: unseal: %w", err)
+		}
+		return nil
+	})
+	return key, err
+}
+
+// Rewrap unseals the db key and seals it again with the PCRs given WithPCRs, replacing the key file, to
+// move the key to other PCRs or to the current values of the same ones without changing the db key
+func (p *Provider) Rewrap(ctx context.Context) error {
+	wrapped, err := os.ReadFile(p.keyFile)
+	if err != nil {
+		return err
+	}
+	key, err := p.Unwrap(ctx, wrapped)
+	if err != nil {
+		return err
+	}
+	if wrapped, err = p.Wrap(ctx, key); err != nil {
+		return err
+	}
+	return locknut.WriteKeyFile(p.keyFile, wrapped, true)
+}
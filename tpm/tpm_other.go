@@ -0,0 +1,34 @@
+//go:build !linux && !windows
+
+package tpm
+
+import "context"
+
+// New returns a Provider keeping the sealed db key in keyFile
+func New(keyFile string, opts ...Option) *Provider {
+	p := &Provider{keyFile: keyFile}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// GetKey fails with ErrUnsupported
+func (p *Provider) GetKey(ctx context.Context) ([]byte, error) {
+	return nil, ErrUnsupported
+}
+
+// Wrap fails with ErrUnsupported
+func (p *Provider) Wrap(ctx context.Context, key []byte) ([]byte, error) {
+	return nil, ErrUnsupported
+}
+
+// Unwrap fails with ErrUnsupported
+func (p *Provider) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	return nil, ErrUnsupported
+}
+
+// Rewrap fails with ErrUnsupported
+func (p *Provider) Rewrap(ctx context.Context) error {
+	return ErrUnsupported
+}
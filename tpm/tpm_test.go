@@ -0,0 +1,63 @@
+//go:build linux || windows
+
+package tpm
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/legacy/tpm2"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+// simDevice keeps the simulator running when the Provider closes the device
+type simDevice struct {
+	io.ReadWriter
+}
+
+func (simDevice) Close() error { return nil }
+
+func TestProvider(t *testing.T) {
+	sim, err := simulator.Get()
+	if err != nil {
+		t.Skipf("no TPM simulator: %s", err)
+	}
+	defer sim.Close()
+	device := WithDevice(func() (io.ReadWriteCloser, error) { return simDevice{sim}, nil })
+	ctx := context.Background()
+	keyFile := filepath.Join(t.TempDir(), "db.key")
+
+	// the debug PCR, which can be extended at will
+	p := New(keyFile, device, WithPCRs(16))
+	key, err := p.GetKey(ctx)
+	if err != nil {
+		t.Fatalf("GetKey: %s", err)
+	}
+	if got, err := New(keyFile, device).GetKey(ctx); err != nil || !bytes.Equal(got, key) {
+		t.Errorf("expected the same key unsealed, got %v", err)
+	}
+
+	// a changed boot state locks the key out
+	digest := sha256.Sum256([]byte("another boot"))
+	if err = tpm2.PCRExtend(sim, 16, tpm2.AlgSHA256, digest[:], ""); err != nil {
+		t.Fatalf("PCRExtend: %s", err)
+	}
+	if _, err = New(keyFile, device).GetKey(ctx); err == nil {
+		t.Error("expected the key not to unseal after the PCR changed")
+	}
+
+	// a key sealed without PCRs still unseals, another TPM does not
+	other := filepath.Join(t.TempDir(), "other.key")
+	if key, err = New(other, device).GetKey(ctx); err != nil {
+		t.Fatalf("GetKey: %s", err)
+	}
+	if err = sim.ManufactureReset(); err != nil {
+		t.Fatalf("ManufactureReset: %s", err)
+	}
+	if _, err = New(other, device).GetKey(ctx); err == nil {
+		t.Error("expected the key not to unseal on another TPM")
+	}
+}
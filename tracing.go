@@ -0,0 +1,28 @@
+package locknut
+
+import (
+	"context"
+)
+
+// Tracer creates spans around operations, set with WithTracer. The otel subpackage adapts an
+// OpenTelemetry TracerProvider.
+type Tracer interface {
+	// Start begins a span named name for an operation on bucket, as a child of the span in ctx. It
+	// returns the context carrying the new span and a function that ends it with the operation's error.
+	Start(ctx context.Context, name, bucket string) (context.Context, func(err error))
+}
+
+// WithTracer traces Save, GetOne and GetByPrefix, with a sub span for each value encrypted or
+// decrypted. Use the Context variants of the methods to parent the spans.
+func WithTracer(t Tracer) Option {
+	return func(bl *BoltLocknut) {
+		bl.tracer = t
+	}
+}
+
+func (bl *BoltLocknut) startSpan(ctx context.Context, name, bucket string) (context.Context, func(error)) {
+	if bl.tracer == nil {
+		return ctx, func(error) {}
+	}
+	return bl.tracer.Start(ctx, name, bucket)
+}
@@ -0,0 +1,269 @@
+package locknut
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"io"
+)
+
+// frameMagic starts the stored value of a record, or the ciphertext of an encrypted field, whose
+// plaintext is a frame: transformed by the pipeline or padded, see prepare. The mark sits outside the
+// plaintext, so no value saved passes for a frame, and values without it are read as they are.
+var frameMagic = []byte("\x00locknut frame\x00")
+
+// A frame is frameV1, the number of stages, the name of each stage as a length byte and the name, in
+// the order they were applied, the length of the transformed value as 4 bytes big endian, the value
+// and the zeros padding it.
+const frameV1 byte = 1
+
+// ErrUnknownTransformer is returned when a value was transformed by a stage the pipeline no longer has
+var ErrUnknownTransformer = errors.New("value transformed by an unknown stage")
+
+// Transformer is a stage of the value pipeline set WithTransformers
+type Transformer interface {
+	// Name identifies the stage in the values it transformed, up to 255 bytes. Values are reversed by
+	// the stages named in them, so a stage must keep its name as long as values it transformed exist.
+	Name() string
+	// Apply transforms a value before it is stored
+	Apply(data []byte) ([]byte, error)
+	// Reverse undoes Apply on a value read back
+	Reverse(data []byte) ([]byte, error)
+}
+
+// CompressingTransformer is implemented by Transformers that compress values, so BucketStats can count
+// the records they ran on
+type CompressingTransformer interface {
+	Transformer
+	Compresses() bool
+}
+
+// WithTransformers runs values through stages, in order, before they are padded and encrypted with the
+// secret, and back through them in reverse order once decrypted. Encryption with the secret always
+// comes last: the canary, key rotation, deterministic lookups and the other backends rely on it. Each
+// transformed value records the names of its stages, so the pipeline can change over time: values
+// written before a stage was added are read as they are, and the Optimizer rewrites them with the
+// current pipeline. Gzip and AESGCM are built in, stages must be deterministic for the buckets of
+// WithDeterministic. Dbs without a secret store values as they are, the pipeline does not run.
+func WithTransformers(stages ...Transformer) Option {
+	return func(bl *BoltLocknut) {
+		bl.transformers = stages
+	}
+}
+
+// prepare returns the plaintext data is encrypted as, and whether it is a frame: transformed by the
+// pipeline, then padded. Without stages or padding sizes it is data as it is.
+func (bl *BoltLocknut) prepare(data []byte) ([]byte, bool, error) {
+	if len(bl.transformers) == 0 && len(bl.padSizes) == 0 {
+		return data, false, nil
+	}
+	header := []byte{frameV1, byte(len(bl.transformers))}
+	for _, t := range bl.transformers {
+		name := t.Name()
+		if len(name) == 0 || len(name) > 255 {
+			return nil, false, fmt.Errorf("transformer name %q must be 1 to 255 bytes", name)
+		}
+		var err error
+		if data, err = t.Apply(data); err != nil {
+			return nil, false, fmt.Errorf("transformer %s: %w", name, err)
+		}
+		header = append(append(header, byte(len(name))), name...)
+	}
+	header = binary.BigEndian.AppendUint32(header, uint32(len(data)))
+	return bl.pad(append(header, data...)), true, nil
+}
+
+// markFrame returns ct, the ciphertext of a plaintext from prepare, marked as a frame when it is one
+func markFrame(ct []byte, framed bool) []byte {
+	if !framed {
+		return ct
+	}
+	return append(append([]byte(nil), frameMagic...), ct...)
+}
+
+// cutFrame returns the ciphertext of a stored value or encrypted field, and whether its plaintext is
+// a frame
+func cutFrame(stored []byte) ([]byte, bool) {
+	return bytes.CutPrefix(stored, frameMagic)
+}
+
+// restore returns the value of a decrypted plaintext, reversed through the stages named in it when
+// it is a frame
+func (bl *BoltLocknut) restore(plain []byte, framed bool) ([]byte, error) {
+	data, _, err := bl.restoreStages(plain, framed)
+	return data, err
+}
+
+// restoreStages is restore, also returning the names of the stages the value went through
+func (bl *BoltLocknut) restoreStages(plain []byte, framed bool) ([]byte, []string, error) {
+	if !framed {
+		return plain, nil, nil
+	}
+	names, data, _, err := parseFrame(plain)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := len(names) - 1; i >= 0; i-- {
+		t := bl.transformer(names[i])
+		if t == nil {
+			return nil, nil, fmt.Errorf("%w: %s", ErrUnknownTransformer, names[i])
+		}
+		if l, ok := t.(limitedReverser); ok {
+			data, err = l.reverseLimit(data, bl.maxValue())
+		} else {
+			data, err = t.Reverse(data)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("transformer %s: %w", names[i], err)
+		}
+	}
+	return data, names, nil
+}
+
+// limitedReverser is implemented by the stages whose Reverse expands values, reversing at most limit
+// bytes so a crafted value cannot exhaust memory
+type limitedReverser interface {
+	reverseLimit(data []byte, limit int) ([]byte, error)
+}
+
+// compresses reports whether any of the stages named compresses values
+func (bl *BoltLocknut) compresses(names []string) bool {
+	for _, name := range names {
+		if c, ok := bl.transformer(name).(CompressingTransformer); ok && c.Compresses() {
+			return true
+		}
+	}
+	return false
+}
+
+// stageNames returns the names of the stages of the pipeline
+func (bl *BoltLocknut) stageNames() []string {
+	names := make([]string, len(bl.transformers))
+	for i, t := range bl.transformers {
+		names[i] = t.Name()
+	}
+	return names
+}
+
+// parseFrame returns the names of the stages the frame went through, the transformed value and the
+// size of the frame without its padding
+func parseFrame(frame []byte) (names []string, value []byte, size int, err error) {
+	if len(frame) < 2 || frame[0] != frameV1 {
+		return nil, nil, 0, fmt.Errorf("%w: bad frame", ErrCorrupt)
+	}
+	names = make([]string, frame[1])
+	rest := frame[2:]
+	for i := range names {
+		if len(rest) < 1 || len(rest) < 1+int(rest[0]) {
+			return nil, nil, 0, fmt.Errorf("%w: truncated frame", ErrCorrupt)
+		}
+		names[i], rest = string(rest[1:1+int(rest[0])]), rest[1+int(rest[0]):]
+	}
+	if len(rest) < 4 || int64(binary.BigEndian.Uint32(rest)) > int64(len(rest)-4) {
+		return nil, nil, 0, fmt.Errorf("%w: truncated frame", ErrCorrupt)
+	}
+	value = rest[4 : 4+binary.BigEndian.Uint32(rest)]
+	return names, value, len(frame) - len(rest) + 4 + len(value), nil
+}
+
+// currentStages reports whether names are the stages of the pipeline
+func (bl *BoltLocknut) currentStages(names []string) bool {
+	if len(names) != len(bl.transformers) {
+		return false
+	}
+	for i, t := range bl.transformers {
+		if names[i] != t.Name() {
+			return false
+		}
+	}
+	return true
+}
+
+// transformer returns the stage of the pipeline named name
+func (bl *BoltLocknut) transformer(name string) Transformer {
+	for _, t := range bl.transformers {
+		if t.Name() == name {
+			return t
+		}
+	}
+	return nil
+}
+
+type gzipStage struct {
+	level int
+}
+
+// Gzip returns a stage compressing values with gzip at level, see compress/gzip. Compression before
+// encryption can tell attackers who influence part of a value and see the size of the record about
+// the rest of it, so combine it with WithPadding for such values. Records it ran on count as Compressed
+// in BucketStats. Values read back decompress to no more than the limit set WithMaxValueSize.
+func Gzip(level int) Transformer {
+	return gzipStage{level: level}
+}
+
+func (gzipStage) Name() string { return "gzip" }
+
+func (gzipStage) FIPSApproved() bool { return true }
+
+func (gzipStage) Compresses() bool { return true }
+
+func (g gzipStage) Apply(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, g.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err = zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (g gzipStage) Reverse(data []byte) ([]byte, error) {
+	return g.reverseLimit(data, bbolt.MaxValueSize)
+}
+
+func (gzipStage) reverseLimit(data []byte, limit int) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	out, err := io.ReadAll(io.LimitReader(zr, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > limit {
+		return nil, fmt.Errorf("%w: decompresses to more than %d bytes", ErrValueTooLarge, limit)
+	}
+	return out, nil
+}
+
+type aesGCMStage struct {
+	key []byte
+}
+
+// AESGCM returns a stage encrypting values with AES-GCM under a key derived from secret, on top of the
+// encryption with the secret of the db, such as a key of the tenant or application the records belong
+// to. It uses random nonces, so it does not suit the buckets of WithDeterministic.
+func AESGCM(secret []byte) Transformer {
+	return aesGCMStage{key: deriveSecret(secret)}
+}
+
+func (aesGCMStage) Name() string { return "aes-gcm" }
+
+func (aesGCMStage) FIPSApproved() bool { return true }
+
+func (a aesGCMStage) Apply(data []byte) ([]byte, error) {
+	return encryptWith(rand.Reader, data, a.key)
+}
+
+func (a aesGCMStage) Reverse(data []byte) ([]byte, error) {
+	return Decrypt(data, a.key)
+}
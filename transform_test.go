@@ -0,0 +1,129 @@
+package locknut
+
+import (
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+// base64Stage is a custom stage
+type base64Stage struct{}
+
+func (base64Stage) Name() string { return "base64" }
+
+func (base64Stage) Apply(data []byte) ([]byte, error) {
+	return []byte(base64.StdEncoding.EncodeToString(data)), nil
+}
+
+func (base64Stage) Reverse(data []byte) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(string(data))
+}
+
+func TestTransformers(t *testing.T) {
+	skipFIPS(t, "deterministic encryption")
+	defer os.Remove("transform_test.db")
+
+	open := func(opts ...Option) *BoltLocknut {
+		t.Helper()
+		bl, err := NewBoltLocknut("transform_test.db", ".", []byte("secret"), false, []string{"docs", "emails"}, opts...)
+		if err != nil {
+			t.Fatalf("NewBoltLocknut: %s", err)
+		}
+		return bl
+	}
+	long := strings.Repeat("all work and no play ", 100)
+
+	bl := open()
+	if err := bl.Save("docs", "plain", long); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	plainSize := len(storedValue(t, bl, "docs", "plain"))
+
+	bl = open(WithTransformers(Gzip(gzip.BestCompression), base64Stage{}, AESGCM([]byte("tenant key"))),
+		WithDeterministic("emails"))
+	if err := bl.Save("docs", "packed", long); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if size := len(storedValue(t, bl, "docs", "packed")); size >= plainSize/2 {
+		t.Errorf("expected the value compressed, %d bytes against %d", size, plainSize)
+	}
+	for _, key := range []string{"plain", "packed"} {
+		if v, err := bl.Get("docs", key); err != nil || string(v) != `"`+long+`"` {
+			t.Errorf("%s: unexpected value %.20q %v", key, v, err)
+		}
+	}
+
+	// stages must be deterministic for deterministic buckets, AESGCM is not
+	det := open(WithTransformers(Gzip(gzip.DefaultCompression)), WithDeterministic("emails"))
+	if err := det.Save("emails", "1", "ada@example.com"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if keys, err := det.FindEqual("emails", "ada@example.com"); err != nil || len(keys) != 1 {
+		t.Errorf("expected the email found, got %v %v", keys, err)
+	}
+
+	// values name their stages, a pipeline without one of them cannot read them
+	bl = open(WithTransformers(Gzip(gzip.BestSpeed)))
+	if _, err := bl.Get("docs", "packed"); !errors.Is(err, ErrUnknownTransformer) {
+		t.Errorf("expected ErrUnknownTransformer, got %v", err)
+	}
+	if v, err := bl.Get("emails", "1"); err != nil || string(v) != `"ada@example.com"` {
+		t.Errorf("unexpected value %s %v", v, err)
+	}
+
+	// the optimizer moves records to the current pipeline, and leaves them alone once there
+	bl = open(WithTransformers(Gzip(gzip.BestSpeed), base64Stage{}, AESGCM([]byte("tenant key"))))
+	o := NewOptimizer(bl)
+	if n, _, err := o.Step(); err != nil || n != 2 {
+		t.Errorf("expected 2 records rewritten, got %d %v", n, err)
+	}
+	if n, _, err := o.Step(); err != nil || n != 0 {
+		t.Errorf("expected nothing to rewrite, got %d %v", n, err)
+	}
+	if err := bl.RotateKey([]byte("new secret")); err != nil {
+		t.Fatalf("RotateKey: %s", err)
+	}
+	for _, key := range []string{"plain", "packed"} {
+		ct, framed := cutFrame(storedValue(t, bl, "docs", key))
+		if !framed {
+			t.Fatalf("%s: expected a frame", key)
+		}
+		plain, err := Decrypt(ct, bl.secret)
+		if err != nil {
+			t.Fatalf("Decrypt: %s", err)
+		}
+		if names, _, _, err := parseFrame(plain); err != nil || !bl.currentStages(names) {
+			t.Errorf("%s: expected the record transformed, got %v %v", key, names, err)
+		}
+		if v, err := bl.Get("docs", key); err != nil || string(v) != `"`+long+`"` {
+			t.Errorf("%s: unexpected value %.20q %v", key, v, err)
+		}
+	}
+}
+
+func TestGzipLimit(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("gzip_test.db")
+
+	bl, err := NewBoltLocknut("gzip_test.db", ".", []byte("secret"), false, []string{"docs"},
+		WithTransformers(Gzip(gzip.BestCompression)))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if err = bl.SaveBytes("docs", "zeros", make([]byte, 1<<20)); err != nil {
+		t.Fatalf("SaveBytes: %s", err)
+	}
+
+	// a value decompresses to no more than the values the db takes
+	bl, err = NewBoltLocknut("gzip_test.db", ".", []byte("secret"), false, []string{"docs"},
+		WithTransformers(Gzip(gzip.BestCompression)), WithMaxValueSize(1<<10))
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if _, err = bl.Get("docs", "zeros"); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("expected ErrValueTooLarge, got %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+package locknut
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"time"
+)
+
+// expiryBucket holds, in a bucket per record bucket, when records saved with a ttl expire. Its "keys"
+// bucket maps a key to its expiry, its "due" bucket orders the keys by expiry so sweeps only look at
+// the records that are due, like the idempotency keys.
+var expiryBucket = []byte(internalPrefix + "expiry")
+
+var (
+	expiryKeys = []byte("keys")
+	expiryDue  = []byte("due")
+)
+
+// sweepBatch is how many expired records RunSweeper deletes per transaction
+const sweepBatch = 1000
+
+type ttlCtx struct{}
+
+// WithTTL makes the records saved with ctx expire after ttl. Expired records are no longer returned
+// by Get, GetOne, GetByPrefix and GetKeyList, and are deleted by SweepExpired. Like a Redis SET, saving
+// a record again without a ttl keeps it forever.
+func WithTTL(ctx context.Context, ttl time.Duration) context.Context {
+	return context.WithValue(ctx, ttlCtx{}, ttl)
+}
+
+func ttlOf(ctx context.Context) time.Duration {
+	if ctx == nil {
+		return 0
+	}
+	ttl, _ := ctx.Value(ttlCtx{}).(time.Duration)
+	return ttl
+}
+
+// SaveWithTTL is Save for a record that expires after ttl, zero keeps it forever
+func (bl *BoltLocknut) SaveWithTTL(bucket, key string, data interface{}, ttl time.Duration) error {
+	return bl.SaveContext(WithTTL(context.Background(), ttl), bucket, key, data)
+}
+
+// SaveBytesWithTTL is SaveBytes for a record that expires after ttl, zero keeps it forever
+func (bl *BoltLocknut) SaveBytesWithTTL(bucket, key string, data []byte, ttl time.Duration) error {
+	return bl.SaveBytesContext(WithTTL(context.Background(), ttl), bucket, key, data)
+}
+
+// setExpiry records when bucket/key expires for a save, forgetting the expiry of an earlier save
+func (w *writeTx) setExpiry(bucket, key string) error {
+	ttl := ttlOf(w.ctx)
+	if ttl <= 0 {
+		return w.deleteExpiry(bucket, key)
+	}
+
+	root, err := w.tx.CreateBucketIfNotExists(expiryBucket)
+	if err != nil {
+		return err
+	}
+	bkt, err := root.CreateBucketIfNotExists([]byte(bucket))
+	if err != nil {
+		return err
+	}
+	keys, err := bkt.CreateBucketIfNotExists(expiryKeys)
+	if err != nil {
+		return err
+	}
+	due, err := bkt.CreateBucketIfNotExists(expiryDue)
+	if err != nil {
+		return err
+	}
+
+	if old := keys.Get([]byte(key)); old != nil {
+		if err = due.Delete(append(append([]byte(nil), old...), key...)); err != nil {
+			return err
+		}
+	}
+	expires := seqKey(uint64(w.bl.now().Add(ttl).UnixNano()))
+	if err = keys.Put([]byte(key), expires); err != nil {
+		return err
+	}
+	return due.Put(append(expires, key...), nil)
+}
+
+// deleteExpiry forgets the expiry of bucket/key
+func (w *writeTx) deleteExpiry(bucket, key string) error {
+	root := w.tx.Bucket(expiryBucket)
+	if root == nil {
+		return nil
+	}
+	bkt := root.Bucket([]byte(bucket))
+	if bkt == nil {
+		return nil
+	}
+	keys, due := bkt.Bucket(expiryKeys), bkt.Bucket(expiryDue)
+	old := keys.Get([]byte(key))
+	if old == nil {
+		return nil
+	}
+	if err := due.Delete(append(append([]byte(nil), old...), key...)); err != nil {
+		return err
+	}
+	return keys.Delete([]byte(key))
+}
+
+// expiredIn returns a function reporting whether a record of bucket expired, or nil when no record of
+// bucket expires, so reads of buckets without ttls pay nothing
+func (bl *BoltLocknut) expiredIn(tx *bbolt.Tx, bucket string) func(key []byte) bool {
+	root := tx.Bucket(expiryBucket)
+	if root == nil {
+		return nil
+	}
+	bkt := root.Bucket([]byte(bucket))
+	if bkt == nil {
+		return nil
+	}
+	keys := bkt.Bucket(expiryKeys)
+	now := seqKey(uint64(bl.now().UnixNano()))
+	return func(key []byte) bool {
+		expires := keys.Get(key)
+		return expires != nil && bytes.Compare(expires, now) <= 0
+	}
+}
+
+// Expires returns when the record stored under key expires, the zero time when it does not. A key
+// without a record, or whose record expired, fails with ErrRecordNotFound.
+func (bl *BoltLocknut) Expires(bucket, key string) (expires time.Time, err error) {
+	if err = bl.openDB(); err != nil {
+		return expires, err
+	}
+	defer bl.closeDB()
+
+	err = bl.db.view(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket([]byte(bucket))
+		if bkt == nil {
+			return bucketNotFound("expires", bucket)
+		}
+		expired := bl.expiredIn(tx, bucket)
+		if bkt.Get([]byte(key)) == nil || (expired != nil && expired([]byte(key))) {
+			return fmt.Errorf("%w: %s/%s", ErrRecordNotFound, bucket, key)
+		}
+		if root := tx.Bucket(expiryBucket); root != nil {
+			if b := root.Bucket([]byte(bucket)); b != nil {
+				if v := b.Bucket(expiryKeys).Get([]byte(key)); v != nil {
+					expires = time.Unix(0, int64(binary.BigEndian.Uint64(v)))
+				}
+			}
+		}
+		return nil
+	})
+	return expires, err
+}
+
+// SweepExpired deletes up to limit expired records, or all of them when limit is 0 or less, in a single
+// transaction, and returns how many it deleted. Only the expiry index is scanned, from the record that
+// expired first. The deletes go to the changelog and the audit log like any other, hooks are not called.
+func (bl *BoltLocknut) SweepExpired(limit int) (int, error) {
+	if err := bl.openDB(); err != nil {
+		return 0, err
+	}
+	defer bl.closeDB()
+
+	swept := 0
+	err := bl.write(func(w *writeTx) error {
+		root := w.tx.Bucket(expiryBucket)
+		if root == nil {
+			return nil
+		}
+		var buckets []string
+		err := root.ForEach(func(name, _ []byte) error {
+			buckets = append(buckets, string(name))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		now := seqKey(uint64(bl.now().UnixNano()))
+		for _, bucket := range buckets {
+			if w.tx.Bucket([]byte(bucket)) == nil {
+				// the bucket was deleted along with its records
+				if err := root.DeleteBucket([]byte(bucket)); err != nil {
+					return err
+				}
+				continue
+			}
+			due := root.Bucket([]byte(bucket)).Bucket(expiryDue)
+			c := due.Cursor()
+			for k, _ := c.First(); k != nil && bytes.Compare(k[:8], now) <= 0; k, _ = c.First() {
+				if limit > 0 && swept >= limit {
+					return nil
+				}
+				if err := w.delete(bucket, string(k[8:])); err != nil {
+					return err
+				}
+				swept++
+			}
+		}
+		return nil
+	})
+	return swept, err
+}
+
+// RunSweeper deletes the expired records every interval, until ctx is done. Sweeps that find the db
+// under maintenance are skipped.
+func (bl *BoltLocknut) RunSweeper(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		for {
+			n, err := bl.SweepExpired(sweepBatch)
+			if err != nil && !errors.Is(err, ErrMaintenance) {
+				return err
+			}
+			if err != nil || n < sweepBatch || ctx.Err() != nil {
+				break
+			}
+		}
+	}
+}
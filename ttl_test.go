@@ -0,0 +1,97 @@
+package locknut
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTTL(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("ttl_test.db")
+
+	clock := &fakeClock{t: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	bl, err := NewBoltLocknut("ttl_test.db", ".", []byte("secret"), false, []string{"sessions"},
+		WithClock(clock), WithChangelog())
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	for i := 0; i < 5; i++ {
+		if err = bl.SaveWithTTL("sessions", fmt.Sprintf("s%d", i), "session", time.Duration(i+1)*time.Minute); err != nil {
+			t.Fatalf("SaveWithTTL: %s", err)
+		}
+	}
+	if err = bl.Save("sessions", "forever", "session"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	// saving again without a ttl keeps the record forever
+	if err = bl.Save("sessions", "s4", "session"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	// saving again with a ttl moves its expiry
+	if err = bl.SaveBytesWithTTL("sessions", "s0", []byte(`"session"`), time.Hour); err != nil {
+		t.Fatalf("SaveBytesWithTTL: %s", err)
+	}
+
+	if expires, err := bl.Expires("sessions", "s1"); err != nil || !expires.Equal(clock.t.Add(2*time.Minute)) {
+		t.Errorf("unexpected expiry %s %v", expires, err)
+	}
+	if expires, err := bl.Expires("sessions", "s4"); err != nil || !expires.IsZero() {
+		t.Errorf("expected no expiry, got %s %v", expires, err)
+	}
+
+	clock.t = clock.t.Add(3 * time.Minute)
+	if v, err := bl.Get("sessions", "s1"); err != nil || v != nil {
+		t.Errorf("expected s1 to have expired, got %s %v", v, err)
+	}
+	if v, err := bl.GetOne("sessions", "s"); err != nil || string(v) != `"session"` {
+		t.Errorf("expected s0, got %s %v", v, err)
+	}
+	if keys, err := bl.GetKeyList("sessions", ""); err != nil || len(keys) != 4 {
+		t.Errorf("expected 4 live keys, got %v %v", keys, err)
+	}
+	if records, err := bl.GetByPrefix("sessions", "s"); err != nil || len(records) != 3 {
+		t.Errorf("expected 3 live records, got %d %v", len(records), err)
+	}
+	if _, err = bl.Expires("sessions", "s2"); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expected ErrRecordNotFound, got %v", err)
+	}
+
+	if n, err := bl.SweepExpired(1); err != nil || n != 1 {
+		t.Errorf("expected 1 record swept, got %d %v", n, err)
+	}
+	if n, err := bl.SweepExpired(0); err != nil || n != 1 {
+		t.Errorf("expected the other record swept, got %d %v", n, err)
+	}
+	if n, err := bl.Count("sessions"); err != nil || n != 4 {
+		t.Errorf("expected 4 records left, got %d %v", n, err)
+	}
+	changes, err := bl.Changes(0, 0)
+	if err != nil || len(changes) != 10 || changes[8].Op != OpDelete || changes[8].Key != "s1" {
+		t.Errorf("expected the sweeps in the changelog, got %+v %v", changes, err)
+	}
+
+	// deleted records forget their expiry
+	if err = bl.Delete("sessions", "s3"); err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+	if err = bl.Save("sessions", "s3", "session"); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	clock.t = clock.t.Add(24 * time.Hour)
+	if v, err := bl.Get("sessions", "s3"); err != nil || v == nil {
+		t.Errorf("expected s3 to be kept, got %s %v", v, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err = bl.RunSweeper(ctx, 10*time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected RunSweeper to stop with ctx, got %v", err)
+	}
+	if keys, err := bl.GetKeyList("sessions", ""); err != nil || fmt.Sprint(keys) != "[forever s3 s4]" {
+		t.Errorf("unexpected keys %v %v", keys, err)
+	}
+}
@@ -0,0 +1,167 @@
+package locknut
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+var (
+	// ErrValidation is returned when a record fails a validator of its registered type
+	ErrValidation = errors.New("record failed validation")
+	// ErrTypeNotRegistered is returned by SaveTyped and GetTyped for a type no RegisterType declared
+	ErrTypeNotRegistered = errors.New("type is not registered")
+)
+
+// Codec turns the records of a registered type into the bytes that are encrypted and back
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSONCodec is the Codec of Save, and of registered types unless UseCodec says otherwise
+type JSONCodec struct{}
+
+// Marshal encodes v as json
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal decodes json into v
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// typeSpec is what RegisterType declared for a bucket
+type typeSpec struct {
+	typ        reflect.Type
+	codec      Codec
+	validators []func(v interface{}) error
+	indexes    []IndexField
+}
+
+// TypeOption declares how a registered type is stored, passed to RegisterType
+type TypeOption func(*typeSpec)
+
+// UseCodec stores the records of the type with c instead of json. The search index reads json, so
+// indexed types need a codec writing json.
+func UseCodec(c Codec) TypeOption {
+	return func(s *typeSpec) {
+		s.codec = c
+	}
+}
+
+// Index adds field of the type to the search index, as an IndexField of its bucket would. It takes
+// effect with WithSearchIndex.
+func Index(field string, analyzer Analyzer) TypeOption {
+	return func(s *typeSpec) {
+		s.indexes = append(s.indexes, IndexField{Field: field, Analyzer: analyzer})
+	}
+}
+
+// Validate checks every record of the type before it is saved, a failure is wrapped in ErrValidation
+func Validate[T any](fn func(T) error) TypeOption {
+	return func(s *typeSpec) {
+		s.validators = append(s.validators, func(v interface{}) error {
+			t, ok := v.(T)
+			if !ok {
+				return fmt.Errorf("validator for %v given %T", reflect.TypeOf((*T)(nil)).Elem(), v)
+			}
+			return fn(t)
+		})
+	}
+}
+
+// RegisterType declares that bucket holds records of type T, stored with the codec and checked by the
+// validators of opts, and makes sure the bucket exists when the db is opened. Save checks the records
+// it is given for the bucket, SaveTyped and GetTyped find the bucket from the type.
+func RegisterType[T any](bucket string, opts ...TypeOption) Option {
+	return func(bl *BoltLocknut) {
+		spec := &typeSpec{typ: reflect.TypeOf((*T)(nil)).Elem(), codec: JSONCodec{}}
+		for _, opt := range opts {
+			opt(spec)
+		}
+		for _, f := range spec.indexes {
+			f.Bucket = bucket
+			bl.searchFields = append(bl.searchFields, f)
+		}
+
+		if bl.types == nil {
+			bl.types = make(map[string]*typeSpec)
+			bl.typeBuckets = make(map[reflect.Type]string)
+		}
+		bl.types[bucket] = spec
+		bl.typeBuckets[spec.typ] = bucket
+		bl.buckets = append(bl.buckets, bucket)
+	}
+}
+
+// encode validates v against the type registered for bucket and encodes it with its codec. Buckets
+// without a registered type take any value and store it as json.
+func (bl *BoltLocknut) encode(bucket string, v interface{}) ([]byte, error) {
+	spec := bl.types[bucket]
+	if spec == nil {
+		return json.Marshal(v)
+	}
+
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Pointer && rv.Type().Elem() == spec.typ {
+		v = rv.Elem().Interface()
+	}
+	if reflect.TypeOf(v) != spec.typ {
+		return nil, fmt.Errorf("%w: bucket %s holds %v, given %T", ErrValidation, bucket, spec.typ, v)
+	}
+	for _, validate := range spec.validators {
+		if err := validate(v); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrValidation, bucket, err)
+		}
+	}
+	return spec.codec.Marshal(v)
+}
+
+// storesJSON reports whether the records of bucket are encoded as json
+func (bl *BoltLocknut) storesJSON(bucket string) bool {
+	spec := bl.types[bucket]
+	if spec == nil {
+		return true
+	}
+	_, ok := spec.codec.(JSONCodec)
+	return ok
+}
+
+// bucketOf returns the bucket and spec registered for T
+func bucketOf[T any](bl *BoltLocknut) (string, *typeSpec, error) {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	bucket, ok := bl.typeBuckets[typ]
+	if !ok {
+		return "", nil, fmt.Errorf("%w: %v", ErrTypeNotRegistered, typ)
+	}
+	return bucket, bl.types[bucket], nil
+}
+
+// SaveTyped saves v under key in the bucket registered for T
+func SaveTyped[T any](bl *BoltLocknut, key string, v T) error {
+	bucket, _, err := bucketOf[T](bl)
+	if err != nil {
+		return err
+	}
+	return bl.Save(bucket, key, v)
+}
+
+// GetTyped returns the record stored under exactly key in the bucket registered for T, and whether
+// there is one
+func GetTyped[T any](bl *BoltLocknut, key string) (T, bool, error) {
+	var v T
+	bucket, spec, err := bucketOf[T](bl)
+	if err != nil {
+		return v, false, err
+	}
+	data, err := bl.Get(bucket, key)
+	if err != nil || data == nil {
+		return v, false, err
+	}
+	if err = spec.codec.Unmarshal(data, &v); err != nil {
+		return v, false, err
+	}
+	return v, true, nil
+}
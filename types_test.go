@@ -0,0 +1,60 @@
+package locknut
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestRegisterType(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("types_test.db")
+	defer os.Remove("types_test.search.db")
+
+	bl, err := NewBoltLocknut("types_test.db", ".", []byte("secret"), false, nil,
+		RegisterType[Article]("articles",
+			Index("title", Analyzer{}),
+			Validate(func(a Article) error {
+				if a.ID == "" {
+					return errors.New("id is required")
+				}
+				return nil
+			}),
+		),
+		WithSearchIndex("types_test.search.db"),
+	)
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if names, _ := bl.Buckets(); len(names) != 1 || names[0] != "articles" {
+		t.Errorf("expected the registered bucket to exist, got %v", names)
+	}
+
+	if err = SaveTyped(bl, "ID-0001", Article{ID: "ID-0001", Title: "registered types"}); err != nil {
+		t.Fatalf("SaveTyped: %s", err)
+	}
+	a, ok, err := GetTyped[Article](bl, "ID-0001")
+	if err != nil || !ok || a.Title != "registered types" {
+		t.Errorf("unexpected GetTyped %+v %v %v", a, ok, err)
+	}
+	if _, ok, err = GetTyped[Article](bl, "missing"); err != nil || ok {
+		t.Errorf("expected no record, got %v %v", ok, err)
+	}
+
+	if err = bl.Save("articles", "ID-0002", Article{Title: "no id"}); !errors.Is(err, ErrValidation) {
+		t.Errorf("expected ErrValidation from the validator, got %v", err)
+	}
+	if err = bl.Save("articles", "ID-0002", "not an article"); !errors.Is(err, ErrValidation) {
+		t.Errorf("expected ErrValidation for another type, got %v", err)
+	}
+	if err = bl.Save("articles", "ID-0002", &Article{ID: "ID-0002"}); err != nil {
+		t.Errorf("expected a pointer to the type to be accepted, got %v", err)
+	}
+	if err = SaveTyped(bl, "k", struct{}{}); !errors.Is(err, ErrTypeNotRegistered) {
+		t.Errorf("expected ErrTypeNotRegistered, got %v", err)
+	}
+
+	if keys, err := bl.Search("articles", "title", "registered"); err != nil || len(keys) != 1 || keys[0] != "ID-0001" {
+		t.Errorf("expected the declared index to be searchable, got %v %v", keys, err)
+	}
+}
@@ -0,0 +1,99 @@
+// Package vault implements locknut.KeyProvider with the transit secrets engine of HashiCorp Vault, so
+// the master key of a db never leaves Vault and only the wrapped db key is kept next to the db.
+package vault
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/vault/api"
+	"github.com/taybart/locknut"
+)
+
+// keyContext binds wrapped keys to their use with transit keys created with derived=true
+var keyContext = base64.StdEncoding.EncodeToString([]byte("locknut db key"))
+
+// Provider wraps keys with a transit key
+type Provider struct {
+	client  *api.Client
+	mount   string
+	keyName string
+	derived bool
+	keyFile string
+}
+
+var _ locknut.KeyProvider = (*Provider)(nil)
+
+// Option configures a Provider, passed to New
+type Option func(*Provider)
+
+// WithMount sets the path the transit engine is mounted at, "transit" by default
+func WithMount(mount string) Option {
+	return func(p *Provider) {
+		p.mount = mount
+	}
+}
+
+// WithDerivedKey passes a context with every request, for transit keys created with derived=true
+func WithDerivedKey() Option {
+	return func(p *Provider) {
+		p.derived = true
+	}
+}
+
+// New returns a Provider wrapping keys with the transit key keyName and keeping the wrapped db key in
+// keyFile. client carries the address and token of Vault.
+func New(client *api.Client, keyName, keyFile string, opts ...Option) *Provider {
+	p := &Provider{client: client, mount: "transit", keyName: keyName, keyFile: keyFile}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// GetKey returns the db key, created on first use, see locknut.EnvelopeKey
+func (p *Provider) GetKey(ctx context.Context) ([]byte, error) {
+	return locknut.EnvelopeKey(ctx, p, p.keyFile)
+}
+
+// transit calls the encrypt or decrypt endpoint of the key and returns the field of the response
+func (p *Provider) transit(ctx context.Context, op string, data map[string]interface{}, field string) (string, error) {
+	if p.derived {
+		data["context"] = keyContext
+	}
+	secret, err := p.client.Logical().WriteWithContext(ctx, fmt.Sprintf("%s/%s/%s", p.mount, op, p.keyName), data)
+	if err != nil {
+		return "", err
+	}
+	if secret == nil {
+		return "", errors.New("vault transit: empty response")
+	}
+	v, ok := secret.Data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("vault transit: no %s in response", field)
+	}
+	return v, nil
+}
+
+// Wrap encrypts key with the transit key, the result being the vault:v<n>: ciphertext
+func (p *Provider) Wrap(ctx context.Context, key []byte) ([]byte, error) {
+	ct, err := p.transit(ctx, "encrypt", map[string]interface{}{
+		"plaintext": base64.StdEncoding.EncodeToString(key),
+	}, "ciphertext")
+	if err != nil {
+		return nil, err
+	}
+	return []byte(ct), nil
+}
+
+// Unwrap decrypts a key wrapped by Wrap, with any version of the transit key still allowed to decrypt
+func (p *Provider) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	pt, err := p.transit(ctx, "decrypt", map[string]interface{}{
+		"ciphertext": string(wrapped),
+	}, "plaintext")
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(pt)
+}
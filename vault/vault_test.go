@@ -0,0 +1,71 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/hashicorp/vault/api"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeTransit answers the transit encrypt and decrypt endpoints of the key "locknut", "encrypting" by
+// prefixing the plaintext
+func fakeTransit(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		var req map[string]string
+		json.NewDecoder(r.Body).Decode(&req)
+		if req["context"] == "" {
+			http.Error(w, `{"errors":["missing context for derived key"]}`, http.StatusBadRequest)
+			return
+		}
+
+		data := map[string]string{}
+		switch r.URL.Path {
+		case "/v1/keys/encrypt/locknut":
+			data["ciphertext"] = "vault:v1:" + req["plaintext"]
+		case "/v1/keys/decrypt/locknut":
+			data["plaintext"] = strings.TrimPrefix(req["ciphertext"], "vault:v1:")
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+}
+
+func TestProvider(t *testing.T) {
+	srv := fakeTransit(t)
+	defer srv.Close()
+
+	config := api.DefaultConfig()
+	config.Address = srv.URL
+	client, err := api.NewClient(config)
+	if err != nil {
+		t.Fatalf("NewClient: %s", err)
+	}
+	client.SetToken("token")
+
+	keyFile := filepath.Join(t.TempDir(), "db.key")
+	p := New(client, "locknut", keyFile, WithMount("keys"), WithDerivedKey())
+	key, err := p.GetKey(context.Background())
+	if err != nil || len(key) != 32 {
+		t.Fatalf("unexpected GetKey %x %v", key, err)
+	}
+	again, err := New(client, "locknut", keyFile, WithMount("keys"), WithDerivedKey()).GetKey(context.Background())
+	if err != nil || !bytes.Equal(again, key) {
+		t.Errorf("expected the same key from the key file, got %x %v", again, err)
+	}
+
+	client.SetToken("revoked")
+	if _, err = p.Unwrap(context.Background(), []byte("vault:v1:AAAA")); err == nil {
+		t.Errorf("expected an error without access to the key")
+	}
+}
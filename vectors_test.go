@@ -0,0 +1,102 @@
+package locknut
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"os"
+	"testing"
+)
+
+var updateVectors = flag.Bool("update-vectors", false, "regenerate the test vectors shared with the JavaScript SDK")
+
+// vectorsPath holds the test vectors the JavaScript SDK in sdk/js is tested against
+const vectorsPath = "sdk/js/testdata/vectors.json"
+
+// vector is a record as stored by Save or SaveBytes with a given secret
+type vector struct {
+	Name      string `json:"name"`
+	Secret    string `json:"secret"`    // hex
+	Key       string `json:"key"`       // hex, the AES key derived from secret
+	Plaintext string `json:"plaintext"` // hex
+	Stored    string `json:"stored"`    // base64, the value kept in the db
+	Valid     bool   `json:"valid"`     // false if stored was modified and must fail to decrypt
+}
+
+func makeVectors(t *testing.T) []vector {
+	cases := []struct {
+		name   string
+		secret []byte
+		plain  []byte
+	}{
+		{"short secret is hashed", []byte("secret"), []byte(`{"name":"taylor"}`)},
+		{"32 byte secret is used as is", []byte("0123456789abcdef0123456789abcdef"), []byte(`"value"`)},
+		{"empty value", []byte("secret"), []byte{}},
+		{"binary value", []byte("another secret"), []byte{0, 1, 2, 0xfe, 0xff}},
+	}
+
+	var vectors []vector
+	for _, c := range cases {
+		key := deriveSecret(c.secret)
+		stored, err := Encrypt(c.plain, key)
+		if err != nil {
+			t.Fatalf("Encrypt: %s", err)
+		}
+		vectors = append(vectors, vector{
+			Name:      c.name,
+			Secret:    hex.EncodeToString(c.secret),
+			Key:       hex.EncodeToString(key),
+			Plaintext: hex.EncodeToString(c.plain),
+			Stored:    base64.StdEncoding.EncodeToString(stored),
+			Valid:     true,
+		})
+	}
+
+	tampered := vectors[0]
+	stored, _ := base64.StdEncoding.DecodeString(tampered.Stored)
+	stored[len(stored)-1] ^= 1
+	tampered.Name, tampered.Stored, tampered.Valid = "modified record", base64.StdEncoding.EncodeToString(stored), false
+	return append(vectors, tampered)
+}
+
+func TestVectors(t *testing.T) {
+	if *updateVectors {
+		out, err := json.MarshalIndent(makeVectors(t), "", "  ")
+		if err != nil {
+			t.Fatalf("Marshal: %s", err)
+		}
+		if err = os.WriteFile(vectorsPath, append(out, '\n'), 0644); err != nil {
+			t.Fatalf("WriteFile: %s", err)
+		}
+	}
+
+	raw, err := os.ReadFile(vectorsPath)
+	if err != nil {
+		t.Fatalf("ReadFile: %s", err)
+	}
+	var vectors []vector
+	if err = json.Unmarshal(raw, &vectors); err != nil {
+		t.Fatalf("Unmarshal: %s", err)
+	}
+
+	for _, v := range vectors {
+		secret, _ := hex.DecodeString(v.Secret)
+		key := deriveSecret(secret)
+		if hex.EncodeToString(key) != v.Key {
+			t.Errorf("%s: key derivation changed", v.Name)
+		}
+		stored, _ := base64.StdEncoding.DecodeString(v.Stored)
+		plain, err := Decrypt(stored, key)
+		want, _ := hex.DecodeString(v.Plaintext)
+		switch {
+		case v.Valid && err != nil:
+			t.Errorf("%s: Decrypt: %s", v.Name, err)
+		case v.Valid && !bytes.Equal(plain, want):
+			t.Errorf("%s: unexpected plaintext %x", v.Name, plain)
+		case !v.Valid && err == nil:
+			t.Errorf("%s: modified record decrypted", v.Name)
+		}
+	}
+}
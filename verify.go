@@ -0,0 +1,60 @@
+package locknut
+
+import (
+	"errors"
+	"go.etcd.io/bbolt"
+)
+
+// Report is the result of Verify
+type Report struct {
+	// Records is the number of records checked
+	Records int
+	// Pages holds the inconsistencies bbolt found in the file, such as unreachable or doubly used pages
+	Pages []error
+	// Failed holds the records that could not be decrypted, with their bucket and key
+	Failed []*RecordError
+}
+
+// OK reports whether Verify found no problem
+func (r Report) OK() bool {
+	return len(r.Pages) == 0 && len(r.Failed) == 0
+}
+
+// Verify checks the consistency of the db file and decrypts every record to find the ones that fail
+// authentication or are corrupt. Problems are collected in the report rather than returned, the error
+// is only set when the check could not run. It reads the whole file in one transaction, so it is
+// meant for scheduled jobs rather than hot paths.
+func (bl *BoltLocknut) Verify() (Report, error) {
+	var report Report
+	var err error
+	if err = bl.openDB(); err != nil {
+		return report, err
+	}
+	defer bl.closeDB()
+
+	err = bl.db.view(func(tx *bbolt.Tx) error {
+		for err := range tx.Check() {
+			report.Pages = append(report.Pages, err)
+		}
+
+		return tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+			if isInternalBucket(name) {
+				return nil
+			}
+			return b.ForEach(func(k, v []byte) error {
+				if v == nil {
+					return nil
+				}
+				report.Records++
+				_, err := bl.decrypt(string(name), string(k), v)
+				var re *RecordError
+				if errors.As(err, &re) {
+					report.Failed = append(report.Failed, re)
+					return nil
+				}
+				return err
+			})
+		})
+	})
+	return report, err
+}
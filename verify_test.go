@@ -0,0 +1,62 @@
+package locknut
+
+import (
+	"errors"
+	"go.etcd.io/bbolt"
+	"os"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("verify_test.db")
+
+	bl, err := NewBoltLocknut("verify_test.db", ".", []byte("secret"), false, []string{"items"})
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	for _, k := range []string{"a", "b", "c"} {
+		if err = bl.Save("items", k, k); err != nil {
+			t.Fatalf("Save: %s", err)
+		}
+	}
+
+	report, err := bl.Verify()
+	if err != nil {
+		t.Fatalf("Verify: %s", err)
+	}
+	if !report.OK() || report.Records != 3 {
+		t.Errorf("unexpected report for a healthy db %+v", report)
+	}
+
+	if err = bl.openDB(); err != nil {
+		t.Fatalf("openDB: %s", err)
+	}
+	err = bl.db.update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("items"))
+		v := append([]byte(nil), b.Get([]byte("b"))...)
+		v[len(v)-1] ^= 1
+		if err := b.Put([]byte("b"), v); err != nil {
+			return err
+		}
+		return b.Put([]byte("c"), []byte("xx"))
+	})
+	bl.closeDB()
+	if err != nil {
+		t.Fatalf("update: %s", err)
+	}
+
+	report, err = bl.Verify()
+	if err != nil {
+		t.Fatalf("Verify: %s", err)
+	}
+	if report.OK() || len(report.Pages) != 0 || len(report.Failed) != 2 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if f := report.Failed[0]; f.Bucket != "items" || f.Key != "b" || !errors.Is(f, ErrDecrypt) {
+		t.Errorf("unexpected failure %v", f)
+	}
+	if f := report.Failed[1]; f.Key != "c" || !errors.Is(f, ErrCorrupt) {
+		t.Errorf("unexpected failure %v", f)
+	}
+}
@@ -0,0 +1,215 @@
+package locknut
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"go.etcd.io/bbolt"
+	"golang.org/x/crypto/pbkdf2"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// ErrNoPassword is returned by ExportZip when given an empty password
+var ErrNoPassword = errors.New("zip export needs a password")
+
+// The WinZip AES encryption of zip entries, AE-2 with 256 bit keys, which 7-Zip, WinZip, macOS
+// Archive Utility and most other archive tools open
+const (
+	zipMethodAES   = 99
+	zipAESExtraID  = 0x9901
+	zipAESSaltSize = 16
+	zipAESMACSize  = 10
+	zipAESRounds   = 1000
+)
+
+// ZipOptions are the options of ExportZip
+type ZipOptions struct {
+	// Buckets are the buckets exported, every bucket when empty
+	Buckets []string
+	// Redact lists, per bucket, the json fields replaced by "[redacted]" in the records exported.
+	// Records of those buckets that are not json objects are left out.
+	Redact map[string][]string
+}
+
+// ExportZip writes the decrypted records of the buckets of opts to w as a zip archive encrypted with
+// password, for handing data to people who only have generic archive tools. Each record is the file
+// bucket/key holding its value, the names path escaped so every key is a single file. The entries are
+// deflated and encrypted with WinZip AES-256, keyed by PBKDF2 of the password: the names of buckets
+// and keys are visible to anyone holding the archive, only the values are protected.
+func (bl *BoltLocknut) ExportZip(w io.Writer, password string, opts ZipOptions) error {
+	if password == "" {
+		return ErrNoPassword
+	}
+	var err error
+	if err = bl.openDB(); err != nil {
+		return err
+	}
+	defer bl.closeDB()
+
+	buckets := opts.Buckets
+	if len(buckets) == 0 {
+		if buckets, err = bl.Buckets(); err != nil {
+			return err
+		}
+	}
+
+	zw := zip.NewWriter(w)
+	for _, bucket := range buckets {
+		fields := opts.Redact[bucket]
+		err = bl.db.view(func(tx *bbolt.Tx) error {
+			bkt := tx.Bucket([]byte(bucket))
+			if bkt == nil {
+				return bucketNotFound("export", bucket)
+			}
+			return bkt.ForEach(func(k, v []byte) error {
+				if v == nil {
+					return nil
+				}
+				dec, err := bl.decrypt(bucket, string(k), v)
+				if err != nil {
+					return err
+				}
+				if len(fields) > 0 {
+					if dec = redactFields(dec, fields); dec == nil {
+						return nil
+					}
+				}
+				return bl.writeZipEntry(zw, path.Join(zipName(bucket), zipName(string(k))), dec, password)
+			})
+		})
+		if err == nil {
+			err = bl.auditRead(context.Background(), "export", bucket, "")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// zipName escapes s to a single path element
+func zipName(s string) string {
+	name := url.PathEscape(s)
+	if strings.Trim(name, ".") == "" {
+		name = strings.ReplaceAll(name, ".", "%2E")
+	}
+	return name
+}
+
+// redactFields returns the json object v with fields replaced by "[redacted]", or nil when v is not
+// a json object
+func redactFields(v []byte, fields []string) []byte {
+	var doc map[string]json.RawMessage
+	if json.Unmarshal(v, &doc) != nil || doc == nil {
+		return nil
+	}
+	for _, f := range fields {
+		if _, ok := doc[f]; ok {
+			doc[f] = json.RawMessage(`"[redacted]"`)
+		}
+	}
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return nil
+	}
+	return out
+}
+
+// writeZipEntry adds the file name holding data to zw, deflated and encrypted with password
+func (bl *BoltLocknut) writeZipEntry(zw *zip.Writer, name string, data []byte, password string) error {
+	var deflated bytes.Buffer
+	fw, err := flate.NewWriter(&deflated, flate.DefaultCompression)
+	if err != nil {
+		return err
+	}
+	if _, err = fw.Write(data); err != nil {
+		return err
+	}
+	if err = fw.Close(); err != nil {
+		return err
+	}
+
+	salt := make([]byte, zipAESSaltSize)
+	if _, err = io.ReadFull(bl.randReader(), salt); err != nil {
+		return err
+	}
+	payload, err := zipAESEncrypt(deflated.Bytes(), []byte(password), salt)
+	if err != nil {
+		return err
+	}
+
+	// AE-2 entries carry no crc, the mac covers the data
+	extra := make([]byte, 11)
+	binary.LittleEndian.PutUint16(extra[0:], zipAESExtraID)
+	binary.LittleEndian.PutUint16(extra[2:], 7)
+	binary.LittleEndian.PutUint16(extra[4:], 2) // AE-2
+	copy(extra[6:], "AE")
+	extra[8] = 3 // 256 bit keys
+	binary.LittleEndian.PutUint16(extra[9:], zip.Deflate)
+
+	ew, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               name,
+		Method:             zipMethodAES,
+		Flags:              0x1, // encrypted
+		Modified:           bl.now(),
+		Extra:              extra,
+		CompressedSize64:   uint64(len(payload)),
+		UncompressedSize64: uint64(len(data)),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = ew.Write(payload)
+	return err
+}
+
+// zipAESKeys derives the encryption key, the mac key and the password verifier of an entry
+func zipAESKeys(password, salt []byte) (key, macKey, verifier []byte) {
+	k := pbkdf2.Key(password, salt, zipAESRounds, 32+32+2, sha1.New)
+	return k[:32], k[32:64], k[64:]
+}
+
+// zipAESEncrypt returns the stored form of an entry: the salt, the password verifier, data encrypted
+// with AES-CTR and the truncated HMAC-SHA1 of the ciphertext
+func zipAESEncrypt(data, password, salt []byte) ([]byte, error) {
+	key, macKey, verifier := zipAESKeys(password, salt)
+	ct, err := zipAESCTR(data, key)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha1.New, macKey)
+	mac.Write(ct)
+
+	out := make([]byte, 0, len(salt)+len(verifier)+len(ct)+zipAESMACSize)
+	out = append(append(append(out, salt...), verifier...), ct...)
+	return append(out, mac.Sum(nil)[:zipAESMACSize]...), nil
+}
+
+// zipAESCTR is AES in counter mode the way WinZip does it, the counter is little endian and starts at
+// one, so cipher.NewCTR does not fit. It encrypts and decrypts.
+func zipAESCTR(data, key []byte) ([]byte, error) {
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(data))
+	var counter, stream [aes.BlockSize]byte
+	for i := 0; i < len(data); i += aes.BlockSize {
+		binary.LittleEndian.PutUint64(counter[:], uint64(i/aes.BlockSize)+1)
+		c.Encrypt(stream[:], counter[:])
+		for j := i; j < len(data) && j < i+aes.BlockSize; j++ {
+			out[j] = data[j] ^ stream[j-i]
+		}
+	}
+	return out, nil
+}
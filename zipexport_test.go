@@ -0,0 +1,127 @@
+package locknut
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+// openZipAES decrypts an entry written by ExportZip the way an archive tool would, nil when the
+// password is wrong
+func openZipAES(t *testing.T, f *zip.File, password string) []byte {
+	t.Helper()
+	if f.Method != zipMethodAES || f.Flags&0x1 == 0 {
+		t.Fatalf("%s: expected an aes encrypted entry, got method %d flags %x", f.Name, f.Method, f.Flags)
+	}
+	if len(f.Extra) < 11 || binary.LittleEndian.Uint16(f.Extra) != zipAESExtraID || f.Extra[8] != 3 {
+		t.Fatalf("%s: missing aes extra field %x", f.Name, f.Extra)
+	}
+	r, err := f.OpenRaw()
+	if err != nil {
+		t.Fatalf("OpenRaw: %s", err)
+	}
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read: %s", err)
+	}
+
+	salt, verifier := raw[:zipAESSaltSize], raw[zipAESSaltSize:zipAESSaltSize+2]
+	ct, tag := raw[zipAESSaltSize+2:len(raw)-zipAESMACSize], raw[len(raw)-zipAESMACSize:]
+	key, macKey, want := zipAESKeys([]byte(password), salt)
+	if !bytes.Equal(verifier, want) {
+		return nil
+	}
+	mac := hmac.New(sha1.New, macKey)
+	mac.Write(ct)
+	if !hmac.Equal(tag, mac.Sum(nil)[:zipAESMACSize]) {
+		return nil
+	}
+	deflated, err := zipAESCTR(ct, key)
+	if err != nil {
+		t.Fatalf("zipAESCTR: %s", err)
+	}
+	data, err := io.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+	if err != nil {
+		t.Fatalf("inflate: %s", err)
+	}
+	if uint64(len(data)) != f.UncompressedSize64 {
+		t.Errorf("%s: expected %d bytes, got %d", f.Name, f.UncompressedSize64, len(data))
+	}
+	return data
+}
+
+func TestExportZip(t *testing.T) {
+	skipFIPS(t, "a short secret")
+	defer os.Remove("zipexport_test.db")
+
+	bl, err := NewBoltLocknut("zipexport_test.db", ".", []byte("secret"), false, []string{"users", "notes"})
+	if err != nil {
+		t.Fatalf("NewBoltLocknut: %s", err)
+	}
+	if err = bl.Save("users", "ada", map[string]string{"name": "Ada", "ssn": "123-45-6789"}); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+	if err = bl.SaveBytes("users", "plain", []byte("not json")); err != nil {
+		t.Fatalf("SaveBytes: %s", err)
+	}
+	long := bytes.Repeat([]byte("a note that compresses well "), 100)
+	if err = bl.SaveBytes("notes", "../up/and away", long); err != nil {
+		t.Fatalf("SaveBytes: %s", err)
+	}
+
+	if err = bl.ExportZip(io.Discard, "", ZipOptions{}); !errors.Is(err, ErrNoPassword) {
+		t.Errorf("expected ErrNoPassword, got %v", err)
+	}
+
+	var buf bytes.Buffer
+	opts := ZipOptions{Redact: map[string][]string{"users": {"ssn"}}}
+	if err = bl.ExportZip(&buf, "hunter2", opts); err != nil {
+		t.Fatalf("ExportZip: %s", err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("compresses")) || bytes.Contains(buf.Bytes(), []byte("Ada")) {
+		t.Error("expected the values to be encrypted")
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("zip.NewReader: %s", err)
+	}
+	got := make(map[string]string)
+	for _, f := range zr.File {
+		data := openZipAES(t, f, "hunter2")
+		if data == nil {
+			t.Fatalf("%s: expected the password to be accepted", f.Name)
+		}
+		got[f.Name] = string(data)
+		if openZipAES(t, f, "wrong") != nil {
+			t.Errorf("%s: expected the wrong password to be rejected", f.Name)
+		}
+	}
+	want := map[string]string{
+		"users/ada":                  `{"name":"Ada","ssn":"[redacted]"}`,
+		"notes/..%2Fup%2Fand%20away": string(long),
+	}
+	if len(got) != len(want) {
+		t.Errorf("expected %d entries, got %v", len(want), got)
+	}
+	for name, v := range want {
+		if got[name] != v {
+			t.Errorf("%s: expected %q, got %q", name, v, got[name])
+		}
+	}
+}
+
+func TestZipName(t *testing.T) {
+	for in, want := range map[string]string{"a": "a", "a/b": "a%2Fb", "..": "%2E%2E", ".": "%2E", ".x": ".x"} {
+		if got := zipName(in); got != want {
+			t.Errorf("zipName(%q): expected %q, got %q", in, want, got)
+		}
+	}
+}